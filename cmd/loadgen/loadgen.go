@@ -0,0 +1,506 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+	"github.com/AnshulDekate/pubsub/pkg/pubsubclient"
+)
+
+// rampPoll is the longest a publisher waits before rechecking the ramp
+const rampPoll = 10 * time.Millisecond
+
+// ramp scales the target rate over the run. Linear rises from zero to the
+// full rate over period; step rises in equal steps lasting period each, the
+// first at 1/steps of the rate.
+type ramp struct {
+	kind   string // "none", "linear" or "step"
+	period time.Duration
+	steps  int
+}
+
+// parseRamp parses none, linear:DURATION or step:NxDURATION
+func parseRamp(spec string) (ramp, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "", "none":
+		if arg == "" {
+			return ramp{kind: "none"}, nil
+		}
+	case "linear":
+		if period, err := time.ParseDuration(arg); err == nil && period > 0 {
+			return ramp{kind: kind, period: period}, nil
+		}
+	case "step":
+		count, each, _ := strings.Cut(arg, "x")
+		steps, err := strconv.Atoi(count)
+		period, perr := time.ParseDuration(each)
+		if err == nil && perr == nil && steps > 0 && period > 0 {
+			return ramp{kind: kind, period: period, steps: steps}, nil
+		}
+	}
+	return ramp{}, fmt.Errorf("ramp %q is not none, linear:DURATION or step:NxDURATION", spec)
+}
+
+// fraction returns the share of the full rate elapsed into the run
+func (r ramp) fraction(elapsed time.Duration) float64 {
+	switch r.kind {
+	case "linear":
+		return min(1, float64(elapsed)/float64(r.period))
+	case "step":
+		return min(1, float64(int(elapsed/r.period)+1)/float64(r.steps))
+	default:
+		return 1
+	}
+}
+
+func (r ramp) String() string {
+	switch r.kind {
+	case "linear":
+		return "linear:" + r.period.String()
+	case "step":
+		return fmt.Sprintf("step:%dx%s", r.steps, r.period)
+	default:
+		return "none"
+	}
+}
+
+// Latency buckets grow by 1% from a microsecond, so percentiles are exact
+// to about 1% without keeping every sample
+const (
+	bucketGrowth = 1.01
+	bucketCount  = 2100 // Up to about 20 minutes
+)
+
+// histogram counts latencies in logarithmic buckets
+type histogram struct {
+	mutex   sync.Mutex
+	buckets [bucketCount]int64
+	count   int64
+	sum     time.Duration
+	min     time.Duration
+	max     time.Duration
+}
+
+func (h *histogram) record(d time.Duration) {
+	d = max(d, 0)
+	bucket := 0
+	if us := float64(d) / float64(time.Microsecond); us > 1 {
+		bucket = min(bucketCount-1, int(math.Ceil(math.Log(us)/math.Log(bucketGrowth))))
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	h.max = max(h.max, d)
+	h.count++
+	h.sum += d
+	h.buckets[bucket]++
+}
+
+// LatencyReport summarizes end-to-end latencies in milliseconds
+type LatencyReport struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	P999 float64 `json:"p999"`
+	Max  float64 `json:"max"`
+}
+
+func (h *histogram) report() LatencyReport {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.count == 0 {
+		return LatencyReport{}
+	}
+	ms := func(d time.Duration) float64 {
+		return math.Round(float64(d)/float64(time.Millisecond)*1000) / 1000
+	}
+
+	// A percentile is its bucket's upper bound, within the observed range
+	percentile := func(p float64) float64 {
+		rank := int64(math.Ceil(p * float64(h.count)))
+		var seen int64
+		for bucket, n := range h.buckets {
+			if seen += n; seen >= rank {
+				upper := time.Duration(math.Pow(bucketGrowth, float64(bucket)) * float64(time.Microsecond))
+				return ms(min(max(upper, h.min), h.max))
+			}
+		}
+		return ms(h.max)
+	}
+	return LatencyReport{
+		Min:  ms(h.min),
+		Mean: ms(h.sum / time.Duration(h.count)),
+		P50:  percentile(0.50),
+		P90:  percentile(0.90),
+		P99:  percentile(0.99),
+		P999: percentile(0.999),
+		Max:  ms(h.max),
+	}
+}
+
+// ConnectionReport counts websocket connection problems. The error rate
+// is failed connects and disconnects per connection attempted.
+type ConnectionReport struct {
+	Attempted   int     `json:"attempted"`
+	Failed      int     `json:"failed"`
+	Disconnects int64   `json:"disconnects"`
+	ErrorRate   float64 `json:"error_rate"`
+}
+
+// ServerReport is what the broker's /stats counted on the load topics
+// during the run
+type ServerReport struct {
+	Published     int64 `json:"published"`
+	SlowConsumers int64 `json:"slow_consumer_unsubscribes"`
+	Matches       bool  `json:"matches"` // Whether the broker counted exactly the acked publishes
+}
+
+// Report is the outcome of a run
+type Report struct {
+	Target      string  `json:"target"`
+	Publishers  int     `json:"publishers"`
+	Subscribers int     `json:"subscribers"`
+	Topics      int     `json:"topics"`
+	PayloadSize int     `json:"payload_size"`
+	Ramp        string  `json:"ramp"`
+	TargetRate  float64 `json:"target_rate"`
+	Duration    float64 `json:"duration_seconds"` // Time spent publishing
+
+	Published     int64   `json:"published"` // Publishes the broker acked
+	PublishErrors int64   `json:"publish_errors"`
+	Rate          float64 `json:"rate"` // Acked publishes per second
+
+	// Every acked publish should reach each subscriber of its topic;
+	// dropped is those deliveries that didn't arrive before the drain
+	// timeout, and gaps the events subscribers were told they lost
+	Expected  int64 `json:"expected_deliveries"`
+	Delivered int64 `json:"delivered"`
+	Dropped   int64 `json:"dropped"`
+	Gaps      int64 `json:"gaps"`
+
+	Latency     LatencyReport    `json:"latency_ms"`
+	Connections ConnectionReport `json:"connections"`
+	Server      *ServerReport    `json:"server,omitempty"` // Left out when /stats can't be read
+}
+
+// WriteText writes the report for people
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Target:\t%s\n", r.Target)
+	fmt.Fprintf(tw, "Load:\t%d publishers, %d subscribers, %d topics, %d-byte payloads\n", r.Publishers, r.Subscribers, r.Topics, r.PayloadSize)
+	fmt.Fprintf(tw, "Rate:\t%.1f/s achieved of %g/s target (ramp %s) over %.1fs\n", r.Rate, r.TargetRate, r.Ramp, r.Duration)
+	fmt.Fprintf(tw, "Published:\t%d acked, %d failed\n", r.Published, r.PublishErrors)
+	fmt.Fprintf(tw, "Delivered:\t%d of %d expected, %d dropped, %d reported lost\n", r.Delivered, r.Expected, r.Dropped, r.Gaps)
+	l := r.Latency
+	fmt.Fprintf(tw, "Latency (ms):\tmin %.3f  mean %.3f  p50 %.3f  p90 %.3f  p99 %.3f  p99.9 %.3f  max %.3f\n", l.Min, l.Mean, l.P50, l.P90, l.P99, l.P999, l.Max)
+	c := r.Connections
+	fmt.Fprintf(tw, "Connections:\t%d attempted, %d failed, %d disconnects (error rate %.2f%%)\n", c.Attempted, c.Failed, c.Disconnects, 100*c.ErrorRate)
+	if s := r.Server; s != nil {
+		check := "matches"
+		if !s.Matches {
+			check = "MISMATCH"
+		}
+		fmt.Fprintf(tw, "Server /stats:\t%d published (%s), %d slow consumer unsubscribes\n", s.Published, check, s.SlowConsumers)
+	} else {
+		fmt.Fprintf(tw, "Server /stats:\tunavailable\n")
+	}
+	return tw.Flush()
+}
+
+// payload is what load publishes carry. SentAt is the publisher's clock,
+// so latencies are only meaningful with loadgen's own subscribers.
+type payload struct {
+	Run    string `json:"run"`
+	SentAt string `json:"sent_at"` // RFC 3339 with nanoseconds
+	Pad    string `json:"pad"`
+}
+
+// generator runs one load test
+type generator struct {
+	cfg    *config
+	stderr io.Writer
+	run    string
+	topics []string
+	pad    string
+
+	publishMutex sync.Mutex
+	published    []int64 // Acked publishes per topic
+	subscribed   []int64 // Subscribers per topic
+
+	publishErrors atomic.Int64
+	delivered     atomic.Int64
+	gaps          atomic.Int64
+	disconnects   atomic.Int64
+	latency       histogram
+
+	// Serializes writes to stderr
+	logMutex sync.Mutex
+}
+
+// generate connects the clients, publishes for the configured duration,
+// waits for deliveries to drain and reports
+func generate(ctx context.Context, cfg *config, stderr io.Writer) (*Report, error) {
+	g := &generator{
+		cfg:        cfg,
+		stderr:     stderr,
+		run:        uuid.NewString(),
+		published:  make([]int64, cfg.topics),
+		subscribed: make([]int64, cfg.topics),
+	}
+	for i := 0; i < cfg.topics; i++ {
+		g.topics = append(g.topics, fmt.Sprintf("%s%d", cfg.topicPrefix, i))
+	}
+	overhead, _ := json.Marshal(payload{Run: g.run, SentAt: time.Now().Format(time.RFC3339Nano)})
+	g.pad = strings.Repeat("x", max(0, cfg.payloadSize-len(overhead)))
+
+	if err := g.createTopics(ctx); err != nil {
+		return nil, err
+	}
+	before, statsErr := g.stats(ctx)
+	if statsErr != nil {
+		g.logf("can't read /stats, so the report won't be checked against it: %v", statsErr)
+	}
+
+	report := &Report{
+		Target:      cfg.server,
+		Publishers:  cfg.publishers,
+		Subscribers: cfg.subscribers,
+		Topics:      cfg.topics,
+		PayloadSize: cfg.payloadSize,
+		Ramp:        cfg.ramp.String(),
+		TargetRate:  cfg.rate,
+	}
+
+	// Subscriber i listens on topic i mod T, so the topics share them
+	// evenly
+	var clients []*pubsubclient.Client
+	defer func() {
+		for _, client := range clients {
+			client.Close()
+		}
+	}()
+	for i := 0; i < cfg.subscribers; i++ {
+		report.Connections.Attempted++
+		client, err := g.subscriber(ctx, i%cfg.topics)
+		if err != nil {
+			report.Connections.Failed++
+			g.logf("subscriber %d: %v", i, err)
+			continue
+		}
+		clients = append(clients, client)
+	}
+	var publishers []*pubsubclient.Client
+	for i := 0; i < cfg.publishers; i++ {
+		report.Connections.Attempted++
+		client, err := g.connect(ctx)
+		if err != nil {
+			report.Connections.Failed++
+			g.logf("publisher %d: %v", i, err)
+			continue
+		}
+		clients = append(clients, client)
+		publishers = append(publishers, client)
+	}
+	if len(publishers) == 0 {
+		return nil, errors.New("no publisher could connect")
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i, client := range publishers {
+		wg.Add(1)
+		go func(i int, client *pubsubclient.Client) {
+			defer wg.Done()
+			g.publish(ctx, client, i, len(publishers), start)
+		}(i, client)
+	}
+	wg.Wait()
+	report.Duration = time.Since(start).Seconds()
+
+	// Deliveries still in flight get until the drain timeout
+	for i, count := range g.published {
+		report.Published += count
+		report.Expected += count * g.subscribed[i]
+	}
+	drainUntil := time.Now().Add(cfg.drain)
+	for g.delivered.Load() < report.Expected && time.Now().Before(drainUntil) && ctx.Err() == nil {
+		time.Sleep(rampPoll)
+	}
+
+	report.PublishErrors = g.publishErrors.Load()
+	report.Rate = float64(report.Published) / report.Duration
+	report.Delivered = g.delivered.Load()
+	report.Dropped = max(0, report.Expected-report.Delivered)
+	report.Gaps = g.gaps.Load()
+	report.Latency = g.latency.report()
+	report.Connections.Disconnects = g.disconnects.Load()
+	if report.Connections.Attempted > 0 {
+		failures := float64(report.Connections.Failed) + float64(report.Connections.Disconnects)
+		report.Connections.ErrorRate = failures / float64(report.Connections.Attempted)
+	}
+
+	if statsErr == nil {
+		after, err := g.stats(ctx)
+		if err != nil {
+			g.logf("can't read /stats after the run: %v", err)
+		} else {
+			report.Server = &ServerReport{
+				Published:     after.Messages - before.Messages,
+				SlowConsumers: after.SlowConsumers - before.SlowConsumers,
+			}
+			report.Server.Matches = report.Server.Published == report.Published
+		}
+	}
+	return report, nil
+}
+
+// createTopics creates the load topics that don't exist yet
+func (g *generator) createTopics(ctx context.Context) error {
+	for _, topic := range g.topics {
+		err := g.cfg.do(ctx, "GET", "/topics/"+url.PathEscape(topic), nil, nil)
+		var status *statusError
+		if errors.As(err, &status) && status.status == http.StatusNotFound {
+			err = g.cfg.do(ctx, "POST", "/topics", pubsub.CreateTopicRequest{Name: topic}, nil)
+		}
+		if err != nil {
+			return fmt.Errorf("preparing topic %s: %w", topic, err)
+		}
+	}
+	return nil
+}
+
+// stats sums the load topics' counters in /stats
+func (g *generator) stats(ctx context.Context) (pubsub.TopicStats, error) {
+	var resp pubsub.StatsResponse
+	if err := g.cfg.do(ctx, "GET", "/stats", nil, &resp); err != nil {
+		return pubsub.TopicStats{}, err
+	}
+	var sum pubsub.TopicStats
+	for _, topic := range g.topics {
+		stats := resp.Topics[topic]
+		sum.Messages += stats.Messages
+		sum.SlowConsumers += stats.SlowConsumers
+	}
+	return sum, nil
+}
+
+// connect opens an SDK client, counting its disconnects and lost events
+func (g *generator) connect(ctx context.Context) (*pubsubclient.Client, error) {
+	opts := pubsubclient.Options{
+		Header:         g.cfg.header(),
+		RequestTimeout: g.cfg.timeout,
+		OnError: func(clientErr *pubsubclient.Error) {
+			switch clientErr.Kind {
+			case pubsubclient.KindDisconnected:
+				g.disconnects.Add(1)
+			case pubsubclient.KindGap:
+				g.gaps.Add(clientErr.Missing)
+			}
+			g.logf("%v", clientErr)
+		},
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, g.cfg.timeout)
+	defer cancel()
+	return pubsubclient.Connect(dialCtx, g.cfg.wsURL(), opts)
+}
+
+// subscriber connects a client subscribed to topic i, recording the
+// latency of every load event it receives
+func (g *generator) subscriber(ctx context.Context, i int) (*pubsubclient.Client, error) {
+	client, err := g.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	handler := func(event pubsub.EventResponse) {
+		received := time.Now()
+		fields, _ := event.Message.Payload.(map[string]interface{})
+		if fields["run"] != g.run {
+			return
+		}
+		sentAt, _ := fields["sent_at"].(string)
+		sent, err := time.Parse(time.RFC3339Nano, sentAt)
+		if err != nil {
+			return
+		}
+		g.latency.record(received.Sub(sent))
+		g.delivered.Add(1)
+	}
+	if err := client.Subscribe(ctx, g.topics[i], handler, pubsubclient.SubscribeOptions{}); err != nil {
+		client.Close()
+		return nil, err
+	}
+	g.publishMutex.Lock()
+	g.subscribed[i]++
+	g.publishMutex.Unlock()
+	return client, nil
+}
+
+// publish sends publisher i's share of the rate, following the ramp, until
+// the duration is up. Each publisher cycles through the topics, starting
+// at its own. A publisher that falls behind catches up by up to a second.
+func (g *generator) publish(ctx context.Context, client *pubsubclient.Client, i, publishers int, start time.Time) {
+	topic := i % len(g.topics)
+	credit, last := 0.0, start
+	for {
+		now := time.Now()
+		elapsed := now.Sub(start)
+		if elapsed >= g.cfg.duration || ctx.Err() != nil {
+			return
+		}
+		rate := g.cfg.rate * g.cfg.ramp.fraction(elapsed) / float64(publishers)
+		credit = min(credit+rate*now.Sub(last).Seconds(), rate+1)
+		last = now
+		if credit < 1 {
+			wait := rampPoll
+			if rate > 0 {
+				wait = min(wait, time.Duration((1-credit)/rate*float64(time.Second)))
+			}
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		credit--
+
+		message := payload{Run: g.run, SentAt: time.Now().Format(time.RFC3339Nano), Pad: g.pad}
+		if _, err := client.Publish(ctx, g.topics[topic], message); err != nil {
+			g.publishErrors.Add(1)
+			g.logf("publish to %s: %v", g.topics[topic], err)
+		} else {
+			g.publishMutex.Lock()
+			g.published[topic]++
+			g.publishMutex.Unlock()
+		}
+		topic = (topic + 1) % len(g.topics)
+	}
+}
+
+// logf reports a problem on stderr as it happens
+func (g *generator) logf(format string, args ...interface{}) {
+	g.logMutex.Lock()
+	defer g.logMutex.Unlock()
+	fmt.Fprintf(g.stderr, "loadgen: "+format+"\n", args...)
+}
@@ -0,0 +1,264 @@
+// Command loadgen drives a running broker for capacity testing. It
+// connects websocket publishers and subscribers with the Go client SDK,
+// publishes at a target rate for a while, and reports end-to-end latency,
+// delivered and dropped counts checked against the broker's /stats, and
+// connection errors.
+//
+// Usage:
+//
+//	loadgen [--publishers N] [--subscribers M] [--topics T] [--rate R]
+//	        [--payload-size BYTES] [--duration D] [--ramp PROFILE] [--json]
+//
+// The connection flags --server, --token, --user and --password default to
+// PUBSUB_SERVER, PUBSUB_TOKEN, PUBSUB_USER and PUBSUB_PASSWORD, as for
+// pubsubctl. Admin credentials are needed to create the load topics and to
+// read /stats.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultServer      = "http://localhost:8080"
+	DefaultTimeout     = 10 * time.Second
+	DefaultPublishers  = 4
+	DefaultSubscribers = 4
+	DefaultTopics      = 1
+	DefaultRate        = 100 // Publishes per second, across all publishers
+	DefaultPayloadSize = 256 // Bytes of JSON per payload
+	DefaultDuration    = 10 * time.Second
+	DefaultDrain       = 5 * time.Second
+	DefaultTopicPrefix = "loadgen-"
+)
+
+// Exit codes
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+const usage = `Usage: loadgen [flags]
+
+Load:
+  --publishers N              Publishing connections (default 4)
+  --subscribers N             Subscribing connections, spread over the topics (default 4)
+  --topics N                  Topics named <topic-prefix>0..N-1, created if missing (default 1)
+  --topic-prefix PREFIX       Load topic name prefix (default loadgen-)
+  --rate N                    Publishes per second across all publishers (default 100)
+  --payload-size BYTES        Size of each JSON payload (default 256)
+  --duration DURATION         How long to publish (default 10s)
+  --ramp PROFILE              none, linear:DURATION or step:NxDURATION (default none)
+  --drain DURATION            How long to wait for deliveries after publishing (default 5s)
+  --json                      Print the report as JSON instead of text
+
+Connection:
+  --server URL                Broker base URL (PUBSUB_SERVER, default ` + DefaultServer + `)
+  --token TOKEN               Admin bearer token (PUBSUB_TOKEN)
+  --user NAME                 Admin basic-auth user (PUBSUB_USER)
+  --password PASSWORD         Admin basic-auth password (PUBSUB_PASSWORD)
+  --timeout DURATION          Connect and request timeout (default 10s)
+`
+
+// errUsage marks errors caused by bad arguments
+var errUsage = errors.New("usage")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run generates load as args describe and returns the exit code
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	cfg, err := parseFlags(args, stderr)
+	if err == nil {
+		var report *Report
+		if report, err = generate(ctx, cfg, stderr); err == nil {
+			if cfg.json {
+				encoder := json.NewEncoder(stdout)
+				encoder.SetIndent("", "  ")
+				err = encoder.Encode(report)
+			} else {
+				err = report.WriteText(stdout)
+			}
+		}
+	}
+
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, flag.ErrHelp):
+		return exitUsage
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(stderr, "loadgen: %v\n\n%s", err, usage)
+		return exitUsage
+	default:
+		fmt.Fprintf(stderr, "loadgen: %v\n", err)
+		return exitError
+	}
+}
+
+// config holds the flags
+type config struct {
+	server   string
+	token    string
+	user     string
+	password string
+	timeout  time.Duration
+
+	publishers  int
+	subscribers int
+	topics      int
+	topicPrefix string
+	rate        float64
+	payloadSize int
+	duration    time.Duration
+	ramp        ramp
+	drain       time.Duration
+	json        bool
+}
+
+// parseFlags parses and checks the flags
+func parseFlags(args []string, stderr io.Writer) (*config, error) {
+	fs := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, usage) }
+	cfg := &config{}
+	fs.StringVar(&cfg.server, "server", getEnvOrDefault("PUBSUB_SERVER", DefaultServer), "broker base URL")
+	fs.StringVar(&cfg.token, "token", os.Getenv("PUBSUB_TOKEN"), "admin bearer token")
+	fs.StringVar(&cfg.user, "user", os.Getenv("PUBSUB_USER"), "admin basic-auth user")
+	fs.StringVar(&cfg.password, "password", os.Getenv("PUBSUB_PASSWORD"), "admin basic-auth password")
+	fs.DurationVar(&cfg.timeout, "timeout", DefaultTimeout, "connect and request timeout")
+	fs.IntVar(&cfg.publishers, "publishers", DefaultPublishers, "publishing connections")
+	fs.IntVar(&cfg.subscribers, "subscribers", DefaultSubscribers, "subscribing connections")
+	fs.IntVar(&cfg.topics, "topics", DefaultTopics, "load topics")
+	fs.StringVar(&cfg.topicPrefix, "topic-prefix", DefaultTopicPrefix, "load topic name prefix")
+	fs.Float64Var(&cfg.rate, "rate", DefaultRate, "publishes per second")
+	fs.IntVar(&cfg.payloadSize, "payload-size", DefaultPayloadSize, "payload size in bytes")
+	fs.DurationVar(&cfg.duration, "duration", DefaultDuration, "how long to publish")
+	rampSpec := fs.String("ramp", "none", "ramp-up profile")
+	fs.DurationVar(&cfg.drain, "drain", DefaultDrain, "how long to wait for deliveries")
+	fs.BoolVar(&cfg.json, "json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("%w: unexpected argument %q", errUsage, fs.Arg(0))
+	}
+
+	var err error
+	if cfg.ramp, err = parseRamp(*rampSpec); err != nil {
+		return nil, fmt.Errorf("%w: %v", errUsage, err)
+	}
+	switch {
+	case cfg.publishers < 1:
+		return nil, fmt.Errorf("%w: --publishers must be at least 1", errUsage)
+	case cfg.subscribers < 0:
+		return nil, fmt.Errorf("%w: --subscribers can't be negative", errUsage)
+	case cfg.topics < 1:
+		return nil, fmt.Errorf("%w: --topics must be at least 1", errUsage)
+	case cfg.rate <= 0:
+		return nil, fmt.Errorf("%w: --rate must be positive", errUsage)
+	case cfg.duration <= 0:
+		return nil, fmt.Errorf("%w: --duration must be positive", errUsage)
+	}
+	return cfg, nil
+}
+
+// header returns the auth headers for admin credentials
+func (c *config) header() http.Header {
+	header := http.Header{}
+	if c.token != "" {
+		header.Set("Authorization", "Bearer "+c.token)
+	} else if c.user != "" {
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(c.user+":"+c.password)))
+	}
+	return header
+}
+
+// wsURL returns the websocket endpoint for the server URL
+func (c *config) wsURL() string {
+	base := strings.TrimSuffix(c.server, "/")
+	if rest, ok := strings.CutPrefix(base, "https://"); ok {
+		return "wss://" + rest + "/ws"
+	}
+	return "ws://" + strings.TrimPrefix(base, "http://") + "/ws"
+}
+
+// statusError is a REST response outside 2xx
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+// do sends a REST request and decodes a 2xx JSON response into out. Other
+// statuses become a *statusError carrying the response body.
+func (c *config) do(ctx context.Context, method, path string, body, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.server, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header = c.header()
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &statusError{
+			status: resp.StatusCode,
+			err:    fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data))),
+		}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// getEnvOrDefault gets environment variable or returns default value
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+	"github.com/AnshulDekate/pubsub/pkg/transport/httpapi"
+	"github.com/AnshulDekate/pubsub/pkg/transport/ws"
+)
+
+const testToken = "secret"
+
+// broker runs the REST API and websocket in-process, with the admin routes
+// behind testToken
+func broker(t *testing.T) (*pubsub.PubSubSystem, string) {
+	t.Helper()
+	ps := pubsub.New()
+	t.Cleanup(ps.Close)
+	wsHandler, err := ws.NewHandler(ps, ws.WebSocketOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers := httpapi.NewHTTPHandlers(ps)
+	handlers.SetWebSocketHandler(wsHandler)
+	router := mux.NewRouter()
+	admin := router.NewRoute().Subrouter()
+	handlers.SetupAdminRoutes(admin)
+	admin.Use(httpapi.AdminAuth{Token: testToken}.Middleware)
+	handlers.SetupPublicRoutes(router)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return ps, server.URL
+}
+
+// loadgen runs a short load test against server and returns its exit code
+// and output
+func loadgen(t *testing.T, server string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	args = append(args, "--server", server, "--timeout", "5s", "--duration", "500ms", "--drain", "5s")
+	code := run(context.Background(), args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestSmokeRun(t *testing.T) {
+	ps, server := broker(t)
+	code, out, errOut := loadgen(t, server, "--token", testToken, "--json",
+		"--publishers", "2", "--subscribers", "3", "--topics", "2", "--rate", "200", "--payload-size", "512", "--ramp", "linear:200ms")
+	if code != exitOK {
+		t.Fatalf("exit %d: %s", code, errOut)
+	}
+	var report Report
+	if err := json.Unmarshal([]byte(out), &report); err != nil {
+		t.Fatalf("report isn't JSON: %v\n%s", err, out)
+	}
+
+	// Topic 0 has two subscribers and topic 1 one, and every publish
+	// reached all of its topic's subscribers
+	if report.Published == 0 || report.PublishErrors != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+	if report.Expected < report.Published || report.Delivered != report.Expected || report.Dropped != 0 {
+		t.Errorf("delivered %d of %d expected for %d publishes", report.Delivered, report.Expected, report.Published)
+	}
+	if l := report.Latency; l.Min <= 0 || l.P50 < l.Min || l.P99 < l.P50 || l.Max < l.P99 {
+		t.Errorf("latency = %+v", l)
+	}
+	if c := report.Connections; c.Attempted != 5 || c.Failed != 0 || c.ErrorRate != 0 {
+		t.Errorf("connections = %+v", c)
+	}
+	if report.Server == nil || !report.Server.Matches || report.Server.Published != report.Published {
+		t.Errorf("server = %+v, published %d", report.Server, report.Published)
+	}
+
+	// The load topics were created, and hold the run's publishes
+	var stored int64
+	for _, topic := range []string{"loadgen-0", "loadgen-1"} {
+		detail, err := ps.GetTopicDetail(topic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored += detail.MessageCount
+	}
+	if stored != report.Published {
+		t.Errorf("topics hold %d messages, report says %d", stored, report.Published)
+	}
+}
+
+func TestTextReportWithoutStats(t *testing.T) {
+	_, server := broker(t)
+
+	// Topics exist, so only /stats needs the token that isn't given
+	if code, _, errOut := loadgen(t, server, "--token", testToken, "--subscribers", "0", "--rate", "10", "--topic-prefix", "text-"); code != exitOK {
+		t.Fatalf("preparing topics: %s", errOut)
+	}
+	code, out, errOut := loadgen(t, server, "--publishers", "1", "--subscribers", "1", "--rate", "50", "--topic-prefix", "text-")
+	if code != exitOK {
+		t.Fatalf("exit %d: %s", code, errOut)
+	}
+	for _, want := range []string{"Published:", "Latency (ms):", "2 attempted, 0 failed", "Server /stats:  unavailable"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report lacks %q:\n%s", want, out)
+		}
+	}
+	if !strings.Contains(errOut, "can't read /stats") {
+		t.Errorf("stderr = %q", errOut)
+	}
+}
+
+func TestFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"--rate", "0"},
+		{"--publishers", "0"},
+		{"--ramp", "linear"},
+		{"--ramp", "step:0x1s"},
+		{"extra"},
+	} {
+		var stderr bytes.Buffer
+		if code := run(context.Background(), args, &bytes.Buffer{}, &stderr); code != exitUsage {
+			t.Errorf("%v: exit %d, want %d", args, code, exitUsage)
+		}
+	}
+}
+
+func TestRamp(t *testing.T) {
+	for _, tc := range []struct {
+		spec    string
+		elapsed time.Duration
+		want    float64
+	}{
+		{"none", 0, 1},
+		{"linear:10s", 0, 0},
+		{"linear:10s", 2500 * time.Millisecond, 0.25},
+		{"linear:10s", time.Minute, 1},
+		{"step:4x5s", 0, 0.25},
+		{"step:4x5s", 12 * time.Second, 0.75},
+		{"step:4x5s", time.Minute, 1},
+	} {
+		r, err := parseRamp(tc.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.fraction(tc.elapsed); got != tc.want {
+			t.Errorf("%s at %s = %g, want %g", tc.spec, tc.elapsed, got, tc.want)
+		}
+		if r.String() != tc.spec {
+			t.Errorf("%s prints as %s", tc.spec, r)
+		}
+	}
+}
+
+func TestHistogramPercentiles(t *testing.T) {
+	var h histogram
+	for i := 1; i <= 1000; i++ {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+	report := h.report()
+	for _, check := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"min", report.Min, 1},
+		{"p50", report.P50, 500},
+		{"p90", report.P90, 900},
+		{"p99", report.P99, 990},
+		{"max", report.Max, 1000},
+		{"mean", report.Mean, 500.5},
+	} {
+		if check.got < check.want || check.got > check.want*1.01 {
+			t.Errorf("%s = %g, want %g within 1%%", check.name, check.got, check.want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+	"github.com/AnshulDekate/pubsub/pkg/pubsubclient"
+)
+
+// unsubscribeTimeout bounds the clean unsubscribe after an interrupt
+const unsubscribeTimeout = 2 * time.Second
+
+// topics handles topics list, create and delete
+func (e env) topics(ctx context.Context, args []string) error {
+	fs, cfg := e.newFlagSet("topics")
+	retention := fs.Duration("retention", 0, "drop history older than this (create)")
+	dlq := fs.String("dlq", "", "dead-letter topic for undelivered events (create)")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return fmt.Errorf("%w: topics needs list, create or delete", errUsage)
+	}
+
+	switch action := positional[0]; {
+	case action == "list" && len(positional) == 1:
+		var resp pubsub.TopicsResponse
+		if err := cfg.do(ctx, "GET", cfg.path("/topics"), nil, &resp); err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(e.stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSUBSCRIBERS\tRATE_1M\tRATE_5M\tLAST_PUBLISHED")
+		for _, topic := range resp.Topics {
+			lastPublished := "-"
+			if topic.LastPublishedAt != nil {
+				lastPublished = topic.LastPublishedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%d\t%g\t%g\t%s\n", topic.Name, topic.Subscribers, topic.Rate1m, topic.Rate5m, lastPublished)
+		}
+		return w.Flush()
+
+	case action == "create" && len(positional) == 2:
+		req := pubsub.CreateTopicRequest{
+			Name:             positional[1],
+			RetentionSeconds: int(retention.Seconds()),
+			DeadLetterTopic:  *dlq,
+		}
+		var resp pubsub.CreateTopicResponse
+		if err := cfg.do(ctx, "POST", cfg.path("/topics"), req, &resp); err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stdout, "%s %s\n", resp.Status, resp.Topic)
+		return nil
+
+	case action == "delete" && len(positional) == 2:
+		var resp pubsub.DeleteTopicResponse
+		if err := cfg.do(ctx, "DELETE", cfg.path("/topics/"+url.PathEscape(positional[1])), nil, &resp); err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stdout, "%s %s\n", resp.Status, resp.Topic)
+		return nil
+
+	default:
+		return fmt.Errorf("%w: topics list | topics create <name> | topics delete <name>", errUsage)
+	}
+}
+
+// publish publishes one JSON payload over the websocket API
+func (e env) publish(ctx context.Context, args []string) error {
+	fs, cfg := e.newFlagSet("publish")
+	inline := fs.String("payload", "", "JSON payload")
+	file := fs.String("file", "", "read the JSON payload from a file")
+	stdin := fs.Bool("stdin", false, "read the JSON payload from standard input")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("%w: publish <topic> --payload JSON | --file PATH | --stdin", errUsage)
+	}
+
+	var data []byte
+	switch {
+	case *inline != "" && *file == "" && !*stdin:
+		data = []byte(*inline)
+	case *file != "" && *inline == "" && !*stdin:
+		data, err = os.ReadFile(*file)
+	case *stdin && *inline == "" && *file == "":
+		data, err = io.ReadAll(e.stdin)
+	default:
+		return fmt.Errorf("%w: publish needs exactly one of --payload, --file or --stdin", errUsage)
+	}
+	if err != nil {
+		return err
+	}
+	var payload interface{}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return fmt.Errorf("payload is not valid JSON: %v", err)
+	}
+
+	client, err := e.connect(ctx, cfg, nil)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ack, err := client.Publish(ctx, positional[0], payload)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(e.stdout).Encode(ack)
+}
+
+// subscribe prints a topic's events as NDJSON until interrupted, then
+// unsubscribes
+func (e env) subscribe(ctx context.Context, args []string) error {
+	fs, cfg := e.newFlagSet("subscribe")
+	lastN := fs.Int("last-n", 0, "replay this many events from history first")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("%w: subscribe <topic> [--last-n N]", errUsage)
+	}
+	topic := positional[0]
+
+	// Topic deletion, or a refused resubscribe, ends the subscription
+	ended := make(chan struct{}, 1)
+	client, err := e.connect(ctx, cfg, func(clientErr *pubsubclient.Error) {
+		if clientErr.Kind == pubsubclient.KindTopicDeleted || clientErr.Kind == pubsubclient.KindResubscribe {
+			select {
+			case ended <- struct{}{}:
+			default:
+			}
+		}
+		fmt.Fprintf(e.stderr, "pubsubctl: %v\n", clientErr)
+	})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	encoder := json.NewEncoder(e.stdout)
+	handler := func(event pubsub.EventResponse) {
+		encoder.Encode(event)
+	}
+	if err := client.Subscribe(ctx, topic, handler, pubsubclient.SubscribeOptions{LastN: *lastN}); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-ended:
+		return errors.New("subscription ended")
+	}
+
+	unsubscribeCtx, cancel := context.WithTimeout(context.Background(), unsubscribeTimeout)
+	defer cancel()
+	if err := client.Unsubscribe(unsubscribeCtx, topic); err != nil {
+		fmt.Fprintf(e.stderr, "pubsubctl: unsubscribe: %v\n", err)
+	}
+	return nil
+}
+
+// stats prints GET /stats, or a namespace's stats
+func (e env) stats(ctx context.Context, args []string) error {
+	fs, cfg := e.newFlagSet("stats")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return fmt.Errorf("%w: stats takes no arguments", errUsage)
+	}
+
+	var stats pubsub.StatsResponse
+	if err := cfg.do(ctx, "GET", cfg.path("/stats"), nil, &stats); err != nil {
+		return err
+	}
+	return e.printJSON(stats)
+}
+
+// health prints GET /health, or a namespace's health, and fails unless
+// the broker reports ok
+func (e env) health(ctx context.Context, args []string) error {
+	fs, cfg := e.newFlagSet("health")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return fmt.Errorf("%w: health takes no arguments", errUsage)
+	}
+
+	var health pubsub.HealthResponse
+	if err := cfg.do(ctx, "GET", cfg.path("/health"), nil, &health); err != nil {
+		return err
+	}
+	if err := e.printJSON(health); err != nil {
+		return err
+	}
+	if health.Status != "ok" {
+		return fmt.Errorf("broker is %s", health.Status)
+	}
+	return nil
+}
+
+// connect opens an SDK client with the command's server and credentials.
+// A nil onError prints client errors to stderr.
+func (e env) connect(ctx context.Context, cfg *config, onError func(*pubsubclient.Error)) (*pubsubclient.Client, error) {
+	if onError == nil {
+		onError = func(clientErr *pubsubclient.Error) {
+			fmt.Fprintf(e.stderr, "pubsubctl: %v\n", clientErr)
+		}
+	}
+	opts := pubsubclient.Options{
+		Namespace:      cfg.namespace,
+		Header:         cfg.header(),
+		RequestTimeout: cfg.timeout,
+		OnError:        onError,
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+	return pubsubclient.Connect(dialCtx, cfg.wsURL(), opts)
+}
@@ -0,0 +1,247 @@
+// Command pubsubctl manages topics, publishes and subscribes from the
+// command line, against a running broker.
+//
+// Usage:
+//
+//	pubsubctl topics list|create|delete [name]
+//	pubsubctl publish <topic> --payload JSON | --file PATH | --stdin
+//	pubsubctl subscribe <topic> [--last-n N]
+//	pubsubctl stats
+//	pubsubctl health
+//
+// Every command takes --server, --namespace, --token, --user and
+// --password, which default to PUBSUB_SERVER, PUBSUB_NAMESPACE,
+// PUBSUB_TOKEN, PUBSUB_USER and PUBSUB_PASSWORD.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultServer  = "http://localhost:8080"
+	DefaultTimeout = 10 * time.Second
+)
+
+// Exit codes
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+const usage = `Usage: pubsubctl <command> [flags]
+
+Commands:
+  topics list                 List topics
+  topics create <name>        Create a topic (--retention, --dlq)
+  topics delete <name>        Delete a topic
+  publish <topic>             Publish --payload JSON, --file PATH or --stdin
+  subscribe <topic>           Print events as NDJSON until interrupted (--last-n)
+  stats                       Print broker statistics
+  health                      Print broker health; exits 1 unless ok
+
+Common flags:
+  --server URL                Broker base URL (PUBSUB_SERVER, default ` + DefaultServer + `)
+  --namespace NAME            Namespace for topics, stats and health (PUBSUB_NAMESPACE)
+  --token TOKEN               Admin bearer token (PUBSUB_TOKEN)
+  --user NAME                 Admin basic-auth user (PUBSUB_USER)
+  --password PASSWORD         Admin basic-auth password (PUBSUB_PASSWORD)
+  --timeout DURATION          Request timeout (default 10s)
+`
+
+// errUsage marks errors caused by bad arguments
+var errUsage = errors.New("usage")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// env bundles a command's I/O so commands can run in-process
+type env struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// run executes one command and returns its exit code
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	e := env{stdin: stdin, stdout: stdout, stderr: stderr}
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	var err error
+	switch args[0] {
+	case "topics":
+		err = e.topics(ctx, args[1:])
+	case "publish":
+		err = e.publish(ctx, args[1:])
+	case "subscribe":
+		err = e.subscribe(ctx, args[1:])
+	case "stats":
+		err = e.stats(ctx, args[1:])
+	case "health":
+		err = e.health(ctx, args[1:])
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		err = fmt.Errorf("%w: unknown command %q", errUsage, args[0])
+	}
+
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, flag.ErrHelp):
+		return exitUsage
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(stderr, "pubsubctl: %v\n\n%s", err, usage)
+		return exitUsage
+	default:
+		fmt.Fprintf(stderr, "pubsubctl: %v\n", err)
+		return exitError
+	}
+}
+
+// config holds the connection flags shared by every command
+type config struct {
+	server    string
+	namespace string
+	token     string
+	user      string
+	password  string
+	timeout   time.Duration
+}
+
+// newFlagSet creates a command's flag set with the common flags registered
+func (e env) newFlagSet(name string) (*flag.FlagSet, *config) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	cfg := &config{}
+	fs.StringVar(&cfg.server, "server", getEnvOrDefault("PUBSUB_SERVER", DefaultServer), "broker base URL")
+	fs.StringVar(&cfg.namespace, "namespace", os.Getenv("PUBSUB_NAMESPACE"), "namespace for topics, stats and health")
+	fs.StringVar(&cfg.token, "token", os.Getenv("PUBSUB_TOKEN"), "admin bearer token")
+	fs.StringVar(&cfg.user, "user", os.Getenv("PUBSUB_USER"), "admin basic-auth user")
+	fs.StringVar(&cfg.password, "password", os.Getenv("PUBSUB_PASSWORD"), "admin basic-auth password")
+	fs.DurationVar(&cfg.timeout, "timeout", DefaultTimeout, "request timeout")
+	return fs, cfg
+}
+
+// parseArgs parses flags that may come before, between or after the
+// positional arguments, and returns the positional ones
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// header returns the auth headers for admin credentials
+func (c *config) header() http.Header {
+	header := http.Header{}
+	if c.token != "" {
+		header.Set("Authorization", "Bearer "+c.token)
+	} else if c.user != "" {
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(c.user+":"+c.password)))
+	}
+	return header
+}
+
+// path returns a REST path within the configured namespace
+func (c *config) path(p string) string {
+	if c.namespace == "" {
+		return p
+	}
+	return "/namespaces/" + url.PathEscape(c.namespace) + p
+}
+
+// wsURL returns the websocket endpoint for the server URL
+func (c *config) wsURL() string {
+	base := strings.TrimSuffix(c.server, "/")
+	if rest, ok := strings.CutPrefix(base, "https://"); ok {
+		return "wss://" + rest + "/ws"
+	}
+	return "ws://" + strings.TrimPrefix(base, "http://") + "/ws"
+}
+
+// do sends a REST request and decodes a 2xx JSON response into out. Other
+// statuses become errors carrying the response body.
+func (c *config) do(ctx context.Context, method, path string, body, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.server, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header = c.header()
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// printJSON writes v as indented JSON
+func (e env) printJSON(v interface{}) error {
+	encoder := json.NewEncoder(e.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// getEnvOrDefault gets environment variable or returns default value
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+	"github.com/AnshulDekate/pubsub/pkg/transport/httpapi"
+	"github.com/AnshulDekate/pubsub/pkg/transport/ws"
+)
+
+const testToken = "secret"
+
+// syncBuffer is a bytes.Buffer a command can write while the test reads
+type syncBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+// broker runs the REST API and websocket in-process, with the admin routes
+// behind testToken
+func broker(t *testing.T) (*pubsub.PubSubSystem, string) {
+	t.Helper()
+	ps := pubsub.New()
+	t.Cleanup(ps.Close)
+	wsHandler, err := ws.NewHandler(ps, ws.WebSocketOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers := httpapi.NewHTTPHandlers(ps)
+	handlers.SetWebSocketHandler(wsHandler)
+	router := mux.NewRouter()
+	admin := router.NewRoute().Subrouter()
+	handlers.SetupAdminRoutes(admin)
+	admin.Use(httpapi.AdminAuth{Token: testToken}.Middleware)
+	handlers.SetupPublicRoutes(router)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return ps, server.URL
+}
+
+// ctl runs pubsubctl against server and returns its exit code and output
+func ctl(t *testing.T, server, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr syncBuffer
+	args = append(args, "--server", server, "--token", testToken, "--timeout", "5s")
+	code := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestTopicsCommands(t *testing.T) {
+	ps, server := broker(t)
+	if code, out, errOut := ctl(t, server, "", "topics", "create", "orders", "--retention", "1h"); code != exitOK || out != "created orders\n" {
+		t.Fatalf("create = %d, %q, %q", code, out, errOut)
+	}
+	detail, err := ps.GetTopicDetail("orders")
+	if err != nil || detail.RetentionSeconds != 3600 {
+		t.Errorf("created %+v, %v", detail, err)
+	}
+	code, out, _ := ctl(t, server, "", "topics", "list")
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); code != exitOK || len(lines) != 2 ||
+		!strings.HasPrefix(lines[0], "NAME") || !strings.HasPrefix(lines[1], "orders ") {
+		t.Errorf("list = %d, %q", code, out)
+	}
+	if code, _, errOut := ctl(t, server, "", "topics", "create", "orders"); code != exitError || !strings.Contains(errOut, "409") {
+		t.Errorf("creating it again = %d, %q", code, errOut)
+	}
+	if code, out, _ := ctl(t, server, "", "topics", "delete", "orders"); code != exitOK || out != "deleted orders\n" {
+		t.Errorf("delete = %d, %q", code, out)
+	}
+	if code, _, errOut := ctl(t, server, "", "topics", "delete", "orders"); code != exitError || !strings.Contains(errOut, "404") {
+		t.Errorf("deleting a missing topic = %d, %q", code, errOut)
+	}
+
+	// Admin commands need the credentials
+	var stderr syncBuffer
+	if code := run(context.Background(), []string{"topics", "create", "x", "--server", server}, nil, &bytes.Buffer{}, &stderr); code != exitError || !strings.Contains(stderr.String(), "401") {
+		t.Errorf("create without a token = %d, %q", code, stderr.String())
+	}
+
+	for _, args := range [][]string{{"topics"}, {"topics", "rename", "x"}, {"topics", "create"}, {"bogus"}} {
+		if code, _, _ := ctl(t, server, "", args...); code != exitUsage {
+			t.Errorf("%v = %d, want %d", args, code, exitUsage)
+		}
+	}
+}
+
+func TestPublishCommand(t *testing.T) {
+	ps, server := broker(t)
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "payload.json")
+	if err := os.WriteFile(file, []byte(`{"from":"file"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		stdin string
+		args  []string
+	}{
+		{"", []string{"--payload", `{"from":"flag"}`}},
+		{"", []string{"--file", file}},
+		{`{"from":"stdin"}`, []string{"--stdin"}},
+	} {
+		code, out, errOut := ctl(t, server, tc.stdin, append([]string{"publish", "orders"}, tc.args...)...)
+		var ack pubsub.AckResponse
+		if code != exitOK || json.Unmarshal([]byte(out), &ack) != nil || ack.Status != "ok" {
+			t.Errorf("publish %v = %d, %q, %q", tc.args, code, out, errOut)
+		}
+	}
+	messages, _, err := ps.GetTopicMessages("orders", 0, 0, 10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sources []string
+	for _, event := range messages {
+		payload, _ := event.Message.Payload.(map[string]interface{})
+		sources = append(sources, payload["from"].(string))
+	}
+	if strings.Join(sources, ",") != "flag,file,stdin" {
+		t.Errorf("published from %v", sources)
+	}
+
+	if code, _, errOut := ctl(t, server, "", "publish", "orders", "--payload", "{oops"); code != exitError || !strings.Contains(errOut, "not valid JSON") {
+		t.Errorf("invalid JSON = %d, %q", code, errOut)
+	}
+	if code, _, errOut := ctl(t, server, "", "publish", "missing", "--payload", "1"); code != exitError || !strings.Contains(errOut, "topic missing not found") {
+		t.Errorf("publishing to a missing topic = %d, %q", code, errOut)
+	}
+	for _, args := range [][]string{{"publish", "orders"}, {"publish", "orders", "--payload", "1", "--stdin"}, {"publish", "--payload", "1"}} {
+		if code, _, _ := ctl(t, server, "", args...); code != exitUsage {
+			t.Errorf("%v = %d, want %d", args, code, exitUsage)
+		}
+	}
+}
+
+func TestSubscribeCommand(t *testing.T) {
+	ps, server := broker(t)
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	publish := func(id string) {
+		t.Helper()
+		if err := ps.Publish(ctx, "orders", pubsub.MessageData{ID: id, Payload: id}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish("old-1")
+	publish("old-2")
+
+	// Interrupting is cancelling the context, as SIGINT does in main
+	interrupt, cancel := context.WithCancel(ctx)
+	var stdout, stderr syncBuffer
+	exited := make(chan int, 1)
+	go func() {
+		exited <- run(interrupt, []string{"subscribe", "orders", "--last-n", "1", "--server", server}, nil, &stdout, &stderr)
+	}()
+	subscribers := func() int {
+		detail, _ := ps.GetTopicDetail("orders")
+		return detail.Subscribers
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for subscribers() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	publish("new-1")
+	publish("new-2")
+	for strings.Count(stdout.String(), "\n") < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case code := <-exited:
+		if code != exitOK {
+			t.Errorf("interrupted subscribe exited %d: %s", code, stderr.String())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscribe didn't exit when interrupted")
+	}
+	var ids []string
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		var event pubsub.EventResponse
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("line %q is not an event: %v", line, err)
+		}
+		ids = append(ids, event.Message.ID)
+	}
+	if strings.Join(ids, ",") != "old-2,new-1,new-2" {
+		t.Errorf("printed %v", ids)
+	}
+	if n := subscribers(); n != 0 {
+		t.Errorf("%d subscribers left after the interrupt", n)
+	}
+
+	if code, _, errOut := ctl(t, server, "", "subscribe", "missing"); code != exitError || !strings.Contains(errOut, "topic missing not found") {
+		t.Errorf("subscribing to a missing topic = %d, %q", code, errOut)
+	}
+}
+
+func TestSubscribeEndsWhenTopicDeleted(t *testing.T) {
+	ps, server := broker(t)
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan int, 1)
+	go func() {
+		code, _, _ := ctl(t, server, "", "subscribe", "orders")
+		exited <- code
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if detail, _ := ps.GetTopicDetail("orders"); detail.Subscribers > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := ps.DeleteTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case code := <-exited:
+		if code != exitError {
+			t.Errorf("subscribe exited %d when its topic was deleted", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscribe didn't exit when its topic was deleted")
+	}
+}
+
+func TestStatsAndHealthCommands(t *testing.T) {
+	ps, server := broker(t)
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	code, out, errOut := ctl(t, server, "", "stats")
+	var stats pubsub.StatsResponse
+	if code != exitOK || json.Unmarshal([]byte(out), &stats) != nil {
+		t.Fatalf("stats = %d, %q, %q", code, out, errOut)
+	}
+	if _, ok := stats.Topics["orders"]; !ok {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	code, out, errOut = ctl(t, server, "", "health")
+	var health pubsub.HealthResponse
+	if code != exitOK || json.Unmarshal([]byte(out), &health) != nil || health.Status != "ok" {
+		t.Errorf("health = %d, %q, %q", code, out, errOut)
+	}
+	if code, _, _ := ctl(t, server, "", "health", "extra"); code != exitUsage {
+		t.Errorf("health with an argument = %d", code)
+	}
+	if code, _, errOut := ctl(t, "http://127.0.0.1:1", "", "health"); code != exitError || errOut == "" {
+		t.Errorf("health of an unreachable server = %d, %q", code, errOut)
+	}
+}
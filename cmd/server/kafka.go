@@ -0,0 +1,33 @@
+//go:build kafka
+
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/AnshulDekate/pubsub/pkg/kafkaexport"
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// startKafkaExport mirrors the topics KAFKA_EXPORT routes into the Kafka
+// cluster at KAFKA_BROKERS and returns the exporter's Close
+func startKafkaExport(ps *pubsub.PubSubSystem, brokers, routes string) (func(context.Context), error) {
+	parsed, err := kafkaexport.ParseRoutes(routes)
+	if err != nil {
+		return nil, err
+	}
+	producer, err := kafkaexport.NewProducer(strings.Split(brokers, ","))
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := kafkaexport.Start(ps, producer, kafkaexport.Options{
+		Routes:    parsed,
+		QueueSize: getEnvIntOrDefault("KAFKA_EXPORT_QUEUE_SIZE", kafkaexport.DefaultQueueSize),
+	})
+	if err != nil {
+		producer.Close()
+		return nil, err
+	}
+	return exporter.Close, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Route groups a listener can serve
+const (
+	listenerPublic = "public"
+	listenerAdmin  = "admin"
+)
+
+// DefaultUnixSocketMode is the permission of unix sockets the server
+// creates: the owner and its group, e.g. a reverse proxy, may connect
+const DefaultUnixSocketMode os.FileMode = 0o660
+
+// listenerSpec is one entry of LISTENERS
+type listenerSpec struct {
+	role    string // listenerPublic or listenerAdmin
+	network string // "tcp" or "unix"
+	address string
+}
+
+func (spec listenerSpec) String() string {
+	if spec.network == "unix" {
+		return spec.role + " unix:" + spec.address
+	}
+	return spec.role + " " + spec.address
+}
+
+// parseListeners parses a comma-separated list of [public=|admin=]ADDRESS
+// entries, where ADDRESS is host:port or unix:PATH. Entries without a role
+// serve the public routes.
+func parseListeners(value string) ([]listenerSpec, error) {
+	var specs []listenerSpec
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		spec := listenerSpec{role: listenerPublic, network: "tcp", address: entry}
+		if role, address, found := strings.Cut(entry, "="); found {
+			if role != listenerPublic && role != listenerAdmin {
+				return nil, fmt.Errorf("listener %q: role must be %s or %s", entry, listenerPublic, listenerAdmin)
+			}
+			spec.role, spec.address = role, address
+		}
+		if path, found := strings.CutPrefix(spec.address, "unix:"); found {
+			spec.network, spec.address = "unix", path
+		}
+		if spec.address == "" {
+			return nil, fmt.Errorf("listener %q: missing address", entry)
+		}
+		if spec.network == "tcp" {
+			if _, _, err := net.SplitHostPort(spec.address); err != nil {
+				return nil, fmt.Errorf("listener %q: %w", entry, err)
+			}
+		}
+		specs = append(specs, spec)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("no listeners configured")
+	}
+	for _, spec := range specs {
+		if spec.role == listenerPublic {
+			return specs, nil
+		}
+	}
+	return nil, fmt.Errorf("at least one public listener is required")
+}
+
+// serve serves srv on ln until srv is shut down. TCP listeners use TLS when
+// the server has a TLS configuration; unix sockets are meant for a local
+// reverse proxy and always serve plain HTTP.
+func (spec listenerSpec) serve(srv *http.Server, ln net.Listener) error {
+	if srv.TLSConfig != nil && spec.network == "tcp" {
+		// Certificates are already loaded into TLSConfig
+		return srv.ServeTLS(ln, "", "")
+	}
+	return srv.Serve(ln)
+}
+
+// hasAdminListener reports whether any listener serves the admin routes
+func hasAdminListener(specs []listenerSpec) bool {
+	for _, spec := range specs {
+		if spec.role == listenerAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// listen opens the listener. A unix socket gets socketMode; a stale socket
+// file left by an earlier run is replaced, and the file is removed again
+// when the listener is closed.
+func (spec listenerSpec) listen(socketMode os.FileMode) (net.Listener, error) {
+	if spec.network == "tcp" {
+		return net.Listen("tcp", spec.address)
+	}
+
+	if info, err := os.Lstat(spec.address); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", spec.address)
+		}
+		if conn, err := net.Dial("unix", spec.address); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", spec.address)
+		}
+		if err := os.Remove(spec.address); err != nil {
+			return nil, fmt.Errorf("removing stale socket: %w", err)
+		}
+	}
+	ln, err := net.Listen("unix", spec.address)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(spec.address, socketMode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("setting socket permissions: %w", err)
+	}
+	return ln, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/AnshulDekate/pubsub/pkg/transport/httpapi"
+	"github.com/AnshulDekate/pubsub/pkg/transport/ws"
+)
+
+func TestParseListeners(t *testing.T) {
+	specs, err := parseListeners(" :9090, admin=127.0.0.1:9091 ,public=unix:/run/pubsub.sock,admin=unix:/run/admin.sock,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []listenerSpec{
+		{role: listenerPublic, network: "tcp", address: ":9090"},
+		{role: listenerAdmin, network: "tcp", address: "127.0.0.1:9091"},
+		{role: listenerPublic, network: "unix", address: "/run/pubsub.sock"},
+		{role: listenerAdmin, network: "unix", address: "/run/admin.sock"},
+	}
+	if !reflect.DeepEqual(specs, want) {
+		t.Errorf("parsed %v, want %v", specs, want)
+	}
+	if !hasAdminListener(specs) || hasAdminListener(specs[:1]) {
+		t.Error("hasAdminListener misreports the admin listeners")
+	}
+
+	for value, want := range map[string]string{
+		"":                         "no listeners",
+		"metrics=:9090":            "role must be",
+		"unix:":                    "missing address",
+		"9090":                     "missing port",
+		"admin=:9091":              "public listener is required",
+		":9090,admin=unix:":        "missing address",
+		":9090,internal=unix:/tmp": "role must be",
+	} {
+		if _, err := parseListeners(value); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseListeners(%q) = %v, want an error with %q", value, err, want)
+		}
+	}
+}
+
+// unixClient returns an HTTP client that connects to the socket at path
+func unixClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+// status returns the status of a GET, with the admin token when admin is
+// set
+func status(t *testing.T, client *http.Client, url string, admin bool) int {
+	t.Helper()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if admin {
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestUnixAndAdminListeners(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "pubsub.sock")
+	specs, err := parseListeners("unix:" + socket + ",admin=127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, adminRouter := composeRouters(t, httpapi.AdminAuth{Token: testAdminToken}, ws.NewOriginPolicy(nil, true), hasAdminListener(specs))
+	server, adminServer := newHTTPServer(router, nil), newHTTPServer(adminRouter, nil)
+
+	var adminAddr string
+	for _, spec := range specs {
+		ln, err := spec.listen(0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := server
+		if spec.role == listenerAdmin {
+			srv, adminAddr = adminServer, ln.Addr().String()
+		}
+		go spec.serve(srv, ln)
+	}
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0o600 {
+		t.Errorf("socket created with mode %v", info.Mode())
+	}
+
+	// Each listener serves its own route group
+	local := unixClient(socket)
+	if code := status(t, local, "http://pubsub/health", false); code != http.StatusOK {
+		t.Errorf("GET /health over the socket = %d", code)
+	}
+	if code := status(t, local, "http://pubsub/stats", true); code != http.StatusNotFound {
+		t.Errorf("GET /stats over the public socket = %d", code)
+	}
+	if code := status(t, http.DefaultClient, "http://"+adminAddr+"/stats", true); code != http.StatusOK {
+		t.Errorf("GET /stats on the admin listener = %d", code)
+	}
+	if code := status(t, http.DefaultClient, "http://"+adminAddr+"/stats", false); code != http.StatusUnauthorized {
+		t.Errorf("GET /stats on the admin listener without credentials = %d", code)
+	}
+
+	// Shutting down closes every listener and removes the socket file
+	server.Shutdown(context.Background())
+	adminServer.Shutdown(context.Background())
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("socket file left behind: %v", err)
+	}
+	if _, err := net.Dial("tcp", adminAddr); err == nil {
+		t.Error("admin listener still accepting connections")
+	}
+}
+
+func TestUnixSocketReplacesOnlyStaleFiles(t *testing.T) {
+	dir := t.TempDir()
+	spec := listenerSpec{role: listenerPublic, network: "unix", address: filepath.Join(dir, "pubsub.sock")}
+
+	// A socket left behind by a crashed run is replaced
+	stale, err := net.Listen("unix", spec.address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	ln, err := spec.listen(DefaultUnixSocketMode)
+	if err != nil {
+		t.Fatalf("listening over a stale socket: %v", err)
+	}
+	defer ln.Close()
+	if info, err := os.Stat(spec.address); err != nil || info.Mode().Perm() != DefaultUnixSocketMode {
+		t.Errorf("socket mode %v, %v", info.Mode(), err)
+	}
+
+	// One still in use is not
+	if _, err := spec.listen(DefaultUnixSocketMode); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("listening on a socket in use = %v", err)
+	}
+
+	// Nor is a file that isn't a socket
+	regular := listenerSpec{role: listenerPublic, network: "unix", address: filepath.Join(dir, "notes.txt")}
+	if err := os.WriteFile(regular.address, []byte("keep me"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := regular.listen(DefaultUnixSocketMode); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Errorf("listening on a regular file = %v", err)
+	}
+	if data, _ := os.ReadFile(regular.address); string(data) != "keep me" {
+		t.Error("regular file was replaced")
+	}
+}
+
+func TestDescribeListener(t *testing.T) {
+	for _, tc := range []struct {
+		spec   listenerSpec
+		secure bool
+		want   string
+	}{
+		{listenerSpec{network: "tcp", address: ":9090"}, false, "http://localhost:9090"},
+		{listenerSpec{network: "tcp", address: "10.0.0.1:9443"}, true, "https://10.0.0.1:9443"},
+		{listenerSpec{network: "unix", address: "/run/pubsub.sock"}, true, "unix:/run/pubsub.sock"},
+	} {
+		if got := describeListener(tc.spec, tc.secure); got != tc.want {
+			t.Errorf("describeListener(%v, %v) = %s, want %s", tc.spec, tc.secure, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,620 @@
+// Command server runs the pub-sub broker with its websocket, HTTP and
+// optional gRPC and MQTT APIs, configured from environment variables.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+	"github.com/AnshulDekate/pubsub/pkg/transport/grpcapi"
+	"github.com/AnshulDekate/pubsub/pkg/transport/httpapi"
+	"github.com/AnshulDekate/pubsub/pkg/transport/mqtt"
+	"github.com/AnshulDekate/pubsub/pkg/transport/ws"
+)
+
+const (
+	DefaultShutdownDrainDelay = 5 * time.Second  // Time /readyz reports 503 before connections are drained
+	DefaultShutdownTimeout    = 10 * time.Second // Upper bound on draining in-flight HTTP requests
+
+	// HTTP server timeouts; the write timeout leaves room for the longest poll
+	serverReadHeaderTimeout = 10 * time.Second
+	serverReadTimeout       = 30 * time.Second
+	serverWriteTimeout      = httpapi.MaxPollWait + 15*time.Second
+	serverIdleTimeout       = 120 * time.Second
+)
+
+func main() {
+	restorePath := flag.String("restore", "", "restore topics and history from a snapshot file before serving")
+	flag.Parse()
+
+	// Create the pub-sub system
+	ps := pubsub.New()
+	ps.SetHealthThresholds(pubsub.HealthThresholds{
+		MaxDropRate:    getEnvFloatOrDefault("HEALTH_MAX_DROP_RATE", pubsub.DefaultHealthMaxDropRate),
+		MaxConnections: getEnvIntOrDefault("HEALTH_MAX_CONNECTIONS", pubsub.DefaultHealthMaxConnections),
+	})
+	ps.SetMaxConnections(getEnvIntOrDefault("MAX_CONNECTIONS", 0))
+	ps.SetMaxConnectionsPerIP(getEnvIntOrDefault("MAX_CONNECTIONS_PER_IP", 0))
+	ps.SetWebhookRetryPolicy(
+		getEnvIntOrDefault("WEBHOOK_MAX_ATTEMPTS", pubsub.DefaultWebhookMaxAttempts),
+		getEnvDurationOrDefault("WEBHOOK_BACKOFF", pubsub.DefaultWebhookBackoff),
+	)
+	ps.SetPayloadLimits(pubsub.PayloadLimits{
+		MaxBytes: getEnvIntOrDefault("MAX_PAYLOAD_BYTES", pubsub.DefaultMaxPayloadBytes),
+		MaxDepth: getEnvIntOrDefault("MAX_PAYLOAD_DEPTH", pubsub.DefaultMaxPayloadDepth),
+	})
+	var tracerProvider *sdktrace.TracerProvider
+	if tracingEnabled() {
+		tp, err := newTracerProvider(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to set up tracing: %v", err)
+		}
+		tracerProvider = tp
+		ps.SetTracerProvider(tp)
+		log.Printf("Exporting OpenTelemetry traces")
+	}
+	if err := ps.SetSlowConsumerPolicy(pubsub.SlowConsumerPolicy{
+		MaxConsecutiveDrops: getEnvIntOrDefault("SLOW_CONSUMER_MAX_DROPS", 0),
+		MaxDropRatio:        getEnvFloatOrDefault("SLOW_CONSUMER_MAX_DROP_RATIO", 0),
+		Grace:               getEnvDurationOrDefault("SLOW_CONSUMER_GRACE", 0),
+	}); err != nil {
+		log.Fatalf("Invalid slow-consumer policy: %v", err)
+	}
+	if err := ps.SetSubscriberWatermarks(pubsub.SubscriberWatermarks{
+		High:       getEnvIntOrDefault("SUBSCRIBERS_HIGH_WATERMARK", 0),
+		Low:        getEnvIntOrDefault("SUBSCRIBERS_LOW_WATERMARK", 0),
+		Hysteresis: getEnvIntOrDefault("SUBSCRIBERS_WATERMARK_HYSTERESIS", 0),
+	}); err != nil {
+		log.Fatalf("Invalid subscriber watermarks: %v", err)
+	}
+	ps.SetPauseBufferSize(getEnvIntOrDefault("PAUSE_BUFFER_SIZE", pubsub.DefaultPauseBufferSize))
+	ps.SetTopicBacklogSize(getEnvIntOrDefault("TOPIC_BACKLOG_SIZE", pubsub.DefaultTopicBacklogSize))
+	ps.SetMaxScheduled(getEnvIntOrDefault("MAX_SCHEDULED", pubsub.DefaultMaxScheduled))
+	ps.SetJoinTimeout(getEnvDurationOrDefault("JOIN_REQUEST_TIMEOUT", pubsub.DefaultJoinTimeout))
+	ps.SetSessionRetention(getEnvDurationOrDefault("SESSION_RETENTION", pubsub.DefaultSessionRetention))
+	ps.SetAckPolicy(
+		getEnvDurationOrDefault("ACK_TIMEOUT", pubsub.DefaultAckTimeout),
+		getEnvIntOrDefault("ACK_WINDOW", pubsub.DefaultAckWindow),
+	)
+	ps.SetReceiptPolicy(
+		getEnvDurationOrDefault("RECEIPT_RETENTION", pubsub.DefaultReceiptRetention),
+		getEnvIntOrDefault("MAX_RECEIPTS", pubsub.DefaultMaxReceipts),
+	)
+
+	// Prometheus metrics are fed by hooks; register them before topics
+	// are restored so restored topics are counted
+	var metrics *pubsub.Metrics
+	if getEnvOrDefault("METRICS_ENABLED", "true") == "true" {
+		metrics = pubsub.NewMetrics()
+		ps.AddHooks(metrics.Hooks())
+	}
+	// The same metrics pushed to a statsd server, when one is set
+	var statsd *pubsub.StatsD
+	if addr := os.Getenv("STATSD_ADDR"); addr != "" {
+		var err error
+		statsd, err = pubsub.NewStatsD(addr,
+			getEnvOrDefault("STATSD_PREFIX", pubsub.DefaultStatsDPrefix),
+			getEnvFloatOrDefault("STATSD_SAMPLE_RATE", 1),
+		)
+		if err != nil {
+			log.Fatalf("Failed to start statsd sink: %v", err)
+		}
+		ps.AddHooks(statsd.Hooks())
+	}
+
+	// Optional worker lanes for live delivery
+	if err := ps.EnableFanoutWorkers(getEnvIntOrDefault("FANOUT_WORKERS", 0)); err != nil {
+		log.Fatalf("Failed to start fan-out workers: %v", err)
+	}
+
+	// Keys topics can sign events with, as KEY_ID:BASE64_SECRET pairs
+	if v := os.Getenv("SIGNING_KEYS"); v != "" {
+		keys, err := pubsub.ParseSigningKeys(v)
+		if err != nil {
+			log.Fatalf("Invalid SIGNING_KEYS: %v", err)
+		}
+		if err := ps.SetSigningKeys(keys); err != nil {
+			log.Fatalf("Invalid SIGNING_KEYS: %v", err)
+		}
+	}
+
+	// Per-namespace limits, e.g.
+	// {"tenant-a":{"max_topics":100,"publish_rate":500,"publish_burst":1000}}
+	if v := os.Getenv("NAMESPACE_LIMITS"); v != "" {
+		var limits map[string]pubsub.NamespaceLimits
+		if err := json.Unmarshal([]byte(v), &limits); err != nil {
+			log.Fatalf("Invalid NAMESPACE_LIMITS: %v", err)
+		}
+		for ns, nsLimits := range limits {
+			if err := ps.SetNamespaceLimits(ns, nsLimits); err != nil {
+				log.Fatalf("Invalid NAMESPACE_LIMITS: %v", err)
+			}
+		}
+	}
+
+	// Optional file-backed history
+	if dataDir := os.Getenv("DATA_DIR"); dataDir != "" {
+		store, err := pubsub.OpenHistoryStore(dataDir, getEnvDurationOrDefault("FSYNC_INTERVAL", pubsub.DefaultFsyncInterval))
+		if err != nil {
+			log.Fatalf("Failed to open data directory: %v", err)
+		}
+		if err := store.SetOverflow(pubsub.HistoryOverflow(os.Getenv("HISTORY_OVERFLOW"))); err != nil {
+			log.Fatalf("Invalid HISTORY_OVERFLOW: %v", err)
+		}
+		if err := ps.EnablePersistence(store); err != nil {
+			log.Fatalf("Failed to restore history: %v", err)
+		}
+	}
+
+	// Optional SQLite archive of every published event
+	if sqlitePath := os.Getenv("SQLITE_PATH"); sqlitePath != "" {
+		archive, err := pubsub.OpenSQLiteHistory(sqlitePath, getEnvDurationOrDefault("SQLITE_RETENTION", 0))
+		if err != nil {
+			log.Fatalf("Failed to open SQLite history (build with -tags sqlite): %v", err)
+		}
+		ps.EnableSQLiteHistory(archive)
+	}
+
+	// Optional bridge to NATS, compiled in with -tags nats
+	var closeNATSBridge func()
+	if natsURL := os.Getenv("NATS_URL"); natsURL != "" {
+		var err error
+		if closeNATSBridge, err = startNATSBridge(ps, natsURL); err != nil {
+			log.Fatalf("Failed to start NATS bridge (build with -tags nats): %v", err)
+		}
+	}
+
+	// Optional export of selected topics to Kafka, compiled in with -tags kafka
+	var closeKafkaExport func(context.Context)
+	if brokers := os.Getenv("KAFKA_BROKERS"); brokers != "" {
+		var err error
+		if closeKafkaExport, err = startKafkaExport(ps, brokers, os.Getenv("KAFKA_EXPORT")); err != nil {
+			log.Fatalf("Failed to start Kafka export (build with -tags kafka): %v", err)
+		}
+	}
+
+	// Connection audit log, kept in memory and optionally appended to a file
+	if auditSize := getEnvIntOrDefault("AUDIT_LOG_SIZE", pubsub.DefaultAuditLogSize); auditSize > 0 {
+		auditLog, err := pubsub.NewAuditLog(auditSize, os.Getenv("AUDIT_LOG_FILE"))
+		if err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+		ps.EnableAuditLog(auditLog)
+	}
+
+	// Restore from a snapshot before accepting any clients
+	if *restorePath != "" {
+		if err := restoreFromFile(ps, *restorePath); err != nil {
+			log.Fatalf("Failed to restore snapshot: %v", err)
+		}
+	}
+
+	// Broker-owned $sys topics, on unless SYS_TOPICS=false
+	if getEnvOrDefault("SYS_TOPICS", "true") != "false" {
+		ps.EnableSystemTopics(getEnvDurationOrDefault("SYS_STATS_INTERVAL", pubsub.DefaultSysStatsInterval))
+	}
+
+	// Development mode allows every origin unless ALLOWED_ORIGINS is set;
+	// production mode only allows the listed origins
+	var allowedOrigins []string
+	if v := os.Getenv("ALLOWED_ORIGINS"); v != "" {
+		allowedOrigins = strings.Split(v, ",")
+	}
+	productionMode := getEnvOrDefault("SERVER_MODE", "development") == "production"
+	origins := ws.NewOriginPolicy(allowedOrigins, !productionMode && len(allowedOrigins) == 0)
+
+	// Optional TLS, with client certificates required when a CA bundle is given
+	var tlsConfig *tls.Config
+	if certFile := os.Getenv("TLS_CERT_FILE"); certFile != "" {
+		var err error
+		tlsConfig, err = LoadServerTLSConfig(certFile, os.Getenv("TLS_KEY_FILE"), os.Getenv("TLS_CLIENT_CA_FILE"))
+		if err != nil {
+			log.Fatalf("Failed to configure TLS: %v", err)
+		}
+	}
+
+	// REST request limits; MAX_REQUEST_BODY_BYTES=0 and REST_RATE_LIMIT=0
+	// (the default) turn them off. Websocket connections are counted
+	// against MAX_CONNECTIONS_PER_IP by the same client address.
+	trustedProxies, err := httpapi.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	limiter := httpapi.NewRequestLimiter(ps, httpapi.RequestLimits{
+		MaxBodyBytes:   int64(getEnvIntOrDefault("MAX_REQUEST_BODY_BYTES", httpapi.DefaultMaxRequestBodyBytes)),
+		Rate:           getEnvFloatOrDefault("REST_RATE_LIMIT", 0),
+		Burst:          getEnvIntOrDefault("REST_RATE_BURST", 0),
+		TrustedProxies: trustedProxies,
+	})
+
+	pongWait := getEnvDurationOrDefault("WS_PONG_WAIT", ws.DefaultPongWait)
+	wsHandler, err := ws.NewHandler(ps, ws.WebSocketOptions{
+		Origins:              origins,
+		EnableCompression:    getEnvOrDefault("WS_COMPRESSION", "false") == "true",
+		CompressionLevel:     getEnvIntOrDefault("WS_COMPRESSION_LEVEL", ws.DefaultCompressionLevel),
+		CompressionThreshold: getEnvIntOrDefault("WS_COMPRESSION_THRESHOLD", ws.DefaultCompressionThreshold),
+		ClientIDFromCert:     getEnvOrDefault("TLS_CLIENT_CN_AS_ID", "false") == "true",
+		ClientIDTakeover:     getEnvOrDefault("WS_CLIENT_ID_TAKEOVER", "false") == "true",
+		ClientIP:             limiter.ClientIP,
+		ResumeTokens:         ws.ResumeTokenPolicy(getEnvOrDefault("WS_RESUME_TOKENS", string(ws.ResumeTokensNewIdentity))),
+		PongWait:             pongWait,
+		PingPeriod:           getEnvDurationOrDefault("WS_PING_PERIOD", pongWait*9/10),
+		WriteWait:            getEnvDurationOrDefault("WS_WRITE_WAIT", ws.DefaultWriteWait),
+		MaxMessageSize:       getEnvIntOrDefault("WS_MAX_MESSAGE_SIZE", 0),
+		SendBufferSize:       getEnvIntOrDefault("WS_SEND_BUFFER_SIZE", pubsub.ClientSendBufferSize),
+		ControlBufferSize:    getEnvIntOrDefault("WS_CONTROL_BUFFER_SIZE", ws.DefaultControlBufferSize),
+		ReadBufferSize:       getEnvIntOrDefault("WS_READ_BUFFER_SIZE", ws.DefaultReadBufferSize),
+		WriteBufferSize:      getEnvIntOrDefault("WS_WRITE_BUFFER_SIZE", ws.DefaultWriteBufferSize),
+		RequestCacheSize:     getEnvIntOrDefault("WS_REQUEST_CACHE_SIZE", ws.DefaultRequestCacheSize),
+		RequestCacheTTL:      getEnvDurationOrDefault("WS_REQUEST_CACHE_TTL", ws.DefaultRequestCacheTTL),
+		ProbePeriod:          getEnvDurationOrDefault("WS_PROBE_PERIOD", ws.DefaultProbePeriod),
+		ProbeTimeout:         getEnvDurationOrDefault("WS_PROBE_TIMEOUT", ws.DefaultProbeTimeout),
+		ErrorBudget:          getEnvIntOrDefault("WS_ERROR_BUDGET", ws.DefaultErrorBudget),
+		ErrorWindow:          getEnvDurationOrDefault("WS_ERROR_WINDOW", ws.DefaultErrorWindow),
+		MaxChunkedBytes:      getEnvIntOrDefault("WS_MAX_CHUNKED_BYTES", ws.DefaultMaxChunkedBytes),
+		ChunkTimeout:         getEnvDurationOrDefault("WS_CHUNK_TIMEOUT", ws.DefaultChunkTimeout),
+		PublishAckBatchSize:  getEnvIntOrDefault("WS_PUBLISH_ACK_BATCH_SIZE", ws.DefaultPublishAckBatchSize),
+		PublishAckInterval:   getEnvDurationOrDefault("WS_PUBLISH_ACK_INTERVAL", ws.DefaultPublishAckInterval),
+		StrictProtocol:       getEnvOrDefault("WS_STRICT_PROTOCOL", "false") == "true",
+	})
+	if err != nil {
+		log.Fatalf("Invalid websocket options: %v", err)
+	}
+
+	// Create HTTP handlers
+	handlers := httpapi.NewHTTPHandlers(ps)
+	historyMaxLimit := getEnvIntOrDefault("HISTORY_MAX_LIMIT", httpapi.DefaultMaxHistoryLimit)
+	if historyMaxLimit <= 0 {
+		log.Fatalf("Invalid HISTORY_MAX_LIMIT %d: must be positive", historyMaxLimit)
+	}
+	handlers.SetMaxHistoryLimit(historyMaxLimit)
+	handlers.Polls().SetTTL(getEnvDurationOrDefault("POLL_SUBSCRIPTION_TTL", httpapi.DefaultPollSubscriptionTTL))
+	handlers.SetMetrics(metrics)
+	handlers.SetWebSocketHandler(wsHandler)
+
+	// Admin routes need their own credential; without one they stay open
+	// as before
+	adminAuth := httpapi.AdminAuth{
+		Token:    os.Getenv("ADMIN_TOKEN"),
+		Username: os.Getenv("ADMIN_USERNAME"),
+		Password: os.Getenv("ADMIN_PASSWORD"),
+	}
+	if !adminAuth.Configured() {
+		log.Printf("WARNING: no ADMIN_TOKEN or ADMIN_USERNAME set - topic management, /stats and /subscriptions are open to anyone who can reach the server")
+	}
+
+	// Snapshots dump and replace every topic, so they need credentials;
+	// server-side snapshot files stay under SNAPSHOT_DIR
+	if err := handlers.EnableSnapshots(adminAuth, os.Getenv("SNAPSHOT_DIR")); err != nil {
+		log.Printf("WARNING: /admin/snapshot and /admin/restore are disabled: %v", err)
+	}
+
+	// LISTENERS lists every address to serve, e.g.
+	// ":9090,admin=unix:/run/pubsub/admin.sock"; without it the public
+	// routes are served on PORT and, with ADMIN_PORT set, the admin routes
+	// only on that port so it can be firewalled separately
+	listenerConfig := os.Getenv("LISTENERS")
+	if listenerConfig == "" {
+		listenerConfig = ":" + getEnvOrDefault("PORT", "9090")
+		if adminPort := os.Getenv("ADMIN_PORT"); adminPort != "" {
+			listenerConfig += "," + listenerAdmin + "=:" + adminPort
+		}
+	}
+	listeners, err := parseListeners(listenerConfig)
+	if err != nil {
+		log.Fatalf("Invalid LISTENERS: %v", err)
+	}
+	socketMode := DefaultUnixSocketMode
+	if v := os.Getenv("UNIX_SOCKET_MODE"); v != "" {
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil {
+			log.Fatalf("Invalid UNIX_SOCKET_MODE: %v", err)
+		}
+		socketMode = os.FileMode(mode)
+	}
+	router, adminRouter := newRouters(handlers, adminAuth, origins, limiter, hasAdminListener(listeners))
+
+	// One server per route group; Shutdown closes every listener it serves
+	server := newHTTPServer(router, tlsConfig)
+	var adminServer *http.Server
+	if adminRouter != nil {
+		adminServer = newHTTPServer(adminRouter, tlsConfig)
+	}
+
+	log.Printf("Starting chat room server")
+	for _, spec := range listeners {
+		ln, err := spec.listen(socketMode)
+		if err != nil {
+			log.Fatalf("Failed to listen on %s: %v", spec, err)
+		}
+		srv := server
+		if spec.role == listenerAdmin {
+			srv = adminServer
+		}
+		log.Printf("Serving %s routes on %s", spec.role, describeListener(spec, tlsConfig != nil))
+		go func(spec listenerSpec, srv *http.Server, ln net.Listener) {
+			if err := spec.serve(srv, ln); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Server error on %s: %v", spec, err)
+			}
+		}(spec, srv, ln)
+	}
+
+	// Optional gRPC API on its own port
+	var grpcServer *grpc.Server
+	if grpcPort := os.Getenv("GRPC_PORT"); grpcPort != "" {
+		var grpcOpts []grpc.ServerOption
+		if tlsConfig != nil {
+			grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		grpcServer = grpcapi.NewGRPCServer(ps, grpcOpts...)
+		log.Printf("gRPC API available at: localhost:%s", grpcPort)
+		go func() {
+			if err := grpcapi.ServeGRPC(grpcServer, ":"+grpcPort); err != nil {
+				log.Fatalf("gRPC server error: %v", err)
+			}
+		}()
+	}
+
+	// Optional MQTT 3.1.1 listener on its own address, e.g. ":1883", with
+	// TLS when the HTTP server has it
+	var mqttServer *mqtt.Server
+	if mqttAddr := os.Getenv("MQTT_ADDR"); mqttAddr != "" {
+		mqttServer = mqtt.NewServer(ps, mqtt.Options{
+			TopicSeparator: getEnvOrDefault("MQTT_TOPIC_SEPARATOR", mqtt.DefaultTopicSeparator),
+			MaxPacketSize:  getEnvIntOrDefault("MQTT_MAX_PACKET_SIZE", mqtt.DefaultMaxPacketSize),
+		})
+		ln, err := net.Listen("tcp", mqttAddr)
+		if err != nil {
+			log.Fatalf("Failed to listen for MQTT on %s: %v", mqttAddr, err)
+		}
+		if tlsConfig != nil {
+			ln = tls.NewListener(ln, tlsConfig)
+		}
+		log.Printf("MQTT listener available at: %s", mqttAddr)
+		go func() {
+			if err := mqttServer.Serve(ln); err != nil && !errors.Is(err, mqtt.ErrServerClosed) {
+				log.Fatalf("MQTT server error: %v", err)
+			}
+		}()
+	}
+
+	// Handle graceful shutdown
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-c
+		log.Println("Shutting down server...")
+
+		// Fail readiness first so load balancers stop routing new traffic,
+		// then give them time to notice before draining connections
+		ps.BeginShutdown()
+		drainDelay := getEnvDurationOrDefault("SHUTDOWN_DRAIN_DELAY", DefaultShutdownDrainDelay)
+		time.Sleep(drainDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
+		defer cancel()
+		// Websocket connections are hijacked, so Shutdown doesn't wait for
+		// them; they are told to go and given until the timeout to leave
+		ps.CloseAllClients("server shutting down")
+		waitForConnections(ctx, ps)
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Error during shutdown: %v", err)
+		}
+		if adminServer != nil {
+			if err := adminServer.Shutdown(ctx); err != nil {
+				log.Printf("Error during admin shutdown: %v", err)
+			}
+		}
+		if grpcServer != nil {
+			grpcServer.GracefulStop()
+		}
+		if mqttServer != nil {
+			mqttServer.Close()
+		}
+		if closeNATSBridge != nil {
+			closeNATSBridge()
+		}
+		if closeKafkaExport != nil {
+			closeKafkaExport(ctx)
+		}
+		ps.Close()
+		if statsd != nil {
+			statsd.Close()
+		}
+		if tracerProvider != nil {
+			if err := tracerProvider.Shutdown(ctx); err != nil {
+				log.Printf("Error flushing traces: %v", err)
+			}
+		}
+		os.Exit(0)
+	}()
+
+	select {} // Wait for the shutdown goroutine to exit the process
+}
+
+// waitForConnections waits until every websocket connection has ended or
+// ctx is done
+func waitForConnections(ctx context.Context, ps *pubsub.PubSubSystem) {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for ps.OpenConnections() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// newHTTPServer returns a server for one route group
+func newHTTPServer(handler http.Handler, tlsConfig *tls.Config) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: serverReadHeaderTimeout,
+		ReadTimeout:       serverReadTimeout,
+		WriteTimeout:      serverWriteTimeout,
+		IdleTimeout:       serverIdleTimeout,
+	}
+}
+
+// describeListener returns the base URL a listener is reachable at
+func describeListener(spec listenerSpec, secure bool) string {
+	if spec.network == "unix" {
+		return "unix:" + spec.address
+	}
+	scheme := "http"
+	if secure {
+		scheme = "https"
+	}
+	host, port, _ := net.SplitHostPort(spec.address)
+	if host == "" {
+		host = "localhost"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+// newRouters builds the public router and, when separateAdmin is set, a
+// second router for the admin routes. Otherwise the admin routes are served
+// by the public router behind auth and the second router is nil.
+func newRouters(handlers *httpapi.HTTPHandlers, auth httpapi.AdminAuth, origins *ws.OriginPolicy, limiter *httpapi.RequestLimiter, separateAdmin bool) (*mux.Router, *mux.Router) {
+	router := mux.NewRouter()
+	var adminRouter *mux.Router
+	if separateAdmin {
+		adminRouter = mux.NewRouter()
+		handlers.SetupAdminRoutes(adminRouter)
+		adminRouter.Use(corsMiddleware(origins))
+		adminRouter.Use(loggingMiddleware)
+		adminRouter.Use(limiter.Middleware)
+		adminRouter.Use(auth.Middleware)
+	} else {
+		admin := router.NewRoute().Subrouter()
+		handlers.SetupAdminRoutes(admin)
+		admin.Use(auth.Middleware)
+	}
+	handlers.SetupPublicRoutes(router)
+
+	// Add CORS middleware for development
+	router.Use(corsMiddleware(origins))
+
+	// Add logging middleware
+	router.Use(loggingMiddleware)
+
+	// Body size and per-IP rate limits
+	router.Use(limiter.Middleware)
+
+	return router, adminRouter
+}
+
+// restoreFromFile loads a snapshot file into the pub-sub system
+func restoreFromFile(ps *pubsub.PubSubSystem, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	snapshot, err := pubsub.ReadSnapshot(f)
+	if err != nil {
+		return err
+	}
+	restored, err := ps.RestoreSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+	log.Printf("Restored %d topics from snapshot %s", restored, path)
+	return nil
+}
+
+// corsMiddleware adds CORS headers according to the origin policy
+func corsMiddleware(policy *ws.OriginPolicy) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if policy.Permissive() {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Add("Vary", "Origin")
+				if origin := r.Header.Get("Origin"); origin != "" && policy.AllowedOrigin(origin) {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+				}
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+httpapi.PollTokenHeader)
+
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// loggingMiddleware logs HTTP requests
+func loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("%s %s %s", r.Method, r.RequestURI, r.RemoteAddr)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// getEnvOrDefault returns environment variable value or default
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvIntOrDefault returns environment variable value parsed as an int or default
+func getEnvIntOrDefault(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+		log.Printf("Invalid value %q for %s, using default %d", value, key, defaultValue)
+	}
+	return defaultValue
+}
+
+// getEnvFloatOrDefault returns environment variable value parsed as a float or default
+func getEnvFloatOrDefault(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+		log.Printf("Invalid value %q for %s, using default %g", value, key, defaultValue)
+	}
+	return defaultValue
+}
+
+// getEnvDurationOrDefault returns environment variable value parsed as a duration or default
+func getEnvDurationOrDefault(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+		log.Printf("Invalid value %q for %s, using default %s", value, key, defaultValue)
+	}
+	return defaultValue
+}
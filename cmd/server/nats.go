@@ -0,0 +1,18 @@
+//go:build nats
+
+package main
+
+import (
+	"github.com/AnshulDekate/pubsub/pkg/natsbridge"
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// startNATSBridge bridges ps to the NATS server at NATS_URL, configured by
+// NATS_SUBJECT_PREFIX and NATS_AUTO_CREATE, and returns its Close
+func startNATSBridge(ps *pubsub.PubSubSystem, url string) (func(), error) {
+	bridge := natsbridge.Start(ps, natsbridge.Dial(url), natsbridge.Options{
+		SubjectPrefix: getEnvOrDefault("NATS_SUBJECT_PREFIX", natsbridge.DefaultSubjectPrefix),
+		AutoCreate:    getEnvOrDefault("NATS_AUTO_CREATE", "false") == "true",
+	})
+	return bridge.Close, nil
+}
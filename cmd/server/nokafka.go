@@ -0,0 +1,15 @@
+//go:build !kafka
+
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// startKafkaExport fails in servers built without the Kafka client
+func startKafkaExport(*pubsub.PubSubSystem, string, string) (func(context.Context), error) {
+	return nil, errors.New("this server was built without Kafka support")
+}
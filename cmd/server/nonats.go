@@ -0,0 +1,14 @@
+//go:build !nats
+
+package main
+
+import (
+	"errors"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// startNATSBridge fails in servers built without the NATS client
+func startNATSBridge(*pubsub.PubSubSystem, string) (func(), error) {
+	return nil, errors.New("this server was built without NATS support")
+}
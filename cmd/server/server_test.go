@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+	"github.com/AnshulDekate/pubsub/pkg/transport/httpapi"
+	"github.com/AnshulDekate/pubsub/pkg/transport/ws"
+)
+
+const testAdminToken = "admin-secret"
+
+// composeServer wires the library packages together the way main does and
+// returns the public server and, with separateAdmin, the admin server.
+// Admin routes need testAdminToken.
+func composeServer(t *testing.T, separateAdmin bool) (*httptest.Server, *httptest.Server) {
+	t.Helper()
+	return composeServerWithAuth(t, httpapi.AdminAuth{Token: testAdminToken}, ws.NewOriginPolicy(nil, true), separateAdmin)
+}
+
+// composeServerWithAuth is composeServer with the given admin credentials
+// and allowed origins
+func composeServerWithAuth(t *testing.T, auth httpapi.AdminAuth, origins *ws.OriginPolicy, separateAdmin bool) (*httptest.Server, *httptest.Server) {
+	t.Helper()
+	router, adminRouter := composeRouters(t, auth, origins, separateAdmin)
+	server := httptest.NewUnstartedServer(nil)
+	server.Config = newHTTPServer(router, nil)
+	server.Start()
+	t.Cleanup(server.Close)
+
+	var admin *httptest.Server
+	if adminRouter != nil {
+		admin = httptest.NewUnstartedServer(nil)
+		admin.Config = newHTTPServer(adminRouter, nil)
+		admin.Start()
+		t.Cleanup(admin.Close)
+	}
+	return server, admin
+}
+
+// composeRouters builds the routers the way main does, over a new
+// pub-sub system
+func composeRouters(t *testing.T, auth httpapi.AdminAuth, origins *ws.OriginPolicy, separateAdmin bool) (*mux.Router, *mux.Router) {
+	t.Helper()
+	ps := pubsub.New()
+	t.Cleanup(ps.Close)
+	wsHandler, err := ws.NewHandler(ps, ws.WebSocketOptions{Origins: origins})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers := httpapi.NewHTTPHandlers(ps)
+	handlers.SetWebSocketHandler(wsHandler)
+	handlers.EnableSnapshots(auth, "")
+	limiter := httpapi.NewRequestLimiter(ps, httpapi.RequestLimits{MaxBodyBytes: httpapi.DefaultMaxRequestBodyBytes})
+	return newRouters(handlers, auth, origins, limiter, separateAdmin)
+}
+
+// request sends a JSON request, with the admin token when admin is set,
+// and decodes the response into out when it is non-nil
+func request(t *testing.T, method, url, body string, admin bool, out interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if admin {
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decoding response: %v", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// dialServer connects to the server's websocket and skips the welcome
+func dialServer(t *testing.T, server *httptest.Server) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	expectFrame(t, conn, "welcome")
+	return conn
+}
+
+// expectFrame reads frames until one of type kind arrives
+func expectFrame(t *testing.T, conn *websocket.Conn, kind string) map[string]interface{} {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var frame map[string]interface{}
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatalf("waiting for %s: %v", kind, err)
+		}
+		if frame["type"] == kind {
+			return frame
+		}
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after 5 seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// probeClient is a client registered only to test whether its ID is taken
+type probeClient struct{ id string }
+
+func (c *probeClient) GetClientID() string           { return c.id }
+func (c *probeClient) IsConnected() bool             { return true }
+func (c *probeClient) SendMessage(interface{}) error { return nil }
+func (c *probeClient) GetLastActive() time.Time      { return time.Now() }
+
+func TestComposedServerEndToEnd(t *testing.T) {
+	server, _ := composeServer(t, false)
+
+	// Topic management needs the admin token
+	if status := request(t, "POST", server.URL+"/topics", `{"name":"orders"}`, false, nil); status != http.StatusUnauthorized {
+		t.Fatalf("POST /topics without a token = %d", status)
+	}
+	if status := request(t, "POST", server.URL+"/topics", `{"name":"orders"}`, true, nil); status != http.StatusCreated {
+		t.Fatalf("POST /topics = %d", status)
+	}
+
+	sub, pub := dialServer(t, server), dialServer(t, server)
+	sub.WriteJSON(map[string]interface{}{"type": "subscribe", "topic": "orders", "request_id": "s-1"})
+	expectFrame(t, sub, "ack")
+
+	id := uuid.New().String()
+	pub.WriteJSON(map[string]interface{}{
+		"type":       "publish",
+		"topic":      "orders",
+		"request_id": "p-1",
+		"message":    map[string]interface{}{"id": id, "payload": map[string]interface{}{"total": 42}},
+	})
+	expectFrame(t, pub, "ack")
+
+	event := expectFrame(t, sub, "event")
+	message := event["message"].(map[string]interface{})
+	if event["topic"] != "orders" || message["id"] != id || message["payload"].(map[string]interface{})["total"] != 42.0 {
+		t.Errorf("event = %v", event)
+	}
+
+	// The message is in the topic's history over REST
+	var page pubsub.TopicMessagesResponse
+	if status := request(t, "GET", server.URL+"/topics/orders/messages", "", false, &page); status != http.StatusOK || len(page.Messages) != 1 || page.Messages[0].Message.ID != id {
+		t.Errorf("GET /topics/orders/messages = %d %+v", status, page)
+	}
+
+	var health pubsub.HealthResponse
+	if status := request(t, "GET", server.URL+"/health", "", false, &health); status != http.StatusOK || health.Topics != 1 || health.Subscribers != 1 {
+		t.Errorf("GET /health = %d %+v", status, health)
+	}
+	if status := request(t, "GET", server.URL+"/stats", "", true, nil); status != http.StatusOK {
+		t.Errorf("GET /stats = %d", status)
+	}
+}
+
+func TestComposedServerCORS(t *testing.T) {
+	server, _ := composeServerWithAuth(t, httpapi.AdminAuth{Token: testAdminToken}, ws.NewOriginPolicy([]string{"https://app.example.com"}, false), false)
+
+	for origin, want := range map[string]string{
+		"https://app.example.com": "https://app.example.com",
+		"https://evil.test":       "",
+	} {
+		req, _ := http.NewRequest("GET", server.URL+"/topics", nil)
+		req.Header.Set("Origin", origin)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != want {
+			t.Errorf("Access-Control-Allow-Origin for %q = %q, want %q", origin, got, want)
+		}
+		if !strings.Contains(resp.Header.Get("Access-Control-Allow-Headers"), httpapi.PollTokenHeader) {
+			t.Errorf("CORS doesn't allow the poll token header: %q", resp.Header.Get("Access-Control-Allow-Headers"))
+		}
+	}
+
+	// Browsers from other origins can't open websockets either
+	header := http.Header{"Origin": []string{"https://evil.test"}}
+	if _, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", header); err == nil {
+		t.Error("websocket from another origin was accepted")
+	}
+}
+
+func TestComposedServerSeparateAdmin(t *testing.T) {
+	server, admin := composeServer(t, true)
+	if admin == nil {
+		t.Fatal("no admin router")
+	}
+
+	// Admin routes are only on the admin server, still behind the token
+	if status := request(t, "POST", server.URL+"/topics", `{"name":"orders"}`, true, nil); status == http.StatusCreated {
+		t.Error("public server created a topic")
+	}
+	if status := request(t, "POST", admin.URL+"/topics", `{"name":"orders"}`, false, nil); status != http.StatusUnauthorized {
+		t.Errorf("admin POST /topics without a token = %d", status)
+	}
+	if status := request(t, "POST", admin.URL+"/topics", `{"name":"orders"}`, true, nil); status != http.StatusCreated {
+		t.Errorf("admin POST /topics = %d", status)
+	}
+
+	// Reads stay public
+	var detail pubsub.TopicDetailResponse
+	if status := request(t, "GET", server.URL+"/topics/orders", "", false, &detail); status != http.StatusOK || detail.Name != "orders" {
+		t.Errorf("GET /topics/orders = %d %+v", status, detail)
+	}
+}
+
+// adminRoutes are a sample of the admin routes. With the token, each
+// succeeds once topic orders exists.
+var adminRoutes = []struct{ method, path, body string }{
+	{"POST", "/topics", `{"name":"audit"}`},
+	{"GET", "/stats", ""},
+	{"GET", "/subscriptions", ""},
+	{"POST", "/admin/snapshot", ""},
+	{"DELETE", "/topics/orders", ""},
+}
+
+func TestAdminRoutesNeedCredentials(t *testing.T) {
+	for _, separateAdmin := range []bool{false, true} {
+		server, admin := composeServer(t, separateAdmin)
+		adminURL := server.URL
+		if separateAdmin {
+			adminURL = admin.URL
+		}
+		if status := request(t, "POST", adminURL+"/topics", `{"name":"orders"}`, true, nil); status != http.StatusCreated {
+			t.Fatalf("POST /topics = %d", status)
+		}
+		for _, route := range adminRoutes {
+			if status := request(t, route.method, adminURL+route.path, route.body, false, nil); status != http.StatusUnauthorized {
+				t.Errorf("separate admin %v: %s %s without the token = %d", separateAdmin, route.method, route.path, status)
+			}
+			if status := request(t, route.method, adminURL+route.path, route.body, true, nil); status >= 300 {
+				t.Errorf("separate admin %v: %s %s with the token = %d", separateAdmin, route.method, route.path, status)
+			}
+			// The public listener doesn't serve them at all once they
+			// have their own
+			if separateAdmin {
+				if status := request(t, route.method, server.URL+route.path, route.body, true, nil); status != http.StatusNotFound && status != http.StatusMethodNotAllowed {
+					t.Errorf("public %s %s = %d", route.method, route.path, status)
+				}
+			}
+		}
+
+		// The public group needs nothing
+		if status := request(t, "GET", server.URL+"/health", "", false, nil); status != http.StatusOK {
+			t.Errorf("separate admin %v: GET /health = %d", separateAdmin, status)
+		}
+		dialServer(t, server)
+	}
+}
+
+func TestAdminRoutesWithBasicAuth(t *testing.T) {
+	server, _ := composeServerWithAuth(t, httpapi.AdminAuth{Username: "ops", Password: "hunter2"}, ws.NewOriginPolicy(nil, true), false)
+	for _, tc := range []struct {
+		user, pass string
+		status     int
+	}{
+		{"ops", "hunter2", http.StatusOK},
+		{"ops", "wrong", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+	} {
+		req, err := http.NewRequest("GET", server.URL+"/stats", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.user != "" {
+			req.SetBasicAuth(tc.user, tc.pass)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("GET /stats as %q:%q = %d, want %d", tc.user, tc.pass, resp.StatusCode, tc.status)
+		}
+	}
+}
+
+func TestAdminRoutesOpenWithoutCredentials(t *testing.T) {
+	// Kept open for existing deployments, with a warning at startup
+	server, _ := composeServerWithAuth(t, httpapi.AdminAuth{}, ws.NewOriginPolicy(nil, true), false)
+	if status := request(t, "POST", server.URL+"/topics", `{"name":"orders"}`, false, nil); status != http.StatusCreated {
+		t.Errorf("POST /topics with no admin credentials configured = %d", status)
+	}
+	if status := request(t, "GET", server.URL+"/stats", "", false, nil); status != http.StatusOK {
+		t.Errorf("GET /stats with no admin credentials configured = %d", status)
+	}
+
+	// Except snapshots, which aren't served at all
+	for _, path := range []string{"/admin/snapshot", "/admin/restore"} {
+		if status := request(t, "POST", server.URL+path, "", false, nil); status != http.StatusNotFound && status != http.StatusMethodNotAllowed {
+			t.Errorf("POST %s with no admin credentials configured = %d", path, status)
+		}
+	}
+}
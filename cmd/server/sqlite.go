@@ -0,0 +1,6 @@
+//go:build sqlite
+
+package main
+
+// Registers the cgo-free SQLite driver used by SQLITE_PATH
+import _ "modernc.org/sqlite"
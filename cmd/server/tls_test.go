@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+	"github.com/AnshulDekate/pubsub/pkg/transport/httpapi"
+	"github.com/AnshulDekate/pubsub/pkg/transport/ws"
+)
+
+// testCert is a certificate and key generated for a test
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// issueCert creates a certificate for cn signed by parent, or self-signed
+// when parent is nil, in which case it is a CA
+func issueCert(t *testing.T, cn string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// writePEM writes the certificate and key to files in dir
+func (c *testCert) writePEM(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// serveTLS serves the HTTP routes for ps over TLS on a local port the way
+// main does, with websocket options opts, and returns its address
+func serveTLS(t *testing.T, ps *pubsub.PubSubSystem, opts ws.WebSocketOptions, config *tls.Config) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsHandler, err := ws.NewHandler(ps, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers := httpapi.NewHTTPHandlers(ps)
+	handlers.SetWebSocketHandler(wsHandler)
+	limiter := httpapi.NewRequestLimiter(ps, httpapi.RequestLimits{MaxBodyBytes: httpapi.DefaultMaxRequestBodyBytes})
+	router, _ := newRouters(handlers, httpapi.AdminAuth{}, ws.NewOriginPolicy(nil, true), limiter, false)
+	srv := newHTTPServer(router, config)
+	go srv.ServeTLS(ln, "", "")
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+// dialWSS opens a wss:// connection trusting ca, presenting client if set
+func dialWSS(t *testing.T, addr string, ca *testCert, client *testCert) error {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	config := &tls.Config{RootCAs: roots}
+	if client != nil {
+		config.Certificates = []tls.Certificate{client.tlsCertificate()}
+	}
+	dialer := websocket.Dialer{TLSClientConfig: config, HandshakeTimeout: 5 * time.Second}
+	conn, _, err := dialer.Dial("wss://"+addr+"/ws", nil)
+	if err != nil {
+		return err
+	}
+	t.Cleanup(func() { conn.Close() })
+	return nil
+}
+
+func TestServeWSS(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, "test CA", nil)
+	certFile, keyFile := issueCert(t, "localhost", ca).writePEM(t, dir, "server")
+
+	config, err := LoadServerTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("LoadServerTLSConfig: %v", err)
+	}
+	if config.ClientAuth != tls.NoClientCert || config.MinVersion < tls.VersionTLS12 {
+		t.Errorf("config = client auth %v, min version %x", config.ClientAuth, config.MinVersion)
+	}
+	ps := pubsub.New()
+	addr := serveTLS(t, ps, ws.WebSocketOptions{}, config)
+
+	if err := dialWSS(t, addr, ca, nil); err != nil {
+		t.Errorf("wss:// without mutual TLS: %v", err)
+	}
+	waitFor(t, "the wss:// client to register", func() bool { return ps.GetHealth().Connections == 1 })
+	// Plain HTTP on the TLS port is refused
+	if resp, err := http.Get("http://" + addr + "/"); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("plain HTTP got %d", resp.StatusCode)
+		}
+	}
+
+	if _, err := LoadServerTLSConfig(certFile, filepath.Join(dir, "missing.key"), ""); err == nil {
+		t.Error("loaded a certificate without its key")
+	}
+	if _, err := LoadServerTLSConfig(certFile, keyFile, keyFile); err == nil || !strings.Contains(err.Error(), "no certificates") {
+		t.Errorf("CA bundle without certificates = %v", err)
+	}
+}
+
+func TestServeMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, "test CA", nil)
+	certFile, keyFile := issueCert(t, "localhost", ca).writePEM(t, dir, "server")
+	caFile, _ := ca.writePEM(t, dir, "ca")
+
+	config, err := LoadServerTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("LoadServerTLSConfig: %v", err)
+	}
+	ps := pubsub.New()
+	addr := serveTLS(t, ps, ws.WebSocketOptions{ClientIDFromCert: true}, config)
+
+	// A client certificate from the CA is accepted, and its CN is the
+	// client_id
+	if err := dialWSS(t, addr, ca, issueCert(t, "device-42", ca)); err != nil {
+		t.Fatalf("client with a certificate: %v", err)
+	}
+	waitFor(t, "device-42 to register", func() bool {
+		_, ok := ps.RegisterClientIfAbsent(&probeClient{id: "device-42"})
+		return !ok
+	})
+
+	// No certificate, or one from another CA, is refused in the handshake
+	if err := dialWSS(t, addr, ca, nil); err == nil {
+		t.Error("client without a certificate was accepted")
+	}
+	stranger := issueCert(t, "other CA", nil)
+	if err := dialWSS(t, addr, ca, issueCert(t, "device-42", stranger)); err == nil {
+		t.Error("client with a certificate from another CA was accepted")
+	}
+}
@@ -1,80 +0,0 @@
-package main
-
-import (
-	"log"
-	"net/http"
-	"os"
-	"os/signal"
-	"syscall"
-
-	"github.com/gorilla/mux"
-)
-
-func main() {
-	// Create the pub-sub system
-	pubsub := NewPubSubSystem()
-
-	// Create HTTP handlers
-	handlers := NewHTTPHandlers(pubsub)
-
-	// Create router and setup routes
-	router := mux.NewRouter()
-	handlers.SetupRoutes(router)
-
-	// Add CORS middleware for development
-	router.Use(corsMiddleware)
-
-	// Add logging middleware
-	router.Use(loggingMiddleware)
-
-	// Start server
-	port := getEnvOrDefault("PORT", "9090")
-	log.Printf("Starting chat room server on port %s", port)
-	log.Printf("WebSocket endpoint: ws://localhost:%s/ws", port)
-	log.Printf("HTTP API available at: http://localhost:%s", port)
-
-	// Handle graceful shutdown
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-
-	go func() {
-		<-c
-		log.Println("Shutting down server...")
-		os.Exit(0)
-	}()
-
-	// Start the HTTP server
-	log.Fatal(http.ListenAndServe(":"+port, router))
-}
-
-// corsMiddleware adds CORS headers for development
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
-
-// loggingMiddleware logs HTTP requests
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("%s %s %s", r.Method, r.RequestURI, r.RemoteAddr)
-		next.ServeHTTP(w, r)
-	})
-}
-
-// getEnvOrDefault returns environment variable value or default
-func getEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
@@ -0,0 +1,55 @@
+// Package clock abstracts the time source the broker reads and waits on,
+// so TTLs, retention, rate limits, keepalives and scheduled delivery can be
+// driven by hand in tests. Real is the wall clock; package clocktest has a
+// fake one.
+package clock
+
+import "time"
+
+// Clock tells the time and makes timers that follow it
+type Clock interface {
+	Now() time.Time
+
+	// NewTimer, NewTicker and AfterFunc behave like their time package
+	// namesakes, on this clock's time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a *time.Timer made by a Clock. C is nil for AfterFunc timers.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a *time.Ticker made by a Clock
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+// Real is the wall clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
@@ -0,0 +1,193 @@
+// Package clocktest provides a fake clock.Clock for deterministic tests of
+// code built on the broker, such as pubsub.NewWithClock(clocktest.NewFake(start)).
+package clocktest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AnshulDekate/pubsub/pkg/clock"
+)
+
+// Fake is a clock that only moves when told to. Timers and tickers fire,
+// in deadline order, as Advance passes their deadlines; AfterFunc
+// functions run on their own goroutines, as with the time package.
+type Fake struct {
+	mutex   sync.Mutex
+	now     time.Time
+	pending []*fakeTimer // Armed timers and tickers
+}
+
+var _ clock.Clock = (*Fake)(nil)
+
+// NewFake returns a fake clock reading start
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+// Now returns the fake time
+func (f *Fake) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+// Advance moves the clock on by d, firing every timer and tick that falls
+// due on the way, and returns the new time
+func (f *Fake) Advance(d time.Duration) time.Time {
+	f.mutex.Lock()
+	target := f.now.Add(d)
+	for {
+		next := f.nextDue(target)
+		if next == nil {
+			break
+		}
+		f.now = next.at
+		f.fire(next)
+	}
+	f.now = target
+	f.mutex.Unlock()
+	return target
+}
+
+// Timers returns how many timers and tickers are armed, so a test can wait
+// for a goroutine to start waiting before advancing past its deadline
+func (f *Fake) Timers() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.pending)
+}
+
+// NewTimer returns a timer that fires once the clock passes d from now
+func (f *Fake) NewTimer(d time.Duration) clock.Timer {
+	t := &fakeTimer{clock: f, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// AfterFunc runs fn on its own goroutine once the clock passes d from now
+func (f *Fake) AfterFunc(d time.Duration, fn func()) clock.Timer {
+	t := &fakeTimer{clock: f, fn: fn}
+	t.Reset(d)
+	return t
+}
+
+// NewTicker returns a ticker that ticks every d of fake time. Like a real
+// one it drops ticks its reader isn't ready for.
+func (f *Fake) NewTicker(d time.Duration) clock.Ticker {
+	if d <= 0 {
+		panic("clocktest: non-positive interval for NewTicker")
+	}
+	t := &fakeTimer{clock: f, c: make(chan time.Time, 1), period: d}
+	t.Reset(d)
+	return fakeTicker{t}
+}
+
+// nextDue returns the armed timer with the earliest deadline at or before
+// target, or nil. Callers must hold the mutex.
+func (f *Fake) nextDue(target time.Time) *fakeTimer {
+	if len(f.pending) == 0 {
+		return nil
+	}
+	sort.SliceStable(f.pending, func(i, j int) bool { return f.pending[i].at.Before(f.pending[j].at) })
+	if next := f.pending[0]; !next.at.After(target) {
+		return next
+	}
+	return nil
+}
+
+// fire delivers a due timer, re-arming tickers for their next tick.
+// Callers must hold the mutex.
+func (f *Fake) fire(t *fakeTimer) {
+	if t.period > 0 {
+		t.at = t.at.Add(t.period)
+	} else {
+		f.disarm(t)
+	}
+	if t.fn != nil {
+		go t.fn()
+		return
+	}
+	select {
+	case t.c <- f.now:
+	default:
+	}
+}
+
+// arm schedules t for d from now, firing it at once when d isn't positive.
+// Callers must hold the mutex.
+func (f *Fake) arm(t *fakeTimer, d time.Duration) {
+	t.at = f.now.Add(d)
+	if !t.armed() {
+		f.pending = append(f.pending, t)
+	}
+	if d <= 0 {
+		f.fire(t)
+	}
+}
+
+// disarm unschedules t, reporting whether it was armed. Callers must hold
+// the mutex.
+func (f *Fake) disarm(t *fakeTimer) bool {
+	for i, pending := range f.pending {
+		if pending == t {
+			f.pending = append(f.pending[:i], f.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fakeTimer is a Fake's timer, AfterFunc timer or ticker
+type fakeTimer struct {
+	clock  *Fake
+	c      chan time.Time
+	fn     func()
+	period time.Duration // Set on tickers
+	at     time.Time     // Next deadline; guarded by the clock's mutex
+}
+
+// armed reports whether t is scheduled. Callers must hold the clock's mutex.
+func (t *fakeTimer) armed() bool {
+	for _, pending := range t.clock.pending {
+		if pending == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+// Stop disarms the timer, reporting whether it was armed
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	return t.clock.disarm(t)
+}
+
+// Reset re-arms the timer for d from now, reporting whether it was armed.
+// A ticker's interval becomes d.
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	wasArmed := t.armed()
+	if t.period > 0 {
+		t.period = d
+	}
+	t.clock.arm(t, d)
+	return wasArmed
+}
+
+// fakeTicker is a ticker's view of its fakeTimer
+type fakeTicker struct{ t *fakeTimer }
+
+func (t fakeTicker) C() <-chan time.Time { return t.t.c }
+func (t fakeTicker) Stop()               { t.t.Stop() }
+func (t fakeTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("clocktest: non-positive interval for Ticker.Reset")
+	}
+	t.t.Reset(d)
+}
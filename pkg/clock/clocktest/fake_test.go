@@ -0,0 +1,86 @@
+package clocktest
+
+import (
+	"testing"
+	"time"
+)
+
+var start = time.Date(2025, 8, 25, 10, 0, 0, 0, time.UTC)
+
+// fired reports whether c has a value ready
+func fired(c <-chan time.Time) (time.Time, bool) {
+	select {
+	case at := <-c:
+		return at, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestFakeTimers(t *testing.T) {
+	clock := NewFake(start)
+	timer := clock.NewTimer(time.Minute)
+	stopped := clock.NewTimer(time.Minute)
+	if !stopped.Stop() || stopped.Stop() {
+		t.Error("Stop should report the timer armed only the first time")
+	}
+
+	clock.Advance(59 * time.Second)
+	if _, ok := fired(timer.C()); ok {
+		t.Fatal("timer fired early")
+	}
+	if now := clock.Advance(2 * time.Second); !now.Equal(start.Add(61 * time.Second)) {
+		t.Errorf("Advance returned %s", now)
+	}
+	// It fires at its deadline, not at the end of the advance
+	if at, ok := fired(timer.C()); !ok || !at.Equal(start.Add(time.Minute)) {
+		t.Errorf("timer fired %v at %s", ok, at)
+	}
+	if _, ok := fired(stopped.C()); ok {
+		t.Error("stopped timer fired")
+	}
+
+	// Reset re-arms a fired timer; zero fires at once
+	if timer.Reset(0) {
+		t.Error("Reset reported a fired timer armed")
+	}
+	if _, ok := fired(timer.C()); !ok {
+		t.Error("timer reset to zero didn't fire")
+	}
+	if clock.Timers() != 0 {
+		t.Errorf("%d timers armed, want 0", clock.Timers())
+	}
+}
+
+func TestFakeTickerAndAfterFunc(t *testing.T) {
+	clock := NewFake(start)
+	ticker := clock.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	ran := make(chan time.Time, 1)
+	clock.AfterFunc(25*time.Second, func() { ran <- clock.Now() })
+
+	var ticks []time.Time
+	for i := 0; i < 3; i++ {
+		clock.Advance(10 * time.Second)
+		if at, ok := fired(ticker.C()); ok {
+			ticks = append(ticks, at)
+		}
+	}
+	if len(ticks) != 3 || !ticks[2].Equal(start.Add(30*time.Second)) {
+		t.Errorf("ticks = %v", ticks)
+	}
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("AfterFunc never ran")
+	}
+
+	// Ticks nobody reads are dropped, as with a real ticker
+	clock.Advance(time.Minute)
+	if _, ok := fired(ticker.C()); !ok {
+		t.Error("no tick after a long advance")
+	}
+	if _, ok := fired(ticker.C()); ok {
+		t.Error("ticks were queued")
+	}
+}
@@ -0,0 +1,380 @@
+// Package kafkaexport mirrors the events of selected topics into Kafka for
+// long-term retention. Events are queued as they are published and handed
+// to a Producer by a single background worker, so publishing never waits on
+// Kafka; when the queue is full, events are dropped and counted instead.
+//
+// The package is the broker's only Kafka dependency; programs that don't
+// import it don't link the Kafka client.
+package kafkaexport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// Defaults for the zero values in Options
+const (
+	DefaultName       = "kafka"
+	DefaultQueueSize  = 4096
+	DefaultBatchSize  = 100
+	DefaultMinBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff = 10 * time.Second
+)
+
+// Record is one Kafka message
+type Record struct {
+	Topic string
+	Key   []byte
+	Value []byte
+}
+
+// Producer writes records to Kafka. NewProducer makes real ones.
+type Producer interface {
+	// Produce writes records in order, returning once Kafka has them all
+	// or with the error that stopped it
+	Produce(ctx context.Context, records []Record) error
+	Close()
+}
+
+// Route exports the events of local topics matching Pattern to the Kafka
+// topic KafkaTopic. Patterns match internal topic names, ns::name for
+// namespaced topics, and '*' in one matches any run of characters.
+type Route struct {
+	Pattern    string
+	KafkaTopic string
+}
+
+// ParseRoutes parses comma-separated pattern=kafka-topic pairs, such as
+// "orders.*=archive-orders,audit=archive-audit"
+func ParseRoutes(spec string) ([]Route, error) {
+	var routes []Route
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		pattern, kafkaTopic, found := strings.Cut(pair, "=")
+		pattern, kafkaTopic = strings.TrimSpace(pattern), strings.TrimSpace(kafkaTopic)
+		if !found || pattern == "" || kafkaTopic == "" {
+			return nil, fmt.Errorf("kafkaexport: route %q is not pattern=kafka-topic", pair)
+		}
+		routes = append(routes, Route{Pattern: pattern, KafkaTopic: kafkaTopic})
+	}
+	return routes, nil
+}
+
+// match reports whether topic matches pattern, where '*' matches any run
+// of characters
+func match(pattern, topic string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == topic
+	}
+	if !strings.HasPrefix(topic, parts[0]) {
+		return false
+	}
+	rest := topic[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	return strings.HasSuffix(rest, parts[len(parts)-1])
+}
+
+// Options configures an Exporter
+type Options struct {
+	// Name the exporter is reported under in /health
+	Name string
+
+	// The first route matching a topic decides where its events go; topics
+	// matching none aren't exported
+	Routes []Route
+
+	// Events queued for Kafka before new ones are dropped
+	QueueSize int
+
+	// Most events handed to the producer at once
+	BatchSize int
+
+	// Delays between attempts at a failed batch grow from MinBackoff to
+	// MaxBackoff, with jitter
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// queuedEvent is an event waiting for export
+type queuedEvent struct {
+	kafkaTopic string
+	event      pubsub.EventResponse
+}
+
+// Exporter copies published events to Kafka. Batches are retried until
+// they succeed, so events reach Kafka in the order they were published,
+// at least once.
+type Exporter struct {
+	ps       *pubsub.PubSubSystem
+	producer Producer
+	opts     Options
+	tapID    string
+
+	queue   chan queuedEvent
+	closing chan struct{} // Closed by Close; no more events are queued
+	abort   context.Context
+	stop    context.CancelFunc // Gives up on what is still queued
+	done    chan struct{}
+
+	closeOnce   sync.Once
+	exported    atomic.Int64
+	dropped     atomic.Int64
+	overflowing atomic.Bool
+}
+
+// Start exports the events of ps's topics matching opts.Routes through
+// producer, which the exporter closes when it is closed
+func Start(ps *pubsub.PubSubSystem, producer Producer, opts Options) (*Exporter, error) {
+	if len(opts.Routes) == 0 {
+		return nil, errors.New("kafkaexport: no routes")
+	}
+	if opts.Name == "" {
+		opts.Name = DefaultName
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultMinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = max(DefaultMaxBackoff, opts.MinBackoff)
+	}
+
+	abort, stop := context.WithCancel(context.Background())
+	e := &Exporter{
+		ps:       ps,
+		producer: producer,
+		opts:     opts,
+		tapID:    "kafka-export-" + uuid.NewString(),
+		queue:    make(chan queuedEvent, opts.QueueSize),
+		closing:  make(chan struct{}),
+		abort:    abort,
+		stop:     stop,
+		done:     make(chan struct{}),
+	}
+	ps.AddExporter(opts.Name, e.Status)
+	ps.SubscribeFirehose(e.tapID, tap{e})
+	go e.run()
+	return e, nil
+}
+
+// Close stops exporting new events and waits until those already queued
+// are exported or ctx is done, when the rest are dropped. It then closes
+// the producer.
+func (e *Exporter) Close(ctx context.Context) {
+	e.closeOnce.Do(func() {
+		e.ps.UnsubscribeFirehose(e.tapID)
+		close(e.closing)
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			e.stop()
+			<-e.done
+		}
+		e.stop()
+		e.ps.RemoveExporter(e.opts.Name)
+		e.producer.Close()
+	})
+}
+
+// Status reports the queue for /health
+func (e *Exporter) Status() pubsub.StoreStatus {
+	return pubsub.StoreStatus{
+		Queued:      len(e.queue),
+		Capacity:    cap(e.queue),
+		Overflowing: e.overflowing.Load(),
+		Dropped:     e.dropped.Load(),
+	}
+}
+
+// Exported returns the number of events Kafka has accepted
+func (e *Exporter) Exported() int64 {
+	return e.exported.Load()
+}
+
+// run exports queued events in batches until the exporter is closed and
+// its queue is empty
+func (e *Exporter) run() {
+	defer close(e.done)
+	for {
+		select {
+		case first := <-e.queue:
+			if !e.export(e.batch(first)) {
+				e.dropQueued()
+				return
+			}
+		case <-e.closing:
+			for {
+				select {
+				case first := <-e.queue:
+					if !e.export(e.batch(first)) {
+						e.dropQueued()
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// batch returns first with the events queued behind it, up to BatchSize
+func (e *Exporter) batch(first queuedEvent) []queuedEvent {
+	batch := []queuedEvent{first}
+	for len(batch) < e.opts.BatchSize {
+		select {
+		case next := <-e.queue:
+			batch = append(batch, next)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// export hands a batch to the producer, retrying with backoff until it
+// succeeds. It returns false, having dropped the batch, if the exporter
+// gives up first.
+func (e *Exporter) export(batch []queuedEvent) bool {
+	records := make([]Record, 0, len(batch))
+	for _, queued := range batch {
+		value, err := json.Marshal(queued.event)
+		if err != nil {
+			e.dropped.Add(1)
+			log.Printf("Kafka export %s: dropping event %s: %v", e.opts.Name, queued.event.Message.ID, err)
+			continue
+		}
+		records = append(records, Record{Topic: queued.kafkaTopic, Key: []byte(queued.event.Message.ID), Value: value})
+	}
+	if len(records) == 0 {
+		return true
+	}
+
+	backoff := e.opts.MinBackoff
+	for attempt := 1; ; attempt++ {
+		err := e.producer.Produce(e.abort, records)
+		if err == nil {
+			e.exported.Add(int64(len(records)))
+			if len(e.queue) <= cap(e.queue)/2 {
+				e.overflowing.Store(false)
+			}
+			return true
+		}
+		if e.abort.Err() != nil {
+			e.dropped.Add(int64(len(records)))
+			return false
+		}
+		log.Printf("Kafka export %s: producing %d records failed (attempt %d): %v", e.opts.Name, len(records), attempt, err)
+
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-time.After(wait):
+		case <-e.abort.Done():
+			e.dropped.Add(int64(len(records)))
+			return false
+		}
+		backoff = min(2*backoff, e.opts.MaxBackoff)
+	}
+}
+
+// dropQueued counts what is left in the queue as dropped
+func (e *Exporter) dropQueued() {
+	for {
+		select {
+		case <-e.queue:
+			e.dropped.Add(1)
+		default:
+			return
+		}
+	}
+}
+
+// route returns the Kafka topic for a local topic, or "" if it isn't
+// exported
+func (e *Exporter) route(topic string) string {
+	for _, route := range e.opts.Routes {
+		if match(route.Pattern, topic) {
+			return route.KafkaTopic
+		}
+	}
+	return ""
+}
+
+// tap receives a copy of every local event from the firehose and queues
+// those the routes select
+type tap struct{ e *Exporter }
+
+func (t tap) GetClientID() string {
+	return t.e.tapID
+}
+
+func (t tap) IsConnected() bool {
+	select {
+	case <-t.e.closing:
+		return false
+	default:
+		return true
+	}
+}
+
+func (t tap) GetLastActive() time.Time {
+	return time.Now()
+}
+
+// SendMessage queues an event for export. It runs under the topic's lock,
+// so it never blocks and leaves encoding to the worker.
+func (t tap) SendMessage(msg interface{}) error {
+	prepared, ok := msg.(*pubsub.PreparedEvent)
+	if !ok {
+		return nil
+	}
+	event := prepared.Event
+	topic, err := pubsub.QualifyTopic(event.Namespace, event.Topic)
+	if err != nil || pubsub.IsSystemTopic(topic) {
+		return nil
+	}
+	kafkaTopic := t.e.route(topic)
+	if kafkaTopic == "" {
+		return nil
+	}
+
+	// Exported as subscribers see it, with the namespace the firehose adds
+	event.Type = "event"
+	select {
+	case t.e.queue <- queuedEvent{kafkaTopic: kafkaTopic, event: event}:
+		return nil
+	default:
+		t.e.dropped.Add(1)
+		if !t.e.overflowing.Swap(true) {
+			log.Printf("Kafka export %s: queue is full (%d events); dropping events until it drains", t.e.opts.Name, cap(t.e.queue))
+		}
+		return pubsub.ErrorData{Code: pubsub.CodeServerBusy, Reason: "CLIENT_OVERLOADED", Message: "Kafka export queue is full"}
+	}
+}
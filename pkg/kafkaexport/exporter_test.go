@@ -0,0 +1,240 @@
+package kafkaexport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// mockProducer records what it is asked to produce. It fails while
+// failures is positive, and each call waits for gate when one is set.
+type mockProducer struct {
+	mutex    sync.Mutex
+	records  []Record
+	calls    int
+	failures int
+	gate     chan struct{}
+	closed   bool
+}
+
+func (p *mockProducer) Produce(ctx context.Context, records []Record) error {
+	if p.gate != nil {
+		select {
+		case <-p.gate:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.calls++
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("broker not available")
+	}
+	p.records = append(p.records, records...)
+	return nil
+}
+
+func (p *mockProducer) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.closed = true
+}
+
+func (p *mockProducer) produced() []Record {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]Record(nil), p.records...)
+}
+
+func newSystem(t *testing.T, topics ...string) *pubsub.PubSubSystem {
+	t.Helper()
+	ps := pubsub.New()
+	t.Cleanup(ps.Close)
+	for _, topic := range topics {
+		if err := ps.CreateTopic(context.Background(), topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ps
+}
+
+func publish(t *testing.T, ps *pubsub.PubSubSystem, topic string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := ps.Publish(context.Background(), topic, pubsub.MessageData{ID: fmt.Sprintf("%s-%d", topic, i), Payload: i}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestExportKeepsTopicOrder(t *testing.T) {
+	ps := newSystem(t, "orders.eu", "orders.us", "chat")
+	producer := &mockProducer{}
+	exporter, err := Start(ps, producer, Options{
+		Routes:    []Route{{Pattern: "orders.eu", KafkaTopic: "eu"}, {Pattern: "orders.*", KafkaTopic: "orders"}},
+		BatchSize: 7,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for _, topic := range []string{"orders.eu", "orders.us", "chat"} {
+		wg.Add(1)
+		go func(topic string) {
+			defer wg.Done()
+			publish(t, ps, topic, 100)
+		}(topic)
+	}
+	wg.Wait()
+	exporter.Close(context.Background())
+
+	records := producer.produced()
+	if len(records) != 200 || !producer.closed {
+		t.Fatalf("produced %d records, closed %v", len(records), producer.closed)
+	}
+	next := map[string]int64{"orders.eu": 1, "orders.us": 1}
+	for _, record := range records {
+		var event pubsub.EventResponse
+		if err := json.Unmarshal(record.Value, &event); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{"orders.eu": "eu", "orders.us": "orders"}[event.Topic]
+		if record.Topic != want || string(record.Key) != event.Message.ID || event.Type != "event" {
+			t.Fatalf("record for %s went to %s with key %s: %+v", event.Topic, record.Topic, record.Key, event)
+		}
+		if event.Seq != next[event.Topic] {
+			t.Fatalf("%s: exported seq %d, want %d", event.Topic, event.Seq, next[event.Topic])
+		}
+		next[event.Topic]++
+	}
+}
+
+func TestExportRetriesFailedBatches(t *testing.T) {
+	ps := newSystem(t, "audit")
+	producer := &mockProducer{failures: 3}
+	exporter, err := Start(ps, producer, Options{Routes: []Route{{Pattern: "audit", KafkaTopic: "audit"}}, MinBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { exporter.Close(context.Background()) })
+
+	publish(t, ps, "audit", 5)
+	waitFor(t, func() bool { return exporter.Exported() == 5 })
+	records := producer.produced()
+	for i, record := range records {
+		if want := fmt.Sprintf("audit-%d", i); string(record.Key) != want {
+			t.Errorf("record %d has key %s, want %s", i, record.Key, want)
+		}
+	}
+	if status := exporter.Status(); status.Dropped != 0 || producer.calls < 4 {
+		t.Errorf("status = %+v after %d calls", status, producer.calls)
+	}
+}
+
+func TestExportOverflowIsCountedNotBlocking(t *testing.T) {
+	ps := newSystem(t, "audit")
+	producer := &mockProducer{gate: make(chan struct{})}
+	exporter, err := Start(ps, producer, Options{Routes: []Route{{Pattern: "*", KafkaTopic: "all"}}, QueueSize: 4, BatchSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { exporter.Close(context.Background()) })
+
+	// The first event is taken by the worker, which waits on Kafka; four
+	// more fill the queue and the rest are dropped without holding up
+	// the publisher
+	publish(t, ps, "audit", 1)
+	waitFor(t, func() bool { return exporter.Status().Queued == 0 })
+	publish(t, ps, "audit", 19)
+	status := exporter.Status()
+	if status.Queued != 4 || status.Dropped != 15 || !status.Overflowing {
+		t.Fatalf("status = %+v", status)
+	}
+	health := ps.GetHealth()
+	if health.Status != "degraded" || !health.Exporters["kafka"].Overflowing {
+		t.Errorf("health = %+v", health)
+	}
+
+	// Draining clears the flag
+	close(producer.gate)
+	waitFor(t, func() bool { return exporter.Exported() == 5 })
+	if status := exporter.Status(); status.Overflowing || status.Dropped != 15 {
+		t.Errorf("status after draining = %+v", status)
+	}
+	if health := ps.GetHealth(); health.Status != "ok" {
+		t.Errorf("health after draining = %+v", health)
+	}
+}
+
+func TestCloseDropsWhatItCantExport(t *testing.T) {
+	ps := newSystem(t, "audit")
+	producer := &mockProducer{gate: make(chan struct{})}
+	exporter, err := Start(ps, producer, Options{Routes: []Route{{Pattern: "audit", KafkaTopic: "audit"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, ps, "audit", 3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	exporter.Close(ctx)
+	if status := exporter.Status(); status.Dropped != 3 || status.Queued != 0 || !producer.closed {
+		t.Errorf("status after close = %+v", status)
+	}
+	if _, exists := ps.GetHealth().Exporters["kafka"]; exists {
+		t.Error("closed exporter still reported in /health")
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	routes, err := ParseRoutes(" orders.*=archive-orders, tenant::*.audit=audit ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Route{{"orders.*", "archive-orders"}, {"tenant::*.audit", "audit"}}
+	if fmt.Sprint(routes) != fmt.Sprint(want) {
+		t.Errorf("routes = %v, want %v", routes, want)
+	}
+	if _, err := ParseRoutes("orders"); err == nil {
+		t.Error("route without a Kafka topic was accepted")
+	}
+
+	for _, tc := range []struct {
+		pattern, topic string
+		match          bool
+	}{
+		{"orders", "orders", true},
+		{"orders", "orders.eu", false},
+		{"orders.*", "orders.eu", true},
+		{"orders.*", "orders", false},
+		{"*.audit", "billing.audit", true},
+		{"a*b*c", "abc", true},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*bc*c", "abc", false},
+		{"*", "anything", true},
+	} {
+		if got := match(tc.pattern, tc.topic); got != tc.match {
+			t.Errorf("match(%q, %q) = %v", tc.pattern, tc.topic, got)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package kafkaexport
+
+import (
+	"context"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// NewProducer returns a Producer writing to the Kafka cluster reachable
+// through the seed brokers, with franz-go. options are passed to the client
+// after the seed brokers.
+func NewProducer(brokers []string, options ...kgo.Opt) (Producer, error) {
+	client, err := kgo.NewClient(append([]kgo.Opt{kgo.SeedBrokers(brokers...)}, options...)...)
+	if err != nil {
+		return nil, err
+	}
+	return franzProducer{client: client}, nil
+}
+
+// franzProducer is a Producer over a franz-go client
+type franzProducer struct {
+	client *kgo.Client
+}
+
+func (p franzProducer) Produce(ctx context.Context, records []Record) error {
+	batch := make([]*kgo.Record, len(records))
+	for i, record := range records {
+		batch[i] = &kgo.Record{Topic: record.Topic, Key: record.Key, Value: record.Value}
+	}
+	return p.client.ProduceSync(ctx, batch...).FirstErr()
+}
+
+func (p franzProducer) Close() {
+	p.client.Close()
+}
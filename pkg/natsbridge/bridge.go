@@ -0,0 +1,409 @@
+// Package natsbridge links a PubSubSystem to NATS, so the broker can serve
+// websocket clients at the edge of an existing NATS deployment. Every event
+// published locally is forwarded to the NATS subject prefix+topic, and
+// messages published on NATS under the prefix are published to the
+// matching local topics. Each bridged message carries OriginHeader, and
+// messages that already carry it are never bridged again, so nothing loops
+// between the two.
+//
+// The package is the broker's only NATS dependency; programs that don't
+// import it don't link the NATS client.
+package natsbridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// Defaults for the zero values in Options
+const (
+	DefaultName          = "nats"
+	DefaultSubjectPrefix = "pubsub."
+	DefaultQueueSize     = 1024
+	DefaultMinBackoff    = 100 * time.Millisecond
+	DefaultMaxBackoff    = 30 * time.Second
+)
+
+const (
+	// OriginHeader holds the ID of the bridge a message first crossed, on
+	// NATS messages and in the headers of events published locally
+	OriginHeader = "Pubsub-Origin"
+
+	// MessageIDHeader carries an event's message ID to NATS, and names the
+	// local message ID of a NATS message
+	MessageIDHeader = "Pubsub-Message-Id"
+
+	// SenderID is the sender of events published from NATS
+	SenderID = "nats-bridge"
+)
+
+// Message is a message on NATS
+type Message struct {
+	Subject string
+	Header  map[string]string
+	Data    []byte
+}
+
+// Conn is a connection to NATS. Dial makes real ones.
+type Conn interface {
+	Publish(msg Message) error
+
+	// Subscribe passes the messages on subject to handle, one at a time
+	Subscribe(subject string, handle func(Message)) error
+
+	// Done is closed once the connection is lost or closed, and Err then
+	// says why
+	Done() <-chan struct{}
+	Err() error
+
+	Close()
+}
+
+// Dialer opens a connection to NATS
+type Dialer func(ctx context.Context) (Conn, error)
+
+// Options configures a Bridge
+type Options struct {
+	// Name the bridge is reported under in /health
+	Name string
+
+	// Local topic T is bridged to the NATS subject SubjectPrefix+T. A
+	// prefix that doesn't end in '.' gets one.
+	SubjectPrefix string
+
+	// Create local topics for NATS subjects that have none; otherwise
+	// their messages are dropped
+	AutoCreate bool
+
+	// Local events held for NATS, e.g. while reconnecting, before new ones
+	// are dropped
+	QueueSize int
+
+	// Reconnect delays grow from MinBackoff to MaxBackoff, with jitter
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Bridge forwards events between a PubSubSystem and NATS, reconnecting
+// whenever the NATS connection is lost
+type Bridge struct {
+	ps   *pubsub.PubSubSystem
+	dial Dialer
+	opts Options
+	id   string // Origin of the messages this bridge sends
+
+	queue  chan pubsub.EventResponse
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mutex     sync.Mutex
+	connected bool
+	lastError string
+
+	reconnects atomic.Int64
+	forwarded  atomic.Int64
+	injected   atomic.Int64
+	dropped    atomic.Int64
+}
+
+// Start bridges ps to the NATS connections dial opens. It returns at once
+// and connects in the background; Status and ps's /health report whether
+// the bridge is connected.
+func Start(ps *pubsub.PubSubSystem, dial Dialer, opts Options) *Bridge {
+	if opts.Name == "" {
+		opts.Name = DefaultName
+	}
+	if opts.SubjectPrefix == "" {
+		opts.SubjectPrefix = DefaultSubjectPrefix
+	}
+	if !strings.HasSuffix(opts.SubjectPrefix, ".") {
+		opts.SubjectPrefix += "."
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultMinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = max(DefaultMaxBackoff, opts.MinBackoff)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Bridge{
+		ps:     ps,
+		dial:   dial,
+		opts:   opts,
+		id:     uuid.NewString(),
+		queue:  make(chan pubsub.EventResponse, opts.QueueSize),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	ps.AddBridge(opts.Name, b.Status)
+	ps.SubscribeFirehose(b.tapID(), tap{b})
+	go b.run()
+	return b
+}
+
+// Close disconnects from NATS and stops bridging
+func (b *Bridge) Close() {
+	b.ps.UnsubscribeFirehose(b.tapID())
+	b.ps.RemoveBridge(b.opts.Name)
+	b.cancel()
+	<-b.done
+}
+
+// Status reports the bridge's connection and counters
+func (b *Bridge) Status() pubsub.BridgeStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return pubsub.BridgeStatus{
+		Connected:  b.connected,
+		Reconnects: b.reconnects.Load(),
+		LastError:  b.lastError,
+		Forwarded:  b.forwarded.Load(),
+		Injected:   b.injected.Load(),
+		Dropped:    b.dropped.Load(),
+	}
+}
+
+// tapID is the firehose client_id local events reach the bridge as
+func (b *Bridge) tapID() string {
+	return SenderID + "-" + b.id
+}
+
+// run connects, forwards until the connection is lost, and reconnects,
+// until the bridge is closed
+func (b *Bridge) run() {
+	defer close(b.done)
+	backoff := b.opts.MinBackoff
+	connectedBefore := false
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+			select {
+			case <-time.After(wait):
+			case <-b.ctx.Done():
+				return
+			}
+		}
+
+		conn, err := b.connect()
+		if err != nil {
+			if b.ctx.Err() != nil {
+				return
+			}
+			b.fail(fmt.Errorf("connecting: %w", err))
+			backoff = min(2*backoff, b.opts.MaxBackoff)
+			continue
+		}
+		if connectedBefore {
+			b.reconnects.Add(1)
+		}
+		connectedBefore = true
+		backoff = b.opts.MinBackoff
+		b.setConnected()
+
+		b.forward(conn)
+		conn.Close()
+		if b.ctx.Err() != nil {
+			b.mutex.Lock()
+			b.connected = false
+			b.mutex.Unlock()
+			return
+		}
+		b.fail(fmt.Errorf("connection lost: %w", conn.Err()))
+	}
+}
+
+// connect dials NATS and subscribes to the bridged subjects
+func (b *Bridge) connect() (Conn, error) {
+	conn, err := b.dial(b.ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Subscribe(b.opts.SubjectPrefix+">", b.inject); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("subscribing: %w", err)
+	}
+	return conn, nil
+}
+
+func (b *Bridge) setConnected() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.connected = true
+	log.Printf("NATS bridge %s connected", b.opts.Name)
+}
+
+// fail records why the bridge is disconnected
+func (b *Bridge) fail(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.connected {
+		log.Printf("NATS bridge %s disconnected: %v", b.opts.Name, err)
+	}
+	b.connected = false
+	b.lastError = err.Error()
+}
+
+// forward publishes queued local events to NATS until conn is lost or the
+// bridge is closed. Events queued meanwhile wait for the next connection.
+func (b *Bridge) forward(conn Conn) {
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-conn.Done():
+			return
+		case event := <-b.queue:
+			msg, err := b.outbound(event)
+			if err == nil {
+				err = conn.Publish(msg)
+			}
+			if err != nil {
+				b.dropped.Add(1)
+				log.Printf("NATS bridge %s dropped event %s on %s: %v", b.opts.Name, event.Message.ID, event.Topic, err)
+				continue
+			}
+			b.forwarded.Add(1)
+		}
+	}
+}
+
+// outbound turns a local event into its NATS message: the payload as JSON,
+// with the event's headers, message ID and the bridge as origin
+func (b *Bridge) outbound(event pubsub.EventResponse) (Message, error) {
+	subject := b.opts.SubjectPrefix + event.Topic
+	if !validSubject(subject) {
+		return Message{}, fmt.Errorf("topic can't be a NATS subject")
+	}
+	data, err := json.Marshal(event.Message.Payload)
+	if err != nil {
+		return Message{}, err
+	}
+	header := make(map[string]string, len(event.Message.Headers)+2)
+	for key, value := range event.Message.Headers {
+		header[key] = value
+	}
+	header[MessageIDHeader] = event.Message.ID
+	header[OriginHeader] = b.id
+	return Message{Subject: subject, Header: header, Data: data}, nil
+}
+
+// inject publishes a NATS message to its local topic. Messages the bridge
+// sent itself are ignored.
+func (b *Bridge) inject(msg Message) {
+	if msg.Header[OriginHeader] == b.id {
+		return
+	}
+	topic := strings.TrimPrefix(msg.Subject, b.opts.SubjectPrefix)
+	if topic == "" || pubsub.IsSystemTopic(topic) {
+		b.dropped.Add(1)
+		return
+	}
+
+	// A payload that isn't JSON arrives as a string
+	var payload interface{}
+	if err := json.Unmarshal(msg.Data, &payload); err != nil {
+		payload = string(msg.Data)
+	}
+	message := pubsub.MessageData{
+		ID:      msg.Header[MessageIDHeader],
+		Payload: payload,
+		Headers: make(map[string]string, len(msg.Header)+1),
+	}
+	if message.ID == "" {
+		message.ID = uuid.NewString()
+	}
+	for key, value := range msg.Header {
+		if key != MessageIDHeader {
+			message.Headers[key] = value
+		}
+	}
+	if message.Headers[OriginHeader] == "" {
+		message.Headers[OriginHeader] = b.id
+	}
+
+	err := b.ps.Publish(b.ctx, topic, message, SenderID)
+	if errors.Is(err, pubsub.ErrTopicNotFound) && b.opts.AutoCreate {
+		if err = b.ps.CreateTopic(b.ctx, topic); err == nil || errors.Is(err, pubsub.ErrTopicExists) {
+			err = b.ps.Publish(b.ctx, topic, message, SenderID)
+		}
+	}
+	if err != nil {
+		b.dropped.Add(1)
+		log.Printf("NATS bridge %s dropped message on %s: %v", b.opts.Name, msg.Subject, err)
+		return
+	}
+	b.injected.Add(1)
+}
+
+// validSubject reports whether subject can be published to: no whitespace,
+// no empty tokens and no wildcards
+func validSubject(subject string) bool {
+	if strings.ContainsAny(subject, " \t\r\n") {
+		return false
+	}
+	for _, token := range strings.Split(subject, ".") {
+		if token == "" || token == "*" || token == ">" {
+			return false
+		}
+	}
+	return true
+}
+
+// tap receives a copy of every local event from the firehose and queues
+// those that should cross to NATS
+type tap struct{ b *Bridge }
+
+func (t tap) GetClientID() string {
+	return t.b.tapID()
+}
+
+func (t tap) IsConnected() bool {
+	return t.b.ctx.Err() == nil
+}
+
+func (t tap) GetLastActive() time.Time {
+	return time.Now()
+}
+
+// SendMessage queues an event for NATS. It runs under the topic's lock, so
+// it never blocks; events that arrived over a bridge aren't sent back out.
+func (t tap) SendMessage(msg interface{}) error {
+	prepared, ok := msg.(*pubsub.PreparedEvent)
+	if !ok {
+		return nil
+	}
+	event := prepared.Event
+	if event.Message.Headers[OriginHeader] != "" {
+		return nil
+	}
+	topic, err := pubsub.QualifyTopic(event.Namespace, event.Topic)
+	if err != nil || pubsub.IsSystemTopic(topic) {
+		return nil
+	}
+	event.Topic = topic
+	select {
+	case t.b.queue <- event:
+		return nil
+	default:
+		t.b.dropped.Add(1)
+		return pubsub.ErrorData{Code: pubsub.CodeServerBusy, Reason: "CLIENT_OVERLOADED", Message: "NATS bridge queue is full"}
+	}
+}
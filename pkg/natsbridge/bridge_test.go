@@ -0,0 +1,281 @@
+package natsbridge
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// fakeConn is a NATS connection that records what the bridge publishes
+type fakeConn struct {
+	published chan Message
+
+	mutex  sync.Mutex
+	handle func(Message)
+
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{published: make(chan Message, 64), done: make(chan struct{})}
+}
+
+func (c *fakeConn) Publish(msg Message) error {
+	c.published <- msg
+	return nil
+}
+
+func (c *fakeConn) Subscribe(subject string, handle func(Message)) error {
+	if subject != "pubsub.>" {
+		return errors.New("unexpected subject " + subject)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.handle = handle
+	return nil
+}
+
+// deliver passes a message from NATS to the bridge's subscription
+func (c *fakeConn) deliver(msg Message) {
+	c.mutex.Lock()
+	handle := c.handle
+	c.mutex.Unlock()
+	handle(msg)
+}
+
+// lose drops the connection as the server going away would
+func (c *fakeConn) lose(err error) {
+	c.once.Do(func() {
+		c.err = err
+		close(c.done)
+	})
+}
+
+func (c *fakeConn) Done() <-chan struct{} { return c.done }
+func (c *fakeConn) Err() error            { return c.err }
+func (c *fakeConn) Close()                { c.lose(errors.New("closed")) }
+
+// fakeNATS hands out the connections queued on conns, failing while none
+// is queued
+type fakeNATS struct {
+	conns chan *fakeConn
+}
+
+func (n *fakeNATS) dial(ctx context.Context) (Conn, error) {
+	select {
+	case conn := <-n.conns:
+		return conn, nil
+	default:
+		return nil, errors.New("connection refused")
+	}
+}
+
+// recordingClient is a local subscriber
+type recordingClient struct {
+	id     string
+	events chan pubsub.EventResponse
+}
+
+func (c *recordingClient) GetClientID() string      { return c.id }
+func (c *recordingClient) IsConnected() bool        { return true }
+func (c *recordingClient) GetLastActive() time.Time { return time.Now() }
+func (c *recordingClient) SendMessage(msg interface{}) error {
+	if prepared, ok := msg.(*pubsub.PreparedEvent); ok {
+		c.events <- prepared.Event
+	}
+	return nil
+}
+
+// startBridge starts a bridge on a system with the given topics, connected
+// to a fake NATS server
+func startBridge(t *testing.T, opts Options, topics ...string) (*pubsub.PubSubSystem, *Bridge, *fakeConn) {
+	t.Helper()
+	ps := pubsub.New()
+	t.Cleanup(ps.Close)
+	for _, topic := range topics {
+		if err := ps.CreateTopic(context.Background(), topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn := newFakeConn()
+	nats := &fakeNATS{conns: make(chan *fakeConn, 1)}
+	nats.conns <- conn
+	bridge := Start(ps, nats.dial, opts)
+	t.Cleanup(bridge.Close)
+	waitFor(t, func() bool { return bridge.Status().Connected })
+	return ps, bridge, conn
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func nextMessage(t *testing.T, conn *fakeConn) Message {
+	t.Helper()
+	select {
+	case msg := <-conn.published:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was published to NATS")
+		return Message{}
+	}
+}
+
+func nextEvent(t *testing.T, c *recordingClient) pubsub.EventResponse {
+	t.Helper()
+	select {
+	case event := <-c.events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event was delivered")
+		return pubsub.EventResponse{}
+	}
+}
+
+func TestLocalPublishesAreForwarded(t *testing.T) {
+	ps, bridge, conn := startBridge(t, Options{}, "orders", "acme::orders")
+
+	publish := func(topic, id string) {
+		t.Helper()
+		msg := pubsub.MessageData{ID: id, Payload: map[string]interface{}{"qty": 2}, Headers: map[string]string{"region": "eu"}}
+		if err := ps.Publish(context.Background(), topic, msg, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish("orders", "m1")
+	msg := nextMessage(t, conn)
+	if msg.Subject != "pubsub.orders" || string(msg.Data) != `{"qty":2}` {
+		t.Errorf("forwarded %s %s", msg.Subject, msg.Data)
+	}
+	if msg.Header[MessageIDHeader] != "m1" || msg.Header["region"] != "eu" || msg.Header[OriginHeader] != bridge.id {
+		t.Errorf("forwarded headers %v", msg.Header)
+	}
+
+	// Namespaced topics keep their internal name
+	publish("acme::orders", "m2")
+	if msg := nextMessage(t, conn); msg.Subject != "pubsub.acme::orders" {
+		t.Errorf("namespaced topic forwarded to %s", msg.Subject)
+	}
+	if status := bridge.Status(); status.Forwarded != 2 || status.Dropped != 0 {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestNATSMessagesAreInjected(t *testing.T) {
+	ps, bridge, conn := startBridge(t, Options{}, "orders")
+	local := &recordingClient{id: "local", events: make(chan pubsub.EventResponse, 16)}
+	if _, err := ps.Subscribe(context.Background(), local.id, "orders", 0, local); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.deliver(Message{Subject: "pubsub.orders", Header: map[string]string{MessageIDHeader: "n1", OriginHeader: "other-broker"}, Data: []byte(`{"qty":3}`)})
+	event := nextEvent(t, local)
+	if payload, _ := event.Message.Payload.(map[string]interface{}); event.Message.ID != "n1" || payload["qty"] != float64(3) {
+		t.Errorf("injected %+v", event.Message)
+	}
+	if event.Message.Headers[OriginHeader] != "other-broker" {
+		t.Errorf("injected headers %v", event.Message.Headers)
+	}
+
+	// A payload that isn't JSON is a string, and a message without an
+	// origin gets the bridge's
+	conn.deliver(Message{Subject: "pubsub.orders", Data: []byte("plain text")})
+	event = nextEvent(t, local)
+	if event.Message.Payload != "plain text" || event.Message.ID == "" || event.Message.Headers[OriginHeader] != bridge.id {
+		t.Errorf("injected %+v", event.Message)
+	}
+
+	// The bridge's own messages, topics that don't exist and system topics
+	// are ignored
+	conn.deliver(Message{Subject: "pubsub.orders", Header: map[string]string{OriginHeader: bridge.id}, Data: []byte("1")})
+	conn.deliver(Message{Subject: "pubsub.missing", Data: []byte("1")})
+	conn.deliver(Message{Subject: "pubsub.$sys.clients", Data: []byte("1")})
+
+	// Injected events don't go back out: the next message on NATS is a
+	// local publish made after them
+	if err := ps.Publish(context.Background(), "orders", pubsub.MessageData{ID: "local-1", Payload: 1}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if msg := nextMessage(t, conn); msg.Header[MessageIDHeader] != "local-1" {
+		t.Errorf("reflected %s back to NATS", msg.Header[MessageIDHeader])
+	}
+	if event := nextEvent(t, local); event.Message.ID != "local-1" {
+		t.Errorf("delivered %+v", event.Message)
+	}
+	if status := bridge.Status(); status.Injected != 2 || status.Dropped != 2 || status.Forwarded != 1 {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestAutoCreateTopics(t *testing.T) {
+	ps, bridge, conn := startBridge(t, Options{AutoCreate: true})
+	conn.deliver(Message{Subject: "pubsub.invoices", Data: []byte(`"hello"`)})
+	if _, err := ps.GetTopicDetail("invoices"); err != nil {
+		t.Fatalf("topic wasn't created: %v", err)
+	}
+	if status := bridge.Status(); status.Injected != 1 {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestReconnectsAfterConnectionLoss(t *testing.T) {
+	ps := pubsub.New()
+	t.Cleanup(ps.Close)
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	nats := &fakeNATS{conns: make(chan *fakeConn, 1)}
+	bridge := Start(ps, nats.dial, Options{MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond})
+	t.Cleanup(bridge.Close)
+
+	// Until NATS accepts a connection, /health is degraded
+	waitFor(t, func() bool { return bridge.Status().LastError != "" })
+	if health := ps.GetHealth(); health.Status != "degraded" || health.Bridges["nats"].Connected {
+		t.Errorf("health while connecting = %+v", health)
+	}
+	first := newFakeConn()
+	nats.conns <- first
+	waitFor(t, func() bool { return bridge.Status().Connected })
+	if health := ps.GetHealth(); health.Status != "ok" || !health.Bridges["nats"].Connected {
+		t.Errorf("health once connected = %+v", health)
+	}
+
+	// Events published while the connection is down are sent once it's back
+	first.lose(errors.New("server went away"))
+	waitFor(t, func() bool { return !bridge.Status().Connected })
+	if err := ps.Publish(context.Background(), "orders", pubsub.MessageData{ID: "m1", Payload: 1}, ""); err != nil {
+		t.Fatal(err)
+	}
+	second := newFakeConn()
+	nats.conns <- second
+	if msg := nextMessage(t, second); msg.Header[MessageIDHeader] != "m1" {
+		t.Errorf("forwarded %v after reconnecting", msg.Header)
+	}
+	status := bridge.Status()
+	if !status.Connected || status.Reconnects != 1 {
+		t.Errorf("status after reconnecting = %+v", status)
+	}
+
+	// The subscription is made again on the new connection
+	local := &recordingClient{id: "local", events: make(chan pubsub.EventResponse, 1)}
+	if _, err := ps.Subscribe(context.Background(), local.id, "orders", 0, local); err != nil {
+		t.Fatal(err)
+	}
+	second.deliver(Message{Subject: "pubsub.orders", Data: []byte("2")})
+	if event := nextEvent(t, local); event.Message.Payload != float64(2) {
+		t.Errorf("injected %+v", event.Message)
+	}
+}
@@ -0,0 +1,87 @@
+package natsbridge
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Dial returns a Dialer connecting to the NATS server at url. The client's
+// own reconnects are turned off, since the bridge reconnects with its own
+// backoff, and so is echo, since the bridge ignores its own messages anyway.
+func Dial(url string, options ...nats.Option) Dialer {
+	return func(ctx context.Context) (Conn, error) {
+		conn := &natsConn{done: make(chan struct{})}
+		options := append([]nats.Option{
+			nats.Name(SenderID),
+			nats.NoReconnect(),
+			nats.NoEcho(),
+			nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+				conn.lost(err)
+			}),
+			nats.ClosedHandler(func(*nats.Conn) {
+				conn.lost(nats.ErrConnectionClosed)
+			}),
+		}, options...)
+
+		nc, err := nats.Connect(url, options...)
+		if err != nil {
+			return nil, err
+		}
+		conn.nc = nc
+		return conn, nil
+	}
+}
+
+// natsConn is a Conn over a nats.go connection
+type natsConn struct {
+	nc *nats.Conn
+
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+func (c *natsConn) Publish(msg Message) error {
+	header := make(nats.Header, len(msg.Header))
+	for key, value := range msg.Header {
+		header.Set(key, value)
+	}
+	return c.nc.PublishMsg(&nats.Msg{Subject: msg.Subject, Header: header, Data: msg.Data})
+}
+
+func (c *natsConn) Subscribe(subject string, handle func(Message)) error {
+	_, err := c.nc.Subscribe(subject, func(m *nats.Msg) {
+		header := make(map[string]string, len(m.Header))
+		for key := range m.Header {
+			header[key] = m.Header.Get(key)
+		}
+		handle(Message{Subject: m.Subject, Header: header, Data: m.Data})
+	})
+	return err
+}
+
+// lost records the first reason the connection went away
+func (c *natsConn) lost(err error) {
+	c.once.Do(func() {
+		if err == nil {
+			err = nats.ErrConnectionClosed
+		}
+		c.err = err
+		close(c.done)
+	})
+}
+
+func (c *natsConn) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *natsConn) Err() error {
+	<-c.done
+	return c.err
+}
+
+func (c *natsConn) Close() {
+	c.nc.Close()
+}
@@ -0,0 +1,357 @@
+package pubsub
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	AckModeAuto     = "auto"     // Fire-and-forget delivery (default)
+	AckModeExplicit = "explicit" // At-least-once delivery acknowledged with msg_ack
+
+	DefaultAckTimeout = 30 * time.Second // Unacked events are redelivered after this long
+	DefaultAckWindow  = 100              // Unacked events allowed before delivery pauses
+
+	ackSweepInterval = time.Second // How often unacked events are checked for redelivery
+)
+
+// SubscribeOptions configures a subscription
+type SubscribeOptions struct {
+	// Replay this many events from history (not tracked for acks)
+	LastN int
+
+	// Replay every event in history after this sequence number, ahead of
+	// any live event; takes precedence over LastN (auto ack mode only)
+	SinceSeq int64
+
+	// AckModeAuto or AckModeExplicit; empty means auto
+	AckMode string
+
+	// Name under which explicit-ack state is kept across reconnects;
+	// defaults to the client ID
+	Consumer string
+
+	// Only deliver (and replay) events whose payload matches
+	Filter *Filter
+
+	// Only deliver (and replay) events with all of these headers
+	Headers map[string]string
+
+	// Unsubscribe automatically once this long has passed without a
+	// touch; 0 keeps the subscription until it is ended
+	TTL time.Duration
+}
+
+// unackedEvent is an event delivered to an explicit-ack consumer
+type unackedEvent struct {
+	event    EventResponse
+	sentAt   time.Time
+	attempts int // Sends a client accepted
+}
+
+// ackState tracks at-least-once delivery for one consumer on one topic. It
+// outlives the connection so a consumer that reconnects gets its unacked
+// events again.
+type ackState struct {
+	ps       *PubSubSystem
+	consumer string
+	topic    *Topic
+
+	mutex       sync.Mutex
+	client      ClientInterface // nil while the consumer is disconnected
+	filter      *EventFilter
+	nextTag     int64
+	lastSentSeq int64                   // Newest topic sequence handed to the consumer
+	unacked     map[int64]*unackedEvent // delivery tag -> event
+}
+
+// ackKey identifies a consumer's state on a topic
+func ackKey(consumer, topicName string) string {
+	return consumer + "\x00" + topicName
+}
+
+// SetAckPolicy configures explicit-ack redelivery timeout and window size.
+// Non-positive values keep the defaults.
+func (ps *PubSubSystem) SetAckPolicy(timeout time.Duration, window int) {
+	if timeout <= 0 {
+		timeout = DefaultAckTimeout
+	}
+	if window <= 0 {
+		window = DefaultAckWindow
+	}
+	ps.ackMutex.Lock()
+	defer ps.ackMutex.Unlock()
+	ps.ackTimeout = timeout
+	ps.ackWindow = window
+}
+
+// ackPolicy returns the current timeout and window
+func (ps *PubSubSystem) ackPolicy() (time.Duration, int) {
+	ps.ackMutex.Lock()
+	defer ps.ackMutex.Unlock()
+	return ps.ackTimeout, ps.ackWindow
+}
+
+// attachAckState finds or creates the consumer's state for a topic and
+// points it at client. Existing unacked events are redelivered. Callers
+// must hold topic.mutex.
+func (ps *PubSubSystem) attachAckState(consumer string, topic *Topic, client ClientInterface, filter *EventFilter) *ackState {
+	ps.ackMutex.Lock()
+	state, exists := ps.acks[ackKey(consumer, topic.Name)]
+	if !exists {
+		state = &ackState{
+			ps:          ps,
+			consumer:    consumer,
+			topic:       topic,
+			lastSentSeq: topic.LastSeq,
+			unacked:     make(map[int64]*unackedEvent),
+		}
+		ps.acks[ackKey(consumer, topic.Name)] = state
+	}
+	ps.ackMutex.Unlock()
+
+	_, window := ps.ackPolicy()
+
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	state.client = client
+	state.filter = filter
+	if exists {
+		// Resume: everything unacked goes out again, then anything
+		// published while the consumer was away
+		for _, pending := range state.sortedUnacked() {
+			state.send(pending, true)
+		}
+		state.refill(window)
+	}
+	return state
+}
+
+// detach marks the consumer as disconnected if client is still the one
+// attached, keeping unacked events for a later resume
+func (state *ackState) detach(client ClientInterface) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	if state.client == client {
+		state.client = nil
+	}
+}
+
+// deliver hands a freshly published event to the consumer unless the
+// window is full or older events are still waiting, in which case a later
+// ack picks it up from topic history
+func (state *ackState) deliver(event EventResponse, window int) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	if state.client == nil || event.Seq != state.lastSentSeq+1 {
+		return
+	}
+	if !state.matches(event) {
+		// Nothing to deliver, but later events are now in order
+		state.lastSentSeq = event.Seq
+		return
+	}
+	if len(state.unacked) >= window {
+		return
+	}
+	state.track(event)
+}
+
+// track assigns a delivery tag to event and sends it. Callers must hold
+// the mutex.
+func (state *ackState) track(event EventResponse) {
+	state.nextTag++
+	pending := &unackedEvent{event: event}
+	pending.event.DeliveryTag = state.nextTag
+	state.unacked[state.nextTag] = pending
+	state.lastSentSeq = event.Seq
+	state.send(pending, false)
+}
+
+// send delivers an unacked event. A full client queue is not an error: the
+// event stays unacked and is redelivered after the timeout. Callers must
+// hold the mutex.
+func (state *ackState) send(pending *unackedEvent, redelivered bool) {
+	pending.sentAt = state.ps.clock.Now()
+	if state.client == nil {
+		return
+	}
+	attempt := pending.attempts + 1
+	event := pending.event
+	event.Redelivered = redelivered
+	event.Delivery = &DeliveryInfo{Redelivered: attempt > 1, Attempt: attempt}
+	if err := state.client.SendMessage(event); err != nil {
+		log.Printf("Delivery to consumer %s deferred - %v", state.consumer, err)
+		return
+	}
+	pending.attempts = attempt
+	state.topic.activity.delivered()
+	state.ps.emit(hookEvent{kind: hookDeliver, topic: state.topic.Name, clientID: state.client.GetClientID()})
+}
+
+// refill sends events published after lastSentSeq while the window has
+// room. Callers must hold the mutex.
+func (state *ackState) refill(window int) {
+	if state.client == nil {
+		return
+	}
+	scratch := acquireEvents()
+	events := *scratch
+	defer func() { releaseEvents(scratch, events) }()
+
+	for room := window - len(state.unacked); room > 0; {
+		clear(events)
+		events = state.topic.MessageHistory.AppendAfterSeq(events[:0], state.lastSentSeq, room)
+		if len(events) == 0 {
+			return
+		}
+		for _, event := range events {
+			if !state.matches(event) {
+				state.lastSentSeq = event.Seq
+				continue
+			}
+			state.track(event)
+			room--
+		}
+	}
+}
+
+// matches reports whether the consumer's filter and the delivery
+// interceptors let event through. Callers must hold the mutex.
+func (state *ackState) matches(event EventResponse) bool {
+	return state.filter.MatchMessage(event.Message) && state.ps.deliverable(event, state.client.GetClientID())
+}
+
+// sortedUnacked returns unacked events in delivery order. Callers must
+// hold the mutex.
+func (state *ackState) sortedUnacked() []*unackedEvent {
+	pending := make([]*unackedEvent, 0, len(state.unacked))
+	for _, p := range state.unacked {
+		pending = append(pending, p)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].event.DeliveryTag < pending[j].event.DeliveryTag
+	})
+	return pending
+}
+
+// AckMessages acknowledges events delivered to an explicit-ack consumer,
+// either a single delivery tag or every event up to and including a topic
+// sequence number. Returns how many events were acknowledged.
+func (ps *PubSubSystem) AckMessages(ctx context.Context, consumer, topicName string, deliveryTag, upToSeq int64) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	ps.ackMutex.Lock()
+	state, exists := ps.acks[ackKey(consumer, topicName)]
+	ps.ackMutex.Unlock()
+	if !exists {
+		return 0, errorOf(ErrNotSubscribed, "no explicit-ack subscription for %s on topic %s", consumer, topicName)
+	}
+
+	_, window := ps.ackPolicy()
+
+	ps.holdHooks()
+	defer ps.releaseHooks()
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	var acked []EventResponse
+	if deliveryTag > 0 {
+		if pending, ok := state.unacked[deliveryTag]; ok {
+			delete(state.unacked, deliveryTag)
+			acked = append(acked, pending.event)
+		}
+	}
+	if upToSeq > 0 {
+		for tag, pending := range state.unacked {
+			if pending.event.Seq <= upToSeq {
+				delete(state.unacked, tag)
+				acked = append(acked, pending.event)
+			}
+		}
+	}
+	if len(acked) > 0 && state.client != nil {
+		ps.receipts.acked(state.client.GetClientID(), acked)
+	}
+
+	state.refill(window)
+	return len(acked), nil
+}
+
+// removeAckState forgets a consumer's state on a topic
+func (ps *PubSubSystem) removeAckState(consumer, topicName string) {
+	ps.ackMutex.Lock()
+	defer ps.ackMutex.Unlock()
+	delete(ps.acks, ackKey(consumer, topicName))
+}
+
+// removeTopicAckStates forgets every consumer's state on a deleted topic
+func (ps *PubSubSystem) removeTopicAckStates(topic *Topic) {
+	ps.ackMutex.Lock()
+	defer ps.ackMutex.Unlock()
+	for key, state := range ps.acks {
+		if state.topic == topic {
+			delete(ps.acks, key)
+		}
+	}
+}
+
+// unackedByConsumer returns the number of unacked events per consumer,
+// counting only topics in namespace ns unless it is empty
+func (ps *PubSubSystem) unackedByConsumer(ns string) map[string]int {
+	ps.ackMutex.Lock()
+	states := make([]*ackState, 0, len(ps.acks))
+	for _, state := range ps.acks {
+		if topicNS, _ := SplitTopic(state.topic.Name); ns != "" && topicNS != ns {
+			continue
+		}
+		states = append(states, state)
+	}
+	ps.ackMutex.Unlock()
+
+	depth := make(map[string]int)
+	for _, state := range states {
+		state.mutex.Lock()
+		depth[state.consumer] += len(state.unacked)
+		state.mutex.Unlock()
+	}
+	return depth
+}
+
+// ackLoop redelivers events that have not been acknowledged in time
+func (ps *PubSubSystem) ackLoop() {
+	ticker := ps.clock.NewTicker(ackSweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C() {
+		timeout, _ := ps.ackPolicy()
+
+		ps.ackMutex.Lock()
+		states := make([]*ackState, 0, len(ps.acks))
+		for _, state := range ps.acks {
+			states = append(states, state)
+		}
+		ps.ackMutex.Unlock()
+
+		cutoff := ps.clock.Now().Add(-timeout)
+		ps.holdHooks()
+		for _, state := range states {
+			state.mutex.Lock()
+			for _, pending := range state.sortedUnacked() {
+				if pending.sentAt.Before(cutoff) {
+					state.send(pending, true)
+				}
+			}
+			state.mutex.Unlock()
+		}
+		ps.releaseHooks()
+	}
+}
@@ -0,0 +1,66 @@
+package pubsub
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// topicActivity tracks a topic's recent publish rates and when it last
+// delivered an event. Updates are O(1) and old buckets expire lazily, so
+// no goroutine runs per topic.
+type topicActivity struct {
+	rate1m       *slidingCounter
+	rate5m       *slidingCounter
+	lastDelivery atomic.Int64 // Unix nanoseconds, 0 if nothing was delivered yet
+	bytesIn      atomic.Int64 // Publish frames received for the topic
+	bytesOut     atomic.Int64 // Event frames sent to its subscribers
+	now          func() time.Time
+}
+
+// newTopicActivity creates activity tracking for a topic. now is the clock;
+// nil means time.Now.
+func newTopicActivity(now func() time.Time) *topicActivity {
+	if now == nil {
+		now = time.Now
+	}
+	return &topicActivity{
+		rate1m: newSlidingCounterWithClock(time.Minute, time.Second, now),
+		rate5m: newSlidingCounterWithClock(5*time.Minute, 5*time.Second, now),
+		now:    now,
+	}
+}
+
+// published records one published event
+func (a *topicActivity) published() {
+	a.rate1m.Add(1)
+	a.rate5m.Add(1)
+}
+
+// delivered records that an event reached a subscriber
+func (a *topicActivity) delivered() {
+	a.lastDelivery.Store(a.now().UnixNano())
+}
+
+// Snapshot returns the current rates and timestamps. lastPublished comes
+// from the topic, which already tracks it.
+func (a *topicActivity) Snapshot(lastPublished time.Time) TopicActivity {
+	activity := TopicActivity{
+		Rate1m: perSecond(a.rate1m),
+		Rate5m: perSecond(a.rate5m),
+	}
+	if !lastPublished.IsZero() {
+		activity.LastPublishedAt = &lastPublished
+	}
+	if nanos := a.lastDelivery.Load(); nanos != 0 {
+		lastDelivery := time.Unix(0, nanos)
+		activity.LastDeliveryAt = &lastDelivery
+	}
+	return activity
+}
+
+// perSecond averages a counter over its window, rounded to thousandths
+func perSecond(sc *slidingCounter) float64 {
+	rate := float64(sc.Sum()) / sc.Window().Seconds()
+	return math.Round(rate*1000) / 1000
+}
@@ -0,0 +1,74 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTopicActivityWindowsRollOver(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	a := newTopicActivity(func() time.Time { return now })
+	publish := func(n int) {
+		for i := 0; i < n; i++ {
+			a.published()
+		}
+	}
+	expect := func(at time.Duration, rate1m, rate5m float64) {
+		t.Helper()
+		now = start.Add(at)
+		if got := a.Snapshot(time.Time{}); got.Rate1m != rate1m || got.Rate5m != rate5m {
+			t.Errorf("after %s: rates %g/s and %g/s, want %g/s and %g/s", at, got.Rate1m, got.Rate5m, rate1m, rate5m)
+		}
+	}
+
+	publish(120)
+	expect(0, 2, 0.4)
+	now = start.Add(30 * time.Second)
+	publish(60)
+	expect(30*time.Second, 3, 0.6)
+
+	// The first burst leaves the one-minute window, then the second
+	expect(61*time.Second, 1, 0.6)
+	expect(91*time.Second, 0, 0.6)
+
+	// And then the five-minute one
+	expect(301*time.Second, 0, 0.2)
+	expect(331*time.Second, 0, 0)
+
+	// Nothing published for longer than either window
+	expect(time.Hour, 0, 0)
+	publish(6)
+	expect(time.Hour, 0.1, 0.02)
+}
+
+func TestTopicActivityTimestamps(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	a := newTopicActivity(func() time.Time { return now })
+	if got := a.Snapshot(time.Time{}); got.LastPublishedAt != nil || got.LastDeliveryAt != nil {
+		t.Errorf("idle topic reports %+v", got)
+	}
+
+	now = start.Add(time.Minute)
+	a.delivered()
+	published := start.Add(30 * time.Second)
+	got := a.Snapshot(published)
+	if got.LastPublishedAt == nil || !got.LastPublishedAt.Equal(published) || got.LastDeliveryAt == nil || !got.LastDeliveryAt.Equal(now) {
+		t.Errorf("activity = %+v", got)
+	}
+
+	// Timestamps don't expire with the rates
+	now = start.Add(time.Hour)
+	if got := a.Snapshot(published); got.LastDeliveryAt == nil || !got.LastDeliveryAt.Equal(start.Add(time.Minute)) {
+		t.Errorf("last delivery an hour on = %v", got.LastDeliveryAt)
+	}
+}
+
+func BenchmarkTopicActivityPublished(b *testing.B) {
+	a := newTopicActivity(nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		a.published()
+	}
+}
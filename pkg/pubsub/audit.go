@@ -0,0 +1,231 @@
+package pubsub
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Connection lifecycle events recorded in the audit log
+const (
+	AuditConnect     = "connect"     // Connection opened, with remote address and user agent
+	AuditIdentify    = "identify"    // Connection bound to a client_id other than the assigned one
+	AuditSubscribe   = "subscribe"   // Subscribed to a topic
+	AuditUnsubscribe = "unsubscribe" // Unsubscribed, disconnected or the topic was deleted
+	AuditDisconnect  = "disconnect"  // Connection closed, with reason, close code and publish count
+
+	// Unsubscribed by the server for dropping too many events, with what
+	// the subscription was over
+	AuditSlowConsumer = "slow_consumer"
+)
+
+const (
+	// DefaultAuditLogSize is how many records the in-memory window keeps
+	DefaultAuditLogSize = 10000
+
+	// Records waiting for the writer; more are dropped and counted
+	auditQueueSize = 4096
+)
+
+// AuditRecord is one connection lifecycle event
+type AuditRecord struct {
+	Timestamp  time.Time `json:"ts"`
+	Event      string    `json:"event"`
+	ClientID   string    `json:"client_id"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	PreviousID string    `json:"previous_id,omitempty"` // identify: the ID assigned on connect
+	Topic      string    `json:"topic,omitempty"`
+	Published  int64     `json:"published,omitempty"` // disconnect: messages the connection published
+	Reason     string    `json:"reason,omitempty"`
+	CloseCode  int       `json:"close_code,omitempty"`
+}
+
+// AuditQuery selects records from the in-memory window
+type AuditQuery struct {
+	ClientID string    // Only this client's records, if set
+	From     time.Time // Inclusive, if set
+	To       time.Time // Exclusive, if set
+	Limit    int       // Most recent matches only; 0 returns all
+}
+
+// AuditLog keeps the most recent connection lifecycle records in memory and
+// optionally appends every record to a JSON-lines file. Recording never
+// blocks: records go through a buffered channel to a writer goroutine, and
+// are dropped and counted when it falls behind.
+type AuditLog struct {
+	records chan AuditRecord
+	done    chan struct{}
+	dropped atomic.Int64
+
+	// Ring of the most recent records, oldest first from next once full
+	window []AuditRecord
+	next   int
+	full   bool
+	mutex  sync.RWMutex
+
+	// Optional file sink (writer goroutine only)
+	file   *os.File
+	writer *bufio.Writer
+
+	// Set by Close; Record checks it under closeMutex
+	closed     bool
+	closeMutex sync.RWMutex
+}
+
+// NewAuditLog creates an audit log keeping size records in memory, also
+// appending them to path unless it is empty, and starts its writer
+func NewAuditLog(size int, path string) (*AuditLog, error) {
+	if size <= 0 {
+		size = DefaultAuditLogSize
+	}
+	al := &AuditLog{
+		records: make(chan AuditRecord, auditQueueSize),
+		done:    make(chan struct{}),
+		window:  make([]AuditRecord, size),
+	}
+	if path != "" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("opening audit log: %w", err)
+		}
+		al.file = file
+		al.writer = bufio.NewWriter(file)
+	}
+	go al.run()
+	return al, nil
+}
+
+// Record queues a record without blocking. A zero Timestamp is set to now.
+func (al *AuditLog) Record(rec AuditRecord) {
+	if rec.Timestamp.IsZero() {
+		rec.Timestamp = time.Now()
+	}
+
+	al.closeMutex.RLock()
+	defer al.closeMutex.RUnlock()
+	if al.closed {
+		return
+	}
+	select {
+	case al.records <- rec:
+	default:
+		al.dropped.Add(1)
+	}
+}
+
+// Dropped returns how many records were lost to a full queue
+func (al *AuditLog) Dropped() int64 {
+	return al.dropped.Load()
+}
+
+// Query returns the matching records in the in-memory window, oldest first
+func (al *AuditLog) Query(q AuditQuery) []AuditRecord {
+	al.mutex.RLock()
+	defer al.mutex.RUnlock()
+
+	ordered := al.window[:al.next]
+	if al.full {
+		ordered = append(append([]AuditRecord(nil), al.window[al.next:]...), al.window[:al.next]...)
+	}
+
+	matches := []AuditRecord{}
+	for _, rec := range ordered {
+		if q.ClientID != "" && rec.ClientID != q.ClientID {
+			continue
+		}
+		if !q.From.IsZero() && rec.Timestamp.Before(q.From) {
+			continue
+		}
+		if !q.To.IsZero() && !rec.Timestamp.Before(q.To) {
+			continue
+		}
+		matches = append(matches, rec)
+	}
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[len(matches)-q.Limit:]
+	}
+	return matches
+}
+
+// Close writes the queued records and closes the file
+func (al *AuditLog) Close() {
+	al.closeMutex.Lock()
+	if al.closed {
+		al.closeMutex.Unlock()
+		return
+	}
+	al.closed = true
+	close(al.records)
+	al.closeMutex.Unlock()
+	<-al.done
+}
+
+// run is the writer loop
+func (al *AuditLog) run() {
+	defer func() {
+		if al.file != nil {
+			al.writer.Flush()
+			al.file.Close()
+		}
+		close(al.done)
+	}()
+
+	for rec := range al.records {
+		al.mutex.Lock()
+		al.window[al.next] = rec
+		al.next = (al.next + 1) % len(al.window)
+		if al.next == 0 {
+			al.full = true
+		}
+		al.mutex.Unlock()
+
+		if al.writer == nil {
+			continue
+		}
+		line, err := json.Marshal(rec)
+		if err != nil {
+			continue
+		}
+		al.writer.Write(append(line, '\n'))
+		// Flush once the queue is drained so the file is never far behind
+		if len(al.records) == 0 {
+			if err := al.writer.Flush(); err != nil {
+				log.Printf("Error writing audit log: %v", err)
+			}
+		}
+	}
+}
+
+// EnableAuditLog records connection lifecycle events in al. Subscribes and
+// unsubscribes on every transport are recorded here; transports record
+// connects, identifies and disconnects. Call before the server starts
+// accepting clients.
+func (ps *PubSubSystem) EnableAuditLog(al *AuditLog) {
+	ps.auditLog = al
+	ps.AddHooks(Hooks{
+		OnSubscribe: func(topic, clientID string) {
+			al.Record(AuditRecord{Event: AuditSubscribe, ClientID: clientID, Topic: topic})
+		},
+		OnUnsubscribe: func(topic, clientID string) {
+			al.Record(AuditRecord{Event: AuditUnsubscribe, ClientID: clientID, Topic: topic})
+		},
+	})
+}
+
+// AuditLog returns the audit log, or nil when it is disabled
+func (ps *PubSubSystem) AuditLog() *AuditLog {
+	return ps.auditLog
+}
+
+// Audit records a connection lifecycle event if the audit log is enabled
+func (ps *PubSubSystem) Audit(rec AuditRecord) {
+	if ps.auditLog != nil {
+		ps.auditLog.Record(rec)
+	}
+}
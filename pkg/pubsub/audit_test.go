@@ -0,0 +1,179 @@
+package pubsub
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// auditEvents returns the events of records, in order
+func auditEvents(records []AuditRecord) []string {
+	events := make([]string, len(records))
+	for i, rec := range records {
+		events[i] = rec.Event + ":" + rec.ClientID
+	}
+	return events
+}
+
+// waitAudit waits until the audit log's window holds n records
+func waitAudit(t *testing.T, al *AuditLog, n int) []AuditRecord {
+	t.Helper()
+	var records []AuditRecord
+	waitFor(t, "the audit records", func() bool {
+		records = al.Query(AuditQuery{})
+		return len(records) >= n
+	})
+	return records
+}
+
+func TestAuditLogWindow(t *testing.T) {
+	al, err := NewAuditLog(3, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(al.Close)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, rec := range []AuditRecord{
+		{Event: AuditConnect, ClientID: "a"},
+		{Event: AuditConnect, ClientID: "b"},
+		{Event: AuditSubscribe, ClientID: "a", Topic: "orders"},
+		{Event: AuditDisconnect, ClientID: "b"},
+	} {
+		rec.Timestamp = start.Add(time.Duration(i) * time.Minute)
+		al.Record(rec)
+	}
+	waitFor(t, "the audit records", func() bool {
+		records := al.Query(AuditQuery{})
+		return len(records) == 3 && records[2].Event == AuditDisconnect
+	})
+
+	// The oldest record made way for the newest
+	for _, tc := range []struct {
+		name string
+		q    AuditQuery
+		want []string
+	}{
+		{"everything", AuditQuery{}, []string{"connect:b", "subscribe:a", "disconnect:b"}},
+		{"one client", AuditQuery{ClientID: "b"}, []string{"connect:b", "disconnect:b"}},
+		{"from is inclusive", AuditQuery{From: start.Add(2 * time.Minute)}, []string{"subscribe:a", "disconnect:b"}},
+		{"to is exclusive", AuditQuery{To: start.Add(2 * time.Minute)}, []string{"connect:b"}},
+		{"most recent", AuditQuery{Limit: 2}, []string{"subscribe:a", "disconnect:b"}},
+		{"nothing matches", AuditQuery{ClientID: "c"}, []string{}},
+	} {
+		got := auditEvents(al.Query(tc.q))
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: %v, want %v", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: %v, want %v", tc.name, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestAuditLogFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	al, err := NewAuditLog(2, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, client := range []string{"a", "b", "c"} {
+		al.Record(AuditRecord{Event: AuditConnect, ClientID: client, RemoteAddr: "10.0.0.1:5000"})
+	}
+	al.Close()
+	// Recording after close is ignored rather than a panic
+	al.Record(AuditRecord{Event: AuditConnect, ClientID: "late"})
+
+	// The file keeps every record, beyond the in-memory window
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var clients []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		if rec.Event != AuditConnect || rec.RemoteAddr != "10.0.0.1:5000" || rec.Timestamp.IsZero() {
+			t.Errorf("record %+v", rec)
+		}
+		clients = append(clients, rec.ClientID)
+	}
+	if len(clients) != 3 || clients[0] != "a" || clients[2] != "c" {
+		t.Errorf("file holds records of %v", clients)
+	}
+
+	// Appended to, not truncated, when reopened
+	al, err = NewAuditLog(2, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	al.Record(AuditRecord{Event: AuditDisconnect, ClientID: "a"})
+	al.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines != 4 {
+		t.Errorf("file has %d records after reopening, want 4", lines)
+	}
+}
+
+func TestAuditLogRecordsSubscriptions(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	al, err := NewAuditLog(0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(al.Close)
+	ps.EnableAuditLog(al)
+	for _, name := range []string{"orders", "payments"} {
+		if err := ps.CreateTopic(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dashboard := newTestClient("dashboard")
+	subscribeClient(t, ps, "orders", dashboard)
+	if _, err := ps.Subscribe(ctx, dashboard.id, "payments", 0, dashboard); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Unsubscribe(ctx, dashboard.id, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	// Deleting a topic unsubscribes its subscribers
+	if err := ps.DeleteTopic(ctx, "payments"); err != nil {
+		t.Fatal(err)
+	}
+	ps.Audit(AuditRecord{Event: AuditDisconnect, ClientID: dashboard.id})
+
+	records := waitAudit(t, al, 5)
+	want := []AuditRecord{
+		{Event: AuditSubscribe, ClientID: "dashboard", Topic: "orders"},
+		{Event: AuditSubscribe, ClientID: "dashboard", Topic: "payments"},
+		{Event: AuditUnsubscribe, ClientID: "dashboard", Topic: "orders"},
+		{Event: AuditUnsubscribe, ClientID: "dashboard", Topic: "payments"},
+		{Event: AuditDisconnect, ClientID: "dashboard"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("recorded %v", auditEvents(records))
+	}
+	for i, rec := range records {
+		rec.Timestamp = time.Time{}
+		if rec != want[i] {
+			t.Errorf("record %d = %+v, want %+v", i, rec, want[i])
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Severities of an operator broadcast
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// ErrInvalidBroadcast is returned for a broadcast without a message or with
+// an unknown severity
+var ErrInvalidBroadcast = errors.New("invalid broadcast")
+
+// GracefulCloser is implemented by clients whose connection can be closed
+// from the server side, e.g. after a maintenance broadcast
+type GracefulCloser interface {
+	CloseGracefully(reason string)
+}
+
+// Kicker is implemented by clients an operator can disconnect, telling
+// them why
+type Kicker interface {
+	Kick(reason string)
+}
+
+// EventPayload returns the payload of the info in the event shape: the
+// message, or the message with the severity of a broadcast or the reason
+// of an unsubscribed notice
+func (info InfoResponse) EventPayload() interface{} {
+	if info.Severity == "" && info.Reason == "" {
+		return info.Message
+	}
+	payload := map[string]string{"msg": info.Message}
+	if info.Severity != "" {
+		payload["severity"] = info.Severity
+	}
+	if info.Reason != "" {
+		payload["reason"] = info.Reason
+	}
+	return payload
+}
+
+// Broadcast sends an info notice with the given severity to every connected
+// client on every transport. The notice never waits for a slow client: sends
+// that fail are counted instead. With closeAfter set, the clients reached or
+// not are closed gracefully once it elapses, unless they have already gone.
+func (ps *PubSubSystem) Broadcast(message, severity string, closeAfter time.Duration) (BroadcastResponse, error) {
+	if severity == "" {
+		severity = SeverityInfo
+	}
+	if message == "" {
+		return BroadcastResponse{}, fmt.Errorf("%w: message is required", ErrInvalidBroadcast)
+	}
+	if severity != SeverityInfo && severity != SeverityWarning && severity != SeverityCritical {
+		return BroadcastResponse{}, fmt.Errorf("%w: severity %q", ErrInvalidBroadcast, severity)
+	}
+	if closeAfter < 0 {
+		return BroadcastResponse{}, fmt.Errorf("%w: negative close delay", ErrInvalidBroadcast)
+	}
+
+	// Copy the registry so no send happens under clientMutex
+	ps.clientMutex.RLock()
+	clients := make([]ClientInterface, 0, len(ps.clients))
+	for _, client := range ps.clients {
+		clients = append(clients, client)
+	}
+	ps.clientMutex.RUnlock()
+
+	notice := InfoResponse{
+		Type:      "info",
+		Message:   message,
+		Severity:  severity,
+		Timestamp: ps.clock.Now(),
+	}
+	resp := BroadcastResponse{Status: "sent"}
+	for _, client := range clients {
+		if !client.IsConnected() {
+			continue
+		}
+		if err := client.SendMessage(notice); err != nil {
+			log.Printf("Error sending broadcast to client %s: %v", client.GetClientID(), err)
+			resp.Failed++
+			continue
+		}
+		resp.Reached++
+	}
+
+	if closeAfter > 0 {
+		closeAt := notice.Timestamp.Add(closeAfter)
+		resp.CloseAt = &closeAt
+		ps.clock.AfterFunc(closeAfter, func() { ps.closeClients(clients, message) })
+	}
+	return resp, nil
+}
+
+// closeClients gracefully closes the clients that are still registered
+func (ps *PubSubSystem) closeClients(clients []ClientInterface, reason string) {
+	for _, client := range clients {
+		ps.clientMutex.RLock()
+		current, registered := ps.clients[client.GetClientID()]
+		ps.clientMutex.RUnlock()
+		if !registered || current != client {
+			continue
+		}
+		if closer, ok := client.(GracefulCloser); ok {
+			closer.CloseGracefully(reason)
+		}
+	}
+}
+
+// CloseAllClients gracefully closes every connected client, e.g. when the
+// server shuts down. It doesn't wait for the connections to end.
+func (ps *PubSubSystem) CloseAllClients(reason string) {
+	ps.clientMutex.RLock()
+	clients := make([]ClientInterface, 0, len(ps.clients))
+	for _, client := range ps.clients {
+		clients = append(clients, client)
+	}
+	ps.clientMutex.RUnlock()
+	ps.closeClients(clients, reason)
+}
+
+// KickClient disconnects a client, telling it reason. Its last will is
+// published as for any unexpected disconnect. It reports whether the
+// client was connected on a transport that can kick it.
+func (ps *PubSubSystem) KickClient(clientID, reason string) bool {
+	ps.clientMutex.RLock()
+	client, registered := ps.clients[clientID]
+	ps.clientMutex.RUnlock()
+	kicker, ok := client.(Kicker)
+	if !registered || !ok {
+		return false
+	}
+	log.Printf("Kicking client %s: %s", clientID, reason)
+	kicker.Kick(reason)
+	return true
+}
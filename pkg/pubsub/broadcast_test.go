@@ -0,0 +1,103 @@
+package pubsub
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// closingClient is a recordingClient that records being closed gracefully
+type closingClient struct {
+	*recordingClient
+	closedWith atomic.Value // string
+}
+
+func (c *closingClient) CloseGracefully(reason string) {
+	c.closedWith.Store(reason)
+}
+
+// broadcasts returns the operator broadcasts sent to c
+func (c *recordingClient) broadcasts() []InfoResponse {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var notices []InfoResponse
+	for _, msg := range c.messages {
+		if info, ok := msg.(InfoResponse); ok && info.Severity != "" {
+			notices = append(notices, info)
+		}
+	}
+	return notices
+}
+
+func TestBroadcastReachesEveryClient(t *testing.T) {
+	ps := New()
+	dashboard, ledger := &recordingClient{id: "dashboard"}, &recordingClient{id: "ledger"}
+	for _, client := range []ClientInterface{dashboard, ledger, fullClient{id: "stalled"}} {
+		ps.RegisterClient(client)
+	}
+
+	// Subscriptions don't matter, and a stalled client is counted rather
+	// than waited for
+	resp, err := ps.Broadcast("moving to the other region in 5 minutes", SeverityWarning, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != "sent" || resp.Reached != 2 || resp.Failed != 1 || resp.CloseAt != nil {
+		t.Errorf("broadcast = %+v", resp)
+	}
+	for _, client := range []*recordingClient{dashboard, ledger} {
+		notices := client.broadcasts()
+		if len(notices) != 1 || notices[0].Message != "moving to the other region in 5 minutes" || notices[0].Severity != SeverityWarning {
+			t.Errorf("%s got %+v", client.id, notices)
+		}
+	}
+
+	// Info is the default severity
+	if _, err := ps.Broadcast("hello", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	if notices := dashboard.broadcasts(); len(notices) != 2 || notices[1].Severity != SeverityInfo {
+		t.Errorf("dashboard got %+v", notices)
+	}
+}
+
+func TestBroadcastValidation(t *testing.T) {
+	ps := New()
+	for name, call := range map[string]func() error{
+		"no message":        func() error { _, err := ps.Broadcast("", SeverityInfo, 0); return err },
+		"unknown severity":  func() error { _, err := ps.Broadcast("hi", "urgent", 0); return err },
+		"negative deadline": func() error { _, err := ps.Broadcast("hi", SeverityInfo, -time.Second); return err },
+	} {
+		if err := call(); !errors.Is(err, ErrInvalidBroadcast) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestBroadcastClosesAfterDelay(t *testing.T) {
+	ps := New()
+	staying := &closingClient{recordingClient: &recordingClient{id: "staying"}}
+	leaving := &closingClient{recordingClient: &recordingClient{id: "leaving"}}
+	ps.RegisterClient(staying)
+	ps.RegisterClient(leaving)
+
+	resp, err := ps.Broadcast("maintenance", SeverityCritical, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.CloseAt == nil || resp.CloseAt.Before(time.Now()) {
+		t.Errorf("broadcast closes at %v", resp.CloseAt)
+	}
+	// A client gone before the deadline isn't closed again
+	ps.UnregisterClient(leaving.id)
+
+	waitFor(t, "the graceful close", func() bool { return staying.closedWith.Load() != nil })
+	if reason := staying.closedWith.Load(); reason != "maintenance" {
+		t.Errorf("closed with %v", reason)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if reason := leaving.closedWith.Load(); reason != nil {
+		t.Errorf("client that left was closed with %v", reason)
+	}
+}
@@ -0,0 +1,73 @@
+package pubsub
+
+// bufferStats sums the ring buffers the broker holds: topic histories,
+// direct inboxes and paused subscriptions
+func (ps *PubSubSystem) bufferStats() *BufferUsageStats {
+	stats := &BufferUsageStats{}
+	ps.topics.each(func(topic *Topic) {
+		topic.mutex.RLock()
+		stats.TopicHistory.Add(topic.MessageHistory.Stats())
+		for _, subscriber := range topic.Subscribers {
+			if subscriber.paused != nil {
+				stats.PausedSubscriptions.Add(subscriber.paused.events.Stats())
+			}
+		}
+		topic.mutex.RUnlock()
+	})
+
+	ps.direct.mutex.Lock()
+	for _, inbox := range ps.direct.byClient {
+		stats.DirectInboxes.Add(inbox.Stats())
+	}
+	ps.direct.mutex.Unlock()
+	return stats
+}
+
+// ClientBufferStats returns the ring buffers holding events for clientID
+// in the broker: its direct inbox and paused subscriptions
+func (ps *PubSubSystem) ClientBufferStats(clientID string) ClientBufferStats {
+	var stats ClientBufferStats
+	ps.direct.mutex.Lock()
+	if inbox, exists := ps.direct.byClient[clientID]; exists {
+		inboxStats := inbox.Stats()
+		stats.DirectInbox = &inboxStats
+	}
+	ps.direct.mutex.Unlock()
+
+	for _, name := range ps.GetClientTopics(clientID) {
+		topic, exists := ps.topics.get(name)
+		if !exists {
+			continue
+		}
+		topic.mutex.RLock()
+		if subscriber, subscribed := topic.Subscribers[clientID]; subscribed && subscriber.paused != nil {
+			if stats.Paused == nil {
+				stats.Paused = make(map[string]RingBufferStats)
+			}
+			stats.Paused[name] = subscriber.paused.events.Stats()
+		}
+		topic.mutex.RUnlock()
+	}
+	return stats
+}
+
+// ResetBufferHighWatermarks starts the high watermark of every topic
+// history, direct inbox and paused subscription over from its current size
+func (ps *PubSubSystem) ResetBufferHighWatermarks() {
+	ps.topics.each(func(topic *Topic) {
+		topic.mutex.RLock()
+		topic.MessageHistory.ResetHighWatermark()
+		for _, subscriber := range topic.Subscribers {
+			if subscriber.paused != nil {
+				subscriber.paused.events.ResetHighWatermark()
+			}
+		}
+		topic.mutex.RUnlock()
+	})
+
+	ps.direct.mutex.Lock()
+	for _, inbox := range ps.direct.byClient {
+		inbox.ResetHighWatermark()
+	}
+	ps.direct.mutex.Unlock()
+}
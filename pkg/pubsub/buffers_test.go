@@ -0,0 +1,45 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBufferStatsAggregate(t *testing.T) {
+	ps := New()
+	for _, name := range []string{"orders", "alerts"} {
+		if err := ps.CreateTopic(context.Background(), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publishN(t, ps, "orders", 4)
+	publishN(t, ps, "alerts", 2)
+	if _, err := ps.SendDirect(context.Background(), "bob", "alice", MessageData{ID: "d1", Payload: "hi"}, DirectOptions{Hold: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := ps.GetStats()
+	if got, want := stats.Buffers.TopicHistory, (BufferStats{Buffers: 2, Capacity: 2 * TopicHistoryBufferSize, Size: 6, Pushes: 6, HighWatermark: 4}); got != want {
+		t.Errorf("topic histories = %+v, want %+v", got, want)
+	}
+	if got := stats.Topics["alerts"].History; got.Size != 2 || got.HighWatermark != 2 {
+		t.Errorf("alerts history = %+v", got)
+	}
+	if got := stats.Buffers.DirectInboxes; got.Buffers != 1 || got.Size != 1 {
+		t.Errorf("direct inboxes = %+v", got)
+	}
+	if inbox := ps.ClientBufferStats("alice").DirectInbox; inbox == nil || inbox.Pushes != 1 {
+		t.Errorf("alice's inbox = %+v", inbox)
+	}
+	if ps.GetNamespaceStats("billing").Buffers != nil {
+		t.Error("namespace stats report the server's buffers")
+	}
+
+	if _, err := ps.PurgeTopicHistory("orders", ps.clock.Now().Add(1), 0); err != nil {
+		t.Fatal(err)
+	}
+	ps.ResetBufferHighWatermarks()
+	if got := ps.GetStats().Buffers.TopicHistory; got.HighWatermark != 2 || got.Pushes != 6 {
+		t.Errorf("topic histories after purging orders and resetting = %+v", got)
+	}
+}
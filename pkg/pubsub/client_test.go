@@ -0,0 +1,62 @@
+package pubsub
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClient is a connected client that records everything sent to it
+type testClient struct {
+	id string
+
+	mutex    sync.Mutex
+	messages []interface{}
+}
+
+func newTestClient(id string) *testClient {
+	return &testClient{id: id}
+}
+
+func (c *testClient) GetClientID() string      { return c.id }
+func (c *testClient) IsConnected() bool        { return true }
+func (c *testClient) GetLastActive() time.Time { return time.Now() }
+
+func (c *testClient) SendMessage(msg interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.messages = append(c.messages, msg)
+	return nil
+}
+
+// events returns the events sent so far, unwrapping shared fan-out events
+func (c *testClient) events() []EventResponse {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var events []EventResponse
+	for _, msg := range c.messages {
+		switch m := msg.(type) {
+		case EventResponse:
+			events = append(events, m)
+		case *PreparedEvent:
+			events = append(events, m.Event)
+		}
+	}
+	return events
+}
+
+// waitEvents waits until at least n events have been sent and returns them
+func (c *testClient) waitEvents(t *testing.T, n int) []EventResponse {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		events := c.events()
+		if len(events) >= n {
+			return events
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("client %s got %d events, want %d", c.id, len(events), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,112 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MsgpackSubprotocol is the websocket subprotocol that selects MessagePack framing
+const MsgpackSubprotocol = "pubsub-msgpack"
+
+// Codec encodes and decodes protocol messages for one wire format
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+
+	// Binary reports whether messages are sent as binary rather than text frames
+	Binary() bool
+}
+
+// jsonCodec is the default text protocol
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Binary() bool { return false }
+
+// msgpackCodec carries the same message structs as MessagePack binary frames.
+// Field names follow the json tags so both codecs share one schema, and
+// []byte payloads travel as native bin values instead of base64.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.SetOmitEmpty(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+func (msgpackCodec) Binary() bool { return true }
+
+var (
+	// JSONCodec is the default text wire format
+	JSONCodec Codec = jsonCodec{}
+
+	// MsgpackCodec is the MessagePack binary wire format
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+// PreparedEvent is a published event shared by every subscriber of a
+// publish. Each wire format is encoded at most once and the bytes reused,
+// so fan-out to N clients doesn't marshal the same event N times.
+type PreparedEvent struct {
+	Event EventResponse
+
+	mutex   sync.Mutex
+	encoded []encodedEvent
+}
+
+// encodedEvent caches one codec's encoding of a PreparedEvent
+type encodedEvent struct {
+	codec Codec
+	data  []byte
+}
+
+// NewPreparedEvent wraps an event for shared delivery
+func NewPreparedEvent(event EventResponse) *PreparedEvent {
+	return &PreparedEvent{Event: event}
+}
+
+// Encode returns the event encoded with codec. The returned bytes are
+// shared between callers and must not be modified.
+func (pe *PreparedEvent) Encode(codec Codec) ([]byte, error) {
+	pe.mutex.Lock()
+	defer pe.mutex.Unlock()
+
+	for _, enc := range pe.encoded {
+		if enc.codec == codec {
+			return enc.data, nil
+		}
+	}
+
+	data, err := codec.Marshal(LocalizeMessage(pe.Event))
+	if err != nil {
+		return nil, err
+	}
+	pe.encoded = append(pe.encoded, encodedEvent{codec: codec, data: data})
+	return data, nil
+}
@@ -0,0 +1,203 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// encodingClient encodes every event it is sent the way a websocket
+// client's write pump does, and keeps nothing
+type encodingClient struct {
+	id    string
+	codec Codec
+}
+
+func (c encodingClient) GetClientID() string      { return c.id }
+func (c encodingClient) IsConnected() bool        { return true }
+func (c encodingClient) GetLastActive() time.Time { return time.Time{} }
+
+func (c encodingClient) SendMessage(msg interface{}) error {
+	if prepared, ok := msg.(*PreparedEvent); ok {
+		_, err := prepared.Encode(c.codec)
+		return err
+	}
+	return nil
+}
+
+// fanOutTopic creates "orders" with n encoding subscribers
+func fanOutTopic(tb testing.TB, n int) *PubSubSystem {
+	tb.Helper()
+	ps := New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		tb.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		client := encodingClient{id: fmt.Sprintf("c%d", i), codec: JSONCodec}
+		ps.RegisterClient(client)
+		if _, err := ps.Subscribe(context.Background(), client.id, "orders", 0, client); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return ps
+}
+
+func TestPreparedEventIsSharedAndEncodedOnce(t *testing.T) {
+	ps := New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	clients := []*recordingClient{{id: "a"}, {id: "b"}}
+	for _, client := range clients {
+		ps.RegisterClient(client)
+		if _, err := ps.Subscribe(context.Background(), client.id, "orders", 0, client); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publishN(t, ps, "orders", 1)
+	if len(clients[0].received()) != 1 || len(clients[1].received()) != 1 {
+		t.Fatal("the subscribers weren't sent the event")
+	}
+
+	first, ok := clients[0].messages[0].(*PreparedEvent)
+	if !ok || clients[1].messages[0] != first {
+		t.Fatalf("subscribers got %T and %T, not one shared event", clients[0].messages[0], clients[1].messages[0])
+	}
+
+	// Concurrent writers share one encoding per codec
+	var wg sync.WaitGroup
+	encodings := make([][]byte, 8)
+	for i := range encodings {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codec := JSONCodec
+			if i%2 == 1 {
+				codec = MsgpackCodec
+			}
+			data, err := first.Encode(codec)
+			if err != nil {
+				t.Error(err)
+			}
+			encodings[i] = data
+		}(i)
+	}
+	wg.Wait()
+	for i := 2; i < len(encodings); i++ {
+		if &encodings[i][0] != &encodings[i%2][0] {
+			t.Errorf("encoding %d was marshalled again", i)
+		}
+	}
+	if string(encodings[0]) == string(encodings[1]) {
+		t.Error("JSON and MessagePack encodings are the same")
+	}
+}
+
+func TestFanOutAllocationsDoNotGrowPerSubscriber(t *testing.T) {
+	allocs := func(subscribers int) float64 {
+		ps := fanOutTopic(t, subscribers)
+		msg := MessageData{ID: "m", Payload: map[string]interface{}{"n": 1}}
+		return testing.AllocsPerRun(50, func() {
+			ps.Publish(context.Background(), "orders", msg, "")
+		})
+	}
+	few, many := allocs(1), allocs(500)
+	// Well under one allocation per extra subscriber
+	if perSubscriber := (many - few) / 499; perSubscriber > 0.1 {
+		t.Errorf("%.1f allocations per publish to 1 subscriber, %.1f to 500: %.2f per subscriber", few, many, perSubscriber)
+	}
+}
+
+func BenchmarkPublishFanOut(b *testing.B) {
+	for _, n := range []int{1, 100, 1000} {
+		b.Run(fmt.Sprintf("subscribers=%d", n), func(b *testing.B) {
+			ps := fanOutTopic(b, n)
+			msg := MessageData{ID: "m", Payload: map[string]interface{}{"n": 1, "items": []string{"a", "b"}}}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := ps.Publish(context.Background(), "orders", msg, ""); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestPooledHistoryIsNotShared checks that replays read through pooled
+// scratch slices are the subscriber's own: later replays reusing the
+// slices, concurrently with publishes, never change an earlier result
+func TestPooledHistoryIsNotShared(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	publishKinds := func(from, n int) {
+		for i := from; i < from+n; i++ {
+			kind := []string{"even", "odd"}[i%2]
+			msg := MessageData{ID: fmt.Sprint(i), Payload: map[string]interface{}{"n": i, "kind": kind}}
+			if err := ps.Publish(ctx, "orders", msg, ""); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}
+	publishKinds(0, 100)
+
+	// A filtered last_n replay is served from a scratch slice
+	subscribe := func(id, kind string, lastN int) []EventResponse {
+		client := newTestClient(id)
+		ps.RegisterClient(client)
+		filter := &Filter{FilterPredicate: FilterPredicate{Path: "kind", Op: FilterOpEq, Value: kind}}
+		replay, err := ps.SubscribeWithOptions(ctx, id, "orders", SubscribeOptions{LastN: lastN, Filter: filter}, client)
+		if err != nil {
+			t.Error(err)
+		}
+		return replay
+	}
+	first := subscribe("first", "even", 5)
+	want := fmt.Sprint(seqs(first))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		publishKinds(100, 200)
+	}()
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				kind := []string{"even", "odd"}[(w+i)%2]
+				replay := subscribe(fmt.Sprintf("reader-%d-%d", w, i), kind, 10)
+				for _, event := range replay {
+					payload := event.Message.Payload.(map[string]interface{})
+					if payload["kind"] != kind || event.Message.ID != fmt.Sprint(event.Seq-1) {
+						t.Errorf("replay for %s holds %+v", kind, event)
+						return
+					}
+				}
+				// Catch-up replays go through scratch slices too
+				client := newTestClient(fmt.Sprintf("since-%d-%d", w, i))
+				ps.RegisterClient(client)
+				if _, err := ps.SubscribeWithOptions(ctx, client.id, "orders", SubscribeOptions{SinceSeq: int64(90 + i)}, client); err != nil {
+					t.Error(err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if got := fmt.Sprint(seqs(first)); got != want {
+		t.Errorf("first replay changed from %s to %s", want, got)
+	}
+	for i, event := range first {
+		if payload := event.Message.Payload.(map[string]interface{}); payload["kind"] != "even" || event.Seq != int64(91+2*i) {
+			t.Errorf("first replay event %d = %+v", i, event)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package pubsub
+
+// Compacted topics keep only the newest event per compact key in their
+// history, so replaying a state topic (prices, device status) delivers
+// each key's current value rather than every update. Events without a key
+// are kept like in any other topic. Live delivery is unaffected.
+
+// SetCompacted turns key compaction on or off. Turning it on drops every
+// held event superseded by a newer one with the same key; returns the
+// number of events removed.
+func (eb *EventBuffer) SetCompacted(compacted bool) int {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	if !compacted {
+		eb.keys = nil
+		return 0
+	}
+	if eb.keys != nil {
+		return 0
+	}
+
+	kept := compactEvents(eb.copyRange(0, eb.size))
+	removed := eb.size - len(kept)
+	eb.dropOldest(eb.size)
+	eb.keys = make(map[string]int64)
+	for _, event := range kept {
+		eb.push(event)
+		if event.CompactKey != "" {
+			eb.keys[event.CompactKey] = event.Seq
+		}
+	}
+	return removed
+}
+
+// Compacted reports whether the buffer keeps only the newest event per key
+func (eb *EventBuffer) Compacted() bool {
+	eb.mutex.RLock()
+	defer eb.mutex.RUnlock()
+	return eb.keys != nil
+}
+
+// supersedeLocked removes the event that event replaces, if the buffer is
+// compacted and holds one with its key. Superseded events aren't counted
+// as dropped: a replay that skips them has missed nothing current.
+// Callers must hold the mutex.
+func (eb *EventBuffer) supersedeLocked(event EventResponse) {
+	if eb.keys == nil || event.CompactKey == "" {
+		return
+	}
+	if seq, exists := eb.keys[event.CompactKey]; exists {
+		if i := eb.searchSeq(seq); i < eb.size && eb.buffer[(eb.tail+i)%eb.capacity].Seq == seq {
+			eb.removeAt(i)
+		}
+	}
+	eb.keys[event.CompactKey] = event.Seq
+}
+
+// unindexLocked forgets event's key if event is still its newest. Callers
+// must hold the mutex.
+func (eb *EventBuffer) unindexLocked(event EventResponse) {
+	if eb.keys != nil && event.CompactKey != "" && eb.keys[event.CompactKey] == event.Seq {
+		delete(eb.keys, event.CompactKey)
+	}
+}
+
+// compactEvents returns events, in order, without those superseded by a
+// later event with the same compact key
+func compactEvents(events []EventResponse) []EventResponse {
+	latest := make(map[string]int64)
+	for _, event := range events {
+		if event.CompactKey != "" {
+			latest[event.CompactKey] = event.Seq
+		}
+	}
+	kept := events[:0:0]
+	for _, event := range events {
+		if event.CompactKey == "" || latest[event.CompactKey] == event.Seq {
+			kept = append(kept, event)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,149 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// publishKeyed publishes payload to topic under a compact key
+func publishKeyed(t *testing.T, ps *PubSubSystem, topic, key string, payload interface{}) {
+	t.Helper()
+	id := fmt.Sprintf("%s=%v", key, payload)
+	if err := ps.PublishWithOptions(context.Background(), topic, MessageData{ID: id, Payload: payload}, "", PublishOptions{CompactKey: key}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+}
+
+// messageIDs returns the message IDs of events, in order
+func messageIDs(events []EventResponse) []string {
+	ids := make([]string, len(events))
+	for i, event := range events {
+		ids[i] = event.Message.ID
+	}
+	return ids
+}
+
+func TestCompactedTopicReplaysLatestPerKey(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopicWithConfig(ctx, "prices", TopicConfig{Compacted: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateTopic(ctx, "trades"); err != nil {
+		t.Fatal(err)
+	}
+	live := &recordingClient{id: "live"}
+	subscribeClient(t, ps, "prices", live)
+
+	for i := 1; i <= 4; i++ {
+		for _, key := range []string{"AAPL", "GOOG", "MSFT"} {
+			publishKeyed(t, ps, "prices", key, i)
+			publishKeyed(t, ps, "trades", key, i)
+		}
+	}
+	publishKeyed(t, ps, "prices", "GOOG", 5)
+
+	// Live delivery sees every update
+	if events := live.waitEvents(t, 13); len(events) != 13 {
+		t.Errorf("live subscriber got %d events, want 13", len(events))
+	}
+
+	// Replay gets the three latest, in publish order
+	late := &recordingClient{id: "late"}
+	ps.RegisterClient(late)
+	history, err := ps.Subscribe(ctx, "late", "prices", 100, late)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(messageIDs(history)); got != "[AAPL=4 MSFT=4 GOOG=5]" {
+		t.Errorf("compacted replay = %s", got)
+	}
+	if !equalSeqs(history, 10, 12, 13) {
+		t.Errorf("compacted replay seqs = %v", seqs(history))
+	}
+
+	// Other topics keep every update
+	history, _ = ps.Subscribe(ctx, "late", "trades", 100, late)
+	if len(history) != 12 {
+		t.Errorf("uncompacted replay has %d events, want 12", len(history))
+	}
+	if detail, _ := ps.GetTopicDetail("prices"); !detail.Compacted || detail.HistoryCount != 3 || detail.LatestSeq != 13 {
+		t.Errorf("detail = compacted %v, %d held, latest seq %d", detail.Compacted, detail.HistoryCount, detail.LatestSeq)
+	}
+}
+
+func TestEventBufferCompaction(t *testing.T) {
+	eb := NewEventBuffer(4)
+	push := func(seq int64, key string) {
+		eb.Push(EventResponse{Seq: seq, CompactKey: key, Message: MessageData{ID: fmt.Sprintf("%s%d", key, seq)}})
+	}
+
+	// Turning compaction on drops what is already superseded
+	push(1, "a")
+	push(2, "b")
+	push(3, "a")
+	if removed := eb.SetCompacted(true); removed != 1 || !equalSeqs(eb.GetAll(), 2, 3) {
+		t.Fatalf("SetCompacted removed %d, left %v", removed, seqs(eb.GetAll()))
+	}
+
+	// Keyless events are kept; an update frees its key's old slot
+	push(4, "")
+	push(5, "b")
+	push(6, "c")
+	if !equalSeqs(eb.GetAll(), 3, 4, 5, 6) {
+		t.Fatalf("held %v, want 3 4 5 6", seqs(eb.GetAll()))
+	}
+	if events, evicted := eb.GetAfterSeq(2, 0); evicted || !equalSeqs(events, 3, 4, 5, 6) {
+		t.Errorf("after seq 2 = %v, evicted %v; superseded events aren't evictions", seqs(events), evicted)
+	}
+
+	// Once a key's event is overwritten, a new one for it evicts nothing
+	push(7, "d")
+	push(8, "a")
+	if !equalSeqs(eb.GetAll(), 5, 6, 7, 8) {
+		t.Errorf("held %v, want 5 6 7 8", seqs(eb.GetAll()))
+	}
+	eb.Clear()
+	if len(eb.keys) != 0 {
+		t.Errorf("cleared buffer still indexes %v", eb.keys)
+	}
+}
+
+func TestCompactedHistoryFileKeepsEveryKey(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenHistoryStore(dir, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.historySize = 4
+	ps := New()
+	if err := ps.EnablePersistence(store); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateTopicWithConfig(context.Background(), "devices", TopicConfig{Compacted: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is trimmed to four lines several times over, without losing
+	// the one early key
+	publishKeyed(t, ps, "devices", "door", "open")
+	for i := 0; i < 20; i++ {
+		publishKeyed(t, ps, "devices", "thermostat", i)
+	}
+	ps.Close()
+
+	ps = openStore(t, dir)
+	defer ps.Close()
+	events, _, err := ps.GetTopicMessages("devices", 0, 0, 10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(messageIDs(events)); got != "[door=open thermostat=19]" {
+		t.Errorf("restored history = %s", got)
+	}
+	if detail, _ := ps.GetTopicDetail("devices"); !detail.Compacted {
+		t.Error("restored topic isn't compacted")
+	}
+}
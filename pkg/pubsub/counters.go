@@ -0,0 +1,95 @@
+package pubsub
+
+import "sync/atomic"
+
+// CounterSnapshot returns every counter with the time it was read, for
+// tooling that diffs successive snapshots
+func (ps *PubSubSystem) CounterSnapshot() CounterSnapshot {
+	return ps.readCounters(false)
+}
+
+// ResetCounters zeroes the resettable counters, leaving gauges such as
+// subscriber and connection counts alone, and returns them as they were.
+// Each counter is swapped for zero on its own, so a publish racing the
+// reset is counted either in the returned snapshot or after the reset,
+// never in both or neither.
+func (ps *PubSubSystem) ResetCounters() CounterSnapshot {
+	return ps.readCounters(true)
+}
+
+// readCounters reads the counters, zeroing them if reset is set
+func (ps *PubSubSystem) readCounters(reset bool) CounterSnapshot {
+	ps.countersMutex.Lock()
+	defer ps.countersMutex.Unlock()
+
+	take := func(counter *atomic.Int64) int64 {
+		if reset {
+			return counter.Swap(0)
+		}
+		return counter.Load()
+	}
+	window := func(counter *slidingCounter) int64 {
+		if reset {
+			return counter.Take()
+		}
+		return counter.Sum()
+	}
+
+	now := ps.clock.Now()
+	snapshot := CounterSnapshot{
+		Timestamp: now,
+		Since:     ps.countersSince,
+		Topics:    make(map[string]TopicCounters),
+		WebSocket: WebSocketTrafficStats{
+			PayloadBytes:       take(&ps.wsTraffic.PayloadBytes),
+			WireBytes:          take(&ps.wsTraffic.WireBytes),
+			CompressedMessages: take(&ps.wsTraffic.CompressedMessages),
+			OriginRejections:   take(&ps.wsTraffic.OriginRejections),
+			LimitRejections:    take(&ps.wsTraffic.LimitRejections),
+			IPLimitRejections:  take(&ps.wsTraffic.IPLimitRejections),
+			RepeatedRequests:   take(&ps.wsTraffic.RepeatedRequests),
+			ErrorDisconnects:   take(&ps.wsTraffic.ErrorDisconnects),
+			ExpiredUploads:     take(&ps.wsTraffic.ExpiredUploads),
+			UnackedPublishes:   take(&ps.wsTraffic.UnackedPublishes),
+			BatchedPublishes:   take(&ps.wsTraffic.BatchedPublishes),
+			BatchAcks:          take(&ps.wsTraffic.BatchAcks),
+			Connections:        ps.connections.Load(),
+		},
+		HTTP: HTTPTrafficStats{
+			BodyTooLarge: take(&ps.httpTraffic.BodyTooLarge),
+			RateLimited:  take(&ps.httpTraffic.RateLimited),
+		},
+		DeliveriesLastMinute: window(ps.deliveries),
+		DroppedLastMinute:    window(ps.drops),
+	}
+
+	ps.topics.each(func(topic *Topic) {
+		// Publishes count under the write lock, so holding it makes the
+		// topic's message counts and zeroing them one step
+		topic.mutex.Lock()
+		counters := TopicCounters{
+			Messages:              topic.MessageCount,
+			Ephemeral:             topic.EphemeralCount,
+			SlowConsumers:         topic.SlowConsumerUnsubscribes,
+			BacklogEvictions:      topic.BacklogEvictions,
+			Subscribers:           len(topic.Subscribers),
+			BytesIn:               take(&topic.activity.bytesIn),
+			BytesOut:              take(&topic.activity.bytesOut),
+			PublishedLastMinute:   window(topic.activity.rate1m),
+			PublishedLast5Minutes: window(topic.activity.rate5m),
+		}
+		if reset {
+			topic.MessageCount = 0
+			topic.EphemeralCount = 0
+			topic.SlowConsumerUnsubscribes = 0
+			topic.BacklogEvictions = 0
+		}
+		topic.mutex.Unlock()
+		snapshot.Topics[topic.Name] = counters
+	})
+
+	if reset {
+		ps.countersSince = now
+	}
+	return snapshot
+}
@@ -0,0 +1,57 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestResetCountersUnderConcurrentPublishes(t *testing.T) {
+	ps := New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.Subscribe(context.Background(), "c1", "orders", 0, newTestClient("c1")); err != nil {
+		t.Fatal(err)
+	}
+
+	const publishers, each = 4, 500
+	var wg sync.WaitGroup
+	for p := 0; p < publishers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				ps.Publish(context.Background(), "orders", MessageData{ID: fmt.Sprintf("%d-%d", p, i)}, "")
+			}
+		}(p)
+	}
+
+	// Every publish lands in exactly one reset's snapshot or the final one
+	var messages, published int64
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		counters := ps.ResetCounters().Topics["orders"]
+		messages += counters.Messages
+		published += counters.PublishedLastMinute
+		if counters.Subscribers != 1 {
+			t.Fatalf("subscribers = %d, want the gauge left alone", counters.Subscribers)
+		}
+	}
+	final := ps.CounterSnapshot().Topics["orders"]
+	messages += final.Messages
+	published += final.PublishedLastMinute
+	if messages != publishers*each || published != publishers*each {
+		t.Errorf("counted %d messages and %d in the rate window, want %d", messages, published, publishers*each)
+	}
+}
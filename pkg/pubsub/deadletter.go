@@ -0,0 +1,169 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DeadLetterBufferEvicted   = "buffer_evicted"           // Dropped because the client's buffer was full
+	DeadLetterTTLExpired      = "ttl_expired"              // Still buffered when the subscription expired
+	DeadLetterSlowConsumer    = "slow_consumer_disconnect" // Still queued when the client disconnected
+	DeadLetterBacklogOverflow = "backlog_overflow"         // Evicted from a paused topic's full backlog
+
+	// Dead letters waiting to be republished; more are dropped with a log line
+	deadLetterQueueSize = 1024
+)
+
+// ErrInvalidDeadLetterTopic is returned for a dead-letter topic that is
+// missing or would form a loop
+var ErrInvalidDeadLetterTopic = errors.New("invalid dead-letter topic")
+
+// DeadLetterEnvelope is the payload of events republished to a dead-letter
+// topic
+type DeadLetterEnvelope struct {
+	OriginalTopic  string      `json:"original_topic"`
+	OriginalSeq    int64       `json:"original_seq"`
+	ClientID       string      `json:"client_id"`
+	Reason         string      `json:"reason"`
+	Message        MessageData `json:"message"`
+	PublishedAt    time.Time   `json:"published_at"`
+	DeadLetteredAt time.Time   `json:"dead_lettered_at"`
+}
+
+// deadLetter is an undelivered event waiting to be republished
+type deadLetter struct {
+	event    EventResponse
+	clientID string
+	reason   string
+	at       time.Time
+}
+
+// DeadLetter reports that event could not be delivered to clientID. If the
+// event's topic has a dead-letter topic, the event is republished there in
+// a DeadLetterEnvelope. It never blocks, so transports may call it from
+// SendMessage; called elsewhere it runs OnDrop hooks before returning.
+func (ps *PubSubSystem) DeadLetter(event EventResponse, clientID, reason string) {
+	if event.Type != "event" {
+		return
+	}
+	ps.emit(hookEvent{kind: hookDrop, topic: event.Topic, clientID: clientID, reason: reason})
+
+	// Dead letters are never dead-lettered again
+	if _, ok := event.Message.Payload.(DeadLetterEnvelope); ok {
+		return
+	}
+
+	select {
+	case ps.deadLetters <- deadLetter{event: event, clientID: clientID, reason: reason, at: ps.clock.Now()}:
+	default:
+		log.Printf("Dead-letter queue is full, dropping %s event seq %d for client %s", event.Topic, event.Seq, clientID)
+	}
+}
+
+// deadLetterLoop republishes undelivered events to their dead-letter topics
+func (ps *PubSubSystem) deadLetterLoop() {
+	for dl := range ps.deadLetters {
+		topic, exists := ps.topics.get(dl.event.Topic)
+		if !exists {
+			continue
+		}
+		topic.mutex.RLock()
+		dlqName := topic.DeadLetterTopic
+		topic.mutex.RUnlock()
+		if dlqName == "" {
+			continue
+		}
+
+		dlq, exists := ps.topics.get(dlqName)
+		if !exists {
+			log.Printf("Dead-letter topic %s for %s no longer exists", dlqName, dl.event.Topic)
+			continue
+		}
+
+		envelope := DeadLetterEnvelope{
+			OriginalTopic:  LocalTopic(dl.event.Topic),
+			OriginalSeq:    dl.event.Seq,
+			ClientID:       dl.clientID,
+			Reason:         dl.reason,
+			Message:        dl.event.Message,
+			PublishedAt:    dl.event.Timestamp,
+			DeadLetteredAt: dl.at,
+		}
+		message := MessageData{ID: uuid.New().String(), Payload: envelope}
+		opts := PublishOptions{OrderingKey: dl.event.OrderingKey}
+		if _, err := ps.publishToTopic(context.Background(), dlq, message, ps.payloadSize(envelope), "", opts); err != nil {
+			log.Printf("Error dead-lettering %s event seq %d to %s: %v", dl.event.Topic, dl.event.Seq, dlqName, err)
+		}
+	}
+}
+
+// SetDeadLetterTopic changes where a topic's undelivered events go. An
+// empty dlq turns dead-lettering off.
+func (ps *PubSubSystem) SetDeadLetterTopic(ctx context.Context, name, dlq string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := checkUserTopic(name); err != nil {
+		return err
+	}
+
+	topic, exists := ps.topics.get(name)
+	if !exists {
+		return errorOf(ErrTopicNotFound, "topic %s not found", name)
+	}
+	if err := ps.checkDeadLetterTopic(name, dlq); err != nil {
+		return err
+	}
+
+	topic.mutex.Lock()
+	topic.DeadLetterTopic = dlq
+	config := topic.config()
+	createdAt := topic.CreatedAt
+	topic.mutex.Unlock()
+
+	if ps.store != nil {
+		ps.store.TopicCreated(name, createdAt, config)
+	}
+	return nil
+}
+
+// checkDeadLetterTopic verifies that dlq exists and that following
+// dead-letter topics from it never leads back to name. Callers must not
+// hold any topic shard lock.
+func (ps *PubSubSystem) checkDeadLetterTopic(name, dlq string) error {
+	if dlq == "" {
+		return nil
+	}
+	if dlq == name {
+		return fmt.Errorf("%w: topic %s cannot be its own dead-letter topic", ErrInvalidDeadLetterTopic, name)
+	}
+	if IsSystemTopic(dlq) {
+		return fmt.Errorf("%w: %s is a system topic", ErrInvalidDeadLetterTopic, dlq)
+	}
+
+	seen := map[string]bool{name: true}
+	for next := dlq; next != ""; {
+		if seen[next] {
+			return fmt.Errorf("%w: %s would loop back through %s", ErrInvalidDeadLetterTopic, dlq, next)
+		}
+		seen[next] = true
+
+		topic, exists := ps.topics.get(next)
+		if !exists {
+			if next == dlq {
+				return fmt.Errorf("%w: topic %s not found", ErrInvalidDeadLetterTopic, dlq)
+			}
+			return nil
+		}
+		topic.mutex.RLock()
+		next = topic.DeadLetterTopic
+		topic.mutex.RUnlock()
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fullClient is a connection whose buffer is always full, so every event
+// sent to it is evicted
+type fullClient struct{ id string }
+
+func (c fullClient) GetClientID() string           { return c.id }
+func (c fullClient) IsConnected() bool             { return true }
+func (c fullClient) GetLastActive() time.Time      { return time.Now() }
+func (c fullClient) SendMessage(interface{}) error { return errors.New("send buffer full") }
+
+func subscribeClient(t *testing.T, ps *PubSubSystem, topic string, client ClientInterface) {
+	t.Helper()
+	ps.RegisterClient(client)
+	if _, err := ps.Subscribe(context.Background(), client.GetClientID(), topic, 0, client); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeadLetterEnvelope(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "orders.dlq"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateTopicWithConfig(ctx, "orders", TopicConfig{DeadLetterTopic: "orders.dlq"}); err != nil {
+		t.Fatal(err)
+	}
+	watcher := &recordingClient{id: "watcher"}
+	subscribeClient(t, ps, "orders.dlq", watcher)
+	subscribeClient(t, ps, "orders", fullClient{id: "stuck"})
+	// Deliveries that succeed are not dead-lettered
+	subscribeClient(t, ps, "orders", &recordingClient{id: "fine"})
+
+	publishN(t, ps, "orders", 3)
+	// One dead letter per evicted event, in order
+	events := watcher.waitEvents(t, 3)
+	published := history(t, ps, "orders")
+	for i, event := range events {
+		envelope, ok := event.Message.Payload.(DeadLetterEnvelope)
+		if !ok {
+			t.Fatalf("dead letter %d has payload %T", i, event.Message.Payload)
+		}
+		original := published[i]
+		if envelope.OriginalTopic != "orders" || envelope.ClientID != "stuck" || envelope.Reason != DeadLetterBufferEvicted ||
+			envelope.OriginalSeq != original.Seq || envelope.Message.ID != original.Message.ID ||
+			!envelope.PublishedAt.Equal(original.Timestamp) || envelope.DeadLetteredAt.IsZero() {
+			t.Errorf("dead letter %d = %+v, for %+v", i, envelope, original)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got := len(watcher.received()); got != 3 {
+		t.Errorf("%d dead letters, want 3", got)
+	}
+}
+
+func TestDeadLettersAreNotDeadLetteredAgain(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	for _, name := range []string{"final", "orders.dlq", "orders"} {
+		if err := ps.CreateTopic(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ps.SetDeadLetterTopic(ctx, "orders.dlq", "final"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.SetDeadLetterTopic(ctx, "orders", "orders.dlq"); err != nil {
+		t.Fatal(err)
+	}
+	subscribeClient(t, ps, "orders", fullClient{id: "stuck"})
+	subscribeClient(t, ps, "orders.dlq", fullClient{id: "also-stuck"})
+
+	publishN(t, ps, "orders", 2)
+	waitFor(t, "the dead letters", func() bool { return len(history(t, ps, "orders.dlq")) == 2 })
+	time.Sleep(20 * time.Millisecond)
+	if got := history(t, ps, "final"); len(got) != 0 {
+		t.Errorf("dead letters were dead-lettered again: %+v", got)
+	}
+}
+
+func TestDeadLetterTopicLoopsRejected(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := ps.CreateTopic(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ps.SetDeadLetterTopic(ctx, "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.SetDeadLetterTopic(ctx, "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct{ topic, dlq string }{
+		{"c", "a"},       // c -> a -> b -> c
+		{"b", "a"},       // b -> a -> b
+		{"a", "a"},       // Its own
+		{"a", "missing"}, // Doesn't exist
+	} {
+		if err := ps.SetDeadLetterTopic(ctx, tc.topic, tc.dlq); !errors.Is(err, ErrInvalidDeadLetterTopic) {
+			t.Errorf("%s -> %s = %v", tc.topic, tc.dlq, err)
+		}
+	}
+	if err := ps.CreateTopicWithConfig(ctx, "d", TopicConfig{DeadLetterTopic: "d"}); !errors.Is(err, ErrInvalidDeadLetterTopic) {
+		t.Errorf("creating a topic that is its own dead-letter topic = %v", err)
+	}
+
+	// Turning it off always works
+	if err := ps.SetDeadLetterTopic(ctx, "a", ""); err != nil {
+		t.Errorf("clearing the dead-letter topic = %v", err)
+	}
+	if err := ps.SetDeadLetterTopic(ctx, "c", "a"); err != nil {
+		t.Errorf("c -> a once a no longer leads back = %v", err)
+	}
+}
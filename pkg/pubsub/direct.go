@@ -0,0 +1,178 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Outcomes of a direct message, reported to its sender
+const (
+	DirectDelivered = "delivered" // Handed to the target's connection
+	DirectBuffered  = "buffered"  // Held until the target next connects
+	DirectOffline   = "offline"   // Not connected and not held
+)
+
+const (
+	// DirectInboxSize is how many direct messages are held for a client
+	// that can't take them; the oldest are dropped past it
+	DirectInboxSize = 100
+
+	// MaxDirectInboxes is how many client IDs may have messages held at
+	// once
+	MaxDirectInboxes = 10000
+)
+
+var (
+	// ErrInvalidDirect is returned for a direct message without a target
+	// or message ID
+	ErrInvalidDirect = errors.New("invalid direct message")
+
+	// ErrTargetOverloaded is returned when the target's send buffer is full
+	// and the message wasn't to be held
+	ErrTargetOverloaded = errors.New("target client is overloaded")
+)
+
+// DirectOptions are the optional parts of a direct message
+type DirectOptions struct {
+	// Hold the message when the target is offline or its send buffer is
+	// full, until a connection next takes its ID
+	Hold bool
+
+	// Copied onto the event, to match a reply to its request
+	CorrelationID string
+}
+
+// DirectAuthorizer decides whether from may send direct messages to to.
+// Returning an error refuses the message with PERMISSION_DENIED.
+type DirectAuthorizer func(ctx context.Context, from, to string) error
+
+// directInboxes holds direct messages for clients that couldn't take them
+// and routes replies to the inboxes of requests waiting for one
+type directInboxes struct {
+	mutex      sync.Mutex
+	byClient   map[string]*RingBuffer[EventResponse]
+	replies    map[string]chan EventResponse // inbox -> its request's first reply
+	authorizer DirectAuthorizer
+}
+
+// SetDirectAuthorizer installs the check run before every direct message;
+// nil allows every client to message every other
+func (ps *PubSubSystem) SetDirectAuthorizer(authorizer DirectAuthorizer) {
+	ps.direct.mutex.Lock()
+	defer ps.direct.mutex.Unlock()
+	ps.direct.authorizer = authorizer
+}
+
+// SendDirect delivers a message to one client by its ID, outside any topic,
+// as a "direct" event naming the sender. A target that is offline, or whose
+// send buffer is full, can have it held with opts.Hold; it is delivered
+// when a connection next takes the target's ID. A target that is a request
+// inbox takes the message as its reply. Returns DirectDelivered,
+// DirectBuffered or DirectOffline.
+func (ps *PubSubSystem) SendDirect(ctx context.Context, from, to string, message MessageData, opts DirectOptions) (string, error) {
+	if to == "" || message.ID == "" {
+		return "", fmt.Errorf("%w: target client_id and message.id are required", ErrInvalidDirect)
+	}
+	if len(opts.CorrelationID) > MaxCorrelationLength {
+		return "", fmt.Errorf("%w: correlation_id must be at most %d bytes", ErrInvalidDirect, MaxCorrelationLength)
+	}
+	if _, err := ps.PayloadLimits().checkMessage(message); err != nil {
+		return "", err
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	ps.direct.mutex.Lock()
+	authorizer := ps.direct.authorizer
+	ps.direct.mutex.Unlock()
+	if authorizer != nil {
+		if err := authorizer(ctx, from, to); err != nil {
+			return "", fmt.Errorf("%w: %s may not message %s: %v", ErrPermissionDenied, from, to, err)
+		}
+	}
+
+	event := EventResponse{
+		Type:          "direct",
+		Message:       message,
+		From:          from,
+		CorrelationID: opts.CorrelationID,
+		Timestamp:     ps.clock.Now(),
+		Delivery:      &DeliveryInfo{Attempt: 1},
+	}
+	if strings.HasPrefix(to, InboxPrefix) {
+		if ps.deliverReply(to, event) {
+			return DirectDelivered, nil
+		}
+		return DirectOffline, nil
+	}
+
+	ps.clientMutex.RLock()
+	target, online := ps.clients[to]
+	ps.clientMutex.RUnlock()
+	if online && target.IsConnected() {
+		err := target.SendMessage(event)
+		if err == nil {
+			return DirectDelivered, nil
+		}
+		if !opts.Hold {
+			return "", fmt.Errorf("%w: %v", ErrTargetOverloaded, err)
+		}
+		// Delivering it from the inbox is the second try
+		event.Delivery = &DeliveryInfo{Redelivered: true, Attempt: 2}
+	}
+
+	if opts.Hold && ps.holdDirect(to, event) {
+		return DirectBuffered, nil
+	}
+	return DirectOffline, nil
+}
+
+// holdDirect keeps an event for a client, reporting false when too many
+// clients already have messages held
+func (ps *PubSubSystem) holdDirect(clientID string, event EventResponse) bool {
+	ps.direct.mutex.Lock()
+	defer ps.direct.mutex.Unlock()
+
+	inbox, exists := ps.direct.byClient[clientID]
+	if !exists {
+		if len(ps.direct.byClient) >= MaxDirectInboxes {
+			return false
+		}
+		inbox = NewRingBuffer[EventResponse](DirectInboxSize)
+		ps.direct.byClient[clientID] = inbox
+	}
+	inbox.PushEvict(event)
+	return true
+}
+
+// DeliverHeldDirect sends client the direct messages held for its ID, in
+// the order they were sent, and returns how many it took. Transports call
+// it once a connection has its final client ID.
+func (ps *PubSubSystem) DeliverHeldDirect(client ClientInterface) int {
+	clientID := client.GetClientID()
+	ps.direct.mutex.Lock()
+	inbox, exists := ps.direct.byClient[clientID]
+	delete(ps.direct.byClient, clientID)
+	ps.direct.mutex.Unlock()
+	if !exists {
+		return 0
+	}
+
+	delivered := 0
+	for {
+		event, ok := inbox.Pop()
+		if !ok {
+			return delivered
+		}
+		if err := client.SendMessage(event); err != nil {
+			log.Printf("Error delivering held direct message %s to client %s: %v", event.Message.ID, clientID, err)
+			continue
+		}
+		delivered++
+	}
+}
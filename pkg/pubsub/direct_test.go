@@ -0,0 +1,102 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// sendDirect sends a direct message from alice, failing the test on error
+func sendDirect(t *testing.T, ps *PubSubSystem, to, id string, hold bool) string {
+	t.Helper()
+	status, err := ps.SendDirect(context.Background(), "alice", to, MessageData{ID: id, Payload: "refresh"}, DirectOptions{Hold: hold})
+	if err != nil {
+		t.Fatalf("SendDirect to %s: %v", to, err)
+	}
+	return status
+}
+
+func TestDirectMessageToOnlineClient(t *testing.T) {
+	ps := New()
+	bob := &recordingClient{id: "bob"}
+	ps.RegisterClient(bob)
+
+	if status := sendDirect(t, ps, "bob", "m1", false); status != DirectDelivered {
+		t.Errorf("status = %s, want delivered", status)
+	}
+	events := bob.received()
+	if len(events) != 1 || events[0].Type != "direct" || events[0].From != "alice" || events[0].Message.ID != "m1" {
+		t.Errorf("bob received %+v", events)
+	}
+
+	if _, err := ps.SendDirect(context.Background(), "alice", "", MessageData{ID: "m2"}, DirectOptions{}); !errors.Is(err, ErrInvalidDirect) {
+		t.Errorf("message without a target: %v", err)
+	}
+}
+
+func TestDirectMessageToSlowClient(t *testing.T) {
+	ps := New()
+	ps.RegisterClient(fullClient{id: "bob"})
+
+	if _, err := ps.SendDirect(context.Background(), "alice", "bob", MessageData{ID: "m1"}, DirectOptions{}); !errors.Is(err, ErrTargetOverloaded) {
+		t.Errorf("send to a full buffer: %v", err)
+	}
+
+	// Held, it reaches the next connection to take the ID
+	if status := sendDirect(t, ps, "bob", "m2", true); status != DirectBuffered {
+		t.Errorf("status = %s, want buffered", status)
+	}
+	ps.UnregisterClient("bob")
+	bob := &recordingClient{id: "bob"}
+	ps.RegisterClient(bob)
+	if n := ps.DeliverHeldDirect(bob); n != 1 || bob.received()[0].Message.ID != "m2" {
+		t.Errorf("delivered %d held messages: %+v", n, bob.received())
+	}
+}
+
+func TestDirectMessageToOfflineClient(t *testing.T) {
+	ps := New()
+	if status := sendDirect(t, ps, "bob", "dropped", false); status != DirectOffline {
+		t.Errorf("status = %s, want offline", status)
+	}
+
+	// Only the newest are held, in the order they were sent
+	for i := 0; i < DirectInboxSize+2; i++ {
+		if status := sendDirect(t, ps, "bob", fmt.Sprintf("m%d", i), true); status != DirectBuffered {
+			t.Fatalf("status = %s, want buffered", status)
+		}
+	}
+	bob := &recordingClient{id: "bob"}
+	if n := ps.DeliverHeldDirect(bob); n != DirectInboxSize {
+		t.Fatalf("delivered %d held messages, want %d", n, DirectInboxSize)
+	}
+	if first := bob.received()[0].Message.ID; first != "m2" {
+		t.Errorf("first held message = %s, want m2", first)
+	}
+	if n := ps.DeliverHeldDirect(bob); n != 0 {
+		t.Errorf("held messages delivered twice: %d", n)
+	}
+}
+
+func TestDirectAuthorizer(t *testing.T) {
+	ps := New()
+	bob := &recordingClient{id: "bob"}
+	ps.RegisterClient(bob)
+	ps.SetDirectAuthorizer(func(ctx context.Context, from, to string) error {
+		if from != "admin" {
+			return errors.New("only admin sends direct messages")
+		}
+		return nil
+	})
+
+	if _, err := ps.SendDirect(context.Background(), "alice", "bob", MessageData{ID: "m1"}, DirectOptions{Hold: true}); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("unauthorized send: %v", err)
+	}
+	if status, err := ps.SendDirect(context.Background(), "admin", "bob", MessageData{ID: "m2"}, DirectOptions{}); err != nil || status != DirectDelivered {
+		t.Errorf("authorized send = %s, %v", status, err)
+	}
+	if events := bob.received(); len(events) != 1 || events[0].From != "admin" {
+		t.Errorf("bob received %+v", events)
+	}
+}
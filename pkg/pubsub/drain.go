@@ -0,0 +1,47 @@
+package pubsub
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrServerDraining is returned for new subscriptions while the server is
+// draining
+var ErrServerDraining = errors.New("server is draining")
+
+// Drain stops the server taking new work ahead of a restart: readiness
+// fails, transports refuse new connections and Subscribe returns
+// ErrServerDraining. Existing connections, subscriptions, publishes and
+// deliveries carry on. It reports whether the server was not draining yet.
+func (ps *PubSubSystem) Drain() bool {
+	if !ps.draining.CompareAndSwap(false, true) {
+		return false
+	}
+	ps.drainedAt.Store(ps.clock.Now().UnixNano())
+	return true
+}
+
+// Undrain takes new connections and subscriptions again. It reports whether
+// the server was draining.
+func (ps *PubSubSystem) Undrain() bool {
+	if !ps.draining.CompareAndSwap(true, false) {
+		return false
+	}
+	ps.drainedAt.Store(0)
+	return true
+}
+
+// IsDraining reports whether the server is draining
+func (ps *PubSubSystem) IsDraining() bool {
+	return ps.draining.Load()
+}
+
+// DrainState returns whether the server is draining and since when
+func (ps *PubSubSystem) DrainState() DrainResponse {
+	state := DrainResponse{Draining: ps.draining.Load()}
+	if at := ps.drainedAt.Load(); state.Draining && at != 0 {
+		since := time.Unix(0, at)
+		state.Since = &since
+	}
+	return state
+}
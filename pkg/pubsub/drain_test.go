@@ -0,0 +1,44 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDrainRefusesOnlyNewSubscriptions(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	veteran := &recordingClient{id: "veteran"}
+	subscribeClient(t, ps, "orders", veteran)
+
+	if !ps.Drain() || ps.Drain() || !ps.IsDraining() {
+		t.Error("Drain didn't report the change once")
+	}
+	latecomer := &recordingClient{id: "latecomer"}
+	ps.RegisterClient(latecomer)
+	_, err := ps.Subscribe(ctx, latecomer.id, "orders", 0, latecomer)
+	if !errors.Is(err, ErrServerDraining) {
+		t.Errorf("subscribing while draining = %v", err)
+	}
+	if err := ps.Publish(ctx, "orders", MessageData{ID: "m"}, ""); err != nil {
+		t.Errorf("publishing while draining = %v", err)
+	}
+	veteran.waitEvents(t, 1)
+	if readiness := ps.CheckReadiness(); readiness.Reason != "draining" {
+		t.Errorf("readiness while draining = %+v", readiness)
+	}
+
+	if !ps.Undrain() || ps.Undrain() || ps.IsDraining() {
+		t.Error("Undrain didn't report the change once")
+	}
+	if _, err := ps.Subscribe(ctx, latecomer.id, "orders", 0, latecomer); err != nil {
+		t.Errorf("subscribing after undraining = %v", err)
+	}
+	if state := ps.DrainState(); state.Draining || state.Since != nil {
+		t.Errorf("drain state after undraining = %+v", state)
+	}
+}
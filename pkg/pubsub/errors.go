@@ -0,0 +1,276 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Stable error codes. Every error frame carries one of them as its code, so
+// clients can branch on it; the reason, when set, narrows it down.
+const (
+	CodeTopicNotFound    = "TOPIC_NOT_FOUND"
+	CodeTopicExists      = "TOPIC_EXISTS"
+	CodeNotSubscribed    = "NOT_SUBSCRIBED"
+	CodeNotFound         = "NOT_FOUND"
+	CodePermissionDenied = "PERMISSION_DENIED"
+	CodeRateLimited      = "RATE_LIMITED"
+	CodePayloadTooLarge  = "PAYLOAD_TOO_LARGE"
+	CodeValidationFailed = "VALIDATION_FAILED"
+	CodeServerBusy       = "SERVER_BUSY"
+	CodeInternal         = "INTERNAL"
+)
+
+var (
+	// ErrTopicNotFound is returned for a topic that doesn't exist
+	ErrTopicNotFound = errors.New("topic not found")
+
+	// ErrTopicExists is returned when creating a topic that already exists
+	ErrTopicExists = errors.New("topic already exists")
+
+	// ErrNotSubscribed is returned for a subscription that doesn't exist
+	ErrNotSubscribed = errors.New("not subscribed")
+
+	// ErrInvalidRequest is returned for a request with a bad argument
+	ErrInvalidRequest = errors.New("invalid request")
+
+	// ErrNotFound is returned for a message, webhook, client or other
+	// resource that doesn't exist
+	ErrNotFound = errors.New("not found")
+)
+
+// errorCodes classifies the broker's errors, first match wins
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrTopicNotFound, CodeTopicNotFound},
+	{ErrTopicExists, CodeTopicExists},
+	{ErrNotSubscribed, CodeNotSubscribed},
+	{ErrNotFound, CodeNotFound},
+	{ErrNoSubscriptionTTL, CodeNotSubscribed},
+	{ErrPermissionDenied, CodePermissionDenied},
+	{ErrJoinRequired, CodePermissionDenied},
+	{ErrJoinDenied, CodePermissionDenied},
+	{ErrTopicArchived, CodePermissionDenied},
+	{ErrTopicLimit, CodePermissionDenied},
+	{ErrRateLimited, CodeRateLimited},
+	{ErrTopicRateLimited, CodeRateLimited},
+	{ErrServerDraining, CodeServerBusy},
+	{ErrTargetOverloaded, CodeServerBusy},
+	{ErrScheduleFull, CodeServerBusy},
+	{ErrRequestTimeout, CodeServerBusy},
+	{context.DeadlineExceeded, CodeServerBusy},
+	{ErrInvalidRequest, CodeValidationFailed},
+	{ErrInvalidFilter, CodeValidationFailed},
+	{ErrInvalidSchema, CodeValidationFailed},
+	{ErrInvalidSchedule, CodeValidationFailed},
+	{ErrScheduledNotFound, CodeValidationFailed},
+	{ErrInvalidDirect, CodeValidationFailed},
+	{ErrInvalidWill, CodeValidationFailed},
+	{ErrInvalidTopicName, CodeValidationFailed},
+	{ErrInvalidNamespace, CodeValidationFailed},
+	{ErrInvalidDeadLetterTopic, CodeValidationFailed},
+	{ErrInvalidTopicMetadata, CodeValidationFailed},
+	{ErrInvalidTopicLimits, CodeValidationFailed},
+	{ErrInvalidTopicState, CodeValidationFailed},
+	{ErrInvalidTopicSort, CodeValidationFailed},
+	{ErrInvalidVisibility, CodeValidationFailed},
+	{ErrInvalidRetention, CodeValidationFailed},
+	{ErrInvalidSlowConsumerPolicy, CodeValidationFailed},
+	{ErrInvalidSubscriberWatermarks, CodeValidationFailed},
+	{ErrInvalidBroadcast, CodeValidationFailed},
+	{ErrUnknownSigningKey, CodeValidationFailed},
+	{ErrJoinExpired, CodeValidationFailed},
+	{ErrJoinNotFound, CodeValidationFailed},
+	{ErrMessageNotFound, CodeValidationFailed},
+	{ErrNoReceipts, CodeValidationFailed},
+}
+
+// reasonCodes are the stable codes of the specific codes error frames carry
+// as their reason
+var reasonCodes = map[string]string{
+	"JOIN_REQUIRED":                CodePermissionDenied,
+	"JOIN_DENIED":                  CodePermissionDenied,
+	"TOPIC_ARCHIVED":               CodePermissionDenied,
+	"TOPIC_LIMIT":                  CodePermissionDenied,
+	"CLIENT_ID_IN_USE":             CodePermissionDenied,
+	"RESUME_TOKEN_INVALID":         CodePermissionDenied,
+	"PUBLISH_REJECTED":             CodePermissionDenied,
+	"TOPIC_RATE_LIMITED":           CodeRateLimited,
+	"TOO_MANY_ERRORS":              CodeRateLimited,
+	"IP_CONNECTION_LIMIT":          CodeRateLimited,
+	"PAYLOAD_TOO_DEEP":             CodePayloadTooLarge,
+	"CHUNKED_TOO_LARGE":            CodePayloadTooLarge,
+	"MESSAGE_TOO_BIG":              CodePayloadTooLarge,
+	"NO_SUBSCRIPTION_TTL":          CodeNotSubscribed,
+	"SERVER_DRAINING":              CodeServerBusy,
+	"CONNECTION_LIMIT":             CodeServerBusy,
+	"CLIENT_OVERLOADED":            CodeServerBusy,
+	"TARGET_OVERLOADED":            CodeServerBusy,
+	"SCHEDULE_FULL":                CodeServerBusy,
+	"REQUEST_TIMEOUT":              CodeServerBusy,
+	"SCHEMA_VALIDATION_FAILED":     CodeValidationFailed,
+	"FILTER_INVALID":               CodeValidationFailed,
+	"INVALID_TOPIC":                CodeValidationFailed,
+	"INVALID_NAMESPACE":            CodeValidationFailed,
+	"INVALID_LAST_WILL":            CodeValidationFailed,
+	"UNKNOWN_MESSAGE_TYPE":         CodeValidationFailed,
+	"UNSUPPORTED_PROTOCOL_VERSION": CodeValidationFailed,
+	"UNKNOWN_UPLOAD":               CodeValidationFailed,
+	"CHUNK_OUT_OF_ORDER":           CodeValidationFailed,
+	"CHUNK_SIZE_MISMATCH":          CodeValidationFailed,
+	"INVALID_MESSAGE_TYPE":         CodeValidationFailed,
+	"BAD_REQUEST":                  CodeValidationFailed,
+	"SCHEDULE_NOT_FOUND":           CodeValidationFailed,
+	"JOIN_NOT_FOUND":               CodeValidationFailed,
+	"JOIN_EXPIRED":                 CodeValidationFailed,
+}
+
+// reasons are the specific codes some errors are reported with
+var reasons = []struct {
+	err    error
+	reason string
+}{
+	{ErrJoinRequired, "JOIN_REQUIRED"},
+	{ErrJoinDenied, "JOIN_DENIED"},
+	{ErrJoinExpired, "JOIN_EXPIRED"},
+	{ErrJoinNotFound, "JOIN_NOT_FOUND"},
+	{ErrTopicArchived, "TOPIC_ARCHIVED"},
+	{ErrTopicLimit, "TOPIC_LIMIT"},
+	{ErrTopicRateLimited, "TOPIC_RATE_LIMITED"},
+	{ErrNoSubscriptionTTL, "NO_SUBSCRIPTION_TTL"},
+	{ErrServerDraining, "SERVER_DRAINING"},
+	{ErrTargetOverloaded, "TARGET_OVERLOADED"},
+	{ErrScheduleFull, "SCHEDULE_FULL"},
+	{ErrScheduledNotFound, "SCHEDULE_NOT_FOUND"},
+	{ErrRequestTimeout, "REQUEST_TIMEOUT"},
+	{ErrInvalidFilter, "FILTER_INVALID"},
+	{ErrInvalidWill, "INVALID_LAST_WILL"},
+	{ErrInvalidTopicName, "INVALID_TOPIC"},
+	{ErrInvalidNamespace, "INVALID_NAMESPACE"},
+}
+
+// kindError reads as its own message while matching kind with errors.Is
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (e *kindError) Error() string { return e.msg }
+
+func (e *kindError) Unwrap() error { return e.kind }
+
+// errorOf returns an error reading as the formatted message that matches
+// kind
+func errorOf(kind error, format string, args ...interface{}) error {
+	return &kindError{kind: kind, msg: fmt.Sprintf(format, args...)}
+}
+
+// ErrorCode returns the stable code err falls under, CodeInternal for
+// errors the broker doesn't recognize
+func ErrorCode(err error) string {
+	return NewErrorData(err).Code
+}
+
+// NewErrorData describes err for a client under its stable code, keeping
+// a payload limit, schema errors or an ErrorData's own fields
+func NewErrorData(err error) ErrorData {
+	var coded ErrorData
+	var limitErr *PayloadLimitError
+	var schemaErr *SchemaValidationError
+	var interceptorErr *InterceptorError
+	switch {
+	case errors.As(err, &limitErr):
+		return ErrorData{Code: limitErr.Code, Message: err.Error(), Limit: limitErr.Limit}.Stable()
+	case errors.As(err, &schemaErr):
+		return ErrorData{
+			Code:    "SCHEMA_VALIDATION_FAILED",
+			Message: "payload does not match the topic schema",
+			Details: schemaErr.Errors,
+		}.Stable()
+	case errors.As(err, &interceptorErr):
+		// An interceptor's own code refuses the publish
+		errData := ErrorData{Code: interceptorErr.Code, Message: err.Error()}
+		if !isStableCode(errData.Code) && reasonCodes[errData.Code] == "" {
+			errData.Code, errData.Reason = CodePermissionDenied, errData.Code
+		}
+		return errData.Stable()
+	case errors.As(err, &coded):
+		return coded.Stable()
+	}
+
+	errData := ErrorData{Code: CodeInternal, Message: err.Error()}
+	for _, entry := range errorCodes {
+		if errors.Is(err, entry.err) {
+			errData.Code = entry.code
+			break
+		}
+	}
+	for _, entry := range reasons {
+		if errors.Is(err, entry.err) {
+			errData.Reason = entry.reason
+			break
+		}
+	}
+	return errData.Stable()
+}
+
+// Stable returns the error under its stable code: a more specific code
+// becomes the reason, and Retryable is set
+func (e ErrorData) Stable() ErrorData {
+	if !isStableCode(e.Code) {
+		code, known := reasonCodes[e.Code]
+		if !known {
+			code = CodeInternal
+		}
+		if e.Reason == "" {
+			e.Reason = e.Code
+		}
+		e.Code = code
+	}
+	e.Retryable = Retryable(e.Code)
+	return e
+}
+
+// isStableCode reports whether code is one of the stable codes
+func isStableCode(code string) bool {
+	switch code {
+	case CodeTopicNotFound, CodeTopicExists, CodeNotSubscribed, CodeNotFound, CodePermissionDenied, CodeRateLimited,
+		CodePayloadTooLarge, CodeValidationFailed, CodeServerBusy, CodeInternal:
+		return true
+	}
+	return false
+}
+
+// Retryable reports whether a request that failed with code may succeed
+// if sent again unchanged, after a pause
+func Retryable(code string) bool {
+	switch code {
+	case CodeRateLimited, CodeServerBusy, CodeInternal:
+		return true
+	}
+	return false
+}
+
+// HTTPStatus is the HTTP status of a request that failed with code
+func HTTPStatus(code string) int {
+	switch code {
+	case CodeTopicNotFound, CodeNotSubscribed, CodeNotFound:
+		return http.StatusNotFound
+	case CodeTopicExists:
+		return http.StatusConflict
+	case CodePermissionDenied:
+		return http.StatusForbidden
+	case CodeRateLimited:
+		return http.StatusTooManyRequests
+	case CodePayloadTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case CodeValidationFailed:
+		return http.StatusBadRequest
+	case CodeServerBusy:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
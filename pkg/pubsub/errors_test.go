@@ -0,0 +1,68 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestErrorCodes(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	client := &recordingClient{id: "a"}
+
+	cases := []struct {
+		name   string
+		err    error
+		kind   error
+		code   string
+		status int
+	}{
+		{"publish to a missing topic", ps.Publish(ctx, "missing", MessageData{ID: "m-1"}, ""), ErrTopicNotFound, CodeTopicNotFound, http.StatusNotFound},
+		{"create an existing topic", ps.CreateTopic(ctx, "orders"), ErrTopicExists, CodeTopicExists, http.StatusConflict},
+		{"unsubscribe without a subscription", ps.Unsubscribe(ctx, "a", "orders"), ErrNotSubscribed, CodeNotSubscribed, http.StatusNotFound},
+		{"create a reserved topic", ps.CreateTopic(ctx, "$orders"), ErrPermissionDenied, CodePermissionDenied, http.StatusForbidden},
+		{"subscribe with a bad ack mode", subscribeErr(ps, client, SubscribeOptions{AckMode: "sometimes"}), ErrInvalidRequest, CodeValidationFailed, http.StatusBadRequest},
+		{"a namespace over its publish rate", fmt.Errorf("%w: 10 per second", ErrRateLimited), ErrRateLimited, CodeRateLimited, http.StatusTooManyRequests},
+		{"an unrecognized failure", errors.New("disk on fire"), nil, CodeInternal, http.StatusInternalServerError},
+	}
+	for _, tc := range cases {
+		if tc.err == nil {
+			t.Errorf("%s succeeded", tc.name)
+			continue
+		}
+		if tc.kind != nil && !errors.Is(tc.err, tc.kind) {
+			t.Errorf("%s: %v is not %v", tc.name, tc.err, tc.kind)
+		}
+		if code := ErrorCode(tc.err); code != tc.code || HTTPStatus(code) != tc.status {
+			t.Errorf("%s: code %s, status %d; want %s, %d", tc.name, code, HTTPStatus(code), tc.code, tc.status)
+		}
+	}
+
+	// Messages read as before
+	if err := ps.Publish(ctx, "missing", MessageData{ID: "m-1"}, ""); err.Error() != "topic missing not found" {
+		t.Errorf("message = %q", err)
+	}
+
+	// Specific codes become the reason under their stable code
+	ps.Drain()
+	errData := NewErrorData(subscribeErr(ps, client, SubscribeOptions{}))
+	if errData.Code != CodeServerBusy || errData.Reason != "SERVER_DRAINING" || !errData.Retryable {
+		t.Errorf("subscribing while draining = %+v", errData)
+	}
+	errData = NewErrorData(&PayloadLimitError{Code: "PAYLOAD_TOO_DEEP", Limit: 8, Actual: 9})
+	if errData.Code != CodePayloadTooLarge || errData.Reason != "PAYLOAD_TOO_DEEP" || errData.Limit != 8 || errData.Retryable {
+		t.Errorf("nesting limit = %+v", errData)
+	}
+}
+
+// subscribeErr subscribes client to "orders" and returns the error
+func subscribeErr(ps *PubSubSystem, client *recordingClient, opts SubscribeOptions) error {
+	_, err := ps.SubscribeWithOptions(context.Background(), client.id, "orders", opts, client)
+	return err
+}
@@ -0,0 +1,67 @@
+package pubsub
+
+import (
+	"context"
+)
+
+// exportPageSize is how many events ExportTopicHistory reads at a time
+const exportPageSize = 500
+
+// ExportTopicHistory calls fn with every event in a topic's history, oldest
+// first, until fn returns an error. The history is read a page at a time,
+// from the SQLite archive when enabled, so events published meanwhile are
+// included and none are held in memory at once beyond a page.
+func (ps *PubSubSystem) ExportTopicHistory(ctx context.Context, name string, fn func(EventResponse) error) error {
+	var afterSeq int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		events, more, err := ps.QueryTopicMessages(name, HistoryQuery{AfterSeq: afterSeq, Limit: exportPageSize})
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+		if !more || len(events) == 0 {
+			return nil
+		}
+		afterSeq = events[len(events)-1].Seq
+	}
+}
+
+// ImportEvent appends an exported event to a topic under a new sequence
+// number and timestamp, returning the sequence number. It is checked like
+// a publish. With deliver it is published to subscribers and webhooks as
+// well; otherwise it only goes into the history, skipping the rate limits
+// and publish interceptors. The event's message, ordering and compact
+// keys and reply fields are kept.
+func (ps *PubSubSystem) ImportEvent(ctx context.Context, name string, event EventResponse, deliver bool) (int64, error) {
+	if event.Message.ID == "" {
+		return 0, errorOf(ErrInvalidRequest, "message.id is required")
+	}
+	if event.Ephemeral || event.Volatile {
+		return 0, errorOf(ErrInvalidRequest, "ephemeral events have no history to import into")
+	}
+	opts := PublishOptions{
+		OrderingKey:   event.OrderingKey,
+		CompactKey:    event.CompactKey,
+		ReplyTo:       event.ReplyTo,
+		CorrelationID: event.CorrelationID,
+	}
+	if deliver {
+		result, err := ps.PublishWithResult(ctx, name, event.Message, "", opts)
+		return result.Seq, err
+	}
+
+	opts.historyOnly = true
+	topic, size, err := ps.checkPublish(ctx, name, event.Message, opts)
+	if err != nil {
+		return 0, err
+	}
+	result, err := ps.publishToTopic(ctx, topic, event.Message, size, "", opts)
+	return result.Seq, err
+}
@@ -0,0 +1,78 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// exported returns a topic's history through ExportTopicHistory
+func exported(t *testing.T, ps *PubSubSystem, name string) []EventResponse {
+	t.Helper()
+	var events []EventResponse
+	err := ps.ExportTopicHistory(context.Background(), name, func(event EventResponse) error {
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestExportAndImportHistory(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	subscriber := &recordingClient{id: "sub"}
+	subscribeClient(t, ps, "orders", subscriber)
+	publishN(t, ps, "orders", exportPageSize+3)
+	before := exported(t, ps, "orders")
+	if len(before) != exportPageSize+3 || before[0].Seq != 1 || before[len(before)-1].Seq != exportPageSize+3 {
+		t.Fatalf("exported %d events", len(before))
+	}
+
+	// Loaded into history only, in order under new sequence numbers
+	if _, err := ps.PurgeTopicHistory("orders", time.Time{}, 0); err != nil {
+		t.Fatal(err)
+	}
+	delivered := len(subscriber.received())
+	for _, event := range before {
+		if _, err := ps.ImportEvent(ctx, "orders", event, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	after := exported(t, ps, "orders")
+	if len(after) != len(before) {
+		t.Fatalf("%d events after import, want %d", len(after), len(before))
+	}
+	for i := range after {
+		if !reflect.DeepEqual(after[i].Message, before[i].Message) || after[i].Seq != before[i].Seq+int64(len(before)) {
+			t.Fatalf("event %d imported as %+v, was %+v", i, after[i], before[i])
+		}
+	}
+	if n := len(subscriber.received()); n != delivered {
+		t.Errorf("a history-only import delivered %d events", n-delivered)
+	}
+
+	// Or published again
+	seq, err := ps.ImportEvent(ctx, "orders", before[0], true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := subscriber.waitEvents(t, delivered+1)
+	if last := events[len(events)-1]; last.Seq != seq || last.Message.ID != before[0].Message.ID {
+		t.Errorf("delivered %+v, want seq %d", last, seq)
+	}
+
+	if _, err := ps.ImportEvent(ctx, "orders", EventResponse{Message: MessageData{Payload: 1}}, false); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("an event without an ID: err = %v", err)
+	}
+	if err := ps.ExportTopicHistory(ctx, "missing", func(EventResponse) error { return nil }); !errors.Is(err, ErrTopicNotFound) {
+		t.Errorf("exporting a missing topic: err = %v", err)
+	}
+}
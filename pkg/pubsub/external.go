@@ -0,0 +1,71 @@
+package pubsub
+
+import (
+	"sort"
+	"sync"
+)
+
+// statusFuncs holds the status callbacks of components outside the broker
+// that /health reports on, such as bridges and exporters
+type statusFuncs[T any] struct {
+	mutex  sync.Mutex
+	byName map[string]func() T
+}
+
+func (s *statusFuncs[T]) add(name string, status func() T) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.byName == nil {
+		s.byName = make(map[string]func() T)
+	}
+	s.byName[name] = status
+}
+
+func (s *statusFuncs[T]) remove(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.byName, name)
+}
+
+// statuses calls every callback, returning the names sorted
+func (s *statusFuncs[T]) statuses() ([]string, map[string]T) {
+	s.mutex.Lock()
+	callbacks := make(map[string]func() T, len(s.byName))
+	for name, status := range s.byName {
+		callbacks[name] = status
+	}
+	s.mutex.Unlock()
+
+	names := make([]string, 0, len(callbacks))
+	statuses := make(map[string]T, len(callbacks))
+	for name, status := range callbacks {
+		names = append(names, name)
+		statuses[name] = status()
+	}
+	sort.Strings(names)
+	return names, statuses
+}
+
+// AddBridge reports a link to an external broker under name in /health,
+// which is degraded while status reports it disconnected. Registering a
+// name again replaces it.
+func (ps *PubSubSystem) AddBridge(name string, status func() BridgeStatus) {
+	ps.bridges.add(name, status)
+}
+
+// RemoveBridge stops reporting the bridge registered as name
+func (ps *PubSubSystem) RemoveBridge(name string) {
+	ps.bridges.remove(name)
+}
+
+// AddExporter reports the queue of an exporter copying events to an
+// external system under name in /health, which is degraded while status
+// reports it overflowing. Registering a name again replaces it.
+func (ps *PubSubSystem) AddExporter(name string, status func() StoreStatus) {
+	ps.exporters.add(name, status)
+}
+
+// RemoveExporter stops reporting the exporter registered as name
+func (ps *PubSubSystem) RemoveExporter(name string) {
+	ps.exporters.remove(name)
+}
@@ -0,0 +1,192 @@
+package pubsub
+
+import (
+	"errors"
+	"hash/fnv"
+	"log"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	// MaxOrderingKeyLength is the longest ordering key a publish may carry
+	MaxOrderingKeyLength = 256
+
+	// MaxCompactKeyLength is the longest compact key a publish may carry
+	MaxCompactKeyLength = 256
+
+	// Deliveries queued per fan-out lane before publishers wait
+	fanoutLaneQueueSize = 1024
+)
+
+// ErrFanoutConfigured is returned when fan-out workers are enabled twice
+var ErrFanoutConfigured = errors.New("fan-out workers are already enabled")
+
+// ErrVolatileDropped is returned by a client's SendMessage for a volatile
+// event it won't buffer; the event is neither a drop nor dead-lettered
+var ErrVolatileDropped = errors.New("volatile event dropped by a subscriber that is behind")
+
+// PublishOptions controls how a published message is delivered
+type PublishOptions struct {
+	// Events with the same key on the same topic are delivered in publish
+	// order; events with different keys may be delivered in parallel.
+	// Events without a key share the topic's default lane.
+	OrderingKey string
+
+	// Ephemeral events reach current subscribers but stay out of history,
+	// persistence and the topic's message count, and take no seq
+	Ephemeral bool
+
+	// Volatile events are ephemeral and also dropped rather than buffered
+	// for subscribers that are behind or paused
+	Volatile bool
+
+	// A compacted topic's history keeps only the newest event per key
+	CompactKey string
+
+	// Where replies go, a client ID or an inbox, and what they carry to
+	// match the request; both are copied onto the event
+	ReplyTo       string
+	CorrelationID string
+
+	// Record which explicit-ack subscribers ack the event, and push their
+	// acks to the publisher
+	Receipts bool
+
+	// Imported events only go into history, set by ImportEvent
+	historyOnly bool
+}
+
+// fanoutJob is one event's live delivery to the subscribers it was
+// published to. A job with done set is a barrier: the lane closes done
+// once every job queued before it has run.
+type fanoutJob struct {
+	topic       *Topic
+	event       EventResponse
+	prepared    *PreparedEvent
+	subscribers []*Subscriber
+	hooked      bool
+	done        chan struct{}
+}
+
+// fanoutPool delivers events on a fixed set of lanes, each drained by one
+// worker. An event's lane is picked by hashing its topic and ordering key,
+// so events sharing both are delivered in the order they were queued.
+type fanoutPool struct {
+	lanes []chan fanoutJob
+	wg    sync.WaitGroup
+}
+
+// EnableFanoutWorkers moves live delivery off the publishing goroutine
+// onto worker lanes, so slow fan-out to many subscribers no longer holds
+// up publishers and events with different ordering keys are delivered in
+// parallel. It must be called before the first publish. Without it events
+// are delivered by the publisher, under the topic lock.
+func (ps *PubSubSystem) EnableFanoutWorkers(workers int) error {
+	if workers <= 0 {
+		return nil
+	}
+	pool := &fanoutPool{lanes: make([]chan fanoutJob, workers)}
+	if !ps.fanout.CompareAndSwap(nil, pool) {
+		return ErrFanoutConfigured
+	}
+	for i := range pool.lanes {
+		lane := make(chan fanoutJob, fanoutLaneQueueSize)
+		pool.lanes[i] = lane
+		pool.wg.Add(1)
+		go func() {
+			defer pool.wg.Done()
+			for job := range lane {
+				if job.done != nil {
+					close(job.done)
+					continue
+				}
+				for _, subscriber := range job.subscribers {
+					ps.deliverLive(job.topic, subscriber, job.event, job.prepared, job.hooked)
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// dispatch queues an event's live delivery on the lane for its topic and
+// ordering key. Callers hold the topic lock, so each lane sees a topic's
+// events in sequence order.
+func (pool *fanoutPool) dispatch(job fanoutJob) {
+	h := fnv.New32a()
+	h.Write([]byte(job.topic.Name))
+	if job.event.OrderingKey != "" {
+		h.Write([]byte{0})
+		h.Write([]byte(job.event.OrderingKey))
+	}
+	pool.lanes[h.Sum32()%uint32(len(pool.lanes))] <- job
+}
+
+// flush waits until every delivery queued so far has run. Workers never
+// take topic locks, so it may be called with one held.
+func (pool *fanoutPool) flush() {
+	barriers := make([]chan struct{}, len(pool.lanes))
+	for i, lane := range pool.lanes {
+		barriers[i] = make(chan struct{})
+		lane <- fanoutJob{done: barriers[i]}
+	}
+	for _, done := range barriers {
+		<-done
+	}
+}
+
+// close stops the workers once the queued deliveries have run
+func (pool *fanoutPool) close() {
+	for _, lane := range pool.lanes {
+		close(lane)
+	}
+	pool.wg.Wait()
+}
+
+// flushFanout waits for queued live deliveries, if workers are enabled, so
+// a notice sent next can't overtake events published before it
+func (ps *PubSubSystem) flushFanout() {
+	if pool := ps.fanout.Load(); pool != nil {
+		pool.flush()
+	}
+}
+
+// deliverLive sends a published event to one subscriber that isn't paused
+// or on explicit acks. Unhooked loopback deliveries stay out of the
+// delivery and drop counters, since the self-check reports its own failure.
+func (ps *PubSubSystem) deliverLive(topic *Topic, subscriber *Subscriber, event EventResponse, prepared *PreparedEvent, hooked bool) {
+	// Filtered-out and vetoed events are neither delivered nor dropped
+	if !subscriber.filter.MatchMessage(event.Message) || !ps.deliverable(event, subscriber.ClientID) {
+		return
+	}
+
+	span := ps.traceDelivery(event, subscriber.ClientID, "live")
+	defer span.End()
+	if hooked {
+		ps.deliveries.Add(1)
+	}
+	if err := subscriber.Client.SendMessage(prepared); errors.Is(err, ErrVolatileDropped) {
+		// Volatile events are meant to be lost by subscribers that are behind
+		span.SetAttributes(attribute.Bool("pubsub.dropped", true))
+		return
+	} else if err != nil {
+		// Client is disconnected or channel is full, drop message
+		span.SetStatus(codes.Error, err.Error())
+		if !hooked {
+			return
+		}
+		ps.drops.Add(1)
+		log.Printf("Dropping message for client %s - %v", subscriber.ClientID, err)
+		ps.DeadLetter(event, subscriber.ClientID, DeadLetterBufferEvicted)
+		ps.noteDelivery(topic, subscriber, true)
+		return
+	}
+	ps.noteDelivery(topic, subscriber, false)
+	topic.activity.delivered()
+	if hooked {
+		ps.emit(hookEvent{kind: hookDeliver, topic: topic.Name, clientID: subscriber.ClientID})
+	}
+}
@@ -0,0 +1,199 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// laneOf returns the fan-out lane an ordering key's events go to, out of
+// workers lanes
+func laneOf(topic, key string, workers int) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(topic))
+	if key != "" {
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+	}
+	return h.Sum32() % uint32(workers)
+}
+
+// gatedClient holds back the events of one ordering key until n events of
+// the others have been sent to it, so their lanes have to overtake it
+type gatedClient struct {
+	*recordingClient
+	held    string
+	n       int
+	sent    int
+	once    sync.Once
+	release chan struct{}
+}
+
+func (c *gatedClient) SendMessage(msg interface{}) error {
+	event := msg.(*PreparedEvent).Event
+	if event.OrderingKey == c.held {
+		select {
+		case <-c.release:
+		case <-time.After(5 * time.Second):
+		}
+		return c.recordingClient.SendMessage(msg)
+	}
+	c.recordingClient.SendMessage(msg)
+	c.mutex.Lock()
+	c.sent++
+	sent := c.sent
+	c.mutex.Unlock()
+	if sent >= c.n {
+		c.once.Do(func() { close(c.release) })
+	}
+	return nil
+}
+
+func TestOrderingKeysKeepPerKeyOrder(t *testing.T) {
+	const workers, perKey = 4, 300
+	ps := New()
+	ctx := context.Background()
+	if err := ps.EnableFanoutWorkers(workers); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two keys on different lanes, so they are delivered in parallel
+	slow, fast := "order-0", ""
+	for i := 1; fast == ""; i++ {
+		if key := fmt.Sprintf("order-%d", i); laneOf("orders", key, workers) != laneOf("orders", slow, workers) {
+			fast = key
+		}
+	}
+	client := &gatedClient{recordingClient: &recordingClient{id: "shipping"}, held: slow, n: perKey, release: make(chan struct{})}
+	subscribeClient(t, ps, "orders", client)
+
+	// The held key publishes first, so its first event precedes every
+	// event of the other key in the topic
+	var wg sync.WaitGroup
+	started := make(chan struct{})
+	for _, key := range []string{slow, fast} {
+		key := key
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if key == fast {
+				<-started
+			}
+			for i := 0; i < perKey; i++ {
+				msg := MessageData{ID: fmt.Sprintf("%s-%d", key, i), Payload: i}
+				if err := ps.PublishWithOptions(ctx, "orders", msg, "", PublishOptions{OrderingKey: key}); err != nil {
+					t.Error(err)
+				}
+				if i == 0 && key == slow {
+					close(started)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	events := client.waitEvents(t, 2*perKey)
+
+	// Each key's events arrive in publish order, carrying their key
+	last := map[string]int64{}
+	count := map[string]int{}
+	for _, event := range events {
+		key := event.OrderingKey
+		if key != slow && key != fast {
+			t.Fatalf("event %+v delivered with ordering key %q", event.Message, key)
+		}
+		if want := fmt.Sprintf("%s-%d", key, count[key]); event.Message.ID != want {
+			t.Errorf("%s delivered event %s, want %s", key, event.Message.ID, want)
+		}
+		if event.Seq <= last[key] {
+			t.Errorf("%s delivered seq %d after %d", key, event.Seq, last[key])
+		}
+		last[key] = event.Seq
+		count[key]++
+	}
+	if count[slow] != perKey || count[fast] != perKey {
+		t.Errorf("delivered %v, want %d of each key", count, perKey)
+	}
+
+	// The held key's lane was overtaken, so delivery isn't in topic
+	// sequence order across keys
+	interleaved := false
+	for i := 1; i < len(events); i++ {
+		if events[i].Seq < events[i-1].Seq {
+			interleaved = true
+			break
+		}
+	}
+	if !interleaved {
+		t.Error("events of the two keys were delivered in topic sequence order, not in parallel")
+	}
+}
+
+func TestEventsWithoutKeyShareTheDefaultLane(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.EnableFanoutWorkers(4); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	client := &recordingClient{id: "shipping"}
+	subscribeClient(t, ps, "orders", client)
+
+	var wg sync.WaitGroup
+	for p := 0; p < 4; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if err := ps.Publish(ctx, "orders", MessageData{ID: "m", Payload: i}, ""); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	events := client.waitEvents(t, 400)
+	for i, event := range events {
+		if event.Seq != int64(i+1) || event.OrderingKey != "" {
+			t.Fatalf("event %d delivered as seq %d with key %q", i, event.Seq, event.OrderingKey)
+		}
+	}
+}
+
+func TestOrderingKeyValidation(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.EnableFanoutWorkers(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.EnableFanoutWorkers(8); !errors.Is(err, ErrFanoutConfigured) {
+		t.Errorf("enabling fan-out workers twice = %v", err)
+	}
+	if err := ps.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+
+	key := strings.Repeat("k", MaxOrderingKeyLength)
+	if err := ps.PublishWithOptions(ctx, "orders", MessageData{ID: "m"}, "", PublishOptions{OrderingKey: key}); err != nil {
+		t.Errorf("ordering key of %d bytes = %v", len(key), err)
+	}
+	if err := ps.PublishWithOptions(ctx, "orders", MessageData{ID: "m"}, "", PublishOptions{OrderingKey: key + "k"}); err == nil || !strings.Contains(err.Error(), "ordering key") {
+		t.Errorf("ordering key a byte too long = %v", err)
+	}
+
+	// The key is kept with the event in history too
+	events := history(t, ps, "orders")
+	if len(events) != 1 || events[0].OrderingKey != key {
+		t.Errorf("history = %+v", events)
+	}
+}
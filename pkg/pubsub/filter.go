@@ -0,0 +1,311 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Filter operators
+const (
+	FilterOpEq  = "eq"
+	FilterOpNe  = "ne"
+	FilterOpIn  = "in"
+	FilterOpGt  = "gt"
+	FilterOpGte = "gte"
+	FilterOpLt  = "lt"
+	FilterOpLte = "lte"
+)
+
+// ErrInvalidFilter is returned when a subscription filter can't be compiled
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// EventFilter is a compiled subscription filter: payload predicates and
+// a header selector. A nil *EventFilter matches every event.
+type EventFilter struct {
+	predicates []compiledPredicate
+	headers    map[string]string // Exact values every matching event has
+}
+
+// compiledPredicate is a validated predicate with its path pre-split
+type compiledPredicate struct {
+	path  []string
+	op    string
+	value interface{}   // eq and ne
+	set   []interface{} // in
+	num   float64       // gt, gte, lt and lte
+}
+
+// NewEventFilter validates a filter from a subscribe request. It returns
+// nil for a nil or empty filter.
+func NewEventFilter(filter *Filter) (*EventFilter, error) {
+	if filter == nil {
+		return nil, nil
+	}
+
+	predicates := filter.All
+	if filter.Path != "" || filter.Op != "" {
+		predicates = append([]FilterPredicate{filter.FilterPredicate}, predicates...)
+	}
+	if len(predicates) == 0 {
+		return nil, nil
+	}
+
+	ef := &EventFilter{predicates: make([]compiledPredicate, 0, len(predicates))}
+	for i, p := range predicates {
+		cp, err := compilePredicate(p)
+		if err != nil {
+			return nil, fmt.Errorf("%w: predicate %d: %v", ErrInvalidFilter, i, err)
+		}
+		ef.predicates = append(ef.predicates, cp)
+	}
+	return ef, nil
+}
+
+// compilePredicate checks one predicate's path, operator and value
+func compilePredicate(p FilterPredicate) (compiledPredicate, error) {
+	cp := compiledPredicate{op: p.Op}
+	if p.Path == "" {
+		return cp, errors.New("path is required")
+	}
+	cp.path = strings.Split(p.Path, ".")
+	for _, part := range cp.path {
+		if part == "" {
+			return cp, fmt.Errorf("path %q has an empty segment", p.Path)
+		}
+	}
+
+	switch p.Op {
+	case FilterOpEq, FilterOpNe:
+		if !isScalar(p.Value) {
+			return cp, fmt.Errorf("%s needs a string, number, bool or null value", p.Op)
+		}
+		cp.value = p.Value
+	case FilterOpIn:
+		list, ok := toList(p.Value)
+		if !ok || len(list) == 0 {
+			return cp, errors.New("in needs a non-empty list value")
+		}
+		for _, v := range list {
+			if !isScalar(v) {
+				return cp, errors.New("in list entries must be strings, numbers, bools or null")
+			}
+		}
+		cp.set = list
+	case FilterOpGt, FilterOpGte, FilterOpLt, FilterOpLte:
+		n, ok := toNumber(p.Value)
+		if !ok {
+			return cp, fmt.Errorf("%s needs a numeric value", p.Op)
+		}
+		cp.num = n
+	case "":
+		return cp, errors.New("op is required")
+	default:
+		return cp, fmt.Errorf("unknown op %q", p.Op)
+	}
+	return cp, nil
+}
+
+// WithHeaders returns the filter with a header selector added: only events
+// whose headers have every key with the same value match. An empty
+// selector leaves the filter as it is.
+func (ef *EventFilter) WithHeaders(selector map[string]string) (*EventFilter, error) {
+	if len(selector) == 0 {
+		return ef, nil
+	}
+	for key := range selector {
+		if key == "" {
+			return nil, fmt.Errorf("%w: header selector has an empty key", ErrInvalidFilter)
+		}
+	}
+	filter := &EventFilter{headers: make(map[string]string, len(selector))}
+	if ef != nil {
+		filter.predicates = ef.predicates
+	}
+	for key, value := range selector {
+		filter.headers[key] = value
+	}
+	return filter, nil
+}
+
+// MatchMessage reports whether a message's headers match the selector and
+// its payload every predicate. Headers are checked first since they cost
+// no payload traversal.
+func (ef *EventFilter) MatchMessage(message MessageData) bool {
+	if ef == nil {
+		return true
+	}
+	for key, value := range ef.headers {
+		if got, exists := message.Headers[key]; !exists || got != value {
+			return false
+		}
+	}
+	if len(ef.predicates) == 0 {
+		return true
+	}
+	return ef.Match(message.Payload)
+}
+
+// Match reports whether every predicate holds for payload
+func (ef *EventFilter) Match(payload interface{}) bool {
+	if ef == nil {
+		return true
+	}
+
+	payload = normalizePayload(payload)
+	for _, p := range ef.predicates {
+		if !p.match(payload) {
+			return false
+		}
+	}
+	return true
+}
+
+// match evaluates one predicate. A missing field or a value of the wrong
+// type fails every operator except ne.
+func (p compiledPredicate) match(payload interface{}) bool {
+	field, found := lookupPath(payload, p.path)
+
+	switch p.op {
+	case FilterOpEq:
+		return found && scalarEqual(field, p.value)
+	case FilterOpNe:
+		return !found || !scalarEqual(field, p.value)
+	case FilterOpIn:
+		if !found {
+			return false
+		}
+		for _, v := range p.set {
+			if scalarEqual(field, v) {
+				return true
+			}
+		}
+		return false
+	}
+
+	n, ok := toNumber(field)
+	if !found || !ok {
+		return false
+	}
+	switch p.op {
+	case FilterOpGt:
+		return n > p.num
+	case FilterOpGte:
+		return n >= p.num
+	case FilterOpLt:
+		return n < p.num
+	case FilterOpLte:
+		return n <= p.num
+	}
+	return false
+}
+
+// lastMatching returns the newest n events that match, oldest first
+func lastMatching(events []EventResponse, n int, match func(EventResponse) bool) []EventResponse {
+	start := len(events)
+	for i := len(events) - 1; i >= 0 && len(events)-start < n; i-- {
+		if match(events[i]) {
+			start--
+			events[start] = events[i]
+		}
+	}
+	return events[start:]
+}
+
+// lookupPath follows dot-separated keys through nested objects
+func lookupPath(value interface{}, path []string) (interface{}, bool) {
+	for _, key := range path {
+		switch obj := value.(type) {
+		case map[string]interface{}:
+			v, ok := obj[key]
+			if !ok {
+				return nil, false
+			}
+			value = v
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// normalizePayload turns payloads published in-process as Go values into
+// the generic form JSON and MessagePack clients publish, so paths resolve
+// the same way regardless of origin
+func normalizePayload(payload interface{}) interface{} {
+	switch payload.(type) {
+	case nil, map[string]interface{}, []interface{}, string, bool, float64:
+		return payload
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return payload
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return payload
+	}
+	return generic
+}
+
+// scalarEqual compares two scalars, treating all numeric types as equal
+// when their values are
+func scalarEqual(a, b interface{}) bool {
+	if an, ok := toNumber(a); ok {
+		bn, ok := toNumber(b)
+		return ok && an == bn
+	}
+	if !isScalar(a) {
+		return false
+	}
+	return a == b
+}
+
+// isScalar reports whether v is null, a string, a bool or a number
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case nil, string, bool:
+		return true
+	}
+	_, ok := toNumber(v)
+	return ok
+}
+
+// toNumber converts any numeric value to float64
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case nil:
+		return 0, false
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+// toList returns v's elements if it is a slice
+func toList(v interface{}) ([]interface{}, bool) {
+	if list, ok := v.([]interface{}); ok {
+		return list, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return nil, false
+	}
+	list := make([]interface{}, rv.Len())
+	for i := range list {
+		list[i] = rv.Index(i).Interface()
+	}
+	return list, true
+}
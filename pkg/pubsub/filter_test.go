@@ -0,0 +1,312 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEventFilterPredicates(t *testing.T) {
+	payload := map[string]interface{}{
+		"level": "error",
+		"code":  float64(503),
+		"ok":    false,
+		"user":  map[string]interface{}{"id": "u1", "tier": map[string]interface{}{"rank": float64(3)}},
+		"tags":  []interface{}{"a", "b"},
+	}
+	for _, tc := range []struct {
+		name  string
+		p     FilterPredicate
+		match bool
+	}{
+		{"eq", FilterPredicate{Path: "level", Op: FilterOpEq, Value: "error"}, true},
+		{"eq other value", FilterPredicate{Path: "level", Op: FilterOpEq, Value: "info"}, false},
+		{"eq bool", FilterPredicate{Path: "ok", Op: FilterOpEq, Value: false}, true},
+		{"eq number of another type", FilterPredicate{Path: "code", Op: FilterOpEq, Value: 503}, true},
+		{"ne", FilterPredicate{Path: "level", Op: FilterOpNe, Value: "info"}, true},
+		{"ne missing field", FilterPredicate{Path: "missing", Op: FilterOpNe, Value: "info"}, true},
+		{"in", FilterPredicate{Path: "level", Op: FilterOpIn, Value: []interface{}{"warn", "error"}}, true},
+		{"not in", FilterPredicate{Path: "level", Op: FilterOpIn, Value: []interface{}{"warn", "info"}}, false},
+		{"gt", FilterPredicate{Path: "code", Op: FilterOpGt, Value: 500}, true},
+		{"gte boundary", FilterPredicate{Path: "code", Op: FilterOpGte, Value: 503}, true},
+		{"lt", FilterPredicate{Path: "code", Op: FilterOpLt, Value: 503}, false},
+		{"lte boundary", FilterPredicate{Path: "code", Op: FilterOpLte, Value: 503}, true},
+
+		// Nested paths
+		{"nested eq", FilterPredicate{Path: "user.id", Op: FilterOpEq, Value: "u1"}, true},
+		{"deeply nested gt", FilterPredicate{Path: "user.tier.rank", Op: FilterOpGt, Value: 2}, true},
+		{"missing nested field", FilterPredicate{Path: "user.name", Op: FilterOpEq, Value: "u1"}, false},
+		{"path through a scalar", FilterPredicate{Path: "level.name", Op: FilterOpEq, Value: "error"}, false},
+		{"path through a list", FilterPredicate{Path: "tags.0", Op: FilterOpEq, Value: "a"}, false},
+
+		// Type mismatches fail rather than coerce
+		{"string against a number", FilterPredicate{Path: "code", Op: FilterOpEq, Value: "503"}, false},
+		{"number against a string", FilterPredicate{Path: "level", Op: FilterOpGt, Value: 0}, false},
+		{"comparing an object", FilterPredicate{Path: "user", Op: FilterOpEq, Value: "u1"}, false},
+		{"comparing a list", FilterPredicate{Path: "tags", Op: FilterOpIn, Value: []interface{}{"a"}}, false},
+		{"bool against a number", FilterPredicate{Path: "ok", Op: FilterOpLt, Value: 1}, false},
+	} {
+		filter, err := NewEventFilter(&Filter{FilterPredicate: tc.p})
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := filter.Match(payload); got != tc.match {
+			t.Errorf("%s: %+v matched %v, want %v", tc.name, tc.p, got, tc.match)
+		}
+	}
+}
+
+func TestEventFilterPredicatesAreANDed(t *testing.T) {
+	filter, err := NewEventFilter(&Filter{
+		FilterPredicate: FilterPredicate{Path: "level", Op: FilterOpEq, Value: "error"},
+		All:             []FilterPredicate{{Path: "code", Op: FilterOpGte, Value: 500}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		payload interface{}
+		match   bool
+	}{
+		{map[string]interface{}{"level": "error", "code": 503}, true},
+		{map[string]interface{}{"level": "error", "code": 404}, false},
+		{map[string]interface{}{"level": "info", "code": 503}, false},
+		// Go values published in-process resolve like decoded JSON
+		{struct {
+			Level string `json:"level"`
+			Code  int    `json:"code"`
+		}{"error", 500}, true},
+		{"error", false},
+		{nil, false},
+	} {
+		if got := filter.Match(tc.payload); got != tc.match {
+			t.Errorf("%#v matched %v, want %v", tc.payload, got, tc.match)
+		}
+	}
+
+	// No predicates is no filter
+	if filter, err := NewEventFilter(&Filter{}); filter != nil || err != nil {
+		t.Errorf("empty filter = %v, %v", filter, err)
+	}
+}
+
+func TestInvalidFiltersRejected(t *testing.T) {
+	for name, p := range map[string]FilterPredicate{
+		"no path":           {Op: FilterOpEq, Value: "x"},
+		"empty segment":     {Path: "user..id", Op: FilterOpEq, Value: "x"},
+		"no op":             {Path: "level", Value: "x"},
+		"unknown op":        {Path: "level", Op: "like", Value: "x"},
+		"eq an object":      {Path: "level", Op: FilterOpEq, Value: map[string]interface{}{}},
+		"in a scalar":       {Path: "level", Op: FilterOpIn, Value: "x"},
+		"in an empty list":  {Path: "level", Op: FilterOpIn, Value: []interface{}{}},
+		"in nested lists":   {Path: "level", Op: FilterOpIn, Value: []interface{}{[]interface{}{"x"}}},
+		"gt a string":       {Path: "code", Op: FilterOpGt, Value: "500"},
+		"lte without value": {Path: "code", Op: FilterOpLte},
+	} {
+		if _, err := NewEventFilter(&Filter{All: []FilterPredicate{p}}); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	// Rejected at subscribe time too
+	ps := New()
+	if err := ps.CreateTopic(context.Background(), "logs"); err != nil {
+		t.Fatal(err)
+	}
+	client := &recordingClient{id: "dashboard"}
+	ps.RegisterClient(client)
+	opts := SubscribeOptions{Filter: &Filter{FilterPredicate: FilterPredicate{Path: "level", Op: "like"}}}
+	_, err := ps.SubscribeWithOptions(context.Background(), client.id, "logs", opts, client)
+	if !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("subscribing with an invalid filter = %v", err)
+	}
+	if topics := ps.GetClientTopics(client.id); len(topics) != 0 {
+		t.Errorf("rejected subscription left the client on %v", topics)
+	}
+}
+
+func TestFilteredSubscription(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "logs"); err != nil {
+		t.Fatal(err)
+	}
+	publish := func(id, level string) {
+		t.Helper()
+		msg := MessageData{ID: id, Payload: map[string]interface{}{"level": level}}
+		if err := ps.Publish(ctx, "logs", msg, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish("old-1", "error")
+	publish("old-2", "info")
+	publish("old-3", "error")
+	publish("old-4", "info")
+
+	errorsOnly := &Filter{FilterPredicate: FilterPredicate{Path: "level", Op: FilterOpEq, Value: "error"}}
+	dashboard := &recordingClient{id: "dashboard"}
+	ps.RegisterClient(dashboard)
+	// The last_n replay is the newest matching events, not the newest
+	// events filtered
+	replay, err := ps.SubscribeWithOptions(ctx, dashboard.id, "logs", SubscribeOptions{LastN: 2, Filter: errorsOnly}, dashboard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replay) != 2 || replay[0].Message.ID != "old-1" || replay[1].Message.ID != "old-3" {
+		t.Errorf("replay = %+v", replay)
+	}
+
+	// A stuck subscriber whose filter passes nothing has nothing dropped
+	stuck := fullClient{id: "stuck"}
+	ps.RegisterClient(stuck)
+	never := &Filter{FilterPredicate: FilterPredicate{Path: "level", Op: FilterOpEq, Value: "fatal"}}
+	if _, err := ps.SubscribeWithOptions(ctx, stuck.id, "logs", SubscribeOptions{Filter: never}, stuck); err != nil {
+		t.Fatal(err)
+	}
+
+	publish("new-1", "info")
+	publish("new-2", "error")
+	publish("new-3", "warn")
+	publish("new-4", "error")
+	events := dashboard.waitEvents(t, 2)
+	if len(events) != 2 || events[0].Message.ID != "new-2" || events[1].Message.ID != "new-4" {
+		t.Errorf("delivered %+v", events)
+	}
+	if dropped := ps.GetHealth().DroppedLastMinute; dropped != 0 {
+		t.Errorf("%d filtered-out events counted as drops", dropped)
+	}
+}
+
+func TestHeaderSelectors(t *testing.T) {
+	eu := map[string]string{"region": "eu", "kind": "order"}
+	selector, err := (*EventFilter)(nil).WithHeaders(eu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The selector keeps its own copy
+	eu["region"] = "us"
+
+	for _, tc := range []struct {
+		name    string
+		headers map[string]string
+		match   bool
+	}{
+		{"exact", map[string]string{"region": "eu", "kind": "order"}, true},
+		{"extra headers", map[string]string{"region": "eu", "kind": "order", "trace": "t1"}, true},
+		{"other value", map[string]string{"region": "us", "kind": "order"}, false},
+		{"missing key", map[string]string{"region": "eu"}, false},
+		{"value case differs", map[string]string{"region": "EU", "kind": "order"}, false},
+		{"no headers", nil, false},
+	} {
+		if got := selector.MatchMessage(MessageData{Headers: tc.headers}); got != tc.match {
+			t.Errorf("%s: matched %v, want %v", tc.name, got, tc.match)
+		}
+	}
+
+	// Selectors and payload predicates must both hold
+	errorsOnly, err := NewEventFilter(&Filter{FilterPredicate: FilterPredicate{Path: "level", Op: FilterOpEq, Value: "error"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	both, err := errorsOnly.WithHeaders(map[string]string{"region": "eu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		level, region string
+		match         bool
+	}{
+		{"error", "eu", true},
+		{"info", "eu", false},
+		{"error", "us", false},
+	} {
+		msg := MessageData{Payload: map[string]interface{}{"level": tc.level}, Headers: map[string]string{"region": tc.region}}
+		if got := both.MatchMessage(msg); got != tc.match {
+			t.Errorf("%s from %s: matched %v, want %v", tc.level, tc.region, got, tc.match)
+		}
+	}
+
+	// An empty selector changes nothing, and an empty key is invalid
+	if same, err := errorsOnly.WithHeaders(map[string]string{}); err != nil || same != errorsOnly {
+		t.Errorf("empty selector = %v, %v", same, err)
+	}
+	if _, err := selector.WithHeaders(map[string]string{"": "eu"}); !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("empty header key: %v", err)
+	}
+}
+
+func TestHeaderSelectedSubscription(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	publish := func(id string, headers map[string]string) {
+		t.Helper()
+		msg := MessageData{ID: id, Payload: map[string]interface{}{"id": id}, Headers: headers}
+		if err := ps.Publish(ctx, "orders", msg, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	eu := map[string]string{"region": "eu"}
+	publish("old-1", eu)
+	publish("old-2", map[string]string{"region": "us"})
+	publish("old-3", nil)
+	publish("old-4", map[string]string{"region": "eu", "kind": "refund"})
+
+	// last_n replays the newest selected events
+	lastN := newTestClient("last-n")
+	ps.RegisterClient(lastN)
+	replay, err := ps.SubscribeWithOptions(ctx, lastN.id, "orders", SubscribeOptions{LastN: 1, Headers: eu}, lastN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replay) != 1 || replay[0].Message.ID != "old-4" || replay[0].Message.Headers["kind"] != "refund" {
+		t.Errorf("last_n replay = %+v", replay)
+	}
+
+	// A live subscription sees only new selected events, and since_seq
+	// replays the selected events after it first
+	live := newTestClient("live")
+	ps.RegisterClient(live)
+	if _, err := ps.SubscribeWithOptions(ctx, live.id, "orders", SubscribeOptions{Headers: eu}, live); err != nil {
+		t.Fatal(err)
+	}
+	sinceSeq := newTestClient("since-seq")
+	ps.RegisterClient(sinceSeq)
+	if _, err := ps.SubscribeWithOptions(ctx, sinceSeq.id, "orders", SubscribeOptions{SinceSeq: 1, Headers: eu}, sinceSeq); err != nil {
+		t.Fatal(err)
+	}
+
+	publish("new-1", nil)
+	publish("new-2", map[string]string{"region": "us"})
+	publish("new-3", map[string]string{"region": "eu", "kind": "order"})
+
+	ids := func(events []EventResponse) string {
+		var ids []string
+		for _, event := range events {
+			ids = append(ids, event.Message.ID)
+		}
+		return strings.Join(ids, ",")
+	}
+	if got := ids(sinceSeq.waitEvents(t, 2)); got != "old-4,new-3" {
+		t.Errorf("since_seq subscriber got %s", got)
+	}
+	// Messages without headers never match a selector
+	if got := ids(live.waitEvents(t, 1)); got != "new-3" {
+		t.Errorf("live subscriber got %s", got)
+	}
+	if got := ids(lastN.waitEvents(t, 1)); got != "new-3" {
+		t.Errorf("last_n subscriber got %s", got)
+	}
+	if events := history(t, ps, "orders"); events[len(events)-1].Message.Headers["kind"] != "order" {
+		t.Errorf("history lost the headers: %+v", events[len(events)-1])
+	}
+
+	opts := SubscribeOptions{Headers: map[string]string{"": "eu"}}
+	if _, err := ps.SubscribeWithOptions(ctx, live.id, "orders", opts, live); !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("subscribing with an empty header key = %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package pubsub
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// FirehoseEventType is the type of the event copies a firehose delivers
+const FirehoseEventType = "firehose"
+
+// FirehoseStats reports firehose delivery in GetStats
+type FirehoseStats struct {
+	Subscribers int   `json:"subscribers"`
+	Delivered   int64 `json:"delivered"`
+	Dropped     int64 `json:"dropped"` // Copies lost to a full client buffer
+}
+
+// firehoseSubscriber is one client receiving every published event
+type firehoseSubscriber struct {
+	clientID string
+	client   ClientInterface
+}
+
+// firehose tracks the clients tapping every topic. The subscriber list is
+// copy-on-write so publishes check for it with a single atomic load.
+type firehose struct {
+	subscribers atomic.Pointer[[]firehoseSubscriber]
+	mutex       sync.Mutex
+	delivered   atomic.Int64
+	dropped     atomic.Int64
+}
+
+// SubscribeFirehose sends clientID a copy of every event published to any
+// topic in any namespace, with type "firehose" and the origin namespace and
+// topic. The client isn't subscribed to the topics themselves: subscriber
+// counts, filters, acks and delivery interceptors don't apply, and copies
+// that don't fit the client's buffer are dropped and counted without being
+// dead-lettered. Callers are expected to restrict it to operators.
+func (ps *PubSubSystem) SubscribeFirehose(clientID string, client ClientInterface) {
+	ps.firehose.mutex.Lock()
+	defer ps.firehose.mutex.Unlock()
+
+	var subscribers []firehoseSubscriber
+	if current := ps.firehose.subscribers.Load(); current != nil {
+		for _, sub := range *current {
+			if sub.clientID != clientID {
+				subscribers = append(subscribers, sub)
+			}
+		}
+	}
+	subscribers = append(subscribers, firehoseSubscriber{clientID: clientID, client: client})
+	ps.firehose.subscribers.Store(&subscribers)
+}
+
+// UnsubscribeFirehose stops clientID's firehose. It reports whether the
+// client had one.
+func (ps *PubSubSystem) UnsubscribeFirehose(clientID string) bool {
+	ps.firehose.mutex.Lock()
+	defer ps.firehose.mutex.Unlock()
+
+	current := ps.firehose.subscribers.Load()
+	if current == nil {
+		return false
+	}
+	var subscribers []firehoseSubscriber
+	found := false
+	for _, sub := range *current {
+		if sub.clientID == clientID {
+			found = true
+			continue
+		}
+		subscribers = append(subscribers, sub)
+	}
+	if !found {
+		return false
+	}
+	if len(subscribers) == 0 {
+		ps.firehose.subscribers.Store(nil)
+	} else {
+		ps.firehose.subscribers.Store(&subscribers)
+	}
+	return true
+}
+
+// tapFirehose sends a copy of a published event to every firehose client.
+// Callers hold the topic lock, so copies arrive in sequence order per topic.
+func (ps *PubSubSystem) tapFirehose(event EventResponse) {
+	subscribers := ps.firehose.subscribers.Load()
+	if subscribers == nil {
+		return
+	}
+
+	tapped := event
+	tapped.Type = FirehoseEventType
+	tapped.Namespace, tapped.Topic = SplitTopic(event.Topic)
+	prepared := NewPreparedEvent(tapped)
+	for _, sub := range *subscribers {
+		if !sub.client.IsConnected() {
+			continue
+		}
+		if err := sub.client.SendMessage(prepared); err != nil {
+			ps.firehose.dropped.Add(1)
+			continue
+		}
+		ps.firehose.delivered.Add(1)
+	}
+}
+
+// firehoseStats returns the firehose counters, or nil if no client has used
+// one
+func (ps *PubSubSystem) firehoseStats() *FirehoseStats {
+	stats := FirehoseStats{
+		Delivered: ps.firehose.delivered.Load(),
+		Dropped:   ps.firehose.dropped.Load(),
+	}
+	if subscribers := ps.firehose.subscribers.Load(); subscribers != nil {
+		stats.Subscribers = len(*subscribers)
+	}
+	if stats == (FirehoseStats{}) {
+		return nil
+	}
+	return &stats
+}
@@ -0,0 +1,109 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFirehoseSeesEveryTopic(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	payments, err := QualifyTopic("acme", "payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"orders", payments} {
+		if err := ps.CreateTopic(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shipping := newTestClient("shipping")
+	subscribeClient(t, ps, "orders", shipping)
+	tap := newTestClient("tap")
+	ps.RegisterClient(tap)
+	ps.SubscribeFirehose(tap.id, tap)
+
+	publish := func(topic, id, sender string) {
+		t.Helper()
+		if err := ps.Publish(ctx, topic, MessageData{ID: id}, sender); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish("orders", "o-1", "")
+	publish(payments, "p-1", "")
+	// The tap's own publishes are copied to it too
+	publish("orders", "o-2", tap.id)
+
+	events := tap.waitEvents(t, 3)
+	for i, want := range []struct{ id, namespace, topic string }{
+		{"o-1", DefaultNamespace, "orders"},
+		{"p-1", "acme", "payments"},
+		{"o-2", DefaultNamespace, "orders"},
+	} {
+		event := events[i]
+		if event.Type != FirehoseEventType || event.Message.ID != want.id || event.Namespace != want.namespace || event.Topic != want.topic {
+			t.Errorf("firehose copy %d = %+v, want %s from %s/%s", i, event, want.id, want.namespace, want.topic)
+		}
+	}
+
+	// Topic subscribers get the event itself, and the tap counts as none
+	if events := shipping.waitEvents(t, 2); events[0].Type != "event" || events[0].Topic != "orders" {
+		t.Errorf("subscriber got %+v", events[0])
+	}
+	for _, name := range []string{"orders", payments} {
+		detail, err := ps.GetTopicDetail(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]int{"orders": 1, payments: 0}[name]; detail.Subscribers != want {
+			t.Errorf("%s has %d subscribers, want %d", name, detail.Subscribers, want)
+		}
+	}
+	if topics := ps.GetClientTopics(tap.id); len(topics) != 0 {
+		t.Errorf("firehose client is subscribed to %v", topics)
+	}
+	if stats := ps.GetStats().Firehose; stats == nil || stats.Subscribers != 1 || stats.Delivered != 3 {
+		t.Errorf("firehose stats = %+v", stats)
+	}
+
+	// Disconnecting ends it
+	ps.DisconnectClient(tap.id)
+	publish("orders", "o-3", "")
+	if got := len(tap.events()); got != 3 {
+		t.Errorf("disconnected tap got %d copies, want 3", got)
+	}
+	if ps.UnsubscribeFirehose(tap.id) {
+		t.Error("disconnected tap still had a firehose")
+	}
+}
+
+func TestFirehoseDropsAreCountedSeparately(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	stuck := fullClient{id: "stuck"}
+	ps.RegisterClient(stuck)
+	ps.SubscribeFirehose(stuck.id, stuck)
+	for i := 0; i < 3; i++ {
+		if err := ps.Publish(ctx, "orders", MessageData{ID: "m"}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if stats := ps.GetStats().Firehose; stats == nil || stats.Dropped != 3 || stats.Delivered != 0 {
+		t.Errorf("firehose stats = %+v", stats)
+	}
+	if dropped := ps.GetHealth().DroppedLastMinute; dropped != 0 {
+		t.Errorf("firehose drops counted as %d subscriber drops", dropped)
+	}
+
+	// Unsubscribing stops it, and the counters stay
+	if !ps.UnsubscribeFirehose(stuck.id) || ps.UnsubscribeFirehose(stuck.id) {
+		t.Error("UnsubscribeFirehose didn't report the one firehose")
+	}
+	if stats := ps.GetStats().Firehose; stats == nil || stats.Subscribers != 0 || stats.Dropped != 3 {
+		t.Errorf("firehose stats after unsubscribing = %+v", stats)
+	}
+}
@@ -0,0 +1,163 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Name of the hidden topic the readiness self-check publishes to
+	loopbackTopicName = "$loopback"
+
+	// How long the readiness self-check waits for its own message
+	readinessSelfCheckTimeout = time.Second
+)
+
+// loopbackClient is an in-process subscriber used by the readiness self-check
+type loopbackClient struct {
+	clientID string
+	received chan EventResponse
+}
+
+func (lc *loopbackClient) GetClientID() string {
+	return lc.clientID
+}
+
+func (lc *loopbackClient) IsConnected() bool {
+	return true
+}
+
+func (lc *loopbackClient) SendMessage(msg interface{}) error {
+	prepared, ok := msg.(*PreparedEvent)
+	if !ok {
+		return nil
+	}
+	select {
+	case lc.received <- prepared.Event:
+		return nil
+	default:
+		return ErrorData{Code: CodeServerBusy, Reason: "CLIENT_OVERLOADED", Message: "loopback buffer is full"}
+	}
+}
+
+func (lc *loopbackClient) GetLastActive() time.Time {
+	return time.Now()
+}
+
+// SetMaxConnections configures the websocket connection cap (0 = unlimited)
+func (ps *PubSubSystem) SetMaxConnections(max int) {
+	ps.clientMutex.Lock()
+	defer ps.clientMutex.Unlock()
+	ps.maxConnections = max
+}
+
+// AcquireConnection reserves a slot under the connection cap, reporting
+// false when the cap is reached. Each successful call must be paired with
+// one ReleaseConnection when the connection ends.
+func (ps *PubSubSystem) AcquireConnection() bool {
+	ps.clientMutex.RLock()
+	maxConnections := int64(ps.maxConnections)
+	ps.clientMutex.RUnlock()
+
+	for {
+		current := ps.connections.Load()
+		if maxConnections > 0 && current >= maxConnections {
+			return false
+		}
+		if ps.connections.CompareAndSwap(current, current+1) {
+			return true
+		}
+	}
+}
+
+// ReleaseConnection frees a slot taken by AcquireConnection
+func (ps *PubSubSystem) ReleaseConnection() {
+	ps.connections.Add(-1)
+}
+
+// OpenConnections returns how many slots taken by AcquireConnection are
+// still held
+func (ps *PubSubSystem) OpenConnections() int64 {
+	return ps.connections.Load()
+}
+
+// BeginShutdown marks the system as shutting down so readiness fails
+func (ps *PubSubSystem) BeginShutdown() {
+	ps.shuttingDown.Store(true)
+}
+
+// IsShuttingDown reports whether graceful shutdown has begun
+func (ps *PubSubSystem) IsShuttingDown() bool {
+	return ps.shuttingDown.Load()
+}
+
+// CheckReadiness reports whether the system should receive new traffic
+func (ps *PubSubSystem) CheckReadiness() ReadinessResponse {
+	if ps.IsShuttingDown() {
+		return ReadinessResponse{Status: "unavailable", Reason: "shutting down"}
+	}
+	if ps.IsDraining() {
+		return ReadinessResponse{Status: "unavailable", Reason: "draining"}
+	}
+
+	ps.clientMutex.RLock()
+	maxConnections := ps.maxConnections
+	ps.clientMutex.RUnlock()
+
+	if connections := int(ps.connections.Load()); maxConnections > 0 && connections >= maxConnections {
+		return ReadinessResponse{
+			Status: "unavailable",
+			Reason: fmt.Sprintf("connection limit reached (%d/%d)", connections, maxConnections),
+		}
+	}
+
+	if err := ps.selfCheck(readinessSelfCheckTimeout); err != nil {
+		return ReadinessResponse{Status: "unavailable", Reason: err.Error()}
+	}
+
+	return ReadinessResponse{Status: "ready"}
+}
+
+// selfCheck publishes to a hidden loopback topic through the regular
+// fan-out path and waits for the message to come back. Each probe gets its
+// own topic, so concurrent probes can't fill each other's channels.
+func (ps *PubSubSystem) selfCheck(timeout time.Duration) error {
+	client := &loopbackClient{
+		clientID: uuid.New().String(),
+		received: make(chan EventResponse, 1),
+	}
+	messageID := uuid.New().String()
+
+	go func() {
+		// Look the topic up the way a real publish does so a stuck topic
+		// map shard shows up as a failed check
+		ps.topics.get(loopbackTopicName)
+
+		probe := ps.newTopic(loopbackTopicName, 0)
+		probe.MessageHistory = NewEventBufferWithMaxAge(1, 0, ps.clock.Now)
+		probe.Subscribers[client.clientID] = &Subscriber{
+			ClientID: client.clientID,
+			Topic:    loopbackTopicName,
+			Client:   client,
+		}
+
+		ps.publishToTopic(context.Background(), probe, MessageData{ID: messageID}, 0, "", PublishOptions{})
+	}()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		select {
+		case event := <-client.received:
+			if event.Message.ID == messageID {
+				return nil
+			}
+		case <-deadline.C:
+			return fmt.Errorf("self-check publish not received within %s", timeout)
+		}
+	}
+}
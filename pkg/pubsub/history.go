@@ -0,0 +1,72 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ReadHistory returns up to limit events of a topic published before
+// beforeSeq, or before beforeTS, oldest first, as clientID may see them:
+// a private topic needs membership, and delivery interceptors drop events
+// the client wouldn't be delivered. A zero cursor starts from the newest
+// event. next is the before_seq of the following, older page, 0 once no
+// older events remain. Operators pass clientID "".
+func (ps *PubSubSystem) ReadHistory(ctx context.Context, clientID, topicName string, beforeSeq int64, beforeTS time.Time, limit int) ([]EventResponse, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	if limit <= 0 {
+		return nil, 0, errorOf(ErrInvalidRequest, "limit must be positive")
+	}
+	topic, exists := ps.topics.get(topicName)
+	if !exists {
+		return nil, 0, errorOf(ErrTopicNotFound, "topic %s not found", topicName)
+	}
+
+	topic.mutex.RLock()
+	admitted := clientID == "" || topic.admits(clientID)
+	var cursor int64
+	if !beforeTS.IsZero() && ps.sqlite == nil {
+		// The ring holds events in seq order, so the timestamp becomes the
+		// seq of the first event at or after it
+		cursor = topic.LastSeq + 1
+		scratch := acquireEvents()
+		history := topic.MessageHistory.AppendAll(*scratch)
+		for _, event := range history {
+			if !event.Timestamp.Before(beforeTS) {
+				cursor = event.Seq
+				break
+			}
+		}
+		releaseEvents(scratch, history)
+		beforeTS = time.Time{}
+	}
+	topic.mutex.RUnlock()
+	if !admitted {
+		return nil, 0, fmt.Errorf("%w: %s", ErrJoinRequired, topicName)
+	}
+	if cursor > 0 && (beforeSeq == 0 || cursor < beforeSeq) {
+		beforeSeq = cursor
+	}
+	if beforeSeq == 1 {
+		return []EventResponse{}, 0, nil
+	}
+
+	events, more, err := ps.QueryTopicMessages(topicName, HistoryQuery{BeforeSeq: beforeSeq, To: beforeTS, Limit: limit, Descending: true})
+	if err != nil {
+		return nil, 0, err
+	}
+	var next int64
+	if more && len(events) > 0 {
+		next = events[len(events)-1].Seq
+	}
+
+	page := make([]EventResponse, 0, len(events))
+	for i := len(events) - 1; i >= 0; i-- {
+		if ps.deliverable(events[i], clientID) {
+			page = append(page, events[i])
+		}
+	}
+	return page, next, nil
+}
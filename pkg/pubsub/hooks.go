@@ -0,0 +1,202 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Hooks receives instrumentation callbacks from a PubSubSystem. Any field
+// may be nil. Callbacks run synchronously on a broker goroutine but never
+// while the broker holds one of its locks, so they may call back into the
+// system; they may run concurrently with each other and must be cheap. A
+// panicking callback is recovered and logged.
+type Hooks struct {
+	OnPublish            func(topic string, size int) // size is the payload's marshaled JSON length
+	OnDeliver            func(topic, clientID string)
+	OnDrop               func(topic, clientID, reason string) // reason is a DeadLetter* constant
+	OnSubscribe          func(topic, clientID string)
+	OnUnsubscribe        func(topic, clientID string) // Also on disconnect and topic deletion
+	OnTopicCreated       func(topic string)           // Also for topics restored from disk or a snapshot
+	OnTopicDeleted       func(topic string)
+	OnClientConnected    func(clientID string)
+	OnClientDisconnected func(clientID string)
+
+	// How long an accepted publish took, from being received to being
+	// handed to subscribers and webhook workers
+	OnPublishLatency func(topic string, latency time.Duration)
+
+	// A topic's subscriber count crossed one of its watermarks
+	OnSubscriberWatermark func(event SubscriberWatermarkEvent)
+}
+
+// hookKind identifies the callback a hookEvent is for
+type hookKind int
+
+const (
+	hookPublish hookKind = iota
+	hookDeliver
+	hookDrop
+	hookSubscribe
+	hookUnsubscribe
+	hookTopicCreated
+	hookTopicDeleted
+	hookClientConnected
+	hookClientDisconnected
+	hookSubscriberWatermark
+	hookPublishLatency
+)
+
+var hookNames = [...]string{
+	hookPublish:             "OnPublish",
+	hookDeliver:             "OnDeliver",
+	hookDrop:                "OnDrop",
+	hookSubscribe:           "OnSubscribe",
+	hookUnsubscribe:         "OnUnsubscribe",
+	hookTopicCreated:        "OnTopicCreated",
+	hookTopicDeleted:        "OnTopicDeleted",
+	hookClientConnected:     "OnClientConnected",
+	hookClientDisconnected:  "OnClientDisconnected",
+	hookSubscriberWatermark: "OnSubscriberWatermark",
+	hookPublishLatency:      "OnPublishLatency",
+}
+
+// hookEvent is one callback waiting to run
+type hookEvent struct {
+	kind     hookKind
+	topic    string
+	clientID string
+	reason   string
+	size     int
+	latency  time.Duration
+
+	watermark *SubscriberWatermarkEvent // For hookSubscriberWatermark
+}
+
+// AddHooks registers instrumentation callbacks. Every registered set is
+// called, in registration order.
+func (ps *PubSubSystem) AddHooks(hooks Hooks) {
+	ps.hookMutex.Lock()
+	defer ps.hookMutex.Unlock()
+
+	var registered []Hooks
+	if current := ps.hooks.Load(); current != nil {
+		registered = append(registered, *current...)
+	}
+	registered = append(registered, hooks)
+	ps.hooks.Store(&registered)
+}
+
+// payloadSize returns payload's marshaled length for OnPublish, skipping
+// the work when no hooks are registered
+func (ps *PubSubSystem) payloadSize(payload interface{}) int {
+	if ps.hooks.Load() == nil {
+		return 0
+	}
+	data, _ := json.Marshal(payload)
+	return len(data)
+}
+
+// holdHooks starts a section that records hook events while holding broker
+// locks; they run at the matching releaseHooks. Call it before taking the
+// locks and releaseHooks after dropping them.
+func (ps *PubSubSystem) holdHooks() {
+	ps.hookHolds.Add(1)
+}
+
+// releaseHooks ends a holdHooks section and runs the events recorded so far
+func (ps *PubSubSystem) releaseHooks() {
+	ps.hookHolds.Add(-1)
+	ps.flushHooks()
+}
+
+// emit records a hook event. It runs at once unless a holdHooks section is
+// open, in which case that section's release runs it.
+func (ps *PubSubSystem) emit(event hookEvent) {
+	if ps.hooks.Load() == nil {
+		return
+	}
+
+	ps.hookMutex.Lock()
+	ps.hookPending = append(ps.hookPending, event)
+	ps.hookMutex.Unlock()
+
+	if ps.hookHolds.Load() == 0 {
+		ps.flushHooks()
+	}
+}
+
+// flushHooks runs every recorded hook event
+func (ps *PubSubSystem) flushHooks() {
+	registered := ps.hooks.Load()
+	if registered == nil {
+		return
+	}
+
+	ps.hookMutex.Lock()
+	pending := ps.hookPending
+	ps.hookPending = nil
+	ps.hookMutex.Unlock()
+
+	for _, event := range pending {
+		for i := range *registered {
+			callHook(&(*registered)[i], event)
+		}
+	}
+}
+
+// callHook runs one callback, recovering from a panic in it
+func callHook(hooks *Hooks, event hookEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Recovered from panic in %s hook: %v", hookNames[event.kind], r)
+		}
+	}()
+
+	switch event.kind {
+	case hookPublish:
+		if hooks.OnPublish != nil {
+			hooks.OnPublish(event.topic, event.size)
+		}
+	case hookDeliver:
+		if hooks.OnDeliver != nil {
+			hooks.OnDeliver(event.topic, event.clientID)
+		}
+	case hookDrop:
+		if hooks.OnDrop != nil {
+			hooks.OnDrop(event.topic, event.clientID, event.reason)
+		}
+	case hookSubscribe:
+		if hooks.OnSubscribe != nil {
+			hooks.OnSubscribe(event.topic, event.clientID)
+		}
+	case hookUnsubscribe:
+		if hooks.OnUnsubscribe != nil {
+			hooks.OnUnsubscribe(event.topic, event.clientID)
+		}
+	case hookTopicCreated:
+		if hooks.OnTopicCreated != nil {
+			hooks.OnTopicCreated(event.topic)
+		}
+	case hookTopicDeleted:
+		if hooks.OnTopicDeleted != nil {
+			hooks.OnTopicDeleted(event.topic)
+		}
+	case hookClientConnected:
+		if hooks.OnClientConnected != nil {
+			hooks.OnClientConnected(event.clientID)
+		}
+	case hookClientDisconnected:
+		if hooks.OnClientDisconnected != nil {
+			hooks.OnClientDisconnected(event.clientID)
+		}
+	case hookSubscriberWatermark:
+		if hooks.OnSubscriberWatermark != nil {
+			hooks.OnSubscriberWatermark(*event.watermark)
+		}
+	case hookPublishLatency:
+		if hooks.OnPublishLatency != nil {
+			hooks.OnPublishLatency(event.topic, event.latency)
+		}
+	}
+}
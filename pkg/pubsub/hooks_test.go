@@ -0,0 +1,203 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hookRecorder records the callbacks it receives, one line each
+type hookRecorder struct {
+	mutex sync.Mutex
+	calls []string
+}
+
+func (r *hookRecorder) record(format string, args ...interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.calls = append(r.calls, fmt.Sprintf(format, args...))
+}
+
+func (r *hookRecorder) hooks() Hooks {
+	return Hooks{
+		OnPublish:            func(topic string, size int) { r.record("publish %s %d", topic, size) },
+		OnDeliver:            func(topic, clientID string) { r.record("deliver %s %s", topic, clientID) },
+		OnDrop:               func(topic, clientID, reason string) { r.record("drop %s %s %s", topic, clientID, reason) },
+		OnSubscribe:          func(topic, clientID string) { r.record("subscribe %s %s", topic, clientID) },
+		OnUnsubscribe:        func(topic, clientID string) { r.record("unsubscribe %s %s", topic, clientID) },
+		OnTopicCreated:       func(topic string) { r.record("created %s", topic) },
+		OnTopicDeleted:       func(topic string) { r.record("deleted %s", topic) },
+		OnClientConnected:    func(clientID string) { r.record("connected %s", clientID) },
+		OnClientDisconnected: func(clientID string) { r.record("disconnected %s", clientID) },
+	}
+}
+
+func (r *hookRecorder) take() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	calls := r.calls
+	r.calls = nil
+	return calls
+}
+
+func TestHookCallbackSequence(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	var recorder hookRecorder
+	ps.AddHooks(recorder.hooks())
+
+	step := func(name string, fn func() error, want ...string) {
+		t.Helper()
+		if err := fn(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got := recorder.take()
+		// Subscribers are handed an event in no particular order
+		if len(got) == len(want) && len(got) > 1 && strings.HasPrefix(want[0], "publish ") {
+			sort.Strings(got[1:])
+			want = append([]string(nil), want...)
+			sort.Strings(want[1:])
+		}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("%s called\n  %s\nwant\n  %s", name, strings.Join(got, "\n  "), strings.Join(want, "\n  "))
+		}
+	}
+	reader := &recordingClient{id: "reader"}
+	stuck := fullClient{id: "stuck"}
+
+	step("creating a topic", func() error { return ps.CreateTopic(ctx, "orders") }, "created orders")
+	step("connecting", func() error { ps.RegisterClient(reader); ps.RegisterClient(stuck); return nil },
+		"connected reader", "connected stuck")
+	step("subscribing", func() error {
+		if _, err := ps.Subscribe(ctx, "reader", "orders", 0, reader); err != nil {
+			return err
+		}
+		_, err := ps.Subscribe(ctx, "stuck", "orders", 0, stuck)
+		return err
+	}, "subscribe orders reader", "subscribe orders stuck")
+	// The payload "12345" marshals to 7 bytes with its quotes
+	step("publishing", func() error { return ps.Publish(ctx, "orders", MessageData{ID: "m1", Payload: "12345"}, "") },
+		"publish orders 7", "deliver orders reader", "drop orders stuck "+DeadLetterBufferEvicted)
+	step("unsubscribing", func() error { return ps.Unsubscribe(ctx, "reader", "orders") }, "unsubscribe orders reader")
+	step("publishing to nobody that can take it", func() error { return ps.Publish(ctx, "orders", MessageData{ID: "m2", Payload: 1}, "") },
+		"publish orders 1", "drop orders stuck "+DeadLetterBufferEvicted)
+	step("disconnecting", func() error { ps.UnregisterClient("reader"); return nil }, "disconnected reader")
+	step("deleting the topic", func() error { return ps.DeleteTopic(ctx, "orders") }, "unsubscribe orders stuck", "deleted orders")
+
+	// Failed calls report nothing
+	step("publishing to a missing topic", func() error {
+		if err := ps.Publish(ctx, "orders", MessageData{ID: "m3", Payload: 1}, ""); err == nil {
+			return fmt.Errorf("published to a deleted topic")
+		}
+		return nil
+	})
+}
+
+func TestHooksMayCallBackIntoTheSystem(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	var subscribers []int
+	ps.AddHooks(Hooks{
+		// Reading broker state would deadlock if a lock were held
+		OnSubscribe: func(topic, clientID string) {
+			detail, _ := ps.GetTopicDetail(topic)
+			subscribers = append(subscribers, detail.Subscribers)
+		},
+		OnPublish: func(topic string, size int) { ps.GetStats() },
+		OnDeliver: func(topic, clientID string) { ps.GetClientTopics(clientID) },
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client := &recordingClient{id: "reader"}
+		ps.RegisterClient(client)
+		if _, err := ps.Subscribe(ctx, "reader", "orders", 0, client); err != nil {
+			t.Error(err)
+		}
+		if err := ps.Publish(ctx, "orders", MessageData{ID: "m", Payload: 1}, ""); err != nil {
+			t.Error(err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a hook calling into the system deadlocked")
+	}
+	if len(subscribers) != 1 || subscribers[0] != 1 {
+		t.Errorf("OnSubscribe saw subscriber counts %v", subscribers)
+	}
+}
+
+func TestPanickingHookIsRecovered(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	var recorder hookRecorder
+	ps.AddHooks(Hooks{OnTopicCreated: func(string) { panic("broken telemetry") }})
+	ps.AddHooks(recorder.hooks())
+
+	if err := ps.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	// Later hook sets still run, and the broker carries on
+	if got := recorder.take(); len(got) != 1 || got[0] != "created orders" {
+		t.Errorf("after the panic, recorded %v", got)
+	}
+	if err := ps.Publish(ctx, "orders", MessageData{ID: "m", Payload: 1}, ""); err != nil {
+		t.Errorf("publishing after the panic = %v", err)
+	}
+}
+
+func TestMetricsShareTheHooks(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	metrics := NewMetrics()
+	ps.AddHooks(metrics.Hooks())
+
+	if err := ps.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	reader := &recordingClient{id: "reader"}
+	subscribeClient(t, ps, "orders", reader)
+	subscribeClient(t, ps, "orders", fullClient{id: "stuck"})
+	for i := 0; i < 3; i++ {
+		if err := ps.Publish(ctx, "orders", MessageData{ID: "m", Payload: "12345"}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out strings.Builder
+	if _, err := metrics.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"pubsub_topics 1",
+		"pubsub_connected_clients 2",
+		`pubsub_subscriptions{topic="orders"} 2`,
+		`pubsub_messages_published_total{topic="orders"} 3`,
+		`pubsub_published_bytes_total{topic="orders"} 21`,
+		`pubsub_messages_delivered_total{topic="orders"} 3`,
+		`pubsub_messages_dropped_total{topic="orders",reason="buffer_evicted"} 3`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("metrics lack %q:\n%s", line, out.String())
+		}
+	}
+
+	// A deleted topic's series go
+	if err := ps.DeleteTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	metrics.WriteTo(&out)
+	if strings.Contains(out.String(), `topic="orders"`) || !strings.Contains(out.String(), "pubsub_topics 0\n") {
+		t.Errorf("after deleting the topic:\n%s", out.String())
+	}
+}
@@ -0,0 +1,105 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+)
+
+// PublishInterceptor inspects or rewrites a message before it is published.
+// It may modify msg, including its payload. Returning an error rejects the
+// publish; the publisher sees the error's code when it is an ErrorData, or
+// PUBLISH_REJECTED otherwise.
+type PublishInterceptor func(ctx context.Context, topic string, msg *MessageData, senderID string) error
+
+// DeliveryInterceptor decides whether one subscriber receives an event.
+// Returning false skips that subscriber; like a filter mismatch, the event
+// is neither delivered nor dropped.
+type DeliveryInterceptor func(topic string, msg MessageData, clientID string) bool
+
+// InterceptorError is returned by Publish when a publish interceptor
+// rejects a message
+type InterceptorError struct {
+	Code string // The interceptor's ErrorData code, or PUBLISH_REJECTED
+	Err  error
+}
+
+func (e *InterceptorError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the interceptor's error
+func (e *InterceptorError) Unwrap() error {
+	return e.Err
+}
+
+// AddPublishInterceptor registers an interceptor that runs on every
+// publish, after the payload limit and schema checks and before the message
+// is recorded in history or fanned out. Interceptors run in registration
+// order, each seeing the previous one's changes, and the first error stops
+// the chain. Changes they make are not checked against the limits or schema
+// again.
+func (ps *PubSubSystem) AddPublishInterceptor(interceptor PublishInterceptor) {
+	ps.interceptorMutex.Lock()
+	defer ps.interceptorMutex.Unlock()
+
+	var registered []PublishInterceptor
+	if current := ps.publishInterceptors.Load(); current != nil {
+		registered = append(registered, *current...)
+	}
+	registered = append(registered, interceptor)
+	ps.publishInterceptors.Store(&registered)
+}
+
+// AddDeliveryInterceptor registers an interceptor consulted before each
+// delivery to a subscriber, including last_n and since_seq catch-up and
+// explicit-ack consumers. Interceptors run in registration order and the
+// first to return false vetoes the delivery. They run while the topic is
+// locked, so they must be fast and must not call back into the system.
+func (ps *PubSubSystem) AddDeliveryInterceptor(interceptor DeliveryInterceptor) {
+	ps.interceptorMutex.Lock()
+	defer ps.interceptorMutex.Unlock()
+
+	var registered []DeliveryInterceptor
+	if current := ps.deliveryInterceptors.Load(); current != nil {
+		registered = append(registered, *current...)
+	}
+	registered = append(registered, interceptor)
+	ps.deliveryInterceptors.Store(&registered)
+}
+
+// intercept runs the publish interceptors over message
+func (ps *PubSubSystem) intercept(ctx context.Context, topic string, message *MessageData, senderID string) error {
+	registered := ps.publishInterceptors.Load()
+	if registered == nil {
+		return nil
+	}
+	for _, interceptor := range *registered {
+		if err := interceptor(ctx, topic, message, senderID); err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return err
+			}
+			code := "PUBLISH_REJECTED"
+			var errData ErrorData
+			if errors.As(err, &errData) && errData.Code != "" {
+				code = errData.Code
+			}
+			return &InterceptorError{Code: code, Err: err}
+		}
+	}
+	return nil
+}
+
+// deliverable reports whether every delivery interceptor allows clientID
+// to receive event. The readiness self-check is never intercepted.
+func (ps *PubSubSystem) deliverable(event EventResponse, clientID string) bool {
+	registered := ps.deliveryInterceptors.Load()
+	if registered == nil || event.Topic == loopbackTopicName {
+		return true
+	}
+	for _, interceptor := range *registered {
+		if !interceptor(event.Topic, event.Message, clientID) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,171 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// enrich stamps the server's time and region into object payloads
+func enrich(now time.Time) PublishInterceptor {
+	return func(ctx context.Context, topic string, msg *MessageData, senderID string) error {
+		if payload, ok := msg.Payload.(map[string]interface{}); ok {
+			payload["server_ts"] = now.Format(time.RFC3339)
+			payload["region"] = "eu-west-1"
+			payload["sender"] = senderID
+		}
+		return nil
+	}
+}
+
+// banWords rejects payloads containing any of words
+func banWords(words ...string) PublishInterceptor {
+	return func(ctx context.Context, topic string, msg *MessageData, senderID string) error {
+		text, _ := msg.Payload.(map[string]interface{})["text"].(string)
+		for _, word := range words {
+			if strings.Contains(text, word) {
+				return ErrorData{Code: "BANNED_WORD", Message: "message contains " + word}
+			}
+		}
+		return nil
+	}
+}
+
+func TestPublishInterceptorsEnrichAndVeto(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "chat"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	ps.AddPublishInterceptor(enrich(now))
+	ps.AddPublishInterceptor(banWords("spoiler"))
+	// Registered last, so it sees what the first one added
+	var seenRegions []interface{}
+	ps.AddPublishInterceptor(func(ctx context.Context, topic string, msg *MessageData, senderID string) error {
+		seenRegions = append(seenRegions, msg.Payload.(map[string]interface{})["region"])
+		return nil
+	})
+	reader := &recordingClient{id: "reader"}
+	subscribeClient(t, ps, "chat", reader)
+
+	if err := ps.Publish(ctx, "chat", MessageData{ID: "m1", Payload: map[string]interface{}{"text": "hello"}}, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	events := reader.waitEvents(t, 1)
+	payload, _ := events[0].Message.Payload.(map[string]interface{})
+	if payload["text"] != "hello" || payload["region"] != "eu-west-1" || payload["server_ts"] != "2026-01-01T12:00:00Z" || payload["sender"] != "alice" {
+		t.Errorf("delivered %v", payload)
+	}
+	if stored := history(t, ps, "chat"); len(stored) != 1 || stored[0].Message.Payload.(map[string]interface{})["region"] != "eu-west-1" {
+		t.Errorf("history holds %+v", stored)
+	}
+
+	// A veto stops the chain and surfaces its code; nothing is recorded
+	err := ps.Publish(ctx, "chat", MessageData{ID: "m2", Payload: map[string]interface{}{"text": "big spoiler"}}, "alice")
+	var interceptorErr *InterceptorError
+	if !errors.As(err, &interceptorErr) || interceptorErr.Code != "BANNED_WORD" {
+		t.Errorf("publishing a banned word = %v", err)
+	}
+	if len(seenRegions) != 1 {
+		t.Errorf("the chain went on after the veto: %v", seenRegions)
+	}
+	if detail, _ := ps.GetTopicDetail("chat"); detail.MessageCount != 1 || len(history(t, ps, "chat")) != 1 {
+		t.Errorf("the rejected publish was recorded: %+v", detail)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if got := len(reader.received()); got != 1 {
+		t.Errorf("%d events delivered, want only the accepted one", got)
+	}
+}
+
+func TestPublishInterceptorErrorCodes(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "chat"); err != nil {
+		t.Fatal(err)
+	}
+	var reject error
+	ps.AddPublishInterceptor(func(ctx context.Context, topic string, msg *MessageData, senderID string) error {
+		return reject
+	})
+
+	for _, tc := range []struct {
+		err  error
+		code string
+	}{
+		{errors.New("not today"), "PUBLISH_REJECTED"},
+		{ErrorData{Message: "no code"}, "PUBLISH_REJECTED"},
+		{ErrorData{Code: "QUOTA_EXCEEDED", Message: "over quota"}, "QUOTA_EXCEEDED"},
+	} {
+		reject = tc.err
+		err := ps.Publish(ctx, "chat", MessageData{ID: "m", Payload: 1}, "alice")
+		var interceptorErr *InterceptorError
+		if !errors.As(err, &interceptorErr) || interceptorErr.Code != tc.code || interceptorErr.Err.Error() != tc.err.Error() {
+			t.Errorf("rejecting with %v = %v, want %s", tc.err, err, tc.code)
+		}
+	}
+
+	// Context errors pass through unwrapped
+	reject = context.DeadlineExceeded
+	if err := ps.Publish(ctx, "chat", MessageData{ID: "m", Payload: 1}, "alice"); err != context.DeadlineExceeded {
+		t.Errorf("rejecting with a context error = %#v", err)
+	}
+}
+
+func TestDeliveryInterceptorMutes(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "chat"); err != nil {
+		t.Fatal(err)
+	}
+	// bob has muted mallory
+	muted := map[string]string{"bob": "mallory"}
+	ps.AddDeliveryInterceptor(func(topic string, msg MessageData, clientID string) bool {
+		return msg.Headers["from"] != muted[clientID]
+	})
+	publish := func(id, from string) {
+		t.Helper()
+		if err := ps.Publish(ctx, "chat", MessageData{ID: id, Payload: id, Headers: map[string]string{"from": from}}, from); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish("old-1", "alice")
+	publish("old-2", "mallory")
+
+	ids := func(events []EventResponse) string {
+		var ids []string
+		for _, event := range events {
+			ids = append(ids, event.Message.ID)
+		}
+		return strings.Join(ids, ",")
+	}
+
+	// The last_n replay is vetoed like live events
+	alice, bob := &recordingClient{id: "alice"}, &recordingClient{id: "bob"}
+	for client, want := range map[*recordingClient]string{alice: "old-1,old-2", bob: "old-1"} {
+		ps.RegisterClient(client)
+		replay, err := ps.SubscribeWithOptions(ctx, client.id, "chat", SubscribeOptions{LastN: 2}, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(replay); got != want {
+			t.Errorf("%s replayed %s, want %s", client.id, got, want)
+		}
+	}
+	publish("new-1", "mallory")
+	publish("new-2", "alice")
+
+	if got := ids(alice.waitEvents(t, 2)); got != "new-1,new-2" {
+		t.Errorf("alice got %s", got)
+	}
+	if got := ids(bob.waitEvents(t, 1)); got != "new-2" {
+		t.Errorf("bob got %s", got)
+	}
+	// Vetoed deliveries aren't drops
+	if dropped := ps.GetHealth().DroppedLastMinute; dropped != 0 {
+		t.Errorf("%d vetoed deliveries counted as drops", dropped)
+	}
+}
@@ -0,0 +1,65 @@
+package pubsub
+
+import "sync"
+
+// ipConnections counts open websocket connections per remote IP. There is
+// no per-client_id cap: the websocket transport binds a client ID, claimed
+// or taken from a client certificate, to one live connection at a time.
+type ipConnections struct {
+	mutex sync.Mutex
+	byIP  map[string]int
+	max   int
+}
+
+// SetMaxConnectionsPerIP caps concurrent websocket connections from one
+// remote IP (0 = unlimited)
+func (ps *PubSubSystem) SetMaxConnectionsPerIP(max int) {
+	ps.ipConns.mutex.Lock()
+	defer ps.ipConns.mutex.Unlock()
+	ps.ipConns.max = max
+}
+
+// MaxConnectionsPerIP returns the per-IP connection cap (0 = unlimited)
+func (ps *PubSubSystem) MaxConnectionsPerIP() int {
+	ps.ipConns.mutex.Lock()
+	defer ps.ipConns.mutex.Unlock()
+	return ps.ipConns.max
+}
+
+// AcquireIPConnection reserves a slot under ip's connection cap, reporting
+// false when ip is at the cap. Each successful call must be paired with one
+// ReleaseIPConnection when the connection ends, however it ends.
+func (ps *PubSubSystem) AcquireIPConnection(ip string) bool {
+	ps.ipConns.mutex.Lock()
+	defer ps.ipConns.mutex.Unlock()
+	if ps.ipConns.max > 0 && ps.ipConns.byIP[ip] >= ps.ipConns.max {
+		return false
+	}
+	ps.ipConns.byIP[ip]++
+	return true
+}
+
+// ReleaseIPConnection frees a slot taken by AcquireIPConnection
+func (ps *PubSubSystem) ReleaseIPConnection(ip string) {
+	ps.ipConns.mutex.Lock()
+	defer ps.ipConns.mutex.Unlock()
+	// Forget addresses without connections so the map doesn't grow with
+	// every IP ever seen
+	if ps.ipConns.byIP[ip] <= 1 {
+		delete(ps.ipConns.byIP, ip)
+		return
+	}
+	ps.ipConns.byIP[ip]--
+}
+
+// ConnectionsByIP returns how many websocket connections each remote IP
+// has open
+func (ps *PubSubSystem) ConnectionsByIP() map[string]int {
+	ps.ipConns.mutex.Lock()
+	defer ps.ipConns.mutex.Unlock()
+	counts := make(map[string]int, len(ps.ipConns.byIP))
+	for ip, n := range ps.ipConns.byIP {
+		counts[ip] = n
+	}
+	return counts
+}
@@ -0,0 +1,369 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AnshulDekate/pubsub/pkg/clock"
+)
+
+// Topic visibilities reported by GetTopicDetail and accepted by
+// SetTopicVisibility
+const (
+	TopicVisibilityPublic  = "public"
+	TopicVisibilityPrivate = "private"
+)
+
+// DefaultJoinTimeout is how long a join request waits for the owner's
+// decision by default
+const DefaultJoinTimeout = 5 * time.Minute
+
+var (
+	// ErrJoinRequired is returned when subscribing to a private topic the
+	// client isn't a member of
+	ErrJoinRequired = errors.New("topic is private; joining needs the owner's approval")
+
+	// ErrJoinDenied is returned to a join request the owner turned down
+	ErrJoinDenied = errors.New("join request denied")
+
+	// ErrJoinExpired is returned to a join request nobody decided in time
+	ErrJoinExpired = errors.New("join request expired")
+
+	// ErrJoinNotFound is returned when deciding a join request that isn't
+	// pending
+	ErrJoinNotFound = errors.New("join request not found")
+
+	// ErrInvalidVisibility is returned for a visibility other than public
+	// or private
+	ErrInvalidVisibility = errors.New("invalid topic visibility")
+)
+
+// JoinRequest is a client waiting to be admitted to a private topic
+type JoinRequest struct {
+	ClientID    string    `json:"client_id"`
+	RequestedAt time.Time `json:"requested_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// JoinDecision is called once with a join request's outcome: nil when it
+// was approved, ErrJoinDenied, ErrJoinExpired, or the error that cancelled
+// it
+type JoinDecision func(err error)
+
+// pendingJoin is a join request and the connection waiting on it
+type pendingJoin struct {
+	request JoinRequest
+	client  ClientInterface
+	decided JoinDecision
+	timer   clock.Timer
+}
+
+// joinRequests holds the pending join requests of every private topic
+type joinRequests struct {
+	mutex   sync.Mutex
+	timeout time.Duration
+	byTopic map[string]map[string]*pendingJoin // topic -> client ID -> request
+}
+
+// visibility returns the topic's visibility. Callers must hold the mutex.
+func (topic *Topic) visibility() string {
+	if topic.Private {
+		return TopicVisibilityPrivate
+	}
+	return TopicVisibilityPublic
+}
+
+// admits reports whether clientID may subscribe without a join request.
+// Callers must hold the mutex.
+func (topic *Topic) admits(clientID string) bool {
+	return !topic.Private || clientID == topic.Owner || topic.Members[clientID]
+}
+
+// CheckMember returns ErrJoinRequired unless clientID may read a topic's
+// history and receipts: anyone for a topic that isn't private, only the
+// owner and members for a private one. Anonymous readers pass "".
+func (ps *PubSubSystem) CheckMember(name, clientID string) error {
+	topic, exists := ps.topics.get(name)
+	if !exists {
+		return errorOf(ErrTopicNotFound, "topic %s not found", name)
+	}
+	topic.mutex.RLock()
+	admitted := !topic.Private || (clientID != "" && topic.admits(clientID))
+	topic.mutex.RUnlock()
+	if !admitted {
+		return fmt.Errorf("%w: %s", ErrJoinRequired, name)
+	}
+	return nil
+}
+
+// memberList returns the topic's members in order. Callers must hold the
+// mutex.
+func (topic *Topic) memberList() []string {
+	if len(topic.Members) == 0 {
+		return nil
+	}
+	members := make([]string, 0, len(topic.Members))
+	for clientID := range topic.Members {
+		members = append(members, clientID)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// memberSet builds a topic's member set from a stored list
+func memberSet(members []string) map[string]bool {
+	set := make(map[string]bool, len(members))
+	for _, clientID := range members {
+		set[clientID] = true
+	}
+	return set
+}
+
+// ParseVisibility reports whether a visibility makes a topic private. ""
+// is public, as topics are by default.
+func ParseVisibility(visibility string) (bool, error) {
+	switch visibility {
+	case "", TopicVisibilityPublic:
+		return false, nil
+	case TopicVisibilityPrivate:
+		return true, nil
+	}
+	return false, fmt.Errorf("%w: %q", ErrInvalidVisibility, visibility)
+}
+
+// SetJoinTimeout sets how long later join requests wait for a decision
+// before they expire; 0 restores DefaultJoinTimeout
+func (ps *PubSubSystem) SetJoinTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultJoinTimeout
+	}
+	ps.joins.mutex.Lock()
+	defer ps.joins.mutex.Unlock()
+	ps.joins.timeout = timeout
+}
+
+// SetTopicVisibility makes a topic public or private. Subscribers already
+// on a topic made private stay subscribed.
+func (ps *PubSubSystem) SetTopicVisibility(ctx context.Context, name, visibility string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	private, err := ParseVisibility(visibility)
+	if err != nil || visibility == "" {
+		return fmt.Errorf("%w: %q", ErrInvalidVisibility, visibility)
+	}
+	if err := checkUserTopic(name); err != nil {
+		return err
+	}
+	topic, exists := ps.topics.get(name)
+	if !exists {
+		return errorOf(ErrTopicNotFound, "topic %s not found", name)
+	}
+
+	topic.mutex.Lock()
+	if topic.Private == private {
+		topic.mutex.Unlock()
+		return nil
+	}
+	topic.Private = private
+	config := topic.config()
+	createdAt := topic.CreatedAt
+	topic.mutex.Unlock()
+
+	if ps.store != nil {
+		ps.store.TopicCreated(name, createdAt, config)
+	}
+	log.Printf("Topic %s is now %s", name, visibility)
+	return nil
+}
+
+// RequestJoin asks the owner of a private topic to admit client, which
+// must have been refused with ErrJoinRequired. The owner, when connected,
+// gets a join_request event naming the client. decided is called once,
+// from another goroutine, when the request is approved, denied or
+// expires; a new request from the same client ID replaces the old one.
+func (ps *PubSubSystem) RequestJoin(ctx context.Context, topicName string, client ClientInterface, decided JoinDecision) (JoinRequest, error) {
+	if err := ctx.Err(); err != nil {
+		return JoinRequest{}, err
+	}
+	clientID := client.GetClientID()
+	topic, exists := ps.topics.get(topicName)
+	if !exists {
+		return JoinRequest{}, errorOf(ErrTopicNotFound, "topic %s not found", topicName)
+	}
+	topic.mutex.RLock()
+	admitted := topic.admits(clientID)
+	owner := topic.Owner
+	topic.mutex.RUnlock()
+	if admitted {
+		return JoinRequest{}, errorOf(ErrInvalidRequest, "client %s may already subscribe to topic %s", clientID, topicName)
+	}
+
+	ps.joins.mutex.Lock()
+	now := ps.clock.Now()
+	pending := &pendingJoin{
+		request: JoinRequest{ClientID: clientID, RequestedAt: now, ExpiresAt: now.Add(ps.joins.timeout)},
+		client:  client,
+		decided: decided,
+	}
+	if ps.joins.byTopic[topicName] == nil {
+		ps.joins.byTopic[topicName] = make(map[string]*pendingJoin)
+	}
+	if previous, exists := ps.joins.byTopic[topicName][clientID]; exists {
+		previous.timer.Stop()
+	}
+	ps.joins.byTopic[topicName][clientID] = pending
+	pending.timer = ps.clock.AfterFunc(ps.joins.timeout, func() { ps.expireJoin(topicName, pending) })
+	ps.joins.mutex.Unlock()
+	log.Printf("Client %s asked to join private topic %s", clientID, topicName)
+
+	// An owner that isn't connected finds the request in the topic's detail
+	if owner != "" {
+		ps.clientMutex.RLock()
+		target, online := ps.clients[owner]
+		ps.clientMutex.RUnlock()
+		if online && target.IsConnected() {
+			notice := EventResponse{Type: "join_request", Topic: topicName, From: clientID, Timestamp: now}
+			if err := target.SendMessage(notice); err != nil {
+				log.Printf("Error sending join request for topic %s to owner %s: %v", topicName, owner, err)
+			}
+		}
+	}
+	return pending.request, nil
+}
+
+// DecideJoin approves or denies clientID's pending request to join a
+// private topic. An approved client becomes a member and may subscribe
+// from then on. A non-empty requester must own the topic; operators pass
+// "".
+func (ps *PubSubSystem) DecideJoin(ctx context.Context, topicName, requester, clientID string, approve bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if requester != "" {
+		if err := ps.checkOwner(topicName, requester); err != nil {
+			return err
+		}
+	}
+	topic, exists := ps.topics.get(topicName)
+	if !exists {
+		return errorOf(ErrTopicNotFound, "topic %s not found", topicName)
+	}
+
+	pending := ps.joins.take(topicName, clientID)
+	if pending == nil {
+		return fmt.Errorf("%w: %s has not asked to join topic %s", ErrJoinNotFound, clientID, topicName)
+	}
+
+	if !approve {
+		log.Printf("Join request of client %s to topic %s denied", clientID, topicName)
+		pending.decided(ErrJoinDenied)
+		return nil
+	}
+
+	topic.mutex.Lock()
+	if topic.Members == nil {
+		topic.Members = make(map[string]bool)
+	}
+	topic.Members[clientID] = true
+	config := topic.config()
+	createdAt := topic.CreatedAt
+	topic.mutex.Unlock()
+	if ps.store != nil {
+		ps.store.TopicCreated(topicName, createdAt, config)
+	}
+	log.Printf("Join request of client %s to topic %s approved", clientID, topicName)
+	pending.decided(nil)
+	return nil
+}
+
+// PendingJoins returns a topic's pending join requests, oldest first
+func (ps *PubSubSystem) PendingJoins(topicName string) []JoinRequest {
+	ps.joins.mutex.Lock()
+	defer ps.joins.mutex.Unlock()
+	var requests []JoinRequest
+	for _, pending := range ps.joins.byTopic[topicName] {
+		requests = append(requests, pending.request)
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].RequestedAt.Before(requests[j].RequestedAt) })
+	return requests
+}
+
+// WithdrawJoinRequests drops the pending join requests made by client,
+// whose connection closed, without deciding them. Requests made since by
+// another connection with the same client ID are kept.
+func (ps *PubSubSystem) WithdrawJoinRequests(client ClientInterface) {
+	ps.joins.mutex.Lock()
+	defer ps.joins.mutex.Unlock()
+	for topicName, pendings := range ps.joins.byTopic {
+		pending, exists := pendings[client.GetClientID()]
+		if !exists || pending.client != client {
+			continue
+		}
+		pending.timer.Stop()
+		ps.joins.remove(topicName, pending.request.ClientID)
+	}
+}
+
+// expireJoin turns down a join request nobody decided in time
+func (ps *PubSubSystem) expireJoin(topicName string, pending *pendingJoin) {
+	ps.joins.mutex.Lock()
+	if ps.joins.byTopic[topicName][pending.request.ClientID] != pending {
+		ps.joins.mutex.Unlock()
+		return
+	}
+	ps.joins.remove(topicName, pending.request.ClientID)
+	ps.joins.mutex.Unlock()
+
+	log.Printf("Join request of client %s to topic %s expired", pending.request.ClientID, topicName)
+	pending.decided(ErrJoinExpired)
+}
+
+// take removes and returns a pending join request, nil when there is none
+func (j *joinRequests) take(topicName, clientID string) *pendingJoin {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	pending, exists := j.byTopic[topicName][clientID]
+	if !exists {
+		return nil
+	}
+	pending.timer.Stop()
+	j.remove(topicName, clientID)
+	return pending
+}
+
+// remove forgets a join request. Callers must hold the mutex.
+func (j *joinRequests) remove(topicName, clientID string) {
+	delete(j.byTopic[topicName], clientID)
+	if len(j.byTopic[topicName]) == 0 {
+		delete(j.byTopic, topicName)
+	}
+}
+
+// dropTopic cancels the join requests of a deleted topic. Their decisions
+// are reported from another goroutine, since the caller holds topic locks.
+func (j *joinRequests) dropTopic(topicName string) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	for _, pending := range j.byTopic[topicName] {
+		pending.timer.Stop()
+		go pending.decided(fmt.Errorf("topic %s was deleted", topicName))
+	}
+	delete(j.byTopic, topicName)
+}
+
+// stop cancels the expiry of every pending join request
+func (j *joinRequests) stop() {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	for _, pendings := range j.byTopic {
+		for _, pending := range pendings {
+			pending.timer.Stop()
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestJoinApprovalMakesAMember(t *testing.T) {
+	dir := t.TempDir()
+	ps := openStore(t, dir)
+	ctx := context.Background()
+	if err := ps.CreateTopicWithConfig(ctx, "board", TopicConfig{Owner: "alice", Private: true}); err != nil {
+		t.Fatal(err)
+	}
+	bob := &recordingClient{id: "bob"}
+	ps.RegisterClient(bob)
+	if _, err := ps.Subscribe(ctx, "bob", "board", 0, bob); !errors.Is(err, ErrJoinRequired) {
+		t.Fatalf("subscribe without joining: %v", err)
+	}
+
+	// With the owner offline the request waits for a decision
+	outcome := make(chan error, 1)
+	if _, err := ps.RequestJoin(ctx, "board", bob, func(err error) { outcome <- err }); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.DecideJoin(ctx, "board", "bob", "bob", true); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("approval by a non-owner: %v", err)
+	}
+	if err := ps.DecideJoin(ctx, "board", "alice", "bob", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-outcome; err != nil {
+		t.Errorf("approved request reported %v", err)
+	}
+	ps.Close()
+
+	// Members subscribe directly, also after a restart
+	ps = openStore(t, dir)
+	defer ps.Close()
+	if detail, _ := ps.GetTopicDetail("board"); detail.Visibility != TopicVisibilityPrivate {
+		t.Errorf("restored visibility = %s", detail.Visibility)
+	}
+	if _, err := ps.Subscribe(ctx, "bob", "board", 0, bob); err != nil {
+		t.Errorf("member subscribe: %v", err)
+	}
+	if _, err := ps.Subscribe(ctx, "carol", "board", 0, &recordingClient{id: "carol"}); !errors.Is(err, ErrJoinRequired) {
+		t.Errorf("non-member subscribe: %v", err)
+	}
+}
+
+func TestWithdrawnJoinRequest(t *testing.T) {
+	ps := New()
+	defer ps.Close()
+	ctx := context.Background()
+	if err := ps.CreateTopicWithConfig(ctx, "board", TopicConfig{Owner: "alice", Private: true}); err != nil {
+		t.Fatal(err)
+	}
+	first, second := &recordingClient{id: "bob"}, &recordingClient{id: "bob"}
+	if _, err := ps.RequestJoin(ctx, "board", first, func(error) { t.Error("withdrawn request was decided") }); err != nil {
+		t.Fatal(err)
+	}
+	ps.WithdrawJoinRequests(first)
+	if pending := ps.PendingJoins("board"); len(pending) != 0 {
+		t.Fatalf("pending after withdrawing: %+v", pending)
+	}
+	if err := ps.DecideJoin(ctx, "board", "alice", "bob", false); !errors.Is(err, ErrJoinNotFound) {
+		t.Errorf("deciding a withdrawn request: %v", err)
+	}
+
+	// A later connection's request under the same ID is its own
+	outcome := make(chan error, 1)
+	if _, err := ps.RequestJoin(ctx, "board", second, func(err error) { outcome <- err }); err != nil {
+		t.Fatal(err)
+	}
+	ps.WithdrawJoinRequests(first)
+	if err := ps.DecideJoin(ctx, "board", "", "bob", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-outcome; !errors.Is(err, ErrJoinDenied) {
+		t.Errorf("denied request reported %v", err)
+	}
+}
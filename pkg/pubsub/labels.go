@@ -0,0 +1,103 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Limits on topic descriptions and labels, so operator metadata stays small
+const (
+	MaxTopicDescriptionLength = 1024
+	MaxTopicLabels            = 32
+	MaxLabelKeyLength         = 63
+	MaxLabelValueLength       = 256
+)
+
+// ErrInvalidTopicMetadata is returned for a description or labels over the
+// limits
+var ErrInvalidTopicMetadata = errors.New("invalid topic metadata")
+
+// checkTopicMetadata verifies a description and labels against the limits
+func checkTopicMetadata(description string, labels map[string]string) error {
+	if len(description) > MaxTopicDescriptionLength {
+		return fmt.Errorf("%w: description is longer than %d bytes", ErrInvalidTopicMetadata, MaxTopicDescriptionLength)
+	}
+	if len(labels) > MaxTopicLabels {
+		return fmt.Errorf("%w: more than %d labels", ErrInvalidTopicMetadata, MaxTopicLabels)
+	}
+	for key, value := range labels {
+		if key == "" || len(key) > MaxLabelKeyLength || strings.Contains(key, "=") {
+			return fmt.Errorf("%w: label key %q must be 1-%d bytes without \"=\"", ErrInvalidTopicMetadata, key, MaxLabelKeyLength)
+		}
+		if len(value) > MaxLabelValueLength {
+			return fmt.Errorf("%w: label %s value is longer than %d bytes", ErrInvalidTopicMetadata, key, MaxLabelValueLength)
+		}
+	}
+	return nil
+}
+
+// matchLabels reports whether labels has every key and value in selector
+func matchLabels(labels, selector map[string]string) bool {
+	for key, value := range selector {
+		if got, exists := labels[key]; !exists || got != value {
+			return false
+		}
+	}
+	return true
+}
+
+// SetTopicMetadata changes a topic's description, labels or both; a nil
+// argument leaves that field alone. Labels replace the existing set, and an
+// empty map clears it.
+func (ps *PubSubSystem) SetTopicMetadata(ctx context.Context, name string, description *string, labels map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := checkUserTopic(name); err != nil {
+		return err
+	}
+	var checkDescription string
+	if description != nil {
+		checkDescription = *description
+	}
+	if err := checkTopicMetadata(checkDescription, labels); err != nil {
+		return err
+	}
+
+	topic, exists := ps.topics.get(name)
+	if !exists {
+		return errorOf(ErrTopicNotFound, "topic %s not found", name)
+	}
+
+	topic.mutex.Lock()
+	if description != nil {
+		topic.Description = *description
+	}
+	if labels != nil {
+		// Replaced rather than changed in place, so configs copied before
+		// keep their own labels
+		topic.Labels = copyLabels(labels)
+	}
+	config := topic.config()
+	createdAt := topic.CreatedAt
+	topic.mutex.Unlock()
+
+	if ps.store != nil {
+		ps.store.TopicCreated(name, createdAt, config)
+	}
+	return nil
+}
+
+// copyLabels returns a copy of labels, or nil if there are none
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(labels))
+	for key, value := range labels {
+		copied[key] = value
+	}
+	return copied
+}
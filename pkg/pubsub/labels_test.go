@@ -0,0 +1,147 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTopicMetadataLimits(t *testing.T) {
+	ps := New()
+	tooMany := make(map[string]string)
+	for i := 0; i <= MaxTopicLabels; i++ {
+		tooMany[fmt.Sprint("k", i)] = "v"
+	}
+	for name, config := range map[string]TopicConfig{
+		"long description": {Description: strings.Repeat("d", MaxTopicDescriptionLength+1)},
+		"too many labels":  {Labels: tooMany},
+		"empty key":        {Labels: map[string]string{"": "v"}},
+		"long key":         {Labels: map[string]string{strings.Repeat("k", MaxLabelKeyLength+1): "v"}},
+		"key with =":       {Labels: map[string]string{"team=shop": "v"}},
+		"long value":       {Labels: map[string]string{"team": strings.Repeat("v", MaxLabelValueLength+1)}},
+	} {
+		if err := ps.CreateTopicWithConfig(context.Background(), "orders", config); !errors.Is(err, ErrInvalidTopicMetadata) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := ps.GetTopicDetail("orders"); err == nil {
+		t.Error("topic created with invalid metadata")
+	}
+
+	// At the limits is fine
+	atLimit := map[string]string{strings.Repeat("k", MaxLabelKeyLength): strings.Repeat("v", MaxLabelValueLength)}
+	config := TopicConfig{Description: strings.Repeat("d", MaxTopicDescriptionLength), Labels: atLimit}
+	if err := ps.CreateTopicWithConfig(context.Background(), "orders", config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetTopicMetadata(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	labels := map[string]string{"team": "shop", "tier": "gold"}
+	if err := ps.CreateTopicWithConfig(ctx, "orders", TopicConfig{Description: "Order events", Labels: labels}); err != nil {
+		t.Fatal(err)
+	}
+	// The topic keeps its own copy
+	labels["team"] = "changed"
+	detail, _ := ps.GetTopicDetail("orders")
+	if detail.Description != "Order events" || detail.Labels["team"] != "shop" || detail.Labels["tier"] != "gold" {
+		t.Fatalf("created detail = %+v", detail)
+	}
+
+	// Nil leaves a field alone, labels replace the whole set, and an
+	// empty map clears it
+	description := "Orders placed in the shop"
+	for _, step := range []struct {
+		description *string
+		labels      map[string]string
+		want        string
+		wantLabels  string
+	}{
+		{&description, nil, description, "team=shop,tier=gold"},
+		{nil, map[string]string{"team": "billing"}, description, "team=billing"},
+		{nil, map[string]string{}, description, ""},
+	} {
+		if err := ps.SetTopicMetadata(ctx, "orders", step.description, step.labels); err != nil {
+			t.Fatal(err)
+		}
+		detail, _ := ps.GetTopicDetail("orders")
+		var got []string
+		for _, key := range []string{"team", "tier"} {
+			if value, ok := detail.Labels[key]; ok {
+				got = append(got, key+"="+value)
+			}
+		}
+		if detail.Description != step.want || strings.Join(got, ",") != step.wantLabels || len(detail.Labels) != len(got) {
+			t.Errorf("detail = %q %v, want %q %s", detail.Description, detail.Labels, step.want, step.wantLabels)
+		}
+	}
+
+	if err := ps.SetTopicMetadata(ctx, "orders", nil, map[string]string{"": "x"}); !errors.Is(err, ErrInvalidTopicMetadata) {
+		t.Errorf("invalid labels: %v", err)
+	}
+	if err := ps.SetTopicMetadata(ctx, "missing", &description, nil); err == nil {
+		t.Error("changed an unknown topic's metadata")
+	}
+}
+
+func TestListTopicsByLabel(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	for name, labels := range map[string]map[string]string{
+		"orders":   {"team": "shop", "env": "prod"},
+		"carts":    {"team": "shop", "env": "staging"},
+		"invoices": {"team": "billing", "env": "prod"},
+		"scratch":  nil,
+	} {
+		if err := ps.CreateTopicWithConfig(ctx, name, TopicConfig{Labels: labels}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		labels map[string]string
+		want   string
+	}{
+		{map[string]string{"team": "shop"}, "carts,orders"},
+		{map[string]string{"team": "shop", "env": "prod"}, "orders"},
+		{map[string]string{"env": "prod"}, "invoices,orders"},
+		{map[string]string{"team": "ops"}, ""},
+		{map[string]string{"owner": ""}, ""},
+		{nil, "carts,invoices,orders,scratch"},
+	} {
+		topics, total, err := ps.ListTopics(DefaultNamespace, TopicListQuery{Labels: tc.labels})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := listNames(topics); got != tc.want || total != len(topics) {
+			t.Errorf("labels %v: %s of %d, want %s", tc.labels, got, total, tc.want)
+		}
+	}
+}
+
+func TestTopicMetadataIsPersisted(t *testing.T) {
+	dir := t.TempDir()
+	ps := openStore(t, dir)
+	ctx := context.Background()
+	if err := ps.CreateTopicWithConfig(ctx, "orders", TopicConfig{Description: "Order events", Labels: map[string]string{"team": "shop"}}); err != nil {
+		t.Fatal(err)
+	}
+	description := "Orders placed in the shop"
+	if err := ps.SetTopicMetadata(ctx, "orders", &description, map[string]string{"team": "billing"}); err != nil {
+		t.Fatal(err)
+	}
+	ps.Close()
+
+	ps = openStore(t, dir)
+	defer ps.Close()
+	detail, err := ps.GetTopicDetail("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detail.Description != description || len(detail.Labels) != 1 || detail.Labels["team"] != "billing" {
+		t.Errorf("restored detail = %+v", detail)
+	}
+}
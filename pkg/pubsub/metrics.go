@@ -0,0 +1,100 @@
+package pubsub
+
+import (
+	"sync"
+	"time"
+)
+
+// slidingCounter counts events over a trailing window using fixed-width
+// time buckets
+type slidingCounter struct {
+	buckets []int64
+	stamps  []int64 // Bucket number (Unix seconds / width) each bucket was last written for
+	width   int64   // Bucket width in seconds
+	now     func() time.Time
+	mutex   sync.Mutex
+}
+
+// newSlidingCounter creates a counter covering the given window with one
+// bucket per second
+func newSlidingCounter(window time.Duration) *slidingCounter {
+	return newSlidingCounterWithClock(window, time.Second, nil)
+}
+
+// newSlidingCounterWithClock creates a counter covering window with buckets
+// of the given width. now is the clock; nil means time.Now.
+func newSlidingCounterWithClock(window, bucket time.Duration, now func() time.Time) *slidingCounter {
+	width := int64(bucket / time.Second)
+	if width < 1 {
+		width = 1
+	}
+	count := int(int64(window/time.Second) / width)
+	if count < 1 {
+		count = 1
+	}
+	if now == nil {
+		now = time.Now
+	}
+	return &slidingCounter{
+		buckets: make([]int64, count),
+		stamps:  make([]int64, count),
+		width:   width,
+		now:     now,
+	}
+}
+
+// Add records n events at the current time
+func (sc *slidingCounter) Add(n int64) {
+	now := sc.now().Unix() / sc.width
+	idx := int(now % int64(len(sc.buckets)))
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	if sc.stamps[idx] != now {
+		sc.stamps[idx] = now
+		sc.buckets[idx] = 0
+	}
+	sc.buckets[idx] += n
+}
+
+// Sum returns the number of events recorded within the window
+func (sc *slidingCounter) Sum() int64 {
+	now := sc.now().Unix() / sc.width
+	oldest := now - int64(len(sc.buckets))
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	var total int64
+	for i, stamp := range sc.stamps {
+		if stamp > oldest && stamp <= now {
+			total += sc.buckets[i]
+		}
+	}
+	return total
+}
+
+// Window returns the span the counter covers
+func (sc *slidingCounter) Window() time.Duration {
+	return time.Duration(int64(len(sc.buckets))*sc.width) * time.Second
+}
+
+// Take returns the number of events recorded within the window and starts
+// the counter over from zero
+func (sc *slidingCounter) Take() int64 {
+	now := sc.now().Unix() / sc.width
+	oldest := now - int64(len(sc.buckets))
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	var total int64
+	for i, stamp := range sc.stamps {
+		if stamp > oldest && stamp <= now {
+			total += sc.buckets[i]
+		}
+		sc.buckets[i] = 0
+	}
+	return total
+}
@@ -0,0 +1,1069 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Request message types
+type SubscribeRequest struct {
+	Type       string            `json:"type"`
+	Topic      string            `json:"topic"`
+	ClientID   string            `json:"client_id,omitempty"` // Optional - server generates if not provided
+	LastN      int               `json:"last_n,omitempty"`
+	SinceSeq   int64             `json:"since_seq,omitempty"`   // Replay history after this seq before live events
+	Batch      bool              `json:"batch,omitempty"`       // Opt the connection into JSON array frames
+	AckMode    string            `json:"ack_mode,omitempty"`    // "explicit" for at-least-once delivery with msg_ack
+	Filter     *Filter           `json:"filter,omitempty"`      // Only deliver events whose payload matches
+	Headers    map[string]string `json:"headers,omitempty"`     // Only deliver events with all of these headers
+	Firehose   bool              `json:"firehose,omitempty"`    // Tap every topic instead; admin connections only
+	LastWill   *LastWill         `json:"last_will,omitempty"`   // Published if the connection drops
+	TTLSeconds int               `json:"ttl_seconds,omitempty"` // Unsubscribe automatically after this long
+	FullTopic  bool              `json:"full_topic,omitempty"`  // Name the topic in full on a connection using topic aliases
+	RequestID  string            `json:"request_id"`
+}
+
+// FilterPredicate tests one payload field, addressed by a dot path such as
+// "meta.level"
+type FilterPredicate struct {
+	Path  string      `json:"path,omitempty"`
+	Op    string      `json:"op,omitempty"` // eq, ne, in, gt, gte, lt or lte
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Filter is either a single predicate or a list of predicates in All;
+// every predicate must hold for an event to be delivered
+type Filter struct {
+	FilterPredicate
+	All []FilterPredicate `json:"all,omitempty"`
+}
+
+type UnsubscribeRequest struct {
+	Type      string `json:"type"`
+	Topic     string `json:"topic"`
+	ClientID  string `json:"client_id,omitempty"` // Optional - server uses connection's client ID
+	Firehose  bool   `json:"firehose,omitempty"`  // Stop the connection's firehose
+	RequestID string `json:"request_id"`
+}
+
+// TouchSubscriptionRequest restarts the TTL of a subscription made with
+// ttl_seconds
+type TouchSubscriptionRequest struct {
+	Type      string `json:"type"`
+	Topic     string `json:"topic"`
+	ClientID  string `json:"client_id,omitempty"` // Optional - server uses connection's client ID
+	RequestID string `json:"request_id"`
+}
+
+type PublishRequest struct {
+	Type      string      `json:"type"`
+	Topic     string      `json:"topic"`
+	Message   MessageData `json:"message"`
+	ClientID  string      `json:"client_id,omitempty"` // Optional - used to set client ID if not already set
+	RequestID string      `json:"request_id"`
+
+	// Events with the same key are delivered in publish order
+	OrderingKey string `json:"ordering_key,omitempty"`
+
+	// False fans the message out without keeping it in history, for typing
+	// indicators and the like; nil means true
+	Store *bool `json:"store,omitempty"`
+
+	// Also drop the message rather than buffer it for subscribers that are
+	// behind or paused; implies store false
+	Volatile bool `json:"volatile,omitempty"`
+
+	// On compacted topics, history keeps only the newest message per key
+	CompactKey string `json:"compact_key,omitempty"`
+
+	// Hold the message and publish it at a time, or after a delay; at most
+	// one is set
+	DeliverAt *time.Time `json:"deliver_at,omitempty"`
+	DelayMs   int64      `json:"delay_ms,omitempty"`
+
+	// Copied onto the event so responders know where to reply and how to
+	// match the reply to the request
+	ReplyTo       string `json:"reply_to,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+
+	// How long a publish_and_wait waits for its reply; 0 is the default
+	TimeoutMs int64 `json:"timeout_ms,omitempty"`
+
+	// PublishAckNone or PublishAckBatch; empty acknowledges each publish
+	Ack string `json:"ack,omitempty"`
+
+	// Track which explicit-ack subscribers ack the message and push their
+	// acks back as receipt frames
+	Receipts bool `json:"receipts,omitempty"`
+}
+
+// How a publish is acknowledged, when not one ack per request
+const (
+	PublishAckNone  = "none"  // Only errors are answered
+	PublishAckBatch = "batch" // One cumulative ack lists the accepted message IDs
+)
+
+// Options returns the publish options the request asks for
+func (req PublishRequest) Options() PublishOptions {
+	return PublishOptions{
+		OrderingKey: req.OrderingKey,
+		CompactKey:  req.CompactKey,
+		Ephemeral:   req.Store != nil && !*req.Store,
+		Volatile:    req.Volatile,
+
+		ReplyTo:       req.ReplyTo,
+		CorrelationID: req.CorrelationID,
+		Receipts:      req.Receipts,
+	}
+}
+
+// MsgAckRequest acknowledges events on an explicit-ack subscription, either
+// one delivery tag or everything up to and including a topic seq
+type MsgAckRequest struct {
+	Type        string `json:"type"`
+	Topic       string `json:"topic"`
+	DeliveryTag int64  `json:"delivery_tag,omitempty"`
+	UpToSeq     int64  `json:"up_to_seq,omitempty"`
+	RequestID   string `json:"request_id"`
+}
+
+// SendToClientRequest sends a message to one client by its ID, outside any
+// topic
+type SendToClientRequest struct {
+	Type            string      `json:"type"`
+	Target          string      `json:"target_client_id"`
+	Message         MessageData `json:"message"`
+	BufferIfOffline bool        `json:"buffer_if_offline,omitempty"` // Hold it until the target connects
+	CorrelationID   string      `json:"correlation_id,omitempty"`    // Matches a reply to its request
+	ClientID        string      `json:"client_id,omitempty"`
+	RequestID       string      `json:"request_id"`
+}
+
+// CancelScheduledRequest cancels a scheduled message by the token its
+// publish was acknowledged with
+type CancelScheduledRequest struct {
+	Type      string `json:"type"`
+	Token     string `json:"token"`
+	RequestID string `json:"request_id"`
+}
+
+// GetHistoryRequest reads a page of a topic's history without touching
+// the connection's subscriptions. before_seq and before_ts are exclusive
+// cursors; with neither the page ends at the newest message.
+type GetHistoryRequest struct {
+	Type      string     `json:"type"`
+	Topic     string     `json:"topic"`
+	Limit     int        `json:"limit,omitempty"`
+	BeforeSeq int64      `json:"before_seq,omitempty"`
+	BeforeTS  *time.Time `json:"before_ts,omitempty"`
+	RequestID string     `json:"request_id"`
+}
+
+// TopicRequest creates, deletes or transfers a topic over the websocket.
+// The creator owns the topic; deleting and transferring it are reserved to
+// its owner and admin connections.
+type TopicRequest struct {
+	Type      string `json:"type"` // "create_topic", "delete_topic" or "transfer_topic"
+	Topic     string `json:"topic"`
+	ClientID  string `json:"client_id,omitempty"`
+	NewOwner  string `json:"new_owner,omitempty"` // Client ID a transfer_topic hands the topic to
+	RequestID string `json:"request_id"`
+
+	// "public" or "private" for create_topic; private topics admit
+	// subscribers once the owner approves them
+	Visibility string `json:"visibility,omitempty"`
+}
+
+// JoinDecisionRequest approves or denies a client's pending request to join
+// a private topic. Only the topic's owner and admin connections may decide.
+type JoinDecisionRequest struct {
+	Type      string `json:"type"` // "approve_join" or "deny_join"
+	Topic     string `json:"topic"`
+	ClientID  string `json:"client_id"` // The client that asked to join
+	RequestID string `json:"request_id"`
+}
+
+// PauseRequest stops delivery on one of the client's subscriptions,
+// buffering events until a ResumeRequest
+type PauseRequest struct {
+	Type      string `json:"type"`
+	Topic     string `json:"topic"`
+	ClientID  string `json:"client_id,omitempty"`
+	RequestID string `json:"request_id"`
+}
+
+// ResumeRequest delivers the events buffered by a PauseRequest and resumes
+// live delivery
+type ResumeRequest struct {
+	Type      string `json:"type"`
+	Topic     string `json:"topic"`
+	ClientID  string `json:"client_id,omitempty"`
+	RequestID string `json:"request_id"`
+}
+
+type PingRequest struct {
+	Type      string `json:"type"`
+	RequestID string `json:"request_id"`
+}
+
+// ChunkBegin starts a message sent in chunks because it doesn't fit one
+// frame: a publish from a client ("publish_begin") or an event to one
+// ("event_begin"). Chunks follow in order, then a ChunkEnd.
+type ChunkBegin struct {
+	Type      string `json:"type"`
+	Topic     string `json:"topic"`
+	MessageID string `json:"message_id"`
+	TotalSize int    `json:"total_size"` // Bytes of the reassembled data
+	Chunks    int    `json:"chunks"`
+	ClientID  string `json:"client_id,omitempty"`
+	RequestID string `json:"request_id,omitempty"` // Answers the whole publish
+}
+
+// Chunk carries one fragment of a chunked message ("publish_chunk" or
+// "event_chunk"), numbered from 0
+type Chunk struct {
+	Type      string `json:"type"`
+	MessageID string `json:"message_id"`
+	Index     int    `json:"index"`
+	Data      []byte `json:"data"` // Base64 in JSON, binary in MessagePack
+}
+
+// ChunkEnd completes a chunked message ("publish_end" or "event_end")
+type ChunkEnd struct {
+	Type      string `json:"type"`
+	MessageID string `json:"message_id"`
+}
+
+// ProbeAckRequest answers a server's ProbeMessage
+type ProbeAckRequest struct {
+	Type    string `json:"type"`
+	ProbeID string `json:"probe_id"`
+}
+
+type MessageData struct {
+	ID      string            `json:"id"`
+	Payload interface{}       `json:"payload"`
+	Headers map[string]string `json:"headers,omitempty"` // Routing metadata, matched by subscription header selectors
+}
+
+// Response message types
+type AckResponse struct {
+	Type      string       `json:"type"`
+	RequestID string       `json:"request_id"`
+	Topic     string       `json:"topic,omitempty"`
+	Status    string       `json:"status"`
+	Resume    *ResumeStats `json:"resume,omitempty"`  // Set only when acknowledging a resume
+	Connect   bool         `json:"connect,omitempty"` // Answers a subscription made in the websocket URL
+	Token     string       `json:"token,omitempty"`   // Cancels a scheduled publish
+	DeliverAt *time.Time   `json:"deliver_at,omitempty"`
+	ExpiresAt *time.Time   `json:"expires_at,omitempty"` // When a pending join request expires
+
+	// Number the subscribed topic's events name it by, on connections that
+	// negotiated topic aliases
+	TopicAlias int `json:"topic_alias,omitempty"`
+
+	// The client_id a request bound the connection to, and the secret to
+	// present when reconnecting as it; set on the first ack after binding
+	ClientID    string `json:"client_id,omitempty"`
+	ResumeToken string `json:"resume_token,omitempty"`
+
+	// Message IDs a cumulative publish ack accepts
+	MessageIDs []string  `json:"message_ids,omitempty"`
+	Timestamp  time.Time `json:"ts"`
+}
+
+// ResumeStats reports what happened to events while a subscription was
+// paused
+type ResumeStats struct {
+	Buffered int `json:"buffered"` // Events held and delivered on resume
+	Evicted  int `json:"evicted"`  // Events dropped because the buffer was full
+}
+
+type EventResponse struct {
+	Type      string      `json:"type"`
+	Topic     string      `json:"topic"`
+	Message   MessageData `json:"message"`
+	Seq       int64       `json:"seq,omitempty"`
+	Timestamp time.Time   `json:"ts"`
+
+	// Set when the publisher gave one
+	OrderingKey string `json:"ordering_key,omitempty"`
+
+	// Set only on explicit-ack subscriptions
+	DeliveryTag int64 `json:"delivery_tag,omitempty"`
+	Redelivered bool  `json:"redelivered,omitempty"`
+
+	// Set only on firehose copies, whose topic is named within it
+	Namespace string `json:"namespace,omitempty"`
+
+	// Set when the topic signs its events
+	Signature *Signature `json:"signature,omitempty"`
+
+	// Set when the publisher gave one
+	CompactKey string `json:"compact_key,omitempty"`
+
+	// Set on events kept in no history, which carry no seq
+	Ephemeral bool `json:"ephemeral,omitempty"`
+	Volatile  bool `json:"volatile,omitempty"`
+
+	// Sender's client ID, set on direct events
+	From string `json:"from,omitempty"`
+
+	// Set when the publisher asks for replies
+	ReplyTo       string `json:"reply_to,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+
+	// Who removed the message, set on message_deleted events when known
+	Actor string `json:"actor,omitempty"`
+
+	// How this copy of the event reached the subscriber; set on every
+	// delivery, never on stored history
+	Delivery *DeliveryInfo `json:"delivery,omitempty"`
+
+	// Span the event's deliveries are traced under; unset while tracing is
+	// off
+	trace trace.SpanContext
+}
+
+// DeliveryInfo tells a subscriber whether an event is live, replayed from
+// history, or sent again after going unacknowledged
+type DeliveryInfo struct {
+	Redelivered bool `json:"redelivered"`      // Sent before without being acked
+	Attempt     int  `json:"delivery_attempt"` // 1 on the first delivery to this subscriber
+	Replay      bool `json:"replay"`           // From history: last_n or since_seq
+}
+
+// ReplyResponse answers a publish_and_wait with the first reply sent to
+// its inbox
+type ReplyResponse struct {
+	Type          string      `json:"type"`
+	RequestID     string      `json:"request_id"`
+	Topic         string      `json:"topic"`
+	CorrelationID string      `json:"correlation_id,omitempty"`
+	From          string      `json:"from,omitempty"` // Client ID of the responder
+	Message       MessageData `json:"message"`
+	Timestamp     time.Time   `json:"ts"`
+}
+
+// HistoryResponse answers a get_history with its page, oldest first.
+// NextCursor is the before_seq of the next, older page; nil when there is
+// none.
+type HistoryResponse struct {
+	Type       string          `json:"type"`
+	RequestID  string          `json:"request_id"`
+	Topic      string          `json:"topic"`
+	Messages   []EventResponse `json:"messages"`
+	NextCursor *int64          `json:"next_cursor"`
+	Timestamp  time.Time       `json:"ts"`
+}
+
+// ReceiptResponse pushes to a publisher the acks its receipt-tracked
+// messages got since the last one
+type ReceiptResponse struct {
+	Type      string          `json:"type"` // "receipt"
+	Receipts  []ReceiptUpdate `json:"receipts"`
+	Timestamp time.Time       `json:"ts"`
+}
+
+// ReceiptUpdate is one subscriber's ack of a message, with the message's
+// running totals
+type ReceiptUpdate struct {
+	Topic     string    `json:"topic"`
+	MessageID string    `json:"message_id"`
+	ClientID  string    `json:"client_id"`
+	AckedAt   time.Time `json:"acked_at"`
+	Acked     int       `json:"acked"`
+	Expected  int       `json:"expected"`
+}
+
+// Signature is an HMAC over an event, made with the key named by KeyID so
+// keys can be rotated
+type Signature struct {
+	KeyID     string `json:"key_id"`
+	Algorithm string `json:"alg"`
+	Value     string `json:"value"` // Base64 MAC
+}
+
+type ErrorResponse struct {
+	Type      string    `json:"type"`
+	RequestID string    `json:"request_id,omitempty"`
+	Topic     string    `json:"topic,omitempty"`   // Set only on a connect error, naming its topic
+	Connect   bool      `json:"connect,omitempty"` // Answers a subscription made in the websocket URL
+	Error     ErrorData `json:"error"`
+	Timestamp time.Time `json:"ts"`
+}
+
+type ErrorData struct {
+	Code      string   `json:"code"`
+	Message   string   `json:"message"`
+	Details   []string `json:"details,omitempty"` // e.g. the first schema validation errors
+	Limit     int      `json:"limit,omitempty"`   // The limit that was exceeded, for PAYLOAD_TOO_LARGE errors
+	Field     string   `json:"field,omitempty"`   // The offending request field, for VALIDATION_FAILED errors
+	Reason    string   `json:"reason,omitempty"`  // A more specific code, e.g. JOIN_REQUIRED under PERMISSION_DENIED
+	Retryable bool     `json:"retryable"`         // Whether the same request may succeed if sent again later
+}
+
+// Error implements the error interface
+func (e ErrorData) Error() string {
+	return e.Message
+}
+
+type PongResponse struct {
+	Type       string    `json:"type"`
+	RequestID  string    `json:"request_id"`
+	ServerTime int64     `json:"server_time_ms"` // Server clock in Unix milliseconds, for measuring clock skew
+	Timestamp  time.Time `json:"ts"`
+}
+
+// ProbeMessage asks a connection that negotiated the probe capability to
+// show it is alive by answering with a ProbeAckRequest
+type ProbeMessage struct {
+	Type      string    `json:"type"`
+	ProbeID   string    `json:"probe_id"`
+	Timestamp time.Time `json:"ts"`
+}
+
+// Websocket protocol versions. A connection speaks DefaultProtocolVersion
+// until a hello negotiates another.
+const (
+	ProtocolVersion1 = 1 // Every response is wrapped in the event shape
+	ProtocolVersion2 = 2 // Responses use their own shapes; client_id may be omitted
+
+	DefaultProtocolVersion = ProtocolVersion1
+	MaxProtocolVersion     = ProtocolVersion2
+)
+
+// WelcomeResponse is sent once, right after the websocket upgrade
+type WelcomeResponse struct {
+	Type               string        `json:"type"`
+	ClientID           string        `json:"client_id"`        // Server-assigned; adopt it or claim another in the first request
+	ResumeToken        string        `json:"resume_token"`     // Secret to present when reconnecting as ClientID
+	ProtocolVersion    int           `json:"protocol_version"` // Version in effect until a hello
+	MaxProtocolVersion int           `json:"max_protocol_version"`
+	Limits             WelcomeLimits `json:"limits"`
+	Timestamp          time.Time     `json:"ts"`
+}
+
+// HelloRequest is the optional first client message, selecting a protocol
+// version and announcing the client's capabilities
+type HelloRequest struct {
+	Type            string    `json:"type"`
+	ProtocolVersion int       `json:"protocol_version"`
+	Capabilities    []string  `json:"capabilities,omitempty"`
+	Namespace       string    `json:"namespace,omitempty"`    // Namespace the connection's topics live in
+	LastWill        *LastWill `json:"last_will,omitempty"`    // Published if the connection drops
+	ResumeToken     string    `json:"resume_token,omitempty"` // Proves the right to reclaim a client_id
+	RequestID       string    `json:"request_id,omitempty"`
+}
+
+// HelloAckResponse answers a hello with the negotiated version
+type HelloAckResponse struct {
+	Type            string        `json:"type"`
+	RequestID       string        `json:"request_id,omitempty"`
+	ProtocolVersion int           `json:"protocol_version"`
+	Capabilities    []string      `json:"capabilities"` // What the server supports
+	Namespace       string        `json:"namespace"`    // Namespace the connection is bound to
+	Limits          WelcomeLimits `json:"limits"`
+	Timestamp       time.Time     `json:"ts"`
+}
+
+// WelcomeLimits describes the server limits a client should respect
+type WelcomeLimits struct {
+	MaxMessageSize    int `json:"max_message_size"`    // Largest accepted incoming frame in bytes
+	MaxPayloadBytes   int `json:"max_payload_bytes"`   // Largest accepted payload, as marshaled JSON
+	MaxPayloadDepth   int `json:"max_payload_depth"`   // Deepest accepted payload nesting
+	SendBufferSize    int `json:"send_buffer_size"`    // Events queued per client before drops
+	ControlBufferSize int `json:"control_buffer_size"` // Acks, errors and pongs queued per client
+	MaxBatchMessages  int `json:"max_batch_messages"`
+	MaxBatchBytes     int `json:"max_batch_bytes"`
+	MaxClientIDLength int `json:"max_client_id_length"`
+	PingPeriodMs      int `json:"ping_period_ms"` // How often the server pings
+	PongWaitMs        int `json:"pong_wait_ms"`   // Silence after which the server drops the connection
+	WriteWaitMs       int `json:"write_wait_ms"`  // Time allowed for the client to accept a frame
+}
+
+type InfoResponse struct {
+	Type      string    `json:"type"`
+	Topic     string    `json:"topic,omitempty"`
+	Message   string    `json:"msg"`
+	Severity  string    `json:"severity,omitempty"` // Set on operator broadcasts
+	Reason    string    `json:"reason,omitempty"`   // Why a subscription ended, on "unsubscribed" notices
+	Timestamp time.Time `json:"ts"`
+}
+
+// HTTP API models
+type CreateTopicRequest struct {
+	Name             string                `json:"name"`
+	RetentionSeconds int                   `json:"retention_seconds,omitempty"` // Drop history older than this; 0 keeps it
+	DeadLetterTopic  string                `json:"dlq_topic,omitempty"`         // Republish undelivered events here
+	Schema           json.RawMessage       `json:"schema,omitempty"`            // JSON Schema for published payloads
+	Description      string                `json:"description,omitempty"`
+	Labels           map[string]string     `json:"labels,omitempty"`
+	SigningKeyID     string                `json:"signing_key_id,omitempty"`        // Sign events with this server key
+	Compacted        bool                  `json:"compacted,omitempty"`             // History keeps the newest message per compact_key
+	Visibility       string                `json:"visibility,omitempty"`            // "public" (the default) or "private"
+	MaxPayloadBytes  int                   `json:"max_payload_bytes,omitempty"`     // Overrides the server's payload limit
+	MaxPublishRate   float64               `json:"max_publish_rate,omitempty"`      // Messages per second across all publishers
+	SlowConsumer     *SlowConsumerSettings `json:"slow_consumer,omitempty"`         // Overrides the server's slow-consumer policy
+	Watermarks       *SubscriberWatermarks `json:"subscriber_watermarks,omitempty"` // Overrides the server's subscriber watermarks
+}
+
+// UpdateTopicRequest changes a topic's settings; omitted fields are left alone
+type UpdateTopicRequest struct {
+	RetentionSeconds *int              `json:"retention_seconds,omitempty"` // 0 keeps history until overwritten
+	DeadLetterTopic  *string           `json:"dlq_topic,omitempty"`         // "" turns dead-lettering off
+	State            *string           `json:"state,omitempty"`             // "active" or "archived"
+	Description      *string           `json:"description,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`                // Replaces every label; {} removes them
+	SigningKeyID     *string           `json:"signing_key_id,omitempty"`        // "" turns signing off
+	Visibility       *string           `json:"visibility,omitempty"`            // "public" or "private"
+	MaxPayloadBytes  *int              `json:"max_payload_bytes,omitempty"`     // 0 restores the server default
+	MaxPublishRate   *float64          `json:"max_publish_rate,omitempty"`      // Messages per second; 0 removes the limit
+	SlowConsumer     json.RawMessage   `json:"slow_consumer,omitempty"`         // SlowConsumerSettings; null restores the server default
+	Watermarks       json.RawMessage   `json:"subscriber_watermarks,omitempty"` // SubscriberWatermarks; null restores the server default
+}
+
+// SlowConsumerSettings is a SlowConsumerPolicy in JSON. All zero turns the
+// policy off.
+type SlowConsumerSettings struct {
+	MaxConsecutiveDrops int     `json:"max_consecutive_drops,omitempty"`
+	MaxDropRatio        float64 `json:"max_drop_ratio,omitempty"`
+	GraceSeconds        float64 `json:"grace_seconds,omitempty"`
+}
+
+type CreateTopicResponse struct {
+	Status string `json:"status"`
+	Topic  string `json:"topic"`
+}
+
+type DeleteTopicResponse struct {
+	Status string `json:"status"`
+	Topic  string `json:"topic"`
+}
+
+type TopicInfo struct {
+	Name         string            `json:"name"`
+	Subscribers  int               `json:"subscribers"`
+	MessageCount int64             `json:"message_count"`
+	CreatedAt    time.Time         `json:"created_at"`
+	Description  string            `json:"description,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Paused       bool              `json:"paused,omitempty"` // Delivery is suspended; publishes are held
+	TopicActivity
+}
+
+// TopicActivity reports how busy a topic is. Rates are messages per second
+// averaged over the trailing one and five minutes.
+type TopicActivity struct {
+	Rate1m          float64    `json:"rate_1m"`
+	Rate5m          float64    `json:"rate_5m"`
+	LastPublishedAt *time.Time `json:"last_published_at,omitempty"`
+	LastDeliveryAt  *time.Time `json:"last_delivery_at,omitempty"`
+}
+
+type TopicDetailResponse struct {
+	Name             string                `json:"name"`
+	CreatedAt        time.Time             `json:"created_at"`
+	MessageCount     int64                 `json:"message_count"`
+	Subscribers      int                   `json:"subscribers"`
+	HistorySize      int                   `json:"history_size"`
+	HistoryCount     int                   `json:"history_count"`
+	LatestSeq        int64                 `json:"latest_seq"`
+	RetentionSeconds int                   `json:"retention_seconds,omitempty"`
+	ExpiredCount     int64                 `json:"expired_count"` // History entries dropped for their age
+	DeadLetterTopic  string                `json:"dlq_topic,omitempty"`
+	Schema           json.RawMessage       `json:"schema,omitempty"`
+	State            string                `json:"state"` // "active" or "archived"
+	Description      string                `json:"description,omitempty"`
+	Labels           map[string]string     `json:"labels,omitempty"`
+	Signed           bool                  `json:"signed"`
+	SigningKeyID     string                `json:"signing_key_id,omitempty"`
+	Compacted        bool                  `json:"compacted"`
+	Owner            string                `json:"owner,omitempty"`         // Client that may delete or transfer it over the websocket
+	Visibility       string                `json:"visibility"`              // "public" or "private"
+	PendingJoins     []JoinRequest         `json:"pending_joins,omitempty"` // Clients waiting to join a private topic
+	Paused           bool                  `json:"paused"`
+	Backlog          int                   `json:"backlog,omitempty"`               // Events held for subscribers while paused
+	BacklogEvictions int64                 `json:"backlog_evictions,omitempty"`     // Held events dropped because the backlog was full
+	MaxPayloadBytes  int                   `json:"max_payload_bytes,omitempty"`     // Per-topic override of the payload limit
+	MaxPublishRate   float64               `json:"max_publish_rate,omitempty"`      // Messages per second across all publishers
+	SlowConsumer     *SlowConsumerSettings `json:"slow_consumer,omitempty"`         // Overrides the server's slow-consumer policy
+	Watermarks       *SubscriberWatermarks `json:"subscriber_watermarks,omitempty"` // Overrides the server's subscriber watermarks
+	TopicActivity
+}
+
+type TopicsResponse struct {
+	Topics     []TopicInfo `json:"topics"`
+	TotalCount int         `json:"total_count"` // Topics matching the filter, across all pages
+}
+
+type TopicMessagesResponse struct {
+	Topic      string          `json:"topic"`
+	Messages   []EventResponse `json:"messages"`
+	NextCursor *int64          `json:"next_cursor"`
+}
+
+// DeleteMessageResponse answers DELETE /topics/{name}/messages/{message_id}
+type DeleteMessageResponse struct {
+	Status    string `json:"status"`
+	Topic     string `json:"topic"`
+	MessageID string `json:"message_id"`
+	Seq       int64  `json:"seq"`
+}
+
+// MessageReceiptsResponse answers GET /topics/{name}/messages/{message_id}/receipts
+// with which explicit-ack subscribers have acked a message
+type MessageReceiptsResponse struct {
+	Topic       string    `json:"topic"`
+	MessageID   string    `json:"message_id"`
+	Seq         int64     `json:"seq"`
+	PublishedAt time.Time `json:"published_at"`
+	ExpiresAt   time.Time `json:"expires_at"` // When the receipts are dropped
+	Expected    int       `json:"expected"`   // Explicit-ack subscribers when it was published
+	Acked       []Receipt `json:"acked"`
+	Pending     []string  `json:"pending"` // Client IDs yet to ack
+}
+
+// Receipt is one subscriber's ack of a message
+type Receipt struct {
+	ClientID string    `json:"client_id"`
+	AckedAt  time.Time `json:"acked_at"`
+}
+
+type PurgeMessagesResponse struct {
+	Status string `json:"status"`
+	Topic  string `json:"topic"`
+	Purged int    `json:"purged"`
+}
+
+type HealthResponse struct {
+	Status            string        `json:"status"` // "ok" or "degraded"
+	Reasons           []string      `json:"reasons,omitempty"`
+	UptimeSeconds     int           `json:"uptime_sec"`
+	Topics            int           `json:"topics"`
+	Subscribers       int           `json:"subscribers"`
+	Connections       int           `json:"connections"`
+	IdentifiedClients int           `json:"identified_clients"` // Clients with at least one subscription
+	Goroutines        int           `json:"goroutines"`
+	HeapInuseBytes    uint64        `json:"heap_inuse_bytes"`
+	DroppedLastMinute int64         `json:"dropped_last_minute"`
+	DropRate          float64       `json:"drop_rate"` // Dropped / attempted deliveries over the last minute
+	Drain             DrainResponse `json:"drain"`
+
+	History *StoreStatus `json:"history,omitempty"` // The DATA_DIR history store, if enabled
+	SQLite  *StoreStatus `json:"sqlite,omitempty"`  // The SQLITE_PATH archive, if enabled
+
+	Bridges   map[string]BridgeStatus `json:"bridges,omitempty"`   // Links to external brokers, by name
+	Exporters map[string]StoreStatus  `json:"exporters,omitempty"` // Queues of exporters to external systems, by name
+}
+
+// StoreStatus reports a persistent store's or exporter's write queue in
+// /health, and the history store's in /stats too
+type StoreStatus struct {
+	Queued      int   `json:"queued"`
+	Capacity    int   `json:"capacity"`
+	Overflowing bool  `json:"overflowing"` // The queue filled up and hasn't drained to half since
+	Dropped     int64 `json:"dropped"`     // Appends lost to a full queue, since the server started
+}
+
+// BridgeStatus reports a link to an external broker in /health
+type BridgeStatus struct {
+	Connected  bool   `json:"connected"`
+	Reconnects int64  `json:"reconnects"`           // Connections made after the first
+	LastError  string `json:"last_error,omitempty"` // Why the link last failed
+	Forwarded  int64  `json:"forwarded"`            // Local events sent to the external broker
+	Injected   int64  `json:"injected"`             // External messages published locally
+	Dropped    int64  `json:"dropped"`              // Messages lost in either direction
+}
+
+type ReadinessResponse struct {
+	Status string `json:"status"` // "ready" or "unavailable"
+	Reason string `json:"reason,omitempty"`
+}
+
+type SnapshotRequest struct {
+	Path string `json:"path,omitempty"` // Write to this file under the snapshot directory instead of the response
+	Gzip bool   `json:"gzip,omitempty"`
+}
+
+type SnapshotResponse struct {
+	Status string `json:"status"`
+	Path   string `json:"path"`
+	Topics int    `json:"topics"`
+}
+
+type RestoreResponse struct {
+	Status string `json:"status"`
+	Topics int    `json:"topics"`
+}
+
+// ImportResponse reports POST /topics/{name}/import
+type ImportResponse struct {
+	Topic    string        `json:"topic"`
+	Accepted int           `json:"accepted"`
+	Rejected int           `json:"rejected"`
+	FirstSeq int64         `json:"first_seq,omitempty"` // Sequence numbers the accepted events got
+	LastSeq  int64         `json:"last_seq,omitempty"`
+	Errors   []ImportError `json:"errors,omitempty"` // The first rejected lines
+	Error    string        `json:"error,omitempty"`  // Why the import stopped before the end
+}
+
+// ImportError is a line an import rejected
+type ImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// DrainResponse reports the drain state in /health and from POST
+// /admin/drain and /admin/undrain
+type DrainResponse struct {
+	Draining bool       `json:"draining"`
+	Since    *time.Time `json:"since,omitempty"`
+}
+
+// BroadcastRequest is the body of POST /admin/broadcast
+type BroadcastRequest struct {
+	Message           string `json:"message"`
+	Severity          string `json:"severity,omitempty"`            // info (default), warning or critical
+	CloseAfterSeconds int    `json:"close_after_seconds,omitempty"` // Close every connection after this delay
+}
+
+// BroadcastResponse is returned by POST /admin/broadcast
+type BroadcastResponse struct {
+	Status  string     `json:"status"`
+	Reached int        `json:"reached"`
+	Failed  int        `json:"failed"` // Clients whose queue was full
+	CloseAt *time.Time `json:"close_at,omitempty"`
+}
+
+// DirectSendRequest is the body of POST /clients/{id}/send
+type DirectSendRequest struct {
+	From            string      `json:"from,omitempty"` // Sender's client ID, if any
+	Message         MessageData `json:"message"`
+	BufferIfOffline bool        `json:"buffer_if_offline,omitempty"`
+	CorrelationID   string      `json:"correlation_id,omitempty"`
+}
+
+// DirectSendResponse is returned by POST /clients/{id}/send
+type DirectSendResponse struct {
+	Status   string `json:"status"` // delivered, buffered or offline
+	ClientID string `json:"client_id"`
+}
+
+// KickRequest is the optional body of POST /clients/{id}/kick
+type KickRequest struct {
+	Reason string `json:"reason,omitempty"` // Sent to the client ahead of the close
+}
+
+// ConnectionsResponse answers GET /admin/connections
+type ConnectionsResponse struct {
+	Total    int64          `json:"total"`      // Open websocket connections
+	MaxPerIP int            `json:"max_per_ip"` // 0 when unlimited
+	ByIP     map[string]int `json:"by_ip"`      // Open connections per remote IP
+}
+
+type CreateWebhookRequest struct {
+	URL       string `json:"url"`
+	Secret    string `json:"secret,omitempty"`
+	Events    string `json:"events,omitempty"`     // Only "all" is supported
+	BatchSize int    `json:"batch_size,omitempty"` // Events per POST (default 1)
+}
+
+type WebhookInfo struct {
+	ID            string     `json:"id"`
+	URL           string     `json:"url"`
+	Events        string     `json:"events"`
+	BatchSize     int        `json:"batch_size"`
+	Status        string     `json:"status"` // "active" or "failing"
+	Delivered     int64      `json:"delivered"`
+	Failures      int64      `json:"failures"`
+	Dropped       int64      `json:"dropped"` // Events lost to a full delivery queue
+	LastError     string     `json:"last_error,omitempty"`
+	LastFailureAt *time.Time `json:"last_failure_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+type WebhooksResponse struct {
+	Topic    string        `json:"topic"`
+	Webhooks []WebhookInfo `json:"webhooks"`
+}
+
+type TopicStats struct {
+	Messages    int64 `json:"messages"`
+	Ephemeral   int64 `json:"ephemeral_messages"` // Published with store false, not in messages
+	Scheduled   int   `json:"scheduled"`          // Scheduled messages not yet published
+	Subscribers int   `json:"subscribers"`
+	BytesIn     int64 `json:"bytes_in"`  // Publish frames received from websocket clients
+	BytesOut    int64 `json:"bytes_out"` // Event frames sent to websocket clients
+
+	// Subscriptions unsubscribed for dropping too many events
+	SlowConsumers int64 `json:"slow_consumer_unsubscribes,omitempty"`
+
+	// Occupancy of the topic's history buffer
+	History RingBufferStats `json:"history"`
+	TopicActivity
+}
+
+// ClientUsageStats is one client_id's cumulative websocket traffic
+type ClientUsageStats struct {
+	MessagesReceived int64 `json:"messages_received"`
+	BytesReceived    int64 `json:"bytes_received"` // Raw frame sizes
+	MessagesSent     int64 `json:"messages_sent"`
+	BytesSent        int64 `json:"bytes_sent"` // Encoded sizes, before compression
+}
+
+// AuditResponse is returned by GET /audit
+type AuditResponse struct {
+	Records []AuditRecord `json:"records"` // Oldest first
+	Dropped int64         `json:"dropped"` // Records lost because the writer fell behind
+}
+
+// ClientDetailResponse is returned by GET /clients/{id}
+type ClientDetailResponse struct {
+	ClientID     string            `json:"client_id"`
+	Connected    bool              `json:"connected"`
+	LastActive   *time.Time        `json:"last_active,omitempty"`  // When a connected client was last heard from
+	Unresponsive bool              `json:"unresponsive,omitempty"` // A liveness probe went unanswered past its deadline
+	Topics       []string          `json:"topics"`
+	Usage        ClientUsageStats  `json:"usage"`
+	LastWill     *LastWill         `json:"last_will,omitempty"` // Registered will, and when it fires once the connection dropped
+	Buffers      ClientBufferStats `json:"buffers"`
+}
+
+type WebSocketTrafficStats struct {
+	PayloadBytes       int64 `json:"payload_bytes"` // Encoded message bytes before compression
+	WireBytes          int64 `json:"wire_bytes"`    // Bytes written to sockets, including framing
+	CompressedMessages int64 `json:"compressed_messages"`
+	OriginRejections   int64 `json:"origin_rejections"`   // Upgrades refused by the origin policy
+	LimitRejections    int64 `json:"limit_rejections"`    // Upgrades refused at MAX_CONNECTIONS
+	IPLimitRejections  int64 `json:"ip_limit_rejections"` // Upgrades refused at MAX_CONNECTIONS_PER_IP
+	RepeatedRequests   int64 `json:"repeated_requests"`   // Retried requests answered from the connection's cache
+	ErrorDisconnects   int64 `json:"error_disconnects"`   // Connections closed for sending too many invalid requests
+	ExpiredUploads     int64 `json:"expired_uploads"`     // Chunked publishes abandoned before their end
+	UnackedPublishes   int64 `json:"unacked_publishes"`   // Publishes accepted with ack none
+	BatchedPublishes   int64 `json:"batched_publishes"`   // Publishes accepted with ack batch
+	BatchAcks          int64 `json:"batch_acks"`          // Cumulative acks sent for them
+	Connections        int64 `json:"connections"`         // Open websocket connections
+}
+
+type HTTPTrafficStats struct {
+	BodyTooLarge int64 `json:"body_too_large"` // Requests refused with 413
+	RateLimited  int64 `json:"rate_limited"`   // Requests refused with 429
+}
+
+// BufferStats sums the counters of a kind of ring buffer
+type BufferStats struct {
+	Buffers       int   `json:"buffers"`
+	Capacity      int   `json:"capacity"`
+	Size          int   `json:"size"`
+	Pushes        int64 `json:"pushes"`
+	Evictions     int64 `json:"evictions"`
+	HighWatermark int   `json:"high_watermark"` // Highest of any one buffer
+}
+
+// Add counts one more buffer
+func (s *BufferStats) Add(buffer RingBufferStats) {
+	s.Buffers++
+	s.Capacity += buffer.Capacity
+	s.Size += buffer.Size
+	s.Pushes += buffer.Pushes
+	s.Evictions += buffer.Evictions
+	if buffer.HighWatermark > s.HighWatermark {
+		s.HighWatermark = buffer.HighWatermark
+	}
+}
+
+// BufferUsageStats reports the ring buffers in use, by kind
+type BufferUsageStats struct {
+	TopicHistory        BufferStats `json:"topic_history"`
+	DirectInboxes       BufferStats `json:"direct_inboxes"`
+	PausedSubscriptions BufferStats `json:"paused_subscriptions"`
+	PollSubscriptions   BufferStats `json:"poll_subscriptions"` // Filled in by the HTTP API, which holds them
+}
+
+// ClientBufferStats reports the ring buffers holding events for one client
+type ClientBufferStats struct {
+	DirectInbox *RingBufferStats           `json:"direct_inbox,omitempty"`
+	Paused      map[string]RingBufferStats `json:"paused,omitempty"` // topic -> its paused subscription's buffer
+	Poll        *RingBufferStats           `json:"poll,omitempty"`
+}
+
+type StatsResponse struct {
+	Topics    map[string]TopicStats `json:"topics"`
+	WebSocket WebSocketTrafficStats `json:"websocket"`
+	HTTP      HTTPTrafficStats      `json:"http"`
+	Unacked   map[string]int        `json:"unacked,omitempty"` // consumer -> events awaiting msg_ack
+	Firehose  *FirehoseStats        `json:"firehose,omitempty"`
+	Buffers   *BufferUsageStats     `json:"buffers,omitempty"` // Server-wide, so left out of namespace stats
+	History   *StoreStatus          `json:"history,omitempty"` // The DATA_DIR history store, if enabled; server-wide too
+}
+
+// CounterSnapshot is returned by GET /admin/stats/snapshot, and by
+// POST /admin/stats/reset with the counters as they were before the reset
+type CounterSnapshot struct {
+	Timestamp time.Time                `json:"timestamp"`
+	Since     time.Time                `json:"since"` // Last reset, or when the server started
+	Topics    map[string]TopicCounters `json:"topics"`
+	WebSocket WebSocketTrafficStats    `json:"websocket"` // connections is a gauge and isn't reset
+	HTTP      HTTPTrafficStats         `json:"http"`
+
+	// Delivery attempts and drops over the last minute, as /health counts them
+	DeliveriesLastMinute int64 `json:"deliveries_last_minute"`
+	DroppedLastMinute    int64 `json:"dropped_last_minute"`
+}
+
+// TopicCounters is a topic's resettable counters. Subscribers is a gauge
+// and isn't reset.
+type TopicCounters struct {
+	Messages         int64 `json:"messages"`
+	Ephemeral        int64 `json:"ephemeral_messages"`
+	BytesIn          int64 `json:"bytes_in"`
+	BytesOut         int64 `json:"bytes_out"`
+	SlowConsumers    int64 `json:"slow_consumer_unsubscribes"`
+	BacklogEvictions int64 `json:"backlog_evictions"`
+	Subscribers      int   `json:"subscribers"`
+
+	// Publishes within the windows behind the rates in /stats
+	PublishedLastMinute   int64 `json:"published_last_minute"`
+	PublishedLast5Minutes int64 `json:"published_last_5_minutes"`
+}
+
+type ClientSubscription struct {
+	ClientID     string         `json:"client_id"`
+	Topics       []string       `json:"topics"`
+	TTLRemaining map[string]int `json:"ttl_remaining_seconds,omitempty"` // topic -> seconds left, for subscriptions with a TTL
+}
+
+type SubscriptionsStatusResponse struct {
+	TotalClients   int                  `json:"total_clients"`
+	TotalTopics    int                  `json:"total_topics"`
+	TotalCount     int                  `json:"total_count"` // Clients, or matches when filtered by client_id or topic
+	Subscriptions  []ClientSubscription `json:"subscriptions"`
+	TopicBreakdown map[string][]string  `json:"topic_breakdown"` // topic -> list of client_ids
+}
+
+type PollSubscriptionRequest struct {
+	ClientID string   `json:"client_id,omitempty"` // Server generates one if not provided
+	Topics   []string `json:"topics"`
+}
+
+type PollSubscriptionResponse struct {
+	Status    string   `json:"status"`
+	ClientID  string   `json:"client_id"`
+	PollToken string   `json:"poll_token"` // Send as X-Poll-Token on every later request for the subscription
+	Topics    []string `json:"topics"`
+}
+
+type PollResponse struct {
+	ClientID string          `json:"client_id"`
+	Events   []EventResponse `json:"events"`
+	Cursor   int64           `json:"cursor"` // Pass back as ?cursor= to acknowledge these events
+}
+
+// Generic message wrapper for parsing incoming JSON
+type IncomingMessage struct {
+	Type string `json:"type"`
+}
+
+// ParseMessage parses incoming JSON and returns the appropriate struct
+func ParseMessage(data []byte) (interface{}, error) {
+	return ParseMessageWith(JSONCodec, data)
+}
+
+// ParseMessageWith decodes an incoming message with the given codec and
+// returns the appropriate struct
+func ParseMessageWith(codec Codec, data []byte) (interface{}, error) {
+	return parseMessage(codec, data, func(v interface{}) error { return codec.Unmarshal(data, v) })
+}
+
+// parseMessage reads an incoming message's type with codec and decodes the
+// whole message into the matching struct with decode
+func parseMessage(codec Codec, data []byte, decode func(v interface{}) error) (interface{}, error) {
+	var incoming IncomingMessage
+	if err := codec.Unmarshal(data, &incoming); err != nil {
+		return nil, err
+	}
+
+	switch incoming.Type {
+	case "hello":
+		var msg HelloRequest
+		err := decode(&msg)
+		return msg, err
+	case "subscribe":
+		var msg SubscribeRequest
+		err := decode(&msg)
+		return msg, err
+	case "unsubscribe":
+		var msg UnsubscribeRequest
+		err := decode(&msg)
+		return msg, err
+	case "touch_subscription":
+		var msg TouchSubscriptionRequest
+		err := decode(&msg)
+		return msg, err
+	case "publish", "publish_and_wait":
+		var msg PublishRequest
+		err := decode(&msg)
+		return msg, err
+	case "msg_ack":
+		var msg MsgAckRequest
+		err := decode(&msg)
+		return msg, err
+	case "send_to_client":
+		var msg SendToClientRequest
+		err := decode(&msg)
+		return msg, err
+	case "cancel_scheduled":
+		var msg CancelScheduledRequest
+		err := decode(&msg)
+		return msg, err
+	case "get_history":
+		var msg GetHistoryRequest
+		err := decode(&msg)
+		return msg, err
+	case "create_topic", "delete_topic", "transfer_topic":
+		var msg TopicRequest
+		err := decode(&msg)
+		return msg, err
+	case "approve_join", "deny_join":
+		var msg JoinDecisionRequest
+		err := decode(&msg)
+		return msg, err
+	case "pause":
+		var msg PauseRequest
+		err := decode(&msg)
+		return msg, err
+	case "resume":
+		var msg ResumeRequest
+		err := decode(&msg)
+		return msg, err
+	case "ping":
+		var msg PingRequest
+		err := decode(&msg)
+		return msg, err
+	case "probe_ack":
+		var msg ProbeAckRequest
+		err := decode(&msg)
+		return msg, err
+	case "publish_begin":
+		var msg ChunkBegin
+		err := decode(&msg)
+		return msg, err
+	case "publish_chunk":
+		var msg Chunk
+		err := decode(&msg)
+		return msg, err
+	case "publish_end":
+		var msg ChunkEnd
+		err := decode(&msg)
+		return msg, err
+	default:
+		return nil, ErrorData{
+			Code:    CodeValidationFailed,
+			Reason:  "INVALID_MESSAGE_TYPE",
+			Message: "Unknown message type: " + incoming.Type,
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrMessageNotFound is returned when deleting a message the topic's
+// history doesn't hold
+var ErrMessageNotFound = errors.New("message not found")
+
+// DeleteMessage removes the message with messageID from a topic's history,
+// and from the archive, so later replays leave it out. Current subscribers
+// get a "message_deleted" event naming the message and the actor from ctx,
+// so they can redact copies already delivered. Returns the removed event.
+func (ps *PubSubSystem) DeleteMessage(ctx context.Context, name, messageID string) (EventResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return EventResponse{}, err
+	}
+	if err := checkUserTopic(name); err != nil {
+		return EventResponse{}, err
+	}
+	topic, exists := ps.topics.get(name)
+	if !exists {
+		return EventResponse{}, errorOf(ErrTopicNotFound, "topic %s not found", name)
+	}
+
+	// Hold the topic lock so no subscriber joins between the removal and
+	// the notice
+	topic.mutex.Lock()
+	defer topic.mutex.Unlock()
+
+	var removed EventResponse
+	found := topic.MessageHistory.RemoveWhere(func(event EventResponse) bool {
+		if event.Message.ID != messageID {
+			return false
+		}
+		removed = event
+		return true
+	}) > 0
+	if !found {
+		return EventResponse{}, fmt.Errorf("%w: %s in topic %s", ErrMessageNotFound, messageID, name)
+	}
+
+	if ps.store != nil {
+		ps.store.Rewrite(name, topic.MessageHistory.GetAll())
+	}
+	if ps.sqlite != nil {
+		ps.sqlite.Delete(name, removed)
+	}
+
+	notice := EventResponse{
+		Type:      "message_deleted",
+		Topic:     name,
+		Message:   MessageData{ID: messageID},
+		Seq:       removed.Seq,
+		Actor:     ActorFromContext(ctx),
+		Timestamp: ps.clock.Now(),
+		Delivery:  &DeliveryInfo{Attempt: 1},
+	}
+	ps.flushFanout()
+	for _, subscriber := range topic.Subscribers {
+		if err := subscriber.Client.SendMessage(notice); err != nil {
+			log.Printf("Dropping message_deleted notice for client %s - %v", subscriber.ClientID, err)
+		}
+	}
+	log.Printf("Deleted message %s from topic %s", messageID, name)
+	return removed, nil
+}
@@ -0,0 +1,46 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDeleteMessage(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "chat"); err != nil {
+		t.Fatal(err)
+	}
+	client := &recordingClient{id: "c1"}
+	subscribeClient(t, ps, "chat", client)
+	for i := 0; i < 5; i++ {
+		if err := ps.Publish(ctx, "chat", MessageData{ID: fmt.Sprintf("m%d", i), Payload: i}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client.waitEvents(t, 5)
+
+	removed, err := ps.DeleteMessage(WithActor(ctx, "moderator"), "chat", "m2")
+	if err != nil || removed.Seq != 3 {
+		t.Fatalf("DeleteMessage = %+v, %v", removed, err)
+	}
+	notice := client.waitEvents(t, 6)[5]
+	if notice.Type != "message_deleted" || notice.Message.ID != "m2" || notice.Seq != 3 || notice.Actor != "moderator" {
+		t.Errorf("notice = %+v", notice)
+	}
+
+	// Replays leave it out
+	replay, err := ps.Subscribe(ctx, "c2", "chat", 10, &recordingClient{id: "c2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(messageIDs(replay)); got != "[m0 m1 m3 m4]" {
+		t.Errorf("replay = %s", got)
+	}
+
+	if _, err := ps.DeleteMessage(ctx, "chat", "m2"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("deleting it again: %v", err)
+	}
+}
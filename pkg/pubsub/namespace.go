@@ -0,0 +1,245 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultNamespace holds the topics of clients that don't pick one
+	DefaultNamespace = "default"
+
+	// NamespaceSeparator joins a namespace and a topic name in the internal
+	// name of a topic outside the default namespace, e.g. "billing::orders".
+	// Topic names may not contain it.
+	NamespaceSeparator = "::"
+
+	maxNamespaceLength = 64
+)
+
+var (
+	// ErrInvalidNamespace is returned for a malformed namespace name
+	ErrInvalidNamespace = errors.New("invalid namespace")
+
+	// ErrInvalidTopicName is returned for a topic name that can't be
+	// addressed within a namespace
+	ErrInvalidTopicName = errors.New("invalid topic name")
+
+	// ErrTopicLimit is returned when a namespace already has its maximum
+	// number of topics
+	ErrTopicLimit = errors.New("topic limit reached")
+
+	// ErrRateLimited is returned when a namespace is over its publish rate
+	ErrRateLimited = errors.New("publish rate limit exceeded")
+)
+
+// NamespaceLimits bounds what one namespace may use. Zero means unlimited.
+type NamespaceLimits struct {
+	MaxTopics    int     `json:"max_topics,omitempty"`
+	PublishRate  float64 `json:"publish_rate,omitempty"`  // Messages per second across the namespace
+	PublishBurst int     `json:"publish_burst,omitempty"` // Messages allowed at once; defaults to one second's worth
+}
+
+// namespaceState is a namespace's limits and publish token bucket
+type namespaceState struct {
+	limits NamespaceLimits
+	tokens float64
+	last   time.Time
+}
+
+// namespaces tracks per-namespace limits
+type namespaces struct {
+	states map[string]*namespaceState
+	mutex  sync.Mutex
+
+	// Serializes topic creation in namespaces with a topic limit
+	createMutex sync.Mutex
+}
+
+// ValidNamespace reports whether ns may name a namespace: 1 to 64 lowercase
+// letters, digits, '-' or '_'
+func ValidNamespace(ns string) bool {
+	if ns == "" || len(ns) > maxNamespaceLength {
+		return false
+	}
+	for _, r := range ns {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// QualifyTopic returns the internal name of topic name in namespace ns.
+// Default-namespace topics keep their plain name.
+func QualifyTopic(ns, name string) (string, error) {
+	if !ValidNamespace(ns) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidNamespace, ns)
+	}
+	if strings.Contains(name, NamespaceSeparator) {
+		return "", fmt.Errorf("%w: %q must not contain %q", ErrInvalidTopicName, name, NamespaceSeparator)
+	}
+	if ns == DefaultNamespace {
+		return name, nil
+	}
+	return ns + NamespaceSeparator + name, nil
+}
+
+// SplitTopic returns the namespace and plain name of an internal topic name
+func SplitTopic(topic string) (ns, name string) {
+	if ns, name, found := strings.Cut(topic, NamespaceSeparator); found {
+		return ns, name
+	}
+	return DefaultNamespace, topic
+}
+
+// LocalTopic returns an internal topic name without its namespace, as
+// clients of that namespace know it
+func LocalTopic(topic string) string {
+	_, name := SplitTopic(topic)
+	return name
+}
+
+// LocalizeMessage returns msg with topic names as the clients of their
+// namespace know them. Events, infos, receipts and topic details are
+// rewritten; anything else is returned as is.
+func LocalizeMessage(msg interface{}) interface{} {
+	switch m := msg.(type) {
+	case EventResponse:
+		m.Topic = LocalTopic(m.Topic)
+		return m
+	case InfoResponse:
+		m.Topic = LocalTopic(m.Topic)
+		return m
+	case ReceiptResponse:
+		receipts := make([]ReceiptUpdate, len(m.Receipts))
+		for i, receipt := range m.Receipts {
+			receipt.Topic = LocalTopic(receipt.Topic)
+			receipts[i] = receipt
+		}
+		m.Receipts = receipts
+		return m
+	case TopicDetailResponse:
+		m.Name = LocalTopic(m.Name)
+		m.DeadLetterTopic = LocalTopic(m.DeadLetterTopic)
+		return m
+	}
+	return msg
+}
+
+// SetNamespaceLimits configures one namespace's limits
+func (ps *PubSubSystem) SetNamespaceLimits(ns string, limits NamespaceLimits) error {
+	if !ValidNamespace(ns) {
+		return fmt.Errorf("%w: %q", ErrInvalidNamespace, ns)
+	}
+	if limits.PublishBurst <= 0 {
+		limits.PublishBurst = int(math.Max(1, math.Ceil(limits.PublishRate)))
+	}
+
+	ps.namespaces.mutex.Lock()
+	defer ps.namespaces.mutex.Unlock()
+	ps.namespaces.states[ns] = &namespaceState{limits: limits, tokens: float64(limits.PublishBurst), last: ps.clock.Now()}
+	return nil
+}
+
+// NamespaceLimits returns one namespace's limits
+func (ps *PubSubSystem) NamespaceLimits(ns string) NamespaceLimits {
+	ps.namespaces.mutex.Lock()
+	defer ps.namespaces.mutex.Unlock()
+	if state, exists := ps.namespaces.states[ns]; exists {
+		return state.limits
+	}
+	return NamespaceLimits{}
+}
+
+// allowPublish takes a token from the namespace's publish bucket
+func (ps *PubSubSystem) allowPublish(ns string) error {
+	ps.namespaces.mutex.Lock()
+	defer ps.namespaces.mutex.Unlock()
+
+	state, exists := ps.namespaces.states[ns]
+	if !exists || state.limits.PublishRate <= 0 {
+		return nil
+	}
+	now := ps.clock.Now()
+	state.tokens = math.Min(float64(state.limits.PublishBurst), state.tokens+now.Sub(state.last).Seconds()*state.limits.PublishRate)
+	state.last = now
+	if state.tokens < 1 {
+		return fmt.Errorf("%w: namespace %s allows %g messages per second", ErrRateLimited, ns, state.limits.PublishRate)
+	}
+	state.tokens--
+	return nil
+}
+
+// reserveTopic fails if namespace ns already has its maximum number of
+// topics. Otherwise the returned func must be called once the new topic
+// is in place, or creation has failed.
+func (ps *PubSubSystem) reserveTopic(ns string) (func(), error) {
+	maxTopics := ps.NamespaceLimits(ns).MaxTopics
+	if maxTopics <= 0 {
+		return func() {}, nil
+	}
+
+	ps.namespaces.createMutex.Lock()
+	if count, _ := ps.namespaceCounts(ns); count >= maxTopics {
+		ps.namespaces.createMutex.Unlock()
+		return nil, fmt.Errorf("%w: namespace %s allows %d topics", ErrTopicLimit, ns, maxTopics)
+	}
+	return ps.namespaces.createMutex.Unlock, nil
+}
+
+// namespaceCounts returns the number of topics in namespace ns and their
+// subscribers
+func (ps *PubSubSystem) namespaceCounts(ns string) (topics, subscribers int) {
+	ps.topics.each(func(topic *Topic) {
+		// System topics don't count towards a namespace's topics
+		if topicNS, _ := SplitTopic(topic.Name); topicNS != ns || IsSystemTopic(topic.Name) {
+			return
+		}
+		topic.mutex.RLock()
+		subscribers += len(topic.Subscribers)
+		topic.mutex.RUnlock()
+		topics++
+	})
+	return topics, subscribers
+}
+
+// GetNamespaceTopics returns the topics of one namespace, named without the
+// namespace, in name order
+func (ps *PubSubSystem) GetNamespaceTopics(ns string) []TopicInfo {
+	topics, _, _ := ps.ListTopics(ns, TopicListQuery{})
+	return topics
+}
+
+// GetNamespaceStats returns GetStats restricted to one namespace's topics
+// and consumers, named without the namespace
+func (ps *PubSubSystem) GetNamespaceStats(ns string) StatsResponse {
+	stats := ps.GetStats()
+	topics := make(map[string]TopicStats)
+	for name, topicStats := range stats.Topics {
+		if topicNS, local := SplitTopic(name); topicNS == ns {
+			topics[local] = topicStats
+		}
+	}
+	stats.Topics = topics
+	stats.Unacked = nil
+	stats.Firehose = nil
+	stats.Buffers = nil
+	stats.History = nil
+	if unacked := ps.unackedByConsumer(ns); len(unacked) > 0 {
+		stats.Unacked = unacked
+	}
+	return stats
+}
+
+// GetNamespaceHealth returns GetHealth with topic and subscriber counts
+// for one namespace
+func (ps *PubSubSystem) GetNamespaceHealth(ns string) HealthResponse {
+	health := ps.GetHealth()
+	health.Topics, health.Subscribers = ps.namespaceCounts(ns)
+	return health
+}
@@ -0,0 +1,121 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestQualifyTopic(t *testing.T) {
+	for _, tc := range []struct {
+		ns, name, qualified string
+		err                 error
+	}{
+		{DefaultNamespace, "orders", "orders", nil},
+		{"billing", "orders", "billing::orders", nil},
+		{"team-a_2", "orders", "team-a_2::orders", nil},
+		{"billing", "other::orders", "", ErrInvalidTopicName},
+		{"", "orders", "", ErrInvalidNamespace},
+		{"Billing", "orders", "", ErrInvalidNamespace},
+		{"bill ing", "orders", "", ErrInvalidNamespace},
+		{strings.Repeat("a", maxNamespaceLength+1), "orders", "", ErrInvalidNamespace},
+	} {
+		qualified, err := QualifyTopic(tc.ns, tc.name)
+		if qualified != tc.qualified || !errors.Is(err, tc.err) {
+			t.Errorf("QualifyTopic(%q, %q) = %q, %v", tc.ns, tc.name, qualified, err)
+			continue
+		}
+		if tc.err != nil {
+			continue
+		}
+		if ns, name := SplitTopic(qualified); ns != tc.ns || name != tc.name || LocalTopic(qualified) != tc.name {
+			t.Errorf("SplitTopic(%q) = %q, %q", qualified, ns, name)
+		}
+	}
+}
+
+func TestNamespaceTopicLimit(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.SetNamespaceLimits("alpha", NamespaceLimits{MaxTopics: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.SetNamespaceLimits("Not Valid", NamespaceLimits{}); !errors.Is(err, ErrInvalidNamespace) {
+		t.Errorf("limits for an invalid namespace = %v", err)
+	}
+	create := func(ns, name string) error {
+		t.Helper()
+		topic, err := QualifyTopic(ns, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ps.CreateTopic(ctx, topic)
+	}
+
+	for _, name := range []string{"orders", "invoices"} {
+		if err := create("alpha", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := create("alpha", "refunds"); !errors.Is(err, ErrTopicLimit) {
+		t.Errorf("a third topic in alpha = %v", err)
+	}
+	// Other namespaces have their own counts
+	for _, ns := range []string{"beta", DefaultNamespace} {
+		for _, name := range []string{"orders", "invoices", "refunds"} {
+			if err := create(ns, name); err != nil {
+				t.Errorf("creating %s in %s = %v", name, ns, err)
+			}
+		}
+	}
+
+	// Deleting one frees a slot
+	if err := ps.DeleteTopic(ctx, "alpha::orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err := create("alpha", "refunds"); err != nil {
+		t.Errorf("creating after a delete = %v", err)
+	}
+	if got := len(ps.GetNamespaceTopics("alpha")); got != 2 {
+		t.Errorf("alpha has %d topics", got)
+	}
+}
+
+func TestNamespaceStatsAndHealth(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	for _, topic := range []string{"orders", "alpha::orders", "alpha::invoices", "beta::orders"} {
+		if err := ps.CreateTopic(ctx, topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	subscribeClient(t, ps, "alpha::orders", &recordingClient{id: "reader"})
+	if err := ps.Publish(ctx, "alpha::orders", MessageData{ID: "m", Payload: 1}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := ps.GetNamespaceStats("alpha")
+	if len(stats.Topics) != 2 || stats.Topics["orders"].Messages != 1 || stats.Topics["orders"].Subscribers != 1 {
+		t.Errorf("alpha stats = %+v", stats.Topics)
+	}
+	if stats := ps.GetNamespaceStats("beta"); len(stats.Topics) != 1 || stats.Topics["orders"].Messages != 0 {
+		t.Errorf("beta stats = %+v", stats.Topics)
+	}
+	if health := ps.GetNamespaceHealth("alpha"); health.Topics != 2 || health.Subscribers != 1 {
+		t.Errorf("alpha health = %+v", health)
+	}
+	if health := ps.GetNamespaceHealth("beta"); health.Topics != 1 || health.Subscribers != 0 {
+		t.Errorf("beta health = %+v", health)
+	}
+	// Topics are listed by their local names
+	var names []string
+	for _, topic := range ps.GetNamespaceTopics("alpha") {
+		names = append(names, topic.Name)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "invoices,orders" {
+		t.Errorf("alpha lists %v", names)
+	}
+}
@@ -0,0 +1,75 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// TopicOwner returns the client ID that owns a topic, empty for topics
+// only operators manage, and whether the topic exists
+func (ps *PubSubSystem) TopicOwner(name string) (string, bool) {
+	topic, exists := ps.topics.get(name)
+	if !exists {
+		return "", false
+	}
+	topic.mutex.RLock()
+	defer topic.mutex.RUnlock()
+	return topic.Owner, true
+}
+
+// DeleteOwnedTopic deletes a topic on behalf of requester, who must own
+// it. Operators use DeleteTopic.
+func (ps *PubSubSystem) DeleteOwnedTopic(ctx context.Context, name, requester string) error {
+	if err := ps.checkOwner(name, requester); err != nil {
+		return err
+	}
+	return ps.DeleteTopic(ctx, name)
+}
+
+// TransferTopic makes newOwner the owner of a topic. A non-empty requester
+// must be the current owner; operators pass "".
+func (ps *PubSubSystem) TransferTopic(ctx context.Context, name, requester, newOwner string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if newOwner == "" {
+		return errorOf(ErrInvalidRequest, "new owner is required")
+	}
+	if err := checkUserTopic(name); err != nil {
+		return err
+	}
+	topic, exists := ps.topics.get(name)
+	if !exists {
+		return errorOf(ErrTopicNotFound, "topic %s not found", name)
+	}
+
+	topic.mutex.Lock()
+	if requester != "" && topic.Owner != requester {
+		topic.mutex.Unlock()
+		return fmt.Errorf("%w: %s does not own topic %s", ErrPermissionDenied, requester, name)
+	}
+	previous := topic.Owner
+	topic.Owner = newOwner
+	config := topic.config()
+	createdAt := topic.CreatedAt
+	topic.mutex.Unlock()
+
+	if ps.store != nil {
+		ps.store.TopicCreated(name, createdAt, config)
+	}
+	log.Printf("Topic %s transferred from %q to %s", name, previous, newOwner)
+	return nil
+}
+
+// checkOwner returns ErrPermissionDenied unless requester owns the topic
+func (ps *PubSubSystem) checkOwner(name, requester string) error {
+	owner, exists := ps.TopicOwner(name)
+	if !exists {
+		return errorOf(ErrTopicNotFound, "topic %s not found", name)
+	}
+	if owner == "" || owner != requester {
+		return fmt.Errorf("%w: %s does not own topic %s", ErrPermissionDenied, requester, name)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTopicOwnerSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	ps := openStore(t, dir)
+	ctx := context.Background()
+	if err := ps.CreateTopicWithConfig(ctx, "board", TopicConfig{Owner: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.TransferTopic(ctx, "board", "bob", "bob"); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("transfer by a non-owner: %v", err)
+	}
+	if err := ps.TransferTopic(ctx, "board", "alice", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	ps.Close()
+
+	ps = openStore(t, dir)
+	defer ps.Close()
+	if owner, _ := ps.TopicOwner("board"); owner != "bob" {
+		t.Fatalf("restored owner = %q, want bob", owner)
+	}
+	if err := ps.DeleteOwnedTopic(ctx, "board", "alice"); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("delete by the previous owner: %v", err)
+	}
+	if err := ps.DeleteOwnedTopic(ctx, "board", "bob"); err != nil {
+		t.Errorf("delete by the owner: %v", err)
+	}
+}
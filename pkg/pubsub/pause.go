@@ -0,0 +1,123 @@
+package pubsub
+
+import (
+	"context"
+	"log"
+)
+
+// DefaultPauseBufferSize is how many events a paused subscription holds
+// before evicting the oldest; it matches the websocket send buffer so a
+// resume drain fits an idle connection's queue
+const DefaultPauseBufferSize = 256
+
+// pauseBuffer holds the events published to a paused subscription
+type pauseBuffer struct {
+	events  *RingBuffer[EventResponse]
+	evicted int // Events dropped because the buffer was full
+}
+
+// SetPauseBufferSize configures how many events a paused subscription
+// holds. Non-positive values keep the default. Applies to later pauses.
+func (ps *PubSubSystem) SetPauseBufferSize(size int) {
+	if size <= 0 {
+		size = DefaultPauseBufferSize
+	}
+	ps.clientMutex.Lock()
+	defer ps.clientMutex.Unlock()
+	ps.pauseBufferSize = size
+}
+
+// PauseSubscription stops delivering a topic's events to a client and
+// buffers them instead until ResumeSubscription. The client's other
+// subscriptions are unaffected. Pausing a paused subscription is a no-op.
+func (ps *PubSubSystem) PauseSubscription(ctx context.Context, clientID, topicName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	topic, exists := ps.topics.get(topicName)
+	if !exists {
+		return errorOf(ErrTopicNotFound, "topic %s not found", topicName)
+	}
+
+	ps.clientMutex.RLock()
+	size := ps.pauseBufferSize
+	ps.clientMutex.RUnlock()
+
+	topic.mutex.Lock()
+	defer topic.mutex.Unlock()
+
+	subscriber, exists := topic.Subscribers[clientID]
+	if !exists {
+		return errorOf(ErrNotSubscribed, "client %s is not subscribed to topic %s", clientID, topicName)
+	}
+	if subscriber.ack != nil {
+		// Unacked events already stop delivery once the window is full
+		return errorOf(ErrInvalidRequest, "explicit-ack subscriptions can't be paused; stop acknowledging instead")
+	}
+	if subscriber.paused == nil {
+		subscriber.paused = &pauseBuffer{events: NewRingBuffer[EventResponse](size)}
+	}
+	return nil
+}
+
+// ResumeSubscription delivers the events buffered while a subscription was
+// paused, in order, then resumes live delivery. It returns how many events
+// were buffered and how many were evicted because the buffer was full.
+// Resuming a subscription that isn't paused returns zero counts.
+func (ps *PubSubSystem) ResumeSubscription(ctx context.Context, clientID, topicName string) (int, int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	topic, exists := ps.topics.get(topicName)
+	if !exists {
+		return 0, 0, errorOf(ErrTopicNotFound, "topic %s not found", topicName)
+	}
+
+	// Draining under the topic lock keeps buffered events ahead of any
+	// event published after the resume
+	ps.holdHooks()
+	defer ps.releaseHooks()
+	topic.mutex.Lock()
+	defer topic.mutex.Unlock()
+
+	subscriber, exists := topic.Subscribers[clientID]
+	if !exists {
+		return 0, 0, errorOf(ErrNotSubscribed, "client %s is not subscribed to topic %s", clientID, topicName)
+	}
+	paused := subscriber.paused
+	if paused == nil {
+		return 0, 0, nil
+	}
+	subscriber.paused = nil
+
+	// Live events queued before the pause go out first
+	ps.flushFanout()
+	events := paused.events.PopAll()
+	for _, event := range events {
+		ps.deliveries.Add(1)
+		if err := subscriber.Client.SendMessage(event); err != nil {
+			ps.drops.Add(1)
+			log.Printf("Dropping resumed message for client %s - %v", clientID, err)
+			ps.DeadLetter(event, clientID, DeadLetterBufferEvicted)
+			continue
+		}
+		topic.activity.delivered()
+		ps.emit(hookEvent{kind: hookDeliver, topic: topicName, clientID: clientID})
+	}
+	return len(events), paused.evicted, nil
+}
+
+// hold buffers an event for a paused subscription, evicting the oldest
+// when full. Callers must hold the topic lock.
+func (pb *pauseBuffer) hold(ps *PubSubSystem, clientID string, event EventResponse) {
+	evicted, ok, err := pb.events.PushEvict(event)
+	if err != nil {
+		return
+	}
+	if ok {
+		pb.evicted++
+		ps.DeadLetter(evicted, clientID, DeadLetterBufferEvicted)
+	}
+}
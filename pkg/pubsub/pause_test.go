@@ -0,0 +1,99 @@
+package pubsub
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPauseBuffersAndResumeDrainsInOrder(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	ps.SetPauseBufferSize(5)
+	for _, name := range []string{"news.dlq", "chat"} {
+		if err := ps.CreateTopic(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ps.CreateTopicWithConfig(ctx, "news", TopicConfig{DeadLetterTopic: "news.dlq"}); err != nil {
+		t.Fatal(err)
+	}
+	app := &recordingClient{id: "app"}
+	subscribeClient(t, ps, "news", app)
+	subscribeClient(t, ps, "chat", app)
+
+	publishN(t, ps, "news", 2)
+	app.waitEvents(t, 2)
+	if err := ps.PauseSubscription(ctx, "app", "news"); err != nil {
+		t.Fatal(err)
+	}
+	// Pausing again changes nothing
+	if err := ps.PauseSubscription(ctx, "app", "news"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Past the buffer's capacity, while the other subscription carries on
+	publishN(t, ps, "news", 8)
+	publishN(t, ps, "chat", 3)
+	app.waitEvents(t, 5)
+	time.Sleep(20 * time.Millisecond)
+	for _, event := range app.received()[2:] {
+		if event.Topic != "chat" {
+			t.Fatalf("paused subscription delivered %+v", event)
+		}
+	}
+	if got := len(app.received()); got != 5 {
+		t.Fatalf("%d events while paused, want 2 before and 3 on chat", got)
+	}
+
+	buffered, evicted, err := ps.ResumeSubscription(ctx, "app", "news")
+	if err != nil || buffered != 5 || evicted != 3 {
+		t.Fatalf("resume = %d buffered, %d evicted, %v", buffered, evicted, err)
+	}
+	publishN(t, ps, "news", 1)
+
+	// The newest five of the eight, then live events
+	events := app.waitEvents(t, 11)
+	if !equalSeqs(events[5:], 6, 7, 8, 9, 10, 11) {
+		t.Errorf("after resuming got seqs %v", seqs(events[5:]))
+	}
+
+	// The evicted ones were dead-lettered
+	waitFor(t, "the dead letters", func() bool { return len(history(t, ps, "news.dlq")) == 3 })
+	for i, event := range history(t, ps, "news.dlq") {
+		envelope, _ := event.Message.Payload.(DeadLetterEnvelope)
+		if envelope.Reason != DeadLetterBufferEvicted || envelope.OriginalSeq != int64(i+3) {
+			t.Errorf("dead letter %d = %+v", i, envelope)
+		}
+	}
+
+	// Resuming a live subscription reports nothing held
+	if buffered, evicted, err := ps.ResumeSubscription(ctx, "app", "news"); buffered != 0 || evicted != 0 || err != nil {
+		t.Errorf("resuming again = %d, %d, %v", buffered, evicted, err)
+	}
+}
+
+func TestPauseErrors(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "news"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.PauseSubscription(ctx, "app", "news"); err == nil || !strings.Contains(err.Error(), "not subscribed") {
+		t.Errorf("pausing without subscribing = %v", err)
+	}
+	if _, _, err := ps.ResumeSubscription(ctx, "app", "missing"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("resuming on a missing topic = %v", err)
+	}
+
+	// Explicit-ack subscriptions already stop at their window
+	client := &recordingClient{id: "ledger"}
+	ps.RegisterClient(client)
+	if _, err := ps.SubscribeWithOptions(ctx, client.id, "news", SubscribeOptions{AckMode: AckModeExplicit}, client); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.PauseSubscription(ctx, client.id, "news"); err == nil || !strings.Contains(err.Error(), "can't be paused") {
+		t.Errorf("pausing an explicit-ack subscription = %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	DefaultMaxPayloadBytes = 64 * 1024 // Largest accepted payload, as marshaled JSON
+	DefaultMaxPayloadDepth = 32        // Deepest accepted object/array nesting in a payload
+)
+
+// PayloadLimits bounds what may be published
+type PayloadLimits struct {
+	MaxBytes int // Marshaled JSON size of message.payload plus header bytes
+	MaxDepth int // Object/array nesting of message.payload
+}
+
+// PayloadLimitError is returned when a payload exceeds a PayloadLimits bound
+type PayloadLimitError struct {
+	Code   string // PAYLOAD_TOO_LARGE or PAYLOAD_TOO_DEEP
+	Limit  int
+	Actual int
+}
+
+func (e *PayloadLimitError) Error() string {
+	if e.Code == "PAYLOAD_TOO_DEEP" {
+		return fmt.Sprintf("payload nesting depth %d exceeds the limit of %d", e.Actual, e.Limit)
+	}
+	return fmt.Sprintf("payload is %d bytes, over the limit of %d", e.Actual, e.Limit)
+}
+
+// SetPayloadLimits configures the publish size and depth limits.
+// Non-positive values keep the defaults.
+func (ps *PubSubSystem) SetPayloadLimits(limits PayloadLimits) {
+	if limits.MaxBytes <= 0 {
+		limits.MaxBytes = DefaultMaxPayloadBytes
+	}
+	if limits.MaxDepth <= 0 {
+		limits.MaxDepth = DefaultMaxPayloadDepth
+	}
+	ps.clientMutex.Lock()
+	defer ps.clientMutex.Unlock()
+	ps.payloadLimits = limits
+}
+
+// PayloadLimits returns the publish size and depth limits
+func (ps *PubSubSystem) PayloadLimits() PayloadLimits {
+	ps.clientMutex.RLock()
+	defer ps.clientMutex.RUnlock()
+	return ps.payloadLimits
+}
+
+// Check verifies a payload against the limits
+func (limits PayloadLimits) Check(payload interface{}) error {
+	_, err := limits.check(payload)
+	return err
+}
+
+// check verifies a payload against the limits and returns its marshaled
+// size
+func (limits PayloadLimits) check(payload interface{}) (int, error) {
+	return limits.checkMessage(MessageData{Payload: payload})
+}
+
+// checkMessage verifies a message's payload against the limits, counting
+// its headers toward the size limit, and returns the payload's marshaled
+// size
+func (limits PayloadLimits) checkMessage(message MessageData) (int, error) {
+	if depth := valueDepth(message.Payload, limits.MaxDepth+1); depth > limits.MaxDepth {
+		return 0, &PayloadLimitError{Code: "PAYLOAD_TOO_DEEP", Limit: limits.MaxDepth, Actual: depth}
+	}
+
+	data, err := json.Marshal(message.Payload)
+	if err != nil {
+		return 0, ErrorData{Code: CodeValidationFailed, Message: "payload is not JSON-encodable: " + err.Error()}
+	}
+	if total := len(data) + headersSize(message.Headers); total > limits.MaxBytes {
+		return 0, &PayloadLimitError{Code: "PAYLOAD_TOO_LARGE", Limit: limits.MaxBytes, Actual: total}
+	}
+	return len(data), nil
+}
+
+// headersSize returns the bytes of header keys and values
+func headersSize(headers map[string]string) int {
+	size := 0
+	for key, value := range headers {
+		size += len(key) + len(value)
+	}
+	return size
+}
+
+// valueDepth returns the object/array nesting of a decoded value, giving up
+// once it reaches stop
+func valueDepth(value interface{}, stop int) int {
+	var children []interface{}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, child := range v {
+			children = append(children, child)
+		}
+	case []interface{}:
+		children = v
+	default:
+		return 0
+	}
+
+	deepest := 0
+	if stop <= 1 {
+		return 1
+	}
+	for _, child := range children {
+		if d := valueDepth(child, stop-1); d > deepest {
+			deepest = d
+			if deepest+1 >= stop {
+				break
+			}
+		}
+	}
+	return deepest + 1
+}
+
+// JSONDepth returns the deepest object/array nesting in a JSON document
+// without decoding it, so oversized nesting can be refused before
+// unmarshalling
+func JSONDepth(data []byte) int {
+	depth, deepest := 0, 0
+	inString, escaped := false, false
+	for _, b := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case b == '\\':
+				escaped = true
+			case b == '"':
+				inString = false
+			}
+			continue
+		}
+		switch b {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > deepest {
+				deepest = depth
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return deepest
+}
@@ -0,0 +1,117 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// nested returns a payload of depth levels of single-element arrays
+func nested(depth int) interface{} {
+	var value interface{} = "leaf"
+	for i := 0; i < depth; i++ {
+		value = []interface{}{value}
+	}
+	return value
+}
+
+func TestPayloadLimitsBoundaries(t *testing.T) {
+	limits := PayloadLimits{MaxBytes: 100, MaxDepth: 4}
+	// A string payload marshals to its length plus the quotes
+	for _, tc := range []struct {
+		name    string
+		payload interface{}
+		code    string
+		actual  int
+	}{
+		{"just under the size limit", strings.Repeat("x", 97), "", 0},
+		{"at the size limit", strings.Repeat("x", 98), "", 0},
+		{"just over the size limit", strings.Repeat("x", 99), "PAYLOAD_TOO_LARGE", 101},
+		{"at the depth limit", nested(4), "", 0},
+		{"just over the depth limit", nested(5), "PAYLOAD_TOO_DEEP", 5},
+		{"objects count as levels", map[string]interface{}{"a": map[string]interface{}{"b": nested(3)}}, "PAYLOAD_TOO_DEEP", 5},
+	} {
+		err := limits.Check(tc.payload)
+		if tc.code == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tc.name, err)
+			}
+			continue
+		}
+		var limitErr *PayloadLimitError
+		if !errors.As(err, &limitErr) || limitErr.Code != tc.code || limitErr.Actual != tc.actual {
+			t.Errorf("%s: %v, want %s of %d", tc.name, err, tc.code, tc.actual)
+			continue
+		}
+		if limitErr.Limit == 0 {
+			t.Errorf("%s: reported as %+v", tc.name, limitErr)
+		}
+	}
+}
+
+func TestPathologicalNestingStopsEarly(t *testing.T) {
+	// Measuring stops just past the limit rather than walking the payload
+	limits := PayloadLimits{MaxBytes: 1 << 30, MaxDepth: DefaultMaxPayloadDepth}
+	var limitErr *PayloadLimitError
+	if err := limits.Check(nested(100000)); !errors.As(err, &limitErr) || limitErr.Actual != DefaultMaxPayloadDepth+1 {
+		t.Errorf("100000 levels = %v", err)
+	}
+
+	// The raw check counts brackets outside strings only
+	for doc, depth := range map[string]int{
+		`"x"`:                         0,
+		`{"a":[1,{"b":[]}]}`:          4,
+		`{"a":"[[[[{{{{"}`:            1,
+		`{"a":"\"[[["}`:               1,
+		strings.Repeat("[", 100000):   100000,
+		`[[["]]]\\"],[[[[]]]]]`:       6,
+		`{"a":[{"b":[{"c":[]}]}]}`:    6,
+		`{"type":"publish","x":"{{"}`: 1,
+	} {
+		if got := JSONDepth([]byte(doc)); got != depth {
+			t.Errorf("JSONDepth(%.40s) = %d, want %d", doc, got, depth)
+		}
+	}
+}
+
+func TestPublishEnforcesPayloadLimits(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	ps.SetPayloadLimits(PayloadLimits{MaxBytes: 50, MaxDepth: 3})
+	if err := ps.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	publish := func(topic string, msg MessageData) string {
+		msg.ID = "m"
+		var limitErr *PayloadLimitError
+		if errors.As(ps.Publish(ctx, topic, msg, ""), &limitErr) {
+			return limitErr.Code
+		}
+		return ""
+	}
+
+	if err := ps.Publish(ctx, "orders", MessageData{ID: "m", Payload: strings.Repeat("x", 48)}, ""); err != nil {
+		t.Errorf("payload at the limit = %v", err)
+	}
+	if code := publish("orders", MessageData{Payload: strings.Repeat("x", 49)}); code != "PAYLOAD_TOO_LARGE" {
+		t.Errorf("payload a byte over = %s", code)
+	}
+	// Headers count toward the size
+	if code := publish("orders", MessageData{Payload: strings.Repeat("x", 40), Headers: map[string]string{"trace": "0123456789"}}); code != "PAYLOAD_TOO_LARGE" {
+		t.Errorf("payload with headers over the limit = %s", code)
+	}
+	if code := publish("orders", MessageData{Payload: nested(4)}); code != "PAYLOAD_TOO_DEEP" {
+		t.Errorf("payload a level too deep = %s", code)
+	}
+
+	if got := len(history(t, ps, "orders")); got != 1 {
+		t.Errorf("%d events stored, want only the one within the limits", got)
+	}
+
+	// Non-positive limits keep the defaults
+	ps.SetPayloadLimits(PayloadLimits{})
+	if limits := ps.PayloadLimits(); limits.MaxBytes != DefaultMaxPayloadBytes || limits.MaxDepth != DefaultMaxPayloadDepth {
+		t.Errorf("limits reset to %+v", limits)
+	}
+}
@@ -0,0 +1,561 @@
+package pubsub
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultFsyncInterval = time.Second // How often dirty history files are fsynced
+
+	historyFileSuffix = ".log"
+	metaFileSuffix    = ".meta.json"
+	scheduleSuffix    = ".scheduled.json"
+
+	// Pending writes buffered between publishers and the background writer
+	historyWriteQueueSize = 4096
+)
+
+// topicMeta is the persisted description of a topic
+type topicMeta struct {
+	Name             string                `json:"name"`
+	CreatedAt        time.Time             `json:"created_at"`
+	RetentionSeconds int                   `json:"retention_seconds,omitempty"`
+	DeadLetterTopic  string                `json:"dlq_topic,omitempty"`
+	Schema           json.RawMessage       `json:"schema,omitempty"`
+	Archived         bool                  `json:"archived,omitempty"`
+	Description      string                `json:"description,omitempty"`
+	Labels           map[string]string     `json:"labels,omitempty"`
+	SigningKeyID     string                `json:"signing_key_id,omitempty"`
+	Compacted        bool                  `json:"compacted,omitempty"`
+	Owner            string                `json:"owner,omitempty"`
+	Private          bool                  `json:"private,omitempty"`
+	Members          []string              `json:"members,omitempty"`
+	MaxPayloadBytes  int                   `json:"max_payload_bytes,omitempty"`
+	MaxPublishRate   float64               `json:"max_publish_rate,omitempty"`
+	SlowConsumer     *SlowConsumerSettings `json:"slow_consumer,omitempty"`
+	Watermarks       *SubscriberWatermarks `json:"subscriber_watermarks,omitempty"`
+}
+
+// HistoryOverflow decides what an append does when the history store's
+// write queue is full
+type HistoryOverflow string
+
+const (
+	// HistoryOverflowDrop drops and counts the append, so publishes never
+	// wait on the disk
+	HistoryOverflowDrop HistoryOverflow = "drop"
+
+	// HistoryOverflowBlock waits for room in the queue, slowing publishers
+	// to the writer's pace
+	HistoryOverflowBlock HistoryOverflow = "block"
+)
+
+// historyOp is a unit of work for the background writer
+type historyOp struct {
+	kind      string // "create", "append", "rewrite", "schedule" or "delete"
+	meta      topicMeta
+	events    []EventResponse
+	scheduled []ScheduledMessage
+}
+
+// HistoryStore persists topic metadata and message history to per-topic
+// JSON-lines files. All file I/O happens on a single background goroutine
+// so publishers only pay for a channel send. When the queue is full appends
+// are dropped and counted, or with HistoryOverflowBlock wait for room.
+type HistoryStore struct {
+	dir           string
+	fsyncInterval time.Duration
+	historySize   int
+
+	ops     chan historyOp
+	closing chan struct{} // Closed by Close; later writes are no-ops
+	done    chan struct{}
+
+	dropped     atomic.Int64
+	overflowing atomic.Bool
+	blocking    atomic.Bool // Appends wait for room instead of dropping
+
+	files map[string]*os.File // topic -> open history file (writer goroutine only)
+	lines map[string]int      // topic -> lines in the history file (writer goroutine only)
+	dirty map[string]bool     // topic -> written since last fsync (writer goroutine only)
+
+	// topic -> history keeps the newest event per compact key (writer
+	// goroutine only)
+	compacted map[string]bool
+
+	closeOnce sync.Once
+}
+
+// OpenHistoryStore creates the data directory if needed and starts the writer
+func OpenHistoryStore(dir string, fsyncInterval time.Duration) (*HistoryStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating data directory: %w", err)
+	}
+	if fsyncInterval <= 0 {
+		fsyncInterval = DefaultFsyncInterval
+	}
+
+	hs := &HistoryStore{
+		dir:           dir,
+		fsyncInterval: fsyncInterval,
+		historySize:   TopicHistoryBufferSize,
+		ops:           make(chan historyOp, historyWriteQueueSize),
+		closing:       make(chan struct{}),
+		done:          make(chan struct{}),
+		files:         make(map[string]*os.File),
+		lines:         make(map[string]int),
+		dirty:         make(map[string]bool),
+		compacted:     make(map[string]bool),
+	}
+	return hs, nil
+}
+
+// Start launches the background writer. Call it after Load.
+func (hs *HistoryStore) Start() {
+	go hs.run()
+}
+
+// TopicCreated records a new topic or a change to its configuration
+func (hs *HistoryStore) TopicCreated(name string, createdAt time.Time, config TopicConfig) {
+	hs.send(historyOp{kind: "create", meta: topicMeta{
+		Name:             name,
+		CreatedAt:        createdAt,
+		RetentionSeconds: int(config.Retention / time.Second),
+		DeadLetterTopic:  config.DeadLetterTopic,
+		Schema:           config.Schema,
+		Archived:         config.Archived,
+		Description:      config.Description,
+		Labels:           config.Labels,
+		SigningKeyID:     config.SigningKeyID,
+		Compacted:        config.Compacted,
+		Owner:            config.Owner,
+		Private:          config.Private,
+		Members:          config.Members,
+		MaxPayloadBytes:  config.MaxPayloadBytes,
+		MaxPublishRate:   config.MaxPublishRate,
+		SlowConsumer:     slowConsumerSettings(config.SlowConsumer),
+		Watermarks:       config.SubscriberWatermarks,
+	}})
+}
+
+// TopicDeleted removes a topic's files
+func (hs *HistoryStore) TopicDeleted(name string) {
+	hs.send(historyOp{kind: "delete", meta: topicMeta{Name: name}})
+}
+
+// SetOverflow decides what Append does with the write queue full; empty
+// restores HistoryOverflowDrop
+func (hs *HistoryStore) SetOverflow(policy HistoryOverflow) error {
+	switch policy {
+	case "", HistoryOverflowDrop:
+		hs.blocking.Store(false)
+	case HistoryOverflowBlock:
+		hs.blocking.Store(true)
+	default:
+		return fmt.Errorf("%w: history overflow must be %q or %q, got %q", ErrInvalidRequest, HistoryOverflowDrop, HistoryOverflowBlock, policy)
+	}
+	return nil
+}
+
+// Append records a published event. With the queue full the event is
+// dropped and counted, or with HistoryOverflowBlock waits for room.
+func (hs *HistoryStore) Append(event EventResponse) {
+	select {
+	case <-hs.closing:
+		return
+	default:
+	}
+	op := historyOp{kind: "append", meta: topicMeta{Name: event.Topic}, events: []EventResponse{event}}
+	select {
+	case hs.ops <- op:
+		return
+	default:
+	}
+
+	blocking := hs.blocking.Load()
+	if !hs.overflowing.Swap(true) {
+		if blocking {
+			log.Printf("History store: write queue is full (%d operations); slowing publishers until it drains", cap(hs.ops))
+		} else {
+			log.Printf("History store: write queue is full (%d operations); dropping appends until it drains", cap(hs.ops))
+		}
+	}
+	if !blocking {
+		hs.dropped.Add(1)
+		return
+	}
+	select {
+	case hs.ops <- op:
+	case <-hs.closing:
+	}
+}
+
+// Rewrite replaces a topic's persisted history with the given events
+func (hs *HistoryStore) Rewrite(name string, events []EventResponse) {
+	hs.send(historyOp{kind: "rewrite", meta: topicMeta{Name: name}, events: events})
+}
+
+// Scheduled replaces a topic's persisted scheduled messages
+func (hs *HistoryStore) Scheduled(name string, scheduled []ScheduledMessage) {
+	hs.send(historyOp{kind: "schedule", meta: topicMeta{Name: name}, scheduled: scheduled})
+}
+
+// send queues an operation that must not be dropped, waiting for room in
+// the queue. After Close it does nothing.
+func (hs *HistoryStore) send(op historyOp) {
+	select {
+	case <-hs.closing:
+		return
+	default:
+	}
+	select {
+	case hs.ops <- op:
+	case <-hs.closing:
+	}
+}
+
+// Status reports the write queue for /health and /stats
+func (hs *HistoryStore) Status() StoreStatus {
+	return StoreStatus{
+		Queued:      len(hs.ops),
+		Capacity:    cap(hs.ops),
+		Overflowing: hs.overflowing.Load(),
+		Dropped:     hs.dropped.Load(),
+	}
+}
+
+// Close drains pending writes, fsyncs and closes every file. Writes after
+// Close are ignored.
+func (hs *HistoryStore) Close() {
+	hs.closeOnce.Do(func() {
+		close(hs.closing)
+		<-hs.done
+	})
+}
+
+// run is the background writer loop
+func (hs *HistoryStore) run() {
+	ticker := time.NewTicker(hs.fsyncInterval)
+	defer func() {
+		ticker.Stop()
+		hs.syncAll()
+		for name, f := range hs.files {
+			f.Close()
+			delete(hs.files, name)
+		}
+		close(hs.done)
+	}()
+
+	for {
+		select {
+		case op := <-hs.ops:
+			hs.applyLogged(op)
+		case <-ticker.C:
+			hs.syncAll()
+		case <-hs.closing:
+			// Drain what was queued before Close
+			for {
+				select {
+				case op := <-hs.ops:
+					hs.applyLogged(op)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// applyLogged applies an operation, logging failures, and clears the
+// overflow flag once the queue has drained to half
+func (hs *HistoryStore) applyLogged(op historyOp) {
+	if err := hs.apply(op); err != nil {
+		log.Printf("History store: %s %s failed: %v", op.kind, op.meta.Name, err)
+	}
+	if len(hs.ops) <= cap(hs.ops)/2 {
+		hs.overflowing.Store(false)
+	}
+}
+
+// apply executes a single write operation
+func (hs *HistoryStore) apply(op historyOp) error {
+	switch op.kind {
+	case "create":
+		hs.compacted[op.meta.Name] = op.meta.Compacted
+		return hs.writeMeta(op.meta)
+	case "delete":
+		if f, ok := hs.files[op.meta.Name]; ok {
+			f.Close()
+			delete(hs.files, op.meta.Name)
+		}
+		delete(hs.lines, op.meta.Name)
+		delete(hs.dirty, op.meta.Name)
+		delete(hs.compacted, op.meta.Name)
+		for _, path := range []string{hs.historyPath(op.meta.Name), hs.metaPath(op.meta.Name), hs.schedulePath(op.meta.Name)} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	case "append":
+		f, err := hs.historyFile(op.meta.Name)
+		if err != nil {
+			return err
+		}
+		for _, event := range op.events {
+			line, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if _, err := f.Write(append(line, '\n')); err != nil {
+				return err
+			}
+			hs.lines[op.meta.Name]++
+		}
+		hs.dirty[op.meta.Name] = true
+
+		// Keep the file bounded to a couple of ring buffers' worth of lines
+		if hs.lines[op.meta.Name] > 2*hs.historySize {
+			return hs.compact(op.meta.Name)
+		}
+		return nil
+	case "rewrite":
+		return hs.rewrite(op.meta.Name, op.events)
+	case "schedule":
+		return hs.writeScheduled(op.meta.Name, op.scheduled)
+	default:
+		return fmt.Errorf("unknown history operation %q", op.kind)
+	}
+}
+
+// compact rewrites a topic's history file keeping only the newest entries,
+// and for compacted topics only the newest per key
+func (hs *HistoryStore) compact(name string) error {
+	events, err := hs.readHistory(name)
+	if err != nil {
+		return err
+	}
+	if hs.compacted[name] {
+		events = compactEvents(events)
+	}
+	if len(events) > hs.historySize {
+		events = events[len(events)-hs.historySize:]
+	}
+	return hs.rewrite(name, events)
+}
+
+// rewrite atomically replaces a topic's history file
+func (hs *HistoryStore) rewrite(name string, events []EventResponse) error {
+	if f, ok := hs.files[name]; ok {
+		f.Close()
+		delete(hs.files, name)
+	}
+
+	var buf bytes.Buffer
+	for _, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	if err := writeFileSync(hs.historyPath(name), buf.Bytes()); err != nil {
+		return err
+	}
+	hs.lines[name] = len(events)
+	delete(hs.dirty, name)
+	return nil
+}
+
+// historyFile returns the open append handle for a topic's history
+func (hs *HistoryStore) historyFile(name string) (*os.File, error) {
+	if f, ok := hs.files[name]; ok {
+		return f, nil
+	}
+	f, err := os.OpenFile(hs.historyPath(name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	hs.files[name] = f
+	return f, nil
+}
+
+// writeMeta persists a topic's metadata
+func (hs *HistoryStore) writeMeta(meta topicMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return writeFileSync(hs.metaPath(meta.Name), data)
+}
+
+// writeScheduled replaces a topic's schedule file, removing it once nothing
+// is pending
+func (hs *HistoryStore) writeScheduled(name string, scheduled []ScheduledMessage) error {
+	if len(scheduled) == 0 {
+		if err := os.Remove(hs.schedulePath(name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(scheduled)
+	if err != nil {
+		return err
+	}
+	return writeFileSync(hs.schedulePath(name), data)
+}
+
+// LoadScheduled reads a topic's persisted scheduled messages
+func (hs *HistoryStore) LoadScheduled(name string) ([]ScheduledMessage, error) {
+	data, err := os.ReadFile(hs.schedulePath(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var scheduled []ScheduledMessage
+	if err := json.Unmarshal(data, &scheduled); err != nil {
+		log.Printf("History store: skipping unreadable schedule of %s: %v", name, err)
+		return nil, nil
+	}
+	return scheduled, nil
+}
+
+// syncAll fsyncs every history file written since the last sync
+func (hs *HistoryStore) syncAll() {
+	for name := range hs.dirty {
+		if f, ok := hs.files[name]; ok {
+			if err := f.Sync(); err != nil {
+				log.Printf("History store: fsync %s failed: %v", name, err)
+			}
+		}
+		delete(hs.dirty, name)
+	}
+}
+
+// Load reads every persisted topic and its history. A truncated or corrupt
+// tail (e.g. from a crash mid-write) is dropped and the file is trimmed back
+// to the last complete entry.
+func (hs *HistoryStore) Load() ([]topicMeta, map[string][]EventResponse, error) {
+	entries, err := os.ReadDir(hs.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var metas []topicMeta
+	histories := make(map[string][]EventResponse)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), metaFileSuffix) {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(hs.dir, entry.Name()))
+		if err != nil {
+			return nil, nil, err
+		}
+		var meta topicMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			log.Printf("History store: skipping unreadable metadata %s: %v", entry.Name(), err)
+			continue
+		}
+
+		events, err := hs.readHistory(meta.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		hs.lines[meta.Name] = len(events)
+		hs.compacted[meta.Name] = meta.Compacted
+
+		metas = append(metas, meta)
+		histories[meta.Name] = events
+	}
+
+	return metas, histories, nil
+}
+
+// readHistory parses a topic's history file, trimming any partial tail
+func (hs *HistoryStore) readHistory(name string) ([]EventResponse, error) {
+	path := hs.historyPath(name)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []EventResponse
+	var goodOffset int64
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var event EventResponse
+			if jsonErr := json.Unmarshal(line, &event); jsonErr != nil {
+				log.Printf("History store: corrupt entry in %s at offset %d, truncating", path, goodOffset)
+				return events, os.Truncate(path, goodOffset)
+			}
+			events = append(events, event)
+			goodOffset += int64(len(line))
+			continue
+		}
+		if len(line) > 0 {
+			// Partial final line without a newline: a write was cut short
+			log.Printf("History store: truncated entry in %s at offset %d, truncating", path, goodOffset)
+			return events, os.Truncate(path, goodOffset)
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	return events, nil
+}
+
+func (hs *HistoryStore) historyPath(name string) string {
+	return filepath.Join(hs.dir, url.PathEscape(name)+historyFileSuffix)
+}
+
+func (hs *HistoryStore) metaPath(name string) string {
+	return filepath.Join(hs.dir, url.PathEscape(name)+metaFileSuffix)
+}
+
+func (hs *HistoryStore) schedulePath(name string) string {
+	return filepath.Join(hs.dir, url.PathEscape(name)+scheduleSuffix)
+}
+
+// writeFileSync writes data to a temp file, fsyncs it and renames it over path
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
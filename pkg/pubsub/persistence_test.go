@@ -0,0 +1,255 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openStore opens a history store in dir and attaches it to a new system
+func openStore(t *testing.T, dir string) *PubSubSystem {
+	t.Helper()
+	store, err := OpenHistoryStore(dir, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("OpenHistoryStore: %v", err)
+	}
+	ps := New()
+	if err := ps.EnablePersistence(store); err != nil {
+		t.Fatalf("EnablePersistence: %v", err)
+	}
+	return ps
+}
+
+func TestHistoryStoreRestoresTopicsAndSequences(t *testing.T) {
+	dir := t.TempDir()
+	ps := openStore(t, dir)
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	publishN(t, ps, "orders", 3)
+	ps.Close()
+
+	ps = openStore(t, dir)
+	defer ps.Close()
+	events, _, err := ps.GetTopicMessages("orders", 0, 0, 10, false)
+	if err != nil {
+		t.Fatalf("GetTopicMessages: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("restored %d events, want 3", len(events))
+	}
+	for i, event := range events {
+		if event.Seq != int64(i+1) || event.Message.ID != fmt.Sprintf("m%d", i) {
+			t.Errorf("event %d = seq %d id %s", i, event.Seq, event.Message.ID)
+		}
+	}
+
+	publishN(t, ps, "orders", 1)
+	events, _, _ = ps.GetTopicMessages("orders", 3, 0, 10, false)
+	if len(events) != 1 || events[0].Seq != 4 {
+		t.Fatalf("publish after restore = %+v, want seq 4", events)
+	}
+}
+
+func TestHistoryStoreDeleteTopicRemovesFiles(t *testing.T) {
+	dir := t.TempDir()
+	ps := openStore(t, dir)
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	publishN(t, ps, "orders", 2)
+	if err := ps.DeleteTopic(context.Background(), "orders"); err != nil {
+		t.Fatalf("DeleteTopic: %v", err)
+	}
+	ps.Close()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("file %s left after DeleteTopic", entry.Name())
+	}
+}
+
+// writeTopicFiles lays out a topic the way the writer would have, with raw
+// history contents
+func writeTopicFiles(t *testing.T, dir, name, history string) string {
+	t.Helper()
+	meta, _ := json.Marshal(topicMeta{Name: name, CreatedAt: time.Now()})
+	if err := os.WriteFile(filepath.Join(dir, name+metaFileSuffix), meta, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name+historyFileSuffix)
+	if err := os.WriteFile(path, []byte(history), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func historyLine(seq int64) string {
+	line, _ := json.Marshal(EventResponse{Type: "event", Topic: "orders", Seq: seq, Message: MessageData{ID: fmt.Sprint(seq)}})
+	return string(line) + "\n"
+}
+
+func TestHistoryStoreToleratesTruncatedTail(t *testing.T) {
+	dir := t.TempDir()
+	good := historyLine(1) + historyLine(2)
+	// The writer was killed partway through the third line
+	path := writeTopicFiles(t, dir, "orders", good+historyLine(3)[:20])
+
+	ps := openStore(t, dir)
+	defer ps.Close()
+	events, _, err := ps.GetTopicMessages("orders", 0, 0, 10, false)
+	if err != nil {
+		t.Fatalf("GetTopicMessages: %v", err)
+	}
+	if len(events) != 2 || events[1].Seq != 2 {
+		t.Fatalf("restored %+v, want seqs 1 and 2", events)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != good {
+		t.Errorf("history file not trimmed to the last complete entry: %q", data)
+	}
+
+	// Appends continue on a clean line
+	publishN(t, ps, "orders", 1)
+	ps.Close()
+	ps = openStore(t, dir)
+	defer ps.Close()
+	events, _, _ = ps.GetTopicMessages("orders", 0, 0, 10, false)
+	if len(events) != 3 || events[2].Seq != 3 {
+		t.Fatalf("after append restored %+v, want seqs 1 to 3", events)
+	}
+}
+
+func TestHistoryStoreTruncatesAtCorruptEntry(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTopicFiles(t, dir, "orders", historyLine(1)+"{not json\n"+historyLine(3))
+
+	store, err := OpenHistoryStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, histories, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := len(histories["orders"]); got != 1 {
+		t.Fatalf("loaded %d events, want 1", got)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != historyLine(1) {
+		t.Errorf("history file = %q, want only the first entry", data)
+	}
+}
+
+func TestHistoryStoreAppendDropsWhenQueueFull(t *testing.T) {
+	store, err := OpenHistoryStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without the writer running nothing drains the queue
+	for i := 0; i < historyWriteQueueSize+5; i++ {
+		store.Append(EventResponse{Topic: "orders", Seq: int64(i + 1)})
+	}
+	status := store.Status()
+	if status.Dropped != 5 || !status.Overflowing || status.Queued != historyWriteQueueSize {
+		t.Fatalf("status = %+v, want 5 dropped and overflowing", status)
+	}
+
+	store.Start()
+	store.Close()
+	if store.Status().Overflowing {
+		t.Error("still overflowing after the queue drained")
+	}
+}
+
+func TestHistoryStoreWritesAfterCloseAreIgnored(t *testing.T) {
+	ps := openStore(t, t.TempDir())
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	ps.Close()
+
+	// Must neither panic nor block
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		publishN(t, ps, "orders", 1)
+		ps.store.TopicCreated("late", time.Now(), TopicConfig{})
+		ps.store.Rewrite("orders", nil)
+		ps.store.TopicDeleted("orders")
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("write after Close blocked")
+	}
+}
+
+func TestHealthReportsHistoryStore(t *testing.T) {
+	ps := openStore(t, t.TempDir())
+	defer ps.Close()
+	health := ps.GetHealth()
+	if health.History == nil || health.History.Capacity != historyWriteQueueSize {
+		t.Fatalf("health.History = %+v", health.History)
+	}
+	if stats := ps.GetStats(); stats.History == nil || stats.History.Capacity != historyWriteQueueSize {
+		t.Fatalf("stats.History = %+v", stats.History)
+	}
+}
+
+func TestHistoryOverflowPolicies(t *testing.T) {
+	// Until Start nothing drains the queue
+	hs, err := OpenHistoryStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hs.SetOverflow("spill"); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("unknown overflow policy = %v", err)
+	}
+	event := EventResponse{Type: "event", Topic: "orders", Message: MessageData{ID: "m"}}
+	for i := 0; i < historyWriteQueueSize; i++ {
+		hs.Append(event)
+	}
+
+	// By default an append to the full queue is dropped and counted
+	hs.Append(event)
+	if status := hs.Status(); status.Dropped != 1 || !status.Overflowing {
+		t.Errorf("status after dropping = %+v", status)
+	}
+
+	// Blocking waits for the writer instead
+	if err := hs.SetOverflow(HistoryOverflowBlock); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		hs.Append(event)
+	}()
+	select {
+	case <-done:
+		t.Fatal("append to a full queue returned without waiting")
+	case <-time.After(20 * time.Millisecond):
+	}
+	hs.Start()
+	defer hs.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("append still blocked once the writer started")
+	}
+	if status := hs.Status(); status.Dropped != 1 {
+		t.Errorf("blocking append was dropped: %+v", status)
+	}
+}
@@ -0,0 +1,176 @@
+package pubsub
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Metrics turns hook callbacks into Prometheus metrics and serves them in
+// the text exposition format. Register it with AddHooks(m.Hooks()).
+type Metrics struct {
+	mutex   sync.Mutex
+	topics  map[string]*topicMetrics
+	clients int64
+}
+
+// topicMetrics holds the series labelled with one topic
+type topicMetrics struct {
+	published      int64
+	publishedBytes int64
+	delivered      int64
+	dropped        map[string]int64 // reason -> count
+	subscriptions  int64
+}
+
+// NewMetrics creates an empty set of metrics
+func NewMetrics() *Metrics {
+	return &Metrics{topics: make(map[string]*topicMetrics)}
+}
+
+// Hooks returns the callbacks that feed the metrics
+func (m *Metrics) Hooks() Hooks {
+	return Hooks{
+		OnPublish: func(topic string, size int) {
+			m.update(topic, func(tm *topicMetrics) {
+				tm.published++
+				tm.publishedBytes += int64(size)
+			})
+		},
+		OnDeliver: func(topic, clientID string) {
+			m.update(topic, func(tm *topicMetrics) { tm.delivered++ })
+		},
+		OnDrop: func(topic, clientID, reason string) {
+			m.update(topic, func(tm *topicMetrics) { tm.dropped[reason]++ })
+		},
+		OnSubscribe: func(topic, clientID string) {
+			m.update(topic, func(tm *topicMetrics) { tm.subscriptions++ })
+		},
+		OnUnsubscribe: func(topic, clientID string) {
+			m.update(topic, func(tm *topicMetrics) { tm.subscriptions-- })
+		},
+		OnTopicCreated: func(topic string) {
+			m.update(topic, func(*topicMetrics) {})
+		},
+		OnTopicDeleted: func(topic string) {
+			// Drop the topic's series so deleted topics don't accumulate
+			m.mutex.Lock()
+			defer m.mutex.Unlock()
+			delete(m.topics, topic)
+		},
+		OnClientConnected: func(clientID string) {
+			m.mutex.Lock()
+			defer m.mutex.Unlock()
+			m.clients++
+		},
+		OnClientDisconnected: func(clientID string) {
+			m.mutex.Lock()
+			defer m.mutex.Unlock()
+			m.clients--
+		},
+	}
+}
+
+// update applies fn to a topic's metrics, creating them on first use
+func (m *Metrics) update(topic string, fn func(*topicMetrics)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	tm, exists := m.topics[topic]
+	if !exists {
+		tm = &topicMetrics{dropped: make(map[string]int64)}
+		m.topics[topic] = tm
+	}
+	fn(tm)
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mutex.Lock()
+	names := make([]string, 0, len(m.topics))
+	topics := make(map[string]topicMetrics, len(m.topics))
+	for name, tm := range m.topics {
+		names = append(names, name)
+		copied := *tm
+		copied.dropped = make(map[string]int64, len(tm.dropped))
+		for reason, count := range tm.dropped {
+			copied.dropped[reason] = count
+		}
+		topics[name] = copied
+	}
+	clients := m.clients
+	m.mutex.Unlock()
+	sort.Strings(names)
+
+	out := &countingWriter{w: bufio.NewWriter(w)}
+	gauge := func(name, help string, value int64) {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, value)
+	}
+	perTopic := func(name, kind, help string, value func(topicMetrics) int64) {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, topic := range names {
+			fmt.Fprintf(out, "%s{topic=\"%s\"} %d\n", name, escapeLabel(topic), value(topics[topic]))
+		}
+	}
+
+	gauge("pubsub_topics", "Topics that currently exist.", int64(len(names)))
+	gauge("pubsub_connected_clients", "Open client connections.", clients)
+	perTopic("pubsub_subscriptions", "gauge", "Active subscriptions per topic.",
+		func(tm topicMetrics) int64 { return tm.subscriptions })
+	perTopic("pubsub_messages_published_total", "counter", "Messages published per topic.",
+		func(tm topicMetrics) int64 { return tm.published })
+	perTopic("pubsub_published_bytes_total", "counter", "Marshaled payload bytes published per topic.",
+		func(tm topicMetrics) int64 { return tm.publishedBytes })
+	perTopic("pubsub_messages_delivered_total", "counter", "Messages handed to subscribers per topic.",
+		func(tm topicMetrics) int64 { return tm.delivered })
+
+	fmt.Fprint(out, "# HELP pubsub_messages_dropped_total Messages dropped per topic and reason.\n# TYPE pubsub_messages_dropped_total counter\n")
+	for _, topic := range names {
+		dropped := topics[topic].dropped
+		reasons := make([]string, 0, len(dropped))
+		for reason := range dropped {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			fmt.Fprintf(out, "pubsub_messages_dropped_total{topic=\"%s\",reason=\"%s\"} %d\n", escapeLabel(topic), escapeLabel(reason), dropped[reason])
+		}
+	}
+
+	if out.err == nil {
+		out.err = out.w.Flush()
+	}
+	return out.n, out.err
+}
+
+// escapeLabel escapes a Prometheus label value
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// countingWriter tracks bytes written and the first error
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
@@ -0,0 +1,1723 @@
+// Package pubsub implements the in-memory topic broker: topics with bounded
+// message history, fan-out to subscribed clients, optional persistence,
+// webhooks and snapshots. Transports (websocket, HTTP, gRPC) live in
+// pkg/transport and drive a *PubSubSystem through its exported methods.
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/AnshulDekate/pubsub/pkg/clock"
+)
+
+const (
+	DefaultBufferSize      = 100  // Default ring buffer size per subscriber
+	TopicHistoryBufferSize = 1000 // Default ring buffer size per topic for message history
+	ClientSendBufferSize   = 256  // Outgoing messages buffered per connection before new ones are dropped
+
+	DefaultHealthMaxDropRate    = 0.05  // Fraction of deliveries dropped in the last minute before health degrades
+	DefaultHealthMaxConnections = 10000 // Open connections before health degrades
+
+	healthWindow = time.Minute // Window used for the delivery drop rate
+)
+
+// HealthThresholds configures when GetHealth reports a degraded status
+type HealthThresholds struct {
+	MaxDropRate    float64
+	MaxConnections int
+}
+
+// ClientInterface defines the interface for WebSocket clients
+//
+// SendMessage receives published events as a *PreparedEvent and
+// per-client responses as the plain response types.
+type ClientInterface interface {
+	GetClientID() string
+	IsConnected() bool
+	SendMessage(interface{}) error
+	GetLastActive() time.Time
+}
+
+// Subscriber represents a client subscribed to a topic
+type Subscriber struct {
+	ClientID string
+	Topic    string
+	Client   ClientInterface // Reference to the WebSocket client
+	ack      *ackState       // Delivery tracking for explicit-ack subscriptions, nil otherwise
+	filter   *EventFilter    // Payload filter, nil to receive everything
+	paused   *pauseBuffer    // Events held while paused, nil while delivering
+	health   subscriptionHealth
+}
+
+// Topic represents a chat room topic
+type Topic struct {
+	Name                     string
+	Subscribers              map[string]*Subscriber // clientID -> Subscriber
+	MessageCount             int64
+	EphemeralCount           int64 // Messages published without being stored
+	LastSeq                  int64 // Sequence number of the most recently published message
+	LastPublishedAt          time.Time
+	CreatedAt                time.Time
+	Retention                time.Duration                        // Maximum age of history entries, 0 for no limit
+	MessageHistory           *EventBuffer                         // Topic-level message history for last_n
+	Compacted                bool                                 // History keeps only the newest event per compact key
+	Webhooks                 map[string]*Webhook                  // webhookID -> Webhook
+	DeadLetterTopic          string                               // Topic that receives undelivered events, empty for none
+	Schema                   *TopicSchema                         // Published payloads must match, nil for no check
+	Archived                 bool                                 // Publishes are rejected; history stays readable
+	Description              string                               // What the topic is for, for operators
+	Labels                   map[string]string                    // Operator metadata; replaced, never changed in place
+	SigningKeyID             string                               // Server key events are signed with, empty for none
+	Owner                    string                               // Client that may delete or transfer it over the websocket
+	Private                  bool                                 // Subscribing needs the owner's approval
+	Members                  map[string]bool                      // Clients admitted to a private topic
+	BacklogEvictions         int64                                // Events dropped from full backlogs while paused
+	MaxPayloadBytes          int                                  // Overrides the server's payload limit, 0 for the default
+	SlowConsumerUnsubscribes int64                                // Subscriptions dropped for losing too many events
+	slowConsumer             atomic.Pointer[SlowConsumerPolicy]   // Overrides the server's slow-consumer policy, nil for the default
+	watermarks               atomic.Pointer[SubscriberWatermarks] // Overrides the server's subscriber watermarks, nil for the default
+	subscriberBand           watermarkBand                        // Where the subscriber count stands against the watermarks
+	rate                     *topicRate                           // Publish token bucket, nil for no per-topic rate
+	held                     *topicBacklog                        // Events held from subscribers while paused, nil while delivering
+	backlogSize              int                                  // Events held while paused before evicting the oldest
+	activity                 *topicActivity                       // Publish rates and last delivery time
+	mutex                    sync.RWMutex
+}
+
+// PubSubSystem manages the entire pub-sub system
+type PubSubSystem struct {
+	// Topic -> client_ids mapping for fan-out, sharded by topic name
+	topics *topicMap
+
+	// Worker lanes for live delivery, nil to deliver on the publisher
+	fanout atomic.Pointer[fanoutPool]
+
+	// Span creation for publishes, nil while tracing is off
+	tracing atomic.Pointer[tracing]
+
+	// Unsubscribes subscriptions that keep dropping events, nil when off
+	slowConsumer atomic.Pointer[SlowConsumerPolicy]
+
+	// Subscriber counts announced when crossed, nil when off
+	watermarks atomic.Pointer[SubscriberWatermarks]
+
+	// client_id -> set of topics mapping (client can subscribe to multiple topics)
+	clientTopics map[string]map[string]bool
+
+	// client_id -> connected client (every open connection, subscribed or not)
+	clients map[string]ClientInterface
+
+	// client mapping mutex
+	clientMutex sync.RWMutex
+
+	// Time source for timestamps, expiry and every timer; never changes
+	clock clock.Clock
+
+	// System stats
+	startTime time.Time
+
+	// When the counters were last reset, or startTime; guarded by
+	// countersMutex, which also keeps resets from interleaving
+	countersSince time.Time
+	countersMutex sync.Mutex
+
+	// Delivery attempts and drops over the trailing health window
+	deliveries *slidingCounter
+	drops      *slidingCounter
+
+	// Thresholds that flip /health to degraded
+	healthThresholds HealthThresholds
+
+	// Websocket connection cap enforced on upgrade and reported by
+	// readiness (0 = unlimited), and the slots currently taken
+	maxConnections int
+	connections    atomic.Int64
+
+	// Size and nesting limits on published payloads
+	payloadLimits PayloadLimits
+
+	// Largest per-topic payload limit set so far
+	largestTopicPayload atomic.Int64
+
+	// Events a paused subscription holds before evicting the oldest
+	pauseBufferSize int
+
+	// Events a paused topic holds before evicting the oldest
+	topicBacklogSize int
+
+	// Set once graceful shutdown begins
+	shuttingDown atomic.Bool
+
+	// Set while draining, with when draining began in Unix nanoseconds
+	draining  atomic.Bool
+	drainedAt atomic.Int64
+
+	// Broker-owned $sys topics (nil when disabled)
+	sys atomic.Pointer[systemTopics]
+
+	// Clients receiving a copy of every published event
+	firehose firehose
+
+	// client_id -> traffic counters, kept across reconnects
+	usage clientUsages
+
+	// Optional connection audit log (nil when disabled)
+	auditLog *AuditLog
+
+	// Optional file-backed history (nil when persistence is disabled)
+	store *HistoryStore
+
+	// Optional queryable archive of every published event (nil when
+	// disabled)
+	sqlite *SQLiteHistory
+
+	// Delivers events to registered webhooks
+	webhooks *WebhookDispatcher
+
+	// Outgoing websocket traffic counters, updated by the websocket transport
+	wsTraffic WebSocketTraffic
+
+	// REST API rejection counters, updated by the HTTP transport
+	httpTraffic HTTPTraffic
+
+	// consumer+topic -> explicit-ack delivery state, kept across reconnects
+	acks       map[string]*ackState
+	ackTimeout time.Duration
+	ackWindow  int
+	ackMutex   sync.Mutex
+
+	// Undelivered events waiting to be republished to dead-letter topics
+	deadLetters chan deadLetter
+
+	// Messages held for publishing at a later time
+	scheduler *scheduler
+
+	// Subscriptions that end on their own after a while
+	ttls *subscriptionTTLs
+
+	// Who has acked messages published with receipts on
+	receipts *receiptTracker
+
+	// Direct messages held for clients that couldn't take them
+	direct directInboxes
+
+	// Messages published for clients whose connections drop
+	wills lastWills
+
+	// Secrets a connection must present to take a client_id back
+	resume resumeTokens
+
+	// Open websocket connections per remote IP
+	ipConns ipConnections
+
+	// Requests to join private topics, awaiting their owners
+	joins joinRequests
+
+	// Registered instrumentation callbacks, events recorded under broker
+	// locks, and the number of sections currently recording
+	hooks       atomic.Pointer[[]Hooks]
+	hookPending []hookEvent
+	hookHolds   atomic.Int64
+	hookMutex   sync.Mutex
+
+	// Per-namespace limits
+	namespaces namespaces
+
+	// Registered publish and delivery interceptors
+	publishInterceptors  atomic.Pointer[[]PublishInterceptor]
+	deliveryInterceptors atomic.Pointer[[]DeliveryInterceptor]
+	interceptorMutex     sync.Mutex
+
+	// Key ID -> key that topics can sign events with
+	signingKeys atomic.Pointer[map[string][]byte]
+
+	// Links to external brokers and exporters reported in /health
+	bridges   statusFuncs[BridgeStatus]
+	exporters statusFuncs[StoreStatus]
+}
+
+// WebSocketTraffic accumulates websocket transport counters reported in /stats
+type WebSocketTraffic struct {
+	PayloadBytes       atomic.Int64 // Encoded message bytes before compression
+	WireBytes          atomic.Int64 // Bytes written to sockets, including framing
+	CompressedMessages atomic.Int64
+	OriginRejections   atomic.Int64 // Upgrades refused by the origin policy
+	LimitRejections    atomic.Int64 // Upgrades refused at the connection cap
+	IPLimitRejections  atomic.Int64 // Upgrades refused at the per-IP connection cap
+	RepeatedRequests   atomic.Int64 // Retried requests answered from the connection's cache
+	ErrorDisconnects   atomic.Int64 // Connections closed for sending too many invalid requests
+	ExpiredUploads     atomic.Int64 // Chunked publishes abandoned before their end
+	UnackedPublishes   atomic.Int64 // Publishes accepted without an ack
+	BatchedPublishes   atomic.Int64 // Publishes accepted into a cumulative ack
+	BatchAcks          atomic.Int64 // Cumulative publish acks sent
+}
+
+// WebSocketTraffic returns the counters the websocket transport updates
+func (ps *PubSubSystem) WebSocketTraffic() *WebSocketTraffic {
+	return &ps.wsTraffic
+}
+
+// HTTPTraffic accumulates REST API counters reported in /stats
+type HTTPTraffic struct {
+	BodyTooLarge atomic.Int64 // Requests refused for their body size
+	RateLimited  atomic.Int64 // Requests refused by the per-IP rate limit
+}
+
+// HTTPTraffic returns the counters the REST API updates
+func (ps *PubSubSystem) HTTPTraffic() *HTTPTraffic {
+	return &ps.httpTraffic
+}
+
+// New creates a new pub-sub system on the wall clock
+func New() *PubSubSystem {
+	return NewWithClock(clock.Real)
+}
+
+// NewWithClock creates a new pub-sub system that reads the time and sets
+// its timers with c, such as a clocktest.Fake in tests
+func NewWithClock(c clock.Clock) *PubSubSystem {
+	ps := &PubSubSystem{
+		clock:        c,
+		topics:       newTopicMap(),
+		clientTopics: make(map[string]map[string]bool),
+		clients:      make(map[string]ClientInterface),
+		direct: directInboxes{
+			byClient: make(map[string]*RingBuffer[EventResponse]),
+			replies:  make(map[string]chan EventResponse),
+		},
+		wills:      lastWills{byClient: make(map[string]*lastWill)},
+		resume:     resumeTokens{byClient: make(map[string]*resumeToken), retention: DefaultSessionRetention},
+		ipConns:    ipConnections{byIP: make(map[string]int)},
+		joins:      joinRequests{timeout: DefaultJoinTimeout, byTopic: make(map[string]map[string]*pendingJoin)},
+		startTime:  c.Now(),
+		deliveries: newSlidingCounterWithClock(healthWindow, time.Second, c.Now),
+		drops:      newSlidingCounterWithClock(healthWindow, time.Second, c.Now),
+		healthThresholds: HealthThresholds{
+			MaxDropRate:    DefaultHealthMaxDropRate,
+			MaxConnections: DefaultHealthMaxConnections,
+		},
+		payloadLimits: PayloadLimits{
+			MaxBytes: DefaultMaxPayloadBytes,
+			MaxDepth: DefaultMaxPayloadDepth,
+		},
+		pauseBufferSize:  DefaultPauseBufferSize,
+		topicBacklogSize: DefaultTopicBacklogSize,
+		webhooks:         NewWebhookDispatcher(DefaultWebhookWorkers, DefaultWebhookMaxAttempts, DefaultWebhookBackoff),
+		acks:             make(map[string]*ackState),
+		ackTimeout:       DefaultAckTimeout,
+		ackWindow:        DefaultAckWindow,
+
+		deadLetters: make(chan deadLetter, deadLetterQueueSize),
+		namespaces:  namespaces{states: make(map[string]*namespaceState)},
+		usage:       clientUsages{byClient: make(map[string]*ClientUsage)},
+	}
+	ps.countersSince = ps.startTime
+	ps.scheduler = newScheduler(ps)
+	ps.ttls = newSubscriptionTTLs(ps)
+	ps.receipts = newReceiptTracker(ps)
+	go ps.ackLoop()
+	go ps.deadLetterLoop()
+	go ps.retentionLoop()
+	go ps.scheduler.run()
+	go ps.ttls.run()
+	return ps
+}
+
+// EnablePersistence restores topics and history from the store and records
+// every later change to it. Call before the server starts accepting clients.
+func (ps *PubSubSystem) EnablePersistence(store *HistoryStore) error {
+	metas, histories, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	for _, meta := range metas {
+		topic := ps.newTopic(meta.Name, time.Duration(meta.RetentionSeconds)*time.Second)
+		topic.CreatedAt = meta.CreatedAt
+		topic.DeadLetterTopic = meta.DeadLetterTopic
+		topic.Archived = meta.Archived
+		topic.Description = meta.Description
+		topic.Labels = meta.Labels
+		topic.SigningKeyID = meta.SigningKeyID
+		topic.Owner = meta.Owner
+		topic.Private = meta.Private
+		topic.Members = memberSet(meta.Members)
+		topic.setCompacted(meta.Compacted)
+		topic.setLimits(meta.MaxPayloadBytes, meta.MaxPublishRate)
+		topic.slowConsumer.Store(slowConsumerOverride(meta.SlowConsumer))
+		topic.watermarks.Store(meta.Watermarks)
+		ps.notePayloadLimit(meta.MaxPayloadBytes)
+		if topic.Schema, err = CompileTopicSchema(meta.Schema); err != nil {
+			log.Printf("Ignoring stored schema for topic %s: %v", meta.Name, err)
+		}
+		for _, event := range histories[meta.Name] {
+			topic.MessageHistory.Push(event)
+			topic.LastSeq = event.Seq
+			topic.LastPublishedAt = event.Timestamp
+		}
+		topic.MessageCount = topic.LastSeq
+		ps.topics.set(topic)
+		ps.emit(hookEvent{kind: hookTopicCreated, topic: meta.Name})
+	}
+	ps.store = store
+
+	// Messages that fell due while the server was down are published now
+	for _, meta := range metas {
+		scheduled, err := store.LoadScheduled(meta.Name)
+		if err != nil {
+			return err
+		}
+		ps.scheduler.restore(scheduled)
+	}
+
+	store.Start()
+	log.Printf("Restored %d topics from %s", len(metas), store.dir)
+	return nil
+}
+
+// EnableSQLiteHistory archives every later publish in sh, serves history
+// queries from it and falls back to it for since_seq replay beyond the
+// in-memory ring. Call before the server starts accepting clients.
+func (ps *PubSubSystem) EnableSQLiteHistory(sh *SQLiteHistory) {
+	ps.sqlite = sh
+}
+
+// SQLiteHistory returns the SQLite archive, or nil when it is disabled
+func (ps *PubSubSystem) SQLiteHistory() *SQLiteHistory {
+	return ps.sqlite
+}
+
+// Close flushes any persisted state
+func (ps *PubSubSystem) Close() {
+	ps.scheduler.stop()
+	ps.ttls.stop()
+	ps.wills.stop()
+	ps.joins.stop()
+	if sys := ps.sys.Load(); sys != nil {
+		close(sys.stop)
+	}
+	if pool := ps.fanout.Load(); pool != nil {
+		pool.close()
+	}
+	if ps.store != nil {
+		ps.store.Close()
+	}
+	if ps.sqlite != nil {
+		ps.sqlite.Close()
+	}
+	if ps.auditLog != nil {
+		ps.auditLog.Close()
+	}
+}
+
+// SetWebhookRetryPolicy configures how failed webhook deliveries are retried
+func (ps *PubSubSystem) SetWebhookRetryPolicy(maxAttempts int, backoff time.Duration) {
+	ps.webhooks.mutex.Lock()
+	defer ps.webhooks.mutex.Unlock()
+	ps.webhooks.maxAttempts = maxAttempts
+	ps.webhooks.backoff = backoff
+}
+
+// SetHealthThresholds configures when the system reports a degraded status
+func (ps *PubSubSystem) SetHealthThresholds(thresholds HealthThresholds) {
+	ps.clientMutex.Lock()
+	defer ps.clientMutex.Unlock()
+	ps.healthThresholds = thresholds
+}
+
+// RegisterClient records an open connection
+func (ps *PubSubSystem) RegisterClient(client ClientInterface) {
+	clientID := client.GetClientID()
+	ps.clientMutex.Lock()
+	ps.clients[clientID] = client
+	ps.clientMutex.Unlock()
+	ps.wills.cancelPending(clientID)
+	ps.ClientConnected(clientID)
+}
+
+// ClientConnected reports a new connection to hooks and $sys. RegisterClient
+// does it; transports registering with RegisterClientIfAbsent call it once
+// registered.
+func (ps *PubSubSystem) ClientConnected(clientID string) {
+	ps.emit(hookEvent{kind: hookClientConnected, clientID: clientID})
+	ps.announceClient("client_connected", clientID)
+}
+
+// ClientDisconnected reports a closed connection to hooks and $sys, like
+// UnregisterClient does
+func (ps *PubSubSystem) ClientDisconnected(clientID string) {
+	ps.emit(hookEvent{kind: hookClientDisconnected, clientID: clientID})
+	ps.announceClient("client_disconnected", clientID)
+}
+
+// RebindClient moves a registered connection from oldID to newID. If
+// another connection already holds newID nothing changes and that
+// connection is returned.
+func (ps *PubSubSystem) RebindClient(client ClientInterface, oldID, newID string) (ClientInterface, bool) {
+	ps.clientMutex.Lock()
+	defer ps.clientMutex.Unlock()
+
+	if holder, exists := ps.clients[newID]; exists && holder != client {
+		return holder, false
+	}
+	delete(ps.clients, oldID)
+	ps.clients[newID] = client
+	ps.moveClientUsage(oldID, newID)
+	ps.wills.rebind(oldID, newID)
+	return nil, true
+}
+
+// UnregisterClient forgets a closed connection
+func (ps *PubSubSystem) UnregisterClient(clientID string) {
+	ps.clientMutex.Lock()
+	delete(ps.clients, clientID)
+	ps.clientMutex.Unlock()
+	ps.ClientDisconnected(clientID)
+}
+
+// RegisterClientIfAbsent records an open connection like RegisterClient,
+// unless another connection holds its client ID; then nothing changes and
+// that connection is returned.
+func (ps *PubSubSystem) RegisterClientIfAbsent(client ClientInterface) (ClientInterface, bool) {
+	ps.clientMutex.Lock()
+	defer ps.clientMutex.Unlock()
+	if holder, exists := ps.clients[client.GetClientID()]; exists {
+		return holder, false
+	}
+	ps.clients[client.GetClientID()] = client
+	ps.wills.cancelPending(client.GetClientID())
+	return nil, true
+}
+
+// IsCurrentClient reports whether client is the connection registered
+// under its client ID
+func (ps *PubSubSystem) IsCurrentClient(client ClientInterface) bool {
+	ps.clientMutex.RLock()
+	defer ps.clientMutex.RUnlock()
+	holder, exists := ps.clients[client.GetClientID()]
+	return exists && holder == client
+}
+
+// UnregisterClientIfCurrent is UnregisterClient for a connection that may
+// have lost its client ID to a newer one: the record is only removed while
+// it still belongs to client. Reports whether it was removed.
+func (ps *PubSubSystem) UnregisterClientIfCurrent(client ClientInterface) bool {
+	ps.clientMutex.Lock()
+	defer ps.clientMutex.Unlock()
+	if holder, exists := ps.clients[client.GetClientID()]; !exists || holder != client {
+		return false
+	}
+	delete(ps.clients, client.GetClientID())
+	return true
+}
+
+// TopicConfig holds the settings chosen when a topic is created
+type TopicConfig struct {
+	// Drop history entries older than this; 0 keeps them until overwritten
+	Retention time.Duration
+
+	// Republish undelivered events to this topic; empty turns it off
+	DeadLetterTopic string
+
+	// JSON Schema that published payloads must match; empty for none
+	Schema json.RawMessage
+
+	// Reject publishes while keeping history readable
+	Archived bool
+
+	// Operator-facing description and labels
+	Description string
+	Labels      map[string]string
+
+	// ID of the server key events are signed with; empty for none
+	SigningKeyID string
+
+	// Keep only the newest event per compact key in history
+	Compacted bool
+
+	// Client ID that may delete or transfer the topic without the admin
+	// credential; empty for topics only operators manage
+	Owner string
+
+	// Subscribing needs the owner's approval, except for Members
+	Private bool
+	Members []string
+
+	// Override the server's publish limits; 0 keeps the default
+	MaxPayloadBytes int
+	MaxPublishRate  float64 // Messages per second across all publishers
+
+	// Override the server's slow-consumer policy; nil keeps the default
+	// and the zero policy turns it off for the topic
+	SlowConsumer *SlowConsumerPolicy
+
+	// Override the server's subscriber watermarks; nil keeps the default
+	// and the zero value turns them off for the topic
+	SubscriberWatermarks *SubscriberWatermarks
+}
+
+// config returns the topic's current configuration. Callers must hold the
+// mutex or own the topic exclusively.
+func (topic *Topic) config() TopicConfig {
+	return TopicConfig{
+		Retention:            topic.Retention,
+		DeadLetterTopic:      topic.DeadLetterTopic,
+		Schema:               topic.Schema.Raw(),
+		Archived:             topic.Archived,
+		Description:          topic.Description,
+		Labels:               topic.Labels,
+		SigningKeyID:         topic.SigningKeyID,
+		Owner:                topic.Owner,
+		Private:              topic.Private,
+		Members:              topic.memberList(),
+		Compacted:            topic.Compacted,
+		MaxPayloadBytes:      topic.MaxPayloadBytes,
+		MaxPublishRate:       topic.maxPublishRate(),
+		SlowConsumer:         topic.slowConsumer.Load(),
+		SubscriberWatermarks: topic.watermarks.Load(),
+	}
+}
+
+// CreateTopic creates a new topic with the default configuration
+func (ps *PubSubSystem) CreateTopic(ctx context.Context, name string) error {
+	return ps.CreateTopicWithConfig(ctx, name, TopicConfig{})
+}
+
+// CreateTopicWithConfig creates a new topic with the given configuration.
+// The creation is announced on $sys/topics, attributed to the ctx actor.
+func (ps *PubSubSystem) CreateTopicWithConfig(ctx context.Context, name string, config TopicConfig) error {
+	if err := checkUserTopic(name); err != nil {
+		return err
+	}
+	if err := ps.createTopic(ctx, name, config); err != nil {
+		return err
+	}
+	ps.announceTopic(ctx, "topic_created", name)
+	return nil
+}
+
+// createTopic does the work of CreateTopicWithConfig under the broker locks
+func (ps *PubSubSystem) createTopic(ctx context.Context, name string, config TopicConfig) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := checkTopicMetadata(config.Description, config.Labels); err != nil {
+		return err
+	}
+	if err := checkTopicLimits(config.MaxPayloadBytes, config.MaxPublishRate); err != nil {
+		return err
+	}
+	if config.SlowConsumer != nil {
+		if err := config.SlowConsumer.check(); err != nil {
+			return err
+		}
+	}
+	if config.SubscriberWatermarks != nil {
+		if err := config.SubscriberWatermarks.check(); err != nil {
+			return err
+		}
+	}
+	if err := ps.checkDeadLetterTopic(name, config.DeadLetterTopic); err != nil {
+		return err
+	}
+	if err := ps.checkSigningKey(config.SigningKeyID); err != nil {
+		return err
+	}
+	schema, err := CompileTopicSchema(config.Schema)
+	if err != nil {
+		return err
+	}
+
+	ns, _ := SplitTopic(name)
+	reserved, err := ps.reserveTopic(ns)
+	if err != nil {
+		return err
+	}
+	defer reserved()
+
+	// A topic archived under this name before continues its sequence, so
+	// archived events are never overwritten
+	var archivedSeq int64
+	if ps.sqlite != nil {
+		if archivedSeq, err = ps.sqlite.LastSeq(name); err != nil {
+			return fmt.Errorf("reading archived history: %w", err)
+		}
+	}
+
+	ps.holdHooks()
+	defer ps.releaseHooks()
+
+	// Hold the shard lock until the store has the create queued, so it can't
+	// be reordered with a delete of the same topic
+	shard := ps.topics.shard(name)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	if _, exists := shard.topics[name]; exists {
+		return errorOf(ErrTopicExists, "topic %s already exists", name)
+	}
+
+	topic := ps.newTopic(name, config.Retention)
+	topic.DeadLetterTopic = config.DeadLetterTopic
+	topic.Schema = schema
+	topic.Archived = config.Archived
+	topic.Description = config.Description
+	topic.Labels = copyLabels(config.Labels)
+	topic.SigningKeyID = config.SigningKeyID
+	topic.Owner = config.Owner
+	topic.Private = config.Private
+	topic.Members = memberSet(config.Members)
+	topic.setCompacted(config.Compacted)
+	topic.setLimits(config.MaxPayloadBytes, config.MaxPublishRate)
+	if config.SlowConsumer != nil {
+		policy := *config.SlowConsumer
+		topic.slowConsumer.Store(&policy)
+	}
+	if config.SubscriberWatermarks != nil {
+		watermarks := *config.SubscriberWatermarks
+		topic.watermarks.Store(&watermarks)
+	}
+	topic.LastSeq = archivedSeq
+	shard.topics[name] = topic
+	ps.notePayloadLimit(config.MaxPayloadBytes)
+
+	if ps.store != nil {
+		ps.store.TopicCreated(name, topic.CreatedAt, config)
+	}
+	ps.emit(hookEvent{kind: hookTopicCreated, topic: name})
+
+	return nil
+}
+
+// newTopic creates an empty topic with the default history size, on the
+// system's clock
+func (ps *PubSubSystem) newTopic(name string, retention time.Duration) *Topic {
+	return &Topic{
+		Name:           name,
+		Subscribers:    make(map[string]*Subscriber),
+		CreatedAt:      ps.clock.Now(),
+		Retention:      retention,
+		MessageHistory: NewEventBufferWithMaxAge(TopicHistoryBufferSize, retention, ps.clock.Now),
+		Webhooks:       make(map[string]*Webhook),
+		activity:       newTopicActivity(ps.clock.Now),
+	}
+}
+
+// Clock returns the time source the system runs on, for transports to
+// share
+func (ps *PubSubSystem) Clock() clock.Clock {
+	return ps.clock
+}
+
+// setCompacted turns key compaction of the topic's history on or off.
+// Callers must hold the mutex or own the topic exclusively.
+func (topic *Topic) setCompacted(compacted bool) {
+	topic.Compacted = compacted
+	topic.MessageHistory.SetCompacted(compacted)
+}
+
+// DeleteTopic deletes a topic and disconnects all subscribers. The deletion
+// is announced on $sys/topics, attributed to the ctx actor.
+func (ps *PubSubSystem) DeleteTopic(ctx context.Context, name string) error {
+	if err := checkUserTopic(name); err != nil {
+		return err
+	}
+	if err := ps.deleteTopic(ctx, name); err != nil {
+		return err
+	}
+	ps.announceTopic(ctx, "topic_deleted", name)
+	return nil
+}
+
+// deleteTopic does the work of DeleteTopic under the broker locks
+func (ps *PubSubSystem) deleteTopic(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ps.holdHooks()
+	defer ps.releaseHooks()
+
+	shard := ps.topics.shard(name)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	topic, exists := shard.topics[name]
+	if !exists {
+		return errorOf(ErrTopicNotFound, "topic %s not found", name)
+	}
+
+	// Notify all subscribers about topic deletion, after any event still
+	// on its way to them
+	topic.mutex.Lock()
+	ps.flushFanout()
+	log.Printf("Topic %s has %d subscribers to notify", name, len(topic.Subscribers))
+	for _, subscriber := range topic.Subscribers {
+		// Send topic deletion notice
+		notice := InfoResponse{
+			Type:      "info",
+			Topic:     name,
+			Message:   "topic_deleted",
+			Timestamp: ps.clock.Now(),
+		}
+
+		log.Printf("Sending topic deletion notice to client %s", subscriber.ClientID)
+		if err := subscriber.Client.SendMessage(notice); err != nil {
+			// Client is disconnected or channel is full, drop notice
+			log.Printf("Dropping topic deletion notice for client %s - %v", subscriber.ClientID, err)
+		} else {
+			log.Printf("Successfully sent topic deletion notice to client %s", subscriber.ClientID)
+		}
+
+		ps.ttls.cancel(ttlKey{subscriber.ClientID, name})
+
+		// Remove from client mapping
+		ps.clientMutex.Lock()
+		if clientTopics, exists := ps.clientTopics[subscriber.ClientID]; exists {
+			delete(clientTopics, name)
+			if len(clientTopics) == 0 {
+				delete(ps.clientTopics, subscriber.ClientID)
+			}
+		}
+		ps.clientMutex.Unlock()
+		ps.emit(hookEvent{kind: hookUnsubscribe, topic: name, clientID: subscriber.ClientID})
+	}
+	// A publish that found the topic before it went delivers to no one
+	clear(topic.Subscribers)
+	topic.mutex.Unlock()
+
+	// Delete the topic
+	delete(shard.topics, name)
+	ps.removeTopicAckStates(topic)
+	ps.scheduler.dropTopic(name)
+	ps.wills.dropTopic(name)
+	ps.joins.dropTopic(name)
+
+	if ps.store != nil {
+		ps.store.TopicDeleted(name)
+	}
+	ps.emit(hookEvent{kind: hookTopicDeleted, topic: name})
+	return nil
+}
+
+// Subscribe adds a client to a topic
+func (ps *PubSubSystem) Subscribe(ctx context.Context, clientID, topicName string, lastN int, client ClientInterface) ([]EventResponse, error) {
+	return ps.SubscribeWithOptions(ctx, clientID, topicName, SubscribeOptions{LastN: lastN}, client)
+}
+
+// SubscribeWithOptions adds a client to a topic. In explicit ack mode,
+// events carry a delivery tag and are redelivered until acknowledged with
+// AckMessages; re-subscribing under the same consumer resumes delivery.
+func (ps *PubSubSystem) SubscribeWithOptions(ctx context.Context, clientID, topicName string, opts SubscribeOptions, client ClientInterface) ([]EventResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if ps.draining.Load() {
+		return nil, ErrServerDraining
+	}
+	switch opts.AckMode {
+	case "", AckModeAuto, AckModeExplicit:
+	default:
+		return nil, errorOf(ErrInvalidRequest, "unknown ack mode %q", opts.AckMode)
+	}
+	if opts.Consumer == "" {
+		opts.Consumer = clientID
+	}
+	if opts.SinceSeq < 0 {
+		return nil, errorOf(ErrInvalidRequest, "since_seq must not be negative")
+	}
+	if opts.SinceSeq > 0 && opts.AckMode == AckModeExplicit {
+		return nil, errorOf(ErrInvalidRequest, "since_seq is not supported with explicit ack mode")
+	}
+	if opts.TTL < 0 {
+		return nil, errorOf(ErrInvalidRequest, "ttl must not be negative")
+	}
+	filter, err := NewEventFilter(opts.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if filter, err = filter.WithHeaders(opts.Headers); err != nil {
+		return nil, err
+	}
+
+	// Check if topic exists
+	topic, exists := ps.topics.get(topicName)
+
+	if !exists {
+		return nil, errorOf(ErrTopicNotFound, "topic %s not found", topicName)
+	}
+
+	topic.mutex.RLock()
+	admitted := topic.admits(clientID)
+	topic.mutex.RUnlock()
+	if !admitted {
+		return nil, fmt.Errorf("%w: %s", ErrJoinRequired, topicName)
+	}
+
+	// Archived events a since_seq replay needs are read before taking the
+	// topic lock, so a slow query never stalls publishing
+	var archived []EventResponse
+	if opts.SinceSeq > 0 && ps.sqlite != nil {
+		archived = ps.readArchivedReplay(topic, opts.SinceSeq)
+	}
+
+	// Add client to the topic mapping (allow multiple topic subscriptions)
+	ps.clientMutex.Lock()
+	if ps.clientTopics[clientID] == nil {
+		ps.clientTopics[clientID] = make(map[string]bool)
+	}
+	ps.clientTopics[clientID][topicName] = true
+	ps.clientMutex.Unlock()
+
+	// Add subscriber to topic. A watermark crossing is announced once the
+	// topic lock is released.
+	var crossing *SubscriberWatermarkEvent
+	ps.holdHooks()
+	defer ps.releaseHooks()
+	defer func() { ps.announceWatermark(crossing) }()
+	topic.mutex.Lock()
+	defer topic.mutex.Unlock()
+
+	subscriber := &Subscriber{
+		ClientID: clientID,
+		Topic:    topicName,
+		Client:   client,
+		filter:   filter,
+	}
+	if previous, exists := topic.Subscribers[clientID]; exists && previous.ack != nil && opts.AckMode != AckModeExplicit {
+		ps.removeAckState(previous.ack.consumer, topicName)
+	}
+	if opts.AckMode == AckModeExplicit {
+		subscriber.ack = ps.attachAckState(opts.Consumer, topic, client, filter)
+	} else if previous, exists := topic.Subscribers[clientID]; exists {
+		// Re-subscribing keeps a paused subscription paused
+		subscriber.paused = previous.paused
+	}
+
+	if _, exists := topic.Subscribers[clientID]; !exists {
+		ps.emit(hookEvent{kind: hookSubscribe, topic: topicName, clientID: clientID})
+	} else {
+		// Live events queued for the previous subscription go out before
+		// any replay
+		ps.flushFanout()
+	}
+	previous := len(topic.Subscribers)
+	topic.Subscribers[clientID] = subscriber
+	crossing = ps.crossWatermarks(topic, previous)
+	if opts.TTL > 0 {
+		ps.ttls.set(ttlKey{clientID, topicName}, opts.TTL, client)
+	} else {
+		ps.ttls.cancel(ttlKey{clientID, topicName})
+	}
+
+	// Catch-up replay is sent while the topic lock is held, so no live
+	// event can overtake it
+	if opts.SinceSeq > 0 {
+		ps.replay(topic, subscriber, opts.SinceSeq, archived)
+		return nil, nil
+	}
+
+	// Return last N messages if requested from topic's message history
+	var lastMessages []EventResponse
+	if opts.LastN > 0 && filter == nil && ps.deliveryInterceptors.Load() == nil {
+		lastMessages = topic.MessageHistory.GetLastN(opts.LastN)
+	} else if opts.LastN > 0 {
+		scratch := acquireEvents()
+		history := topic.MessageHistory.AppendAll(*scratch)
+		matched := lastMatching(history, opts.LastN, func(event EventResponse) bool {
+			return filter.MatchMessage(event.Message) && ps.deliverable(event, clientID)
+		})
+		// The matches live in the scratch slice, which is reused
+		lastMessages = append([]EventResponse(nil), matched...)
+		releaseEvents(scratch, history)
+	}
+
+	// Both are copies, so marking them leaves the history bare
+	replay := &DeliveryInfo{Attempt: 1, Replay: true}
+	for i := range lastMessages {
+		lastMessages[i].Delivery = replay
+	}
+	return lastMessages, nil
+}
+
+// readArchivedReplay reads the archived events after sinceSeq that the
+// ring no longer holds. It takes the topic lock only to look at the ring,
+// not for the query.
+func (ps *PubSubSystem) readArchivedReplay(topic *Topic, sinceSeq int64) []EventResponse {
+	topic.mutex.RLock()
+	first := topic.MessageHistory.AppendAfterSeq(nil, sinceSeq, 1)
+	oldest := topic.LastSeq + 1
+	topic.mutex.RUnlock()
+	if len(first) > 0 {
+		oldest = first[0].Seq
+	}
+	if oldest <= sinceSeq+1 {
+		return nil
+	}
+
+	archived, err := ps.sqlite.before(topic.Name, sinceSeq, oldest, sqliteReplayLimit)
+	if err != nil {
+		log.Printf("Reading archived history for %s failed: %v", topic.Name, err)
+	}
+	return archived
+}
+
+// replay sends a subscriber the archived events read for it, then the
+// ring's events after them. Callers must hold the topic lock. Should more
+// than a ring's worth of events have been published while the archive was
+// read, the ones evicted in between are not replayed.
+func (ps *PubSubSystem) replay(topic *Topic, subscriber *Subscriber, sinceSeq int64, archived []EventResponse) {
+	if len(archived) > 0 {
+		sinceSeq = archived[len(archived)-1].Seq
+	}
+	scratch := acquireEvents()
+	events := topic.MessageHistory.AppendAfterSeq(*scratch, sinceSeq, topic.MessageHistory.Cap())
+	defer func() { releaseEvents(scratch, events) }()
+
+	ps.sendHistory(topic, subscriber, archived)
+	ps.sendHistory(topic, subscriber, events)
+}
+
+// sendHistory sends a subscriber the history events it may see. Callers
+// must hold the topic lock, so no live event can overtake them.
+func (ps *PubSubSystem) sendHistory(topic *Topic, subscriber *Subscriber, events []EventResponse) {
+	replay := &DeliveryInfo{Attempt: 1, Replay: true}
+	for _, event := range events {
+		if !subscriber.filter.MatchMessage(event.Message) || !ps.deliverable(event, subscriber.ClientID) {
+			continue
+		}
+		event.Delivery = replay
+		if subscriber.paused != nil {
+			subscriber.paused.hold(ps, subscriber.ClientID, event)
+			continue
+		}
+		ps.deliveries.Add(1)
+		if err := subscriber.Client.SendMessage(event); err != nil {
+			ps.drops.Add(1)
+			log.Printf("Dropping replayed message for client %s - %v", subscriber.ClientID, err)
+			ps.DeadLetter(event, subscriber.ClientID, DeadLetterBufferEvicted)
+			ps.noteDelivery(topic, subscriber, true)
+			continue
+		}
+		ps.noteDelivery(topic, subscriber, false)
+		topic.activity.delivered()
+		ps.emit(hookEvent{kind: hookDeliver, topic: topic.Name, clientID: subscriber.ClientID})
+	}
+}
+
+// Unsubscribe removes a client from a specific topic
+func (ps *PubSubSystem) Unsubscribe(ctx context.Context, clientID, topicName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ps.clientMutex.Lock()
+	clientTopics, exists := ps.clientTopics[clientID]
+	if !exists || !clientTopics[topicName] {
+		ps.clientMutex.Unlock()
+		return errorOf(ErrNotSubscribed, "client %s is not subscribed to topic %s", clientID, topicName)
+	}
+	delete(clientTopics, topicName)
+	if len(clientTopics) == 0 {
+		delete(ps.clientTopics, clientID)
+	}
+	ps.clientMutex.Unlock()
+
+	// Remove from topic
+	topic, exists := ps.topics.get(topicName)
+
+	if !exists {
+		return errorOf(ErrTopicNotFound, "topic %s not found", topicName)
+	}
+
+	topic.mutex.Lock()
+	subscriber, subscribed := topic.Subscribers[clientID]
+	if subscribed && subscriber.ack != nil {
+		ps.removeAckState(subscriber.ack.consumer, topicName)
+	}
+	delete(topic.Subscribers, clientID)
+	var crossing *SubscriberWatermarkEvent
+	if subscribed {
+		crossing = ps.crossWatermarks(topic, len(topic.Subscribers)+1)
+	}
+	topic.mutex.Unlock()
+	ps.ttls.cancel(ttlKey{clientID, topicName})
+	// Deliveries already queued for the subscription reach the client
+	// before the caller acknowledges the unsubscribe
+	ps.flushFanout()
+
+	if subscribed {
+		ps.emit(hookEvent{kind: hookUnsubscribe, topic: topicName, clientID: clientID})
+		ps.announceWatermark(crossing)
+	}
+	return nil
+}
+
+// Publish sends a message to all subscribers of a topic except the sender.
+// When the webhook queue is full it waits for room; if ctx ends first the
+// message has still been delivered to subscribers but ctx.Err() is returned
+// and the webhook batch is dropped.
+func (ps *PubSubSystem) Publish(ctx context.Context, topicName string, message MessageData, senderClientID string) error {
+	return ps.PublishWithOptions(ctx, topicName, message, senderClientID, PublishOptions{})
+}
+
+// PublishWithOptions is Publish with delivery options
+func (ps *PubSubSystem) PublishWithOptions(ctx context.Context, topicName string, message MessageData, senderClientID string, opts PublishOptions) error {
+	_, err := ps.PublishWithResult(ctx, topicName, message, senderClientID, opts)
+	return err
+}
+
+// PublishWithResult is PublishWithOptions that also describes the accepted
+// publish, e.g. whether it is held by a paused topic
+func (ps *PubSubSystem) PublishWithResult(ctx context.Context, topicName string, message MessageData, senderClientID string, opts PublishOptions) (result PublishResult, err error) {
+	// Timed only for hooks, which may be registered meanwhile
+	var received time.Time
+	if ps.hooks.Load() != nil {
+		received = ps.clock.Now()
+	}
+	if t := ps.tracing.Load(); t != nil {
+		var span trace.Span
+		ctx, span = t.startPublish(ctx, topicName, &message, senderClientID)
+		defer func() { endSpan(span, err) }()
+	}
+
+	topic, size, err := ps.checkPublish(ctx, topicName, message, opts)
+	if err != nil {
+		return PublishResult{}, err
+	}
+	ns, _ := SplitTopic(topicName)
+	if err := ps.allowPublish(ns); err != nil {
+		return PublishResult{}, err
+	}
+
+	if ps.publishInterceptors.Load() != nil {
+		if err := ps.intercept(ctx, topicName, &message, senderClientID); err != nil {
+			return PublishResult{}, err
+		}
+		size = ps.payloadSize(message.Payload)
+	}
+
+	result, err = ps.publishToTopic(ctx, topic, message, size, senderClientID, opts)
+	if err == nil && !received.IsZero() {
+		ps.emit(hookEvent{kind: hookPublishLatency, topic: topic.Name, latency: ps.clock.Now().Sub(received)})
+	}
+	return result, err
+}
+
+// checkPublish validates a publish against its topic, returning the topic
+// and the payload's marshaled size. The namespace's rate limit is left to
+// the caller.
+func (ps *PubSubSystem) checkPublish(ctx context.Context, topicName string, message MessageData, opts PublishOptions) (*Topic, int, error) {
+	if len(opts.OrderingKey) > MaxOrderingKeyLength {
+		return nil, 0, errorOf(ErrInvalidRequest, "ordering key is longer than %d bytes", MaxOrderingKeyLength)
+	}
+	if len(opts.CompactKey) > MaxCompactKeyLength {
+		return nil, 0, errorOf(ErrInvalidRequest, "compact key is longer than %d bytes", MaxCompactKeyLength)
+	}
+	if len(opts.ReplyTo) > MaxCorrelationLength || len(opts.CorrelationID) > MaxCorrelationLength {
+		return nil, 0, errorOf(ErrInvalidRequest, "reply_to and correlation_id must be at most %d bytes", MaxCorrelationLength)
+	}
+	if err := checkUserTopic(topicName); err != nil {
+		return nil, 0, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	topic, exists := ps.topics.get(topicName)
+
+	if !exists {
+		return nil, 0, errorOf(ErrTopicNotFound, "topic %s not found", topicName)
+	}
+
+	// Validate outside the topic lock; a schema or limit change applies
+	// from the next publish on
+	limits := ps.PayloadLimits()
+	topic.mutex.RLock()
+	schema, archived := topic.Schema, topic.Archived
+	if topic.MaxPayloadBytes > 0 {
+		limits.MaxBytes = topic.MaxPayloadBytes
+	}
+	topic.mutex.RUnlock()
+	if archived {
+		return nil, 0, fmt.Errorf("%w: %s", ErrTopicArchived, topicName)
+	}
+	size, err := limits.checkMessage(message)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := schema.Validate(topicName, message.Payload); err != nil {
+		return nil, 0, err
+	}
+	return topic, size, nil
+}
+
+// publishToTopic records a message in the topic's history and fans it out,
+// or holds it while the topic is paused; an imported one is only recorded.
+// size is the payload's marshaled
+// length, reported to hooks; sender is the publishing client, if any.
+func (ps *PubSubSystem) publishToTopic(ctx context.Context, topic *Topic, message MessageData, size int, sender string, opts PublishOptions) (PublishResult, error) {
+	// Create event message
+	ephemeral := opts.Ephemeral || opts.Volatile
+	event := EventResponse{
+		Type:        "event",
+		Topic:       topic.Name,
+		Message:     message,
+		OrderingKey: opts.OrderingKey,
+		Timestamp:   ps.clock.Now(),
+		Ephemeral:   ephemeral,
+		Volatile:    opts.Volatile,
+		CompactKey:  opts.CompactKey,
+
+		ReplyTo:       opts.ReplyTo,
+		CorrelationID: opts.CorrelationID,
+	}
+	if ps.tracing.Load() != nil {
+		event.trace = trace.SpanContextFromContext(ctx)
+	}
+
+	// The loopback self-check is not reported to hooks or counted in
+	// deliveries, and neither it nor the $sys topics are archived
+	hooked := topic.Name != loopbackTopicName
+	durable := !IsSystemTopic(topic.Name) && !ephemeral
+	ps.holdHooks()
+	topic.mutex.Lock()
+	// Checked again under the lock so nothing lands after archiving returns
+	if topic.Archived {
+		topic.mutex.Unlock()
+		ps.releaseHooks()
+		return PublishResult{}, fmt.Errorf("%w: %s", ErrTopicArchived, topic.Name)
+	}
+	// The topic's bucket is shared by every publisher, so it is taken under
+	// the lock the publish already holds
+	if topic.rate != nil && !opts.historyOnly && !topic.rate.allow(event.Timestamp) {
+		limit := topic.rate.limit
+		topic.mutex.Unlock()
+		ps.releaseHooks()
+		return PublishResult{}, fmt.Errorf("%w: topic %s allows %g messages per second", ErrTopicRateLimited, topic.Name, limit)
+	}
+	var signingKey []byte
+	if topic.SigningKeyID != "" {
+		// A topic restored with a key the server no longer has publishes
+		// nothing rather than unsigned events
+		key, err := ps.signingKey(topic.SigningKeyID)
+		if err != nil {
+			topic.mutex.Unlock()
+			ps.releaseHooks()
+			return PublishResult{}, err
+		}
+		signingKey = key
+	}
+	if ephemeral {
+		topic.EphemeralCount++
+	} else {
+		topic.MessageCount++
+		topic.LastSeq++
+		event.Seq = topic.LastSeq
+	}
+	topic.LastPublishedAt = event.Timestamp
+	if signingKey != nil {
+		// The payload already passed the JSON size check
+		event.Signature, _ = SignEvent(event, topic.SigningKeyID, signingKey)
+	}
+	if opts.historyOnly {
+		ps.storeEvent(topic, event, durable)
+		result := PublishResult{Seq: event.Seq}
+		topic.mutex.Unlock()
+		ps.releaseHooks()
+		return result, nil
+	}
+	topic.activity.published()
+	if hooked {
+		ps.emit(hookEvent{kind: hookPublish, topic: topic.Name, size: size})
+	}
+
+	// Add message to topic's history for last_n functionality
+	ps.storeEvent(topic, event, durable)
+	// Tracked before fan-out so no ack can arrive ahead of the record
+	if opts.Receipts && !ephemeral {
+		ps.receipts.record(topic, event, sender)
+	}
+
+	// Subscribers get the event marked as its first, live delivery;
+	// history and webhooks keep it bare
+	live := event
+	live.Delivery = &DeliveryInfo{Attempt: 1}
+
+	// A paused topic holds events for its subscribers until it resumes,
+	// except volatile ones
+	if topic.held != nil {
+		if !event.Volatile {
+			topic.hold(ps, live)
+		}
+	} else {
+		ps.fanOut(topic, live, hooked)
+	}
+	if hooked {
+		ps.tapFirehose(live)
+	}
+
+	webhooks := make([]*Webhook, 0, len(topic.Webhooks))
+	for _, webhook := range topic.Webhooks {
+		webhooks = append(webhooks, webhook)
+	}
+	result := PublishResult{Seq: event.Seq, Paused: topic.held != nil}
+	topic.mutex.Unlock()
+	ps.releaseHooks()
+
+	// Hand off to webhook workers outside the topic lock; this never blocks,
+	// a full delivery queue drops the batch instead
+	for _, webhook := range webhooks {
+		ps.webhooks.Enqueue(webhook, event)
+	}
+	return result, nil
+}
+
+// storeEvent adds an event to the topic's history and, if durable, to the
+// persisted history. Callers must hold the topic lock.
+func (ps *PubSubSystem) storeEvent(topic *Topic, event EventResponse, durable bool) {
+	if !event.Ephemeral {
+		topic.MessageHistory.Push(event)
+	}
+	if ps.store != nil && durable {
+		ps.store.Append(event)
+	}
+	if ps.sqlite != nil && durable {
+		ps.sqlite.Append(event)
+	}
+}
+
+// fanOut sends an event to a topic's subscribers. Callers must hold the
+// topic lock.
+func (ps *PubSubSystem) fanOut(topic *Topic, event EventResponse, hooked bool) {
+	// Subscribers share one prepared event so it is encoded once per wire
+	// format rather than once per client
+	prepared := NewPreparedEvent(event)
+	event, span := ps.traceFanOut(event, len(topic.Subscribers))
+	defer span.End()
+	_, ackWindow := ps.ackPolicy()
+	pool := ps.fanout.Load()
+	var live []*Subscriber
+	for _, subscriber := range topic.Subscribers {
+		// Check if client is still connected
+		if !subscriber.Client.IsConnected() {
+			continue
+		}
+
+		// Explicit-ack subscribers get a tagged copy, or nothing while
+		// their unacked window is full; they apply their own filter.
+		// Ephemeral events can't be redelivered, so they go out untracked.
+		if subscriber.ack != nil && !event.Ephemeral {
+			span := ps.traceDelivery(event, subscriber.ClientID, "explicit_ack")
+			subscriber.ack.deliver(event, ackWindow)
+			span.End()
+			continue
+		}
+
+		// Paused subscriptions hold events until they resume, except
+		// volatile ones
+		if subscriber.paused != nil {
+			if !event.Volatile && subscriber.filter.MatchMessage(event.Message) && ps.deliverable(event, subscriber.ClientID) {
+				span := ps.traceDelivery(event, subscriber.ClientID, "held")
+				subscriber.paused.hold(ps, subscriber.ClientID, event)
+				span.End()
+			}
+			continue
+		}
+
+		// Send message to all subscribers (including sender), here or on
+		// the event's fan-out lane
+		if pool != nil {
+			live = append(live, subscriber)
+			continue
+		}
+		ps.deliverLive(topic, subscriber, event, prepared, hooked)
+	}
+	if len(live) > 0 {
+		pool.dispatch(fanoutJob{topic: topic, event: event, prepared: prepared, subscribers: live, hooked: hooked})
+	}
+}
+
+// GetTopics returns all topics with subscriber counts
+func (ps *PubSubSystem) GetTopics() []TopicInfo {
+	topics := make([]TopicInfo, 0, ps.topics.len())
+	ps.topics.each(func(topic *Topic) {
+		topic.mutex.RLock()
+		topics = append(topics, TopicInfo{
+			Name:          topic.Name,
+			Subscribers:   len(topic.Subscribers),
+			MessageCount:  topic.MessageCount,
+			CreatedAt:     topic.CreatedAt,
+			Description:   topic.Description,
+			Labels:        topic.Labels,
+			Paused:        topic.paused(),
+			TopicActivity: topic.activity.Snapshot(topic.LastPublishedAt),
+		})
+		topic.mutex.RUnlock()
+	})
+
+	return topics
+}
+
+// GetTopicDetail returns detailed information about a single topic
+func (ps *PubSubSystem) GetTopicDetail(name string) (TopicDetailResponse, error) {
+	topic, exists := ps.topics.get(name)
+
+	if !exists {
+		return TopicDetailResponse{}, errorOf(ErrTopicNotFound, "topic %s not found", name)
+	}
+
+	topic.mutex.RLock()
+	defer topic.mutex.RUnlock()
+
+	detail := TopicDetailResponse{
+		Name:             topic.Name,
+		CreatedAt:        topic.CreatedAt,
+		MessageCount:     topic.MessageCount,
+		Subscribers:      len(topic.Subscribers),
+		HistorySize:      topic.MessageHistory.Cap(),
+		HistoryCount:     topic.MessageHistory.Len(),
+		LatestSeq:        topic.LastSeq,
+		RetentionSeconds: int(topic.Retention / time.Second),
+		ExpiredCount:     topic.MessageHistory.Expired(),
+		DeadLetterTopic:  topic.DeadLetterTopic,
+		Schema:           topic.Schema.Raw(),
+		State:            topic.state(),
+		Description:      topic.Description,
+		Labels:           topic.Labels,
+		Signed:           topic.SigningKeyID != "",
+		SigningKeyID:     topic.SigningKeyID,
+		Compacted:        topic.Compacted,
+		Owner:            topic.Owner,
+		Visibility:       topic.visibility(),
+		PendingJoins:     ps.PendingJoins(name),
+		Paused:           topic.paused(),
+		BacklogEvictions: topic.BacklogEvictions,
+		MaxPayloadBytes:  topic.MaxPayloadBytes,
+		MaxPublishRate:   topic.maxPublishRate(),
+		SlowConsumer:     slowConsumerSettings(topic.slowConsumer.Load()),
+		Watermarks:       topic.watermarks.Load(),
+		TopicActivity:    topic.activity.Snapshot(topic.LastPublishedAt),
+	}
+	if topic.held != nil {
+		detail.Backlog = len(topic.held.events)
+	}
+
+	return detail, nil
+}
+
+// GetTopicMessages returns a page of a topic's message history.
+// afterSeq and beforeSeq are exclusive cursors (0 means unset); when neither is
+// set the page starts from the newest message for descending order and from the
+// oldest for ascending order. The returned flag reports whether more messages
+// exist beyond the page in the direction of travel.
+func (ps *PubSubSystem) GetTopicMessages(name string, afterSeq, beforeSeq int64, limit int, descending bool) ([]EventResponse, bool, error) {
+	return ps.QueryTopicMessages(name, HistoryQuery{AfterSeq: afterSeq, BeforeSeq: beforeSeq, Limit: limit, Descending: descending})
+}
+
+// QueryTopicMessages returns a page of a topic's message history, like
+// GetTopicMessages. With SQLite history enabled the page comes from the
+// archive, which also supports the From and To time range; otherwise it
+// comes from the in-memory ring.
+func (ps *PubSubSystem) QueryTopicMessages(name string, q HistoryQuery) ([]EventResponse, bool, error) {
+	topic, exists := ps.topics.get(name)
+
+	if !exists {
+		return nil, false, errorOf(ErrTopicNotFound, "topic %s not found", name)
+	}
+	if q.Limit <= 0 {
+		return nil, false, errorOf(ErrInvalidRequest, "limit must be positive")
+	}
+	if ps.sqlite != nil {
+		return ps.sqlite.Query(name, q)
+	}
+	if !q.From.IsZero() || !q.To.IsZero() {
+		return nil, false, errorOf(ErrInvalidRequest, "time ranges need SQLite history")
+	}
+
+	var messages []EventResponse
+	var more bool
+	switch {
+	case q.AfterSeq > 0 || (q.BeforeSeq == 0 && !q.Descending):
+		// Walk forward from the cursor (or from the oldest message)
+		messages = topic.MessageHistory.RangeAfter(q.AfterSeq, q.Limit+1)
+		if len(messages) > q.Limit {
+			messages = messages[:q.Limit]
+			more = true
+		}
+	default:
+		// Walk backward from the cursor (or from the newest message)
+		if q.BeforeSeq == 0 {
+			messages = topic.MessageHistory.GetLastN(q.Limit + 1)
+		} else {
+			messages = topic.MessageHistory.RangeBefore(q.BeforeSeq, q.Limit+1)
+		}
+		if len(messages) > q.Limit {
+			messages = messages[len(messages)-q.Limit:]
+			more = true
+		}
+	}
+
+	if q.Descending {
+		reverseEvents(messages)
+	}
+
+	return messages, more, nil
+}
+
+// PurgeTopicHistory removes messages from a topic's history without touching
+// its subscribers or counters. With a zero beforeTS and beforeSeq the whole
+// history is cleared; otherwise only messages older than beforeTS or with a
+// sequence number below beforeSeq are removed. Returns the number purged.
+func (ps *PubSubSystem) PurgeTopicHistory(name string, beforeTS time.Time, beforeSeq int64) (int, error) {
+	if err := checkUserTopic(name); err != nil {
+		return 0, err
+	}
+	topic, exists := ps.topics.get(name)
+
+	if !exists {
+		return 0, errorOf(ErrTopicNotFound, "topic %s not found", name)
+	}
+
+	// Hold the topic lock so a concurrent publish lands either before or
+	// after the purge, never halfway through it
+	topic.mutex.Lock()
+	defer topic.mutex.Unlock()
+
+	var purged int
+	switch {
+	case !beforeTS.IsZero():
+		purged = topic.MessageHistory.EvictOlderThan(beforeTS)
+	case beforeSeq > 0:
+		purged = topic.MessageHistory.RemoveBeforeSeq(beforeSeq)
+	default:
+		purged = topic.MessageHistory.Clear()
+	}
+
+	if ps.store != nil && purged > 0 {
+		ps.store.Rewrite(name, topic.MessageHistory.GetAll())
+	}
+	// The archive may hold older events than the ring, so purge it either way
+	if ps.sqlite != nil {
+		ps.sqlite.Purge(name, beforeTS, beforeSeq)
+	}
+
+	return purged, nil
+}
+
+// GetStats returns detailed statistics
+func (ps *PubSubSystem) GetStats() StatsResponse {
+	stats := StatsResponse{
+		Topics: make(map[string]TopicStats),
+		WebSocket: WebSocketTrafficStats{
+			PayloadBytes:       ps.wsTraffic.PayloadBytes.Load(),
+			WireBytes:          ps.wsTraffic.WireBytes.Load(),
+			CompressedMessages: ps.wsTraffic.CompressedMessages.Load(),
+			OriginRejections:   ps.wsTraffic.OriginRejections.Load(),
+			LimitRejections:    ps.wsTraffic.LimitRejections.Load(),
+			IPLimitRejections:  ps.wsTraffic.IPLimitRejections.Load(),
+			RepeatedRequests:   ps.wsTraffic.RepeatedRequests.Load(),
+			ErrorDisconnects:   ps.wsTraffic.ErrorDisconnects.Load(),
+			ExpiredUploads:     ps.wsTraffic.ExpiredUploads.Load(),
+			UnackedPublishes:   ps.wsTraffic.UnackedPublishes.Load(),
+			BatchedPublishes:   ps.wsTraffic.BatchedPublishes.Load(),
+			BatchAcks:          ps.wsTraffic.BatchAcks.Load(),
+			Connections:        ps.connections.Load(),
+		},
+		HTTP: HTTPTrafficStats{
+			BodyTooLarge: ps.httpTraffic.BodyTooLarge.Load(),
+			RateLimited:  ps.httpTraffic.RateLimited.Load(),
+		},
+	}
+
+	scheduled := ps.scheduler.pending()
+	ps.topics.each(func(topic *Topic) {
+		topic.mutex.RLock()
+		stats.Topics[topic.Name] = TopicStats{
+			Messages:      topic.MessageCount,
+			Ephemeral:     topic.EphemeralCount,
+			Scheduled:     scheduled[topic.Name],
+			Subscribers:   len(topic.Subscribers),
+			BytesIn:       topic.activity.bytesIn.Load(),
+			BytesOut:      topic.activity.bytesOut.Load(),
+			SlowConsumers: topic.SlowConsumerUnsubscribes,
+			History:       topic.MessageHistory.Stats(),
+			TopicActivity: topic.activity.Snapshot(topic.LastPublishedAt),
+		}
+		topic.mutex.RUnlock()
+	})
+
+	if unacked := ps.unackedByConsumer(""); len(unacked) > 0 {
+		stats.Unacked = unacked
+	}
+	stats.Firehose = ps.firehoseStats()
+	stats.Buffers = ps.bufferStats()
+	if ps.store != nil {
+		status := ps.store.Status()
+		stats.History = &status
+	}
+
+	return stats
+}
+
+// GetHealth returns system health information
+func (ps *PubSubSystem) GetHealth() HealthResponse {
+	totalSubscribers := 0
+	totalTopics := 0
+	ps.topics.each(func(topic *Topic) {
+		topic.mutex.RLock()
+		totalSubscribers += len(topic.Subscribers)
+		topic.mutex.RUnlock()
+		totalTopics++
+	})
+
+	ps.clientMutex.RLock()
+	connections := len(ps.clients)
+	identifiedClients := len(ps.clientTopics)
+	thresholds := ps.healthThresholds
+	ps.clientMutex.RUnlock()
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	deliveries := ps.deliveries.Sum()
+	drops := ps.drops.Sum()
+	dropRate := 0.0
+	if deliveries > 0 {
+		dropRate = float64(drops) / float64(deliveries)
+	}
+
+	health := HealthResponse{
+		Status:            "ok",
+		UptimeSeconds:     int(ps.clock.Now().Sub(ps.startTime).Seconds()),
+		Topics:            totalTopics,
+		Subscribers:       totalSubscribers,
+		Connections:       connections,
+		IdentifiedClients: identifiedClients,
+		Goroutines:        runtime.NumGoroutine(),
+		HeapInuseBytes:    memStats.HeapInuse,
+		DroppedLastMinute: drops,
+		DropRate:          dropRate,
+		Drain:             ps.DrainState(),
+	}
+
+	if thresholds.MaxDropRate > 0 && dropRate > thresholds.MaxDropRate {
+		health.Reasons = append(health.Reasons, fmt.Sprintf("drop rate %.3f exceeds %.3f", dropRate, thresholds.MaxDropRate))
+	}
+	if thresholds.MaxConnections > 0 && connections >= thresholds.MaxConnections {
+		health.Reasons = append(health.Reasons, fmt.Sprintf("%d connections reaches limit of %d", connections, thresholds.MaxConnections))
+	}
+	if ps.store != nil {
+		status := ps.store.Status()
+		health.History = &status
+		if status.Overflowing {
+			health.Reasons = append(health.Reasons, "history store write queue is overflowing")
+		}
+	}
+	if ps.sqlite != nil {
+		status := ps.sqlite.Status()
+		health.SQLite = &status
+		if status.Overflowing {
+			health.Reasons = append(health.Reasons, "SQLite history write queue is overflowing")
+		}
+	}
+	if names, statuses := ps.bridges.statuses(); len(names) > 0 {
+		health.Bridges = statuses
+		for _, name := range names {
+			if !statuses[name].Connected {
+				health.Reasons = append(health.Reasons, fmt.Sprintf("%s bridge is disconnected", name))
+			}
+		}
+	}
+	if names, statuses := ps.exporters.statuses(); len(names) > 0 {
+		health.Exporters = statuses
+		for _, name := range names {
+			if statuses[name].Overflowing {
+				health.Reasons = append(health.Reasons, fmt.Sprintf("%s exporter queue is overflowing", name))
+			}
+		}
+	}
+	if len(health.Reasons) > 0 {
+		health.Status = "degraded"
+	}
+
+	return health
+}
+
+// GetClientTopics returns all topics a client is subscribed to
+func (ps *PubSubSystem) GetClientTopics(clientID string) []string {
+	ps.clientMutex.RLock()
+	defer ps.clientMutex.RUnlock()
+
+	topicsMap, exists := ps.clientTopics[clientID]
+	if !exists {
+		return []string{}
+	}
+
+	topics := make([]string, 0, len(topicsMap))
+	for topic := range topicsMap {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// GetSubscriptionsStatus returns detailed subscription information for all clients
+func (ps *PubSubSystem) GetSubscriptionsStatus() SubscriptionsStatusResponse {
+	return ps.ListSubscriptions(0, 0)
+}
+
+// DisconnectClient cleans up when a client disconnects from all topics
+func (ps *PubSubSystem) DisconnectClient(clientID string) {
+	ps.UnsubscribeFirehose(clientID)
+
+	ps.clientMutex.Lock()
+
+	// Remove client from topics mapping
+	topicsMap, exists := ps.clientTopics[clientID]
+	if exists {
+		delete(ps.clientTopics, clientID)
+	}
+	ps.clientMutex.Unlock()
+
+	if !exists {
+		return
+	}
+
+	// Remove from all subscribed topics
+	ps.holdHooks()
+	defer ps.releaseHooks()
+	for topicName := range topicsMap {
+		ps.ttls.cancel(ttlKey{clientID, topicName})
+		if topic, exists := ps.topics.get(topicName); exists {
+			topic.mutex.Lock()
+			subscriber, subscribed := topic.Subscribers[clientID]
+			if subscribed && subscriber.ack != nil {
+				// Keep unacked events for the consumer's next connection
+				subscriber.ack.detach(subscriber.Client)
+			}
+			delete(topic.Subscribers, clientID)
+			var crossing *SubscriberWatermarkEvent
+			if subscribed {
+				crossing = ps.crossWatermarks(topic, len(topic.Subscribers)+1)
+			}
+			topic.mutex.Unlock()
+			if subscribed {
+				ps.emit(hookEvent{kind: hookUnsubscribe, topic: topicName, clientID: clientID})
+				ps.announceWatermark(crossing)
+			}
+		}
+	}
+
+	// Deliveries already queued for the client run before the transport
+	// tears down its connection
+	ps.flushFanout()
+}
@@ -0,0 +1,407 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingClient is a connected client that keeps everything it is sent
+type recordingClient struct {
+	id       string
+	mutex    sync.Mutex
+	messages []interface{}
+}
+
+func (c *recordingClient) GetClientID() string      { return c.id }
+func (c *recordingClient) IsConnected() bool        { return true }
+func (c *recordingClient) GetLastActive() time.Time { return time.Now() }
+
+func (c *recordingClient) SendMessage(msg interface{}) error {
+	c.mutex.Lock()
+	c.messages = append(c.messages, msg)
+	c.mutex.Unlock()
+	return nil
+}
+
+// received returns the events sent to the client so far, unwrapping
+// shared fan-out events
+func (c *recordingClient) received() []EventResponse {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var events []EventResponse
+	for _, msg := range c.messages {
+		switch m := msg.(type) {
+		case EventResponse:
+			events = append(events, m)
+		case *PreparedEvent:
+			events = append(events, m.Event)
+		}
+	}
+	return events
+}
+
+// waitEvents waits until at least n events have been sent and returns them
+func (c *recordingClient) waitEvents(t *testing.T, n int) []EventResponse {
+	t.Helper()
+	var events []EventResponse
+	waitFor(t, fmt.Sprintf("%d events for %s", n, c.id), func() bool {
+		events = c.received()
+		return len(events) >= n
+	})
+	return events
+}
+
+// publishN publishes n messages to a topic
+func publishN(t *testing.T, ps *PubSubSystem, topic string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := ps.Publish(context.Background(), topic, MessageData{ID: fmt.Sprintf("m%d", i), Payload: i}, ""); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+}
+
+// history returns every message in a topic's history, oldest first
+func history(t *testing.T, ps *PubSubSystem, name string) []EventResponse {
+	t.Helper()
+	events, _, err := ps.GetTopicMessages(name, 0, 0, TopicHistoryBufferSize, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+// historyTopic creates "orders" with a subscriber and n messages
+func historyTopic(t *testing.T, n int) (*PubSubSystem, *recordingClient) {
+	t.Helper()
+	ps := New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	watcher := &recordingClient{id: "watcher"}
+	if _, err := ps.Subscribe(context.Background(), "watcher", "orders", 0, watcher); err != nil {
+		t.Fatal(err)
+	}
+	publishN(t, ps, "orders", n)
+	return ps, watcher
+}
+
+func TestPurgeTopicHistoryClearsEverything(t *testing.T) {
+	ps, _ := historyTopic(t, 5)
+
+	purged, err := ps.PurgeTopicHistory("orders", time.Time{}, 0)
+	if err != nil || purged != 5 {
+		t.Fatalf("PurgeTopicHistory = %d, %v", purged, err)
+	}
+
+	// Counters and subscribers are left alone
+	detail, err := ps.GetTopicDetail("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detail.HistoryCount != 0 || detail.MessageCount != 5 || detail.Subscribers != 1 {
+		t.Errorf("after clearing, detail = %+v", detail)
+	}
+	if replay, err := ps.Subscribe(context.Background(), "late", "orders", 10, &recordingClient{id: "late"}); err != nil || len(replay) != 0 {
+		t.Errorf("last_n after clearing = %d events, %v", len(replay), err)
+	}
+
+	// Sequence numbers carry on where they were
+	publishN(t, ps, "orders", 1)
+	if events := history(t, ps, "orders"); len(events) != 1 || events[0].Seq != 6 {
+		t.Errorf("history after clearing and publishing = %v", seqs(events))
+	}
+
+	if _, err := ps.PurgeTopicHistory("missing", time.Time{}, 0); err == nil {
+		t.Error("purging an unknown topic succeeded")
+	}
+}
+
+func TestPurgeTopicHistoryPartially(t *testing.T) {
+	ps, _ := historyTopic(t, 10)
+
+	purged, err := ps.PurgeTopicHistory("orders", time.Time{}, 4)
+	if err != nil || purged != 3 {
+		t.Fatalf("purge before seq 4 = %d, %v", purged, err)
+	}
+	if events := history(t, ps, "orders"); !equalSeqs(events, 4, 5, 6, 7, 8, 9, 10) {
+		t.Errorf("history after purging before seq 4 = %v", seqs(events))
+	}
+
+	// By timestamp, cutting between two batches
+	time.Sleep(5 * time.Millisecond)
+	cut := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	publishN(t, ps, "orders", 2)
+	purged, err = ps.PurgeTopicHistory("orders", cut, 0)
+	if err != nil || purged != 7 {
+		t.Fatalf("purge before %s = %d, %v", cut, purged, err)
+	}
+	if events := history(t, ps, "orders"); !equalSeqs(events, 11, 12) {
+		t.Errorf("history after purging by time = %v", seqs(events))
+	}
+	if replay, _ := ps.Subscribe(context.Background(), "late", "orders", 10, &recordingClient{id: "late"}); !equalSeqs(replay, 11, 12) {
+		t.Errorf("last_n after purging = %v", seqs(replay))
+	}
+}
+
+func TestPurgeTopicHistoryDuringPublishes(t *testing.T) {
+	ps, watcher := historyTopic(t, 0)
+	const total = 500
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < total; i++ {
+			if err := ps.Publish(context.Background(), "orders", MessageData{ID: fmt.Sprintf("m%d", i)}, ""); err != nil {
+				t.Errorf("Publish: %v", err)
+				return
+			}
+		}
+	}()
+	purged := 0
+purging:
+	for {
+		select {
+		case <-done:
+			break purging
+		default:
+		}
+		n, err := ps.PurgeTopicHistory("orders", time.Time{}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		purged += n
+	}
+
+	// Every message was either purged or survived, and the survivors are
+	// the newest ones in order
+	events := history(t, ps, "orders")
+	if purged+len(events) != total {
+		t.Errorf("purged %d and kept %d of %d", purged, len(events), total)
+	}
+	for i, event := range events {
+		if want := int64(total - len(events) + i + 1); event.Seq != want {
+			t.Fatalf("surviving history = %v", seqs(events))
+		}
+	}
+	if got := len(watcher.received()); got != total {
+		t.Errorf("subscriber got %d of %d events", got, total)
+	}
+}
+
+func TestEphemeralPublishesStayOutOfHistory(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "chat"); err != nil {
+		t.Fatal(err)
+	}
+	app := &recordingClient{id: "app"}
+	subscribeClient(t, ps, "chat", app)
+
+	publishes := []struct {
+		id   string
+		opts PublishOptions
+	}{
+		{"hello", PublishOptions{}},
+		{"typing", PublishOptions{Ephemeral: true}},
+		{"how are you", PublishOptions{}},
+		{"online", PublishOptions{Volatile: true}},
+	}
+	for _, p := range publishes {
+		if err := ps.PublishWithOptions(ctx, "chat", MessageData{ID: p.id, Payload: p.id}, "", p.opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Current subscribers get every message, the ephemeral ones without a
+	// seq so the stored ones stay contiguous
+	events := app.waitEvents(t, 4)
+	for i, want := range []int64{1, 0, 2, 0} {
+		if events[i].Seq != want || events[i].Ephemeral != (want == 0) {
+			t.Errorf("event %d = seq %d ephemeral %v, want seq %d", i, events[i].Seq, events[i].Ephemeral, want)
+		}
+	}
+	if !events[3].Volatile {
+		t.Error("volatile publish delivered without the flag")
+	}
+
+	// last_n sees only the stored ones
+	late := &recordingClient{id: "late"}
+	ps.RegisterClient(late)
+	history, err := ps.Subscribe(ctx, "late", "chat", 10, late)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Message.ID != "hello" || history[1].Message.ID != "how are you" {
+		t.Errorf("last_n = %+v", history)
+	}
+	if stats := ps.GetStats().Topics["chat"]; stats.Messages != 2 || stats.Ephemeral != 2 {
+		t.Errorf("stats = %d messages, %d ephemeral; want 2 and 2", stats.Messages, stats.Ephemeral)
+	}
+}
+
+func TestPausedSubscriptionsDropVolatileEvents(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "chat"); err != nil {
+		t.Fatal(err)
+	}
+	app := &recordingClient{id: "app"}
+	subscribeClient(t, ps, "chat", app)
+	if err := ps.PauseSubscription(ctx, "app", "chat"); err != nil {
+		t.Fatal(err)
+	}
+
+	ps.PublishWithOptions(ctx, "chat", MessageData{ID: "typing", Payload: 1}, "", PublishOptions{Volatile: true})
+	ps.PublishWithOptions(ctx, "chat", MessageData{ID: "status", Payload: 2}, "", PublishOptions{Ephemeral: true})
+	ps.Publish(ctx, "chat", MessageData{ID: "hello", Payload: 3}, "")
+
+	// The volatile event takes no buffer slot; the merely ephemeral one does
+	buffered, evicted, err := ps.ResumeSubscription(ctx, "app", "chat")
+	if err != nil || buffered != 2 || evicted != 0 {
+		t.Fatalf("resume = %d buffered, %d evicted, %v; want 2 and 0", buffered, evicted, err)
+	}
+	if events := app.waitEvents(t, 2); events[0].Message.ID != "status" || events[1].Message.ID != "hello" {
+		t.Errorf("resumed events = %+v", events)
+	}
+}
+
+func TestDeliveryMetadata(t *testing.T) {
+	ps := New()
+	defer ps.Close()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	check := func(who string, event EventResponse, want DeliveryInfo) {
+		t.Helper()
+		if event.Delivery == nil || *event.Delivery != want {
+			t.Errorf("%s seq %d delivery = %+v, want %+v", who, event.Seq, event.Delivery, want)
+		}
+	}
+	live := DeliveryInfo{Attempt: 1}
+	replay := DeliveryInfo{Attempt: 1, Replay: true}
+
+	ledger := &recordingClient{id: "ledger"}
+	ps.RegisterClient(ledger)
+	if _, err := ps.SubscribeWithOptions(ctx, "ledger", "orders", SubscribeOptions{AckMode: AckModeExplicit}, ledger); err != nil {
+		t.Fatal(err)
+	}
+	paused := &recordingClient{id: "paused"}
+	subscribeClient(t, ps, "orders", paused)
+	if err := ps.PauseSubscription(ctx, "paused", "orders"); err != nil {
+		t.Fatal(err)
+	}
+	publishN(t, ps, "orders", 3)
+	for _, event := range ledger.waitEvents(t, 3) {
+		check("ledger", event, live)
+	}
+	// History stays bare
+	for _, event := range history(t, ps, "orders") {
+		if event.Delivery != nil {
+			t.Errorf("history seq %d has delivery %+v", event.Seq, event.Delivery)
+		}
+	}
+
+	// Replays are flagged, then live events are not
+	lastN, err := ps.SubscribeWithOptions(ctx, "late", "orders", SubscribeOptions{LastN: 2}, &recordingClient{id: "late"})
+	if err != nil || len(lastN) != 2 {
+		t.Fatalf("last_n = %d events, %v", len(lastN), err)
+	}
+	for _, event := range lastN {
+		check("last_n", event, replay)
+	}
+	since := &recordingClient{id: "since"}
+	ps.RegisterClient(since)
+	if _, err := ps.SubscribeWithOptions(ctx, "since", "orders", SubscribeOptions{SinceSeq: 1}, since); err != nil {
+		t.Fatal(err)
+	}
+	ps.DisconnectClient("ledger")
+	publishN(t, ps, "orders", 1)
+	events := since.waitEvents(t, 3)
+	check("since_seq", events[0], replay)
+	check("since_seq", events[1], replay)
+	check("since_seq", events[2], live)
+
+	// Events held while paused are still first, live deliveries
+	if _, _, err := ps.ResumeSubscription(ctx, "paused", "orders"); err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range paused.waitEvents(t, 4) {
+		check("resumed", event, live)
+	}
+
+	// A reconnecting explicit-ack consumer gets its unacked events as
+	// second attempts, then what it missed as first ones
+	reconnected := &recordingClient{id: "ledger"}
+	ps.RegisterClient(reconnected)
+	if _, err := ps.SubscribeWithOptions(ctx, "ledger", "orders", SubscribeOptions{AckMode: AckModeExplicit}, reconnected); err != nil {
+		t.Fatal(err)
+	}
+	events = reconnected.waitEvents(t, 4)
+	for _, event := range events[:3] {
+		check("redelivered", event, DeliveryInfo{Redelivered: true, Attempt: 2})
+		if !event.Redelivered {
+			t.Errorf("redelivered seq %d lacks the redelivered flag", event.Seq)
+		}
+	}
+	check("missed", events[3], live)
+}
+
+// waitFor polls cond until it holds or the test times out
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueryTopicMessagesNeedsAPositiveLimit(t *testing.T) {
+	ps := New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	publishN(t, ps, "orders", 3)
+	for _, limit := range []int{0, -1} {
+		if _, _, err := ps.QueryTopicMessages("orders", HistoryQuery{Limit: limit, Descending: true}); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("limit %d: %v", limit, err)
+		}
+	}
+}
+
+func TestConcurrentReadinessProbes(t *testing.T) {
+	ps := New()
+	defer ps.Close()
+
+	var wg sync.WaitGroup
+	results := make(chan ReadinessResponse, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- ps.CheckReadiness()
+		}()
+	}
+	wg.Wait()
+	close(results)
+	for readiness := range results {
+		if readiness.Status != "ready" {
+			t.Errorf("readiness = %+v", readiness)
+		}
+	}
+
+	// Probes aren't subscriber traffic, so they count as neither
+	// deliveries nor drops
+	if deliveries, drops := ps.deliveries.Sum(), ps.drops.Sum(); deliveries != 0 || drops != 0 {
+		t.Errorf("probes counted %d deliveries and %d drops", deliveries, drops)
+	}
+}
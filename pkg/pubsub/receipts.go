@@ -0,0 +1,221 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultReceiptRetention = 10 * time.Minute // How long a message's receipts are kept
+	DefaultMaxReceipts      = 10000            // Messages whose receipts are kept before evicting the oldest
+
+	// How long acks are collected before being pushed to the publisher
+	receiptFlushInterval = 200 * time.Millisecond
+)
+
+// ErrNoReceipts is returned for a message published without receipts, or
+// whose receipts have expired
+var ErrNoReceipts = errors.New("no receipts for message")
+
+// receiptKey identifies a message's receipts
+type receiptKey struct {
+	topic     string
+	messageID string
+}
+
+// messageReceipts records which of a message's explicit-ack subscribers
+// have acked it
+type messageReceipts struct {
+	receiptKey
+	seq         int64
+	publisher   string
+	publishedAt time.Time
+	recordedAt  time.Time            // On the tracker's clock, for expiry
+	expected    map[string]bool      // Explicit-ack subscribers when it was published
+	acked       map[string]time.Time // Client ID -> when it acked
+}
+
+// receiptTracker keeps the receipts of messages published with receipts
+// on, bounded by age and count, and pushes new acks to each publisher in
+// batches. Its mutex is taken after topic locks and ack states, never
+// before.
+type receiptTracker struct {
+	ps *PubSubSystem
+
+	mutex       sync.Mutex
+	retention   time.Duration
+	maxMessages int
+	byKey       map[receiptKey]*messageReceipts
+	order       []*messageReceipts         // Oldest first
+	pending     map[string][]ReceiptUpdate // Publisher -> acks not yet pushed
+}
+
+// newReceiptTracker creates an empty tracker with the default bounds
+func newReceiptTracker(ps *PubSubSystem) *receiptTracker {
+	return &receiptTracker{
+		ps:          ps,
+		retention:   DefaultReceiptRetention,
+		maxMessages: DefaultMaxReceipts,
+		byKey:       make(map[receiptKey]*messageReceipts),
+		pending:     make(map[string][]ReceiptUpdate),
+	}
+}
+
+// SetReceiptPolicy configures how long receipts are kept and for how many
+// messages at most. Non-positive values keep the defaults.
+func (ps *PubSubSystem) SetReceiptPolicy(retention time.Duration, maxMessages int) {
+	if retention <= 0 {
+		retention = DefaultReceiptRetention
+	}
+	if maxMessages <= 0 {
+		maxMessages = DefaultMaxReceipts
+	}
+	t := ps.receipts
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.retention = retention
+	t.maxMessages = maxMessages
+	t.prune()
+}
+
+// MessageReceipts returns which subscribers have acked a message published
+// with receipts on
+func (ps *PubSubSystem) MessageReceipts(topicName, messageID string) (MessageReceiptsResponse, error) {
+	if _, exists := ps.topics.get(topicName); !exists {
+		return MessageReceiptsResponse{}, errorOf(ErrTopicNotFound, "topic %s not found", topicName)
+	}
+
+	t := ps.receipts
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.prune()
+
+	r, exists := t.byKey[receiptKey{topicName, messageID}]
+	if !exists {
+		return MessageReceiptsResponse{}, fmt.Errorf("%w %s on topic %s", ErrNoReceipts, messageID, topicName)
+	}
+	resp := MessageReceiptsResponse{
+		Topic:       topicName,
+		MessageID:   messageID,
+		Seq:         r.seq,
+		PublishedAt: r.publishedAt,
+		ExpiresAt:   r.recordedAt.Add(t.retention),
+		Expected:    len(r.expected),
+		Acked:       make([]Receipt, 0, len(r.acked)),
+		Pending:     make([]string, 0, len(r.expected)-len(r.acked)),
+	}
+	for clientID := range r.expected {
+		if ackedAt, ok := r.acked[clientID]; ok {
+			resp.Acked = append(resp.Acked, Receipt{ClientID: clientID, AckedAt: ackedAt})
+		} else {
+			resp.Pending = append(resp.Pending, clientID)
+		}
+	}
+	sort.Slice(resp.Acked, func(i, j int) bool { return resp.Acked[i].ClientID < resp.Acked[j].ClientID })
+	sort.Strings(resp.Pending)
+	return resp, nil
+}
+
+// record starts tracking the receipts of a published event, expecting an
+// ack from every explicit-ack subscriber. Callers must hold the topic lock.
+func (t *receiptTracker) record(topic *Topic, event EventResponse, publisher string) {
+	expected := make(map[string]bool)
+	for clientID, subscriber := range topic.Subscribers {
+		if subscriber.ack != nil {
+			expected[clientID] = true
+		}
+	}
+	r := &messageReceipts{
+		receiptKey:  receiptKey{topic.Name, event.Message.ID},
+		seq:         event.Seq,
+		publisher:   publisher,
+		publishedAt: event.Timestamp,
+		expected:    expected,
+		acked:       make(map[string]time.Time),
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	r.recordedAt = t.ps.clock.Now()
+	t.byKey[r.receiptKey] = r
+	t.order = append(t.order, r)
+	t.prune()
+}
+
+// acked records that clientID acked events, queueing a push to each
+// event's publisher
+func (t *receiptTracker) acked(clientID string, events []EventResponse) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.prune()
+
+	now := t.ps.clock.Now()
+	for _, event := range events {
+		r, exists := t.byKey[receiptKey{event.Topic, event.Message.ID}]
+		if !exists || r.seq != event.Seq || !r.expected[clientID] {
+			continue
+		}
+		if _, done := r.acked[clientID]; done {
+			continue
+		}
+		r.acked[clientID] = now
+		if r.publisher == "" {
+			continue
+		}
+		if len(t.pending[r.publisher]) == 0 {
+			publisher := r.publisher
+			t.ps.clock.AfterFunc(receiptFlushInterval, func() { t.flush(publisher) })
+		}
+		t.pending[r.publisher] = append(t.pending[r.publisher], ReceiptUpdate{
+			Topic:     r.topic,
+			MessageID: r.messageID,
+			ClientID:  clientID,
+			AckedAt:   now,
+			Acked:     len(r.acked),
+			Expected:  len(r.expected),
+		})
+	}
+}
+
+// flush pushes a publisher's collected acks in one receipt frame
+func (t *receiptTracker) flush(publisher string) {
+	t.mutex.Lock()
+	updates := t.pending[publisher]
+	delete(t.pending, publisher)
+	t.mutex.Unlock()
+	if len(updates) == 0 {
+		return
+	}
+
+	t.ps.clientMutex.RLock()
+	client, connected := t.ps.clients[publisher]
+	t.ps.clientMutex.RUnlock()
+	if !connected {
+		return
+	}
+	receipt := ReceiptResponse{Type: "receipt", Receipts: updates, Timestamp: t.ps.clock.Now()}
+	if err := client.SendMessage(receipt); err != nil {
+		log.Printf("Dropping %d receipts for client %s - %v", len(updates), publisher, err)
+	}
+}
+
+// prune drops receipts past the retention or over the count limit, oldest
+// first. Callers must hold the mutex.
+func (t *receiptTracker) prune() {
+	cutoff := t.ps.clock.Now().Add(-t.retention)
+	drop := 0
+	for drop < len(t.order) && (len(t.order)-drop > t.maxMessages || !t.order[drop].recordedAt.After(cutoff)) {
+		r := t.order[drop]
+		// A message ID published again has replaced this entry
+		if t.byKey[r.receiptKey] == r {
+			delete(t.byKey, r.receiptKey)
+		}
+		t.order[drop] = nil
+		drop++
+	}
+	t.order = t.order[drop:]
+}
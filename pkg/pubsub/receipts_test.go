@@ -0,0 +1,104 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// receipts returns the receipt frames sent to the client
+func (c *recordingClient) receipts() []ReceiptResponse {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var receipts []ReceiptResponse
+	for _, msg := range c.messages {
+		if receipt, ok := msg.(ReceiptResponse); ok {
+			receipts = append(receipts, receipt)
+		}
+	}
+	return receipts
+}
+
+func TestMessageReceipts(t *testing.T) {
+	ps, clock := fakeClockSystem()
+	defer ps.Close()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "announcements"); err != nil {
+		t.Fatal(err)
+	}
+	publisher := &recordingClient{id: "publisher"}
+	ps.RegisterClient(publisher)
+	for _, id := range []string{"a", "b", "c"} {
+		client := &recordingClient{id: id}
+		if _, err := ps.SubscribeWithOptions(ctx, id, "announcements", SubscribeOptions{AckMode: AckModeExplicit}, client); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Auto-ack subscribers aren't expected to ack
+	subscribeClient(t, ps, "announcements", &recordingClient{id: "watcher"})
+
+	if _, err := ps.PublishWithResult(ctx, "announcements", MessageData{ID: "m1"}, "publisher", PublishOptions{Receipts: true}); err != nil {
+		t.Fatal(err)
+	}
+	publishN(t, ps, "announcements", 1)
+
+	// a and b ack, c never does
+	for _, id := range []string{"a", "b"} {
+		if _, err := ps.AckMessages(ctx, id, "announcements", 0, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receipts, err := ps.MessageReceipts("announcements", "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipts.Expected != 3 || len(receipts.Acked) != 2 || receipts.Acked[0].ClientID != "a" || receipts.Acked[1].ClientID != "b" ||
+		fmt.Sprint(receipts.Pending) != "[c]" || receipts.Seq != 1 {
+		t.Errorf("receipts = %+v", receipts)
+	}
+
+	// Both acks reach the publisher in one batch, once the flush interval
+	// has passed
+	if pushed := publisher.receipts(); len(pushed) != 0 {
+		t.Fatalf("receipts pushed before the flush interval: %+v", pushed)
+	}
+	clock.Advance(receiptFlushInterval)
+	waitFor(t, "the receipt push", func() bool { return len(publisher.receipts()) == 1 })
+	clock.Advance(receiptFlushInterval)
+	time.Sleep(10 * time.Millisecond)
+	if pushed := publisher.receipts(); len(pushed) != 1 || len(pushed[0].Receipts) != 2 || pushed[0].Receipts[1].Acked != 2 || pushed[0].Receipts[1].Expected != 3 {
+		t.Errorf("pushed receipts = %+v", pushed)
+	}
+
+	// Messages published without receipts have none, and receipts expire
+	if _, err := ps.MessageReceipts("announcements", "m0"); !errors.Is(err, ErrNoReceipts) {
+		t.Errorf("receipts of an untracked message: %v", err)
+	}
+	clock.Advance(DefaultReceiptRetention)
+	if _, err := ps.MessageReceipts("announcements", "m1"); !errors.Is(err, ErrNoReceipts) {
+		t.Errorf("receipts after the retention: %v", err)
+	}
+}
+
+func TestMessageReceiptsBounded(t *testing.T) {
+	ps := New()
+	defer ps.Close()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "announcements"); err != nil {
+		t.Fatal(err)
+	}
+	ps.SetReceiptPolicy(time.Hour, 3)
+	for i := 0; i < 5; i++ {
+		if _, err := ps.PublishWithResult(ctx, "announcements", MessageData{ID: fmt.Sprint(i)}, "", PublishOptions{Receipts: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		_, err := ps.MessageReceipts("announcements", fmt.Sprint(i))
+		if kept := i >= 2; kept != (err == nil) {
+			t.Errorf("message %d: %v", i, err)
+		}
+	}
+}
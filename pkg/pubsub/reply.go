@@ -0,0 +1,116 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/AnshulDekate/pubsub/pkg/clock"
+)
+
+const (
+	// InboxPrefix starts the client IDs of request inboxes, which take one
+	// reply each
+	InboxPrefix = "_inbox."
+
+	// MaxCorrelationLength is the longest reply_to or correlation_id a
+	// message may carry
+	MaxCorrelationLength = 256
+
+	// DefaultReplyTimeout is how long a request waits for its reply by
+	// default
+	DefaultReplyTimeout = 5 * time.Second
+
+	// MaxReplyTimeout is the longest a request may wait for its reply
+	MaxReplyTimeout = time.Minute
+)
+
+// ErrRequestTimeout is returned when no reply arrives in time
+var ErrRequestTimeout = errors.New("no reply before the timeout")
+
+// PendingReply is a published request waiting for its reply
+type PendingReply struct {
+	ps            *PubSubSystem
+	Inbox         string // The request's reply_to
+	CorrelationID string
+	replies       <-chan EventResponse
+	deadline      clock.Timer
+}
+
+// Request publishes a message with a fresh inbox as its reply_to, for Wait
+// to collect the first message sent to the inbox. A correlation ID is
+// generated when opts has none. timeout counts from the publish; 0 is
+// DefaultReplyTimeout.
+func (ps *PubSubSystem) Request(ctx context.Context, topicName string, message MessageData, senderClientID string, opts PublishOptions, timeout time.Duration) (*PendingReply, error) {
+	if timeout <= 0 {
+		timeout = DefaultReplyTimeout
+	}
+	if timeout > MaxReplyTimeout {
+		return nil, errorOf(ErrInvalidRequest, "timeout is longer than %s", MaxReplyTimeout)
+	}
+	if opts.CorrelationID == "" {
+		opts.CorrelationID = uuid.NewString()
+	}
+
+	inbox, replies := ps.openInbox()
+	opts.ReplyTo = inbox
+	if err := ps.PublishWithOptions(ctx, topicName, message, senderClientID, opts); err != nil {
+		ps.closeInbox(inbox)
+		return nil, err
+	}
+	return &PendingReply{
+		ps:            ps,
+		Inbox:         inbox,
+		CorrelationID: opts.CorrelationID,
+		replies:       replies,
+		deadline:      ps.clock.NewTimer(timeout),
+	}, nil
+}
+
+// Wait returns the reply, or ErrRequestTimeout once the timeout passes.
+// The inbox is removed either way, so callers must Wait exactly once.
+func (p *PendingReply) Wait(ctx context.Context) (EventResponse, error) {
+	defer p.ps.closeInbox(p.Inbox)
+	defer p.deadline.Stop()
+	select {
+	case reply := <-p.replies:
+		return reply, nil
+	case <-p.deadline.C():
+		return EventResponse{}, ErrRequestTimeout
+	case <-ctx.Done():
+		return EventResponse{}, ctx.Err()
+	}
+}
+
+// openInbox registers an inbox for one reply
+func (ps *PubSubSystem) openInbox() (string, <-chan EventResponse) {
+	inbox := InboxPrefix + uuid.NewString()
+	replies := make(chan EventResponse, 1)
+	ps.direct.mutex.Lock()
+	ps.direct.replies[inbox] = replies
+	ps.direct.mutex.Unlock()
+	return inbox, replies
+}
+
+// closeInbox removes an inbox; later replies to it find nobody waiting
+func (ps *PubSubSystem) closeInbox(inbox string) {
+	ps.direct.mutex.Lock()
+	delete(ps.direct.replies, inbox)
+	ps.direct.mutex.Unlock()
+}
+
+// deliverReply hands an event to a waiting inbox. Only the first reply is
+// taken; it reports false for later ones and for unknown inboxes.
+func (ps *PubSubSystem) deliverReply(inbox string, event EventResponse) bool {
+	ps.direct.mutex.Lock()
+	defer ps.direct.mutex.Unlock()
+	replies, exists := ps.direct.replies[inbox]
+	if !exists {
+		return false
+	}
+	delete(ps.direct.replies, inbox)
+	replies <- event
+	return true
+}
@@ -0,0 +1,78 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// echoClient answers every event that asks for a reply with its payload
+type echoClient struct {
+	recordingClient
+	ps *PubSubSystem
+}
+
+func (c *echoClient) SendMessage(msg interface{}) error {
+	if prepared, ok := msg.(*PreparedEvent); ok && prepared.Event.ReplyTo != "" {
+		event := prepared.Event
+		go c.ps.SendDirect(context.Background(), c.id, event.ReplyTo, MessageData{ID: "re-" + event.Message.ID, Payload: event.Message.Payload}, DirectOptions{CorrelationID: event.CorrelationID})
+	}
+	return c.recordingClient.SendMessage(msg)
+}
+
+func TestRequestReply(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "echo"); err != nil {
+		t.Fatal(err)
+	}
+	echo := &echoClient{recordingClient: recordingClient{id: "echo"}, ps: ps}
+	subscribeClient(t, ps, "echo", echo)
+
+	pending, err := ps.Request(ctx, "echo", MessageData{ID: "q1", Payload: "ping"}, "asker", PublishOptions{CorrelationID: "c-1"}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := pending.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Message.Payload != "ping" || reply.CorrelationID != "c-1" || reply.From != "echo" {
+		t.Errorf("reply = %+v", reply)
+	}
+
+	// The request carried its inbox and correlation ID, and the inbox is
+	// gone once answered
+	request := echo.received()[0]
+	if request.ReplyTo != pending.Inbox || request.CorrelationID != "c-1" {
+		t.Errorf("request event = %+v", request)
+	}
+	if status, _ := ps.SendDirect(ctx, "echo", pending.Inbox, MessageData{ID: "late"}, DirectOptions{}); status != DirectOffline {
+		t.Errorf("late reply status = %s, want offline", status)
+	}
+}
+
+func TestRequestTimesOut(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "silent"); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := ps.Request(ctx, "silent", MessageData{ID: "q1"}, "asker", PublishOptions{}, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending.CorrelationID == "" {
+		t.Error("no correlation ID was generated")
+	}
+	if _, err := pending.Wait(ctx); !errors.Is(err, ErrRequestTimeout) {
+		t.Errorf("Wait = %v, want a timeout", err)
+	}
+	ps.direct.mutex.Lock()
+	defer ps.direct.mutex.Unlock()
+	if len(ps.direct.replies) != 0 {
+		t.Errorf("%d inboxes left open", len(ps.direct.replies))
+	}
+}
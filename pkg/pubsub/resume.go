@@ -0,0 +1,182 @@
+package pubsub
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultSessionRetention is how long a disconnected client's resume token
+// stays valid, renewed while the client_id owns a topic or is a member of
+// a private one
+const DefaultSessionRetention = 10 * time.Minute
+
+// resumeToken is the secret a client_id's next connection must present
+type resumeToken struct {
+	token string
+
+	// When the token lapses; zero while a connection holds the client_id
+	expiresAt time.Time
+}
+
+// resumeTokens holds the current resume token of each client_id. Tokens
+// are secrets: they are never logged or reported in stats.
+type resumeTokens struct {
+	mutex     sync.Mutex
+	byClient  map[string]*resumeToken
+	retention time.Duration
+}
+
+// SetSessionRetention changes how long a disconnected client's resume
+// token stays valid; zero or less restores the default. Tokens already
+// counting down keep their expiry.
+func (ps *PubSubSystem) SetSessionRetention(retention time.Duration) {
+	if retention <= 0 {
+		retention = DefaultSessionRetention
+	}
+	ps.resume.mutex.Lock()
+	defer ps.resume.mutex.Unlock()
+	ps.resume.retention = retention
+}
+
+// IssueResumeToken returns a new resume token for clientID, replacing any
+// earlier one. Transports call it each time a connection is bound to a
+// client_id, so tokens rotate on every reconnect.
+func (ps *PubSubSystem) IssueResumeToken(clientID string) string {
+	token := newResumeToken()
+	ps.resume.mutex.Lock()
+	defer ps.resume.mutex.Unlock()
+	ps.resume.byClient[clientID] = &resumeToken{token: token}
+	return token
+}
+
+// CheckResumeToken reports whether a connection presenting token may take
+// clientID: either no token is held for it or token matches. A token
+// found expired is dropped along with the direct messages held for the
+// client_id, so whoever takes it next starts afresh, unless the client_id
+// still holds grants.
+func (ps *PubSubSystem) CheckResumeToken(clientID, token string) bool {
+	ps.resume.mutex.Lock()
+	held, exists := ps.resume.byClient[clientID]
+	expired := exists && ps.resumeExpired(held)
+	ps.resume.mutex.Unlock()
+
+	if expired && !ps.retainResumeToken(clientID, held) {
+		exists = false
+	}
+	return !exists || subtle.ConstantTimeCompare([]byte(token), []byte(held.token)) == 1
+}
+
+// ReleaseResumeToken starts the retention countdown of clientID's token
+// once its connection drops. token is the one issued to that connection,
+// so a connection that has since been taken over can't cut short its
+// successor's token.
+func (ps *PubSubSystem) ReleaseResumeToken(clientID, token string) {
+	ps.resume.mutex.Lock()
+	defer ps.resume.mutex.Unlock()
+	if held, exists := ps.resume.byClient[clientID]; exists && held.token == token {
+		held.expiresAt = ps.clock.Now().Add(ps.resume.retention)
+	}
+}
+
+// DiscardResumeToken drops clientID's token if it is still token, when a
+// connection moves on to another client_id. A client_id holding grants
+// keeps its token, counting down as if its connection had dropped.
+func (ps *PubSubSystem) DiscardResumeToken(clientID, token string) {
+	granted := ps.holdsGrants(clientID)
+	ps.resume.mutex.Lock()
+	defer ps.resume.mutex.Unlock()
+	if held, exists := ps.resume.byClient[clientID]; exists && held.token == token {
+		if granted {
+			held.expiresAt = ps.clock.Now().Add(ps.resume.retention)
+		} else {
+			delete(ps.resume.byClient, clientID)
+		}
+	}
+}
+
+// SweepResumeTokens drops the tokens past their retention and the direct
+// messages held for them, returning how many were dropped
+func (ps *PubSubSystem) SweepResumeTokens() int {
+	ps.resume.mutex.Lock()
+	expired := make(map[string]*resumeToken)
+	for clientID, held := range ps.resume.byClient {
+		if ps.resumeExpired(held) {
+			expired[clientID] = held
+		}
+	}
+	ps.resume.mutex.Unlock()
+
+	dropped := 0
+	for clientID, held := range expired {
+		if !ps.retainResumeToken(clientID, held) {
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// retainResumeToken settles an expired token. Topic ownership and private
+// memberships are keyed on the bare client_id, so the token of an ID still
+// holding either is kept for another retention rather than letting anyone
+// claim the ID. Otherwise the token is dropped, if not replaced meanwhile,
+// with the direct messages held for the ID. It reports whether the token
+// was kept.
+func (ps *PubSubSystem) retainResumeToken(clientID string, held *resumeToken) bool {
+	if ps.holdsGrants(clientID) {
+		ps.resume.mutex.Lock()
+		held.expiresAt = ps.clock.Now().Add(ps.resume.retention)
+		ps.resume.mutex.Unlock()
+		return true
+	}
+
+	ps.resume.mutex.Lock()
+	if ps.resume.byClient[clientID] == held {
+		delete(ps.resume.byClient, clientID)
+	}
+	ps.resume.mutex.Unlock()
+	ps.dropHeldDirect(clientID)
+	return false
+}
+
+// holdsGrants reports whether clientID owns a topic or is a member of a
+// private one
+func (ps *PubSubSystem) holdsGrants(clientID string) bool {
+	if clientID == "" {
+		return false
+	}
+	granted := false
+	ps.topics.each(func(topic *Topic) {
+		topic.mutex.RLock()
+		if topic.Owner == clientID || topic.Members[clientID] {
+			granted = true
+		}
+		topic.mutex.RUnlock()
+	})
+	return granted
+}
+
+// resumeExpired reports whether a released token is past its retention.
+// Called with ps.resume.mutex held.
+func (ps *PubSubSystem) resumeExpired(held *resumeToken) bool {
+	return !held.expiresAt.IsZero() && !ps.clock.Now().Before(held.expiresAt)
+}
+
+// dropHeldDirect discards the direct messages held for clientID
+func (ps *PubSubSystem) dropHeldDirect(clientID string) {
+	ps.direct.mutex.Lock()
+	defer ps.direct.mutex.Unlock()
+	delete(ps.direct.byClient, clientID)
+}
+
+// newResumeToken returns a random resume token
+func newResumeToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("reading random resume token: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
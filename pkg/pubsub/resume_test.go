@@ -0,0 +1,78 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestResumeTokensRotateAndExpire(t *testing.T) {
+	ps, clock := fakeClockSystem()
+	ps.SetSessionRetention(time.Minute)
+
+	if !ps.CheckResumeToken("alice", "") {
+		t.Fatal("a client_id without a token was refused")
+	}
+	first := ps.IssueResumeToken("alice")
+	second := ps.IssueResumeToken("alice")
+	if first == second || ps.CheckResumeToken("alice", first) || !ps.CheckResumeToken("alice", second) {
+		t.Fatal("issuing a token didn't replace the earlier one")
+	}
+
+	// A stale connection's release leaves the current token alone
+	ps.ReleaseResumeToken("alice", first)
+	clock.Advance(2 * time.Minute)
+	if ps.CheckResumeToken("alice", "") {
+		t.Fatal("the token of a connected client expired")
+	}
+
+	// Once released the token lasts the retention, and takes the direct
+	// messages held for alice with it
+	ps.ReleaseResumeToken("alice", second)
+	ps.holdDirect("alice", EventResponse{Type: "direct"})
+	clock.Advance(30 * time.Second)
+	ps.SweepResumeTokens()
+	if ps.CheckResumeToken("alice", "") {
+		t.Fatal("the token expired within the retention")
+	}
+	clock.Advance(30 * time.Second)
+	ps.SweepResumeTokens()
+	if !ps.CheckResumeToken("alice", "") {
+		t.Error("the token outlived the retention")
+	}
+	if held := ps.DeliverHeldDirect(&recordingClient{id: "alice"}); held != 0 {
+		t.Errorf("%d held messages outlived the token", held)
+	}
+}
+
+func TestResumeTokensOfGrantHoldersOutliveRetention(t *testing.T) {
+	ps, clock := fakeClockSystem()
+	ps.SetSessionRetention(time.Minute)
+	ctx := context.Background()
+	if err := ps.CreateTopicWithConfig(ctx, "board", TopicConfig{Owner: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// alice owns a topic, so nobody else may claim her ID
+	ps.ReleaseResumeToken("alice", ps.IssueResumeToken("alice"))
+	clock.Advance(2 * time.Minute)
+	if dropped := ps.SweepResumeTokens(); dropped != 0 || ps.CheckResumeToken("alice", "") {
+		t.Fatal("the token of a topic owner expired")
+	}
+
+	// Moving on to another ID keeps the token too
+	ps.DiscardResumeToken("alice", ps.IssueResumeToken("alice"))
+	clock.Advance(2 * time.Minute)
+	if ps.CheckResumeToken("alice", "") {
+		t.Fatal("the token of a topic owner was discarded")
+	}
+
+	// Once the topic is handed over the token lapses as usual
+	if err := ps.TransferTopic(ctx, "board", "", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * time.Minute)
+	if dropped := ps.SweepResumeTokens(); dropped != 1 || !ps.CheckResumeToken("alice", "") {
+		t.Error("the token outlived alice's ownership")
+	}
+}
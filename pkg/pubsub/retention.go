@@ -0,0 +1,82 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// How often topic histories are swept for events past their retention
+const retentionSweepInterval = 30 * time.Second
+
+// ErrInvalidRetention is returned for a negative retention period
+var ErrInvalidRetention = errors.New("invalid retention")
+
+// SetTopicRetention changes how long a topic keeps history. Events already
+// held that are older than the new period are dropped at once; zero keeps
+// history until it is overwritten.
+func (ps *PubSubSystem) SetTopicRetention(ctx context.Context, name string, retention time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := checkUserTopic(name); err != nil {
+		return err
+	}
+	if retention < 0 {
+		return fmt.Errorf("%w: %v is negative", ErrInvalidRetention, retention)
+	}
+
+	topic, exists := ps.topics.get(name)
+	if !exists {
+		return errorOf(ErrTopicNotFound, "topic %s not found", name)
+	}
+
+	topic.mutex.Lock()
+	topic.Retention = retention
+	expired := topic.MessageHistory.SetMaxAge(retention)
+	if ps.store != nil && expired > 0 {
+		ps.store.Rewrite(name, topic.MessageHistory.GetAll())
+	}
+	config := topic.config()
+	createdAt := topic.CreatedAt
+	topic.mutex.Unlock()
+
+	if ps.store != nil {
+		ps.store.TopicCreated(name, createdAt, config)
+	}
+	return nil
+}
+
+// SweepRetention drops events past their topic's retention from every
+// topic's history, and from the history files when persistence is on, so
+// quiet topics don't hold them until their next publish or read. Returns
+// the number of events removed.
+func (ps *PubSubSystem) SweepRetention() int {
+	removed := 0
+	ps.topics.each(func(topic *Topic) {
+		// Hold the topic lock so the history file is rewritten in order
+		// with concurrent appends
+		topic.mutex.Lock()
+		expired := topic.MessageHistory.Expire()
+		if ps.store != nil && expired > 0 && !IsSystemTopic(topic.Name) {
+			ps.store.Rewrite(topic.Name, topic.MessageHistory.GetAll())
+		}
+		topic.mutex.Unlock()
+		removed += expired
+	})
+	return removed
+}
+
+// retentionLoop sweeps expired history, resume tokens and the usage
+// counters of departed clients periodically
+func (ps *PubSubSystem) retentionLoop() {
+	ticker := ps.clock.NewTicker(retentionSweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C() {
+		ps.SweepRetention()
+		ps.SweepResumeTokens()
+		ps.SweepClientUsage()
+	}
+}
@@ -0,0 +1,752 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBufferClosed is returned by Push and PopWait once a buffer is closed
+var ErrBufferClosed = errors.New("ring buffer is closed")
+
+// RingBuffer implements a bounded circular buffer for message queuing
+// Drops oldest messages when capacity is exceeded (overflow handling)
+type RingBuffer[T any] struct {
+	buffer   []T
+	head     int  // Points to the next write position
+	tail     int  // Points to the oldest message
+	size     int  // Current number of messages
+	capacity int  // Maximum capacity
+	full     bool // Whether buffer is at capacity
+	closed   bool // Set by Close; no more pushes are accepted
+	mutex    sync.RWMutex
+
+	pushes        int64 // Messages pushed since creation
+	evictions     int64 // Messages overwritten to make room
+	highWatermark int   // Most messages held at once since the last reset
+
+	// Closed and cleared by Push and Close to wake PopWait callers
+	wake chan struct{}
+}
+
+// NewRingBuffer creates a new ring buffer with specified capacity
+func NewRingBuffer[T any](capacity int) *RingBuffer[T] {
+	return &RingBuffer[T]{
+		buffer:   make([]T, capacity),
+		capacity: capacity,
+	}
+}
+
+// Push adds a new message to the buffer
+// If at capacity, overwrites the oldest message
+// Returns ErrBufferClosed after Close
+func (rb *RingBuffer[T]) Push(message T) error {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	return rb.push(message)
+}
+
+// PushEvict is Push that also returns the oldest message when it had to be
+// overwritten to make room
+func (rb *RingBuffer[T]) PushEvict(message T) (evicted T, ok bool, err error) {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	if rb.full && !rb.closed {
+		evicted, ok = rb.buffer[rb.tail], true
+	}
+	if err := rb.push(message); err != nil {
+		var zero T
+		return zero, false, err
+	}
+	return evicted, ok, nil
+}
+
+// push adds a message and wakes waiters. Callers must hold the mutex.
+func (rb *RingBuffer[T]) push(message T) error {
+	if rb.closed {
+		return ErrBufferClosed
+	}
+	defer rb.signal()
+
+	rb.buffer[rb.head] = message
+	rb.head = (rb.head + 1) % rb.capacity
+
+	rb.pushes++
+	if rb.full {
+		// Buffer is full, advance tail to drop oldest message
+		rb.tail = (rb.tail + 1) % rb.capacity
+		rb.evictions++
+	} else {
+		// Buffer not full yet
+		rb.size++
+		if rb.size == rb.capacity {
+			rb.full = true
+		}
+		if rb.size > rb.highWatermark {
+			rb.highWatermark = rb.size
+		}
+	}
+	return nil
+}
+
+// signal wakes every PopWait caller. Callers must hold the mutex.
+func (rb *RingBuffer[T]) signal() {
+	if rb.wake != nil {
+		close(rb.wake)
+		rb.wake = nil
+	}
+}
+
+// Pop removes and returns the oldest message
+// Returns false if buffer is empty
+func (rb *RingBuffer[T]) Pop() (T, bool) {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	return rb.pop()
+}
+
+// pop removes the oldest message. Callers must hold the mutex.
+func (rb *RingBuffer[T]) pop() (T, bool) {
+	var zero T
+	if rb.size == 0 {
+		return zero, false
+	}
+
+	message := rb.buffer[rb.tail]
+	rb.buffer[rb.tail] = zero
+	rb.tail = (rb.tail + 1) % rb.capacity
+	rb.size--
+	rb.full = false
+
+	return message, true
+}
+
+// PopWait removes and returns the oldest message, blocking until one is
+// pushed, ctx is done or the buffer is closed. Messages left in a closed
+// buffer are still returned before ErrBufferClosed.
+func (rb *RingBuffer[T]) PopWait(ctx context.Context) (T, error) {
+	for {
+		rb.mutex.Lock()
+		if message, ok := rb.pop(); ok {
+			rb.mutex.Unlock()
+			return message, nil
+		}
+		if rb.closed {
+			rb.mutex.Unlock()
+			var zero T
+			return zero, ErrBufferClosed
+		}
+		if rb.wake == nil {
+			rb.wake = make(chan struct{})
+		}
+		wake := rb.wake
+		rb.mutex.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		}
+	}
+}
+
+// Close stops the buffer accepting pushes and releases PopWait callers
+func (rb *RingBuffer[T]) Close() {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	rb.closed = true
+	rb.signal()
+}
+
+// PopAll returns all messages in chronological order and clears the buffer
+func (rb *RingBuffer[T]) PopAll() []T {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	if rb.size == 0 {
+		return nil
+	}
+
+	messages := rb.copyRange(0, rb.size)
+	rb.dropOldest(rb.size)
+
+	return messages
+}
+
+// GetLastN returns the last N messages in chronological order without removing them
+func (rb *RingBuffer[T]) GetLastN(n int) []T {
+	rb.mutex.RLock()
+	defer rb.mutex.RUnlock()
+
+	if rb.size == 0 || n <= 0 {
+		return nil
+	}
+
+	// Determine how many messages to return
+	count := n
+	if count > rb.size {
+		count = rb.size
+	}
+
+	return rb.copyRange(rb.size-count, rb.size)
+}
+
+// GetAll returns every message in chronological order without removing them
+func (rb *RingBuffer[T]) GetAll() []T {
+	rb.mutex.RLock()
+	defer rb.mutex.RUnlock()
+
+	return rb.copyRange(0, rb.size)
+}
+
+// Peek returns the oldest message without removing it
+// Returns false if buffer is empty
+func (rb *RingBuffer[T]) Peek() (T, bool) {
+	return rb.At(0)
+}
+
+// PeekLast returns the newest message without removing it
+// Returns false if buffer is empty
+func (rb *RingBuffer[T]) PeekLast() (T, bool) {
+	rb.mutex.RLock()
+	defer rb.mutex.RUnlock()
+
+	if rb.size == 0 {
+		var zero T
+		return zero, false
+	}
+	return rb.buffer[(rb.tail+rb.size-1)%rb.capacity], true
+}
+
+// At returns the message at logical index i, where 0 is the oldest
+// Returns false if i is out of range
+func (rb *RingBuffer[T]) At(i int) (T, bool) {
+	rb.mutex.RLock()
+	defer rb.mutex.RUnlock()
+
+	if i < 0 || i >= rb.size {
+		var zero T
+		return zero, false
+	}
+	return rb.buffer[(rb.tail+i)%rb.capacity], true
+}
+
+// search returns the logical index (0 = oldest) of the first message for
+// which pred is true, assuming pred is false then true across the buffer.
+// Callers must hold the mutex.
+func (rb *RingBuffer[T]) search(pred func(T) bool) int {
+	return sort.Search(rb.size, func(i int) bool {
+		return pred(rb.buffer[(rb.tail+i)%rb.capacity])
+	})
+}
+
+// copyRange copies the messages between logical indexes [start, end).
+// Callers must hold the mutex.
+func (rb *RingBuffer[T]) copyRange(start, end int) []T {
+	if start >= end {
+		return nil
+	}
+
+	messages := make([]T, end-start)
+	for i := range messages {
+		messages[i] = rb.buffer[(rb.tail+start+i)%rb.capacity]
+	}
+
+	return messages
+}
+
+// appendRange appends the messages between logical indexes [start, end)
+// to dst. Callers must hold the mutex.
+func (rb *RingBuffer[T]) appendRange(dst []T, start, end int) []T {
+	for i := start; i < end; i++ {
+		dst = append(dst, rb.buffer[(rb.tail+i)%rb.capacity])
+	}
+	return dst
+}
+
+// removeAt removes the message at logical index i, moving newer messages
+// back to close the gap. Callers must hold the mutex.
+func (rb *RingBuffer[T]) removeAt(i int) {
+	for ; i < rb.size-1; i++ {
+		rb.buffer[(rb.tail+i)%rb.capacity] = rb.buffer[(rb.tail+i+1)%rb.capacity]
+	}
+	var zero T
+	rb.buffer[(rb.tail+rb.size-1)%rb.capacity] = zero
+	rb.head = (rb.head - 1 + rb.capacity) % rb.capacity
+	rb.size--
+	rb.full = false
+}
+
+// RemoveWhere removes every message for which pred is true, keeping the
+// rest in order, and returns the number removed
+func (rb *RingBuffer[T]) RemoveWhere(pred func(T) bool) int {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	return rb.removeWhere(pred)
+}
+
+// removeWhere is RemoveWhere for callers that already hold the mutex
+func (rb *RingBuffer[T]) removeWhere(pred func(T) bool) int {
+	kept := 0
+	for i := 0; i < rb.size; i++ {
+		message := rb.buffer[(rb.tail+i)%rb.capacity]
+		if pred(message) {
+			continue
+		}
+		rb.buffer[(rb.tail+kept)%rb.capacity] = message
+		kept++
+	}
+
+	var zero T
+	for i := kept; i < rb.size; i++ {
+		rb.buffer[(rb.tail+i)%rb.capacity] = zero
+	}
+	removed := rb.size - kept
+	rb.size = kept
+	rb.head = (rb.tail + kept) % rb.capacity
+	if removed > 0 {
+		rb.full = false
+	}
+	return removed
+}
+
+// dropOldest advances the tail past the n oldest messages.
+// Callers must hold the mutex.
+func (rb *RingBuffer[T]) dropOldest(n int) int {
+	var zero T
+	for i := 0; i < n; i++ {
+		rb.buffer[(rb.tail+i)%rb.capacity] = zero
+	}
+	rb.tail = (rb.tail + n) % rb.capacity
+	rb.size -= n
+	if n > 0 {
+		rb.full = false
+	}
+
+	return n
+}
+
+// Len returns the current number of messages in the buffer
+func (rb *RingBuffer[T]) Len() int {
+	rb.mutex.RLock()
+	defer rb.mutex.RUnlock()
+	return rb.size
+}
+
+// Cap returns the maximum number of messages the buffer can hold
+func (rb *RingBuffer[T]) Cap() int {
+	return rb.capacity
+}
+
+// Size returns the current number of messages in the buffer
+//
+// Deprecated: use Len.
+func (rb *RingBuffer[T]) Size() int {
+	return rb.Len()
+}
+
+// Capacity returns the maximum number of messages the buffer can hold
+//
+// Deprecated: use Cap.
+func (rb *RingBuffer[T]) Capacity() int {
+	return rb.Cap()
+}
+
+// IsFull returns true if the buffer is at capacity
+func (rb *RingBuffer[T]) IsFull() bool {
+	rb.mutex.RLock()
+	defer rb.mutex.RUnlock()
+	return rb.full
+}
+
+// RingBufferStats describes a buffer's occupancy and overflow
+type RingBufferStats struct {
+	Capacity      int   `json:"capacity"`
+	Size          int   `json:"size"`
+	Pushes        int64 `json:"pushes"`
+	Evictions     int64 `json:"evictions"`      // Messages overwritten to make room
+	HighWatermark int   `json:"high_watermark"` // Most messages held at once since the last reset
+}
+
+// Stats returns the buffer's counters. Pushes and evictions count from
+// creation and survive Clear.
+func (rb *RingBuffer[T]) Stats() RingBufferStats {
+	rb.mutex.RLock()
+	defer rb.mutex.RUnlock()
+	return RingBufferStats{
+		Capacity:      rb.capacity,
+		Size:          rb.size,
+		Pushes:        rb.pushes,
+		Evictions:     rb.evictions,
+		HighWatermark: rb.highWatermark,
+	}
+}
+
+// ResetHighWatermark starts the high watermark over from the current size
+func (rb *RingBuffer[T]) ResetHighWatermark() {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	rb.highWatermark = rb.size
+}
+
+// Clear empties the buffer and returns the number of messages removed
+func (rb *RingBuffer[T]) Clear() int {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	return rb.dropOldest(rb.size)
+}
+
+// EventBuffer is a RingBuffer of events, kept in sequence order, with
+// lookups by sequence number and timestamp. Topic history uses it.
+//
+// An optional max age expires events lazily: they are dropped on Push and
+// before every read, so expired events are never returned. Expire drops
+// them actively, so an idle buffer's memory is reclaimed too.
+type EventBuffer struct {
+	RingBuffer[EventResponse]
+
+	maxAge  atomic.Int64     // Nanoseconds; 0 keeps events until they are overwritten
+	now     func() time.Time // Clock used for expiry
+	expired int64            // Events dropped for their age
+	dropped int64            // Highest sequence number no longer held
+
+	// Compact key -> seq of its newest event, for compacted buffers; nil
+	// otherwise
+	keys map[string]int64
+}
+
+// NewEventBuffer creates an event buffer with the specified capacity
+func NewEventBuffer(capacity int) *EventBuffer {
+	return NewEventBufferWithMaxAge(capacity, 0, nil)
+}
+
+// NewEventBufferWithMaxAge creates an event buffer that expires events older
+// than maxAge. now is the clock used for expiry; nil means time.Now.
+func NewEventBufferWithMaxAge(capacity int, maxAge time.Duration, now func() time.Time) *EventBuffer {
+	if now == nil {
+		now = time.Now
+	}
+	eb := &EventBuffer{
+		RingBuffer: RingBuffer[EventResponse]{
+			buffer:   make([]EventResponse, capacity),
+			capacity: capacity,
+		},
+		now: now,
+	}
+	eb.maxAge.Store(int64(maxAge))
+	return eb
+}
+
+// MaxAge returns the buffer's retention period, 0 if unlimited
+func (eb *EventBuffer) MaxAge() time.Duration {
+	return time.Duration(eb.maxAge.Load())
+}
+
+// SetMaxAge changes the retention period, expiring events already held
+// that are older than the new one. Returns the number of events removed.
+func (eb *EventBuffer) SetMaxAge(maxAge time.Duration) int {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	eb.maxAge.Store(int64(maxAge))
+	return eb.expireLocked()
+}
+
+// Expire drops events older than the max age. Returns the number of
+// events removed.
+func (eb *EventBuffer) Expire() int {
+	if eb.maxAge.Load() <= 0 {
+		return 0
+	}
+
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+	return eb.expireLocked()
+}
+
+// Expired returns the number of events dropped for their age so far
+func (eb *EventBuffer) Expired() int64 {
+	eb.mutex.RLock()
+	defer eb.mutex.RUnlock()
+	return eb.expired
+}
+
+// Push adds an event, dropping the oldest one when full and any that
+// have expired
+func (eb *EventBuffer) Push(event EventResponse) error {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	if eb.closed {
+		return ErrBufferClosed
+	}
+	eb.supersedeLocked(event)
+	if eb.full {
+		eb.noteDropped(eb.buffer[eb.tail])
+	}
+	if err := eb.push(event); err != nil {
+		return err
+	}
+	eb.expireLocked()
+	return nil
+}
+
+// Clear empties the buffer and returns the number of events removed
+func (eb *EventBuffer) Clear() int {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	return eb.dropEvents(eb.size)
+}
+
+// GetLastN returns the last N unexpired events in chronological order
+func (eb *EventBuffer) GetLastN(n int) []EventResponse {
+	eb.Expire()
+	return eb.RingBuffer.GetLastN(n)
+}
+
+// GetAll returns every unexpired event in chronological order
+func (eb *EventBuffer) GetAll() []EventResponse {
+	eb.Expire()
+	return eb.RingBuffer.GetAll()
+}
+
+// Len returns the number of unexpired events in the buffer
+func (eb *EventBuffer) Len() int {
+	eb.Expire()
+	return eb.RingBuffer.Len()
+}
+
+// Stats returns the buffer's counters, its size counting unexpired events
+func (eb *EventBuffer) Stats() RingBufferStats {
+	eb.Expire()
+	return eb.RingBuffer.Stats()
+}
+
+// AppendAll appends every unexpired event to dst in chronological order
+func (eb *EventBuffer) AppendAll(dst []EventResponse) []EventResponse {
+	eb.Expire()
+
+	eb.mutex.RLock()
+	defer eb.mutex.RUnlock()
+	return eb.appendRange(dst, 0, eb.size)
+}
+
+// RemoveWhere removes every event for which pred is true, keeping the
+// rest in order. Removed events aren't counted as dropped, so replays
+// after them don't report a gap.
+func (eb *EventBuffer) RemoveWhere(pred func(EventResponse) bool) int {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	return eb.removeWhere(func(event EventResponse) bool {
+		if !pred(event) {
+			return false
+		}
+		eb.unindexLocked(event)
+		return true
+	})
+}
+
+// EvictOlderThan drops every event with a timestamp before t, keeping
+// newer events in order. Returns the number of events removed.
+func (eb *EventBuffer) EvictOlderThan(t time.Time) int {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	return eb.evictLocked(t)
+}
+
+// expireLocked is Expire for callers that already hold the mutex
+func (eb *EventBuffer) expireLocked() int {
+	maxAge := time.Duration(eb.maxAge.Load())
+	if maxAge <= 0 {
+		return 0
+	}
+	removed := eb.evictLocked(eb.now().Add(-maxAge))
+	eb.expired += int64(removed)
+	return removed
+}
+
+// evictLocked drops events with a timestamp before t. Callers must hold
+// the mutex.
+func (eb *EventBuffer) evictLocked(t time.Time) int {
+	return eb.dropEvents(eb.search(func(event EventResponse) bool {
+		return !event.Timestamp.Before(t)
+	}))
+}
+
+// dropEvents drops the n oldest events, remembering the newest of them.
+// Callers must hold the mutex.
+func (eb *EventBuffer) dropEvents(n int) int {
+	if n > 0 {
+		eb.noteDropped(eb.buffer[(eb.tail+n-1)%eb.capacity])
+	}
+	if eb.keys != nil {
+		for i := 0; i < n; i++ {
+			eb.unindexLocked(eb.buffer[(eb.tail+i)%eb.capacity])
+		}
+	}
+	return eb.dropOldest(n)
+}
+
+// noteDropped records that event is no longer held. Callers must hold the
+// mutex.
+func (eb *EventBuffer) noteDropped(event EventResponse) {
+	if event.Seq > eb.dropped {
+		eb.dropped = event.Seq
+	}
+	eb.unindexLocked(event)
+}
+
+// GetAfterSeq returns up to limit events with a sequence number greater
+// than seq, oldest first; limit <= 0 returns all of them. evicted reports
+// that events right after seq are no longer held, e.g. overwritten or
+// expired, so the result does not continue from seq.
+func (eb *EventBuffer) GetAfterSeq(seq int64, limit int) (events []EventResponse, evicted bool) {
+	eb.Expire()
+
+	eb.mutex.RLock()
+	defer eb.mutex.RUnlock()
+
+	start := eb.searchSeq(seq + 1)
+	end := eb.size
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+	return eb.copyRange(start, end), eb.evictedAfterLocked(seq)
+}
+
+// GetRange returns the events with a sequence number in (fromSeq, toSeq],
+// oldest first. evicted reports that events right after fromSeq are no
+// longer held, as for GetAfterSeq.
+func (eb *EventBuffer) GetRange(fromSeq, toSeq int64) (events []EventResponse, evicted bool) {
+	eb.Expire()
+
+	eb.mutex.RLock()
+	defer eb.mutex.RUnlock()
+
+	if toSeq <= fromSeq {
+		return nil, eb.evictedAfterLocked(fromSeq)
+	}
+	return eb.copyRange(eb.searchSeq(fromSeq+1), eb.searchSeq(toSeq+1)), eb.evictedAfterLocked(fromSeq)
+}
+
+// evictedAfterLocked reports whether the event after seq was dropped or
+// never held, while later ones were published. Callers must hold the
+// mutex.
+func (eb *EventBuffer) evictedAfterLocked(seq int64) bool {
+	if seq < eb.dropped {
+		return true
+	}
+	// History restored from disk may start past events this buffer never
+	// held
+	return eb.size > 0 && eb.buffer[eb.tail].Seq > seq+1
+}
+
+// RangeAfter returns up to n messages with a sequence number greater than seq,
+// oldest first, without removing them
+func (eb *EventBuffer) RangeAfter(seq int64, n int) []EventResponse {
+	if n <= 0 {
+		return nil
+	}
+	return eb.AppendAfterSeq(nil, seq, n)
+}
+
+// AppendAfterSeq is RangeAfter appending to dst, so callers can reuse a
+// slice
+func (eb *EventBuffer) AppendAfterSeq(dst []EventResponse, seq int64, n int) []EventResponse {
+	eb.Expire()
+
+	eb.mutex.RLock()
+	defer eb.mutex.RUnlock()
+
+	if eb.size == 0 || n <= 0 {
+		return dst
+	}
+
+	start := eb.searchSeq(seq + 1)
+	end := start + n
+	if end > eb.size {
+		end = eb.size
+	}
+
+	return eb.appendRange(dst, start, end)
+}
+
+// RangeBefore returns up to n of the newest messages with a sequence number
+// less than seq, oldest first, without removing them
+func (eb *EventBuffer) RangeBefore(seq int64, n int) []EventResponse {
+	eb.Expire()
+
+	eb.mutex.RLock()
+	defer eb.mutex.RUnlock()
+
+	if eb.size == 0 || n <= 0 {
+		return nil
+	}
+
+	end := eb.searchSeq(seq)
+	start := end - n
+	if start < 0 {
+		start = 0
+	}
+
+	return eb.copyRange(start, end)
+}
+
+// searchSeq returns the logical index (0 = oldest) of the first message whose
+// sequence number is at least seq. Callers must hold the mutex.
+func (eb *EventBuffer) searchSeq(seq int64) int {
+	return eb.search(func(event EventResponse) bool {
+		return event.Seq >= seq
+	})
+}
+
+// RemoveBeforeSeq drops every message with a sequence number less than seq,
+// keeping newer messages in order. Returns the number of messages removed.
+func (eb *EventBuffer) RemoveBeforeSeq(seq int64) int {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	return eb.dropEvents(eb.searchSeq(seq))
+}
+
+// Largest scratch event slice returned to the pool
+const maxPooledEvents = 4 * TopicHistoryBufferSize
+
+// eventScratch recycles the slices history reads copy events into on hot
+// paths: catch-up replay, filtered last_n and ack window refills
+var eventScratch = sync.Pool{
+	New: func() interface{} {
+		events := make([]EventResponse, 0, TopicHistoryBufferSize)
+		return &events
+	},
+}
+
+// acquireEvents returns an empty scratch slice. Events appended to it must
+// not be retained past releaseEvents; copy out any that are returned.
+func acquireEvents() *[]EventResponse {
+	return eventScratch.Get().(*[]EventResponse)
+}
+
+// releaseEvents returns a scratch slice to the pool. used is the slice as
+// filled, which may have been reallocated by append.
+func releaseEvents(scratch *[]EventResponse, used []EventResponse) {
+	// Drop references to payloads so pooled slices don't keep them alive
+	clear(used)
+	if cap(used) > maxPooledEvents {
+		return
+	}
+	*scratch = used[:0]
+	eventScratch.Put(scratch)
+}
@@ -0,0 +1,637 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// wrappedBuffer returns a buffer of capacity 10 holding sequence numbers
+// 6 to 15, so its oldest entry sits halfway through the backing array
+func wrappedBuffer() *EventBuffer {
+	rb := NewEventBuffer(10)
+	for seq := int64(1); seq <= 15; seq++ {
+		rb.Push(EventResponse{Seq: seq})
+	}
+	return rb
+}
+
+func seqs(events []EventResponse) []int64 {
+	out := make([]int64, len(events))
+	for i, event := range events {
+		out[i] = event.Seq
+	}
+	return out
+}
+
+func equalSeqs(got []EventResponse, want ...int64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i, event := range got {
+		if event.Seq != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRingBufferRangesAcrossWraparound(t *testing.T) {
+	rb := wrappedBuffer()
+
+	for _, tc := range []struct {
+		name string
+		got  []EventResponse
+		want []int64
+	}{
+		{"after the oldest", rb.RangeAfter(0, 4), []int64{6, 7, 8, 9}},
+		{"after, crossing the wrap", rb.RangeAfter(8, 5), []int64{9, 10, 11, 12, 13}},
+		{"after, past the newest", rb.RangeAfter(13, 5), []int64{14, 15}},
+		{"after the newest", rb.RangeAfter(15, 5), nil},
+		{"before the newest", rb.RangeBefore(16, 3), []int64{13, 14, 15}},
+		{"before, crossing the wrap", rb.RangeBefore(12, 4), []int64{8, 9, 10, 11}},
+		{"before, past the oldest", rb.RangeBefore(8, 5), []int64{6, 7}},
+		{"before an evicted sequence", rb.RangeBefore(3, 5), nil},
+	} {
+		if !equalSeqs(tc.got, tc.want...) {
+			t.Errorf("%s = %v, want %v", tc.name, seqs(tc.got), tc.want)
+		}
+	}
+}
+
+func TestGetTopicMessagesPagesAcrossWraparound(t *testing.T) {
+	ps := New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	total := TopicHistoryBufferSize + 25
+	publishN(t, ps, "orders", total)
+	oldest := int64(total - TopicHistoryBufferSize + 1)
+
+	// Paging forward from the start visits every held message once
+	var cursor int64
+	next := oldest
+	for {
+		page, more, err := ps.GetTopicMessages("orders", cursor, 0, 64, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, event := range page {
+			if event.Seq != next {
+				t.Fatalf("forward page has seq %d, want %d", event.Seq, next)
+			}
+			next++
+		}
+		if !more {
+			break
+		}
+		cursor = page[len(page)-1].Seq
+	}
+	if next != int64(total+1) {
+		t.Errorf("forward paging stopped before seq %d", next)
+	}
+
+	// So does paging backward from the newest, newest first
+	cursor = 0
+	next = int64(total)
+	for {
+		page, more, err := ps.GetTopicMessages("orders", 0, cursor, 64, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, event := range page {
+			if event.Seq != next {
+				t.Fatalf("backward page has seq %d, want %d", event.Seq, next)
+			}
+			next--
+		}
+		if !more {
+			break
+		}
+		cursor = page[len(page)-1].Seq
+	}
+	if next != oldest-1 {
+		t.Errorf("backward paging stopped after seq %d", next+1)
+	}
+}
+
+// checkRingBuffer runs the same operations on a RingBuffer[T] of capacity
+// 3, with value(i) making the i'th element and id(v) recovering i
+func checkRingBuffer[T any](t *testing.T, value func(int) T, id func(T) int) {
+	t.Helper()
+	ids := func(values []T) []int {
+		out := make([]int, len(values))
+		for i, v := range values {
+			out[i] = id(v)
+		}
+		return out
+	}
+	equal := func(got []T, want ...int) bool {
+		return fmt.Sprint(ids(got)) == fmt.Sprint(want)
+	}
+
+	rb := NewRingBuffer[T](3)
+	if _, ok := rb.Pop(); ok || rb.Size() != 0 || rb.IsFull() {
+		t.Fatal("new buffer isn't empty")
+	}
+	for i := 1; i <= 5; i++ {
+		rb.Push(value(i))
+	}
+	// The two oldest were overwritten
+	if rb.Size() != 3 || !rb.IsFull() || !equal(rb.GetLastN(2), 4, 5) || !equal(rb.GetLastN(10), 3, 4, 5) {
+		t.Errorf("after 5 pushes: size %d, full %v, last %v", rb.Size(), rb.IsFull(), ids(rb.GetLastN(10)))
+	}
+
+	if v, ok := rb.Pop(); !ok || id(v) != 3 {
+		t.Errorf("Pop = %v, %v", ids([]T{v}), ok)
+	}
+	if rb.IsFull() {
+		t.Error("full after a pop")
+	}
+	rb.Push(value(6))
+	if got := rb.PopAll(); !equal(got, 4, 5, 6) || rb.Size() != 0 {
+		t.Errorf("PopAll = %v, leaving %d", ids(got), rb.Size())
+	}
+	if _, ok := rb.Pop(); ok {
+		t.Error("Pop from an emptied buffer")
+	}
+
+	rb.Push(value(7))
+	rb.Push(value(8))
+	if n := rb.Clear(); n != 2 || rb.Size() != 0 || rb.GetLastN(1) != nil {
+		t.Errorf("Clear = %d, leaving %v", n, ids(rb.GetLastN(10)))
+	}
+}
+
+func TestRingBufferOfEvents(t *testing.T) {
+	checkRingBuffer(t,
+		func(i int) EventResponse { return EventResponse{Seq: int64(i)} },
+		func(e EventResponse) int { return int(e.Seq) })
+}
+
+func TestRingBufferOfFrames(t *testing.T) {
+	checkRingBuffer(t,
+		func(i int) []byte { return []byte(strconv.Itoa(i)) },
+		func(b []byte) int { n, _ := strconv.Atoi(string(b)); return n })
+}
+
+func BenchmarkRingBufferPushPopEvents(b *testing.B) {
+	rb := NewRingBuffer[EventResponse](ClientSendBufferSize)
+	event := EventResponse{Type: "event", Topic: "orders", Message: MessageData{ID: "m"}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		rb.Push(event)
+		rb.Pop()
+	}
+}
+
+func BenchmarkRingBufferPushPopFrames(b *testing.B) {
+	rb := NewRingBuffer[[]byte](ClientSendBufferSize)
+	frame := []byte(`{"type":"event","topic":"orders"}`)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		rb.Push(frame)
+		rb.Pop()
+	}
+}
+
+// Pushing into a full buffer overwrites the oldest, the hot path for a
+// topic's history
+func BenchmarkRingBufferPushFull(b *testing.B) {
+	rb := NewRingBuffer[EventResponse](TopicHistoryBufferSize)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		rb.Push(EventResponse{Seq: int64(i)})
+	}
+}
+
+func TestRingBufferAccessorsAcrossWraparound(t *testing.T) {
+	eb := wrappedBuffer()
+	rb := &eb.RingBuffer
+	if rb.Len() != 10 || rb.Cap() != 10 {
+		t.Fatalf("Len %d, Cap %d", rb.Len(), rb.Cap())
+	}
+
+	if e, ok := rb.Peek(); !ok || e.Seq != 6 {
+		t.Errorf("Peek = %d, %v", e.Seq, ok)
+	}
+	if e, ok := rb.PeekLast(); !ok || e.Seq != 15 {
+		t.Errorf("PeekLast = %d, %v", e.Seq, ok)
+	}
+	for i := 0; i < 10; i++ {
+		if e, ok := rb.At(i); !ok || e.Seq != int64(6+i) {
+			t.Errorf("At(%d) = %d, %v", i, e.Seq, ok)
+		}
+	}
+	for _, i := range []int{-1, 10} {
+		if _, ok := rb.At(i); ok {
+			t.Errorf("At(%d) found a message", i)
+		}
+	}
+
+	// GetAll copies without removing
+	all := rb.GetAll()
+	if !equalSeqs(all, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15) || rb.Len() != 10 {
+		t.Errorf("GetAll = %v, leaving %d", seqs(all), rb.Len())
+	}
+	all[0].Seq = 99
+	if e, _ := rb.Peek(); e.Seq != 6 {
+		t.Error("GetAll shares memory with the buffer")
+	}
+
+	// None of them work on an empty buffer
+	rb.Clear()
+	if _, ok := rb.Peek(); ok {
+		t.Error("Peek on an empty buffer")
+	}
+	if _, ok := rb.PeekLast(); ok {
+		t.Error("PeekLast on an empty buffer")
+	}
+	if _, ok := rb.At(0); ok || rb.GetAll() != nil {
+		t.Error("At or GetAll on an empty buffer")
+	}
+}
+
+func TestRingBufferRemoveWhereAcrossWraparound(t *testing.T) {
+	eb := wrappedBuffer()
+	removed := eb.RingBuffer.RemoveWhere(func(e EventResponse) bool { return e.Seq == 9 || e.Seq == 12 })
+	if removed != 2 || !equalSeqs(eb.GetAll(), 6, 7, 8, 10, 11, 13, 14, 15) {
+		t.Fatalf("removed %d, leaving %v", removed, seqs(eb.GetAll()))
+	}
+	if n := eb.RingBuffer.RemoveWhere(func(e EventResponse) bool { return e.Seq > 100 }); n != 0 {
+		t.Errorf("removed %d unmatched messages", n)
+	}
+
+	// The freed slots are reused before anything is overwritten
+	eb.Push(EventResponse{Seq: 16})
+	eb.Push(EventResponse{Seq: 17})
+	if !equalSeqs(eb.GetAll(), 6, 7, 8, 10, 11, 13, 14, 15, 16, 17) || !eb.IsFull() {
+		t.Errorf("after refilling = %v", seqs(eb.GetAll()))
+	}
+	eb.Push(EventResponse{Seq: 18})
+	if e, _ := eb.Peek(); e.Seq != 7 {
+		t.Errorf("oldest after overflowing = %d, want 7", e.Seq)
+	}
+}
+
+// agedBuffer returns a buffer of capacity 10 whose clock is at now, holding
+// events 6 to 15 published a second apart, wrapped like wrappedBuffer
+func agedBuffer(t *testing.T, maxAge time.Duration, now *time.Time) *EventBuffer {
+	t.Helper()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	*now = start
+	eb := NewEventBufferWithMaxAge(10, maxAge, func() time.Time { return *now })
+	for seq := int64(1); seq <= 15; seq++ {
+		*now = start.Add(time.Duration(seq) * time.Second)
+		eb.Push(EventResponse{Seq: seq, Timestamp: *now})
+	}
+	return eb
+}
+
+func TestEventBufferExpiresAcrossWraparound(t *testing.T) {
+	var now time.Time
+	eb := agedBuffer(t, time.Minute, &now)
+	if got := eb.GetAll(); !equalSeqs(got, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15) {
+		t.Fatalf("fresh events = %v", seqs(got))
+	}
+
+	// Events 6 to 11 are over a minute old; the cut falls past the wrap
+	now = time.Date(2024, 1, 1, 0, 1, 11, 1, time.UTC)
+	if got := eb.GetLastN(10); !equalSeqs(got, 12, 13, 14, 15) {
+		t.Errorf("after partial expiry = %v", seqs(got))
+	}
+	if n := eb.RingBuffer.Len(); n != 4 {
+		t.Errorf("holding %d after expiry", n)
+	}
+
+	// A push expires lazily too, and the new event is kept
+	now = time.Date(2024, 1, 1, 0, 1, 14, 1, time.UTC)
+	eb.Push(EventResponse{Seq: 16, Timestamp: now})
+	if got := eb.RingBuffer.GetAll(); !equalSeqs(got, 15, 16) {
+		t.Errorf("after a push = %v", seqs(got))
+	}
+}
+
+func TestEventBufferEntirelyExpired(t *testing.T) {
+	var now time.Time
+	eb := agedBuffer(t, time.Minute, &now)
+	now = now.Add(time.Hour)
+	if n := eb.Len(); n != 0 {
+		t.Errorf("Len of an expired buffer = %d", n)
+	}
+	if got := eb.GetLastN(5); got != nil {
+		t.Errorf("GetLastN of an expired buffer = %v", seqs(got))
+	}
+	if got := eb.RangeAfter(10, 5); got != nil {
+		t.Errorf("RangeAfter of an expired buffer = %v", seqs(got))
+	}
+
+	// The buffer still works afterwards
+	eb.Push(EventResponse{Seq: 16, Timestamp: now})
+	if got := eb.GetAll(); !equalSeqs(got, 16) {
+		t.Errorf("after expiring everything = %v", seqs(got))
+	}
+}
+
+func TestEventBufferEvictOlderThan(t *testing.T) {
+	var now time.Time
+	eb := agedBuffer(t, 0, &now)
+	cut := time.Date(2024, 1, 1, 0, 0, 13, 0, time.UTC)
+	if n := eb.EvictOlderThan(cut); n != 7 {
+		t.Errorf("EvictOlderThan removed %d, want 7", n)
+	}
+	if got := eb.GetAll(); !equalSeqs(got, 13, 14, 15) {
+		t.Errorf("after EvictOlderThan = %v", seqs(got))
+	}
+	// Without a max age nothing expires on its own
+	now = now.Add(time.Hour)
+	if eb.Len() != 3 {
+		t.Errorf("buffer without a max age expired events: len %d", eb.Len())
+	}
+}
+
+// seqRange is a GetAfterSeq or GetRange result
+type seqRange struct {
+	events  []EventResponse
+	evicted bool
+}
+
+func rangeOf(events []EventResponse, evicted bool) seqRange {
+	return seqRange{events, evicted}
+}
+
+func TestEventBufferSeqRangesAcrossWraparound(t *testing.T) {
+	eb := wrappedBuffer()
+
+	// Events 1 to 5 were overwritten, so only cursors from 5 on continue
+	for _, tc := range []struct {
+		name    string
+		got     seqRange
+		want    []int64
+		evicted bool
+	}{
+		{"after the last evicted", rangeOf(eb.GetAfterSeq(5, 0)), []int64{6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, false},
+		{"after an evicted sequence", rangeOf(eb.GetAfterSeq(2, 3)), []int64{6, 7, 8}, true},
+		{"from the start", rangeOf(eb.GetAfterSeq(0, 1)), []int64{6}, true},
+		{"crossing the wrap", rangeOf(eb.GetAfterSeq(8, 5)), []int64{9, 10, 11, 12, 13}, false},
+		{"limit past the newest", rangeOf(eb.GetAfterSeq(13, 5)), []int64{14, 15}, false},
+		{"after the newest", rangeOf(eb.GetAfterSeq(15, 5)), nil, false},
+		{"after a future sequence", rangeOf(eb.GetAfterSeq(20, 0)), nil, false},
+		{"range", rangeOf(eb.GetRange(5, 8)), []int64{6, 7, 8}, false},
+		{"range crossing the wrap", rangeOf(eb.GetRange(8, 12)), []int64{9, 10, 11, 12}, false},
+		{"range from an evicted sequence", rangeOf(eb.GetRange(2, 7)), []int64{6, 7}, true},
+		{"range past the newest", rangeOf(eb.GetRange(13, 30)), []int64{14, 15}, false},
+		{"range entirely evicted", rangeOf(eb.GetRange(1, 4)), nil, true},
+		{"empty range", rangeOf(eb.GetRange(10, 10)), nil, false},
+		{"reversed range", rangeOf(eb.GetRange(12, 9)), nil, false},
+	} {
+		if !equalSeqs(tc.got.events, tc.want...) || tc.got.evicted != tc.evicted {
+			t.Errorf("%s = %v, evicted %v; want %v, evicted %v", tc.name, seqs(tc.got.events), tc.got.evicted, tc.want, tc.evicted)
+		}
+	}
+
+	// Results are copies
+	events, _ := eb.GetAfterSeq(5, 1)
+	events[0].Seq = 99
+	if e, _ := eb.Peek(); e.Seq != 6 {
+		t.Error("GetAfterSeq shares memory with the buffer")
+	}
+}
+
+func TestEventBufferSeqRangesAfterEviction(t *testing.T) {
+	// Expired events are evicted like overwritten ones
+	var now time.Time
+	eb := agedBuffer(t, time.Minute, &now)
+	now = time.Date(2024, 1, 1, 0, 1, 9, 1, time.UTC)
+	if events, evicted := eb.GetAfterSeq(5, 0); !equalSeqs(events, 10, 11, 12, 13, 14, 15) || !evicted {
+		t.Errorf("after expiry from 5 = %v, evicted %v", seqs(events), evicted)
+	}
+	if events, evicted := eb.GetAfterSeq(9, 2); !equalSeqs(events, 10, 11) || evicted {
+		t.Errorf("after expiry from 9 = %v, evicted %v", seqs(events), evicted)
+	}
+
+	// So are events removed by sequence or cleared
+	eb = wrappedBuffer()
+	if n := eb.RemoveBeforeSeq(10); n != 4 {
+		t.Errorf("RemoveBeforeSeq removed %d", n)
+	}
+	if _, evicted := eb.GetRange(8, 12); !evicted {
+		t.Error("range over removed events not evicted")
+	}
+	if events, evicted := eb.GetRange(9, 12); !equalSeqs(events, 10, 11, 12) || evicted {
+		t.Errorf("range after the removed events = %v, evicted %v", seqs(events), evicted)
+	}
+	if n := eb.Clear(); n != 6 {
+		t.Errorf("Clear removed %d", n)
+	}
+	if _, evicted := eb.GetAfterSeq(14, 0); !evicted {
+		t.Error("cursor into cleared events not evicted")
+	}
+	if _, evicted := eb.GetAfterSeq(15, 0); evicted {
+		t.Error("cursor at the newest cleared event evicted")
+	}
+	eb.Push(EventResponse{Seq: 16})
+	if events, evicted := eb.GetAfterSeq(15, 0); !equalSeqs(events, 16) || evicted {
+		t.Errorf("after clearing and pushing = %v, evicted %v", seqs(events), evicted)
+	}
+
+	// A buffer restored from disk may start later than a cursor
+	eb = NewEventBuffer(10)
+	for seq := int64(50); seq <= 52; seq++ {
+		eb.Push(EventResponse{Seq: seq})
+	}
+	if events, evicted := eb.GetAfterSeq(40, 0); !equalSeqs(events, 50, 51, 52) || !evicted {
+		t.Errorf("before the restored history = %v, evicted %v", seqs(events), evicted)
+	}
+	if _, evicted := eb.GetAfterSeq(49, 0); evicted {
+		t.Error("cursor just before the restored history evicted")
+	}
+}
+
+// BenchmarkEventBufferGetAfterSeq reads the newest events of a full topic
+// history after a cursor, against copying the history and scanning it
+func BenchmarkEventBufferGetAfterSeq(b *testing.B) {
+	eb := NewEventBuffer(TopicHistoryBufferSize)
+	total := int64(TopicHistoryBufferSize + TopicHistoryBufferSize/2)
+	for seq := int64(1); seq <= total; seq++ {
+		eb.Push(EventResponse{Seq: seq})
+	}
+	cursor := total - 10
+
+	b.Run("binary search", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if events, _ := eb.GetAfterSeq(cursor, 10); len(events) != 10 {
+				b.Fatalf("got %d events", len(events))
+			}
+		}
+	})
+	b.Run("linear scan", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var events []EventResponse
+			for _, event := range eb.GetAll() {
+				if event.Seq > cursor && len(events) < 10 {
+					events = append(events, event)
+				}
+			}
+			if len(events) != 10 {
+				b.Fatalf("got %d events", len(events))
+			}
+		}
+	})
+}
+
+func TestPopWaitConcurrentProducersAndConsumers(t *testing.T) {
+	const producers, perProducer = 4, 500
+	// Large enough that nothing is overwritten, so every push is popped
+	rb := NewRingBuffer[int](producers * perProducer)
+
+	var consumed sync.WaitGroup
+	results := make(chan int, producers*perProducer)
+	for c := 0; c < 3; c++ {
+		consumed.Add(1)
+		go func() {
+			defer consumed.Done()
+			for {
+				v, err := rb.PopWait(context.Background())
+				if err != nil {
+					if !errors.Is(err, ErrBufferClosed) {
+						t.Errorf("PopWait: %v", err)
+					}
+					return
+				}
+				results <- v
+			}
+		}()
+	}
+
+	var produced sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		produced.Add(1)
+		go func(p int) {
+			defer produced.Done()
+			for i := 0; i < perProducer; i++ {
+				rb.Push(p*perProducer + i)
+			}
+		}(p)
+	}
+	produced.Wait()
+	rb.Close()
+	consumed.Wait()
+	close(results)
+
+	seen := make(map[int]bool)
+	for v := range results {
+		if seen[v] {
+			t.Fatalf("%d popped twice", v)
+		}
+		seen[v] = true
+	}
+	if len(seen) != producers*perProducer {
+		t.Errorf("popped %d of %d messages", len(seen), producers*perProducer)
+	}
+	if err := rb.Push(1); !errors.Is(err, ErrBufferClosed) {
+		t.Errorf("Push after Close = %v", err)
+	}
+}
+
+func TestPopWaitCanceled(t *testing.T) {
+	rb := NewRingBuffer[int](4)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := rb.PopWait(ctx)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("PopWait on an empty buffer returned %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("canceled PopWait = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("canceling did not release PopWait")
+	}
+
+	// A push wakes a waiter
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		rb.Push(7)
+	}()
+	if v, err := rb.PopWait(context.Background()); err != nil || v != 7 {
+		t.Errorf("PopWait = %d, %v", v, err)
+	}
+}
+
+func TestPopWaitClosedWhileWaiting(t *testing.T) {
+	rb := NewRingBuffer[int](4)
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := rb.PopWait(context.Background())
+			done <- err
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	rb.Close()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-done:
+			if !errors.Is(err, ErrBufferClosed) {
+				t.Errorf("PopWait after Close = %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Close did not release PopWait")
+		}
+	}
+
+	// Messages left in a closed buffer are still returned first
+	rb = NewRingBuffer[int](4)
+	rb.Push(1)
+	rb.Close()
+	if v, err := rb.PopWait(context.Background()); err != nil || v != 1 {
+		t.Errorf("PopWait on a closed buffer with a message = %d, %v", v, err)
+	}
+	if _, err := rb.PopWait(context.Background()); !errors.Is(err, ErrBufferClosed) {
+		t.Errorf("PopWait on a drained closed buffer = %v", err)
+	}
+}
+
+func TestRingBufferStatsAcrossOverflowAndClear(t *testing.T) {
+	rb := NewRingBuffer[int](3)
+	for i := 0; i < 5; i++ {
+		rb.Push(i)
+	}
+	if got, want := rb.Stats(), (RingBufferStats{Capacity: 3, Size: 3, Pushes: 5, Evictions: 2, HighWatermark: 3}); got != want {
+		t.Fatalf("after overflowing = %+v, want %+v", got, want)
+	}
+
+	// Clear empties the buffer but keeps the counts
+	rb.Pop()
+	rb.ResetHighWatermark()
+	if got := rb.Stats().HighWatermark; got != 2 {
+		t.Errorf("high watermark after a reset = %d, want the size 2", got)
+	}
+	if removed := rb.Clear(); removed != 2 {
+		t.Fatalf("Clear removed %d", removed)
+	}
+	rb.Push(5)
+	if got, want := rb.Stats(), (RingBufferStats{Capacity: 3, Size: 1, Pushes: 6, Evictions: 2, HighWatermark: 2}); got != want {
+		t.Errorf("after Clear = %+v, want %+v", got, want)
+	}
+
+	if got, want := wrappedBuffer().Stats(), (RingBufferStats{Capacity: 10, Size: 10, Pushes: 15, Evictions: 5, HighWatermark: 10}); got != want {
+		t.Errorf("event buffer = %+v, want %+v", got, want)
+	}
+}
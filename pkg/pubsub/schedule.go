@@ -0,0 +1,408 @@
+package pubsub
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultMaxScheduled is how many scheduled messages may be pending at
+	// once, across topics
+	DefaultMaxScheduled = 10000
+
+	// MaxScheduleDelay is the furthest ahead a message may be scheduled
+	MaxScheduleDelay = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrScheduleFull is returned when the pending schedule is at its limit
+	ErrScheduleFull = errors.New("too many scheduled messages")
+
+	// ErrInvalidSchedule is returned for a delivery time too far ahead
+	ErrInvalidSchedule = errors.New("invalid schedule")
+
+	// ErrScheduledNotFound is returned when cancelling a token that isn't
+	// pending: unknown, cancelled or already published
+	ErrScheduledNotFound = errors.New("scheduled message not found")
+)
+
+// ScheduledMessage is a publish held until its delivery time. At that time
+// it is published like any other message: it enters history and fan-out.
+type ScheduledMessage struct {
+	Token       string      `json:"token"` // Cancels the message until it is published
+	Topic       string      `json:"topic"`
+	Message     MessageData `json:"message"`
+	DeliverAt   time.Time   `json:"deliver_at"`
+	SenderID    string      `json:"sender_id,omitempty"`
+	OrderingKey string      `json:"ordering_key,omitempty"`
+	CompactKey  string      `json:"compact_key,omitempty"`
+	Ephemeral   bool        `json:"ephemeral,omitempty"`
+	Volatile    bool        `json:"volatile,omitempty"`
+
+	ReplyTo       string `json:"reply_to,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+// options returns the options the message is published with
+func (sm ScheduledMessage) options() PublishOptions {
+	return PublishOptions{
+		OrderingKey: sm.OrderingKey,
+		CompactKey:  sm.CompactKey,
+		Ephemeral:   sm.Ephemeral,
+		Volatile:    sm.Volatile,
+
+		ReplyTo:       sm.ReplyTo,
+		CorrelationID: sm.CorrelationID,
+	}
+}
+
+// scheduledEntry is a pending message's place in the schedule
+type scheduledEntry struct {
+	ScheduledMessage
+	order int64 // Breaks ties between messages due at the same time
+	index int   // Position in the queue
+}
+
+// scheduleQueue is a min-heap of pending messages, soonest first
+type scheduleQueue []*scheduledEntry
+
+func (q scheduleQueue) Len() int { return len(q) }
+
+func (q scheduleQueue) Less(i, j int) bool {
+	if !q[i].DeliverAt.Equal(q[j].DeliverAt) {
+		return q[i].DeliverAt.Before(q[j].DeliverAt)
+	}
+	return q[i].order < q[j].order
+}
+
+func (q scheduleQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *scheduleQueue) Push(x interface{}) {
+	entry := x.(*scheduledEntry)
+	entry.index = len(*q)
+	*q = append(*q, entry)
+}
+
+func (q *scheduleQueue) Pop() interface{} {
+	old := *q
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return entry
+}
+
+// scheduler holds every topic's pending messages and publishes them when
+// due, from one timer goroutine
+type scheduler struct {
+	ps  *PubSubSystem
+	max int
+
+	mutex   sync.Mutex
+	queue   scheduleQueue
+	byToken map[string]*scheduledEntry
+	byTopic map[string]map[string]*scheduledEntry // topic -> token -> entry
+	order   int64
+
+	// Signalled (non-blocking) when the soonest due time may have changed
+	wake     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// newScheduler creates an empty schedule on the system's clock
+func newScheduler(ps *PubSubSystem) *scheduler {
+	return &scheduler{
+		ps:      ps,
+		max:     DefaultMaxScheduled,
+		byToken: make(map[string]*scheduledEntry),
+		byTopic: make(map[string]map[string]*scheduledEntry),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// SchedulePublish validates a publish now and holds it until deliverAt,
+// when it is published with PublishWithOptions. A time already past is due
+// at once. The returned message's token cancels it until then.
+func (ps *PubSubSystem) SchedulePublish(ctx context.Context, topicName string, message MessageData, senderClientID string, opts PublishOptions, deliverAt time.Time) (ScheduledMessage, error) {
+	if _, _, err := ps.checkPublish(ctx, topicName, message, opts); err != nil {
+		return ScheduledMessage{}, err
+	}
+	if deliverAt.After(ps.clock.Now().Add(MaxScheduleDelay)) {
+		return ScheduledMessage{}, fmt.Errorf("%w: delivery is more than %s away", ErrInvalidSchedule, MaxScheduleDelay)
+	}
+
+	sm := ScheduledMessage{
+		Token:       uuid.NewString(),
+		Topic:       topicName,
+		Message:     message,
+		DeliverAt:   deliverAt,
+		SenderID:    senderClientID,
+		OrderingKey: opts.OrderingKey,
+		CompactKey:  opts.CompactKey,
+		Ephemeral:   opts.Ephemeral,
+		Volatile:    opts.Volatile,
+
+		ReplyTo:       opts.ReplyTo,
+		CorrelationID: opts.CorrelationID,
+	}
+	if err := ps.scheduler.add(sm, true); err != nil {
+		return ScheduledMessage{}, err
+	}
+	return sm, nil
+}
+
+// CancelScheduled cancels a pending scheduled message by its token and
+// returns it
+func (ps *PubSubSystem) CancelScheduled(token string) (ScheduledMessage, error) {
+	return ps.scheduler.cancel(token)
+}
+
+// SetMaxScheduled configures how many scheduled messages may be pending at
+// once. Non-positive values keep the default.
+func (ps *PubSubSystem) SetMaxScheduled(limit int) {
+	if limit <= 0 {
+		limit = DefaultMaxScheduled
+	}
+	ps.scheduler.mutex.Lock()
+	defer ps.scheduler.mutex.Unlock()
+	ps.scheduler.max = limit
+}
+
+// add queues a message. Restored messages skip the limit, so a restart
+// never loses what was pending.
+func (s *scheduler) add(sm ScheduledMessage, limited bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.byToken[sm.Token]; exists {
+		return fmt.Errorf("%w: token %s is already pending", ErrInvalidSchedule, sm.Token)
+	}
+	if limited && len(s.queue) >= s.max {
+		return fmt.Errorf("%w: %d are pending", ErrScheduleFull, s.max)
+	}
+
+	s.insert(sm)
+	s.persist(sm.Topic)
+	s.signal()
+	return nil
+}
+
+// restore queues restored messages that aren't already pending, past the
+// limit, and returns how many were queued
+func (s *scheduler) restore(list []ScheduledMessage) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	touched := make(map[string]bool)
+	restored := 0
+	for _, sm := range list {
+		if _, exists := s.byToken[sm.Token]; exists {
+			continue
+		}
+		s.insert(sm)
+		touched[sm.Topic] = true
+		restored++
+	}
+	for topic := range touched {
+		s.persist(topic)
+	}
+	s.signal()
+	return restored
+}
+
+// insert queues and indexes a message. Callers must hold the mutex.
+func (s *scheduler) insert(sm ScheduledMessage) {
+	s.order++
+	entry := &scheduledEntry{ScheduledMessage: sm, order: s.order}
+	heap.Push(&s.queue, entry)
+	s.byToken[sm.Token] = entry
+	if s.byTopic[sm.Topic] == nil {
+		s.byTopic[sm.Topic] = make(map[string]*scheduledEntry)
+	}
+	s.byTopic[sm.Topic][sm.Token] = entry
+}
+
+// cancel removes a pending message
+func (s *scheduler) cancel(token string) (ScheduledMessage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, exists := s.byToken[token]
+	if !exists {
+		return ScheduledMessage{}, ErrScheduledNotFound
+	}
+	heap.Remove(&s.queue, entry.index)
+	s.forget(entry)
+	s.persist(entry.Topic)
+	s.signal()
+	return entry.ScheduledMessage, nil
+}
+
+// dropTopic removes a deleted topic's pending messages, returning how many
+// there were. The topic's files are deleted with it, so nothing is
+// persisted.
+func (s *scheduler) dropTopic(name string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries := s.byTopic[name]
+	for _, entry := range entries {
+		heap.Remove(&s.queue, entry.index)
+		s.forget(entry)
+	}
+	if len(entries) > 0 {
+		log.Printf("Dropped %d scheduled messages of deleted topic %s", len(entries), name)
+	}
+	return len(entries)
+}
+
+// takeDue removes and returns the messages due by now, soonest first
+func (s *scheduler) takeDue() []ScheduledMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.ps.clock.Now()
+	var due []ScheduledMessage
+	touched := make(map[string]bool)
+	for len(s.queue) > 0 && !s.queue[0].DeliverAt.After(now) {
+		entry := heap.Pop(&s.queue).(*scheduledEntry)
+		s.forget(entry)
+		due = append(due, entry.ScheduledMessage)
+		touched[entry.Topic] = true
+	}
+	for topic := range touched {
+		s.persist(topic)
+	}
+	return due
+}
+
+// publishDue publishes every message that is due. A message whose publish
+// fails, e.g. because its topic was archived meanwhile, is dropped.
+// Returns the number of messages taken from the schedule.
+func (s *scheduler) publishDue() int {
+	due := s.takeDue()
+	for _, sm := range due {
+		if err := s.ps.PublishWithOptions(context.Background(), sm.Topic, sm.Message, sm.SenderID, sm.options()); err != nil {
+			log.Printf("Dropping scheduled message %s for topic %s: %v", sm.Token, sm.Topic, err)
+		}
+	}
+	return len(due)
+}
+
+// forget removes an entry from the indexes. Callers must hold the mutex
+// and have removed it from the queue.
+func (s *scheduler) forget(entry *scheduledEntry) {
+	delete(s.byToken, entry.Token)
+	delete(s.byTopic[entry.Topic], entry.Token)
+	if len(s.byTopic[entry.Topic]) == 0 {
+		delete(s.byTopic, entry.Topic)
+	}
+}
+
+// persist records a topic's pending messages in the history store, when
+// persistence is on. Callers must hold the mutex, which keeps the writes
+// in order.
+func (s *scheduler) persist(topic string) {
+	if s.ps.store != nil {
+		s.ps.store.Scheduled(topic, s.topicList(topic))
+	}
+}
+
+// topicList returns a topic's pending messages, soonest first. Callers
+// must hold the mutex.
+func (s *scheduler) topicList(topic string) []ScheduledMessage {
+	entries := make([]*scheduledEntry, 0, len(s.byTopic[topic]))
+	for _, entry := range s.byTopic[topic] {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return scheduleQueue(entries).Less(i, j) })
+	list := make([]ScheduledMessage, len(entries))
+	for i, entry := range entries {
+		list[i] = entry.ScheduledMessage
+	}
+	return list
+}
+
+// forTopic returns a topic's pending messages, soonest first
+func (s *scheduler) forTopic(topic string) []ScheduledMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.topicList(topic)
+}
+
+// pending returns the number of pending messages per topic
+func (s *scheduler) pending() map[string]int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	counts := make(map[string]int, len(s.byTopic))
+	for topic, entries := range s.byTopic {
+		counts[topic] = len(entries)
+	}
+	return counts
+}
+
+// signal wakes the timer goroutine. Callers must hold the mutex.
+func (s *scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// untilNext returns how long until the soonest pending message is due,
+// and false when nothing is pending
+func (s *scheduler) untilNext() (time.Duration, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.queue) == 0 {
+		return 0, false
+	}
+	return s.queue[0].DeliverAt.Sub(s.ps.clock.Now()), true
+}
+
+// run publishes messages as they fall due until stop
+func (s *scheduler) run() {
+	timer := s.ps.clock.NewTimer(0)
+	defer timer.Stop()
+	for {
+		s.publishDue()
+
+		// Wait for the soonest message, or for a change to the schedule
+		if !timer.Stop() {
+			select {
+			case <-timer.C():
+			default:
+			}
+		}
+		var due <-chan time.Time
+		if wait, ok := s.untilNext(); ok {
+			timer.Reset(max(wait, 0))
+			due = timer.C()
+		}
+		select {
+		case <-due:
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// stop ends the timer goroutine; pending messages stay in the schedule
+func (s *scheduler) stop() {
+	s.stopOnce.Do(func() { close(s.done) })
+}
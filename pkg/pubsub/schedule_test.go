@@ -0,0 +1,174 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AnshulDekate/pubsub/pkg/clock/clocktest"
+)
+
+// fakeClockSystem returns a system on a fake clock starting at the real
+// time
+func fakeClockSystem() (*PubSubSystem, *clocktest.Fake) {
+	clock := clocktest.NewFake(time.Now())
+	return NewWithClock(clock), clock
+}
+
+// schedule holds a message for topic until delay after the clock's time
+func schedule(t *testing.T, ps *PubSubSystem, clock *clocktest.Fake, topic, id string, delay time.Duration) ScheduledMessage {
+	t.Helper()
+	sm, err := ps.SchedulePublish(context.Background(), topic, MessageData{ID: id, Payload: id}, "", PublishOptions{}, clock.Now().Add(delay))
+	if err != nil {
+		t.Fatalf("SchedulePublish: %v", err)
+	}
+	return sm
+}
+
+func TestScheduledPublishDeliversWhenDue(t *testing.T) {
+	ps, clock := fakeClockSystem()
+	defer ps.Close()
+	if err := ps.CreateTopic(context.Background(), "meetings"); err != nil {
+		t.Fatal(err)
+	}
+	client := &recordingClient{id: "c1"}
+	subscribeClient(t, ps, "meetings", client)
+
+	schedule(t, ps, clock, "meetings", "later", 2*time.Minute)
+	schedule(t, ps, clock, "meetings", "sooner", time.Minute)
+	if pending := ps.GetStats().Topics["meetings"].Scheduled; pending != 2 {
+		t.Errorf("scheduled = %d, want 2", pending)
+	}
+
+	clock.Advance(90 * time.Second)
+	events := client.waitEvents(t, 1)
+	if events[0].Message.ID != "sooner" || events[0].Seq != 1 {
+		t.Errorf("first event = %s seq %d, want sooner seq 1", events[0].Message.ID, events[0].Seq)
+	}
+	clock.Advance(time.Minute)
+	if events := client.waitEvents(t, 2); events[1].Message.ID != "later" {
+		t.Errorf("second event = %s, want later", events[1].Message.ID)
+	}
+
+	// Delivered messages are published like any other
+	history, _, err := ps.GetTopicMessages("meetings", 0, 0, 10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(messageIDs(history)); got != "[sooner later]" {
+		t.Errorf("history = %s", got)
+	}
+	if pending := ps.GetStats().Topics["meetings"].Scheduled; pending != 0 {
+		t.Errorf("scheduled = %d after delivery", pending)
+	}
+
+	// Publishes are validated when they are scheduled
+	if _, err := ps.SchedulePublish(context.Background(), "missing", MessageData{ID: "x"}, "", PublishOptions{}, clock.Now()); err == nil {
+		t.Error("scheduled a publish to a missing topic")
+	}
+	if _, err := ps.SchedulePublish(context.Background(), "meetings", MessageData{ID: "x"}, "", PublishOptions{}, clock.Now().Add(MaxScheduleDelay+time.Hour)); !errors.Is(err, ErrInvalidSchedule) {
+		t.Errorf("scheduling past the furthest delay: %v", err)
+	}
+}
+
+func TestCancelScheduledPublish(t *testing.T) {
+	ps, clock := fakeClockSystem()
+	defer ps.Close()
+	ps.SetMaxScheduled(1)
+	if err := ps.CreateTopic(context.Background(), "meetings"); err != nil {
+		t.Fatal(err)
+	}
+	client := &recordingClient{id: "c1"}
+	subscribeClient(t, ps, "meetings", client)
+
+	sm := schedule(t, ps, clock, "meetings", "cancelled", time.Minute)
+	if _, err := ps.SchedulePublish(context.Background(), "meetings", MessageData{ID: "x"}, "", PublishOptions{}, clock.Now()); !errors.Is(err, ErrScheduleFull) {
+		t.Errorf("scheduling past the limit: %v", err)
+	}
+	if cancelled, err := ps.CancelScheduled(sm.Token); err != nil || cancelled.Message.ID != "cancelled" {
+		t.Fatalf("CancelScheduled = %+v, %v", cancelled, err)
+	}
+	if _, err := ps.CancelScheduled(sm.Token); !errors.Is(err, ErrScheduledNotFound) {
+		t.Errorf("second cancel: %v", err)
+	}
+
+	// Cancelling makes room, and the cancelled message never arrives
+	schedule(t, ps, clock, "meetings", "kept", 2*time.Minute)
+	clock.Advance(3 * time.Minute)
+	if events := client.waitEvents(t, 1); len(events) != 1 || events[0].Message.ID != "kept" {
+		t.Errorf("events = %v, want only kept", messageIDs(events))
+	}
+}
+
+func TestDeletingTopicDropsItsSchedule(t *testing.T) {
+	ps, clock := fakeClockSystem()
+	defer ps.Close()
+	ctx := context.Background()
+	for _, name := range []string{"meetings", "reminders"} {
+		if err := ps.CreateTopic(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dropped := schedule(t, ps, clock, "meetings", "dropped", time.Minute)
+	schedule(t, ps, clock, "reminders", "kept", time.Minute)
+
+	if err := ps.DeleteTopic(ctx, "meetings"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.CancelScheduled(dropped.Token); !errors.Is(err, ErrScheduledNotFound) {
+		t.Errorf("cancelling a deleted topic's message: %v", err)
+	}
+
+	// A topic created again under the name doesn't inherit the schedule
+	if err := ps.CreateTopic(ctx, "meetings"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * time.Minute)
+	waitFor(t, "the reminder", func() bool { return ps.GetStats().Topics["reminders"].Messages == 1 })
+	stats := ps.GetStats()
+	if stats.Topics["meetings"].Messages != 0 || stats.Topics["meetings"].Scheduled != 0 {
+		t.Errorf("recreated topic stats = %+v", stats.Topics["meetings"])
+	}
+}
+
+func TestScheduleSurvivesRestartAndSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	ps := openStore(t, dir)
+	clock := clocktest.NewFake(time.Now())
+	if err := ps.CreateTopic(context.Background(), "meetings"); err != nil {
+		t.Fatal(err)
+	}
+	sm := schedule(t, ps, clock, "meetings", "pending", time.Hour)
+	schedule(t, ps, clock, "meetings", "kept", 2*time.Hour)
+	if _, err := ps.CancelScheduled(schedule(t, ps, clock, "meetings", "cancelled", time.Hour).Token); err != nil {
+		t.Fatal(err)
+	}
+	ps.Close()
+	if _, err := os.Stat(filepath.Join(dir, "meetings"+scheduleSuffix)); err != nil {
+		t.Fatalf("schedule file: %v", err)
+	}
+
+	ps = openStore(t, dir)
+	defer ps.Close()
+	if pending := ps.GetStats().Topics["meetings"].Scheduled; pending != 2 {
+		t.Fatalf("restored scheduled = %d, want 2", pending)
+	}
+
+	// A snapshot carries the schedule to another system
+	snapshot := ps.Snapshot()
+	other := New()
+	defer other.Close()
+	if _, err := other.RestoreSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if pending := other.GetStats().Topics["meetings"].Scheduled; pending != 2 {
+		t.Errorf("scheduled after restoring the snapshot = %d, want 2", pending)
+	}
+	if cancelled, err := other.CancelScheduled(sm.Token); err != nil || !cancelled.DeliverAt.Equal(sm.DeliverAt) {
+		t.Errorf("CancelScheduled = %+v, %v", cancelled, err)
+	}
+}
@@ -0,0 +1,151 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// maxSchemaErrors is how many validation errors a rejection reports
+const maxSchemaErrors = 5
+
+// ErrInvalidSchema is returned for a topic schema that can't be compiled
+var ErrInvalidSchema = errors.New("invalid schema")
+
+// TopicSchema is a compiled JSON Schema that published payloads must match
+type TopicSchema struct {
+	raw      json.RawMessage
+	compiled *jsonschema.Schema
+}
+
+// SchemaValidationError lists why a payload was rejected
+type SchemaValidationError struct {
+	Topic  string
+	Errors []string // At most maxSchemaErrors entries
+}
+
+func (e *SchemaValidationError) Error() string {
+	return fmt.Sprintf("payload does not match schema of topic %s: %s", e.Topic, strings.Join(e.Errors, "; "))
+}
+
+// CompileTopicSchema compiles a JSON Schema document. References to other
+// documents are not followed. Returns nil for an empty or null schema.
+func CompileTopicSchema(raw json.RawMessage) (*TopicSchema, error) {
+	if len(bytes.TrimSpace(raw)) == 0 || string(bytes.TrimSpace(raw)) == "null" {
+		return nil, nil
+	}
+
+	const url = "mem:///topic-schema.json"
+	compiler := jsonschema.NewCompiler()
+	compiler.LoadURL = func(s string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("external schema references are not allowed: %s", s)
+	}
+	if err := compiler.AddResource(url, bytes.NewReader(raw)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+	compiled, err := compiler.Compile(url)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+
+	return &TopicSchema{raw: append(json.RawMessage(nil), raw...), compiled: compiled}, nil
+}
+
+// Raw returns the schema document as it was supplied
+func (s *TopicSchema) Raw() json.RawMessage {
+	if s == nil {
+		return nil
+	}
+	return s.raw
+}
+
+// Validate checks a payload against the schema. A nil schema accepts
+// anything.
+func (s *TopicSchema) Validate(topic string, payload interface{}) error {
+	if s == nil {
+		return nil
+	}
+
+	// The validator needs plain JSON values; payloads decoded from
+	// MessagePack or published in-process as Go values are converted first
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return &SchemaValidationError{Topic: topic, Errors: []string{"payload is not JSON-encodable: " + err.Error()}}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return &SchemaValidationError{Topic: topic, Errors: []string{err.Error()}}
+	}
+
+	err = s.compiled.Validate(value)
+	if err == nil {
+		return nil
+	}
+
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return &SchemaValidationError{Topic: topic, Errors: []string{err.Error()}}
+	}
+
+	// Leaf errors carry the specific reasons; the wrappers above them
+	// only say "doesn't validate with ..."
+	var reasons []string
+	var collect func(*jsonschema.ValidationError)
+	collect = func(e *jsonschema.ValidationError) {
+		if len(reasons) >= maxSchemaErrors {
+			return
+		}
+		if len(e.Causes) == 0 {
+			location := e.InstanceLocation
+			if location == "" {
+				location = "/"
+			}
+			reasons = append(reasons, location+": "+e.Message)
+			return
+		}
+		for _, cause := range e.Causes {
+			collect(cause)
+		}
+	}
+	collect(ve)
+	return &SchemaValidationError{Topic: topic, Errors: reasons}
+}
+
+// SetTopicSchema replaces a topic's schema. An empty or null schema removes
+// it. Publishes already validated against the old schema are unaffected.
+func (ps *PubSubSystem) SetTopicSchema(ctx context.Context, name string, raw json.RawMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := checkUserTopic(name); err != nil {
+		return err
+	}
+
+	topic, exists := ps.topics.get(name)
+	if !exists {
+		return errorOf(ErrTopicNotFound, "topic %s not found", name)
+	}
+	schema, err := CompileTopicSchema(raw)
+	if err != nil {
+		return err
+	}
+
+	topic.mutex.Lock()
+	topic.Schema = schema
+	config := topic.config()
+	createdAt := topic.CreatedAt
+	topic.mutex.Unlock()
+
+	if ps.store != nil {
+		ps.store.TopicCreated(name, createdAt, config)
+	}
+	return nil
+}
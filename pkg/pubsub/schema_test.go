@@ -0,0 +1,179 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const orderSchema = `{
+	"type": "object",
+	"required": ["id", "amount"],
+	"properties": {
+		"id": {"type": "string"},
+		"amount": {"type": "number", "minimum": 0},
+		"note": {"type": "string"}
+	}
+}`
+
+func TestTopicSchemaValidation(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopicWithConfig(ctx, "orders", TopicConfig{Schema: json.RawMessage(orderSchema)}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, payload := range []interface{}{
+		map[string]interface{}{"id": "o1", "amount": 10},
+		map[string]interface{}{"id": "o2", "amount": 0.5, "note": "gift"},
+		// Go values published in-process are validated as their JSON
+		struct {
+			ID     string  `json:"id"`
+			Amount float64 `json:"amount"`
+		}{"o3", 3},
+	} {
+		if err := ps.Publish(ctx, "orders", MessageData{ID: "ok", Payload: payload}, ""); err != nil {
+			t.Errorf("valid payload %#v rejected: %v", payload, err)
+		}
+	}
+
+	for name, tc := range map[string]struct {
+		payload interface{}
+		reason  string
+	}{
+		"missing required field": {map[string]interface{}{"id": "o1"}, "amount"},
+		"wrong type":             {map[string]interface{}{"id": 7, "amount": 1}, "/id"},
+		"below minimum":          {map[string]interface{}{"id": "o1", "amount": -1}, "/amount"},
+		"not an object":          {"o1", "/"},
+		"null":                   {nil, "/"},
+	} {
+		err := ps.Publish(ctx, "orders", MessageData{ID: "bad", Payload: tc.payload}, "")
+		var schemaErr *SchemaValidationError
+		if !errors.As(err, &schemaErr) {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(schemaErr.Errors) == 0 || !strings.Contains(strings.Join(schemaErr.Errors, "\n"), tc.reason) {
+			t.Errorf("%s: reported as %+v", name, schemaErr)
+		}
+	}
+
+	// Only valid payloads were stored
+	if got := len(history(t, ps, "orders")); got != 3 {
+		t.Errorf("%d events in history, want 3", got)
+	}
+}
+
+func TestTopicSchemaReportsFirstErrors(t *testing.T) {
+	schema, err := CompileTopicSchema(json.RawMessage(`{"type": "object", "properties": {
+		"a": {"type": "string"}, "b": {"type": "string"}, "c": {"type": "string"}, "d": {"type": "string"},
+		"e": {"type": "string"}, "f": {"type": "string"}, "g": {"type": "string"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := map[string]interface{}{"a": 1, "b": 1, "c": 1, "d": 1, "e": 1, "f": 1, "g": 1}
+	var schemaErr *SchemaValidationError
+	if err := schema.Validate("t", payload); !errors.As(err, &schemaErr) || len(schemaErr.Errors) != maxSchemaErrors {
+		t.Errorf("seven violations reported as %v", err)
+	}
+}
+
+func TestInvalidTopicSchemasRejected(t *testing.T) {
+	for name, raw := range map[string]string{
+		"not JSON":           `{"type":`,
+		"unknown type":       `{"type": "decimal"}`,
+		"bad keyword value":  `{"minimum": "zero"}`,
+		"external reference": `{"$ref": "https://example.com/schema.json"}`,
+	} {
+		if _, err := CompileTopicSchema(json.RawMessage(raw)); !errors.Is(err, ErrInvalidSchema) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	for _, raw := range []string{"", " ", "null"} {
+		if schema, err := CompileTopicSchema(json.RawMessage(raw)); schema != nil || err != nil {
+			t.Errorf("%q = %v, %v, want no schema", raw, schema, err)
+		}
+	}
+}
+
+func TestTopicSchemaUpdate(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopicWithConfig(ctx, "orders", TopicConfig{Schema: json.RawMessage(orderSchema)}); err != nil {
+		t.Fatal(err)
+	}
+	publish := func(payload map[string]interface{}) error {
+		return ps.Publish(ctx, "orders", MessageData{ID: "m", Payload: payload}, "")
+	}
+	withoutNote := map[string]interface{}{"id": "o1", "amount": 1}
+	if err := publish(withoutNote); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new rules make note required; what was published stays
+	stricter := strings.Replace(orderSchema, `["id", "amount"]`, `["id", "amount", "note"]`, 1)
+	if err := ps.SetTopicSchema(ctx, "orders", json.RawMessage(stricter)); err != nil {
+		t.Fatal(err)
+	}
+	if err := publish(withoutNote); !isSchemaError(err) {
+		t.Errorf("publishing without a note under the new schema = %v", err)
+	}
+	if err := publish(map[string]interface{}{"id": "o2", "amount": 1, "note": "n"}); err != nil {
+		t.Errorf("publishing with a note = %v", err)
+	}
+	if got := len(history(t, ps, "orders")); got != 2 {
+		t.Errorf("%d events in history, want 2", got)
+	}
+	detail, err := ps.GetTopicDetail("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(detail.Schema) != stricter {
+		t.Errorf("topic reports schema %s", detail.Schema)
+	}
+
+	// A schema that doesn't compile leaves the current one in place
+	if err := ps.SetTopicSchema(ctx, "orders", json.RawMessage(`{"type": 5}`)); !errors.Is(err, ErrInvalidSchema) {
+		t.Errorf("setting an invalid schema = %v", err)
+	}
+	if err := publish(withoutNote); !isSchemaError(err) {
+		t.Errorf("invalid schema update replaced the schema: %v", err)
+	}
+
+	// Null removes it
+	if err := ps.SetTopicSchema(ctx, "orders", json.RawMessage("null")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Publish(ctx, "orders", MessageData{ID: "m", Payload: "anything"}, ""); err != nil {
+		t.Errorf("publishing with the schema removed = %v", err)
+	}
+	if err := ps.SetTopicSchema(ctx, "missing", json.RawMessage(orderSchema)); err == nil {
+		t.Errorf("setting the schema of a missing topic = %v", err)
+	}
+}
+
+// BenchmarkPublishWithSchema publishes to a topic with a schema, which is
+// compiled once when set rather than for each message
+func BenchmarkPublishWithSchema(b *testing.B) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopicWithConfig(ctx, "orders", TopicConfig{Schema: json.RawMessage(orderSchema)}); err != nil {
+		b.Fatal(err)
+	}
+	msg := MessageData{ID: "m", Payload: map[string]interface{}{"id": "o1", "amount": 10, "note": "n"}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := ps.Publish(ctx, "orders", msg, ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// isSchemaError reports whether err rejected a payload for its schema
+func isSchemaError(err error) bool {
+	var schemaErr *SchemaValidationError
+	return errors.As(err, &schemaErr)
+}
@@ -0,0 +1,212 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Signing keys for the tests, by key ID
+var (
+	testKeyV1 = []byte("0123456789abcdef-v1")
+	testKeyV2 = []byte("0123456789abcdef-v2")
+)
+
+// signingSystem returns a system with both test keys and an "orders"
+// topic signed with v1
+func signingSystem(t *testing.T) *PubSubSystem {
+	t.Helper()
+	ps := New()
+	if err := ps.SetSigningKeys(map[string][]byte{"v1": testKeyV1, "v2": testKeyV2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateTopicWithConfig(context.Background(), "orders", TopicConfig{SigningKeyID: "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	return ps
+}
+
+func TestParseSigningKeys(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(testKeyV1)
+	keys, err := ParseSigningKeys(" v1:" + encoded + ", v2:" + base64.StdEncoding.EncodeToString(testKeyV2) + ",")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || string(keys["v1"]) != string(testKeyV1) || string(keys["v2"]) != string(testKeyV2) {
+		t.Errorf("parsed %q", keys)
+	}
+	for _, value := range []string{"v1", ":" + encoded, "v1:not base64!", "v1:" + encoded + ",v1:" + encoded} {
+		if _, err := ParseSigningKeys(value); err == nil {
+			t.Errorf("ParseSigningKeys(%q) succeeded", value)
+		}
+	}
+
+	ps := New()
+	for name, keys := range map[string]map[string][]byte{
+		"empty key ID": {"": testKeyV1},
+		"short key":    {"v1": []byte("short")},
+	} {
+		if err := ps.SetSigningKeys(keys); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+	if err := ps.CreateTopicWithConfig(context.Background(), "orders", TopicConfig{SigningKeyID: "v1"}); !errors.Is(err, ErrUnknownSigningKey) {
+		t.Errorf("signing with a key the server doesn't have: %v", err)
+	}
+}
+
+func TestSignedEventsVerify(t *testing.T) {
+	ps := signingSystem(t)
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "audit"); err != nil {
+		t.Fatal(err)
+	}
+	client := &recordingClient{id: "verifier"}
+	subscribeClient(t, ps, "orders", client)
+	msg := MessageData{ID: "o1", Payload: map[string]interface{}{"amount": 42, "items": []interface{}{"a", "b"}}}
+	if err := ps.Publish(ctx, "orders", msg, "publisher"); err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string][]byte{"v1": testKeyV1}
+
+	// Delivered and replayed events carry the same signature
+	live := client.waitEvents(t, 1)[0]
+	stored := history(t, ps, "orders")[0]
+	if live.Signature == nil || live.Signature.KeyID != "v1" || live.Signature.Algorithm != SignatureAlgorithm {
+		t.Fatalf("live event signature = %+v", live.Signature)
+	}
+	if stored.Signature == nil || *stored.Signature != *live.Signature {
+		t.Errorf("history signature = %+v, want %+v", stored.Signature, live.Signature)
+	}
+	for name, event := range map[string]EventResponse{"live": live, "history": stored} {
+		if err := VerifyEvent(event, keys); err != nil {
+			t.Errorf("%s event: %v", name, err)
+		}
+	}
+
+	// Any change to a signed field is detected
+	for name, mutate := range map[string]func(*EventResponse){
+		"payload": func(e *EventResponse) {
+			e.Message.Payload = map[string]interface{}{"amount": 43, "items": []interface{}{"a", "b"}}
+		},
+		"id":        func(e *EventResponse) { e.Message.ID = "o2" },
+		"topic":     func(e *EventResponse) { e.Topic = "audit" },
+		"seq":       func(e *EventResponse) { e.Seq++ },
+		"timestamp": func(e *EventResponse) { e.Timestamp = e.Timestamp.Add(time.Nanosecond) },
+		"signature": func(e *EventResponse) {
+			sig := *e.Signature
+			sig.Value = base64.StdEncoding.EncodeToString([]byte(strings.Repeat("x", 32)))
+			e.Signature = &sig
+		},
+	} {
+		event := live
+		mutate(&event)
+		if err := VerifyEvent(event, keys); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("changed %s: %v", name, err)
+		}
+	}
+	if err := VerifyEvent(live, map[string][]byte{"v1": testKeyV2}); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("verified with the wrong key: %v", err)
+	}
+	if err := VerifyEvent(live, map[string][]byte{"v2": testKeyV2}); !errors.Is(err, ErrUnknownSigningKey) {
+		t.Errorf("verified without the key: %v", err)
+	}
+
+	// Topics without a key publish unsigned events
+	publishN(t, ps, "audit", 1)
+	if err := VerifyEvent(history(t, ps, "audit")[0], keys); !errors.Is(err, ErrUnsignedEvent) {
+		t.Errorf("unsigned topic's event: %v", err)
+	}
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	ps := signingSystem(t)
+	ctx := context.Background()
+	keys := map[string][]byte{"v1": testKeyV1, "v2": testKeyV2}
+	publishN(t, ps, "orders", 1)
+
+	// Events after the switch are signed with the new key; those before
+	// still verify with the old one
+	if err := ps.SetSigningKey(ctx, "orders", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	publishN(t, ps, "orders", 1)
+	events := history(t, ps, "orders")
+	for i, keyID := range []string{"v1", "v2"} {
+		if events[i].Signature.KeyID != keyID {
+			t.Errorf("event %d signed with %s, want %s", i, events[i].Signature.KeyID, keyID)
+		}
+		if err := VerifyEvent(events[i], keys); err != nil {
+			t.Errorf("event %d: %v", i, err)
+		}
+	}
+	detail, _ := ps.GetTopicDetail("orders")
+	if !detail.Signed || detail.SigningKeyID != "v2" {
+		t.Errorf("detail = %+v", detail)
+	}
+
+	// A topic left with a retired key refuses to publish unsigned events
+	if err := ps.SetSigningKeys(map[string][]byte{"v1": testKeyV1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Publish(ctx, "orders", MessageData{ID: "m", Payload: 1}, ""); !errors.Is(err, ErrUnknownSigningKey) {
+		t.Errorf("publishing with a retired key: %v", err)
+	}
+	if err := ps.SetSigningKey(ctx, "orders", "v2"); !errors.Is(err, ErrUnknownSigningKey) {
+		t.Errorf("switching to a retired key: %v", err)
+	}
+
+	// Turning signing off keeps the earlier signatures
+	if err := ps.SetSigningKey(ctx, "orders", ""); err != nil {
+		t.Fatal(err)
+	}
+	publishN(t, ps, "orders", 1)
+	events = history(t, ps, "orders")
+	if len(events) != 3 || events[1].Signature == nil || events[2].Signature != nil {
+		t.Errorf("history after turning signing off = %+v", events)
+	}
+	if detail, _ := ps.GetTopicDetail("orders"); detail.Signed {
+		t.Errorf("detail = %+v", detail)
+	}
+	if err := ps.SetSigningKey(ctx, "missing", "v1"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("signing an unknown topic: %v", err)
+	}
+}
+
+func TestSignaturesSurviveRestore(t *testing.T) {
+	dir := t.TempDir()
+	ps := openStore(t, dir)
+	if err := ps.SetSigningKeys(map[string][]byte{"v1": testKeyV1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateTopicWithConfig(context.Background(), "orders", TopicConfig{SigningKeyID: "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	publishN(t, ps, "orders", 2)
+	snapshot := ps.Snapshot()
+	ps.Close()
+
+	ps = openStore(t, dir)
+	defer ps.Close()
+	restored := New()
+	if _, err := restored.RestoreSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	for name, system := range map[string]*PubSubSystem{"history store": ps, "snapshot": restored} {
+		events := history(t, system, "orders")
+		if len(events) != 2 {
+			t.Fatalf("%s: restored %d events", name, len(events))
+		}
+		for _, event := range events {
+			if err := VerifyEvent(event, map[string][]byte{"v1": testKeyV1}); err != nil {
+				t.Errorf("%s: seq %d: %v", name, event.Seq, err)
+			}
+		}
+		if detail, _ := system.GetTopicDetail("orders"); detail.SigningKeyID != "v1" {
+			t.Errorf("%s: detail = %+v", name, detail)
+		}
+	}
+}
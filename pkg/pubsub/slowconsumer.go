@@ -0,0 +1,229 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// ReasonSlowConsumer is the reason of the "unsubscribed" notice sent when a
+// subscription is dropped for losing too many events
+const ReasonSlowConsumer = "slow_consumer"
+
+// ErrInvalidSlowConsumerPolicy is returned for a policy with a negative or
+// out of range threshold
+var ErrInvalidSlowConsumerPolicy = errors.New("invalid slow-consumer policy")
+
+// SlowConsumerPolicy unsubscribes subscriptions whose events keep being
+// dropped. A subscription is over the policy while it exceeds every
+// threshold that is set, and is unsubscribed once it has been over for
+// longer than Grace. A policy without thresholds never unsubscribes.
+type SlowConsumerPolicy struct {
+	MaxConsecutiveDrops int           // Drops in a row; 0 for no limit
+	MaxDropRatio        float64       // Share of deliveries dropped since subscribing, below 1; 0 for no limit
+	Grace               time.Duration // How long a subscription may stay over the thresholds
+}
+
+// enabled reports whether the policy can unsubscribe anyone
+func (p *SlowConsumerPolicy) enabled() bool {
+	return p != nil && (p.MaxConsecutiveDrops > 0 || p.MaxDropRatio > 0)
+}
+
+// check rejects negative thresholds and ratios that can never be exceeded
+func (p SlowConsumerPolicy) check() error {
+	if p.MaxConsecutiveDrops < 0 {
+		return fmt.Errorf("%w: max_consecutive_drops %d is negative", ErrInvalidSlowConsumerPolicy, p.MaxConsecutiveDrops)
+	}
+	if p.MaxDropRatio < 0 || p.MaxDropRatio >= 1 || math.IsNaN(p.MaxDropRatio) {
+		return fmt.Errorf("%w: max_drop_ratio %g must be at least 0 and below 1", ErrInvalidSlowConsumerPolicy, p.MaxDropRatio)
+	}
+	if p.Grace < 0 {
+		return fmt.Errorf("%w: grace %s is negative", ErrInvalidSlowConsumerPolicy, p.Grace)
+	}
+	return nil
+}
+
+// Settings returns the policy in its wire form
+func (p SlowConsumerPolicy) Settings() SlowConsumerSettings {
+	return SlowConsumerSettings{
+		MaxConsecutiveDrops: p.MaxConsecutiveDrops,
+		MaxDropRatio:        p.MaxDropRatio,
+		GraceSeconds:        p.Grace.Seconds(),
+	}
+}
+
+// Policy returns the policy the settings describe
+func (s SlowConsumerSettings) Policy() SlowConsumerPolicy {
+	return SlowConsumerPolicy{
+		MaxConsecutiveDrops: s.MaxConsecutiveDrops,
+		MaxDropRatio:        s.MaxDropRatio,
+		Grace:               time.Duration(s.GraceSeconds * float64(time.Second)),
+	}
+}
+
+// slowConsumerSettings returns a topic override in its wire form, nil for
+// none
+func slowConsumerSettings(policy *SlowConsumerPolicy) *SlowConsumerSettings {
+	if policy == nil {
+		return nil
+	}
+	settings := policy.Settings()
+	return &settings
+}
+
+// slowConsumerOverride returns the policy of stored settings, nil for none
+func slowConsumerOverride(settings *SlowConsumerSettings) *SlowConsumerPolicy {
+	if settings == nil {
+		return nil
+	}
+	policy := settings.Policy()
+	return &policy
+}
+
+// subscriptionHealth counts a subscription's deliveries for the
+// slow-consumer policy. Resubscribing starts a fresh count.
+type subscriptionHealth struct {
+	mutex     sync.Mutex
+	attempts  int64
+	drops     int64
+	streak    int
+	overSince time.Time // When it went over the policy, zero while under
+	tripped   bool      // Already being unsubscribed
+}
+
+// record counts a delivery and reports, once, that the subscription has
+// been over policy for longer than its grace, with what it was over
+func (h *subscriptionHealth) record(dropped bool, policy *SlowConsumerPolicy, now time.Time) (string, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.attempts++
+	if dropped {
+		h.drops++
+		h.streak++
+	} else {
+		h.streak = 0
+	}
+	ratio := float64(h.drops) / float64(h.attempts)
+	over := (policy.MaxConsecutiveDrops == 0 || h.streak > policy.MaxConsecutiveDrops) &&
+		(policy.MaxDropRatio == 0 || ratio > policy.MaxDropRatio)
+	if !over {
+		h.overSince = time.Time{}
+		return "", false
+	}
+	if h.overSince.IsZero() {
+		h.overSince = now
+	}
+	if h.tripped || now.Sub(h.overSince) < policy.Grace {
+		return "", false
+	}
+	h.tripped = true
+	return fmt.Sprintf("%d consecutive drops, %d of %d deliveries dropped", h.streak, h.drops, h.attempts), true
+}
+
+// SetSlowConsumerPolicy sets the server-wide slow-consumer policy, for
+// topics without their own. The zero policy turns it off.
+func (ps *PubSubSystem) SetSlowConsumerPolicy(policy SlowConsumerPolicy) error {
+	if err := policy.check(); err != nil {
+		return err
+	}
+	if !policy.enabled() {
+		ps.slowConsumer.Store(nil)
+		return nil
+	}
+	ps.slowConsumer.Store(&policy)
+	return nil
+}
+
+// SlowConsumerPolicy returns the server-wide slow-consumer policy
+func (ps *PubSubSystem) SlowConsumerPolicy() SlowConsumerPolicy {
+	if policy := ps.slowConsumer.Load(); policy != nil {
+		return *policy
+	}
+	return SlowConsumerPolicy{}
+}
+
+// SetTopicSlowConsumerPolicy overrides the slow-consumer policy for one
+// topic; the zero policy turns it off there, and nil restores the server's
+func (ps *PubSubSystem) SetTopicSlowConsumerPolicy(ctx context.Context, name string, policy *SlowConsumerPolicy) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := checkUserTopic(name); err != nil {
+		return err
+	}
+	if policy != nil {
+		if err := policy.check(); err != nil {
+			return err
+		}
+		copied := *policy
+		policy = &copied
+	}
+	topic, exists := ps.topics.get(name)
+	if !exists {
+		return errorOf(ErrTopicNotFound, "topic %s not found", name)
+	}
+
+	topic.mutex.Lock()
+	topic.slowConsumer.Store(policy)
+	config := topic.config()
+	createdAt := topic.CreatedAt
+	topic.mutex.Unlock()
+
+	if ps.store != nil {
+		ps.store.TopicCreated(name, createdAt, config)
+	}
+	return nil
+}
+
+// noteDelivery counts a delivery attempt against the subscription's
+// slow-consumer policy, unsubscribing it once it is over for too long
+func (ps *PubSubSystem) noteDelivery(topic *Topic, subscriber *Subscriber, dropped bool) {
+	policy := topic.slowConsumer.Load()
+	if policy == nil {
+		policy = ps.slowConsumer.Load()
+	}
+	if !policy.enabled() {
+		return
+	}
+	if detail, trip := subscriber.health.record(dropped, policy, ps.clock.Now()); trip {
+		// Callers may hold the topic lock, which unsubscribing takes
+		go ps.unsubscribeSlowConsumer(topic, subscriber, detail)
+	}
+}
+
+// unsubscribeSlowConsumer ends one subscription that fell too far behind,
+// leaving the client's connection and other subscriptions alone
+func (ps *PubSubSystem) unsubscribeSlowConsumer(topic *Topic, subscriber *Subscriber, detail string) {
+	// A client that has resubscribed since starts over
+	topic.mutex.RLock()
+	current := topic.Subscribers[subscriber.ClientID]
+	topic.mutex.RUnlock()
+	if current != subscriber {
+		return
+	}
+	if err := ps.Unsubscribe(context.Background(), subscriber.ClientID, topic.Name); err != nil {
+		return
+	}
+
+	log.Printf("Unsubscribed slow consumer %s from topic %s: %s", subscriber.ClientID, topic.Name, detail)
+	topic.mutex.Lock()
+	topic.SlowConsumerUnsubscribes++
+	topic.mutex.Unlock()
+	ps.Audit(AuditRecord{Event: AuditSlowConsumer, ClientID: subscriber.ClientID, Topic: topic.Name, Reason: detail})
+
+	notice := InfoResponse{
+		Type:      "unsubscribed",
+		Topic:     topic.Name,
+		Message:   "too many events dropped",
+		Reason:    ReasonSlowConsumer,
+		Timestamp: ps.clock.Now(),
+	}
+	if err := subscriber.Client.SendMessage(notice); err != nil {
+		log.Printf("Dropping slow-consumer notice for client %s - %v", subscriber.ClientID, err)
+	}
+}
@@ -0,0 +1,181 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stalledClient is one connection whose reader has stopped keeping up with
+// one topic: events on stalled are refused as if its buffer were full,
+// everything else is recorded
+type stalledClient struct {
+	recordingClient
+	stalled string
+	mutex   sync.Mutex
+	refused int
+}
+
+func (c *stalledClient) SendMessage(msg interface{}) error {
+	if prepared, ok := msg.(*PreparedEvent); ok && prepared.Event.Topic == c.stalled {
+		c.mutex.Lock()
+		c.refused++
+		c.mutex.Unlock()
+		return errors.New("send buffer full")
+	}
+	return c.recordingClient.SendMessage(msg)
+}
+
+// refusals returns how many events the client refused
+func (c *stalledClient) refusals() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.refused
+}
+
+func TestSlowConsumerUnsubscribed(t *testing.T) {
+	ps, clock := fakeClockSystem()
+	defer ps.Close()
+	al, err := NewAuditLog(100, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer al.Close()
+	ps.EnableAuditLog(al)
+	if err := ps.SetSlowConsumerPolicy(SlowConsumerPolicy{MaxConsecutiveDrops: 5, MaxDropRatio: 0.5, Grace: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ticks", "chat"} {
+		if err := ps.CreateTopic(context.Background(), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := &stalledClient{recordingClient: recordingClient{id: "wedged"}, stalled: "ticks"}
+	for _, name := range []string{"ticks", "chat"} {
+		if _, err := ps.Subscribe(context.Background(), "wedged", name, 0, client); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Over both thresholds, but not yet for longer than the grace
+	publishN(t, ps, "ticks", 10)
+	clock.Advance(30 * time.Second)
+	publishN(t, ps, "ticks", 1)
+	if !ps.IsSubscribed("wedged", "ticks") {
+		t.Fatal("unsubscribed within the grace period")
+	}
+
+	clock.Advance(31 * time.Second)
+	publishN(t, ps, "ticks", 1)
+	waitFor(t, "the slow subscription to end", func() bool { return !ps.IsSubscribed("wedged", "ticks") })
+	waitFor(t, "the notice", func() bool { return len(client.unsubscribedNotices()) == 1 })
+	if notice := client.unsubscribedNotices()[0]; notice.Topic != "ticks" || notice.Reason != ReasonSlowConsumer {
+		t.Errorf("notice = %+v", notice)
+	}
+	waitFor(t, "the audit record", func() bool {
+		records := al.Query(AuditQuery{ClientID: "wedged", Limit: 1})
+		return len(records) == 1 && records[0].Event == AuditSlowConsumer && records[0].Topic == "ticks"
+	})
+	if got := ps.GetStats().Topics["ticks"].SlowConsumers; got != 1 {
+		t.Errorf("slow-consumer unsubscribes = %d, want 1", got)
+	}
+
+	// Later events are no longer attempted, and the connection's other
+	// subscription keeps working
+	refused := client.refusals()
+	publishN(t, ps, "ticks", 5)
+	if client.refusals() != refused {
+		t.Error("events still sent to the unsubscribed consumer")
+	}
+	publishN(t, ps, "chat", 3)
+	if events := client.received(); len(events) != 3 || events[0].Topic != "chat" {
+		t.Errorf("healthy subscription got %d events", len(events))
+	}
+	if !ps.IsSubscribed("wedged", "chat") {
+		t.Error("the healthy subscription was dropped")
+	}
+
+	// Resubscribing starts the counts over
+	if _, err := ps.Subscribe(context.Background(), "wedged", "ticks", 0, client); err != nil {
+		t.Fatal(err)
+	}
+	publishN(t, ps, "ticks", 10)
+	clock.Advance(30 * time.Second)
+	publishN(t, ps, "ticks", 1)
+	if !ps.IsSubscribed("wedged", "ticks") {
+		t.Error("a resubscribed consumer was dropped on its old counts")
+	}
+}
+
+func TestSlowConsumerThresholds(t *testing.T) {
+	policy := &SlowConsumerPolicy{MaxConsecutiveDrops: 3, MaxDropRatio: 0.5}
+	now := time.Now()
+
+	// A long streak on a mostly healthy subscription isn't over the ratio
+	var health subscriptionHealth
+	for i := 0; i < 20; i++ {
+		health.record(false, policy, now)
+	}
+	for i := 0; i < 10; i++ {
+		if _, trip := health.record(true, policy, now); trip {
+			t.Fatalf("tripped after %d drops under the ratio", i+1)
+		}
+	}
+
+	// Over both, it trips once; a delivery in between starts the streak over
+	var failing subscriptionHealth
+	trips := 0
+	for i, dropped := range []bool{true, true, true, false, true, true, true, true, true} {
+		if detail, trip := failing.record(dropped, policy, now); trip {
+			trips++
+			if i != 7 || detail == "" {
+				t.Errorf("tripped at delivery %d: %q", i, detail)
+			}
+		}
+	}
+	if trips != 1 {
+		t.Errorf("tripped %d times", trips)
+	}
+
+	ps := New()
+	for _, bad := range []SlowConsumerPolicy{{MaxConsecutiveDrops: -1}, {MaxDropRatio: 1}, {MaxDropRatio: 0.5, Grace: -time.Second}} {
+		if err := ps.SetSlowConsumerPolicy(bad); !errors.Is(err, ErrInvalidSlowConsumerPolicy) {
+			t.Errorf("SetSlowConsumerPolicy(%+v) = %v", bad, err)
+		}
+	}
+}
+
+func TestTopicSlowConsumerOverride(t *testing.T) {
+	ps := New()
+	if err := ps.SetSlowConsumerPolicy(SlowConsumerPolicy{MaxConsecutiveDrops: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateTopicWithConfig(context.Background(), "metrics", TopicConfig{SlowConsumer: &SlowConsumerPolicy{}}); err != nil {
+		t.Fatal(err)
+	}
+	client := &stalledClient{recordingClient: recordingClient{id: "lagging"}, stalled: "metrics"}
+	if _, err := ps.Subscribe(context.Background(), "lagging", "metrics", 0, client); err != nil {
+		t.Fatal(err)
+	}
+
+	// Turned off for the topic
+	publishN(t, ps, "metrics", 10)
+	if !ps.IsSubscribed("lagging", "metrics") {
+		t.Fatal("unsubscribed from a topic with the policy off")
+	}
+	if detail, _ := ps.GetTopicDetail("metrics"); detail.SlowConsumer == nil || *detail.SlowConsumer != (SlowConsumerSettings{}) {
+		t.Errorf("detail override = %+v", detail.SlowConsumer)
+	}
+
+	// Back on the server's policy
+	if err := ps.SetTopicSlowConsumerPolicy(context.Background(), "metrics", nil); err != nil {
+		t.Fatal(err)
+	}
+	publishN(t, ps, "metrics", 3)
+	waitFor(t, "the server policy to apply", func() bool { return !ps.IsSubscribed("lagging", "metrics") })
+	if detail, _ := ps.GetTopicDetail("metrics"); detail.SlowConsumer != nil {
+		t.Errorf("detail override = %+v after restoring the default", detail.SlowConsumer)
+	}
+}
@@ -0,0 +1,233 @@
+package pubsub
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// SnapshotVersion is the current snapshot file format version
+const SnapshotVersion = 1
+
+// SystemSnapshot is a point-in-time copy of every topic and its history
+type SystemSnapshot struct {
+	Version int             `json:"version"`
+	TakenAt time.Time       `json:"taken_at"`
+	Topics  []TopicSnapshot `json:"topics"`
+}
+
+// TopicSnapshot is the persisted state of a single topic
+type TopicSnapshot struct {
+	Name             string                `json:"name"`
+	CreatedAt        time.Time             `json:"created_at"`
+	MessageCount     int64                 `json:"message_count"`
+	LastSeq          int64                 `json:"last_seq"`
+	LastPublishedAt  time.Time             `json:"last_published_at"`
+	HistorySize      int                   `json:"history_size"`
+	RetentionSeconds int                   `json:"retention_seconds,omitempty"`
+	DeadLetterTopic  string                `json:"dlq_topic,omitempty"`
+	Schema           json.RawMessage       `json:"schema,omitempty"`
+	Archived         bool                  `json:"archived,omitempty"`
+	Description      string                `json:"description,omitempty"`
+	Labels           map[string]string     `json:"labels,omitempty"`
+	SigningKeyID     string                `json:"signing_key_id,omitempty"`
+	Compacted        bool                  `json:"compacted,omitempty"`
+	Owner            string                `json:"owner,omitempty"`
+	Private          bool                  `json:"private,omitempty"`
+	Members          []string              `json:"members,omitempty"`
+	MaxPayloadBytes  int                   `json:"max_payload_bytes,omitempty"`
+	MaxPublishRate   float64               `json:"max_publish_rate,omitempty"`
+	SlowConsumer     *SlowConsumerSettings `json:"slow_consumer,omitempty"`
+	Watermarks       *SubscriberWatermarks `json:"subscriber_watermarks,omitempty"`
+	History          []EventResponse       `json:"history"`
+	Scheduled        []ScheduledMessage    `json:"scheduled,omitempty"` // Pending scheduled messages
+}
+
+// Snapshot copies the state of every topic. Each topic is copied under its
+// own lock, so its history and sequence counter are consistent with each
+// other while publishes to other topics continue.
+func (ps *PubSubSystem) Snapshot() SystemSnapshot {
+	var topics []*Topic
+	ps.topics.each(func(topic *Topic) {
+		// System topics are recreated by EnableSystemTopics
+		if !IsSystemTopic(topic.Name) {
+			topics = append(topics, topic)
+		}
+	})
+
+	snapshot := SystemSnapshot{
+		Version: SnapshotVersion,
+		TakenAt: ps.clock.Now(),
+		Topics:  make([]TopicSnapshot, 0, len(topics)),
+	}
+
+	for _, topic := range topics {
+		topic.mutex.RLock()
+		snapshot.Topics = append(snapshot.Topics, TopicSnapshot{
+			Name:             topic.Name,
+			CreatedAt:        topic.CreatedAt,
+			MessageCount:     topic.MessageCount,
+			LastSeq:          topic.LastSeq,
+			LastPublishedAt:  topic.LastPublishedAt,
+			HistorySize:      topic.MessageHistory.Cap(),
+			RetentionSeconds: int(topic.Retention / time.Second),
+			DeadLetterTopic:  topic.DeadLetterTopic,
+			Schema:           topic.Schema.Raw(),
+			Archived:         topic.Archived,
+			Description:      topic.Description,
+			Labels:           topic.Labels,
+			SigningKeyID:     topic.SigningKeyID,
+			Compacted:        topic.Compacted,
+			Owner:            topic.Owner,
+			Private:          topic.Private,
+			Members:          topic.memberList(),
+			MaxPayloadBytes:  topic.MaxPayloadBytes,
+			MaxPublishRate:   topic.maxPublishRate(),
+			SlowConsumer:     slowConsumerSettings(topic.slowConsumer.Load()),
+			Watermarks:       topic.watermarks.Load(),
+			History:          topic.MessageHistory.GetAll(),
+		})
+		topic.mutex.RUnlock()
+		snapshot.Topics[len(snapshot.Topics)-1].Scheduled = ps.scheduler.forTopic(topic.Name)
+	}
+
+	return snapshot
+}
+
+// RestoreSnapshot recreates topics, histories and sequence counters from a
+// snapshot. Existing topics keep their subscribers but have their history and
+// counters replaced. Returns the number of topics restored.
+func (ps *PubSubSystem) RestoreSnapshot(snapshot SystemSnapshot) (int, error) {
+	if snapshot.Version != SnapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d", snapshot.Version)
+	}
+
+	// Every topic is checked before any is replaced, so a bad one leaves
+	// the system as it was
+	type checkedTopic struct {
+		snapshot     *TopicSnapshot
+		schema       *TopicSchema
+		slowConsumer *SlowConsumerPolicy
+	}
+	var checked []checkedTopic
+	for i := range snapshot.Topics {
+		ts := &snapshot.Topics[i]
+		// Reserved names are never restored over the system topics
+		if IsSystemTopic(ts.Name) {
+			continue
+		}
+		schema, err := CompileTopicSchema(ts.Schema)
+		if err != nil {
+			return 0, fmt.Errorf("topic %s: %w", ts.Name, err)
+		}
+		if err := checkTopicMetadata(ts.Description, ts.Labels); err != nil {
+			return 0, fmt.Errorf("topic %s: %w", ts.Name, err)
+		}
+		if err := checkTopicLimits(ts.MaxPayloadBytes, ts.MaxPublishRate); err != nil {
+			return 0, fmt.Errorf("topic %s: %w", ts.Name, err)
+		}
+		slowConsumer := slowConsumerOverride(ts.SlowConsumer)
+		if slowConsumer != nil {
+			if err := slowConsumer.check(); err != nil {
+				return 0, fmt.Errorf("topic %s: %w", ts.Name, err)
+			}
+		}
+		if ts.Watermarks != nil {
+			if err := ts.Watermarks.check(); err != nil {
+				return 0, fmt.Errorf("topic %s: %w", ts.Name, err)
+			}
+		}
+		checked = append(checked, checkedTopic{snapshot: ts, schema: schema, slowConsumer: slowConsumer})
+	}
+
+	for _, c := range checked {
+		ts, schema, slowConsumer := c.snapshot, c.schema, c.slowConsumer
+		name := ts.Name
+		created := false
+		topic := ps.topics.getOrInsert(name, func() *Topic {
+			created = true
+			return ps.newTopic(name, 0)
+		})
+		if created {
+			ps.emit(hookEvent{kind: hookTopicCreated, topic: name})
+		}
+
+		historySize := ts.HistorySize
+		if historySize <= 0 {
+			historySize = TopicHistoryBufferSize
+		}
+
+		topic.mutex.Lock()
+		topic.CreatedAt = ts.CreatedAt
+		topic.MessageCount = ts.MessageCount
+		topic.LastSeq = ts.LastSeq
+		topic.LastPublishedAt = ts.LastPublishedAt
+		topic.Retention = time.Duration(ts.RetentionSeconds) * time.Second
+		topic.DeadLetterTopic = ts.DeadLetterTopic
+		topic.Schema = schema
+		topic.Archived = ts.Archived
+		topic.Description = ts.Description
+		topic.Labels = copyLabels(ts.Labels)
+		topic.SigningKeyID = ts.SigningKeyID
+		topic.Owner = ts.Owner
+		topic.Private = ts.Private
+		topic.Members = memberSet(ts.Members)
+		topic.MessageHistory = NewEventBufferWithMaxAge(historySize, topic.Retention, ps.clock.Now)
+		topic.setCompacted(ts.Compacted)
+		topic.setLimits(ts.MaxPayloadBytes, ts.MaxPublishRate)
+		topic.slowConsumer.Store(slowConsumer)
+		topic.watermarks.Store(ts.Watermarks)
+		for _, event := range ts.History {
+			topic.MessageHistory.Push(event)
+		}
+		config := topic.config()
+		topic.mutex.Unlock()
+		ps.notePayloadLimit(ts.MaxPayloadBytes)
+
+		if ps.store != nil {
+			ps.store.TopicCreated(ts.Name, ts.CreatedAt, config)
+			ps.store.Rewrite(ts.Name, ts.History)
+		}
+		ps.scheduler.restore(ts.Scheduled)
+	}
+
+	return len(checked), nil
+}
+
+// WriteSnapshot encodes a snapshot as JSON, optionally gzip-compressed
+func WriteSnapshot(w io.Writer, snapshot SystemSnapshot, compress bool) error {
+	if !compress {
+		return json.NewEncoder(w).Encode(snapshot)
+	}
+
+	gz := gzip.NewWriter(w)
+	if err := json.NewEncoder(gz).Encode(snapshot); err != nil {
+		gz.Close()
+		return err
+	}
+	return gz.Close()
+}
+
+// ReadSnapshot decodes a JSON snapshot, transparently handling gzip
+func ReadSnapshot(r io.Reader) (SystemSnapshot, error) {
+	var snapshot SystemSnapshot
+
+	br := bufio.NewReader(r)
+	var src io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return snapshot, err
+		}
+		defer gz.Close()
+		src = gz
+	}
+
+	if err := json.NewDecoder(src).Decode(&snapshot); err != nil {
+		return snapshot, fmt.Errorf("decoding snapshot: %w", err)
+	}
+	return snapshot, nil
+}
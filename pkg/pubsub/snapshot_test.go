@@ -0,0 +1,155 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// historyJSON encodes every topic's history in a snapshot, by name
+func historyJSON(t *testing.T, snapshot SystemSnapshot) map[string][]byte {
+	t.Helper()
+	out := make(map[string][]byte)
+	for _, topic := range snapshot.Topics {
+		data, err := json.Marshal(topic.History)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[topic.Name] = data
+	}
+	return out
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	ps := New()
+	for _, name := range []string{"orders", "audit"} {
+		if err := ps.CreateTopic(context.Background(), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		msg := MessageData{
+			ID:      fmt.Sprintf("m%d", i),
+			Payload: map[string]interface{}{"n": i, "s": "ü <tag>", "f": 1.5},
+		}
+		if err := ps.Publish(context.Background(), "orders", msg, "publisher"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publishN(t, ps, "audit", 3)
+	original := ps.Snapshot()
+	createdAt := map[string]time.Time{}
+	for _, topic := range original.Topics {
+		createdAt[topic.Name] = topic.CreatedAt
+	}
+
+	for _, compress := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := WriteSnapshot(&buf, original, compress); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := ReadSnapshot(&buf)
+		if err != nil {
+			t.Fatalf("gzip %v: ReadSnapshot: %v", compress, err)
+		}
+
+		restored := New()
+		if n, err := restored.RestoreSnapshot(decoded); err != nil || n != 2 {
+			t.Fatalf("gzip %v: RestoreSnapshot = %d, %v", compress, n, err)
+		}
+		again := restored.Snapshot()
+
+		want, got := historyJSON(t, original), historyJSON(t, again)
+		for name, history := range want {
+			if !bytes.Equal(got[name], history) {
+				t.Errorf("gzip %v: topic %s history differs after restore:\n%s\n%s", compress, name, history, got[name])
+			}
+		}
+
+		detail, err := restored.GetTopicDetail("orders")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if detail.LatestSeq != 20 || detail.MessageCount != 20 || !detail.CreatedAt.Equal(createdAt["orders"]) {
+			t.Errorf("gzip %v: restored detail = %+v", compress, detail)
+		}
+
+		// Publishing carries on from the restored sequence number
+		publishN(t, restored, "orders", 1)
+		if events := history(t, restored, "orders"); events[len(events)-1].Seq != 21 {
+			t.Errorf("gzip %v: first publish after restore got seq %d", compress, events[len(events)-1].Seq)
+		}
+	}
+}
+
+func TestSnapshotIsConsistentDuringPublishes(t *testing.T) {
+	ps := New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			if err := ps.Publish(context.Background(), "orders", MessageData{ID: fmt.Sprintf("m%d", i)}, ""); err != nil {
+				t.Errorf("Publish: %v", err)
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		topic := ps.Snapshot().Topics[0]
+		// The history always ends at the sequence counter
+		if n := len(topic.History); n > 0 && topic.History[n-1].Seq != topic.LastSeq {
+			t.Fatalf("snapshot history ends at %d, counter at %d", topic.History[n-1].Seq, topic.LastSeq)
+		}
+	}
+}
+
+func TestRestoreSnapshotRejectsUnknownVersions(t *testing.T) {
+	if _, err := New().RestoreSnapshot(SystemSnapshot{Version: SnapshotVersion + 1}); err == nil {
+		t.Error("restored a snapshot from the future")
+	}
+	if _, err := ReadSnapshot(bytes.NewReader([]byte("{not json"))); err == nil {
+		t.Error("read a corrupt snapshot")
+	}
+}
+
+func TestRestoreSnapshotIsAllOrNothing(t *testing.T) {
+	source := New()
+	for _, name := range []string{"orders", "fresh"} {
+		if err := source.CreateTopic(context.Background(), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publishN(t, source, "orders", 5)
+	snapshot := source.Snapshot()
+	snapshot.Topics = append(snapshot.Topics, TopicSnapshot{Name: "broken", Schema: json.RawMessage("{not json")})
+
+	ps := New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	publishN(t, ps, "orders", 2)
+	if _, err := ps.RestoreSnapshot(snapshot); err == nil {
+		t.Fatal("restored a snapshot with a broken topic")
+	}
+
+	// Neither the topic before the broken one nor a new one was touched
+	if detail, err := ps.GetTopicDetail("orders"); err != nil || detail.HistoryCount != 2 {
+		t.Errorf("orders = %+v, %v", detail, err)
+	}
+	if _, err := ps.GetTopicDetail("fresh"); err == nil {
+		t.Error("fresh was created by a failed restore")
+	}
+}
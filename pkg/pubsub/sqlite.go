@@ -0,0 +1,472 @@
+package pubsub
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// SQLiteDriverName is the database/sql driver OpenSQLiteHistory uses.
+	// The program must register it, e.g. by importing modernc.org/sqlite.
+	SQLiteDriverName = "sqlite"
+
+	// Most archived events replayed ahead of the in-memory ring when a
+	// since_seq subscription reaches back past it
+	sqliteReplayLimit = TopicHistoryBufferSize
+
+	sqliteBatchSize         = 512         // Most inserts committed in one transaction
+	sqliteRetentionInterval = time.Minute // How often expired rows are deleted
+)
+
+// sqliteSetup prepares a new or existing database
+var sqliteSetup = []string{
+	"PRAGMA journal_mode=WAL",
+	"PRAGMA synchronous=NORMAL",
+	`CREATE TABLE IF NOT EXISTS messages (
+		topic     TEXT    NOT NULL,
+		seq       INTEGER NOT NULL,
+		id        TEXT    NOT NULL,
+		payload   TEXT    NOT NULL,
+		ts        INTEGER NOT NULL, -- Unix nanoseconds
+		headers   TEXT,             -- JSON object, NULL without headers
+		signature TEXT,             -- JSON Signature, NULL for unsigned events
+		PRIMARY KEY (topic, seq)
+	)`,
+	"CREATE INDEX IF NOT EXISTS messages_topic_ts ON messages (topic, ts)",
+}
+
+// sqliteAddedColumns are columns added to the messages table after it was
+// first released, with their definitions for older databases
+var sqliteAddedColumns = []struct{ name, definition string }{
+	{"headers", "headers TEXT"},
+	{"signature", "signature TEXT"},
+}
+
+// sqliteOp is a unit of work for the SQLite writer
+type sqliteOp struct {
+	kind  string // "append", "purge", "delete" or "sync"
+	topic string
+	event EventResponse // Appended, or for a "delete" the one removed
+	done  chan struct{} // Closed once a "sync" is reached
+
+	// A "purge" removes events older than beforeTS, or with a seq below
+	// beforeSeq, or all of the topic's events when both are zero
+	beforeTS  time.Time
+	beforeSeq int64
+}
+
+// SQLiteHistory archives every published event in a SQLite database so
+// history can be queried beyond the in-memory ring. Inserts are batched
+// into transactions by a single background writer, so publishers only pay
+// for a channel send and commits (and their fsyncs) are shared by every
+// event that arrived while the previous one ran. Appends never block: when
+// the queue is full they are dropped and counted.
+type SQLiteHistory struct {
+	db        *sql.DB
+	retention time.Duration
+
+	ops     chan sqliteOp
+	closing chan struct{} // Closed by Close; later writes are no-ops
+	done    chan struct{}
+
+	dropped     atomic.Int64
+	overflowing atomic.Bool
+
+	closeOnce sync.Once
+}
+
+// HistoryQuery selects a page of a topic's history. Pages walk forward from
+// AfterSeq (or the oldest event) unless Descending or BeforeSeq is set, in
+// which case they walk backward from BeforeSeq (or the newest event).
+type HistoryQuery struct {
+	AfterSeq   int64
+	BeforeSeq  int64
+	From       time.Time // Only events at or after From, if set
+	To         time.Time // Only events before To, if set
+	Limit      int
+	Descending bool // Return the page newest first
+}
+
+// OpenSQLiteHistory opens or creates the database at path and starts the
+// writer. Events older than retention are deleted periodically; zero keeps
+// them forever.
+func OpenSQLiteHistory(path string, retention time.Duration) (*SQLiteHistory, error) {
+	db, err := sql.Open(SQLiteDriverName, path)
+	if err != nil {
+		return nil, fmt.Errorf("opening SQLite history: %w", err)
+	}
+	// One connection serializes the writer and queries, so no statement
+	// ever sees SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	for _, stmt := range sqliteSetup {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("initializing SQLite history: %w", err)
+		}
+	}
+	if err := migrateSQLiteHistory(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating SQLite history: %w", err)
+	}
+
+	sh := &SQLiteHistory{
+		db:        db,
+		retention: retention,
+		ops:       make(chan sqliteOp, historyWriteQueueSize),
+		closing:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go sh.run()
+	return sh, nil
+}
+
+// migrateSQLiteHistory adds the columns a database created by an older
+// version lacks
+func migrateSQLiteHistory(db *sql.DB) error {
+	for _, column := range sqliteAddedColumns {
+		var exists bool
+		if err := db.QueryRow("SELECT COUNT(*) > 0 FROM pragma_table_info('messages') WHERE name = ?", column.name).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := db.Exec("ALTER TABLE messages ADD COLUMN " + column.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Append queues a published event for insertion. It never blocks: with
+// the queue full the event is dropped and counted.
+func (sh *SQLiteHistory) Append(event EventResponse) {
+	select {
+	case <-sh.closing:
+		return
+	default:
+	}
+	select {
+	case sh.ops <- sqliteOp{kind: "append", topic: event.Topic, event: event}:
+	default:
+		sh.dropped.Add(1)
+		if !sh.overflowing.Swap(true) {
+			log.Printf("SQLite history: write queue is full (%d operations); dropping events until it drains", cap(sh.ops))
+		}
+	}
+}
+
+// Purge queues the removal of a topic's archived events older than
+// beforeTS or with a seq below beforeSeq; with both zero every archived
+// event of the topic goes
+func (sh *SQLiteHistory) Purge(topic string, beforeTS time.Time, beforeSeq int64) {
+	sh.send(sqliteOp{kind: "purge", topic: topic, beforeTS: beforeTS, beforeSeq: beforeSeq})
+}
+
+// Delete queues the removal of an event's archived copy
+func (sh *SQLiteHistory) Delete(topic string, event EventResponse) {
+	sh.send(sqliteOp{kind: "delete", topic: topic, event: event})
+}
+
+// send queues an operation that must not be dropped, waiting for room in
+// the queue. After Close it does nothing and returns false.
+func (sh *SQLiteHistory) send(op sqliteOp) bool {
+	select {
+	case <-sh.closing:
+		return false
+	default:
+	}
+	select {
+	case sh.ops <- op:
+		return true
+	case <-sh.closing:
+		return false
+	}
+}
+
+// sync waits until every operation queued before it has been written
+func (sh *SQLiteHistory) sync() {
+	done := make(chan struct{})
+	if !sh.send(sqliteOp{kind: "sync", done: done}) {
+		return
+	}
+	select {
+	case <-done:
+	case <-sh.done:
+	}
+}
+
+// Status reports the write queue for /health
+func (sh *SQLiteHistory) Status() StoreStatus {
+	return StoreStatus{
+		Queued:      len(sh.ops),
+		Capacity:    cap(sh.ops),
+		Overflowing: sh.overflowing.Load(),
+		Dropped:     sh.dropped.Load(),
+	}
+}
+
+// Close writes pending events and closes the database. Writes after Close
+// are ignored.
+func (sh *SQLiteHistory) Close() {
+	sh.closeOnce.Do(func() {
+		close(sh.closing)
+		<-sh.done
+		sh.db.Close()
+	})
+}
+
+// run is the background writer loop. It takes whatever operations are
+// queued, up to a batch, and commits their inserts together.
+func (sh *SQLiteHistory) run() {
+	defer close(sh.done)
+
+	var retention <-chan time.Time
+	if sh.retention > 0 {
+		ticker := time.NewTicker(sqliteRetentionInterval)
+		defer ticker.Stop()
+		retention = ticker.C
+	}
+
+	batch := make([]sqliteOp, 0, sqliteBatchSize)
+	for {
+		select {
+		case op := <-sh.ops:
+			sh.applyBatch(append(batch[:0], op))
+		case <-sh.closing:
+			// Write what was queued before Close
+			for len(sh.ops) > 0 {
+				sh.applyBatch(batch[:0])
+			}
+			return
+		case <-retention:
+			cutoff := time.Now().Add(-sh.retention).UnixNano()
+			result, err := sh.db.Exec("DELETE FROM messages WHERE ts < ?", cutoff)
+			if err != nil {
+				log.Printf("SQLite history: retention cleanup failed: %v", err)
+			} else if n, _ := result.RowsAffected(); n > 0 {
+				log.Printf("SQLite history: deleted %d events older than %s", n, sh.retention)
+			}
+		}
+	}
+}
+
+// applyBatch tops batch up with whatever else is queued, up to a full
+// batch, writes it and releases its syncs
+func (sh *SQLiteHistory) applyBatch(batch []sqliteOp) {
+fill:
+	for len(batch) < sqliteBatchSize {
+		select {
+		case op := <-sh.ops:
+			batch = append(batch, op)
+		default:
+			break fill
+		}
+	}
+	if err := sh.apply(batch); err != nil {
+		log.Printf("SQLite history: writing %d operations failed: %v", len(batch), err)
+	}
+	for _, op := range batch {
+		if op.done != nil {
+			close(op.done)
+		}
+	}
+	if len(sh.ops) <= cap(sh.ops)/2 {
+		sh.overflowing.Store(false)
+	}
+}
+
+// apply runs a batch of operations in one transaction, in order
+func (sh *SQLiteHistory) apply(batch []sqliteOp) error {
+	tx, err := sh.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.Prepare("INSERT OR REPLACE INTO messages (topic, seq, id, payload, ts, headers, signature) VALUES (?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	for _, op := range batch {
+		switch op.kind {
+		case "purge":
+			if err := purgeArchived(tx, op); err != nil {
+				return err
+			}
+			continue
+		case "delete":
+			if _, err := tx.Exec("DELETE FROM messages WHERE topic = ? AND seq = ?", op.topic, op.event.Seq); err != nil {
+				return err
+			}
+			continue
+		case "append":
+		default:
+			continue
+		}
+		payload, err := json.Marshal(op.event.Message.Payload)
+		if err != nil {
+			log.Printf("SQLite history: skipping %s seq %d: %v", op.topic, op.event.Seq, err)
+			continue
+		}
+		var headers sql.NullString
+		if len(op.event.Message.Headers) > 0 {
+			data, _ := json.Marshal(op.event.Message.Headers)
+			headers = sql.NullString{String: string(data), Valid: true}
+		}
+		var signature sql.NullString
+		if op.event.Signature != nil {
+			data, _ := json.Marshal(op.event.Signature)
+			signature = sql.NullString{String: string(data), Valid: true}
+		}
+		if _, err := insert.Exec(op.topic, op.event.Seq, op.event.Message.ID, string(payload), op.event.Timestamp.UnixNano(), headers, signature); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// purgeArchived deletes the events a "purge" selects
+func purgeArchived(tx *sql.Tx, op sqliteOp) error {
+	var err error
+	switch {
+	case !op.beforeTS.IsZero():
+		_, err = tx.Exec("DELETE FROM messages WHERE topic = ? AND ts < ?", op.topic, op.beforeTS.UnixNano())
+	case op.beforeSeq > 0:
+		_, err = tx.Exec("DELETE FROM messages WHERE topic = ? AND seq < ?", op.topic, op.beforeSeq)
+	default:
+		_, err = tx.Exec("DELETE FROM messages WHERE topic = ?", op.topic)
+	}
+	return err
+}
+
+// LastSeq returns the highest archived sequence number for a topic, or 0
+func (sh *SQLiteHistory) LastSeq(topic string) (int64, error) {
+	sh.sync()
+
+	var seq sql.NullInt64
+	if err := sh.db.QueryRow("SELECT MAX(seq) FROM messages WHERE topic = ?", topic).Scan(&seq); err != nil {
+		return 0, err
+	}
+	return seq.Int64, nil
+}
+
+// Query returns a page of a topic's archived events and whether more match,
+// including every event published before the call
+func (sh *SQLiteHistory) Query(topic string, q HistoryQuery) ([]EventResponse, bool, error) {
+	sh.sync()
+
+	forward := q.AfterSeq > 0 || (q.BeforeSeq == 0 && !q.Descending)
+	events, err := sh.selectEvents(topic, q, forward, q.Limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+
+	more := len(events) > q.Limit
+	if more {
+		events = events[:q.Limit]
+	}
+	// Backward pages come out newest first; put them in seq order
+	if !forward {
+		reverseEvents(events)
+	}
+	if q.Descending {
+		reverseEvents(events)
+	}
+	return events, more, nil
+}
+
+// before returns up to limit of the newest archived events with
+// afterSeq < seq < beforeSeq, oldest first
+func (sh *SQLiteHistory) before(topic string, afterSeq, beforeSeq int64, limit int) ([]EventResponse, error) {
+	sh.sync()
+
+	events, err := sh.selectEvents(topic, HistoryQuery{AfterSeq: afterSeq, BeforeSeq: beforeSeq}, false, limit)
+	if err != nil {
+		return nil, err
+	}
+	reverseEvents(events)
+	return events, nil
+}
+
+// selectEvents reads up to limit events matching q's bounds, in ascending
+// seq order when forward and descending otherwise
+func (sh *SQLiteHistory) selectEvents(topic string, q HistoryQuery, forward bool, limit int) ([]EventResponse, error) {
+	where := []string{"topic = ?"}
+	args := []interface{}{topic}
+	if q.AfterSeq > 0 {
+		where = append(where, "seq > ?")
+		args = append(args, q.AfterSeq)
+	}
+	if q.BeforeSeq > 0 {
+		where = append(where, "seq < ?")
+		args = append(args, q.BeforeSeq)
+	}
+	if !q.From.IsZero() {
+		where = append(where, "ts >= ?")
+		args = append(args, q.From.UnixNano())
+	}
+	if !q.To.IsZero() {
+		where = append(where, "ts < ?")
+		args = append(args, q.To.UnixNano())
+	}
+	order := "DESC"
+	if forward {
+		order = "ASC"
+	}
+	args = append(args, limit)
+
+	rows, err := sh.db.Query("SELECT seq, id, payload, ts, headers, signature FROM messages WHERE "+strings.Join(where, " AND ")+" ORDER BY seq "+order+" LIMIT ?", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []EventResponse
+	for rows.Next() {
+		var seq, ts int64
+		var id, payload string
+		var headers, signature sql.NullString
+		if err := rows.Scan(&seq, &id, &payload, &ts, &headers, &signature); err != nil {
+			return nil, err
+		}
+		event := EventResponse{
+			Type:      "event",
+			Topic:     topic,
+			Message:   MessageData{ID: id},
+			Seq:       seq,
+			Timestamp: time.Unix(0, ts).UTC(),
+		}
+		if err := json.Unmarshal([]byte(payload), &event.Message.Payload); err != nil {
+			return nil, err
+		}
+		if headers.Valid {
+			if err := json.Unmarshal([]byte(headers.String), &event.Message.Headers); err != nil {
+				return nil, err
+			}
+		}
+		if signature.Valid {
+			if err := json.Unmarshal([]byte(signature.String), &event.Signature); err != nil {
+				return nil, err
+			}
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// reverseEvents reverses events in place
+func reverseEvents(events []EventResponse) {
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+}
@@ -0,0 +1,195 @@
+//go:build sqlite
+
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// openSQLite attaches an archive in a temp database to a new system
+func openSQLite(t testing.TB) (*PubSubSystem, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "history.db")
+	sh, err := OpenSQLiteHistory(path, 0)
+	if err != nil {
+		t.Fatalf("OpenSQLiteHistory: %v", err)
+	}
+	ps := New()
+	ps.EnableSQLiteHistory(sh)
+	t.Cleanup(ps.Close)
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	return ps, path
+}
+
+func TestSQLiteHistoryQueriesArchive(t *testing.T) {
+	ps, _ := openSQLite(t)
+	publishN(t, ps, "orders", TopicHistoryBufferSize+50)
+
+	// The oldest events are only in the archive
+	events, more, err := ps.QueryTopicMessages("orders", HistoryQuery{Limit: 10})
+	if err != nil {
+		t.Fatalf("QueryTopicMessages: %v", err)
+	}
+	if len(events) != 10 || events[0].Seq != 1 || !more {
+		t.Fatalf("first page = %d events from seq %d, more %v", len(events), events[0].Seq, more)
+	}
+	if events[3].Message.ID != "m3" {
+		t.Errorf("event seq 4 has id %s", events[3].Message.ID)
+	}
+
+	events, _, err = ps.QueryTopicMessages("orders", HistoryQuery{BeforeSeq: 6, Limit: 2, Descending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Seq != 5 || events[1].Seq != 4 {
+		t.Errorf("descending page before 6 = %+v", events)
+	}
+
+	// Time ranges
+	all, _, _ := ps.QueryTopicMessages("orders", HistoryQuery{Limit: 2000})
+	from, to := all[20].Timestamp, all[30].Timestamp.Add(time.Nanosecond)
+	events, _, err = ps.QueryTopicMessages("orders", HistoryQuery{From: from, To: to, Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, event := range events {
+		if event.Timestamp.Before(from) || !event.Timestamp.Before(to) {
+			t.Errorf("event %d at %v outside the range", event.Seq, event.Timestamp)
+		}
+		found = found || event.Seq == 21
+	}
+	if !found {
+		t.Error("time range is missing the event it starts at")
+	}
+}
+
+func TestSQLiteHistoryReplaysBeyondRing(t *testing.T) {
+	ps, _ := openSQLite(t)
+	total := TopicHistoryBufferSize + 100
+	publishN(t, ps, "orders", total)
+
+	client := newTestClient("replayer")
+	ps.RegisterClient(client)
+	if _, err := ps.SubscribeWithOptions(context.Background(), "replayer", "orders", SubscribeOptions{SinceSeq: 1}, client); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	events := client.waitEvents(t, total-1)
+	for i, event := range events {
+		if event.Seq != int64(i+2) {
+			t.Fatalf("replayed event %d has seq %d, want %d", i, event.Seq, i+2)
+		}
+	}
+}
+
+func TestSQLiteHistoryPurge(t *testing.T) {
+	ps, _ := openSQLite(t)
+	publishN(t, ps, "orders", 10)
+
+	if _, err := ps.PurgeTopicHistory("orders", time.Time{}, 4); err != nil {
+		t.Fatalf("PurgeTopicHistory: %v", err)
+	}
+	events, _, _ := ps.QueryTopicMessages("orders", HistoryQuery{Limit: 100})
+	if len(events) != 7 || events[0].Seq != 4 {
+		t.Fatalf("after purging before seq 4: %d events from seq %d", len(events), events[0].Seq)
+	}
+
+	if _, err := ps.PurgeTopicHistory("orders", time.Time{}, 0); err != nil {
+		t.Fatalf("PurgeTopicHistory: %v", err)
+	}
+	if events, _, _ := ps.QueryTopicMessages("orders", HistoryQuery{Limit: 100}); len(events) != 0 {
+		t.Fatalf("after purging everything: %d events", len(events))
+	}
+}
+
+func TestSQLiteHistoryReopenContinuesSequence(t *testing.T) {
+	ps, path := openSQLite(t)
+	publishN(t, ps, "orders", 5)
+	ps.Close()
+
+	sh, err := OpenSQLiteHistory(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps = New()
+	ps.EnableSQLiteHistory(sh)
+	defer ps.Close()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	publishN(t, ps, "orders", 1)
+	events, _, _ := ps.QueryTopicMessages("orders", HistoryQuery{Limit: 100})
+	if len(events) != 6 || events[5].Seq != 6 {
+		t.Fatalf("after reopening: %+v", events)
+	}
+}
+
+func TestSQLiteHistoryAppendNeverBlocks(t *testing.T) {
+	// No writer is running, so nothing drains the queue
+	sh := &SQLiteHistory{ops: make(chan sqliteOp, 2), closing: make(chan struct{}), done: make(chan struct{})}
+	for i := 0; i < 5; i++ {
+		sh.Append(EventResponse{Topic: "orders", Seq: int64(i + 1)})
+	}
+	if status := sh.Status(); status.Dropped != 3 || !status.Overflowing {
+		t.Fatalf("status = %+v, want 3 dropped and overflowing", status)
+	}
+
+	close(sh.closing)
+	close(sh.done)
+	sh.Append(EventResponse{Topic: "orders"})
+	sh.Purge("orders", time.Time{}, 0)
+	sh.sync()
+}
+
+// BenchmarkPublishSQLite measures publishing with every event archived.
+// Inserts are committed in batches off the publish path, so a publish
+// never waits for a commit or its fsync.
+func BenchmarkPublishSQLite(b *testing.B) {
+	ps, _ := openSQLite(b)
+	msg := MessageData{ID: "m", Payload: map[string]interface{}{"n": 1}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := ps.Publish(context.Background(), "orders", msg, ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	ps.sqlite.sync()
+	b.ReportMetric(float64(ps.sqlite.Status().Dropped), "dropped")
+}
+
+func TestSQLiteHistoryKeepsHeaders(t *testing.T) {
+	ps, _ := openSQLite(t)
+	total := TopicHistoryBufferSize + 10
+	for i := 0; i < total; i++ {
+		msg := MessageData{ID: fmt.Sprintf("m%d", i), Payload: i}
+		if i%2 == 0 {
+			msg.Headers = map[string]string{"region": "eu"}
+		}
+		if err := ps.Publish(context.Background(), "orders", msg, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Events replayed from the archive keep their headers for the selector
+	client := newTestClient("replayer")
+	ps.RegisterClient(client)
+	opts := SubscribeOptions{SinceSeq: 1, Headers: map[string]string{"region": "eu"}}
+	if _, err := ps.SubscribeWithOptions(context.Background(), "replayer", "orders", opts, client); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	events := client.waitEvents(t, total/2-1)
+	for i, event := range events {
+		if event.Seq != int64(2*i+3) || event.Message.Headers["region"] != "eu" {
+			t.Fatalf("replayed event %d = seq %d headers %v", i, event.Seq, event.Message.Headers)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package pubsub
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultStatsDPrefix is prepended to every statsd metric name
+	DefaultStatsDPrefix = "pubsub."
+
+	// statsdBufferSize is how many metric lines wait for the writer before
+	// new ones are dropped
+	statsdBufferSize = 4096
+
+	// statsdMaxPacket keeps a datagram of batched lines within a typical
+	// MTU
+	statsdMaxPacket = 1432
+)
+
+// StatsD turns hook callbacks into statsd metrics with DogStatsD tags and
+// sends them over UDP. Register it with AddHooks(s.Hooks()).
+//
+// Sending never blocks a hook: lines wait in a bounded buffer for a writer
+// goroutine, and are dropped when it is full or the host doesn't answer.
+// Publishes, deliveries and publish latency are sampled at the sample
+// rate; drops and connection churn are always sent.
+type StatsD struct {
+	conn       net.Conn
+	prefix     string
+	sampleRate float64
+	random     func() float64 // Returns [0, 1); replaced in tests
+
+	queue   chan string
+	done    chan struct{}
+	closing sync.Once
+	stopped chan struct{}
+	dropped atomic.Int64
+}
+
+// NewStatsD sends metrics to the statsd server at addr, a host:port,
+// with names starting with prefix. sampleRate, in (0, 1], is the fraction
+// of the high-frequency metrics sent.
+func NewStatsD(addr, prefix string, sampleRate float64) (*StatsD, error) {
+	if sampleRate <= 0 || sampleRate > 1 {
+		return nil, fmt.Errorf("statsd sample rate %g is not in (0, 1]", sampleRate)
+	}
+	// A UDP dial resolves the address but sends nothing, so it succeeds
+	// with the server down
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %w", err)
+	}
+	s := &StatsD{
+		conn:       conn,
+		prefix:     prefix,
+		sampleRate: sampleRate,
+		random:     rand.Float64,
+		queue:      make(chan string, statsdBufferSize),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	go s.write()
+	return s, nil
+}
+
+// Hooks returns the callbacks that feed the sink
+func (s *StatsD) Hooks() Hooks {
+	return Hooks{
+		OnPublish: func(topic string, size int) {
+			if s.sampled() {
+				tags := topicTag(topic)
+				s.send("messages.published", "1", "c", true, tags)
+				s.send("published_bytes", strconv.Itoa(size), "c", true, tags)
+			}
+		},
+		OnDeliver: func(topic, clientID string) {
+			if s.sampled() {
+				s.send("messages.delivered", "1", "c", true, topicTag(topic))
+			}
+		},
+		OnDrop: func(topic, clientID, reason string) {
+			s.send("messages.dropped", "1", "c", false, topicTag(topic)+",reason:"+statsdTagValue(reason))
+		},
+		OnClientConnected: func(clientID string) {
+			s.send("clients.connected", "1", "c", false, "")
+		},
+		OnClientDisconnected: func(clientID string) {
+			s.send("clients.disconnected", "1", "c", false, "")
+		},
+		OnPublishLatency: func(topic string, latency time.Duration) {
+			if s.sampled() {
+				ms := strconv.FormatFloat(float64(latency)/float64(time.Millisecond), 'f', -1, 64)
+				s.send("publish.latency", ms, "ms", true, topicTag(topic))
+			}
+		},
+	}
+}
+
+// Dropped returns how many metric lines were lost to a full buffer or a
+// failed send
+func (s *StatsD) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close sends what is buffered and stops the sink. Metrics after it are
+// discarded.
+func (s *StatsD) Close() error {
+	s.closing.Do(func() { close(s.done) })
+	<-s.stopped
+	return s.conn.Close()
+}
+
+// sampled reports whether to send a sampled metric this time
+func (s *StatsD) sampled() bool {
+	return s.sampleRate >= 1 || s.random() < s.sampleRate
+}
+
+// send queues a metric line, dropping it if the buffer is full. tags is a
+// comma-separated list of name:value pairs, or empty.
+func (s *StatsD) send(name, value, kind string, sampled bool, tags string) {
+	line := s.prefix + name + ":" + value + "|" + kind
+	if sampled && s.sampleRate < 1 {
+		line += "|@" + strconv.FormatFloat(s.sampleRate, 'f', -1, 64)
+	}
+	if tags != "" {
+		line += "|#" + tags
+	}
+
+	select {
+	case <-s.done:
+	case s.queue <- line:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// write batches queued lines into datagrams until the sink is closed
+func (s *StatsD) write() {
+	defer close(s.stopped)
+	packet := make([]byte, 0, statsdMaxPacket)
+	lines := 0
+	flush := func() {
+		if len(packet) == 0 {
+			return
+		}
+		// Writes to an unreachable host fail at once or are lost; either
+		// way nothing waits on them
+		if _, err := s.conn.Write(packet); err != nil {
+			s.dropped.Add(int64(lines))
+		}
+		packet, lines = packet[:0], 0
+	}
+	add := func(line string) {
+		if len(packet) > 0 && len(packet)+1+len(line) > statsdMaxPacket {
+			flush()
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+		lines++
+	}
+
+	for {
+		select {
+		case line := <-s.queue:
+			add(line)
+			// Take whatever else is already waiting into the same datagram
+			for more := true; more; {
+				select {
+				case line := <-s.queue:
+					add(line)
+				default:
+					more = false
+				}
+			}
+			flush()
+		case <-s.done:
+			for {
+				select {
+				case line := <-s.queue:
+					add(line)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// topicTag returns the DogStatsD tag for a topic
+func topicTag(topic string) string {
+	return "topic:" + statsdTagValue(topic)
+}
+
+// statsdTagValue replaces the characters that delimit DogStatsD tags in a
+// tag value
+func statsdTagValue(value string) string {
+	return statsdTagReplacer.Replace(value)
+}
+
+var statsdTagReplacer = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
@@ -0,0 +1,145 @@
+package pubsub
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// statsdListener returns a UDP listener standing in for a statsd server
+func statsdListener(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// statsdLines returns the metric lines a listener has received, waiting
+// until none have arrived for a moment
+func statsdLines(t *testing.T, conn *net.UDPConn) []string {
+	t.Helper()
+	var lines []string
+	buf := make([]byte, 64<<10)
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return lines
+		}
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+	}
+}
+
+func TestStatsDSendsHookMetrics(t *testing.T) {
+	listener := statsdListener(t)
+	sink, err := NewStatsD(listener.LocalAddr().String(), "test.", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := New()
+	ps.AddHooks(sink.Hooks())
+	ctx := context.Background()
+
+	if err := ps.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	reader := &recordingClient{id: "reader"}
+	ps.RegisterClient(reader)
+	subscribeClient(t, ps, "orders", reader)
+	subscribeClient(t, ps, "orders", fullClient{id: "stuck"})
+	if err := ps.Publish(ctx, "orders", MessageData{ID: "m1", Payload: "12345"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	ps.UnregisterClient("reader")
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]bool)
+	var latency string
+	for _, line := range statsdLines(t, listener) {
+		if strings.HasPrefix(line, "test.publish.latency:") {
+			latency = line
+			continue
+		}
+		got[line] = true
+	}
+	for _, want := range []string{
+		"test.clients.connected:1|c",
+		"test.messages.published:1|c|#topic:orders",
+		"test.published_bytes:7|c|#topic:orders",
+		"test.messages.delivered:1|c|#topic:orders",
+		"test.messages.dropped:1|c|#topic:orders,reason:" + DeadLetterBufferEvicted,
+		"test.clients.disconnected:1|c",
+	} {
+		if !got[want] {
+			t.Errorf("no %q in %v", want, got)
+		}
+	}
+	if !strings.HasSuffix(latency, "|ms|#topic:orders") {
+		t.Errorf("publish latency sent as %q", latency)
+	}
+	if n := sink.Dropped(); n != 0 {
+		t.Errorf("%d lines dropped", n)
+	}
+}
+
+func TestStatsDSamplesHighFrequencyMetrics(t *testing.T) {
+	listener := statsdListener(t)
+	sink, err := NewStatsD(listener.LocalAddr().String(), "test.", 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every other draw falls under the rate
+	draws := 0
+	sink.random = func() float64 {
+		draws++
+		if draws%2 == 1 {
+			return 0.25
+		}
+		return 0.75
+	}
+
+	hooks := sink.Hooks()
+	for i := 0; i < 4; i++ {
+		hooks.OnDeliver("a,b|c", "reader")
+		hooks.OnDrop("orders", "stuck", DeadLetterBufferEvicted)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+	for _, line := range statsdLines(t, listener) {
+		counts[line]++
+	}
+	// Sampled counters carry their rate; tag delimiters in a topic are
+	// replaced
+	if n := counts["test.messages.delivered:1|c|@0.5|#topic:a_b_c"]; n != 2 {
+		t.Errorf("%d deliveries sent, want 2: %v", n, counts)
+	}
+	if n := counts["test.messages.dropped:1|c|#topic:orders,reason:"+DeadLetterBufferEvicted]; n != 4 {
+		t.Errorf("%d drops sent, want all 4: %v", n, counts)
+	}
+
+	if _, err := NewStatsD(listener.LocalAddr().String(), "test.", 0); err == nil {
+		t.Error("a zero sample rate was accepted")
+	}
+}
+
+func TestStatsDDropsWhenTheBufferIsFull(t *testing.T) {
+	// No writer drains this sink, as with a writer stuck on the network
+	sink := &StatsD{sampleRate: 1, queue: make(chan string, 2), done: make(chan struct{})}
+	hooks := sink.Hooks()
+	for i := 0; i < 5; i++ {
+		hooks.OnClientConnected("c")
+	}
+	if n := sink.Dropped(); n != 3 {
+		t.Errorf("dropped %d lines, want 3", n)
+	}
+}
@@ -0,0 +1,207 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MaxRequestIDLength is the longest request_id strict parsing accepts
+const MaxRequestIDLength = 128
+
+// ParseMessageStrict decodes an incoming message like ParseMessageWith, but
+// refuses unknown fields and wrong-typed values, and checks required fields
+// and value ranges. Failures are ErrorData with code VALIDATION_FAILED,
+// naming the offending field where there is one.
+func ParseMessageStrict(codec Codec, data []byte) (interface{}, error) {
+	message, err := parseMessage(codec, data, func(v interface{}) error { return strictUnmarshal(codec, data, v) })
+	if err != nil {
+		var coded ErrorData
+		if errors.As(err, &coded) {
+			return nil, err
+		}
+		return nil, decodeFailure(err)
+	}
+	if err := validateMessage(message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// strictUnmarshal decodes data into v with codec, refusing fields v doesn't
+// have
+func strictUnmarshal(codec Codec, data []byte, v interface{}) error {
+	if codec.Binary() {
+		dec := msgpack.NewDecoder(bytes.NewReader(data))
+		dec.SetCustomStructTag("json")
+		dec.DisallowUnknownFields(true)
+		return dec.Decode(v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// validationFailed reports a strict parsing failure of field
+func validationFailed(field, message string) ErrorData {
+	return ErrorData{Code: CodeValidationFailed, Message: message, Field: field}
+}
+
+// decodeFailure describes a strict decoding error, naming the field when
+// the decoder does
+func decodeFailure(err error) ErrorData {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return validationFailed(typeErr.Field, fmt.Sprintf("%s must be %s, got %s", typeErr.Field, kindName(typeErr.Type), typeErr.Value))
+	}
+	// Both decoders report unknown fields as: unknown field "name"
+	if _, quoted, found := strings.Cut(err.Error(), "unknown field "); found {
+		if field, err := strconv.Unquote(quoted); err == nil {
+			return validationFailed(field, fmt.Sprintf("unknown field %q", field))
+		}
+	}
+	return validationFailed("", err.Error())
+}
+
+// kindName describes the values of t for error messages
+func kindName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Ptr:
+		return kindName(t.Elem())
+	default:
+		return "an object"
+	}
+}
+
+// fieldCheck is one strict rule: field is invalid, for reason, when failed
+type fieldCheck struct {
+	field  string
+	failed bool
+	reason string
+}
+
+// requestIDCheck limits a request_id's length
+func requestIDCheck(requestID string) fieldCheck {
+	return fieldCheck{"request_id", len(requestID) > MaxRequestIDLength, fmt.Sprintf("must be at most %d bytes", MaxRequestIDLength)}
+}
+
+// required fails when value is empty
+func required(field, value string) fieldCheck {
+	return fieldCheck{field, value == "", "is required"}
+}
+
+// nonNegative fails when value is below zero
+func nonNegative(field string, value int64) fieldCheck {
+	return fieldCheck{field, value < 0, "must not be negative"}
+}
+
+// validateMessage checks a decoded message's required fields and value
+// ranges, returning the first failure
+func validateMessage(message interface{}) error {
+	var checks []fieldCheck
+	switch msg := message.(type) {
+	case HelloRequest:
+		checks = []fieldCheck{requestIDCheck(msg.RequestID)}
+	case SubscribeRequest:
+		checks = []fieldCheck{
+			requestIDCheck(msg.RequestID),
+			{"topic", msg.Topic == "" && !msg.Firehose, "is required"},
+			nonNegative("last_n", int64(msg.LastN)),
+			nonNegative("since_seq", msg.SinceSeq),
+			nonNegative("ttl_seconds", int64(msg.TTLSeconds)),
+			{"ack_mode", msg.AckMode != "" && msg.AckMode != AckModeAuto && msg.AckMode != AckModeExplicit,
+				fmt.Sprintf("must be %q, %q or empty", AckModeAuto, AckModeExplicit)},
+		}
+	case UnsubscribeRequest:
+		checks = []fieldCheck{
+			requestIDCheck(msg.RequestID),
+			{"topic", msg.Topic == "" && !msg.Firehose, "is required"},
+		}
+	case TouchSubscriptionRequest:
+		checks = []fieldCheck{requestIDCheck(msg.RequestID), required("topic", msg.Topic)}
+	case PublishRequest:
+		checks = []fieldCheck{
+			requestIDCheck(msg.RequestID),
+			required("topic", msg.Topic),
+			required("message.id", msg.Message.ID),
+			nonNegative("delay_ms", msg.DelayMs),
+			nonNegative("timeout_ms", msg.TimeoutMs),
+			{"ack", msg.Ack != "" && msg.Ack != PublishAckNone && msg.Ack != PublishAckBatch,
+				fmt.Sprintf("must be %q, %q or empty", PublishAckNone, PublishAckBatch)},
+		}
+	case MsgAckRequest:
+		checks = []fieldCheck{
+			requestIDCheck(msg.RequestID),
+			required("topic", msg.Topic),
+			nonNegative("delivery_tag", msg.DeliveryTag),
+			nonNegative("up_to_seq", msg.UpToSeq),
+		}
+	case SendToClientRequest:
+		checks = []fieldCheck{
+			requestIDCheck(msg.RequestID),
+			required("target_client_id", msg.Target),
+			required("message.id", msg.Message.ID),
+		}
+	case CancelScheduledRequest:
+		checks = []fieldCheck{requestIDCheck(msg.RequestID), required("token", msg.Token)}
+	case GetHistoryRequest:
+		checks = []fieldCheck{
+			requestIDCheck(msg.RequestID),
+			required("topic", msg.Topic),
+			nonNegative("limit", int64(msg.Limit)),
+			nonNegative("before_seq", msg.BeforeSeq),
+		}
+	case TopicRequest:
+		checks = []fieldCheck{
+			requestIDCheck(msg.RequestID),
+			required("topic", msg.Topic),
+			{"new_owner", msg.Type == "transfer_topic" && msg.NewOwner == "", "is required"},
+		}
+	case JoinDecisionRequest:
+		checks = []fieldCheck{requestIDCheck(msg.RequestID), required("topic", msg.Topic), required("client_id", msg.ClientID)}
+	case PauseRequest:
+		checks = []fieldCheck{requestIDCheck(msg.RequestID), required("topic", msg.Topic)}
+	case ResumeRequest:
+		checks = []fieldCheck{requestIDCheck(msg.RequestID), required("topic", msg.Topic)}
+	case PingRequest:
+		checks = []fieldCheck{requestIDCheck(msg.RequestID)}
+	case ProbeAckRequest:
+		checks = []fieldCheck{required("probe_id", msg.ProbeID)}
+	case ChunkBegin:
+		checks = []fieldCheck{
+			requestIDCheck(msg.RequestID),
+			required("topic", msg.Topic),
+			required("message_id", msg.MessageID),
+			{"total_size", msg.TotalSize <= 0, "must be positive"},
+			{"chunks", msg.Chunks <= 0, "must be positive"},
+		}
+	case Chunk:
+		checks = []fieldCheck{required("message_id", msg.MessageID), nonNegative("index", int64(msg.Index))}
+	case ChunkEnd:
+		checks = []fieldCheck{required("message_id", msg.MessageID)}
+	}
+
+	for _, check := range checks {
+		if check.failed {
+			return validationFailed(check.field, check.field+" "+check.reason)
+		}
+	}
+	return nil
+}
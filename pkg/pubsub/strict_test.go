@@ -0,0 +1,122 @@
+package pubsub
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// conformanceCorpus is a set of frames, mostly malformed, each with what
+// lenient parsing does and the field strict parsing refuses ("" accepts it)
+var conformanceCorpus = []struct {
+	name       string
+	frame      string
+	lenientErr bool   // ParseMessageWith fails
+	field      string // Field named by ParseMessageStrict's VALIDATION_FAILED, or "" for success
+}{
+	{"valid subscribe", `{"type":"subscribe","topic":"orders","last_n":5,"request_id":"r-1"}`, false, ""},
+	{"valid publish", `{"type":"publish","topic":"orders","message":{"id":"m-1","payload":{"a":1}},"request_id":"r-1"}`, false, ""},
+	{"valid firehose", `{"type":"subscribe","firehose":true}`, false, ""},
+	{"valid transfer", `{"type":"transfer_topic","topic":"orders","new_owner":"bob"}`, false, ""},
+
+	{"unknown field", `{"type":"subscribe","topic":"orders","lastN":5}`, false, "lastN"},
+	{"unknown nested field", `{"type":"publish","topic":"orders","message":{"id":"m-1","body":"x"}}`, false, "body"},
+	{"unknown hello field", `{"type":"hello","protocol_version":2,"compression":"zstd"}`, false, "compression"},
+
+	{"string last_n", `{"type":"subscribe","topic":"orders","last_n":"5"}`, true, "last_n"},
+	{"fractional limit", `{"type":"get_history","topic":"orders","limit":1.5}`, true, "limit"},
+	{"numeric topic", `{"type":"unsubscribe","topic":7}`, true, "topic"},
+	{"object request_id", `{"type":"ping","request_id":{}}`, true, "request_id"},
+	{"string store", `{"type":"publish","topic":"orders","message":{"id":"m-1"},"store":"no"}`, true, "store"},
+	{"numeric message id", `{"type":"publish","topic":"orders","message":{"id":1}}`, true, "message.id"},
+
+	{"negative last_n", `{"type":"subscribe","topic":"orders","last_n":-1}`, false, "last_n"},
+	{"negative delay", `{"type":"publish","topic":"orders","message":{"id":"m-1"},"delay_ms":-5}`, false, "delay_ms"},
+	{"negative history limit", `{"type":"get_history","topic":"orders","limit":-10}`, false, "limit"},
+	{"negative chunk index", `{"type":"publish_chunk","message_id":"m-1","index":-1,"data":""}`, false, "index"},
+	{"zero chunks", `{"type":"publish_begin","topic":"orders","message_id":"m-1","total_size":10,"chunks":0}`, false, "chunks"},
+	{"unknown ack mode", `{"type":"subscribe","topic":"orders","ack_mode":"manual"}`, false, "ack_mode"},
+	{"unknown publish ack", `{"type":"publish","topic":"orders","message":{"id":"m-1"},"ack":"later"}`, false, "ack"},
+
+	{"missing topic", `{"type":"subscribe","last_n":3}`, false, "topic"},
+	{"empty publish topic", `{"type":"publish","topic":"","message":{"id":"m-1"}}`, false, "topic"},
+	{"missing message id", `{"type":"publish","topic":"orders","message":{"payload":1}}`, false, "message.id"},
+	{"missing target", `{"type":"send_to_client","message":{"id":"m-1"}}`, false, "target_client_id"},
+	{"missing new owner", `{"type":"transfer_topic","topic":"orders"}`, false, "new_owner"},
+	{"missing probe id", `{"type":"probe_ack"}`, false, "probe_id"},
+	{"long request_id", `{"type":"ping","request_id":"` + strings.Repeat("x", MaxRequestIDLength+1) + `"}`, false, "request_id"},
+}
+
+func TestParseConformance(t *testing.T) {
+	for _, tc := range conformanceCorpus {
+		t.Run(tc.name, func(t *testing.T) {
+			lenient, err := ParseMessageWith(JSONCodec, []byte(tc.frame))
+			if (err != nil) != tc.lenientErr {
+				t.Errorf("lenient parse = %v, %v; want error %v", lenient, err, tc.lenientErr)
+			}
+
+			strict, err := ParseMessageStrict(JSONCodec, []byte(tc.frame))
+			if tc.field == "" {
+				if err != nil {
+					t.Fatalf("strict parse failed: %v", err)
+				}
+				if !tc.lenientErr && !reflect.DeepEqual(strict, lenient) {
+					t.Errorf("strict parse = %+v, lenient %+v", strict, lenient)
+				}
+				return
+			}
+			var coded ErrorData
+			if !errors.As(err, &coded) || coded.Code != "VALIDATION_FAILED" {
+				t.Fatalf("strict parse = %v, %v; want VALIDATION_FAILED", strict, err)
+			}
+			if coded.Field != tc.field || !strings.Contains(coded.Message, tc.field) {
+				t.Errorf("strict error = %+v, want field %s", coded, tc.field)
+			}
+		})
+	}
+}
+
+func TestParseStrictSharesLenientErrors(t *testing.T) {
+	for _, frame := range []string{`{"type":"launch"}`, `{"type":`, `[]`} {
+		_, lenientErr := ParseMessageWith(JSONCodec, []byte(frame))
+		_, strictErr := ParseMessageStrict(JSONCodec, []byte(frame))
+		if lenientErr == nil || strictErr == nil {
+			t.Errorf("%s: lenient %v, strict %v; want both to fail", frame, lenientErr, strictErr)
+		}
+	}
+	var coded ErrorData
+	if _, err := ParseMessageStrict(JSONCodec, []byte(`{"type":"launch"}`)); !errors.As(err, &coded) || coded.Reason != "INVALID_MESSAGE_TYPE" {
+		t.Errorf("unknown type = %v, want INVALID_MESSAGE_TYPE", err)
+	}
+}
+
+func TestParseStrictMsgpack(t *testing.T) {
+	encode := func(v interface{}) []byte {
+		t.Helper()
+		data, err := MsgpackCodec.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	valid := encode(map[string]interface{}{"type": "subscribe", "topic": "orders", "last_n": 2})
+	if msg, err := ParseMessageStrict(MsgpackCodec, valid); err != nil || msg.(SubscribeRequest).LastN != 2 {
+		t.Errorf("valid frame = %+v, %v", msg, err)
+	}
+
+	unknown := encode(map[string]interface{}{"type": "subscribe", "topic": "orders", "lastN": 2})
+	if _, err := ParseMessageWith(MsgpackCodec, unknown); err != nil {
+		t.Errorf("lenient parse of an unknown field: %v", err)
+	}
+	var coded ErrorData
+	if _, err := ParseMessageStrict(MsgpackCodec, unknown); !errors.As(err, &coded) || coded.Field != "lastN" {
+		t.Errorf("strict parse of an unknown field = %v", err)
+	}
+
+	negative := encode(map[string]interface{}{"type": "subscribe", "topic": "orders", "last_n": -2})
+	if _, err := ParseMessageStrict(MsgpackCodec, negative); !errors.As(err, &coded) || coded.Field != "last_n" {
+		t.Errorf("strict parse of a negative last_n = %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package pubsub
+
+import "sort"
+
+// TopicSubscribers returns the client IDs subscribed to a topic, sorted.
+// An unknown topic has none.
+func (ps *PubSubSystem) TopicSubscribers(topicName string) []string {
+	topic, exists := ps.topics.get(topicName)
+	if !exists {
+		return []string{}
+	}
+
+	topic.mutex.RLock()
+	clients := make([]string, 0, len(topic.Subscribers))
+	for clientID := range topic.Subscribers {
+		clients = append(clients, clientID)
+	}
+	topic.mutex.RUnlock()
+	sort.Strings(clients)
+	return clients
+}
+
+// IsSubscribed reports whether clientID is subscribed to a topic
+func (ps *PubSubSystem) IsSubscribed(clientID, topicName string) bool {
+	ps.clientMutex.RLock()
+	defer ps.clientMutex.RUnlock()
+	return ps.clientTopics[clientID][topicName]
+}
+
+// ListSubscriptions returns GetSubscriptionsStatus with clients in ID
+// order and topics in name order, each list cut to the same page of limit
+// entries from offset; limit 0 returns everything from offset on. The
+// totals count every client and topic.
+func (ps *PubSubSystem) ListSubscriptions(offset, limit int) SubscriptionsStatusResponse {
+	// Build client subscriptions list, releasing clientMutex before any topic
+	// lock is taken (DeleteTopic takes them in the opposite order)
+	ps.clientMutex.RLock()
+	clientIDs := make([]string, 0, len(ps.clientTopics))
+	for clientID := range ps.clientTopics {
+		clientIDs = append(clientIDs, clientID)
+	}
+	sort.Strings(clientIDs)
+	clientIDs = page(clientIDs, offset, limit)
+	subscriptions := make([]ClientSubscription, 0, len(clientIDs))
+	for _, clientID := range clientIDs {
+		topicsMap := ps.clientTopics[clientID]
+		topics := make([]string, 0, len(topicsMap))
+		for topic := range topicsMap {
+			topics = append(topics, topic)
+		}
+		sort.Strings(topics)
+		subscriptions = append(subscriptions, ClientSubscription{
+			ClientID:     clientID,
+			Topics:       topics,
+			TTLRemaining: ps.ttls.remaining(clientID, topics),
+		})
+	}
+	totalClients := len(ps.clientTopics)
+	ps.clientMutex.RUnlock()
+
+	// Build topic breakdown (topic -> list of client_ids)
+	var topicNames []string
+	ps.topics.each(func(topic *Topic) {
+		topicNames = append(topicNames, topic.Name)
+	})
+	sort.Strings(topicNames)
+	totalTopics := len(topicNames)
+	topicBreakdown := make(map[string][]string)
+	for _, name := range page(topicNames, offset, limit) {
+		topicBreakdown[name] = ps.TopicSubscribers(name)
+	}
+
+	return SubscriptionsStatusResponse{
+		TotalClients:   totalClients,
+		TotalTopics:    totalTopics,
+		TotalCount:     totalClients,
+		Subscriptions:  subscriptions,
+		TopicBreakdown: topicBreakdown,
+	}
+}
+
+// GetClientSubscriptions returns the subscription status of one client: its
+// topics, or only topicName when that is set. An unknown client has none.
+func (ps *PubSubSystem) GetClientSubscriptions(clientID, topicName string) SubscriptionsStatusResponse {
+	var topics []string
+	if topicName != "" {
+		if ps.IsSubscribed(clientID, topicName) {
+			topics = []string{topicName}
+		}
+	} else {
+		topics = ps.GetClientTopics(clientID)
+		sort.Strings(topics)
+	}
+
+	resp := SubscriptionsStatusResponse{
+		TotalCount:     len(topics),
+		Subscriptions:  []ClientSubscription{},
+		TopicBreakdown: map[string][]string{},
+	}
+	if len(topics) > 0 {
+		resp.TotalClients = 1
+		resp.TotalTopics = len(topics)
+		resp.Subscriptions = append(resp.Subscriptions, ClientSubscription{ClientID: clientID, Topics: topics, TTLRemaining: ps.ttls.remaining(clientID, topics)})
+	}
+	return resp
+}
+
+// GetTopicSubscriptions returns the subscription status of one topic: its
+// subscribers. An unknown topic has none.
+func (ps *PubSubSystem) GetTopicSubscriptions(topicName string) SubscriptionsStatusResponse {
+	clients := ps.TopicSubscribers(topicName)
+	resp := SubscriptionsStatusResponse{
+		TotalClients:   len(clients),
+		TotalCount:     len(clients),
+		Subscriptions:  []ClientSubscription{},
+		TopicBreakdown: map[string][]string{},
+	}
+	if _, exists := ps.topics.get(topicName); exists {
+		resp.TotalTopics = 1
+		resp.TopicBreakdown[topicName] = clients
+	}
+	return resp
+}
+
+// page returns the window of items starting at offset, at most limit long;
+// limit 0 means no maximum
+func page(items []string, offset, limit int) []string {
+	items = items[min(offset, len(items)):]
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items
+}
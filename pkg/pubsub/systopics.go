@@ -0,0 +1,143 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// SystemTopicPrefix starts the name of every topic the broker owns.
+	// Clients may subscribe to system topics but not publish to, change or
+	// delete them, and no client-created topic may start with "$".
+	SystemTopicPrefix = "$sys/"
+
+	SysTopicsTopic  = SystemTopicPrefix + "topics"  // Topic creations and deletions
+	SysClientsTopic = SystemTopicPrefix + "clients" // Client connections and disconnections
+	SysStatsTopic   = SystemTopicPrefix + "stats"   // Periodic GetStats snapshots
+
+	// DefaultSysStatsInterval is how often $sys/stats gets a snapshot
+	DefaultSysStatsInterval = 10 * time.Second
+)
+
+// ErrPermissionDenied is returned when a client publishes to, changes or
+// deletes a system topic, or creates a topic with a reserved name
+var ErrPermissionDenied = errors.New("permission denied")
+
+// SystemEvent is the payload of events on $sys/topics and $sys/clients
+type SystemEvent struct {
+	Event     string `json:"event"` // topic_created, topic_deleted, client_connected or client_disconnected
+	Namespace string `json:"namespace,omitempty"`
+	Topic     string `json:"topic,omitempty"`
+	Actor     string `json:"actor,omitempty"` // Who created or deleted the topic, when known
+	ClientID  string `json:"client_id,omitempty"`
+}
+
+// systemTopics are the broker-owned topics and what stops the stats ticker
+type systemTopics struct {
+	topics  *Topic
+	clients *Topic
+	stats   *Topic
+	stop    chan struct{}
+}
+
+// actorKey is the context key for WithActor
+type actorKey struct{}
+
+// WithActor returns a context that attributes topic changes made with it
+// to actor, e.g. an authenticated user or a remote address
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set by WithActor, or ""
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// IsSystemTopic reports whether an internal topic name is reserved for the
+// broker
+func IsSystemTopic(name string) bool {
+	return strings.HasPrefix(LocalTopic(name), "$")
+}
+
+// checkUserTopic fails for topics clients may not publish to or change
+func checkUserTopic(name string) error {
+	if IsSystemTopic(name) {
+		return fmt.Errorf("%w: %s is a system topic", ErrPermissionDenied, name)
+	}
+	return nil
+}
+
+// EnableSystemTopics creates the $sys topics and starts publishing to them;
+// $sys/stats gets a snapshot every statsInterval. Call before the server
+// starts accepting clients.
+func (ps *PubSubSystem) EnableSystemTopics(statsInterval time.Duration) {
+	if statsInterval <= 0 {
+		statsInterval = DefaultSysStatsInterval
+	}
+
+	sys := &systemTopics{
+		topics:  ps.newTopic(SysTopicsTopic, 0),
+		clients: ps.newTopic(SysClientsTopic, 0),
+		stats:   ps.newTopic(SysStatsTopic, 0),
+		stop:    make(chan struct{}),
+	}
+	for _, topic := range []*Topic{sys.topics, sys.clients, sys.stats} {
+		ps.topics.set(topic)
+		ps.emit(hookEvent{kind: hookTopicCreated, topic: topic.Name})
+	}
+	ps.sys.Store(sys)
+
+	go func() {
+		ticker := ps.clock.NewTicker(statsInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				stats := ps.GetStats()
+				ps.publishSystem(sys.stats, stats)
+			case <-sys.stop:
+				return
+			}
+		}
+	}()
+}
+
+// announceTopic publishes a topic creation or deletion to $sys/topics
+func (ps *PubSubSystem) announceTopic(ctx context.Context, event, name string) {
+	sys := ps.sys.Load()
+	if sys == nil {
+		return
+	}
+	ns, local := SplitTopic(name)
+	ps.publishSystem(sys.topics, SystemEvent{
+		Event:     event,
+		Namespace: ns,
+		Topic:     local,
+		Actor:     ActorFromContext(ctx),
+	})
+}
+
+// announceClient publishes a client connection or disconnection to
+// $sys/clients
+func (ps *PubSubSystem) announceClient(event, clientID string) {
+	if sys := ps.sys.Load(); sys != nil {
+		ps.publishSystem(sys.clients, SystemEvent{Event: event, ClientID: clientID})
+	}
+}
+
+// publishSystem publishes payload to a system topic. Callers must not hold
+// broker locks.
+func (ps *PubSubSystem) publishSystem(topic *Topic, payload interface{}) {
+	message := MessageData{ID: uuid.New().String(), Payload: payload}
+	if _, err := ps.publishToTopic(context.Background(), topic, message, ps.payloadSize(payload), "", PublishOptions{}); err != nil {
+		log.Printf("Error publishing to %s: %v", topic.Name, err)
+	}
+}
@@ -0,0 +1,124 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// systemEvents returns the payloads of the events sent to a $sys subscriber
+func systemEvents(c *recordingClient) []SystemEvent {
+	var events []SystemEvent
+	for _, event := range c.received() {
+		if payload, ok := event.Message.Payload.(SystemEvent); ok {
+			events = append(events, payload)
+		}
+	}
+	return events
+}
+
+func TestSysTopicsAnnouncesTopicChanges(t *testing.T) {
+	ps := New()
+	ps.EnableSystemTopics(time.Hour)
+	t.Cleanup(ps.Close)
+	ctx := context.Background()
+	monitor := &recordingClient{id: "monitor"}
+	subscribeClient(t, ps, SysTopicsTopic, monitor)
+
+	if err := ps.CreateTopic(WithActor(ctx, "alice"), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.DeleteTopic(WithActor(ctx, "bob"), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	monitor.waitEvents(t, 2)
+	want := []SystemEvent{
+		{Event: "topic_created", Namespace: DefaultNamespace, Topic: "orders", Actor: "alice"},
+		{Event: "topic_deleted", Namespace: DefaultNamespace, Topic: "orders", Actor: "bob"},
+	}
+	if got := systemEvents(monitor); !reflect.DeepEqual(got, want) {
+		t.Errorf("$sys/topics got %+v, want %+v", got, want)
+	}
+
+	// Namespaced topics are announced by namespace and local name
+	name, err := QualifyTopic("acme", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateTopic(ctx, name); err != nil {
+		t.Fatal(err)
+	}
+	monitor.waitEvents(t, 3)
+	if got := systemEvents(monitor)[2]; got != (SystemEvent{Event: "topic_created", Namespace: "acme", Topic: "orders"}) {
+		t.Errorf("namespaced topic announced as %+v", got)
+	}
+}
+
+func TestSysClientsAnnouncesConnections(t *testing.T) {
+	ps := New()
+	ps.EnableSystemTopics(time.Hour)
+	t.Cleanup(ps.Close)
+	monitor := &recordingClient{id: "monitor"}
+	subscribeClient(t, ps, SysClientsTopic, monitor)
+
+	ps.RegisterClient(&recordingClient{id: "dashboard"})
+	ps.UnregisterClient("dashboard")
+	monitor.waitEvents(t, 2)
+	want := []SystemEvent{
+		{Event: "client_connected", ClientID: "dashboard"},
+		{Event: "client_disconnected", ClientID: "dashboard"},
+	}
+	if got := systemEvents(monitor); !reflect.DeepEqual(got, want) {
+		t.Errorf("$sys/clients got %+v, want %+v", got, want)
+	}
+}
+
+func TestSysStatsSnapshots(t *testing.T) {
+	ps := New()
+	ps.EnableSystemTopics(10 * time.Millisecond)
+	t.Cleanup(ps.Close)
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	monitor := &recordingClient{id: "monitor"}
+	subscribeClient(t, ps, SysStatsTopic, monitor)
+
+	events := monitor.waitEvents(t, 2)
+	stats, ok := events[0].Message.Payload.(StatsResponse)
+	if !ok {
+		t.Fatalf("$sys/stats event payload is %T", events[0].Message.Payload)
+	}
+	if _, ok := stats.Topics["orders"]; !ok {
+		t.Errorf("snapshot %+v is missing orders", stats)
+	}
+}
+
+func TestSystemTopicsAreReadOnly(t *testing.T) {
+	ps := New()
+	ps.EnableSystemTopics(time.Hour)
+	t.Cleanup(ps.Close)
+	ctx := context.Background()
+
+	for name, err := range map[string]error{
+		"publishing to $sys/topics": ps.Publish(ctx, SysTopicsTopic, MessageData{ID: "m", Payload: "fake"}, ""),
+		"deleting $sys/stats":       ps.DeleteTopic(ctx, SysStatsTopic),
+		"creating $sys/mine":        ps.CreateTopic(ctx, SystemTopicPrefix+"mine"),
+		"creating $orders":          ps.CreateTopic(ctx, "$orders"),
+	} {
+		if !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("%s = %v", name, err)
+		}
+	}
+
+	// The system topics are still there, and are listed like any other
+	for _, name := range []string{SysTopicsTopic, SysClientsTopic, SysStatsTopic} {
+		if _, err := ps.GetTopicDetail(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if !IsSystemTopic(SysTopicsTopic) || IsSystemTopic("orders") {
+		t.Error("IsSystemTopic misclassifies topics")
+	}
+}
@@ -0,0 +1,146 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var (
+	// ErrInvalidTopicLimits is returned for a negative per-topic limit
+	ErrInvalidTopicLimits = errors.New("invalid topic limits")
+
+	// ErrTopicRateLimited is returned when a topic is over its publish rate
+	ErrTopicRateLimited = errors.New("topic publish rate limit exceeded")
+)
+
+// topicRate is a topic's publish token bucket, shared by every publisher.
+// It bursts up to one second's worth. Guarded by the topic mutex.
+type topicRate struct {
+	limit  float64 // Messages per second
+	tokens float64
+	last   time.Time
+}
+
+// newTopicRate returns a full bucket for limit, or nil when limit is 0. It
+// is refilled from the first publish's timestamp, so needs no clock.
+func newTopicRate(limit float64) *topicRate {
+	if limit <= 0 {
+		return nil
+	}
+	rate := &topicRate{limit: limit}
+	rate.tokens = rate.burst()
+	return rate
+}
+
+// burst is how many messages the bucket allows at once
+func (r *topicRate) burst() float64 {
+	return math.Max(1, math.Ceil(r.limit))
+}
+
+// allow takes a token, reporting false when the bucket is empty
+func (r *topicRate) allow(now time.Time) bool {
+	r.tokens = math.Min(r.burst(), r.tokens+now.Sub(r.last).Seconds()*r.limit)
+	r.last = now
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+// checkTopicLimits rejects negative limits
+func checkTopicLimits(maxPayloadBytes int, maxPublishRate float64) error {
+	if maxPayloadBytes < 0 {
+		return fmt.Errorf("%w: max_payload_bytes %d is negative", ErrInvalidTopicLimits, maxPayloadBytes)
+	}
+	if maxPublishRate < 0 || math.IsNaN(maxPublishRate) || math.IsInf(maxPublishRate, 0) {
+		return fmt.Errorf("%w: max_publish_rate %g must be a non-negative number", ErrInvalidTopicLimits, maxPublishRate)
+	}
+	return nil
+}
+
+// setLimits applies a topic's payload and rate overrides. A rate change
+// keeps the tokens already earned, up to the new burst, so tightening the
+// limit takes effect at once. Callers must hold the mutex or own the topic
+// exclusively.
+func (topic *Topic) setLimits(maxPayloadBytes int, maxPublishRate float64) {
+	topic.MaxPayloadBytes = maxPayloadBytes
+	switch {
+	case maxPublishRate <= 0:
+		topic.rate = nil
+	case topic.rate == nil:
+		topic.rate = newTopicRate(maxPublishRate)
+	default:
+		topic.rate.limit = maxPublishRate
+		topic.rate.tokens = math.Min(topic.rate.tokens, topic.rate.burst())
+	}
+}
+
+// maxPublishRate returns the topic's rate override, 0 for none. Callers
+// must hold the mutex.
+func (topic *Topic) maxPublishRate() float64 {
+	if topic.rate == nil {
+		return 0
+	}
+	return topic.rate.limit
+}
+
+// SetTopicLimits changes a topic's payload size and publish rate overrides;
+// a nil argument leaves that limit alone and 0 falls back to the server
+// default
+func (ps *PubSubSystem) SetTopicLimits(ctx context.Context, name string, maxPayloadBytes *int, maxPublishRate *float64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := checkUserTopic(name); err != nil {
+		return err
+	}
+	topic, exists := ps.topics.get(name)
+	if !exists {
+		return errorOf(ErrTopicNotFound, "topic %s not found", name)
+	}
+
+	topic.mutex.Lock()
+	payload, rate := topic.MaxPayloadBytes, topic.maxPublishRate()
+	if maxPayloadBytes != nil {
+		payload = *maxPayloadBytes
+	}
+	if maxPublishRate != nil {
+		rate = *maxPublishRate
+	}
+	if err := checkTopicLimits(payload, rate); err != nil {
+		topic.mutex.Unlock()
+		return err
+	}
+	topic.setLimits(payload, rate)
+	config := topic.config()
+	createdAt := topic.CreatedAt
+	topic.mutex.Unlock()
+
+	ps.notePayloadLimit(payload)
+	if ps.store != nil {
+		ps.store.TopicCreated(name, createdAt, config)
+	}
+	return nil
+}
+
+// notePayloadLimit raises the largest payload limit any topic has had, so
+// transports can accept frames big enough for it
+func (ps *PubSubSystem) notePayloadLimit(maxPayloadBytes int) {
+	for {
+		largest := ps.largestTopicPayload.Load()
+		if int64(maxPayloadBytes) <= largest || ps.largestTopicPayload.CompareAndSwap(largest, int64(maxPayloadBytes)) {
+			return
+		}
+	}
+}
+
+// MaxPublishPayloadBytes returns the largest payload any publish may carry:
+// the server limit, or a bigger per-topic override. Overrides lowered since
+// are still counted, so it only suits sizing read buffers.
+func (ps *PubSubSystem) MaxPublishPayloadBytes() int {
+	return max(ps.PayloadLimits().MaxBytes, int(ps.largestTopicPayload.Load()))
+}
@@ -0,0 +1,147 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTopicPayloadLimit(t *testing.T) {
+	ps := New()
+	defer ps.Close()
+	ctx := context.Background()
+	ps.SetPayloadLimits(PayloadLimits{MaxBytes: 1000})
+	if err := ps.CreateTopicWithConfig(ctx, "telemetry", TopicConfig{MaxPayloadBytes: 100000}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateTopicWithConfig(ctx, "chat", TopicConfig{MaxPayloadBytes: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateTopic(ctx, "plain"); err != nil {
+		t.Fatal(err)
+	}
+	publish := func(topic string, size int) error {
+		return ps.Publish(ctx, topic, MessageData{ID: "m", Payload: strings.Repeat("x", size)}, "")
+	}
+	tooLarge := func(err error, limit int) bool {
+		var limitErr *PayloadLimitError
+		return errors.As(err, &limitErr) && limitErr.Code == "PAYLOAD_TOO_LARGE" && limitErr.Limit == limit
+	}
+
+	// Overrides apply in both directions; other topics keep the server limit
+	if err := publish("telemetry", 50000); err != nil {
+		t.Errorf("publishing under a raised limit: %v", err)
+	}
+	if err := publish("chat", 500); !tooLarge(err, 100) {
+		t.Errorf("publishing over a lowered limit: %v", err)
+	}
+	if err := publish("plain", 5000); !tooLarge(err, 1000) {
+		t.Errorf("publishing over the server limit: %v", err)
+	}
+	if got := ps.MaxPublishPayloadBytes(); got != 100000 {
+		t.Errorf("MaxPublishPayloadBytes = %d, want 100000", got)
+	}
+
+	// 0 restores the server default
+	zero := 0
+	if err := ps.SetTopicLimits(ctx, "telemetry", &zero, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := publish("telemetry", 50000); !tooLarge(err, 1000) {
+		t.Errorf("publishing after clearing the override: %v", err)
+	}
+	negative := -1
+	if err := ps.SetTopicLimits(ctx, "chat", &negative, nil); !errors.Is(err, ErrInvalidTopicLimits) {
+		t.Errorf("setting a negative limit: %v", err)
+	}
+	if detail, _ := ps.GetTopicDetail("chat"); detail.MaxPayloadBytes != 100 {
+		t.Errorf("detail max_payload_bytes = %d", detail.MaxPayloadBytes)
+	}
+}
+
+func TestTopicPublishRate(t *testing.T) {
+	ps := New()
+	defer ps.Close()
+	ctx := context.Background()
+	if err := ps.CreateTopicWithConfig(ctx, "chat", TopicConfig{MaxPublishRate: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateTopic(ctx, "other"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The bucket bursts one second's worth, shared by every publisher
+	for i := 0; i < 5; i++ {
+		if err := ps.Publish(ctx, "chat", MessageData{ID: "m"}, fmt.Sprintf("client-%d", i)); err != nil {
+			t.Fatalf("publish %d: %v", i, err)
+		}
+	}
+	if err := ps.Publish(ctx, "chat", MessageData{ID: "m"}, "client-z"); !errors.Is(err, ErrTopicRateLimited) {
+		t.Errorf("publishing over the rate: %v", err)
+	}
+	publishN(t, ps, "other", 20)
+	if detail, _ := ps.GetTopicDetail("chat"); detail.MaxPublishRate != 5 || detail.MessageCount != 5 {
+		t.Errorf("detail rate %g, count %d", detail.MaxPublishRate, detail.MessageCount)
+	}
+}
+
+func TestTopicPublishRateTightenedUnderLoad(t *testing.T) {
+	ps := New()
+	defer ps.Close()
+	ctx := context.Background()
+	if err := ps.CreateTopicWithConfig(ctx, "chat", TopicConfig{MaxPublishRate: 100000}); err != nil {
+		t.Fatal(err)
+	}
+
+	var accepted, limited atomic.Int64
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				err := ps.Publish(ctx, "chat", MessageData{ID: "m"}, "")
+				switch {
+				case err == nil:
+					accepted.Add(1)
+				case errors.Is(err, ErrTopicRateLimited):
+					limited.Add(1)
+				default:
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	waitFor(t, "publishes to flow", func() bool { return accepted.Load() > 100 })
+
+	rate := 2.0
+	if err := ps.SetTopicLimits(ctx, "chat", nil, &rate); err != nil {
+		t.Fatal(err)
+	}
+	before := accepted.Load()
+	time.Sleep(500 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	// Two tokens of burst plus at most one earned in half a second, and
+	// per publisher one publish that may have been past the check when the
+	// limit changed
+	if after := accepted.Load() - before; after > 3+4 {
+		t.Errorf("%d publishes accepted after tightening to %g per second", after, rate)
+	}
+	if limited.Load() == 0 {
+		t.Error("no publish was rate limited")
+	}
+}
@@ -0,0 +1,118 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sort keys for ListTopics
+const (
+	TopicSortName        = "name"
+	TopicSortSubscribers = "subscribers"
+	TopicSortMessages    = "messages"
+	TopicSortCreatedAt   = "created_at"
+)
+
+// ErrInvalidTopicSort is returned for an unknown TopicListQuery.Sort
+var ErrInvalidTopicSort = errors.New("invalid topic sort")
+
+// TopicListQuery selects a page of a namespace's topics
+type TopicListQuery struct {
+	Prefix     string            // Only topics whose name starts with Prefix
+	Labels     map[string]string // Only topics with all of these labels
+	Sort       string            // One of the TopicSort keys; defaults to name
+	Descending bool
+	Offset     int
+	Limit      int // 0 returns every topic from Offset on
+}
+
+// topicListEntry is what a listing sorts by, copied under the topic lock
+type topicListEntry struct {
+	topic        *Topic
+	name         string
+	subscribers  int
+	messageCount int64
+	createdAt    time.Time
+}
+
+// ListTopics returns a page of the topics in namespace ns, named without
+// the namespace, and how many topics match in total. Only the fields
+// sorted by are copied while scanning; the rest are read for the page
+// alone, so listing a huge namespace costs little more than counting it.
+func (ps *PubSubSystem) ListTopics(ns string, q TopicListQuery) ([]TopicInfo, int, error) {
+	var less func(a, b *topicListEntry) bool
+	switch q.Sort {
+	case "", TopicSortName:
+		less = func(a, b *topicListEntry) bool { return false }
+	case TopicSortSubscribers:
+		less = func(a, b *topicListEntry) bool { return a.subscribers < b.subscribers }
+	case TopicSortMessages:
+		less = func(a, b *topicListEntry) bool { return a.messageCount < b.messageCount }
+	case TopicSortCreatedAt:
+		less = func(a, b *topicListEntry) bool { return a.createdAt.Before(b.createdAt) }
+	default:
+		return nil, 0, fmt.Errorf("%w: %q", ErrInvalidTopicSort, q.Sort)
+	}
+
+	var entries []topicListEntry
+	ps.topics.each(func(topic *Topic) {
+		topicNS, name := SplitTopic(topic.Name)
+		if topicNS != ns || !strings.HasPrefix(name, q.Prefix) {
+			return
+		}
+		topic.mutex.RLock()
+		if !matchLabels(topic.Labels, q.Labels) {
+			topic.mutex.RUnlock()
+			return
+		}
+		entries = append(entries, topicListEntry{
+			topic:        topic,
+			name:         name,
+			subscribers:  len(topic.Subscribers),
+			messageCount: topic.MessageCount,
+			createdAt:    topic.CreatedAt,
+		})
+		topic.mutex.RUnlock()
+	})
+
+	// Ties fall back to the name so pages don't overlap
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := &entries[i], &entries[j]
+		if q.Descending {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return a.name < b.name
+	})
+
+	total := len(entries)
+	page := entries[min(q.Offset, total):]
+	if q.Limit > 0 && len(page) > q.Limit {
+		page = page[:q.Limit]
+	}
+
+	topics := make([]TopicInfo, 0, len(page))
+	for _, entry := range page {
+		entry.topic.mutex.RLock()
+		topics = append(topics, TopicInfo{
+			Name:          entry.name,
+			Subscribers:   entry.subscribers,
+			MessageCount:  entry.messageCount,
+			CreatedAt:     entry.createdAt,
+			Description:   entry.topic.Description,
+			Labels:        entry.topic.Labels,
+			Paused:        entry.topic.paused(),
+			TopicActivity: entry.topic.activity.Snapshot(entry.topic.LastPublishedAt),
+		})
+		entry.topic.mutex.RUnlock()
+	}
+	return topics, total, nil
+}
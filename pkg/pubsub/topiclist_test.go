@@ -0,0 +1,124 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listNames returns the names of a listing, comma-separated
+func listNames(topics []TopicInfo) string {
+	names := make([]string, len(topics))
+	for i, topic := range topics {
+		names[i] = topic.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func TestListTopicsPaging(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	for _, name := range []string{"conv-5", "conv-2", "other", "conv-0", "conv-6", "conv-1", "conv-4", "conv-3", "alpha::conv-9"} {
+		if err := ps.CreateTopic(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all, total, err := ps.ListTopics(DefaultNamespace, TopicListQuery{Prefix: "conv-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "conv-0,conv-1,conv-2,conv-3,conv-4,conv-5,conv-6"; listNames(all) != want || total != 7 {
+		t.Fatalf("prefix conv- lists %s of %d", listNames(all), total)
+	}
+
+	// Pages of 3 cover the listing once, the last one short
+	for _, tc := range []struct {
+		offset int
+		want   string
+	}{
+		{0, "conv-0,conv-1,conv-2"},
+		{3, "conv-3,conv-4,conv-5"},
+		{6, "conv-6"},
+		{7, ""},
+		{100, ""},
+	} {
+		page, total, err := ps.ListTopics(DefaultNamespace, TopicListQuery{Prefix: "conv-", Offset: tc.offset, Limit: 3})
+		if err != nil || listNames(page) != tc.want || total != 7 {
+			t.Errorf("offset %d lists %q of %d (%v), want %q of 7", tc.offset, listNames(page), total, err, tc.want)
+		}
+		if page == nil {
+			t.Errorf("offset %d returned a nil page", tc.offset)
+		}
+	}
+	if page, _, _ := ps.ListTopics(DefaultNamespace, TopicListQuery{Prefix: "conv-", Descending: true, Offset: 5, Limit: 3}); listNames(page) != "conv-1,conv-0" {
+		t.Errorf("last descending page = %s", listNames(page))
+	}
+
+	// Nothing matching is an empty page, not an error
+	if page, total, err := ps.ListTopics(DefaultNamespace, TopicListQuery{Prefix: "none"}); len(page) != 0 || page == nil || total != 0 || err != nil {
+		t.Errorf("no matches = %v, %d, %v", page, total, err)
+	}
+	if page, total, _ := ps.ListTopics("empty", TopicListQuery{}); len(page) != 0 || total != 0 {
+		t.Errorf("an empty namespace lists %v", page)
+	}
+}
+
+func TestListTopicsSorting(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	// Created in this order, a millisecond apart
+	for i, name := range []string{"b", "d", "a", "c"} {
+		if i > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		if err := ps.CreateTopic(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for topic, n := range map[string]int{"a": 2, "c": 2, "d": 1} {
+		for i := 0; i < n; i++ {
+			subscribeClient(t, ps, topic, newTestClient(fmt.Sprintf("%s-%d", topic, i)))
+		}
+	}
+	for topic, n := range map[string]int{"b": 3, "a": 1, "d": 1} {
+		for i := 0; i < n; i++ {
+			if err := ps.Publish(ctx, topic, MessageData{ID: "m", Payload: i}, ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		sort       string
+		descending bool
+		want       string
+	}{
+		{"", false, "a,b,c,d"},
+		{TopicSortName, true, "d,c,b,a"},
+		// Ties fall back to the name, in the same direction
+		{TopicSortSubscribers, false, "b,d,a,c"},
+		{TopicSortSubscribers, true, "c,a,d,b"},
+		{TopicSortMessages, false, "c,a,d,b"},
+		{TopicSortMessages, true, "b,d,a,c"},
+		{TopicSortCreatedAt, false, "b,d,a,c"},
+		{TopicSortCreatedAt, true, "c,a,d,b"},
+	} {
+		topics, _, err := ps.ListTopics(DefaultNamespace, TopicListQuery{Sort: tc.sort, Descending: tc.descending})
+		if err != nil || listNames(topics) != tc.want {
+			t.Errorf("sort %q descending %v = %s (%v), want %s", tc.sort, tc.descending, listNames(topics), err, tc.want)
+		}
+	}
+
+	// The counts sorted by are reported too
+	topics, _, _ := ps.ListTopics(DefaultNamespace, TopicListQuery{Prefix: "b"})
+	if len(topics) != 1 || topics[0].MessageCount != 3 || topics[0].Subscribers != 0 || topics[0].CreatedAt.IsZero() {
+		t.Errorf("b listed as %+v", topics)
+	}
+
+	if _, _, err := ps.ListTopics(DefaultNamespace, TopicListQuery{Sort: "size"}); !errors.Is(err, ErrInvalidTopicSort) {
+		t.Errorf("unknown sort = %v", err)
+	}
+}
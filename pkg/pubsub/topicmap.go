@@ -0,0 +1,104 @@
+package pubsub
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// topicShardCount is the number of independently locked topic map shards
+const topicShardCount = 32
+
+// topicShard is one lock-protected slice of the topic namespace
+type topicShard struct {
+	mutex  sync.RWMutex
+	topics map[string]*Topic
+}
+
+// topicMap is a sharded name -> topic map. Each topic name hashes to one
+// shard, so lookups on the publish path and creates or deletes of other
+// topics rarely contend, and iteration never locks more than one shard.
+type topicMap struct {
+	shards [topicShardCount]topicShard
+}
+
+// newTopicMap creates an empty topic map
+func newTopicMap() *topicMap {
+	tm := &topicMap{}
+	for i := range tm.shards {
+		tm.shards[i].topics = make(map[string]*Topic)
+	}
+	return tm
+}
+
+// shard returns the shard responsible for a topic name
+func (tm *topicMap) shard(name string) *topicShard {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return &tm.shards[h.Sum32()%topicShardCount]
+}
+
+// set stores a topic, replacing any with the same name
+func (tm *topicMap) set(topic *Topic) {
+	shard := tm.shard(topic.Name)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	shard.topics[topic.Name] = topic
+}
+
+// get looks up a topic by name
+func (tm *topicMap) get(name string) (*Topic, bool) {
+	shard := tm.shard(name)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+
+	topic, exists := shard.topics[name]
+	return topic, exists
+}
+
+// getOrInsert returns the existing topic with the given name, or stores and
+// returns the one built by create
+func (tm *topicMap) getOrInsert(name string, create func() *Topic) *Topic {
+	shard := tm.shard(name)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	if topic, exists := shard.topics[name]; exists {
+		return topic
+	}
+	topic := create()
+	shard.topics[name] = topic
+	return topic
+}
+
+// each calls fn for every topic, one shard at a time. Each shard is copied
+// under its read lock and fn runs without any shard locked, so fn may take
+// topic locks without holding up creates and deletes.
+func (tm *topicMap) each(fn func(topic *Topic)) {
+	var topics []*Topic
+	for i := range tm.shards {
+		shard := &tm.shards[i]
+		shard.mutex.RLock()
+		topics = topics[:0]
+		for _, topic := range shard.topics {
+			topics = append(topics, topic)
+		}
+		shard.mutex.RUnlock()
+
+		for _, topic := range topics {
+			fn(topic)
+		}
+	}
+}
+
+// len returns the number of topics
+func (tm *topicMap) len() int {
+	n := 0
+	for i := range tm.shards {
+		shard := &tm.shards[i]
+		shard.mutex.RLock()
+		n += len(shard.topics)
+		shard.mutex.RUnlock()
+	}
+	return n
+}
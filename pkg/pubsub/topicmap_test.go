@@ -0,0 +1,199 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTopicMapConcurrentCreateDeleteAndPublish(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	const stable = 100
+	for i := 0; i < stable; i++ {
+		if err := ps.CreateTopic(ctx, fmt.Sprintf("stable-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	// Churn topics while others are published to and listed
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				name := fmt.Sprintf("churn-%d-%d", w, i)
+				if err := ps.CreateTopic(ctx, name); err != nil {
+					t.Errorf("CreateTopic: %v", err)
+					return
+				}
+				if err := ps.DeleteTopic(ctx, name); err != nil {
+					t.Errorf("DeleteTopic: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				msg := MessageData{ID: fmt.Sprintf("m-%d-%d", w, i)}
+				if err := ps.Publish(ctx, fmt.Sprintf("stable-%d", i%stable), msg, ""); err != nil {
+					t.Errorf("Publish: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			if n := len(ps.GetStats().Topics); n < stable {
+				t.Errorf("stats counted %d topics, fewer than the %d stable ones", n, stable)
+				return
+			}
+			ps.GetTopics()
+		}
+	}()
+	wg.Wait()
+
+	if n := len(ps.GetTopics()); n != stable {
+		t.Errorf("%d topics left, want %d", n, stable)
+	}
+	var published int64
+	for _, topic := range ps.GetTopics() {
+		detail, err := ps.GetTopicDetail(topic.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		published += detail.MessageCount
+	}
+	if published != 4*500 {
+		t.Errorf("published %d messages, want %d", published, 4*500)
+	}
+}
+
+// lockedTopicMap is the single-lock map the sharded one replaced, kept
+// to compare against
+type lockedTopicMap struct {
+	mutex  sync.RWMutex
+	topics map[string]*Topic
+}
+
+func (m *lockedTopicMap) get(name string) (*Topic, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	topic, ok := m.topics[name]
+	return topic, ok
+}
+
+func (m *lockedTopicMap) set(topic *Topic) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.topics[topic.Name] = topic
+}
+
+// BenchmarkTopicLookup looks up 10k topics while one operation in 16
+// stores a topic, against the sharded map and the single-lock one. The
+// single lock falls behind as GOMAXPROCS grows; compare with -cpu 1,4,16.
+func BenchmarkTopicLookup(b *testing.B) {
+	const topics = 10000
+	names := make([]string, topics)
+	for i := range names {
+		names[i] = fmt.Sprintf("topic-%d", i)
+	}
+	sharded := newTopicMap()
+	locked := &lockedTopicMap{topics: make(map[string]*Topic)}
+	for _, name := range names {
+		sharded.set(&Topic{Name: name})
+		locked.set(&Topic{Name: name})
+	}
+
+	for _, bench := range []struct {
+		name string
+		get  func(string) (*Topic, bool)
+		set  func(*Topic)
+	}{
+		{"sharded", sharded.get, sharded.set},
+		{"single-lock", locked.get, locked.set},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			var next atomic.Int64
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := int(next.Add(1))
+					name := names[i%topics]
+					if i%16 == 0 {
+						bench.set(&Topic{Name: name})
+					} else if _, ok := bench.get(name); !ok {
+						b.Errorf("topic %s missing", name)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkPublishWithTopicChurn publishes across 10k topics while other
+// topics are created and deleted and the stats are read
+func BenchmarkPublishWithTopicChurn(b *testing.B) {
+	ps := New()
+	ctx := context.Background()
+	const topics = 10000
+	for i := 0; i < topics; i++ {
+		if err := ps.CreateTopic(ctx, fmt.Sprintf("topic-%d", i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	stop := make(chan struct{})
+	var churn sync.WaitGroup
+	churn.Add(2)
+	go func() {
+		defer churn.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			name := fmt.Sprintf("churn-%d", i)
+			ps.CreateTopic(ctx, name)
+			ps.DeleteTopic(ctx, name)
+		}
+	}()
+	go func() {
+		defer churn.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			ps.GetStats()
+		}
+	}()
+
+	var next atomic.Int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := next.Add(1)
+			msg := MessageData{ID: fmt.Sprint(i)}
+			if err := ps.Publish(ctx, fmt.Sprintf("topic-%d", i%topics), msg, ""); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	churn.Wait()
+}
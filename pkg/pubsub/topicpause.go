@@ -0,0 +1,144 @@
+package pubsub
+
+import (
+	"context"
+	"log"
+)
+
+// DefaultTopicBacklogSize is how many events a paused topic holds for its
+// subscribers before evicting the oldest
+const DefaultTopicBacklogSize = 10000
+
+// PublishResult describes an accepted publish
+type PublishResult struct {
+	Seq    int64 // 0 for ephemeral events
+	Paused bool  // Stored, but held from subscribers until the topic resumes
+}
+
+// topicBacklog holds the events published while a topic's delivery is
+// paused
+type topicBacklog struct {
+	events  []EventResponse
+	evicted int // Events dropped because the backlog was full
+}
+
+// TopicPauseResponse reports a topic's delivery being paused or resumed
+type TopicPauseResponse struct {
+	Topic     string `json:"topic"`
+	Paused    bool   `json:"paused"`
+	Delivered int    `json:"delivered,omitempty"` // Held events sent on resume
+	Evicted   int    `json:"evicted,omitempty"`   // Held events dropped because the backlog was full
+}
+
+// SetTopicBacklogSize configures how many events a paused topic holds.
+// Non-positive values keep the default. Applies to later pauses.
+func (ps *PubSubSystem) SetTopicBacklogSize(size int) {
+	if size <= 0 {
+		size = DefaultTopicBacklogSize
+	}
+	ps.clientMutex.Lock()
+	defer ps.clientMutex.Unlock()
+	ps.topicBacklogSize = size
+}
+
+// PauseTopic suspends delivery on a topic. Publishes are still accepted
+// and stored in history, but subscribers get them only on ResumeTopic.
+// Subscribers are told with a topic_paused info. Pausing a paused topic is
+// a no-op.
+func (ps *PubSubSystem) PauseTopic(ctx context.Context, name string) (TopicPauseResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return TopicPauseResponse{}, err
+	}
+	if err := checkUserTopic(name); err != nil {
+		return TopicPauseResponse{}, err
+	}
+	topic, exists := ps.topics.get(name)
+	if !exists {
+		return TopicPauseResponse{}, errorOf(ErrTopicNotFound, "topic %s not found", name)
+	}
+
+	ps.clientMutex.RLock()
+	size := ps.topicBacklogSize
+	ps.clientMutex.RUnlock()
+
+	topic.mutex.Lock()
+	defer topic.mutex.Unlock()
+	if topic.held == nil {
+		topic.held = &topicBacklog{}
+		topic.backlogSize = size
+		ps.flushFanout()
+		ps.noticeSubscribers(topic, "topic_paused")
+	}
+	return TopicPauseResponse{Topic: name, Paused: true}, nil
+}
+
+// ResumeTopic delivers the events held while a topic was paused, in
+// order, then resumes live delivery. Subscribers get a topic_resumed info
+// first. Resuming a topic that isn't paused returns zero counts.
+func (ps *PubSubSystem) ResumeTopic(ctx context.Context, name string) (TopicPauseResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return TopicPauseResponse{}, err
+	}
+	if err := checkUserTopic(name); err != nil {
+		return TopicPauseResponse{}, err
+	}
+	topic, exists := ps.topics.get(name)
+	if !exists {
+		return TopicPauseResponse{}, errorOf(ErrTopicNotFound, "topic %s not found", name)
+	}
+
+	// Delivering under the topic lock keeps held events ahead of any event
+	// published after the resume
+	ps.holdHooks()
+	defer ps.releaseHooks()
+	topic.mutex.Lock()
+	defer topic.mutex.Unlock()
+
+	held := topic.held
+	if held == nil {
+		return TopicPauseResponse{Topic: name}, nil
+	}
+	topic.held = nil
+	ps.noticeSubscribers(topic, "topic_resumed")
+	for _, event := range held.events {
+		ps.fanOut(topic, event, true)
+	}
+	log.Printf("Resumed topic %s: delivered %d held events, %d evicted", name, len(held.events), held.evicted)
+	return TopicPauseResponse{Topic: name, Delivered: len(held.events), Evicted: held.evicted}, nil
+}
+
+// paused reports whether delivery on the topic is paused. Callers must
+// hold the mutex.
+func (topic *Topic) paused() bool {
+	return topic.held != nil
+}
+
+// hold adds an event to a paused topic's backlog, evicting and
+// dead-lettering the oldest when it is full. Callers must hold the topic
+// lock.
+func (topic *Topic) hold(ps *PubSubSystem, event EventResponse) {
+	held := topic.held
+	if len(held.events) >= topic.backlogSize {
+		ps.DeadLetter(held.events[0], "", DeadLetterBacklogOverflow)
+		held.events = held.events[1:]
+		held.evicted++
+		topic.BacklogEvictions++
+	}
+	held.events = append(held.events, event)
+}
+
+// noticeSubscribers sends a topic's subscribers an info with message.
+// Callers must hold the topic lock.
+func (ps *PubSubSystem) noticeSubscribers(topic *Topic, message string) {
+	notice := InfoResponse{
+		Type:      "info",
+		Topic:     topic.Name,
+		Message:   message,
+		Timestamp: ps.clock.Now(),
+	}
+	for _, subscriber := range topic.Subscribers {
+		if err := subscriber.Client.SendMessage(notice); err != nil {
+			log.Printf("Error sending %s notice to client %s: %v", message, subscriber.ClientID, err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPausedTopicHoldsPublishes(t *testing.T) {
+	ps := New()
+	defer ps.Close()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "alerts"); err != nil {
+		t.Fatal(err)
+	}
+	watcher := &recordingClient{id: "watcher"}
+	subscribeClient(t, ps, "alerts", watcher)
+
+	if _, err := ps.PauseTopic(ctx, "alerts"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		result, err := ps.PublishWithResult(ctx, "alerts", MessageData{ID: fmt.Sprint(i)}, "", PublishOptions{})
+		if err != nil || !result.Paused || result.Seq != int64(i+1) {
+			t.Fatalf("publish while paused = %+v, %v", result, err)
+		}
+	}
+	if held := watcher.received(); len(held) != 0 {
+		t.Fatalf("paused topic delivered %d events", len(held))
+	}
+	if n := len(history(t, ps, "alerts")); n != 5 {
+		t.Errorf("history has %d events, want 5", n)
+	}
+	if detail, _ := ps.GetTopicDetail("alerts"); !detail.Paused || detail.Backlog != 5 {
+		t.Errorf("detail paused %v backlog %d", detail.Paused, detail.Backlog)
+	}
+
+	// Resuming delivers the backlog in order, ahead of later publishes
+	resp, err := ps.ResumeTopic(ctx, "alerts")
+	if err != nil || resp.Delivered != 5 || resp.Evicted != 0 {
+		t.Fatalf("resume = %+v, %v", resp, err)
+	}
+	if result, err := ps.PublishWithResult(ctx, "alerts", MessageData{ID: "5"}, "", PublishOptions{}); err != nil || result.Paused {
+		t.Fatalf("publish after resume = %+v, %v", result, err)
+	}
+	for i, event := range watcher.waitEvents(t, 6) {
+		if event.Seq != int64(i+1) {
+			t.Errorf("event %d has seq %d", i, event.Seq)
+		}
+	}
+	if notices := watcher.notices(); fmt.Sprint(notices) != "[topic_paused topic_resumed]" {
+		t.Errorf("notices = %v", notices)
+	}
+}
+
+func TestPausedTopicBacklogOverflow(t *testing.T) {
+	ps := New()
+	defer ps.Close()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "alerts.dlq"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateTopicWithConfig(ctx, "alerts", TopicConfig{DeadLetterTopic: "alerts.dlq"}); err != nil {
+		t.Fatal(err)
+	}
+	dead := &recordingClient{id: "dead"}
+	subscribeClient(t, ps, "alerts.dlq", dead)
+	watcher := &recordingClient{id: "watcher"}
+	subscribeClient(t, ps, "alerts", watcher)
+
+	ps.SetTopicBacklogSize(3)
+	if _, err := ps.PauseTopic(ctx, "alerts"); err != nil {
+		t.Fatal(err)
+	}
+	publishN(t, ps, "alerts", 5)
+
+	// The two oldest are evicted and dead-lettered, the newest delivered
+	resp, err := ps.ResumeTopic(ctx, "alerts")
+	if err != nil || resp.Delivered != 3 || resp.Evicted != 2 {
+		t.Fatalf("resume = %+v, %v", resp, err)
+	}
+	for i, event := range watcher.waitEvents(t, 3) {
+		if event.Seq != int64(i+3) {
+			t.Errorf("event %d has seq %d", i, event.Seq)
+		}
+	}
+	for i, event := range dead.waitEvents(t, 2) {
+		envelope := event.Message.Payload.(DeadLetterEnvelope)
+		if envelope.Reason != DeadLetterBacklogOverflow || envelope.OriginalSeq != int64(i+1) {
+			t.Errorf("dead letter %d = %+v", i, envelope)
+		}
+	}
+	if detail, _ := ps.GetTopicDetail("alerts"); detail.Paused || detail.BacklogEvictions != 2 {
+		t.Errorf("detail paused %v evictions %d", detail.Paused, detail.BacklogEvictions)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got := len(dead.received()); got != 2 {
+		t.Errorf("%d dead letters, want 2", got)
+	}
+}
@@ -0,0 +1,76 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Topic states reported by GetTopicDetail and accepted by SetTopicState
+const (
+	TopicStateActive   = "active"
+	TopicStateArchived = "archived"
+)
+
+var (
+	// ErrTopicArchived is returned when publishing to an archived topic
+	ErrTopicArchived = errors.New("topic is archived")
+
+	// ErrInvalidTopicState is returned for a state other than active or
+	// archived
+	ErrInvalidTopicState = errors.New("invalid topic state")
+)
+
+// state returns the topic's state. Callers must hold the mutex.
+func (topic *Topic) state() string {
+	if topic.Archived {
+		return TopicStateArchived
+	}
+	return TopicStateActive
+}
+
+// SetTopicState archives or reactivates a topic. An archived topic rejects
+// publishes but can still be subscribed to and its history read. Once the
+// call returns no publish in flight can add to an archived topic. Current
+// subscribers get a topic_archived or topic_unarchived info on a change.
+func (ps *PubSubSystem) SetTopicState(ctx context.Context, name, state string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if state != TopicStateActive && state != TopicStateArchived {
+		return fmt.Errorf("%w: %q", ErrInvalidTopicState, state)
+	}
+	if err := checkUserTopic(name); err != nil {
+		return err
+	}
+
+	topic, exists := ps.topics.get(name)
+	if !exists {
+		return errorOf(ErrTopicNotFound, "topic %s not found", name)
+	}
+
+	topic.mutex.Lock()
+	archived := state == TopicStateArchived
+	if topic.Archived == archived {
+		topic.mutex.Unlock()
+		return nil
+	}
+	topic.Archived = archived
+	config := topic.config()
+	createdAt := topic.CreatedAt
+
+	// Sent under the lock so the notice comes after every event published
+	// before the change
+	ps.flushFanout()
+	if archived {
+		ps.noticeSubscribers(topic, "topic_archived")
+	} else {
+		ps.noticeSubscribers(topic, "topic_unarchived")
+	}
+	topic.mutex.Unlock()
+
+	if ps.store != nil {
+		ps.store.TopicCreated(name, createdAt, config)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// notices returns the info messages sent to c so far
+func (c *recordingClient) notices() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var notices []string
+	for _, msg := range c.messages {
+		if info, ok := msg.(InfoResponse); ok {
+			notices = append(notices, info.Message)
+		}
+	}
+	return notices
+}
+
+func TestArchivedTopic(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "room"); err != nil {
+		t.Fatal(err)
+	}
+	member := &recordingClient{id: "member"}
+	subscribeClient(t, ps, "room", member)
+	publish := func(id string) error {
+		return ps.Publish(ctx, "room", MessageData{ID: id, Payload: id}, "")
+	}
+	for _, id := range []string{"m1", "m2", "m3"} {
+		if err := publish(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ps.SetTopicState(ctx, "room", TopicStateArchived); err != nil {
+		t.Fatal(err)
+	}
+	if notices := member.notices(); len(notices) != 1 || notices[0] != "topic_archived" {
+		t.Errorf("subscriber was told %v", notices)
+	}
+	if detail, _ := ps.GetTopicDetail("room"); detail.State != TopicStateArchived {
+		t.Errorf("detail state = %q", detail.State)
+	}
+
+	// Publishing is refused; reading isn't
+	if err := publish("m4"); !errors.Is(err, ErrTopicArchived) {
+		t.Errorf("publishing to an archived topic = %v", err)
+	}
+	if got := len(history(t, ps, "room")); got != 3 {
+		t.Errorf("history holds %d events, want 3", got)
+	}
+	late := &recordingClient{id: "late"}
+	ps.RegisterClient(late)
+	replay, err := ps.SubscribeWithOptions(ctx, late.id, "room", SubscribeOptions{LastN: 2}, late)
+	if err != nil || len(replay) != 2 || replay[1].Message.ID != "m3" {
+		t.Errorf("subscribing to an archived topic = %v, %v", replay, err)
+	}
+
+	// Setting the same state again tells no one
+	if err := ps.SetTopicState(ctx, "room", TopicStateArchived); err != nil {
+		t.Fatal(err)
+	}
+	if notices := member.notices(); len(notices) != 1 {
+		t.Errorf("archiving twice sent %v", notices)
+	}
+
+	if err := ps.SetTopicState(ctx, "room", TopicStateActive); err != nil {
+		t.Fatal(err)
+	}
+	if notices := late.notices(); len(notices) != 1 || notices[0] != "topic_unarchived" {
+		t.Errorf("unarchiving told the new subscriber %v", notices)
+	}
+	if err := publish("m4"); err != nil {
+		t.Errorf("publishing once unarchived = %v", err)
+	}
+	if events := late.waitEvents(t, 1); events[0].Message.ID != "m4" {
+		t.Errorf("delivered %+v", events)
+	}
+
+	if err := ps.SetTopicState(ctx, "room", "frozen"); !errors.Is(err, ErrInvalidTopicState) {
+		t.Errorf("an unknown state = %v", err)
+	}
+	if err := ps.SetTopicState(ctx, "missing", TopicStateArchived); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("archiving a missing topic = %v", err)
+	}
+}
+
+func TestArchivingDuringPublishes(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "room"); err != nil {
+		t.Fatal(err)
+	}
+	member := &recordingClient{id: "member"}
+	subscribeClient(t, ps, "room", member)
+
+	var stop atomic.Bool
+	var accepted, refused atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				if err := ps.Publish(ctx, "room", MessageData{ID: "m", Payload: 1}, ""); err == nil {
+					accepted.Add(1)
+				} else if errors.Is(err, ErrTopicArchived) {
+					refused.Add(1)
+				} else {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	waitFor(t, "publishes", func() bool { return accepted.Load() > 100 })
+
+	if err := ps.SetTopicState(ctx, "room", TopicStateArchived); err != nil {
+		t.Fatal(err)
+	}
+	detail, _ := ps.GetTopicDetail("room")
+	afterArchive := detail.MessageCount
+	waitFor(t, "refused publishes", func() bool { return refused.Load() > 100 })
+	stop.Store(true)
+	wg.Wait()
+
+	// Nothing was added once the call returned, everything accepted is
+	// in history, and the notice came after the last event
+	if detail, _ := ps.GetTopicDetail("room"); detail.MessageCount != afterArchive || detail.MessageCount != accepted.Load() {
+		t.Errorf("%d messages after archiving, %d then, %d accepted", detail.MessageCount, afterArchive, accepted.Load())
+	}
+	member.mutex.Lock()
+	defer member.mutex.Unlock()
+	last := member.messages[len(member.messages)-1]
+	if info, ok := last.(InfoResponse); !ok || info.Message != "topic_archived" {
+		t.Errorf("last message to the subscriber was %#v", last)
+	}
+}
@@ -0,0 +1,119 @@
+package pubsub
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// TraceparentHeader and TracestateHeader carry W3C trace context in a
+	// message's headers
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+
+	// tracerName is the instrumentation scope of the broker's spans
+	tracerName = "github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// noopSpan stands in for the spans of untraced events
+var noopSpan = trace.SpanFromContext(context.Background())
+
+// tracing creates the broker's spans once a tracer provider is set
+type tracing struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// SetTracerProvider traces every publish with spans from tp: one for the
+// publish, a child for its fan-out and a child of that for each delivery
+// enqueued. A "traceparent" message header makes the publish span part of
+// the publisher's trace, and delivered events carry the publish span's
+// context in the same header so consumers can continue it. nil turns
+// tracing off; untraced publishes create no spans and touch no headers.
+func (ps *PubSubSystem) SetTracerProvider(tp trace.TracerProvider) {
+	if tp == nil {
+		ps.tracing.Store(nil)
+		return
+	}
+	ps.tracing.Store(&tracing{
+		tracer:     tp.Tracer(tracerName),
+		propagator: propagation.TraceContext{},
+	})
+}
+
+// Tracing reports whether a tracer provider is set
+func (ps *PubSubSystem) Tracing() bool {
+	return ps.tracing.Load() != nil
+}
+
+// startPublish starts a publish span in the trace of message's traceparent
+// header, or of ctx, and replaces message's trace headers with the span's
+// context
+func (t *tracing) startPublish(ctx context.Context, topic string, message *MessageData, sender string) (context.Context, trace.Span) {
+	if message.Headers[TraceparentHeader] != "" {
+		ctx = t.propagator.Extract(ctx, propagation.MapCarrier(message.Headers))
+	}
+	ctx, span := t.tracer.Start(ctx, "publish "+topic,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "pubsub"),
+			attribute.String("messaging.operation", "publish"),
+			attribute.String("messaging.destination.name", topic),
+			attribute.String("messaging.message.id", message.ID),
+			attribute.String("messaging.client.id", sender),
+		))
+
+	// Copied so the publisher's map is left alone
+	headers := make(map[string]string, len(message.Headers)+2)
+	for k, v := range message.Headers {
+		headers[k] = v
+	}
+	t.propagator.Inject(ctx, propagation.MapCarrier(headers))
+	message.Headers = headers
+	return ctx, span
+}
+
+// endSpan ends span, marking it failed when err is set
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceFanOut starts the fan-out span of a traced event and returns the
+// event with it as the parent of its deliveries
+func (ps *PubSubSystem) traceFanOut(event EventResponse, subscribers int) (EventResponse, trace.Span) {
+	t := ps.tracing.Load()
+	if t == nil || !event.trace.IsValid() {
+		return event, noopSpan
+	}
+	_, span := t.tracer.Start(trace.ContextWithSpanContext(context.Background(), event.trace), "fanout "+event.Topic,
+		trace.WithAttributes(
+			attribute.String("messaging.destination.name", event.Topic),
+			attribute.Int64("messaging.message.seq", event.Seq),
+			attribute.Int("pubsub.subscribers", subscribers),
+		))
+	event.trace = span.SpanContext()
+	return event, span
+}
+
+// traceDelivery starts the span of enqueueing a traced event for clientID
+func (ps *PubSubSystem) traceDelivery(event EventResponse, clientID, mode string) trace.Span {
+	t := ps.tracing.Load()
+	if t == nil || !event.trace.IsValid() {
+		return noopSpan
+	}
+	_, span := t.tracer.Start(trace.ContextWithSpanContext(context.Background(), event.trace), "deliver "+event.Topic,
+		trace.WithAttributes(
+			attribute.String("messaging.destination.name", event.Topic),
+			attribute.String("messaging.client.id", clientID),
+			attribute.String("pubsub.delivery", mode),
+		))
+	return span
+}
@@ -0,0 +1,118 @@
+package pubsub
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// A producer's span, as a W3C traceparent
+const (
+	producerTrace = "4bf92f3577b34da6a3ce929d0e0e4736"
+	producerSpan  = "00f067aa0ba902b7"
+)
+
+// tracedTopic returns a traced system with "orders" subscribed by a and b,
+// and the recorder its spans end in
+func tracedTopic(t *testing.T) (*PubSubSystem, *tracetest.SpanRecorder, []*recordingClient) {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	ps := New()
+	ps.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	clients := []*recordingClient{{id: "a"}, {id: "b"}}
+	for _, client := range clients {
+		ps.RegisterClient(client)
+		if _, err := ps.Subscribe(context.Background(), client.id, "orders", 0, client); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ps, recorder, clients
+}
+
+func TestTracingSpansOnePublish(t *testing.T) {
+	ps, recorder, clients := tracedTopic(t)
+	headers := map[string]string{TraceparentHeader: "00-" + producerTrace + "-" + producerSpan + "-01", "region": "eu"}
+	if err := ps.Publish(context.Background(), "orders", MessageData{ID: "m-1", Payload: 1, Headers: headers}, "producer"); err != nil {
+		t.Fatal(err)
+	}
+	if headers[TraceparentHeader] != "00-"+producerTrace+"-"+producerSpan+"-01" {
+		t.Error("the publisher's headers were changed")
+	}
+
+	byName := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() != producerTrace {
+			t.Errorf("span %s in trace %s", span.Name(), span.SpanContext().TraceID())
+		}
+		byName[span.Name()] = append(byName[span.Name()], span)
+	}
+	publish, fanOut, deliveries := byName["publish orders"], byName["fanout orders"], byName["deliver orders"]
+	if len(publish) != 1 || len(fanOut) != 1 || len(deliveries) != 2 {
+		t.Fatalf("spans = %v", byName)
+	}
+	if parent := publish[0].Parent(); parent.SpanID().String() != producerSpan || !parent.IsRemote() {
+		t.Errorf("publish parent = %s", parent.SpanID())
+	}
+	if fanOut[0].Parent().SpanID() != publish[0].SpanContext().SpanID() {
+		t.Error("fan-out span isn't a child of the publish span")
+	}
+	delivered := map[string]bool{}
+	for _, span := range deliveries {
+		if span.Parent().SpanID() != fanOut[0].SpanContext().SpanID() {
+			t.Errorf("delivery span %v isn't a child of the fan-out span", span.Attributes())
+		}
+		for _, attr := range span.Attributes() {
+			if attr.Key == "messaging.client.id" {
+				delivered[attr.Value.AsString()] = true
+			}
+		}
+	}
+	if !delivered["a"] || !delivered["b"] {
+		t.Errorf("deliveries traced for %v", delivered)
+	}
+
+	// Consumers get the publish span's context to continue the trace
+	for _, client := range clients {
+		events := client.received()
+		if len(events) != 1 {
+			t.Fatalf("%s got %d events", client.id, len(events))
+		}
+		got := events[0].Message.Headers
+		want := "00-" + producerTrace + "-" + publish[0].SpanContext().SpanID().String() + "-01"
+		if got[TraceparentHeader] != want || got["region"] != "eu" {
+			t.Errorf("%s got headers %v, want traceparent %s", client.id, got, want)
+		}
+	}
+}
+
+func TestTracingFailedPublishAndUntracedSystems(t *testing.T) {
+	ps, recorder, _ := tracedTopic(t)
+	if err := ps.Publish(context.Background(), "missing", MessageData{ID: "m-1"}, ""); err == nil {
+		t.Fatal("publish to a missing topic succeeded")
+	}
+	ended := recorder.Ended()
+	if len(ended) != 1 || ended[0].Status().Code.String() != "Error" || !strings.Contains(ended[0].Status().Description, "not found") {
+		t.Errorf("spans for a failed publish = %v", ended)
+	}
+
+	// Without a tracer provider, headers pass through untouched
+	plain := New()
+	client := &recordingClient{id: "a"}
+	plain.RegisterClient(client)
+	if err := plain.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plain.Subscribe(context.Background(), "a", "orders", 0, client); err != nil {
+		t.Fatal(err)
+	}
+	publishN(t, plain, "orders", 1)
+	if headers := client.received()[0].Message.Headers; headers != nil {
+		t.Errorf("untraced event headers = %v", headers)
+	}
+}
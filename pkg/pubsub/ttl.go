@@ -0,0 +1,261 @@
+package pubsub
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// ReasonTTLExpired is the reason of the "unsubscribed" notice sent when a
+// subscription's TTL runs out
+const ReasonTTLExpired = "ttl_expired"
+
+// ErrNoSubscriptionTTL is returned when touching a subscription that
+// doesn't exist or has no TTL
+var ErrNoSubscriptionTTL = errors.New("no subscription with a TTL")
+
+// ttlKey identifies a subscription
+type ttlKey struct {
+	clientID string
+	topic    string
+}
+
+// ttlEntry is a subscription's place in the expiry queue
+type ttlEntry struct {
+	ttlKey
+	client    ClientInterface // Told when the subscription expires
+	ttl       time.Duration
+	expiresAt time.Time
+	index     int // Position in the queue
+}
+
+// ttlQueue is a min-heap of subscriptions, soonest to expire first
+type ttlQueue []*ttlEntry
+
+func (q ttlQueue) Len() int { return len(q) }
+
+func (q ttlQueue) Less(i, j int) bool { return q[i].expiresAt.Before(q[j].expiresAt) }
+
+func (q ttlQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *ttlQueue) Push(x interface{}) {
+	entry := x.(*ttlEntry)
+	entry.index = len(*q)
+	*q = append(*q, entry)
+}
+
+func (q *ttlQueue) Pop() interface{} {
+	old := *q
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return entry
+}
+
+// subscriptionTTLs holds every subscription with a TTL and unsubscribes
+// them as they expire, from one timer goroutine. Its mutex is taken after
+// topic locks and clientMutex, never before.
+type subscriptionTTLs struct {
+	ps *PubSubSystem
+
+	mutex sync.Mutex
+	queue ttlQueue
+	byKey map[ttlKey]*ttlEntry
+
+	// Signalled (non-blocking) when the soonest expiry may have changed
+	wake     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// newSubscriptionTTLs creates an empty expiry queue on the system's clock
+func newSubscriptionTTLs(ps *PubSubSystem) *subscriptionTTLs {
+	return &subscriptionTTLs{
+		ps:    ps,
+		byKey: make(map[ttlKey]*ttlEntry),
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+}
+
+// TouchSubscription restarts the TTL of a client's subscription to a topic
+// and returns its new expiry
+func (ps *PubSubSystem) TouchSubscription(clientID, topicName string) (time.Time, error) {
+	return ps.ttls.touch(ttlKey{clientID, topicName})
+}
+
+// SubscriptionExpiry returns when a client's subscription to a topic
+// expires, and false when it has no TTL
+func (ps *PubSubSystem) SubscriptionExpiry(clientID, topicName string) (time.Time, bool) {
+	ps.ttls.mutex.Lock()
+	defer ps.ttls.mutex.Unlock()
+	entry, exists := ps.ttls.byKey[ttlKey{clientID, topicName}]
+	if !exists {
+		return time.Time{}, false
+	}
+	return entry.expiresAt, true
+}
+
+// set gives a subscription a TTL, replacing any it had
+func (t *subscriptionTTLs) set(key ttlKey, ttl time.Duration, client ClientInterface) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if entry, exists := t.byKey[key]; exists {
+		entry.client = client
+		entry.ttl = ttl
+		entry.expiresAt = t.ps.clock.Now().Add(ttl)
+		heap.Fix(&t.queue, entry.index)
+	} else {
+		entry := &ttlEntry{ttlKey: key, client: client, ttl: ttl, expiresAt: t.ps.clock.Now().Add(ttl)}
+		heap.Push(&t.queue, entry)
+		t.byKey[key] = entry
+	}
+	t.signal()
+}
+
+// touch restarts a subscription's TTL
+func (t *subscriptionTTLs) touch(key ttlKey) (time.Time, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	entry, exists := t.byKey[key]
+	if !exists {
+		return time.Time{}, fmt.Errorf("%w: client %s on topic %s", ErrNoSubscriptionTTL, key.clientID, key.topic)
+	}
+	entry.expiresAt = t.ps.clock.Now().Add(entry.ttl)
+	heap.Fix(&t.queue, entry.index)
+	t.signal()
+	return entry.expiresAt, nil
+}
+
+// cancel drops a subscription's TTL, if it has one
+func (t *subscriptionTTLs) cancel(key ttlKey) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if entry, exists := t.byKey[key]; exists {
+		heap.Remove(&t.queue, entry.index)
+		delete(t.byKey, key)
+		t.signal()
+	}
+}
+
+// remaining returns the whole seconds, rounded up, left on each of a
+// client's subscriptions with a TTL, by topic; nil when there are none
+func (t *subscriptionTTLs) remaining(clientID string, topics []string) map[string]int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var left map[string]int
+	now := t.ps.clock.Now()
+	for _, topic := range topics {
+		if entry, exists := t.byKey[ttlKey{clientID, topic}]; exists {
+			if left == nil {
+				left = make(map[string]int)
+			}
+			left[topic] = int(math.Ceil(max(entry.expiresAt.Sub(now), 0).Seconds()))
+		}
+	}
+	return left
+}
+
+// takeExpired removes and returns the subscriptions expired by now,
+// soonest first
+func (t *subscriptionTTLs) takeExpired() []*ttlEntry {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.ps.clock.Now()
+	var expired []*ttlEntry
+	for len(t.queue) > 0 && !t.queue[0].expiresAt.After(now) {
+		entry := heap.Pop(&t.queue).(*ttlEntry)
+		delete(t.byKey, entry.ttlKey)
+		expired = append(expired, entry)
+	}
+	return expired
+}
+
+// expire unsubscribes every expired subscription and tells its client.
+// Returns the number taken from the queue.
+func (t *subscriptionTTLs) expire() int {
+	expired := t.takeExpired()
+	for _, entry := range expired {
+		if err := t.ps.Unsubscribe(context.Background(), entry.clientID, entry.topic); err != nil {
+			continue
+		}
+		log.Printf("Subscription of client %s to topic %s expired", entry.clientID, entry.topic)
+		notice := InfoResponse{
+			Type:      "unsubscribed",
+			Topic:     entry.topic,
+			Message:   "subscription TTL expired",
+			Reason:    ReasonTTLExpired,
+			Timestamp: t.ps.clock.Now(),
+		}
+		if err := entry.client.SendMessage(notice); err != nil {
+			log.Printf("Dropping TTL expiry notice for client %s - %v", entry.clientID, err)
+		}
+	}
+	return len(expired)
+}
+
+// signal wakes the timer goroutine. Callers must hold the mutex.
+func (t *subscriptionTTLs) signal() {
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// untilNext returns how long until the soonest subscription expires, and
+// false when none has a TTL
+func (t *subscriptionTTLs) untilNext() (time.Duration, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.queue) == 0 {
+		return 0, false
+	}
+	return t.queue[0].expiresAt.Sub(t.ps.clock.Now()), true
+}
+
+// run unsubscribes subscriptions as they expire until stop
+func (t *subscriptionTTLs) run() {
+	timer := t.ps.clock.NewTimer(0)
+	defer timer.Stop()
+	for {
+		t.expire()
+
+		// Wait for the soonest expiry, or for a change to the queue
+		if !timer.Stop() {
+			select {
+			case <-timer.C():
+			default:
+			}
+		}
+		var due <-chan time.Time
+		if wait, ok := t.untilNext(); ok {
+			timer.Reset(max(wait, 0))
+			due = timer.C()
+		}
+		select {
+		case <-due:
+		case <-t.wake:
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// stop ends the timer goroutine
+func (t *subscriptionTTLs) stop() {
+	t.stopOnce.Do(func() { close(t.done) })
+}
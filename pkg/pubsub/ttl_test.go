@@ -0,0 +1,123 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// subscribeWithTTL subscribes client to "watch" for ttl
+func subscribeWithTTL(t *testing.T, ps *PubSubSystem, client *recordingClient, ttl time.Duration) {
+	t.Helper()
+	if _, err := ps.SubscribeWithOptions(context.Background(), client.id, "watch", SubscribeOptions{TTL: ttl}, client); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// unsubscribedNotices returns the "unsubscribed" notices sent to the client
+func (c *recordingClient) unsubscribedNotices() []InfoResponse {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var notices []InfoResponse
+	for _, msg := range c.messages {
+		if info, ok := msg.(InfoResponse); ok && info.Type == "unsubscribed" {
+			notices = append(notices, info)
+		}
+	}
+	return notices
+}
+
+func TestSubscriptionTTLExpires(t *testing.T) {
+	ps, clock := fakeClockSystem()
+	defer ps.Close()
+	if err := ps.CreateTopic(context.Background(), "watch"); err != nil {
+		t.Fatal(err)
+	}
+	tail := &recordingClient{id: "tail"}
+	subscribeWithTTL(t, ps, tail, time.Minute)
+
+	clock.Advance(20 * time.Second)
+	if expired := ps.ttls.expire(); expired != 0 || !ps.IsSubscribed("tail", "watch") {
+		t.Fatalf("expired %d subscriptions early", expired)
+	}
+	if left := ps.GetClientSubscriptions("tail", "").Subscriptions[0].TTLRemaining["watch"]; left != 40 {
+		t.Errorf("remaining TTL = %d, want 40", left)
+	}
+
+	clock.Advance(40 * time.Second)
+	waitFor(t, "the subscription to expire", func() bool { return !ps.IsSubscribed("tail", "watch") })
+	waitFor(t, "the expiry notice", func() bool { return len(tail.unsubscribedNotices()) == 1 })
+	if notice := tail.unsubscribedNotices()[0]; notice.Topic != "watch" || notice.Reason != ReasonTTLExpired {
+		t.Errorf("notice = %+v", notice)
+	}
+	if _, ok := ps.SubscriptionExpiry("tail", "watch"); ok {
+		t.Error("expired subscription still has a TTL")
+	}
+}
+
+func TestTouchSubscription(t *testing.T) {
+	ps, clock := fakeClockSystem()
+	defer ps.Close()
+	if err := ps.CreateTopic(context.Background(), "watch"); err != nil {
+		t.Fatal(err)
+	}
+	tail := &recordingClient{id: "tail"}
+	subscribeWithTTL(t, ps, tail, time.Minute)
+
+	// A touch restarts the TTL from now
+	clock.Advance(45 * time.Second)
+	expiresAt, err := ps.TouchSubscription("tail", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := clock.Now().Add(time.Minute); !expiresAt.Equal(want) {
+		t.Errorf("touched expiry = %v, want %v", expiresAt, want)
+	}
+	clock.Advance(45 * time.Second)
+	if expired := ps.ttls.expire(); expired != 0 || !ps.IsSubscribed("tail", "watch") {
+		t.Fatal("touched subscription expired")
+	}
+
+	// Subscriptions without a TTL can't be touched
+	if _, err := ps.TouchSubscription("tail", "missing"); !errors.Is(err, ErrNoSubscriptionTTL) {
+		t.Errorf("touching an unknown subscription: %v", err)
+	}
+	subscribeWithTTL(t, ps, tail, 0)
+	if _, err := ps.TouchSubscription("tail", "watch"); !errors.Is(err, ErrNoSubscriptionTTL) {
+		t.Errorf("touching after re-subscribing without a TTL: %v", err)
+	}
+}
+
+func TestSubscriptionTTLCancelled(t *testing.T) {
+	ps, clock := fakeClockSystem()
+	defer ps.Close()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "watch"); err != nil {
+		t.Fatal(err)
+	}
+	unsubscribed, disconnected := &recordingClient{id: "u"}, &recordingClient{id: "d"}
+	subscribeWithTTL(t, ps, unsubscribed, time.Minute)
+	subscribeWithTTL(t, ps, disconnected, time.Minute)
+
+	if err := ps.Unsubscribe(ctx, "u", "watch"); err != nil {
+		t.Fatal(err)
+	}
+	ps.DisconnectClient("d")
+	ps.ttls.mutex.Lock()
+	pending := len(ps.ttls.byKey)
+	ps.ttls.mutex.Unlock()
+	if pending != 0 {
+		t.Fatalf("%d TTLs left after unsubscribing and disconnecting", pending)
+	}
+
+	// Re-subscribing later isn't cut short by the old TTL
+	subscribeWithTTL(t, ps, unsubscribed, 0)
+	clock.Advance(2 * time.Minute)
+	if expired := ps.ttls.expire(); expired != 0 || !ps.IsSubscribed("u", "watch") {
+		t.Error("cancelled TTL expired a later subscription")
+	}
+	if notices := len(unsubscribed.unsubscribedNotices()) + len(disconnected.unsubscribedNotices()); notices != 0 {
+		t.Errorf("sent %d expiry notices", notices)
+	}
+}
@@ -0,0 +1,207 @@
+package pubsub
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ClientUsage counts one client_id's websocket traffic. The counters are
+// cumulative across reconnects with the same client_id until reset, or
+// until the client_id has been gone for the session retention.
+type ClientUsage struct {
+	messagesReceived atomic.Int64
+	bytesReceived    atomic.Int64
+	messagesSent     atomic.Int64
+	bytesSent        atomic.Int64
+
+	// When the client_id's connection ended; zero while connected. Guarded
+	// by clientUsages.mutex.
+	releasedAt time.Time
+}
+
+// Received records one frame read from the client
+func (u *ClientUsage) Received(bytes int) {
+	u.messagesReceived.Add(1)
+	u.bytesReceived.Add(int64(bytes))
+}
+
+// Sent records messages written to the client and their encoded size
+func (u *ClientUsage) Sent(messages, bytes int) {
+	u.messagesSent.Add(int64(messages))
+	u.bytesSent.Add(int64(bytes))
+}
+
+// Stats returns the current counters
+func (u *ClientUsage) Stats() ClientUsageStats {
+	return ClientUsageStats{
+		MessagesReceived: u.messagesReceived.Load(),
+		BytesReceived:    u.bytesReceived.Load(),
+		MessagesSent:     u.messagesSent.Load(),
+		BytesSent:        u.bytesSent.Load(),
+	}
+}
+
+// reset zeroes the counters
+func (u *ClientUsage) reset() {
+	u.messagesReceived.Store(0)
+	u.bytesReceived.Store(0)
+	u.messagesSent.Store(0)
+	u.bytesSent.Store(0)
+}
+
+// add folds another client's counters into u
+func (u *ClientUsage) add(other *ClientUsage) {
+	stats := other.Stats()
+	u.messagesReceived.Add(stats.MessagesReceived)
+	u.bytesReceived.Add(stats.BytesReceived)
+	u.messagesSent.Add(stats.MessagesSent)
+	u.bytesSent.Add(stats.BytesSent)
+}
+
+// clientUsages maps client_id -> usage counters
+type clientUsages struct {
+	byClient map[string]*ClientUsage
+	mutex    sync.Mutex
+}
+
+// ClientUsage returns the usage counters for clientID, creating them on
+// first use. Transports keep the pointer while the client is connected,
+// and call ReleaseClientUsage once its connection ends.
+func (ps *PubSubSystem) ClientUsage(clientID string) *ClientUsage {
+	ps.usage.mutex.Lock()
+	defer ps.usage.mutex.Unlock()
+
+	usage, exists := ps.usage.byClient[clientID]
+	if !exists {
+		usage = &ClientUsage{}
+		ps.usage.byClient[clientID] = usage
+	}
+	usage.releasedAt = time.Time{}
+	return usage
+}
+
+// ReleaseClientUsage starts the retention countdown of clientID's counters
+// once its connection ends. They are dropped after the session retention
+// unless the client_id connects again first.
+func (ps *PubSubSystem) ReleaseClientUsage(clientID string) {
+	ps.usage.mutex.Lock()
+	defer ps.usage.mutex.Unlock()
+	if usage, exists := ps.usage.byClient[clientID]; exists {
+		usage.releasedAt = ps.clock.Now()
+	}
+}
+
+// SweepClientUsage drops the counters of client_ids gone for longer than
+// the session retention, returning how many were dropped
+func (ps *PubSubSystem) SweepClientUsage() int {
+	ps.resume.mutex.Lock()
+	retention := ps.resume.retention
+	ps.resume.mutex.Unlock()
+	now := ps.clock.Now()
+
+	ps.usage.mutex.Lock()
+	defer ps.usage.mutex.Unlock()
+	dropped := 0
+	for clientID, usage := range ps.usage.byClient {
+		if !usage.releasedAt.IsZero() && !now.Before(usage.releasedAt.Add(retention)) {
+			delete(ps.usage.byClient, clientID)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// GetClientUsage returns clientID's counters, if it has any
+func (ps *PubSubSystem) GetClientUsage(clientID string) (ClientUsageStats, bool) {
+	ps.usage.mutex.Lock()
+	usage, exists := ps.usage.byClient[clientID]
+	ps.usage.mutex.Unlock()
+	if !exists {
+		return ClientUsageStats{}, false
+	}
+	return usage.Stats(), true
+}
+
+// LivenessReporter is implemented by clients that probe their peer and can
+// tell when it stopped answering
+type LivenessReporter interface {
+	Unresponsive() bool
+}
+
+// GetClientDetail returns a client's connection state, liveness,
+// subscriptions and usage. It reports false for a client_id that is neither
+// connected nor has usage counters.
+func (ps *PubSubSystem) GetClientDetail(clientID string) (ClientDetailResponse, bool) {
+	ps.clientMutex.RLock()
+	client, connected := ps.clients[clientID]
+	ps.clientMutex.RUnlock()
+
+	usage, exists := ps.GetClientUsage(clientID)
+	will, hasWill := ps.LastWill(clientID)
+	if !connected && !exists && !hasWill {
+		return ClientDetailResponse{}, false
+	}
+
+	topics := ps.GetClientTopics(clientID)
+	sort.Strings(topics)
+	detail := ClientDetailResponse{
+		ClientID:  clientID,
+		Connected: connected,
+		Topics:    topics,
+		Usage:     usage,
+		Buffers:   ps.ClientBufferStats(clientID),
+	}
+	if hasWill {
+		detail.LastWill = &will
+	}
+	if connected {
+		lastActive := client.GetLastActive()
+		detail.LastActive = &lastActive
+		if reporter, ok := client.(LivenessReporter); ok {
+			detail.Unresponsive = reporter.Unresponsive()
+		}
+	}
+	return detail, true
+}
+
+// ResetClientUsage zeroes clientID's counters. It reports whether the
+// client had any.
+func (ps *PubSubSystem) ResetClientUsage(clientID string) bool {
+	ps.usage.mutex.Lock()
+	usage, exists := ps.usage.byClient[clientID]
+	ps.usage.mutex.Unlock()
+	if exists {
+		usage.reset()
+	}
+	return exists
+}
+
+// moveClientUsage folds oldID's counters into newID's when a connection
+// claims a new client_id
+func (ps *PubSubSystem) moveClientUsage(oldID, newID string) {
+	ps.usage.mutex.Lock()
+	defer ps.usage.mutex.Unlock()
+
+	old, exists := ps.usage.byClient[oldID]
+	if !exists {
+		return
+	}
+	delete(ps.usage.byClient, oldID)
+	if usage, exists := ps.usage.byClient[newID]; exists {
+		usage.add(old)
+		usage.releasedAt = time.Time{}
+	} else {
+		ps.usage.byClient[newID] = old
+	}
+}
+
+// RecordTopicTraffic adds bytes received from publishers of a topic and
+// bytes sent to its subscribers to the topic's totals in GetStats
+func (ps *PubSubSystem) RecordTopicTraffic(topicName string, received, sent int) {
+	if topic, exists := ps.topics.get(topicName); exists {
+		topic.activity.bytesIn.Add(int64(received))
+		topic.activity.bytesOut.Add(int64(sent))
+	}
+}
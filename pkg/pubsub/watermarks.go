@@ -0,0 +1,199 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Events announcing a topic's subscriber count crossing a watermark
+const (
+	EventSubscribersHigh   = "subscribers_high"   // Rose above the high watermark
+	EventSubscribersLow    = "subscribers_low"    // Fell below the low watermark
+	EventSubscribersNormal = "subscribers_normal" // Came back from either
+)
+
+// ErrInvalidSubscriberWatermarks is returned for negative watermarks or a
+// high watermark below the low one
+var ErrInvalidSubscriberWatermarks = errors.New("invalid subscriber watermarks")
+
+// SubscriberWatermarks announces a topic's subscriber count crossing a
+// threshold. Once crossed, a watermark counts as crossed back only after
+// the count has moved Hysteresis past it the other way, so churn around a
+// threshold announces nothing. The zero value announces nothing.
+type SubscriberWatermarks struct {
+	High       int `json:"high,omitempty"`       // Announce rising above this; 0 for none
+	Low        int `json:"low,omitempty"`        // Announce falling below this, 1 for a topic emptying; 0 for none
+	Hysteresis int `json:"hysteresis,omitempty"` // How far back a crossing must go to be undone
+}
+
+// SubscriberWatermarkEvent is the payload of a crossing announced on
+// $sys/topics and passed to OnSubscriberWatermark
+type SubscriberWatermarkEvent struct {
+	Event       string    `json:"event"` // subscribers_high, subscribers_low or subscribers_normal
+	Namespace   string    `json:"namespace,omitempty"`
+	Topic       string    `json:"topic"`
+	Previous    int       `json:"previous"`    // Subscribers before the change
+	Subscribers int       `json:"subscribers"` // Subscribers after it
+	Threshold   int       `json:"threshold"`   // The watermark crossed
+	Timestamp   time.Time `json:"ts"`
+}
+
+// watermarkBand is where a topic's subscriber count stands against its
+// watermarks
+type watermarkBand int8
+
+const (
+	bandNormal watermarkBand = iota
+	bandHigh
+	bandLow
+)
+
+// enabled reports whether the watermarks can announce anything
+func (w *SubscriberWatermarks) enabled() bool {
+	return w != nil && (w.High > 0 || w.Low > 0)
+}
+
+// check rejects negative values and watermarks a count could cross both
+// at once
+func (w SubscriberWatermarks) check() error {
+	if w.High < 0 || w.Low < 0 || w.Hysteresis < 0 {
+		return fmt.Errorf("%w: high %d, low %d and hysteresis %d must not be negative", ErrInvalidSubscriberWatermarks, w.High, w.Low, w.Hysteresis)
+	}
+	if w.High > 0 && w.Low > w.High {
+		return fmt.Errorf("%w: low %d is above high %d", ErrInvalidSubscriberWatermarks, w.Low, w.High)
+	}
+	return nil
+}
+
+// band returns where count stands, given where it stood before
+func (w *SubscriberWatermarks) band(current watermarkBand, count int) watermarkBand {
+	switch {
+	case w.High > 0 && count > w.High:
+		return bandHigh
+	case w.Low > 0 && count < w.Low:
+		return bandLow
+	case current == bandHigh && w.High > 0 && count > w.High-w.Hysteresis:
+		return bandHigh
+	case current == bandLow && w.Low > 0 && count < w.Low+w.Hysteresis:
+		return bandLow
+	}
+	return bandNormal
+}
+
+// SetSubscriberWatermarks sets the server-wide subscriber watermarks, for
+// topics without their own. The zero value turns them off.
+func (ps *PubSubSystem) SetSubscriberWatermarks(watermarks SubscriberWatermarks) error {
+	if err := watermarks.check(); err != nil {
+		return err
+	}
+	if !watermarks.enabled() {
+		ps.watermarks.Store(nil)
+		return nil
+	}
+	ps.watermarks.Store(&watermarks)
+	return nil
+}
+
+// SubscriberWatermarks returns the server-wide subscriber watermarks
+func (ps *PubSubSystem) SubscriberWatermarks() SubscriberWatermarks {
+	if watermarks := ps.watermarks.Load(); watermarks != nil {
+		return *watermarks
+	}
+	return SubscriberWatermarks{}
+}
+
+// SetTopicSubscriberWatermarks overrides the subscriber watermarks for one
+// topic; the zero value turns them off there, and nil restores the
+// server's. The topic's count is measured against them from its next
+// change.
+func (ps *PubSubSystem) SetTopicSubscriberWatermarks(ctx context.Context, name string, watermarks *SubscriberWatermarks) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := checkUserTopic(name); err != nil {
+		return err
+	}
+	if watermarks != nil {
+		if err := watermarks.check(); err != nil {
+			return err
+		}
+		copied := *watermarks
+		watermarks = &copied
+	}
+	topic, exists := ps.topics.get(name)
+	if !exists {
+		return errorOf(ErrTopicNotFound, "topic %s not found", name)
+	}
+
+	topic.mutex.Lock()
+	topic.watermarks.Store(watermarks)
+	config := topic.config()
+	createdAt := topic.CreatedAt
+	topic.mutex.Unlock()
+
+	if ps.store != nil {
+		ps.store.TopicCreated(name, createdAt, config)
+	}
+	return nil
+}
+
+// crossWatermarks measures a topic's subscriber count, which was previous
+// before the change just made, against its watermarks. It returns the
+// crossing to announce, nil for none. Callers must hold the topic lock.
+func (ps *PubSubSystem) crossWatermarks(topic *Topic, previous int) *SubscriberWatermarkEvent {
+	watermarks := topic.watermarks.Load()
+	if watermarks == nil {
+		watermarks = ps.watermarks.Load()
+	}
+	if !watermarks.enabled() || IsSystemTopic(topic.Name) {
+		topic.subscriberBand = bandNormal
+		return nil
+	}
+
+	count := len(topic.Subscribers)
+	band := watermarks.band(topic.subscriberBand, count)
+	if band == topic.subscriberBand {
+		return nil
+	}
+	// A new topic is below its low watermark without having fallen there,
+	// as may be one whose watermarks changed; it is left to cross it
+	if (band == bandLow && count >= previous) || (band == bandHigh && count <= previous) {
+		return nil
+	}
+	from := topic.subscriberBand
+	topic.subscriberBand = band
+
+	ns, local := SplitTopic(topic.Name)
+	event := &SubscriberWatermarkEvent{
+		Namespace:   ns,
+		Topic:       local,
+		Previous:    previous,
+		Subscribers: count,
+		Timestamp:   ps.clock.Now(),
+	}
+	switch {
+	case band == bandHigh:
+		event.Event, event.Threshold = EventSubscribersHigh, watermarks.High
+	case band == bandLow:
+		event.Event, event.Threshold = EventSubscribersLow, watermarks.Low
+	case from == bandHigh:
+		event.Event, event.Threshold = EventSubscribersNormal, watermarks.High
+	default:
+		event.Event, event.Threshold = EventSubscribersNormal, watermarks.Low
+	}
+	return event
+}
+
+// announceWatermark publishes a crossing to $sys/topics and the
+// OnSubscriberWatermark hooks. Callers must not hold broker locks.
+func (ps *PubSubSystem) announceWatermark(event *SubscriberWatermarkEvent) {
+	if event == nil {
+		return
+	}
+	if sys := ps.sys.Load(); sys != nil {
+		ps.publishSystem(sys.topics, *event)
+	}
+	ps.emit(hookEvent{kind: hookSubscriberWatermark, watermark: event})
+}
@@ -0,0 +1,147 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// watermarkEvents returns the crossings sent to a $sys/topics subscriber,
+// without their timestamps
+func watermarkEvents(c *recordingClient) []SubscriberWatermarkEvent {
+	var events []SubscriberWatermarkEvent
+	for _, event := range c.received() {
+		if payload, ok := event.Message.Payload.(SubscriberWatermarkEvent); ok {
+			payload.Timestamp = time.Time{}
+			events = append(events, payload)
+		}
+	}
+	return events
+}
+
+func TestSubscriberWatermarksAnnounceEachCrossingOnce(t *testing.T) {
+	ps := New()
+	ps.EnableSystemTopics(time.Hour)
+	t.Cleanup(ps.Close)
+	ctx := context.Background()
+	if err := ps.SetSubscriberWatermarks(SubscriberWatermarks{High: 3, Low: 1, Hysteresis: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateTopic(ctx, "lobby"); err != nil {
+		t.Fatal(err)
+	}
+	monitor := &recordingClient{id: "monitor"}
+	subscribeClient(t, ps, SysTopicsTopic, monitor)
+
+	var mutex sync.Mutex
+	var hooked []string
+	ps.AddHooks(Hooks{OnSubscriberWatermark: func(event SubscriberWatermarkEvent) {
+		mutex.Lock()
+		hooked = append(hooked, event.Event)
+		mutex.Unlock()
+	}})
+
+	clients := make([]*recordingClient, 4)
+	subscribe := func(i int) {
+		if _, err := ps.Subscribe(ctx, clients[i].id, "lobby", 0, clients[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unsubscribe := func(i int) {
+		if err := ps.Unsubscribe(ctx, clients[i].id, "lobby"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range clients {
+		clients[i] = &recordingClient{id: string(rune('a' + i))}
+		subscribe(i)
+	}
+
+	// Churn around each watermark stays within the hysteresis
+	for i := 0; i < 5; i++ {
+		unsubscribe(3)
+		subscribe(3)
+	}
+	unsubscribe(3)
+	unsubscribe(2)
+	subscribe(2)
+	unsubscribe(2)
+	unsubscribe(1)
+	ps.DisconnectClient("a")
+	for i := 0; i < 5; i++ {
+		subscribe(0)
+		unsubscribe(0)
+	}
+	subscribe(0)
+	subscribe(1)
+
+	monitor.waitEvents(t, 4)
+	want := []SubscriberWatermarkEvent{
+		{Event: EventSubscribersHigh, Namespace: DefaultNamespace, Topic: "lobby", Previous: 3, Subscribers: 4, Threshold: 3},
+		{Event: EventSubscribersNormal, Namespace: DefaultNamespace, Topic: "lobby", Previous: 3, Subscribers: 2, Threshold: 3},
+		{Event: EventSubscribersLow, Namespace: DefaultNamespace, Topic: "lobby", Previous: 1, Subscribers: 0, Threshold: 1},
+		{Event: EventSubscribersNormal, Namespace: DefaultNamespace, Topic: "lobby", Previous: 1, Subscribers: 2, Threshold: 1},
+	}
+	if got := watermarkEvents(monitor); !reflect.DeepEqual(got, want) {
+		t.Errorf("$sys/topics got %+v, want %+v", got, want)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if want := []string{EventSubscribersHigh, EventSubscribersNormal, EventSubscribersLow, EventSubscribersNormal}; !reflect.DeepEqual(hooked, want) {
+		t.Errorf("hooks got %v, want %v", hooked, want)
+	}
+}
+
+func TestTopicSubscriberWatermarks(t *testing.T) {
+	ps := New()
+	ctx := context.Background()
+	var mutex sync.Mutex
+	var crossings []SubscriberWatermarkEvent
+	ps.AddHooks(Hooks{OnSubscriberWatermark: func(event SubscriberWatermarkEvent) {
+		mutex.Lock()
+		crossings = append(crossings, event)
+		mutex.Unlock()
+	}})
+	count := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(crossings)
+	}
+
+	// A topic created below its low watermark doesn't announce rising
+	if err := ps.CreateTopicWithConfig(ctx, "quiet", TopicConfig{SubscriberWatermarks: &SubscriberWatermarks{High: 2, Low: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		subscribeClient(t, ps, "quiet", &recordingClient{id: id})
+	}
+	if n := count(); n != 1 || crossings[0].Event != EventSubscribersHigh || crossings[0].Subscribers != 3 {
+		t.Fatalf("crossings = %+v", crossings)
+	}
+
+	// The zero value turns them off for the topic
+	if err := ps.SetTopicSubscriberWatermarks(ctx, "quiet", &SubscriberWatermarks{}); err != nil {
+		t.Fatal(err)
+	}
+	ps.DisconnectClient("a")
+	ps.DisconnectClient("b")
+	if n := count(); n != 1 {
+		t.Errorf("%d crossings with the watermarks off", n)
+	}
+	detail, _ := ps.GetTopicDetail("quiet")
+	if detail.Watermarks == nil || *detail.Watermarks != (SubscriberWatermarks{}) {
+		t.Errorf("detail watermarks = %+v", detail.Watermarks)
+	}
+
+	for _, watermarks := range []SubscriberWatermarks{{High: -1}, {High: 2, Low: 3}, {Low: 1, Hysteresis: -1}} {
+		if err := ps.SetTopicSubscriberWatermarks(ctx, "quiet", &watermarks); !errors.Is(err, ErrInvalidSubscriberWatermarks) {
+			t.Errorf("%+v: err = %v", watermarks, err)
+		}
+	}
+	if err := ps.SetTopicSubscriberWatermarks(ctx, "missing", nil); !errors.Is(err, ErrTopicNotFound) {
+		t.Errorf("missing topic: err = %v", err)
+	}
+}
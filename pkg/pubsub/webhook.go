@@ -0,0 +1,366 @@
+package pubsub
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultWebhookWorkers     = 8                      // Concurrent webhook deliveries
+	DefaultWebhookMaxAttempts = 5                      // Attempts before a delivery is abandoned
+	DefaultWebhookBackoff     = 500 * time.Millisecond // Delay before the first retry, doubled per attempt
+	DefaultWebhookTimeout     = 10 * time.Second       // Per-request HTTP timeout
+
+	webhookQueueSize     = 1024        // Pending deliveries before new ones are dropped
+	webhookFlushInterval = time.Second // How often partial batches are sent
+	webhookMaxBatchSize  = 1000        // Upper bound on batch_size
+	webhookSignatureHdr  = "X-Pubsub-Signature"
+)
+
+// Webhook is an HTTP endpoint that receives a topic's events
+type Webhook struct {
+	ID        string
+	Topic     string
+	URL       string
+	Secret    string
+	Events    string // Only "all" is supported
+	BatchSize int    // Events per POST; 1 sends a single object, more send an array
+	CreatedAt time.Time
+
+	mutex       sync.Mutex
+	pending     []EventResponse
+	failing     bool
+	failures    int64
+	delivered   int64
+	dropped     int64
+	lastError   string
+	lastFailure time.Time
+}
+
+// info returns the externally visible state of the webhook
+func (wh *Webhook) info() WebhookInfo {
+	wh.mutex.Lock()
+	defer wh.mutex.Unlock()
+
+	status := "active"
+	if wh.failing {
+		status = "failing"
+	}
+	info := WebhookInfo{
+		ID:        wh.ID,
+		URL:       wh.URL,
+		Events:    wh.Events,
+		BatchSize: wh.BatchSize,
+		Status:    status,
+		Delivered: wh.delivered,
+		Failures:  wh.failures,
+		Dropped:   wh.dropped,
+		LastError: wh.lastError,
+		CreatedAt: wh.CreatedAt,
+	}
+	if !wh.lastFailure.IsZero() {
+		lastFailure := wh.lastFailure
+		info.LastFailureAt = &lastFailure
+	}
+	return info
+}
+
+// webhookDelivery is a single POST to a webhook, possibly retried
+type webhookDelivery struct {
+	webhook *Webhook
+	events  []EventResponse
+	attempt int
+}
+
+// WebhookDispatcher delivers events to webhooks from a fixed worker pool so
+// slow endpoints never hold up websocket fan-out
+type WebhookDispatcher struct {
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+
+	jobs chan webhookDelivery
+
+	// Webhooks with a partial batch waiting for the flush ticker
+	batching map[*Webhook]bool
+
+	// Guards batching and the retry policy
+	mutex sync.Mutex
+}
+
+// NewWebhookDispatcher creates a dispatcher and starts its workers
+func NewWebhookDispatcher(workers, maxAttempts int, backoff time.Duration) *WebhookDispatcher {
+	wd := &WebhookDispatcher{
+		client:      &http.Client{Timeout: DefaultWebhookTimeout},
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		jobs:        make(chan webhookDelivery, webhookQueueSize),
+		batching:    make(map[*Webhook]bool),
+	}
+
+	for i := 0; i < workers; i++ {
+		go wd.worker()
+	}
+	go wd.flushLoop()
+
+	return wd
+}
+
+// Enqueue adds an event to a webhook's batch, dispatching it once full. It
+// never blocks the publisher: a full batch that finds the delivery queue
+// full is dropped and counted.
+func (wd *WebhookDispatcher) Enqueue(wh *Webhook, event EventResponse) {
+	wh.mutex.Lock()
+	wh.pending = append(wh.pending, event)
+	if len(wh.pending) < wh.BatchSize {
+		wh.mutex.Unlock()
+
+		wd.mutex.Lock()
+		wd.batching[wh] = true
+		wd.mutex.Unlock()
+		return
+	}
+	events := wh.pending
+	wh.pending = nil
+	wh.mutex.Unlock()
+
+	wd.submit(webhookDelivery{webhook: wh, events: events, attempt: 1})
+}
+
+// submit queues a delivery, dropping it when the queue is full
+func (wd *WebhookDispatcher) submit(delivery webhookDelivery) {
+	select {
+	case wd.jobs <- delivery:
+	default:
+		log.Printf("Webhook queue full, dropping %d events for webhook %s", len(delivery.events), delivery.webhook.ID)
+		wd.recordDrop(delivery.webhook, len(delivery.events))
+	}
+}
+
+// flushLoop periodically sends partial batches
+func (wd *WebhookDispatcher) flushLoop() {
+	ticker := time.NewTicker(webhookFlushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		wd.mutex.Lock()
+		webhooks := make([]*Webhook, 0, len(wd.batching))
+		for wh := range wd.batching {
+			webhooks = append(webhooks, wh)
+		}
+		wd.batching = make(map[*Webhook]bool)
+		wd.mutex.Unlock()
+
+		for _, wh := range webhooks {
+			wh.mutex.Lock()
+			events := wh.pending
+			wh.pending = nil
+			wh.mutex.Unlock()
+
+			if len(events) > 0 {
+				wd.submit(webhookDelivery{webhook: wh, events: events, attempt: 1})
+			}
+		}
+	}
+}
+
+// worker performs deliveries, scheduling retries with exponential backoff
+func (wd *WebhookDispatcher) worker() {
+	for delivery := range wd.jobs {
+		err := wd.deliver(delivery)
+		if err == nil {
+			wh := delivery.webhook
+			wh.mutex.Lock()
+			wh.failing = false
+			wh.delivered += int64(len(delivery.events))
+			wh.mutex.Unlock()
+			continue
+		}
+
+		maxAttempts, backoff := wd.retryPolicy()
+		if delivery.attempt >= maxAttempts {
+			log.Printf("Webhook %s failed after %d attempts: %v", delivery.webhook.ID, delivery.attempt, err)
+			wd.recordFailure(delivery.webhook, err, true)
+			continue
+		}
+
+		wd.recordFailure(delivery.webhook, err, false)
+		delay := backoff << (delivery.attempt - 1)
+		retry := delivery
+		retry.attempt++
+		time.AfterFunc(delay, func() { wd.submit(retry) })
+	}
+}
+
+// retryPolicy returns the current attempt limit and initial backoff
+func (wd *WebhookDispatcher) retryPolicy() (int, time.Duration) {
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+	return wd.maxAttempts, wd.backoff
+}
+
+// deliver POSTs the events to the webhook URL with an HMAC signature
+func (wd *WebhookDispatcher) deliver(delivery webhookDelivery) error {
+	wh := delivery.webhook
+
+	// Endpoints see topic names as the topic's namespace does
+	events := make([]interface{}, len(delivery.events))
+	for i, event := range delivery.events {
+		events[i] = LocalizeMessage(event)
+	}
+
+	var body []byte
+	var err error
+	if wh.BatchSize > 1 {
+		body, err = json.Marshal(events)
+	} else {
+		body, err = json.Marshal(events[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if wh.Secret != "" {
+		req.Header.Set(webhookSignatureHdr, "sha256="+signPayload(wh.Secret, body))
+	}
+
+	resp, err := wd.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// recordFailure notes a failed attempt; exhausted deliveries mark the webhook failing
+func (wd *WebhookDispatcher) recordFailure(wh *Webhook, err error, exhausted bool) {
+	wh.mutex.Lock()
+	defer wh.mutex.Unlock()
+
+	wh.failures++
+	wh.lastError = err.Error()
+	wh.lastFailure = time.Now()
+	if exhausted {
+		wh.failing = true
+	}
+}
+
+// recordDrop counts events lost to a full delivery queue and marks the
+// webhook failing
+func (wd *WebhookDispatcher) recordDrop(wh *Webhook, events int) {
+	wh.mutex.Lock()
+	wh.dropped += int64(events)
+	wh.mutex.Unlock()
+	wd.recordFailure(wh, fmt.Errorf("delivery queue full"), true)
+}
+
+// signPayload returns the hex HMAC-SHA256 of body keyed with secret
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// AddWebhook registers a webhook on a topic
+func (ps *PubSubSystem) AddWebhook(topicName string, req CreateWebhookRequest) (WebhookInfo, error) {
+	if req.URL == "" {
+		return WebhookInfo{}, errorOf(ErrInvalidRequest, "url is required")
+	}
+	if endpoint, err := url.Parse(req.URL); err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return WebhookInfo{}, errorOf(ErrInvalidRequest, "url must be an absolute http or https URL")
+	}
+	if req.Events == "" {
+		req.Events = "all"
+	}
+	if req.Events != "all" {
+		return WebhookInfo{}, errorOf(ErrInvalidRequest, "events must be \"all\"")
+	}
+	if req.BatchSize <= 0 {
+		req.BatchSize = 1
+	}
+	if req.BatchSize > webhookMaxBatchSize {
+		req.BatchSize = webhookMaxBatchSize
+	}
+
+	topic, exists := ps.topics.get(topicName)
+
+	if !exists {
+		return WebhookInfo{}, errorOf(ErrTopicNotFound, "topic %s not found", topicName)
+	}
+
+	wh := &Webhook{
+		ID:        uuid.New().String(),
+		Topic:     topicName,
+		URL:       req.URL,
+		Secret:    req.Secret,
+		Events:    req.Events,
+		BatchSize: req.BatchSize,
+		CreatedAt: ps.clock.Now(),
+	}
+
+	topic.mutex.Lock()
+	topic.Webhooks[wh.ID] = wh
+	topic.mutex.Unlock()
+
+	return wh.info(), nil
+}
+
+// RemoveWebhook unregisters a webhook from a topic
+func (ps *PubSubSystem) RemoveWebhook(topicName, webhookID string) error {
+	topic, exists := ps.topics.get(topicName)
+
+	if !exists {
+		return errorOf(ErrTopicNotFound, "topic %s not found", topicName)
+	}
+
+	topic.mutex.Lock()
+	defer topic.mutex.Unlock()
+
+	if _, ok := topic.Webhooks[webhookID]; !ok {
+		return errorOf(ErrNotFound, "webhook %s not found", webhookID)
+	}
+	delete(topic.Webhooks, webhookID)
+	return nil
+}
+
+// GetWebhooks lists a topic's webhooks with their delivery status
+func (ps *PubSubSystem) GetWebhooks(topicName string) ([]WebhookInfo, error) {
+	topic, exists := ps.topics.get(topicName)
+
+	if !exists {
+		return nil, errorOf(ErrTopicNotFound, "topic %s not found", topicName)
+	}
+
+	topic.mutex.RLock()
+	webhooks := make([]*Webhook, 0, len(topic.Webhooks))
+	for _, wh := range topic.Webhooks {
+		webhooks = append(webhooks, wh)
+	}
+	topic.mutex.RUnlock()
+
+	infos := make([]WebhookInfo, 0, len(webhooks))
+	for _, wh := range webhooks {
+		infos = append(infos, wh.info())
+	}
+	return infos, nil
+}
@@ -0,0 +1,165 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookEndpoint records the requests it receives, failing the first
+// failures of them with a 500
+type webhookEndpoint struct {
+	failures int
+
+	mutex    sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (e *webhookEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	e.mutex.Lock()
+	e.requests = append(e.requests, r)
+	e.bodies = append(e.bodies, body)
+	fail := len(e.requests) <= e.failures
+	e.mutex.Unlock()
+	if fail {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (e *webhookEndpoint) received() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return len(e.requests)
+}
+
+// webhookTopic creates "orders" with a webhook on a new endpoint
+func webhookTopic(t *testing.T, endpoint *webhookEndpoint, req CreateWebhookRequest) (*PubSubSystem, WebhookInfo) {
+	t.Helper()
+	server := httptest.NewServer(endpoint)
+	t.Cleanup(server.Close)
+
+	ps := New()
+	ps.SetWebhookRetryPolicy(3, time.Millisecond)
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	req.URL = server.URL
+	info, err := ps.AddWebhook("orders", req)
+	if err != nil {
+		t.Fatalf("AddWebhook: %v", err)
+	}
+	return ps, info
+}
+
+func webhookInfo(t *testing.T, ps *PubSubSystem) WebhookInfo {
+	t.Helper()
+	webhooks, err := ps.GetWebhooks("orders")
+	if err != nil || len(webhooks) != 1 {
+		t.Fatalf("GetWebhooks = %v, %v", webhooks, err)
+	}
+	return webhooks[0]
+}
+
+func TestWebhookRetriesUntilDelivered(t *testing.T) {
+	endpoint := &webhookEndpoint{failures: 2}
+	ps, _ := webhookTopic(t, endpoint, CreateWebhookRequest{Secret: "s3cret"})
+
+	publishN(t, ps, "orders", 1)
+	waitFor(t, "the third attempt", func() bool { return endpoint.received() == 3 })
+	waitFor(t, "the delivery to be counted", func() bool { return webhookInfo(t, ps).Delivered == 1 })
+
+	info := webhookInfo(t, ps)
+	if info.Status != "active" || info.Failures != 2 || info.LastError == "" {
+		t.Errorf("webhook after two failures and a success = %+v", info)
+	}
+
+	// Every attempt carries the same body, signed with the secret
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+	for i, body := range endpoint.bodies {
+		if got, want := endpoint.requests[i].Header.Get(webhookSignatureHdr), "sha256="+signPayload("s3cret", body); got != want {
+			t.Errorf("attempt %d signed %q, want %q", i+1, got, want)
+		}
+		var event EventResponse
+		if err := json.Unmarshal(body, &event); err != nil || event.Message.ID != "m0" || event.Seq != 1 {
+			t.Errorf("attempt %d posted %s", i+1, body)
+		}
+	}
+}
+
+func TestWebhookFailingAfterMaxAttempts(t *testing.T) {
+	endpoint := &webhookEndpoint{failures: 100}
+	ps, _ := webhookTopic(t, endpoint, CreateWebhookRequest{})
+
+	publishN(t, ps, "orders", 1)
+	waitFor(t, "the webhook to be marked failing", func() bool { return webhookInfo(t, ps).Status == "failing" })
+	if info := webhookInfo(t, ps); info.Failures != 3 || info.Delivered != 0 || info.LastFailureAt == nil {
+		t.Errorf("failing webhook = %+v", info)
+	}
+	if got := endpoint.received(); got != 3 {
+		t.Errorf("endpoint got %d attempts, want 3", got)
+	}
+	if endpoint.requests[0].Header.Get(webhookSignatureHdr) != "" {
+		t.Error("webhook without a secret sent a signature")
+	}
+}
+
+func TestWebhookBatches(t *testing.T) {
+	endpoint := &webhookEndpoint{}
+	ps, info := webhookTopic(t, endpoint, CreateWebhookRequest{BatchSize: 3})
+
+	publishN(t, ps, "orders", 3)
+	waitFor(t, "the batch", func() bool { return endpoint.received() == 1 })
+	var events []EventResponse
+	if err := json.Unmarshal(endpoint.bodies[0], &events); err != nil || len(events) != 3 {
+		t.Fatalf("batch = %s", endpoint.bodies[0])
+	}
+
+	if err := ps.RemoveWebhook("orders", info.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.RemoveWebhook("orders", info.ID); err == nil {
+		t.Errorf("removing twice = %v", err)
+	}
+	for _, url := range []string{"", "example.com/hook", "ftp://example.com/hook", "http://", "http://exa mple.com"} {
+		if _, err := ps.AddWebhook("orders", CreateWebhookRequest{URL: url}); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("webhook to %q = %v", url, err)
+		}
+	}
+}
+
+func TestFullWebhookQueueDropsBatch(t *testing.T) {
+	ps, _ := webhookTopic(t, &webhookEndpoint{}, CreateWebhookRequest{})
+	// Without workers the delivery queue never drains
+	ps.webhooks = NewWebhookDispatcher(0, 1, time.Millisecond)
+	publishN(t, ps, "orders", webhookQueueSize)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ps.Publish(context.Background(), "orders", MessageData{ID: "dropped"}, "")
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("publish to a full queue = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish blocked on the full webhook queue")
+	}
+
+	// Subscribers still got the message; only the webhook batch was dropped
+	if events := history(t, ps, "orders"); events[len(events)-1].Message.ID != "dropped" {
+		t.Errorf("history ends with %q", events[len(events)-1].Message.ID)
+	}
+	if info := webhookInfo(t, ps); info.Status != "failing" || info.Failures != 1 || info.Dropped != 1 {
+		t.Errorf("webhook after the dropped batch = %+v", info)
+	}
+}
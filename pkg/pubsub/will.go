@@ -0,0 +1,179 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/AnshulDekate/pubsub/pkg/clock"
+)
+
+// MaxWillDelay is the longest a last will may wait for its client to
+// reconnect
+const MaxWillDelay = time.Hour
+
+// ErrInvalidWill is returned for a last will that could never be published
+var ErrInvalidWill = errors.New("invalid last will")
+
+// LastWill is a message published on a client's behalf when its connection
+// drops without a clean close
+type LastWill struct {
+	Topic   string      `json:"topic"`
+	Message MessageData `json:"message"`
+
+	// How long to wait for the client to reconnect before publishing
+	DelayMs int64 `json:"delay_ms,omitempty"`
+
+	// When a pending will is published; set once the connection dropped
+	FiresAt *time.Time `json:"fires_at,omitempty"`
+}
+
+// lastWill is a registered will and, once its connection dropped, the
+// timer that publishes it
+type lastWill struct {
+	will  LastWill
+	timer clock.Timer
+}
+
+// lastWills holds one will per client ID
+type lastWills struct {
+	mutex    sync.Mutex
+	byClient map[string]*lastWill
+}
+
+// SetLastWill registers the will published for clientID if its connection
+// drops, replacing any earlier one. The will is checked like a publish now,
+// so a will that could never be published is refused with ErrInvalidWill.
+func (ps *PubSubSystem) SetLastWill(clientID string, will LastWill) error {
+	if will.Message.ID == "" {
+		return fmt.Errorf("%w: message.id is required", ErrInvalidWill)
+	}
+	if will.DelayMs < 0 || time.Duration(will.DelayMs)*time.Millisecond > MaxWillDelay {
+		return fmt.Errorf("%w: delay_ms must be 0 to %d", ErrInvalidWill, MaxWillDelay.Milliseconds())
+	}
+	if _, _, err := ps.checkPublish(context.Background(), will.Topic, will.Message, PublishOptions{}); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidWill, err)
+	}
+	will.FiresAt = nil
+
+	ps.wills.mutex.Lock()
+	defer ps.wills.mutex.Unlock()
+	if previous, exists := ps.wills.byClient[clientID]; exists && previous.timer != nil {
+		previous.timer.Stop()
+	}
+	ps.wills.byClient[clientID] = &lastWill{will: will}
+	return nil
+}
+
+// ClearLastWill forgets clientID's will without publishing it, as on a
+// clean close
+func (ps *PubSubSystem) ClearLastWill(clientID string) {
+	ps.wills.mutex.Lock()
+	defer ps.wills.mutex.Unlock()
+	if registered, exists := ps.wills.byClient[clientID]; exists {
+		if registered.timer != nil {
+			registered.timer.Stop()
+		}
+		delete(ps.wills.byClient, clientID)
+	}
+}
+
+// ReleaseLastWill starts the countdown of clientID's will after its
+// connection dropped. It is published once the delay passes, unless a
+// connection takes the client ID first.
+func (ps *PubSubSystem) ReleaseLastWill(clientID string) {
+	ps.wills.mutex.Lock()
+	defer ps.wills.mutex.Unlock()
+	registered, exists := ps.wills.byClient[clientID]
+	if !exists || registered.timer != nil {
+		return
+	}
+	delay := time.Duration(registered.will.DelayMs) * time.Millisecond
+	firesAt := ps.clock.Now().Add(delay)
+	registered.will.FiresAt = &firesAt
+	registered.timer = ps.clock.AfterFunc(delay, func() { ps.publishWill(clientID, registered) })
+}
+
+// LastWill returns the will registered for clientID
+func (ps *PubSubSystem) LastWill(clientID string) (LastWill, bool) {
+	ps.wills.mutex.Lock()
+	defer ps.wills.mutex.Unlock()
+	registered, exists := ps.wills.byClient[clientID]
+	if !exists {
+		return LastWill{}, false
+	}
+	return registered.will, true
+}
+
+// publishWill publishes a will whose delay passed, unless it was cancelled
+// meanwhile
+func (ps *PubSubSystem) publishWill(clientID string, registered *lastWill) {
+	ps.wills.mutex.Lock()
+	if ps.wills.byClient[clientID] != registered {
+		ps.wills.mutex.Unlock()
+		return
+	}
+	delete(ps.wills.byClient, clientID)
+	ps.wills.mutex.Unlock()
+
+	will := registered.will
+	if err := ps.Publish(context.Background(), will.Topic, will.Message, clientID); err != nil {
+		log.Printf("Error publishing last will of client %s to topic %s: %v", clientID, will.Topic, err)
+		return
+	}
+	log.Printf("Published last will of client %s to topic %s", clientID, will.Topic)
+}
+
+// cancelPending drops the will of a client ID a connection took again
+// before the will was published
+func (w *lastWills) cancelPending(clientID string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if registered, exists := w.byClient[clientID]; exists && registered.timer != nil {
+		registered.timer.Stop()
+		delete(w.byClient, clientID)
+	}
+}
+
+// rebind moves the will registered under a connection's old client ID to
+// the one it claimed, cancelling a pending will left there by an earlier
+// connection
+func (w *lastWills) rebind(oldID, newID string) {
+	w.cancelPending(newID)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if registered, exists := w.byClient[oldID]; exists && registered.timer == nil {
+		delete(w.byClient, oldID)
+		w.byClient[newID] = registered
+	}
+}
+
+// dropTopic forgets the wills that would publish to a deleted topic
+func (w *lastWills) dropTopic(topicName string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for clientID, registered := range w.byClient {
+		if registered.will.Topic != topicName {
+			continue
+		}
+		if registered.timer != nil {
+			registered.timer.Stop()
+		}
+		delete(w.byClient, clientID)
+	}
+}
+
+// stop cancels every pending will
+func (w *lastWills) stop() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, registered := range w.byClient {
+		if registered.timer != nil {
+			registered.timer.Stop()
+		}
+	}
+}
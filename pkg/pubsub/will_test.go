@@ -0,0 +1,63 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestLastWillPublishedAfterDrop(t *testing.T) {
+	ps := New()
+	defer ps.Close()
+	if err := ps.CreateTopic(context.Background(), "presence"); err != nil {
+		t.Fatal(err)
+	}
+	watcher := &recordingClient{id: "watcher"}
+	subscribeClient(t, ps, "presence", watcher)
+
+	will := LastWill{Topic: "presence", Message: MessageData{ID: "w1", Payload: "sensor-1 offline"}}
+	if err := ps.SetLastWill("conn-1", will); err != nil {
+		t.Fatal(err)
+	}
+	ps.RebindClient(&recordingClient{id: "sensor-1"}, "conn-1", "sensor-1")
+	detail, ok := ps.GetClientDetail("sensor-1")
+	if !ok || detail.LastWill == nil || detail.LastWill.Topic != "presence" {
+		t.Fatalf("client detail = %+v", detail)
+	}
+
+	ps.UnregisterClient("sensor-1")
+	ps.ReleaseLastWill("sensor-1")
+	if events := watcher.waitEvents(t, 1); events[0].Message.ID != "w1" {
+		t.Errorf("event = %+v, want the will", events[0])
+	}
+	if _, ok := ps.LastWill("sensor-1"); ok {
+		t.Error("will kept after it was published")
+	}
+
+	if err := ps.SetLastWill("sensor-1", LastWill{Topic: "missing", Message: MessageData{ID: "w2"}}); !errors.Is(err, ErrInvalidWill) {
+		t.Errorf("will for a missing topic: %v", err)
+	}
+}
+
+func TestLastWillDroppedWithTopic(t *testing.T) {
+	ps := New()
+	defer ps.Close()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "presence"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.SetLastWill("sensor-1", LastWill{Topic: "presence", Message: MessageData{ID: "w1"}, DelayMs: 60000}); err != nil {
+		t.Fatal(err)
+	}
+	ps.ReleaseLastWill("sensor-1")
+	if will, ok := ps.LastWill("sensor-1"); !ok || will.FiresAt == nil {
+		t.Fatalf("pending will = %+v, %v", will, ok)
+	}
+
+	if err := ps.DeleteTopic(ctx, "presence"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ps.LastWill("sensor-1"); ok {
+		t.Error("will kept after its topic was deleted")
+	}
+}
@@ -0,0 +1,650 @@
+// Package pubsubclient is a Go client for the broker's websocket API. It
+// correlates requests with their responses, keeps the connection alive and,
+// when the connection drops, reconnects with exponential backoff and
+// resubscribes from the last sequence number each subscription has seen, so
+// no event still held in topic history is lost.
+package pubsubclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Defaults for the zero values in Options
+const (
+	DefaultPingInterval   = 15 * time.Second
+	DefaultRequestTimeout = 10 * time.Second
+	DefaultMinBackoff     = 100 * time.Millisecond
+	DefaultMaxBackoff     = 10 * time.Second
+)
+
+var (
+	// ErrClosed is returned by calls on a closed client
+	ErrClosed = errors.New("pubsubclient: client closed")
+
+	// ErrDisconnected is returned by calls made, or still waiting for a
+	// response, while the connection is down
+	ErrDisconnected = errors.New("pubsubclient: disconnected")
+)
+
+// Options configures a Client. The zero value uses the defaults above.
+type Options struct {
+	// Claimed on every connection; empty adopts the server-assigned ID
+	ClientID string
+
+	// Namespace the client's topics live in; empty uses the one the URL
+	// names, or the default namespace
+	Namespace string
+
+	// Extra headers for the websocket handshake, e.g. Authorization
+	Header http.Header
+
+	// Defaults to websocket.DefaultDialer
+	Dialer *websocket.Dialer
+
+	// How often to ping the server; the connection is considered dead
+	// after two intervals without hearing from it
+	PingInterval time.Duration
+
+	// How long to wait for the server to answer a request
+	RequestTimeout time.Duration
+
+	// Reconnect delays grow from MinBackoff to MaxBackoff, with jitter
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Called for disconnects, lost events and errors that no call returns;
+	// nil logs them
+	OnError func(*Error)
+}
+
+// SubscribeOptions selects what a subscription replays before live events
+type SubscribeOptions struct {
+	// Replay this many events from history
+	LastN int
+
+	// Replay every event after this sequence number; takes precedence
+	// over LastN
+	SinceSeq int64
+
+	// Only deliver events with all of these headers
+	Headers map[string]string
+}
+
+// Handler receives a subscription's events. Handlers run on the
+// connection's read goroutine, in order, and must not block on calls to
+// the same client. event.Delivery is always set: Replay marks events
+// replayed from history, including those caught up after a reconnect, and
+// Redelivered with Attempt marks explicit-ack events sent again.
+type Handler func(event pubsub.EventResponse)
+
+// Client is a websocket connection to the broker that survives reconnects.
+// Its methods are safe for concurrent use.
+type Client struct {
+	url    string
+	opts   Options
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// Guards everything below; never held while writing or calling out
+	mutex    sync.Mutex
+	conn     *websocket.Conn
+	clientID string
+	subs     map[string]*subscription
+	pending  map[string]chan result
+
+	// Serializes writes to the connection
+	writeMutex sync.Mutex
+}
+
+// subscription is an active subscription and its position in the topic
+type subscription struct {
+	handler Handler
+	headers map[string]string
+	lastSeq int64
+
+	// Set once the server replays from lastSeq, so repeats are skipped
+	dedupe bool
+}
+
+// result is the server's answer to a request
+type result struct {
+	ack *pubsub.AckResponse
+	err error
+}
+
+// envelope holds the fields needed to route an incoming frame
+type envelope struct {
+	Type      string `json:"type"`
+	RequestID string `json:"request_id"`
+}
+
+// Connect dials the server at url (e.g. ws://localhost:8080/ws) and
+// negotiates protocol version 2. A failed first dial is returned rather
+// than retried; once connected the client reconnects on its own until
+// Close.
+func Connect(ctx context.Context, url string, opts Options) (*Client, error) {
+	if opts.Dialer == nil {
+		opts.Dialer = websocket.DefaultDialer
+	}
+	if opts.PingInterval <= 0 {
+		opts.PingInterval = DefaultPingInterval
+	}
+	if opts.RequestTimeout <= 0 {
+		opts.RequestTimeout = DefaultRequestTimeout
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultMinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = max(DefaultMaxBackoff, opts.MinBackoff)
+	}
+
+	c := &Client{
+		url:     url,
+		opts:    opts,
+		done:    make(chan struct{}),
+		subs:    make(map[string]*subscription),
+		pending: make(map[string]chan result),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	conn, clientID, err := c.dial(ctx)
+	if err != nil {
+		c.cancel()
+		return nil, err
+	}
+	c.conn = conn
+	c.clientID = clientID
+
+	go c.run(conn)
+	return c, nil
+}
+
+// ClientID returns the ID the connection is bound to
+func (c *Client) ClientID() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.clientID
+}
+
+// Subscribe starts delivering topic's events to handler. The subscription
+// is restored after every reconnect, continuing from the last event seen.
+func (c *Client) Subscribe(ctx context.Context, topic string, handler Handler, opts SubscribeOptions) error {
+	sub := &subscription{handler: handler, headers: opts.Headers, lastSeq: opts.SinceSeq, dedupe: opts.SinceSeq > 0}
+
+	// Register first: events replayed for since_seq arrive before the ack
+	c.mutex.Lock()
+	if _, exists := c.subs[topic]; exists {
+		c.mutex.Unlock()
+		return fmt.Errorf("pubsubclient: already subscribed to %s", topic)
+	}
+	c.subs[topic] = sub
+	c.mutex.Unlock()
+
+	_, err := c.request(ctx, func(requestID string) interface{} {
+		return pubsub.SubscribeRequest{
+			Type:      "subscribe",
+			Topic:     topic,
+			ClientID:  c.opts.ClientID,
+			LastN:     opts.LastN,
+			SinceSeq:  opts.SinceSeq,
+			Headers:   opts.Headers,
+			RequestID: requestID,
+		}
+	})
+	if err != nil {
+		c.forget(topic, sub)
+		return err
+	}
+	return nil
+}
+
+// Unsubscribe stops a subscription. No events are delivered for the topic
+// once it returns, even if the server could not be told.
+func (c *Client) Unsubscribe(ctx context.Context, topic string) error {
+	c.mutex.Lock()
+	delete(c.subs, topic)
+	c.mutex.Unlock()
+
+	_, err := c.request(ctx, func(requestID string) interface{} {
+		return pubsub.UnsubscribeRequest{
+			Type:      "unsubscribe",
+			Topic:     topic,
+			ClientID:  c.opts.ClientID,
+			RequestID: requestID,
+		}
+	})
+	return err
+}
+
+// Publish sends payload to topic under a fresh message ID and returns the
+// server's ack. Publishes are not retried: ErrDisconnected means the event
+// may or may not have been published.
+func (c *Client) Publish(ctx context.Context, topic string, payload interface{}) (*pubsub.AckResponse, error) {
+	return c.PublishWithKey(ctx, topic, "", payload)
+}
+
+// PublishWithKey publishes payload with an ordering key: the broker
+// delivers events with the same key on a topic in publish order
+func (c *Client) PublishWithKey(ctx context.Context, topic, orderingKey string, payload interface{}) (*pubsub.AckResponse, error) {
+	return c.publish(ctx, topic, orderingKey, pubsub.MessageData{ID: uuid.NewString(), Payload: payload})
+}
+
+// PublishWithHeaders publishes payload with headers, which subscriptions
+// can select events by without looking at the payload
+func (c *Client) PublishWithHeaders(ctx context.Context, topic string, headers map[string]string, payload interface{}) (*pubsub.AckResponse, error) {
+	return c.publish(ctx, topic, "", pubsub.MessageData{ID: uuid.NewString(), Payload: payload, Headers: headers})
+}
+
+// publish sends a publish request for message
+func (c *Client) publish(ctx context.Context, topic, orderingKey string, message pubsub.MessageData) (*pubsub.AckResponse, error) {
+	return c.request(ctx, func(requestID string) interface{} {
+		return pubsub.PublishRequest{
+			Type:        "publish",
+			Topic:       topic,
+			Message:     message,
+			ClientID:    c.opts.ClientID,
+			RequestID:   requestID,
+			OrderingKey: orderingKey,
+		}
+	})
+}
+
+// Close closes the connection and stops reconnecting. It must not be
+// called from a Handler.
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	if c.ctx.Err() != nil {
+		c.mutex.Unlock()
+		return ErrClosed
+	}
+	c.cancel()
+	conn := c.conn
+	c.mutex.Unlock()
+
+	if conn != nil {
+		c.writeMutex.Lock()
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(c.opts.RequestTimeout))
+		c.writeMutex.Unlock()
+		conn.Close()
+	}
+	<-c.done
+	return nil
+}
+
+// request sends the request built for a fresh request_id and waits for its
+// ack or error
+func (c *Client) request(ctx context.Context, build func(requestID string) interface{}) (*pubsub.AckResponse, error) {
+	requestID := uuid.NewString()
+	answer := make(chan result, 1)
+
+	c.mutex.Lock()
+	if c.ctx.Err() != nil {
+		c.mutex.Unlock()
+		return nil, ErrClosed
+	}
+	conn := c.conn
+	if conn == nil {
+		c.mutex.Unlock()
+		return nil, ErrDisconnected
+	}
+	c.pending[requestID] = answer
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		delete(c.pending, requestID)
+		c.mutex.Unlock()
+	}()
+
+	if err := c.write(conn, build(requestID)); err != nil {
+		conn.Close()
+		return nil, ErrDisconnected
+	}
+
+	timer := time.NewTimer(c.opts.RequestTimeout)
+	defer timer.Stop()
+	select {
+	case res := <-answer:
+		return res.ack, res.err
+	case <-timer.C:
+		return nil, fmt.Errorf("pubsubclient: no response within %s", c.opts.RequestTimeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		return nil, ErrClosed
+	}
+}
+
+// write sends one JSON frame
+func (c *Client) write(conn *websocket.Conn, message interface{}) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(c.opts.RequestTimeout))
+	return conn.WriteJSON(message)
+}
+
+// dial opens a connection, reads the welcome frame and negotiates the
+// protocol version
+func (c *Client) dial(ctx context.Context) (*websocket.Conn, string, error) {
+	conn, _, err := c.opts.Dialer.DialContext(ctx, c.url, c.opts.Header)
+	if err != nil {
+		return nil, "", err
+	}
+	conn.SetReadDeadline(time.Now().Add(c.opts.RequestTimeout))
+
+	// The welcome frame predates negotiation, so it uses the v1 shape
+	var welcome pubsub.EventResponse
+	if err := conn.ReadJSON(&welcome); err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+	fields, _ := welcome.Message.Payload.(map[string]interface{})
+	clientID, _ := fields["client_id"].(string)
+	if welcome.Type != "welcome" || clientID == "" {
+		conn.Close()
+		return nil, "", fmt.Errorf("pubsubclient: expected a welcome frame, got %q", welcome.Type)
+	}
+
+	hello := pubsub.HelloRequest{Type: "hello", ProtocolVersion: pubsub.ProtocolVersion2, Namespace: c.opts.Namespace, RequestID: "hello"}
+	if err := c.write(conn, hello); err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+	var helloAck pubsub.ErrorResponse
+	if err := conn.ReadJSON(&helloAck); err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+	if helloAck.Type != "hello_ack" {
+		conn.Close()
+		return nil, "", &Error{Kind: KindServer, Code: helloAck.Error.Code, Reason: helloAck.Error.Reason, Retryable: helloAck.Error.Retryable, Message: helloAck.Error.Message}
+	}
+
+	if c.opts.ClientID != "" {
+		clientID = c.opts.ClientID
+	}
+	return conn, clientID, nil
+}
+
+// run reads from conn until it fails, then reconnects, until Close
+func (c *Client) run(conn *websocket.Conn) {
+	defer close(c.done)
+	for {
+		err := c.serve(conn)
+		if !c.disconnected(conn, err) {
+			return
+		}
+		if conn = c.reconnect(); conn == nil {
+			return
+		}
+		go c.resubscribe(conn)
+	}
+}
+
+// serve keeps conn alive and dispatches its frames until a read fails
+func (c *Client) serve(conn *websocket.Conn) error {
+	deadline := 2 * c.opts.PingInterval
+	conn.SetReadDeadline(time.Now().Add(deadline))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(deadline))
+	})
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(deadline))
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(c.opts.RequestTimeout))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		return err
+	})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.keepalive(conn, stop)
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(deadline))
+		c.handleFrame(data)
+	}
+}
+
+// keepalive pings the server every PingInterval until stop is closed
+func (c *Client) keepalive(conn *websocket.Conn, stop chan struct{}) {
+	ticker := time.NewTicker(c.opts.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.opts.RequestTimeout)); err != nil {
+				conn.Close()
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// handleFrame routes one incoming frame
+func (c *Client) handleFrame(data []byte) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		c.report(&Error{Kind: KindServer, Message: "unreadable frame", Err: err})
+		return
+	}
+
+	switch env.Type {
+	case "event":
+		var event pubsub.EventResponse
+		if err := json.Unmarshal(data, &event); err != nil {
+			c.report(&Error{Kind: KindServer, Message: "unreadable event", Err: err})
+			return
+		}
+		c.dispatch(event)
+	case "ack":
+		var ack pubsub.AckResponse
+		if err := json.Unmarshal(data, &ack); err == nil {
+			c.answer(env.RequestID, result{ack: &ack})
+		}
+	case "error":
+		var resp pubsub.ErrorResponse
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return
+		}
+		serverErr := &Error{Kind: KindServer, Code: resp.Error.Code, Reason: resp.Error.Reason, Retryable: resp.Error.Retryable, Message: resp.Error.Message}
+		if !c.answer(env.RequestID, result{err: serverErr}) {
+			c.report(serverErr)
+		}
+	case "info":
+		var info pubsub.InfoResponse
+		if err := json.Unmarshal(data, &info); err == nil && info.Message == "topic_deleted" {
+			c.mutex.Lock()
+			delete(c.subs, info.Topic)
+			c.mutex.Unlock()
+			c.report(&Error{Kind: KindTopicDeleted, Topic: info.Topic, Message: "topic " + info.Topic + " was deleted"})
+		}
+	}
+}
+
+// answer hands a response to the request waiting for it
+func (c *Client) answer(requestID string, res result) bool {
+	c.mutex.Lock()
+	answer, ok := c.pending[requestID]
+	delete(c.pending, requestID)
+	c.mutex.Unlock()
+	if ok {
+		answer <- res
+	}
+	return ok
+}
+
+// dispatch delivers an event to its subscription, skipping events already
+// delivered and reporting any sequence numbers skipped over
+func (c *Client) dispatch(event pubsub.EventResponse) {
+	c.mutex.Lock()
+	sub, ok := c.subs[event.Topic]
+	if !ok {
+		c.mutex.Unlock()
+		return
+	}
+	var missing int64
+	if event.Seq > 0 {
+		if sub.dedupe && event.Seq <= sub.lastSeq {
+			c.mutex.Unlock()
+			return
+		}
+		if sub.lastSeq > 0 && event.Seq > sub.lastSeq+1 {
+			missing = event.Seq - sub.lastSeq - 1
+		}
+		sub.lastSeq = max(sub.lastSeq, event.Seq)
+	}
+	handler := sub.handler
+	c.mutex.Unlock()
+
+	// Servers from before delivery metadata send none
+	if event.Delivery == nil {
+		event.Delivery = &pubsub.DeliveryInfo{Redelivered: event.Redelivered, Attempt: 1}
+	}
+	if missing > 0 {
+		c.report(&Error{
+			Kind:    KindGap,
+			Topic:   event.Topic,
+			Message: fmt.Sprintf("%d events before seq %d were lost", missing, event.Seq),
+			Missing: missing,
+		})
+	}
+	handler(event)
+}
+
+// disconnected fails the pending requests of a dead connection and reports
+// why it ended. It returns false once the client is closed.
+func (c *Client) disconnected(conn *websocket.Conn, err error) bool {
+	conn.Close()
+
+	c.mutex.Lock()
+	c.conn = nil
+	pending := c.pending
+	c.pending = make(map[string]chan result)
+	closed := c.ctx.Err() != nil
+	c.mutex.Unlock()
+
+	for _, answer := range pending {
+		answer <- result{err: ErrDisconnected}
+	}
+	if closed {
+		return false
+	}
+	c.report(&Error{Kind: KindDisconnected, Message: "connection lost", Err: err})
+	return true
+}
+
+// reconnect dials until it succeeds, waiting longer after each failure. It
+// returns nil once the client is closed.
+func (c *Client) reconnect() *websocket.Conn {
+	backoff := c.opts.MinBackoff
+	for {
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-time.After(wait):
+		case <-c.ctx.Done():
+			return nil
+		}
+
+		conn, clientID, err := c.dial(c.ctx)
+		if err == nil {
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			if c.ctx.Err() != nil {
+				conn.Close()
+				return nil
+			}
+			c.conn = conn
+			c.clientID = clientID
+			return conn
+		}
+		c.report(&Error{Kind: KindDisconnected, Message: "reconnect failed", Err: err})
+		backoff = min(2*backoff, c.opts.MaxBackoff)
+	}
+}
+
+// resubscribe restores every subscription on a new connection, replaying
+// from the last event each one saw
+func (c *Client) resubscribe(conn *websocket.Conn) {
+	c.mutex.Lock()
+	subs := make(map[string]*subscription, len(c.subs))
+	for topic, sub := range c.subs {
+		subs[topic] = sub
+		if sub.lastSeq > 0 {
+			sub.dedupe = true
+		}
+	}
+	c.mutex.Unlock()
+
+	for topic, sub := range subs {
+		c.mutex.Lock()
+		sinceSeq := sub.lastSeq
+		c.mutex.Unlock()
+
+		_, err := c.request(c.ctx, func(requestID string) interface{} {
+			return pubsub.SubscribeRequest{
+				Type:      "subscribe",
+				Topic:     topic,
+				ClientID:  c.opts.ClientID,
+				SinceSeq:  sinceSeq,
+				Headers:   sub.headers,
+				RequestID: requestID,
+			}
+		})
+		if err == nil {
+			continue
+		}
+
+		var serverErr *Error
+		if !errors.As(err, &serverErr) {
+			// The connection is gone again; the next one retries
+			return
+		}
+		if serverErr.Reason == "CLIENT_ID_IN_USE" {
+			// The server hasn't noticed the old connection drop yet
+			conn.Close()
+			return
+		}
+		c.forget(topic, sub)
+		c.report(&Error{Kind: KindResubscribe, Topic: topic, Code: serverErr.Code, Reason: serverErr.Reason, Message: serverErr.Message, Err: err})
+	}
+}
+
+// forget removes sub unless it has since been replaced
+func (c *Client) forget(topic string, sub *subscription) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.subs[topic] == sub {
+		delete(c.subs, topic)
+	}
+}
+
+// report passes an error to OnError, or logs it
+func (c *Client) report(err *Error) {
+	if c.opts.OnError != nil {
+		c.opts.OnError(err)
+		return
+	}
+	log.Printf("pubsubclient: %v", err)
+}
@@ -0,0 +1,402 @@
+package pubsubclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+	"github.com/AnshulDekate/pubsub/pkg/transport/ws"
+)
+
+// network dials the test server and can drop its connections or refuse
+// new ones, as a flaky network would
+type network struct {
+	mutex sync.Mutex
+	conns []net.Conn
+	down  atomic.Bool
+}
+
+func (n *network) dialer() *websocket.Dialer {
+	return &websocket.Dialer{NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if n.down.Load() {
+			return nil, errors.New("network down")
+		}
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		if err == nil {
+			n.mutex.Lock()
+			n.conns = append(n.conns, conn)
+			n.mutex.Unlock()
+		}
+		return conn, err
+	}}
+}
+
+// drop cuts every connection without a close frame
+func (n *network) drop() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for _, conn := range n.conns {
+		conn.Close()
+	}
+	n.conns = nil
+}
+
+// errorLog collects what a client reports to OnError
+type errorLog struct {
+	mutex  sync.Mutex
+	errors []*Error
+}
+
+func (l *errorLog) report(err *Error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.errors = append(l.errors, err)
+}
+
+func (l *errorLog) of(kind ErrorKind) []*Error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	var found []*Error
+	for _, err := range l.errors {
+		if err.Kind == kind {
+			found = append(found, err)
+		}
+	}
+	return found
+}
+
+// collector is a Handler that keeps the events it is given
+type collector struct {
+	mutex  sync.Mutex
+	events []pubsub.EventResponse
+}
+
+func (c *collector) handle(event pubsub.EventResponse) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.events = append(c.events, event)
+}
+
+func (c *collector) seqs() []int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	seqs := make([]int64, len(c.events))
+	for i, event := range c.events {
+		seqs[i] = event.Seq
+	}
+	return seqs
+}
+
+func (c *collector) waitFor(t *testing.T, n int) []int64 {
+	t.Helper()
+	waitFor(t, "events", func() bool { return len(c.seqs()) >= n })
+	return c.seqs()
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// serve runs the broker's websocket handler with an "orders" topic and
+// returns its URL
+func serve(t *testing.T) (*pubsub.PubSubSystem, string) {
+	t.Helper()
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	return ps, serveSystem(t, ps)
+}
+
+func serveSystem(t *testing.T, ps *pubsub.PubSubSystem) string {
+	t.Helper()
+	h, err := ws.NewHandler(ps, ws.WebSocketOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func connect(t *testing.T, url string, opts Options) *Client {
+	t.Helper()
+	if opts.MinBackoff == 0 {
+		opts.MinBackoff = 5 * time.Millisecond
+		opts.MaxBackoff = 20 * time.Millisecond
+	}
+	c, err := Connect(context.Background(), url, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// publish publishes n numbered events to orders from the server side
+func publish(t *testing.T, ps *pubsub.PubSubSystem, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := ps.Publish(context.Background(), "orders", pubsub.MessageData{ID: "m", Payload: i}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func consecutive(seqs []int64, from, to int64) bool {
+	if int64(len(seqs)) != to-from+1 {
+		return false
+	}
+	for i, seq := range seqs {
+		if seq != from+int64(i) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPublishAndSubscribe(t *testing.T) {
+	_, url := serve(t)
+	subscriber := connect(t, url, Options{})
+	if subscriber.ClientID() == "" {
+		t.Error("no client ID assigned")
+	}
+	var events collector
+	if err := subscriber.Subscribe(context.Background(), "orders", events.handle, SubscribeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	publisher := connect(t, url, Options{ClientID: "publisher"})
+	if publisher.ClientID() != "publisher" {
+		t.Errorf("claimed publisher, bound to %s", publisher.ClientID())
+	}
+	ack, err := publisher.Publish(context.Background(), "orders", map[string]interface{}{"id": "o1"})
+	if err != nil || ack.Status != "ok" || ack.Topic != "orders" {
+		t.Fatalf("publish = %+v, %v", ack, err)
+	}
+	if _, err := publisher.PublishWithHeaders(context.Background(), "orders", map[string]string{"region": "eu"}, "o2"); err != nil {
+		t.Fatal(err)
+	}
+	events.waitFor(t, 2)
+	first, second := events.events[0], events.events[1]
+	if payload, _ := first.Message.Payload.(map[string]interface{}); payload["id"] != "o1" {
+		t.Errorf("first event = %+v", first)
+	}
+	if second.Message.Headers["region"] != "eu" {
+		t.Errorf("second event = %+v", second)
+	}
+
+	// Nothing arrives once unsubscribed
+	if err := subscriber.Unsubscribe(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := publisher.Publish(context.Background(), "orders", "o3"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got := len(events.seqs()); got != 2 {
+		t.Errorf("%d events delivered after unsubscribing", got)
+	}
+}
+
+func TestSubscribeReplay(t *testing.T) {
+	ps, url := serve(t)
+	publish(t, ps, 5)
+	c := connect(t, url, Options{})
+	ctx := context.Background()
+
+	var lastN collector
+	if err := c.Subscribe(ctx, "orders", lastN.handle, SubscribeOptions{LastN: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if seqs := lastN.waitFor(t, 2); !consecutive(seqs, 4, 5) {
+		t.Errorf("last_n 2 replayed %v", seqs)
+	}
+	if err := c.Unsubscribe(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+
+	var since collector
+	if err := c.Subscribe(ctx, "orders", since.handle, SubscribeOptions{SinceSeq: 2, LastN: 1}); err != nil {
+		t.Fatal(err)
+	}
+	publish(t, ps, 1)
+	if seqs := since.waitFor(t, 4); !consecutive(seqs, 3, 6) {
+		t.Errorf("since_seq 2 delivered %v", seqs)
+	}
+}
+
+func TestRequestErrors(t *testing.T) {
+	ps, url := serve(t)
+	c := connect(t, url, Options{})
+	ctx := context.Background()
+	var events collector
+
+	err := c.Subscribe(ctx, "missing", events.handle, SubscribeOptions{})
+	var serverErr *Error
+	if !errors.As(err, &serverErr) || serverErr.Kind != KindServer || serverErr.Code != "TOPIC_NOT_FOUND" || serverErr.Retryable {
+		t.Fatalf("subscribing to a missing topic = %v", err)
+	}
+	// A refused subscription isn't kept
+	if err := ps.CreateTopic(ctx, "missing"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Subscribe(ctx, "missing", events.handle, SubscribeOptions{}); err != nil {
+		t.Errorf("subscribing once the topic exists = %v", err)
+	}
+	if err := c.Subscribe(ctx, "missing", events.handle, SubscribeOptions{}); err == nil || !strings.Contains(err.Error(), "already subscribed") {
+		t.Errorf("subscribing twice = %v", err)
+	}
+	if _, err := c.Publish(ctx, "elsewhere", "x"); !errors.As(err, &serverErr) || serverErr.Code != "TOPIC_NOT_FOUND" {
+		t.Errorf("publishing to a missing topic = %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Publish(ctx, "orders", "x"); !errors.Is(err, ErrClosed) {
+		t.Errorf("publishing after Close = %v", err)
+	}
+	if err := c.Close(); !errors.Is(err, ErrClosed) {
+		t.Errorf("closing twice = %v", err)
+	}
+}
+
+func TestReconnectLosesNothing(t *testing.T) {
+	ps, url := serve(t)
+	var net network
+	var errs errorLog
+	c := connect(t, url, Options{Dialer: net.dialer(), OnError: errs.report})
+	var events collector
+	if err := c.Subscribe(context.Background(), "orders", events.handle, SubscribeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Publish steadily while the connection is cut twice mid-stream
+	const total = 300
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < total; i++ {
+			if err := ps.Publish(context.Background(), "orders", pubsub.MessageData{ID: "m", Payload: i}, ""); err != nil {
+				t.Error(err)
+				return
+			}
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+	events.waitFor(t, 50)
+	net.drop()
+	events.waitFor(t, 150)
+	net.drop()
+	<-done
+
+	// Every event exactly once, in order, with the replayed ones marked
+	if seqs := events.waitFor(t, total); !consecutive(seqs, 1, total) {
+		t.Fatalf("delivered %d events: %v", len(seqs), seqs)
+	}
+	if got := len(errs.of(KindDisconnected)); got < 2 {
+		t.Errorf("%d disconnects reported, want at least 2", got)
+	}
+	if gaps := errs.of(KindGap); len(gaps) != 0 {
+		t.Errorf("gaps reported: %v", gaps[0])
+	}
+	// Requests work on the new connection
+	if _, err := c.Publish(context.Background(), "orders", "after"); err != nil {
+		t.Errorf("publishing after reconnecting = %v", err)
+	}
+}
+
+func TestReconnectReportsGap(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	url := serveSystem(t, ps)
+	var net network
+	var errs errorLog
+	c := connect(t, url, Options{Dialer: net.dialer(), OnError: errs.report})
+	var events collector
+	if err := c.Subscribe(context.Background(), "orders", events.handle, SubscribeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	publish(t, ps, 3)
+	events.waitFor(t, 3)
+
+	// Events published while disconnected are purged from history before
+	// the client is back
+	net.down.Store(true)
+	net.drop()
+	waitFor(t, "the disconnect", func() bool { return len(errs.of(KindDisconnected)) > 0 })
+	if _, err := c.Publish(context.Background(), "orders", "x"); !errors.Is(err, ErrDisconnected) {
+		t.Errorf("publishing while disconnected = %v", err)
+	}
+	publish(t, ps, 4)
+	if _, err := ps.PurgeTopicHistory("orders", time.Time{}, 0); err != nil {
+		t.Fatal(err)
+	}
+	publish(t, ps, 2)
+	net.down.Store(false)
+
+	waitFor(t, "the gap", func() bool { return len(errs.of(KindGap)) > 0 })
+	if gap := errs.of(KindGap)[0]; gap.Topic != "orders" || gap.Missing != 4 {
+		t.Errorf("gap = %+v, want 4 missing", gap)
+	}
+	if seqs := events.waitFor(t, 5); !consecutive(seqs[3:], 8, 9) {
+		t.Errorf("after the gap, delivered %v", seqs[3:])
+	}
+}
+
+func TestDeliveryFlags(t *testing.T) {
+	ps, url := serve(t)
+	publish(t, ps, 3)
+	var net network
+	var errs errorLog
+	c := connect(t, url, Options{Dialer: net.dialer(), OnError: errs.report})
+	var events collector
+	if err := c.Subscribe(context.Background(), "orders", events.handle, SubscribeOptions{LastN: 2}); err != nil {
+		t.Fatal(err)
+	}
+	events.waitFor(t, 2)
+	publish(t, ps, 1)
+	events.waitFor(t, 3)
+
+	// Events published while disconnected are caught up as a replay
+	net.down.Store(true)
+	net.drop()
+	waitFor(t, "the disconnect", func() bool { return len(errs.of(KindDisconnected)) > 0 })
+	publish(t, ps, 3)
+	net.down.Store(false)
+	events.waitFor(t, 6)
+	publish(t, ps, 1)
+	if seqs := events.waitFor(t, 7); !consecutive(seqs, 2, 8) {
+		t.Fatalf("delivered %v", seqs)
+	}
+
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+	for i, event := range events.events {
+		// The last_n pair and the catch-up are replays; the rest are live
+		replayed := i < 2 || (i >= 3 && i < 6)
+		want := pubsub.DeliveryInfo{Attempt: 1, Replay: replayed}
+		if event.Delivery == nil || *event.Delivery != want {
+			t.Errorf("seq %d delivery = %+v, want %+v", event.Seq, event.Delivery, want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package pubsubclient
+
+import "fmt"
+
+// ErrorKind classifies an Error
+type ErrorKind string
+
+const (
+	// KindServer is an error response from the server
+	KindServer ErrorKind = "server"
+
+	// KindDisconnected reports a lost connection or a failed reconnect
+	KindDisconnected ErrorKind = "disconnected"
+
+	// KindGap reports events the server no longer held when a
+	// subscription caught up, or dropped for a slow connection
+	KindGap ErrorKind = "gap"
+
+	// KindResubscribe reports a subscription the server refused to
+	// restore after a reconnect; it is no longer active
+	KindResubscribe ErrorKind = "resubscribe"
+
+	// KindTopicDeleted reports a subscription ended by topic deletion
+	KindTopicDeleted ErrorKind = "topic_deleted"
+)
+
+// Error is returned for server error responses and passed to
+// Options.OnError for problems no call returns
+type Error struct {
+	Kind      ErrorKind
+	Topic     string // Set for subscription problems
+	Code      string // The server's error code, e.g. TOPIC_NOT_FOUND
+	Reason    string // The server's more specific code, if any, e.g. JOIN_REQUIRED
+	Retryable bool   // Whether the server says the request may succeed if sent again
+	Message   string
+	Missing   int64 // Events lost, for KindGap
+	Err       error // Underlying cause, if any
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	msg := string(e.Kind)
+	if e.Topic != "" {
+		msg += " on " + e.Topic
+	}
+	if e.Code != "" {
+		msg += fmt.Sprintf(" (%s)", e.Code)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the underlying cause
+func (e *Error) Unwrap() error {
+	return e.Err
+}
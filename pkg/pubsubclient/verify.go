@@ -0,0 +1,21 @@
+package pubsubclient
+
+import "github.com/AnshulDekate/pubsub/pkg/pubsub"
+
+// VerifyEvent checks the signature of an event delivered to a client of
+// namespace (empty for the default namespace) against keys, by key ID. It
+// returns pubsub.ErrUnsignedEvent for an unsigned event,
+// pubsub.ErrUnknownSigningKey for a key ID missing from keys and
+// pubsub.ErrInvalidSignature for an event that was changed.
+func VerifyEvent(event pubsub.EventResponse, namespace string, keys map[string][]byte) error {
+	// Events reach a namespace's clients under the topic's local name, but
+	// are signed under its internal one
+	if namespace != "" && event.Namespace == "" {
+		topic, err := pubsub.QualifyTopic(namespace, event.Topic)
+		if err != nil {
+			return err
+		}
+		event.Topic = topic
+	}
+	return pubsub.VerifyEvent(event, keys)
+}
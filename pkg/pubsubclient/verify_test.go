@@ -0,0 +1,59 @@
+package pubsubclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+func TestVerifyDeliveredEvents(t *testing.T) {
+	keys := map[string][]byte{"v1": []byte("0123456789abcdef-v1")}
+	ps := pubsub.New()
+	if err := ps.SetSigningKeys(keys); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, name := range []string{"orders", "acme::orders"} {
+		if err := ps.CreateTopicWithConfig(ctx, name, pubsub.TopicConfig{SigningKeyID: "v1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	url := serveSystem(t, ps)
+
+	for _, tc := range []struct {
+		namespace, topic, other string
+	}{
+		{"", "orders", "acme"},
+		{"acme", "acme::orders", ""},
+	} {
+		namespace := tc.namespace
+		c := connect(t, url, Options{Namespace: namespace})
+		var events collector
+		if err := c.Subscribe(ctx, "orders", events.handle, SubscribeOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := ps.Publish(ctx, tc.topic, pubsub.MessageData{ID: "o1", Payload: map[string]interface{}{"amount": 42}}, ""); err != nil {
+			t.Fatal(err)
+		}
+		events.waitFor(t, 1)
+		event := events.events[0]
+
+		// Events arrive under the local topic name and verify within their
+		// namespace only
+		if event.Topic != "orders" {
+			t.Errorf("namespace %q: delivered on %s", namespace, event.Topic)
+		}
+		if err := VerifyEvent(event, namespace, keys); err != nil {
+			t.Errorf("namespace %q: %v", namespace, err)
+		}
+		if err := VerifyEvent(event, tc.other, keys); !errors.Is(err, pubsub.ErrInvalidSignature) {
+			t.Errorf("namespace %q event verified in %q: %v", namespace, tc.other, err)
+		}
+		event.Message.Payload = map[string]interface{}{"amount": 1}
+		if err := VerifyEvent(event, namespace, keys); !errors.Is(err, pubsub.ErrInvalidSignature) {
+			t.Errorf("namespace %q: changed payload: %v", namespace, err)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+// Package grpcapi exposes a pubsub.PubSubSystem over the gRPC services
+// defined in proto/pubsub.proto.
+package grpcapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+	"github.com/AnshulDekate/pubsub/pkg/pubsubpb"
+)
+
+// grpcClient adapts a Subscribe stream to ClientInterface. It buffers and
+// drops exactly like a websocket Client so slow consumers get the same
+// backpressure policy on both transports.
+type grpcClient struct {
+	clientID string
+	events   chan pubsub.EventResponse
+}
+
+func newGRPCClient(clientID string) *grpcClient {
+	return &grpcClient{
+		clientID: clientID,
+		events:   make(chan pubsub.EventResponse, pubsub.ClientSendBufferSize),
+	}
+}
+
+func (gc *grpcClient) GetClientID() string {
+	return gc.clientID
+}
+
+func (gc *grpcClient) IsConnected() bool {
+	return true
+}
+
+func (gc *grpcClient) SendMessage(msg interface{}) error {
+	var event pubsub.EventResponse
+	switch m := msg.(type) {
+	case *pubsub.PreparedEvent:
+		event = m.Event
+	case pubsub.EventResponse:
+		event = m
+	case pubsub.InfoResponse:
+		event = pubsub.EventResponse{
+			Type:      m.Type,
+			Topic:     m.Topic,
+			Message:   pubsub.MessageData{Payload: m.EventPayload()},
+			Timestamp: m.Timestamp,
+		}
+	default:
+		return pubsub.ErrorData{Code: pubsub.CodeInternal, Message: "Unknown message type to send"}
+	}
+
+	select {
+	case gc.events <- event:
+		return nil
+	default:
+		return pubsub.ErrorData{Code: pubsub.CodeServerBusy, Reason: "CLIENT_OVERLOADED", Message: "Client stream buffer is full"}
+	}
+}
+
+func (gc *grpcClient) GetLastActive() time.Time {
+	return time.Now()
+}
+
+// GRPCServer exposes the PubSubSystem over gRPC
+type GRPCServer struct {
+	pubsubpb.UnimplementedPubSubServer
+	pubsubpb.UnimplementedTopicAdminServer
+
+	ps *pubsub.PubSubSystem
+}
+
+// NewGRPCServer creates a grpc.Server with the PubSub and TopicAdmin services registered
+func NewGRPCServer(ps *pubsub.PubSubSystem, opts ...grpc.ServerOption) *grpc.Server {
+	server := grpc.NewServer(opts...)
+	svc := &GRPCServer{ps: ps}
+	pubsubpb.RegisterPubSubServer(server, svc)
+	pubsubpb.RegisterTopicAdminServer(server, svc)
+	return server
+}
+
+// ServeGRPC serves gRPC on the given address until the server is stopped
+func ServeGRPC(server *grpc.Server, addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return server.Serve(lis)
+}
+
+// traceHeaders returns the W3C trace context in a call's metadata as
+// message headers, or nil
+func traceHeaders(ctx context.Context) map[string]string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var headers map[string]string
+	for _, key := range []string{pubsub.TraceparentHeader, pubsub.TracestateHeader} {
+		if values := md.Get(key); len(values) > 0 {
+			if headers == nil {
+				headers = make(map[string]string)
+			}
+			headers[key] = values[0]
+		}
+	}
+	return headers
+}
+
+// Publish implements pubsubpb.PubSubServer
+func (s *GRPCServer) Publish(ctx context.Context, req *pubsubpb.PublishRequest) (*pubsubpb.PublishResponse, error) {
+	if req.GetTopic() == "" {
+		return nil, status.Error(codes.InvalidArgument, "topic is required")
+	}
+	if err := defaultNamespaceTopic(req.GetTopic()); err != nil {
+		return nil, err
+	}
+	if _, err := uuid.Parse(req.GetMessage().GetId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "message.id must be a valid UUID")
+	}
+
+	clientID := req.GetClientId()
+	if clientID == "" {
+		clientID = uuid.New().String()
+	}
+
+	message := pubsub.MessageData{
+		ID:      req.GetMessage().GetId(),
+		Payload: req.GetMessage().GetPayload().AsInterface(),
+	}
+	if s.ps.Tracing() {
+		message.Headers = traceHeaders(ctx)
+	}
+	if err := s.ps.Publish(ctx, req.GetTopic(), message, clientID); err != nil {
+		var limitErr *pubsub.PayloadLimitError
+		var schemaErr *pubsub.SchemaValidationError
+		var interceptorErr *pubsub.InterceptorError
+		if errors.As(err, &limitErr) || errors.As(err, &schemaErr) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if errors.As(err, &interceptorErr) {
+			return nil, status.Error(codes.FailedPrecondition, interceptorErr.Code+": "+err.Error())
+		}
+		if errors.Is(err, pubsub.ErrRateLimited) || errors.Is(err, pubsub.ErrTopicRateLimited) {
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		}
+		if errors.Is(err, pubsub.ErrTopicArchived) {
+			return nil, status.Error(codes.FailedPrecondition, "TOPIC_ARCHIVED: "+err.Error())
+		}
+		return nil, toStatus(err, codes.NotFound)
+	}
+
+	return &pubsubpb.PublishResponse{Status: "ok"}, nil
+}
+
+// Subscribe implements pubsubpb.PubSubServer. Cancelling the stream
+// unsubscribes the client.
+func (s *GRPCServer) Subscribe(req *pubsubpb.SubscribeRequest, stream pubsubpb.PubSub_SubscribeServer) error {
+	if err := defaultNamespaceTopic(req.GetTopic()); err != nil {
+		return err
+	}
+
+	clientID := req.GetClientId()
+	if clientID == "" {
+		clientID = uuid.New().String()
+	}
+
+	// A client_id held by another connection is refused rather than taken
+	// over, and on the way out only our own registration is removed
+	client := newGRPCClient(clientID)
+	if _, ok := s.ps.RegisterClientIfAbsent(client); !ok {
+		return status.Errorf(codes.AlreadyExists, "CLIENT_ID_IN_USE: client_id %s is bound to another connection", clientID)
+	}
+	defer s.ps.UnregisterClientIfCurrent(client)
+
+	ctx := stream.Context()
+	replay, err := s.ps.Subscribe(ctx, clientID, req.GetTopic(), int(req.GetLastN()), client)
+	if err != nil {
+		return toStatus(err, codes.NotFound)
+	}
+	defer s.ps.Unsubscribe(context.Background(), clientID, req.GetTopic())
+
+	log.Printf("gRPC client %s subscribed to topic %s", clientID, req.GetTopic())
+
+	if req.GetSinceSeq() > 0 {
+		since, _, err := s.ps.GetTopicMessages(req.GetTopic(), req.GetSinceSeq(), 0, pubsub.TopicHistoryBufferSize, false)
+		if err != nil {
+			return status.Error(codes.NotFound, err.Error())
+		}
+		replay = mergeBySeq(replay, since)
+	}
+
+	// Live events may already be queued behind the replay; skip anything
+	// the replay has covered so nothing is delivered twice
+	var lastSeq int64
+	for _, event := range replay {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err := stream.Send(eventToProto(event)); err != nil {
+			return err
+		}
+		lastSeq = event.Seq
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-client.events:
+			if event.Type == "event" && event.Seq <= lastSeq {
+				continue
+			}
+			if err := stream.Send(eventToProto(event)); err != nil {
+				return err
+			}
+			if event.Type == "info" && event.Message.Payload == "topic_deleted" {
+				return nil
+			}
+		}
+	}
+}
+
+// CreateTopic implements pubsubpb.TopicAdminServer
+func (s *GRPCServer) CreateTopic(ctx context.Context, req *pubsubpb.CreateTopicRequest) (*pubsubpb.CreateTopicResponse, error) {
+	if req.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "topic name is required")
+	}
+	if err := defaultNamespaceTopic(req.GetName()); err != nil {
+		return nil, err
+	}
+	if err := s.ps.CreateTopic(withPeerActor(ctx), req.GetName()); err != nil {
+		if errors.Is(err, pubsub.ErrTopicLimit) {
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		}
+		return nil, toStatus(err, codes.AlreadyExists)
+	}
+	return &pubsubpb.CreateTopicResponse{Status: "created", Topic: req.GetName()}, nil
+}
+
+// DeleteTopic implements pubsubpb.TopicAdminServer
+func (s *GRPCServer) DeleteTopic(ctx context.Context, req *pubsubpb.DeleteTopicRequest) (*pubsubpb.DeleteTopicResponse, error) {
+	if err := defaultNamespaceTopic(req.GetName()); err != nil {
+		return nil, err
+	}
+	if err := s.ps.DeleteTopic(withPeerActor(ctx), req.GetName()); err != nil {
+		return nil, toStatus(err, codes.NotFound)
+	}
+	return &pubsubpb.DeleteTopicResponse{Status: "deleted", Topic: req.GetName()}, nil
+}
+
+// ListTopics implements pubsubpb.TopicAdminServer
+func (s *GRPCServer) ListTopics(ctx context.Context, req *pubsubpb.ListTopicsRequest) (*pubsubpb.ListTopicsResponse, error) {
+	topics := s.ps.GetNamespaceTopics(pubsub.DefaultNamespace)
+	resp := &pubsubpb.ListTopicsResponse{Topics: make([]*pubsubpb.TopicInfo, 0, len(topics))}
+	for _, topic := range topics {
+		resp.Topics = append(resp.Topics, &pubsubpb.TopicInfo{
+			Name:        topic.Name,
+			Subscribers: int32(topic.Subscribers),
+		})
+	}
+	return resp, nil
+}
+
+// defaultNamespaceTopic rejects topic names that would reach outside the
+// default namespace, the only one gRPC serves
+func defaultNamespaceTopic(name string) error {
+	if _, err := pubsub.QualifyTopic(pubsub.DefaultNamespace, name); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
+
+// withPeerActor attributes topic changes made with ctx to the calling peer
+func withPeerActor(ctx context.Context) context.Context {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return pubsub.WithActor(ctx, "grpc:"+p.Addr.String())
+	}
+	return ctx
+}
+
+// toStatus maps a core error to a gRPC status, keeping cancellation,
+// deadline and permission errors distinguishable from the given code
+func toStatus(err error, code codes.Code) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	if errors.Is(err, pubsub.ErrPermissionDenied) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if errors.Is(err, pubsub.ErrServerDraining) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Error(code, err.Error())
+}
+
+// mergeBySeq combines two seq-ordered event lists, dropping duplicates
+func mergeBySeq(a, b []pubsub.EventResponse) []pubsub.EventResponse {
+	merged := make([]pubsub.EventResponse, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j >= len(b) || (i < len(a) && a[i].Seq < b[j].Seq):
+			merged = append(merged, a[i])
+			i++
+		case i >= len(a) || b[j].Seq < a[i].Seq:
+			merged = append(merged, b[j])
+			j++
+		default:
+			merged = append(merged, a[i])
+			i++
+			j++
+		}
+	}
+	return merged
+}
+
+// eventToProto converts an EventResponse to its protobuf form
+func eventToProto(event pubsub.EventResponse) *pubsubpb.Event {
+	return &pubsubpb.Event{
+		Type:  event.Type,
+		Topic: event.Topic,
+		Message: &pubsubpb.Message{
+			Id:      event.Message.ID,
+			Payload: payloadToValue(event.Message.Payload),
+		},
+		Seq: event.Seq,
+		Ts:  timestamppb.New(event.Timestamp),
+	}
+}
+
+// payloadToValue converts an arbitrary JSON-compatible payload to a
+// structpb.Value, falling back to a JSON round trip for other Go types
+func payloadToValue(payload interface{}) *structpb.Value {
+	if value, err := structpb.NewValue(payload); err == nil {
+		return value
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return structpb.NewNullValue()
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return structpb.NewNullValue()
+	}
+	value, err := structpb.NewValue(generic)
+	if err != nil {
+		return structpb.NewNullValue()
+	}
+	return value
+}
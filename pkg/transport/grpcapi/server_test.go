@@ -0,0 +1,253 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsubpb"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// testConn serves ps over an in-memory listener and returns a connection
+// to it
+func testConn(t *testing.T, ps *pubsub.PubSubSystem) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	server := NewGRPCServer(ps)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dialing bufconn: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func publish(t *testing.T, client pubsubpb.PubSubClient, topic string, payload string) {
+	t.Helper()
+	_, err := client.Publish(context.Background(), &pubsubpb.PublishRequest{
+		Topic:   topic,
+		Message: &pubsubpb.Message{Id: uuid.New().String(), Payload: structpb.NewStringValue(payload)},
+	})
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+}
+
+func recv(t *testing.T, stream pubsubpb.PubSub_SubscribeClient) *pubsubpb.Event {
+	t.Helper()
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	return event
+}
+
+// connected reports whether clientID is registered, by trying to take it
+func connected(ps *pubsub.PubSubSystem, clientID string) bool {
+	probe := newGRPCClient(clientID)
+	if _, ok := ps.RegisterClientIfAbsent(probe); ok {
+		ps.UnregisterClientIfCurrent(probe)
+		return false
+	}
+	return true
+}
+
+// waitFor polls cond until it holds, failing the test after 5 seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func subscribers(ps *pubsub.PubSubSystem, topic string) int {
+	for _, info := range ps.GetTopics() {
+		if info.Name == topic {
+			return info.Subscribers
+		}
+	}
+	return 0
+}
+
+func TestTopicAdmin(t *testing.T) {
+	admin := pubsubpb.NewTopicAdminClient(testConn(t, pubsub.New()))
+	ctx := context.Background()
+
+	if _, err := admin.CreateTopic(ctx, &pubsubpb.CreateTopicRequest{Name: "orders"}); err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	_, err := admin.CreateTopic(ctx, &pubsubpb.CreateTopicRequest{Name: "orders"})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("creating twice = %v, want AlreadyExists", err)
+	}
+
+	list, err := admin.ListTopics(ctx, &pubsubpb.ListTopicsRequest{})
+	if err != nil {
+		t.Fatalf("ListTopics: %v", err)
+	}
+	if len(list.Topics) != 1 || list.Topics[0].Name != "orders" {
+		t.Errorf("ListTopics = %v", list.Topics)
+	}
+
+	if _, err := admin.DeleteTopic(ctx, &pubsubpb.DeleteTopicRequest{Name: "orders"}); err != nil {
+		t.Fatalf("DeleteTopic: %v", err)
+	}
+	_, err = admin.DeleteTopic(ctx, &pubsubpb.DeleteTopicRequest{Name: "orders"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("deleting twice = %v, want NotFound", err)
+	}
+}
+
+func TestPublishValidation(t *testing.T) {
+	ps := pubsub.New()
+	client := pubsubpb.NewPubSubClient(testConn(t, ps))
+
+	_, err := client.Publish(context.Background(), &pubsubpb.PublishRequest{Topic: "orders", Message: &pubsubpb.Message{Id: "not-a-uuid"}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("bad message id = %v, want InvalidArgument", err)
+	}
+	_, err = client.Publish(context.Background(), &pubsubpb.PublishRequest{Topic: "missing", Message: &pubsubpb.Message{Id: uuid.New().String()}})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("missing topic = %v, want NotFound", err)
+	}
+}
+
+func TestPublishContinuesMetadataTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	ps := pubsub.New()
+	ps.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	client := pubsubpb.NewPubSubClient(testConn(t, ps))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, err := client.Publish(ctx, &pubsubpb.PublishRequest{Topic: "orders", Message: &pubsubpb.Message{Id: uuid.New().String()}})
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	ended := recorder.Ended()
+	if len(ended) == 0 || ended[len(ended)-1].Name() != "publish orders" {
+		t.Fatalf("spans = %v", ended)
+	}
+	if parent := ended[len(ended)-1].Parent(); parent.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("publish span parent = %s/%s", parent.TraceID(), parent.SpanID())
+	}
+}
+
+func TestSubscribeReplaysAndStreams(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	client := pubsubpb.NewPubSubClient(testConn(t, ps))
+	for _, payload := range []string{"a", "b", "c"} {
+		publish(t, client, "orders", payload)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Subscribe(ctx, &pubsubpb.SubscribeRequest{Topic: "orders", ClientId: "svc", SinceSeq: 1})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	for _, want := range []int64{2, 3} {
+		if event := recv(t, stream); event.Seq != want {
+			t.Fatalf("replayed seq %d, want %d", event.Seq, want)
+		}
+	}
+
+	waitFor(t, "the subscription", func() bool { return subscribers(ps, "orders") == 1 })
+	publish(t, client, "orders", "d")
+	event := recv(t, stream)
+	if event.Seq != 4 || event.Message.Payload.GetStringValue() != "d" {
+		t.Fatalf("live event = %v", event)
+	}
+}
+
+func TestSubscribeCancelUnsubscribes(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	client := pubsubpb.NewPubSubClient(testConn(t, ps))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := client.Subscribe(ctx, &pubsubpb.SubscribeRequest{Topic: "orders", ClientId: "svc"}); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	waitFor(t, "the subscription", func() bool { return subscribers(ps, "orders") == 1 })
+
+	cancel()
+	waitFor(t, "the unsubscribe", func() bool { return subscribers(ps, "orders") == 0 })
+	waitFor(t, "the client to unregister", func() bool { return !connected(ps, "svc") })
+}
+
+func TestSubscribeRefusesClientIDInUse(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	client := pubsubpb.NewPubSubClient(testConn(t, ps))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	first, err := client.Subscribe(ctx, &pubsubpb.SubscribeRequest{Topic: "orders", ClientId: "svc"})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	waitFor(t, "the subscription", func() bool { return subscribers(ps, "orders") == 1 })
+
+	second, err := client.Subscribe(context.Background(), &pubsubpb.SubscribeRequest{Topic: "orders", ClientId: "svc"})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if _, err := second.Recv(); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("second stream with the same client_id = %v, want AlreadyExists", err)
+	}
+
+	// The refused stream left the first one registered and subscribed
+	if !connected(ps, "svc") {
+		t.Fatal("first stream's client was unregistered")
+	}
+	publish(t, client, "orders", "still mine")
+	if event := recv(t, first); event.Message.Payload.GetStringValue() != "still mine" {
+		t.Fatalf("first stream got %v", event)
+	}
+}
+
+func TestSlowStreamDropsLikeWebsocket(t *testing.T) {
+	client := newGRPCClient("slow")
+	for i := 0; i < pubsub.ClientSendBufferSize; i++ {
+		if err := client.SendMessage(pubsub.EventResponse{Type: "event", Seq: int64(i + 1)}); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+	err := client.SendMessage(pubsub.EventResponse{Type: "event"})
+	if errData, ok := err.(pubsub.ErrorData); !ok || errData.Reason != "CLIENT_OVERLOADED" {
+		t.Fatalf("send to a full stream = %v, want CLIENT_OVERLOADED", err)
+	}
+}
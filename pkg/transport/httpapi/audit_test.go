@@ -0,0 +1,118 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// auditRecords reads GET /audit with query until it returns n records
+func auditRecords(t *testing.T, server string, query string, n int) []pubsub.AuditRecord {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var resp pubsub.AuditResponse
+		if status := do(t, "GET", server+"/audit"+query, "", &resp); status != http.StatusOK {
+			t.Fatalf("GET /audit%s = %d", query, status)
+		}
+		if len(resp.Records) >= n || time.Now().After(deadline) {
+			return resp.Records
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAuditClientSession(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	al, err := pubsub.NewAuditLog(0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(al.Close)
+	ps.EnableAuditLog(al)
+	server := apiServer(t, ps)
+
+	// Connect, claim an ID, subscribe, publish twice, unsubscribe and
+	// close
+	header := http.Header{"User-Agent": {"audit-test/1.0"}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := &tenant{t: t, conn: conn, id: "auditor"}
+	// Protocol v1 wraps the welcome in an event envelope
+	message, _ := c.next("welcome")["message"].(map[string]interface{})
+	payload, _ := message["payload"].(map[string]interface{})
+	assigned, _ := payload["client_id"].(string)
+	if frame := c.subscribe("orders"); frame["type"] != "ack" {
+		t.Fatalf("subscribing = %v", frame)
+	}
+	for i := 0; i < 2; i++ {
+		if frame := c.publish("orders", i); frame["type"] != "ack" {
+			t.Fatalf("publishing = %v", frame)
+		}
+	}
+	if frame := c.request(map[string]interface{}{"type": "unsubscribe", "topic": "orders"}); frame["type"] != "ack" {
+		t.Fatalf("unsubscribing = %v", frame)
+	}
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "done"))
+
+	records := auditRecords(t, server.URL, "", 5)
+	want := []pubsub.AuditRecord{
+		{Event: pubsub.AuditConnect, ClientID: assigned, UserAgent: "audit-test/1.0"},
+		{Event: pubsub.AuditIdentify, ClientID: "auditor", PreviousID: assigned},
+		{Event: pubsub.AuditSubscribe, ClientID: "auditor", Topic: "orders"},
+		{Event: pubsub.AuditUnsubscribe, ClientID: "auditor", Topic: "orders"},
+		{Event: pubsub.AuditDisconnect, ClientID: "auditor", Published: 2, Reason: "done", CloseCode: websocket.CloseNormalClosure},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("audit records = %+v", records)
+	}
+	for i, rec := range records {
+		if i == 0 && !strings.HasPrefix(rec.RemoteAddr, "127.0.0.1:") {
+			t.Errorf("connect recorded from %q", rec.RemoteAddr)
+		}
+		rec.Timestamp, rec.RemoteAddr = time.Time{}, ""
+		if rec != want[i] {
+			t.Errorf("record %d = %+v, want %+v", i, rec, want[i])
+		}
+	}
+
+	// Filtered by client and time
+	if got := auditRecords(t, server.URL, "?client_id=auditor", 4); len(got) != 4 || got[0].Event != pubsub.AuditIdentify {
+		t.Errorf("auditor's records = %+v", got)
+	}
+	from := url.QueryEscape(records[3].Timestamp.Format(time.RFC3339Nano))
+	if got := auditRecords(t, server.URL, "?from="+from, 2); len(got) != 2 || got[0].Event != pubsub.AuditUnsubscribe {
+		t.Errorf("records from the unsubscribe = %+v", got)
+	}
+	if got := auditRecords(t, server.URL, "?limit=1", 1); len(got) != 1 || got[0].Event != pubsub.AuditDisconnect {
+		t.Errorf("latest record = %+v", got)
+	}
+	if got := auditRecords(t, server.URL, "?client_id=nobody", 0); len(got) != 0 {
+		t.Errorf("unknown client's records = %+v", got)
+	}
+	for _, query := range []string{"?from=yesterday", "?limit=0"} {
+		if status := do(t, "GET", server.URL+"/audit"+query, "", nil); status != http.StatusBadRequest {
+			t.Errorf("GET /audit%s = %d", query, status)
+		}
+	}
+}
+
+func TestAuditDisabled(t *testing.T) {
+	server := apiServer(t, pubsub.New())
+	if status := do(t, "GET", server.URL+"/audit", "", nil); status != http.StatusNotFound {
+		t.Errorf("GET /audit without an audit log = %d", status)
+	}
+}
@@ -0,0 +1,65 @@
+package httpapi
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// AdminAuth guards the admin routes with a bearer token, basic-auth
+// credentials, or both (either is accepted). The zero value allows every
+// request.
+type AdminAuth struct {
+	Token    string
+	Username string
+	Password string
+}
+
+// Configured reports whether any admin credential is set
+func (a AdminAuth) Configured() bool {
+	return a.Token != "" || a.Username != ""
+}
+
+// Middleware rejects requests without a valid admin credential with 401
+func (a AdminAuth) Middleware(next http.Handler) http.Handler {
+	if !a.Configured() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.authorized(r) {
+			if a.Username != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="pubsub admin"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="pubsub admin"`)
+			}
+			errData := pubsub.ErrorData{Code: pubsub.CodePermissionDenied, Message: "Admin credentials required"}.Stable()
+			writeErrorStatus(w, http.StatusUnauthorized, errData)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorized checks the request's Authorization header
+func (a AdminAuth) authorized(r *http.Request) bool {
+	if a.Token != "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && secretEqual(token, a.Token) {
+			return true
+		}
+	}
+	if a.Username != "" {
+		if user, pass, ok := r.BasicAuth(); ok && secretEqual(user, a.Username) && secretEqual(pass, a.Password) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretEqual compares two secrets in constant time, regardless of length
+func secretEqual(a, b string) bool {
+	ha, hb := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
@@ -0,0 +1,57 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminAuthMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	type creds struct {
+		token, user, pass string
+	}
+	for _, tc := range []struct {
+		name      string
+		auth      AdminAuth
+		creds     creds
+		status    int
+		challenge string
+	}{
+		{"unconfigured, no credentials", AdminAuth{}, creds{}, http.StatusNoContent, ""},
+		{"unconfigured, any token", AdminAuth{}, creds{token: "x"}, http.StatusNoContent, ""},
+
+		{"token", AdminAuth{Token: "secret"}, creds{token: "secret"}, http.StatusNoContent, ""},
+		{"wrong token", AdminAuth{Token: "secret"}, creds{token: "secrets"}, http.StatusUnauthorized, "Bearer"},
+		{"no token", AdminAuth{Token: "secret"}, creds{}, http.StatusUnauthorized, "Bearer"},
+		{"basic auth when a token is wanted", AdminAuth{Token: "secret"}, creds{user: "admin", pass: "secret"}, http.StatusUnauthorized, "Bearer"},
+
+		{"basic auth", AdminAuth{Username: "admin", Password: "pw"}, creds{user: "admin", pass: "pw"}, http.StatusNoContent, ""},
+		{"wrong password", AdminAuth{Username: "admin", Password: "pw"}, creds{user: "admin", pass: "p"}, http.StatusUnauthorized, "Basic"},
+		{"wrong user", AdminAuth{Username: "admin", Password: "pw"}, creds{user: "root", pass: "pw"}, http.StatusUnauthorized, "Basic"},
+
+		// Either credential will do when both are configured
+		{"both, token", AdminAuth{Token: "secret", Username: "admin", Password: "pw"}, creds{token: "secret"}, http.StatusNoContent, ""},
+		{"both, basic auth", AdminAuth{Token: "secret", Username: "admin", Password: "pw"}, creds{user: "admin", pass: "pw"}, http.StatusNoContent, ""},
+		{"both, neither", AdminAuth{Token: "secret", Username: "admin", Password: "pw"}, creds{}, http.StatusUnauthorized, "Basic"},
+	} {
+		req := httptest.NewRequest("GET", "/stats", nil)
+		if tc.creds.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.creds.token)
+		}
+		if tc.creds.user != "" {
+			req.SetBasicAuth(tc.creds.user, tc.creds.pass)
+		}
+		rec := httptest.NewRecorder()
+		tc.auth.Middleware(ok).ServeHTTP(rec, req)
+		challenge := rec.Header().Get("WWW-Authenticate")
+		if rec.Code != tc.status || (tc.challenge == "") != (challenge == "") || !strings.HasPrefix(challenge, tc.challenge) {
+			t.Errorf("%s: %d, WWW-Authenticate %q", tc.name, rec.Code, challenge)
+		}
+	}
+
+	if (AdminAuth{}).Configured() || !(AdminAuth{Token: "x"}).Configured() || !(AdminAuth{Username: "x"}).Configured() {
+		t.Error("Configured doesn't follow the credentials set")
+	}
+}
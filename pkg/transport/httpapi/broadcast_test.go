@@ -0,0 +1,87 @@
+package httpapi
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+	"github.com/AnshulDekate/pubsub/pkg/transport/ws"
+)
+
+// infoPayload returns the payload of an info frame, which protocol v1 wraps
+// in an event envelope
+func infoPayload(frame map[string]interface{}) map[string]interface{} {
+	message, _ := frame["message"].(map[string]interface{})
+	payload, _ := message["payload"].(map[string]interface{})
+	return payload
+}
+
+func TestBroadcastOverREST(t *testing.T) {
+	ps := pubsub.New()
+	server := apiServer(t, ps)
+	var clients []*tenant
+	for _, id := range []string{"north", "south", "east"} {
+		clients = append(clients, connectTenant(t, server, "/ws", id))
+	}
+	ps.RegisterClient(fullClient{testClient{id: "stalled"}})
+
+	var resp pubsub.BroadcastResponse
+	body := `{"message":"reconnect to the other region in 5 minutes","severity":"warning","close_after_seconds":1}`
+	sent := time.Now()
+	if status := do(t, "POST", server.URL+"/admin/broadcast", body, &resp); status != http.StatusOK {
+		t.Fatalf("POST /admin/broadcast = %d", status)
+	}
+	if resp.Reached != 3 || resp.Failed != 1 || resp.CloseAt == nil || resp.CloseAt.Before(sent.Add(time.Second)) {
+		t.Errorf("broadcast = %+v", resp)
+	}
+	for _, c := range clients {
+		payload := infoPayload(c.next("info"))
+		if payload["msg"] != "reconnect to the other region in 5 minutes" || payload["severity"] != "warning" {
+			t.Errorf("%s got %v", c.id, payload)
+		}
+	}
+
+	// Once the delay is up, each connection is closed
+	for _, c := range clients {
+		c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			if _, _, err := c.conn.ReadMessage(); err != nil {
+				if !websocket.IsCloseError(err, ws.CloseGoingAway) {
+					t.Errorf("%s closed with %v", c.id, err)
+				}
+				break
+			}
+		}
+	}
+
+	for _, body := range []string{`{"severity":"info"}`, `{"message":"hi","severity":"urgent"}`, `{"message":"hi","close_after_seconds":-1}`, `not json`} {
+		if status := do(t, "POST", server.URL+"/admin/broadcast", body, nil); status != http.StatusBadRequest {
+			t.Errorf("broadcasting %s = %d", body, status)
+		}
+	}
+}
+
+func TestKickClientOverREST(t *testing.T) {
+	ps := pubsub.New()
+	server := apiServer(t, ps)
+	c := connectTenant(t, server, "/ws", "noisy")
+
+	if status := do(t, "POST", server.URL+"/clients/noisy/kick", `{"reason":"flooding the lobby"}`, nil); status != http.StatusOK {
+		t.Fatalf("POST /clients/noisy/kick = %d", status)
+	}
+	if payload := infoPayload(c.next("info")); payload["msg"] != "flooding the lobby" || payload["reason"] != "KICKED" {
+		t.Errorf("kick notice = %v", payload)
+	}
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := c.conn.ReadMessage()
+	if closeErr, ok := err.(*websocket.CloseError); !ok || closeErr.Code != ws.CloseKicked || closeErr.Text != "flooding the lobby" {
+		t.Errorf("closed with %v, want %d", err, ws.CloseKicked)
+	}
+
+	if status := do(t, "POST", server.URL+"/clients/nobody/kick", "", nil); status != http.StatusNotFound {
+		t.Errorf("kicking an unknown client = %d", status)
+	}
+}
@@ -0,0 +1,112 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// dialRefused tries a websocket connection that the server should refuse
+// and returns the status and error of the refusal
+func dialRefused(t *testing.T, server string) (int, pubsub.ErrorData) {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server, "http")+"/ws?client_id=latecomer", nil)
+	if err == nil {
+		conn.Close()
+		return http.StatusSwitchingProtocols, pubsub.ErrorData{}
+	}
+	if resp == nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var refused pubsub.ErrorResponse
+	json.NewDecoder(resp.Body).Decode(&refused)
+	return resp.StatusCode, refused.Error
+}
+
+// errorCode returns the code of an error frame, which protocol v1 wraps in
+// an event envelope
+func errorCode(frame map[string]interface{}) string {
+	code, _ := infoPayload(frame)["code"].(string)
+	return code
+}
+
+// errorReason returns the more specific code of an error frame
+func errorReason(frame map[string]interface{}) string {
+	reason, _ := infoPayload(frame)["reason"].(string)
+	return reason
+}
+
+func TestDrainMode(t *testing.T) {
+	ps := pubsub.New()
+	for _, name := range []string{"orders", "payments"} {
+		if err := ps.CreateTopic(context.Background(), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := apiServer(t, ps)
+	veteran := connectTenant(t, server, "/ws", "veteran")
+	if frame := veteran.subscribe("orders"); frame["type"] != "ack" {
+		t.Fatalf("subscribing before the drain = %v", frame)
+	}
+	publisher := connectTenant(t, server, "/ws", "publisher")
+
+	var state pubsub.DrainResponse
+	if status := do(t, "POST", server.URL+"/admin/drain", "", &state); status != http.StatusOK {
+		t.Fatalf("POST /admin/drain = %d", status)
+	}
+	if !state.Draining || state.Since == nil {
+		t.Fatalf("drain state = %+v", state)
+	}
+	drainedAt := *state.Since
+	// Draining again keeps the time it began
+	do(t, "POST", server.URL+"/admin/drain", "", &state)
+	if state.Since == nil || !state.Since.Equal(drainedAt) {
+		t.Errorf("drain state after draining twice = %+v", state)
+	}
+
+	// New work is refused
+	if readiness, status := getReadiness(t, server.URL); status != http.StatusServiceUnavailable || readiness.Reason != "draining" {
+		t.Errorf("GET /readyz while draining = %d %+v", status, readiness)
+	}
+	if health := getHealth(t, server.URL); !health.Drain.Draining || health.Drain.Since == nil {
+		t.Errorf("health while draining reports %+v", health.Drain)
+	}
+	if status, refused := dialRefused(t, server.URL); status != http.StatusServiceUnavailable || refused.Code != "SERVER_BUSY" || refused.Reason != "SERVER_DRAINING" || !refused.Retryable {
+		t.Errorf("connecting while draining = %d %+v", status, refused)
+	}
+	if frame := veteran.subscribe("payments"); errorCode(frame) != "SERVER_BUSY" || errorReason(frame) != "SERVER_DRAINING" {
+		t.Errorf("subscribing while draining = %v", frame)
+	}
+
+	// Existing connections carry on
+	if frame := publisher.publish("orders", "during the drain"); frame["type"] != "ack" {
+		t.Errorf("publishing while draining = %v", frame)
+	}
+	event := veteran.next("event")
+	if message, _ := event["message"].(map[string]interface{}); message["payload"] != "during the drain" {
+		t.Errorf("event delivered as %v", event)
+	}
+
+	// Undraining restores everything
+	var undrained pubsub.DrainResponse
+	if status := do(t, "POST", server.URL+"/admin/undrain", "", &undrained); status != http.StatusOK || undrained.Draining || undrained.Since != nil {
+		t.Errorf("POST /admin/undrain = %d %+v", status, undrained)
+	}
+	if readiness, status := getReadiness(t, server.URL); status != http.StatusOK {
+		t.Errorf("GET /readyz after undraining = %d %+v", status, readiness)
+	}
+	if health := getHealth(t, server.URL); health.Drain.Draining || health.Drain.Since != nil {
+		t.Errorf("health after undraining reports %+v", health.Drain)
+	}
+	if frame := veteran.subscribe("payments"); frame["type"] != "ack" {
+		t.Errorf("subscribing after undraining = %v", frame)
+	}
+	connectTenant(t, server, "/ws", "latecomer")
+}
@@ -0,0 +1,1631 @@
+// Package httpapi serves the REST API, long-polling subscriptions and the
+// websocket endpoint for a pubsub.PubSubSystem.
+package httpapi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+	"github.com/AnshulDekate/pubsub/pkg/transport/ws"
+)
+
+const (
+	DefaultHistoryPageLimit = 50  // Default page size for GET /topics/{name}/messages
+	DefaultMaxHistoryLimit  = 500 // Default upper bound for the ?limit= parameter
+)
+
+const (
+	exportFlushEvery   = 100      // Events written between flushes of an export
+	importLineOverhead = 64 << 10 // Room for an imported event's envelope beyond its payload
+	maxImportErrors    = 20       // Rejected lines an import lists
+)
+
+// errSnapshotsNeedAuth refuses snapshot routes without admin auth
+var errSnapshotsNeedAuth = errors.New("snapshot routes need admin credentials")
+
+// HTTPHandlers provides HTTP handlers for the REST API
+type HTTPHandlers struct {
+	ps *pubsub.PubSubSystem
+
+	// Long-polling subscriptions for clients that can't use WebSockets
+	polls *PollManager
+
+	// Maximum page size accepted by the history browsing endpoint
+	maxHistoryLimit int
+
+	// Served at GET /metrics, nil when metrics are off
+	metrics *pubsub.Metrics
+
+	// Serves /ws and /admin/ws
+	websocket *ws.Handler
+
+	// Whether /admin/snapshot and /admin/restore are mounted, and the
+	// directory server-side snapshots are confined to ("" streams only)
+	snapshots   bool
+	snapshotDir string
+}
+
+// NewHTTPHandlers creates a new HTTP handlers instance
+func NewHTTPHandlers(ps *pubsub.PubSubSystem) *HTTPHandlers {
+	return &HTTPHandlers{
+		ps:              ps,
+		polls:           NewPollManager(ps, DefaultPollSubscriptionTTL),
+		maxHistoryLimit: DefaultMaxHistoryLimit,
+		websocket:       ws.DefaultHandler(ps),
+	}
+}
+
+// Handler returns a router serving every endpoint with default settings,
+// ready to be mounted on an application's own mux
+func Handler(ps *pubsub.PubSubSystem) http.Handler {
+	router := mux.NewRouter()
+	NewHTTPHandlers(ps).SetupRoutes(router)
+	return router
+}
+
+// SetMaxHistoryLimit sets the largest ?limit= accepted when browsing
+// history; 0 or less restores DefaultMaxHistoryLimit
+func (h *HTTPHandlers) SetMaxHistoryLimit(limit int) {
+	if limit <= 0 {
+		limit = DefaultMaxHistoryLimit
+	}
+	h.maxHistoryLimit = limit
+}
+
+// SetMetrics serves m at GET /metrics. The caller registers m.Hooks() with
+// the pub-sub system.
+func (h *HTTPHandlers) SetMetrics(m *pubsub.Metrics) {
+	h.metrics = m
+}
+
+// SetWebSocketHandler serves websocket connections with w, for
+// non-default websocket options
+func (h *HTTPHandlers) SetWebSocketHandler(w *ws.Handler) {
+	h.websocket = w
+}
+
+// EnableSnapshots mounts POST /admin/snapshot and POST /admin/restore,
+// which dump and replace every topic, so auth must be configured. Snapshots
+// written server-side go under dir; with dir empty they can only be
+// streamed in the response.
+func (h *HTTPHandlers) EnableSnapshots(auth AdminAuth, dir string) error {
+	if !auth.Configured() {
+		return errSnapshotsNeedAuth
+	}
+	h.snapshots = true
+	h.snapshotDir = dir
+	return nil
+}
+
+// Polls returns the manager for long-polling subscriptions
+func (h *HTTPHandlers) Polls() *PollManager {
+	return h.polls
+}
+
+// namespaceOf returns the namespace a request addresses: the {ns} of a
+// /namespaces/{ns}/... route, or the default namespace
+func namespaceOf(r *http.Request) string {
+	if ns := mux.Vars(r)["ns"]; ns != "" {
+		return ns
+	}
+	return pubsub.DefaultNamespace
+}
+
+// topicName returns the internal name of the topic a request addresses. It
+// writes a 400 and returns false if the name can't be addressed.
+func topicName(w http.ResponseWriter, r *http.Request, name string) (string, bool) {
+	topic, err := pubsub.QualifyTopic(namespaceOf(r), name)
+	if err != nil {
+		writeError(w, err)
+		return "", false
+	}
+	return topic, true
+}
+
+// withActor attributes topic changes made for a request to its basic-auth
+// user, or else its remote address
+func withActor(r *http.Request) context.Context {
+	if user, _, ok := r.BasicAuth(); ok && user != "" {
+		return pubsub.WithActor(r.Context(), user)
+	}
+	return pubsub.WithActor(r.Context(), r.RemoteAddr)
+}
+
+// CreateTopic handles POST /topics
+func (h *HTTPHandlers) CreateTopic(w http.ResponseWriter, r *http.Request) {
+	var req pubsub.CreateTopicRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "Invalid JSON payload"))
+		return
+	}
+
+	if req.Name == "" {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "Topic name is required"))
+		return
+	}
+	if req.RetentionSeconds < 0 {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "retention_seconds must not be negative"))
+		return
+	}
+
+	name, ok := topicName(w, r, req.Name)
+	if !ok {
+		return
+	}
+	// Dead letters stay in the topic's namespace
+	deadLetterTopic := req.DeadLetterTopic
+	if deadLetterTopic != "" {
+		if deadLetterTopic, ok = topicName(w, r, deadLetterTopic); !ok {
+			return
+		}
+	}
+
+	private, err := pubsub.ParseVisibility(req.Visibility)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	config := pubsub.TopicConfig{
+		Retention:       time.Duration(req.RetentionSeconds) * time.Second,
+		DeadLetterTopic: deadLetterTopic,
+		Schema:          req.Schema,
+		Description:     req.Description,
+		Labels:          req.Labels,
+		SigningKeyID:    req.SigningKeyID,
+		Compacted:       req.Compacted,
+		Private:         private,
+		MaxPayloadBytes: req.MaxPayloadBytes,
+		MaxPublishRate:  req.MaxPublishRate,
+
+		SubscriberWatermarks: req.Watermarks,
+	}
+	if req.SlowConsumer != nil {
+		policy := req.SlowConsumer.Policy()
+		config.SlowConsumer = &policy
+	}
+	err = h.ps.CreateTopicWithConfig(withActor(r), name, config)
+	if errors.Is(err, pubsub.ErrTopicExists) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+
+		resp := pubsub.CreateTopicResponse{
+			Status: "exists",
+			Topic:  req.Name,
+		}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Topic created successfully
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	resp := pubsub.CreateTopicResponse{
+		Status: "created",
+		Topic:  req.Name,
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// UpdateTopic handles PATCH /topics/{name}
+func (h *HTTPHandlers) UpdateTopic(w http.ResponseWriter, r *http.Request) {
+	name, ok := topicName(w, r, mux.Vars(r)["name"])
+	if !ok {
+		return
+	}
+
+	var req pubsub.UpdateTopicRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "Invalid JSON payload"))
+		return
+	}
+
+	if req.RetentionSeconds != nil {
+		err := h.ps.SetTopicRetention(r.Context(), name, time.Duration(*req.RetentionSeconds)*time.Second)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	if req.DeadLetterTopic != nil {
+		deadLetterTopic := *req.DeadLetterTopic
+		if deadLetterTopic != "" {
+			if deadLetterTopic, ok = topicName(w, r, deadLetterTopic); !ok {
+				return
+			}
+		}
+		err := h.ps.SetDeadLetterTopic(r.Context(), name, deadLetterTopic)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	if req.Description != nil || req.Labels != nil {
+		err := h.ps.SetTopicMetadata(r.Context(), name, req.Description, req.Labels)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	if req.SigningKeyID != nil {
+		err := h.ps.SetSigningKey(r.Context(), name, *req.SigningKeyID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	if req.Visibility != nil {
+		err := h.ps.SetTopicVisibility(r.Context(), name, *req.Visibility)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	if req.State != nil {
+		err := h.ps.SetTopicState(r.Context(), name, *req.State)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	if req.MaxPayloadBytes != nil || req.MaxPublishRate != nil {
+		err := h.ps.SetTopicLimits(r.Context(), name, req.MaxPayloadBytes, req.MaxPublishRate)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	if req.SlowConsumer != nil {
+		// null goes back to the server's policy
+		var policy *pubsub.SlowConsumerPolicy
+		if string(req.SlowConsumer) != "null" {
+			var settings pubsub.SlowConsumerSettings
+			if err := json.Unmarshal(req.SlowConsumer, &settings); err != nil {
+				writeError(w, errorOf(pubsub.ErrInvalidRequest, "invalid slow_consumer: "+err.Error()))
+				return
+			}
+			p := settings.Policy()
+			policy = &p
+		}
+		err := h.ps.SetTopicSlowConsumerPolicy(r.Context(), name, policy)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	if req.Watermarks != nil {
+		// null goes back to the server's watermarks
+		var watermarks *pubsub.SubscriberWatermarks
+		if string(req.Watermarks) != "null" {
+			watermarks = &pubsub.SubscriberWatermarks{}
+			if err := json.Unmarshal(req.Watermarks, watermarks); err != nil {
+				writeError(w, errorOf(pubsub.ErrInvalidRequest, "invalid subscriber_watermarks: "+err.Error()))
+				return
+			}
+		}
+		err := h.ps.SetTopicSubscriberWatermarks(r.Context(), name, watermarks)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	detail, err := h.ps.GetTopicDetail(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(pubsub.LocalizeMessage(detail))
+}
+
+// SetTopicSchema handles PUT /topics/{name}/schema. The body is the JSON
+// Schema itself; null removes the schema.
+func (h *HTTPHandlers) SetTopicSchema(w http.ResponseWriter, r *http.Request) {
+	name, ok := topicName(w, r, mux.Vars(r)["name"])
+	if !ok {
+		return
+	}
+
+	var schema json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&schema); err != nil {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "Invalid JSON payload"))
+		return
+	}
+
+	err := h.ps.SetTopicSchema(r.Context(), name, schema)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	detail, err := h.ps.GetTopicDetail(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(pubsub.LocalizeMessage(detail))
+}
+
+// DeleteTopic handles DELETE /topics/{name}
+func (h *HTTPHandlers) DeleteTopic(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if vars["name"] == "" {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "Topic name is required"))
+		return
+	}
+	name, ok := topicName(w, r, vars["name"])
+	if !ok {
+		return
+	}
+
+	err := h.ps.DeleteTopic(withActor(r), name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Topic deleted successfully
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	resp := pubsub.DeleteTopicResponse{
+		Status: "deleted",
+		Topic:  vars["name"],
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// PauseTopic handles POST /topics/{name}/pause
+func (h *HTTPHandlers) PauseTopic(w http.ResponseWriter, r *http.Request) {
+	h.setTopicPaused(w, r, h.ps.PauseTopic)
+}
+
+// ResumeTopic handles POST /topics/{name}/resume
+func (h *HTTPHandlers) ResumeTopic(w http.ResponseWriter, r *http.Request) {
+	h.setTopicPaused(w, r, h.ps.ResumeTopic)
+}
+
+// setTopicPaused pauses or resumes delivery on the named topic
+func (h *HTTPHandlers) setTopicPaused(w http.ResponseWriter, r *http.Request, change func(context.Context, string) (pubsub.TopicPauseResponse, error)) {
+	vars := mux.Vars(r)
+	name, ok := topicName(w, r, vars["name"])
+	if !ok {
+		return
+	}
+
+	resp, err := change(r.Context(), name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp.Topic = vars["name"]
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetTopics handles GET /topics with optional prefix, sort, order, limit
+// and offset parameters
+func (h *HTTPHandlers) GetTopics(w http.ResponseWriter, r *http.Request) {
+	ns := namespaceOf(r)
+	if !pubsub.ValidNamespace(ns) {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "Invalid namespace"))
+		return
+	}
+	query := r.URL.Query()
+
+	listQuery := pubsub.TopicListQuery{
+		Prefix: query.Get("prefix"),
+		Sort:   query.Get("sort"),
+	}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		listQuery.Descending = true
+	default:
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "order must be asc or desc"))
+		return
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, errorOf(pubsub.ErrInvalidRequest, "limit must be a positive integer"))
+			return
+		}
+		listQuery.Limit = n
+	}
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, errorOf(pubsub.ErrInvalidRequest, "offset must be a non-negative integer"))
+			return
+		}
+		listQuery.Offset = n
+	}
+	for _, v := range query["label"] {
+		key, value, found := strings.Cut(v, "=")
+		if !found || key == "" {
+			writeError(w, errorOf(pubsub.ErrInvalidRequest, "label must be key=value"))
+			return
+		}
+		if listQuery.Labels == nil {
+			listQuery.Labels = make(map[string]string)
+		}
+		listQuery.Labels[key] = value
+	}
+
+	topics, total, err := h.ps.ListTopics(ns, listQuery)
+	if err != nil {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "sort must be name, subscribers, messages or created_at"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	resp := pubsub.TopicsResponse{
+		Topics:     topics,
+		TotalCount: total,
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetTopicDetail handles GET /topics/{name}
+func (h *HTTPHandlers) GetTopicDetail(w http.ResponseWriter, r *http.Request) {
+	name, ok := topicName(w, r, mux.Vars(r)["name"])
+	if !ok {
+		return
+	}
+
+	detail, err := h.ps.GetTopicDetail(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	json.NewEncoder(w).Encode(pubsub.LocalizeMessage(detail))
+}
+
+// GetTopicMessages handles GET /topics/{name}/messages
+func (h *HTTPHandlers) GetTopicMessages(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name, ok := topicName(w, r, vars["name"])
+	if !ok {
+		return
+	}
+	// REST readers are anonymous, so private topics are refused
+	if err := h.ps.CheckMember(name, ""); err != nil {
+		writeError(w, err)
+		return
+	}
+	query := r.URL.Query()
+
+	limit := DefaultHistoryPageLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, errorOf(pubsub.ErrInvalidRequest, "limit must be a positive integer"))
+			return
+		}
+		limit = n
+	}
+	if limit > h.maxHistoryLimit {
+		limit = h.maxHistoryLimit
+	}
+
+	var afterSeq, beforeSeq int64
+	if v := query.Get("after_seq"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			writeError(w, errorOf(pubsub.ErrInvalidRequest, "after_seq must be a non-negative integer"))
+			return
+		}
+		afterSeq = n
+	}
+	if v := query.Get("before_seq"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			writeError(w, errorOf(pubsub.ErrInvalidRequest, "before_seq must be a positive integer"))
+			return
+		}
+		beforeSeq = n
+	}
+	if afterSeq > 0 && beforeSeq > 0 {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "after_seq and before_seq cannot be combined"))
+		return
+	}
+
+	descending := true
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		descending = false
+	default:
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "order must be asc or desc"))
+		return
+	}
+
+	// Time ranges are served from the SQLite archive
+	var from, to time.Time
+	for _, bound := range []struct {
+		name string
+		ts   *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		v := query.Get(bound.name)
+		if v == "" {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			writeError(w, errorOf(pubsub.ErrInvalidRequest, bound.name+" must be an RFC3339 timestamp"))
+			return
+		}
+		*bound.ts = ts
+	}
+	if (!from.IsZero() || !to.IsZero()) && h.ps.SQLiteHistory() == nil {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "from and to need SQLite history to be enabled"))
+		return
+	}
+
+	messages, more, err := h.ps.QueryTopicMessages(name, pubsub.HistoryQuery{
+		AfterSeq:   afterSeq,
+		BeforeSeq:  beforeSeq,
+		From:       from,
+		To:         to,
+		Limit:      limit,
+		Descending: descending,
+	})
+	if err != nil {
+		if pubsub.ErrorCode(err) == pubsub.CodeInternal {
+			log.Printf("Reading history of topic %s failed: %v", name, err)
+		}
+		writeError(w, err)
+		return
+	}
+
+	resp := pubsub.TopicMessagesResponse{
+		Topic:    vars["name"],
+		Messages: make([]pubsub.EventResponse, len(messages)),
+	}
+	for i, event := range messages {
+		resp.Messages[i] = pubsub.LocalizeMessage(event).(pubsub.EventResponse)
+	}
+
+	// The next cursor continues in the same direction as this page: pass it
+	// back as after_seq when paging forward and as before_seq otherwise
+	if more && len(messages) > 0 {
+		oldest, newest := messages[0].Seq, messages[len(messages)-1].Seq
+		if descending {
+			oldest, newest = newest, oldest
+		}
+		cursor := oldest
+		if afterSeq > 0 || (beforeSeq == 0 && !descending) {
+			cursor = newest
+		}
+		resp.NextCursor = &cursor
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ExportTopic handles GET /topics/{name}/export, streaming the history as
+// one JSON event per line, oldest first
+func (h *HTTPHandlers) ExportTopic(w http.ResponseWriter, r *http.Request) {
+	name, ok := topicName(w, r, mux.Vars(r)["name"])
+	if !ok {
+		return
+	}
+
+	// The status is sent with the first event, so a missing topic still
+	// gets an error response
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	written := 0
+	start := func() {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+	}
+	err := h.ps.ExportTopicHistory(r.Context(), name, func(event pubsub.EventResponse) error {
+		if written == 0 {
+			start()
+		}
+		if err := encoder.Encode(pubsub.LocalizeMessage(event)); err != nil {
+			return err
+		}
+		written++
+		if written%exportFlushEvery == 0 && flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	switch {
+	case err != nil && written == 0:
+		writeError(w, err)
+	case err != nil:
+		// Too late for an error status; the client sees the stream end
+		log.Printf("Exporting topic %s stopped after %d events: %v", name, written, err)
+	case written == 0:
+		start()
+	}
+}
+
+// ImportTopic handles POST /topics/{name}/import. The body is an export:
+// one JSON event per line, appended in order under new sequence numbers.
+// With ?deliver=true the events are also published to subscribers.
+func (h *HTTPHandlers) ImportTopic(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name, ok := topicName(w, r, vars["name"])
+	if !ok {
+		return
+	}
+	deliver := false
+	if v := r.URL.Query().Get("deliver"); v != "" {
+		var err error
+		if deliver, err = strconv.ParseBool(v); err != nil {
+			writeError(w, errorOf(pubsub.ErrInvalidRequest, "deliver must be true or false"))
+			return
+		}
+	}
+	if _, err := h.ps.GetTopicDetail(name); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := pubsub.ImportResponse{Topic: vars["name"]}
+	reject := func(line int, err error) {
+		resp.Rejected++
+		if len(resp.Errors) < maxImportErrors {
+			resp.Errors = append(resp.Errors, pubsub.ImportError{Line: line, Error: err.Error()})
+		}
+	}
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(nil, h.ps.MaxPublishPayloadBytes()+importLineOverhead)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var event pubsub.EventResponse
+		if err := json.Unmarshal(data, &event); err != nil {
+			reject(line, err)
+			continue
+		}
+		seq, err := h.ps.ImportEvent(r.Context(), name, event, deliver)
+		if err != nil {
+			if code := pubsub.ErrorCode(err); code == pubsub.CodeValidationFailed || code == pubsub.CodePayloadTooLarge {
+				reject(line, err)
+				continue
+			}
+			// The topic itself refused, e.g. it was archived or deleted
+			if resp.Accepted == 0 && resp.Rejected == 0 {
+				writeError(w, err)
+				return
+			}
+			resp.Error = fmt.Sprintf("line %d: %v", line, err)
+			break
+		}
+		resp.Accepted++
+		if resp.FirstSeq == 0 {
+			resp.FirstSeq = seq
+		}
+		resp.LastSeq = seq
+	}
+	if err := scanner.Err(); err != nil && resp.Error == "" {
+		resp.Error = fmt.Sprintf("line %d: %v", line+1, err)
+	}
+	if resp.Accepted > 0 {
+		log.Printf("Imported %d events into topic %s, rejected %d", resp.Accepted, name, resp.Rejected)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// PurgeTopicMessages handles DELETE /topics/{name}/messages
+func (h *HTTPHandlers) PurgeTopicMessages(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name, ok := topicName(w, r, vars["name"])
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+
+	var beforeTS time.Time
+	if v := query.Get("before_ts"); v != "" {
+		ts, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			writeError(w, errorOf(pubsub.ErrInvalidRequest, "before_ts must be an RFC3339 timestamp"))
+			return
+		}
+		beforeTS = ts
+	}
+
+	var beforeSeq int64
+	if v := query.Get("before_seq"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			writeError(w, errorOf(pubsub.ErrInvalidRequest, "before_seq must be a positive integer"))
+			return
+		}
+		beforeSeq = n
+	}
+	if !beforeTS.IsZero() && beforeSeq > 0 {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "before_ts and before_seq cannot be combined"))
+		return
+	}
+
+	purged, err := h.ps.PurgeTopicHistory(name, beforeTS, beforeSeq)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	resp := pubsub.PurgeMessagesResponse{
+		Status: "purged",
+		Topic:  vars["name"],
+		Purged: purged,
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// DeleteTopicMessage handles DELETE /topics/{name}/messages/{message_id}
+func (h *HTTPHandlers) DeleteTopicMessage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name, ok := topicName(w, r, vars["name"])
+	if !ok {
+		return
+	}
+
+	removed, err := h.ps.DeleteMessage(withActor(r), name, vars["message_id"])
+	if errors.Is(err, pubsub.ErrMessageNotFound) {
+		writeError(w, errorOf(pubsub.ErrNotFound, "Message not found"))
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	resp := pubsub.DeleteMessageResponse{
+		Status:    "deleted",
+		Topic:     vars["name"],
+		MessageID: vars["message_id"],
+		Seq:       removed.Seq,
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetMessageReceipts handles GET /topics/{name}/messages/{message_id}/receipts
+func (h *HTTPHandlers) GetMessageReceipts(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name, ok := topicName(w, r, vars["name"])
+	if !ok {
+		return
+	}
+
+	// REST readers are anonymous, so private topics are refused
+	if err := h.ps.CheckMember(name, ""); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	receipts, err := h.ps.MessageReceipts(name, vars["message_id"])
+	if errors.Is(err, pubsub.ErrNoReceipts) {
+		writeError(w, errorOf(pubsub.ErrNotFound, "No receipts for message"))
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	receipts.Topic = vars["name"]
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(receipts)
+}
+
+// CreateWebhook handles POST /topics/{name}/webhooks
+func (h *HTTPHandlers) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	name, ok := topicName(w, r, mux.Vars(r)["name"])
+	if !ok {
+		return
+	}
+
+	var req pubsub.CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "Invalid JSON payload"))
+		return
+	}
+	if req.URL == "" {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "url is required"))
+		return
+	}
+
+	info, err := h.ps.AddWebhook(name, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	json.NewEncoder(w).Encode(info)
+}
+
+// DeleteWebhook handles DELETE /topics/{name}/webhooks/{id}
+func (h *HTTPHandlers) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name, ok := topicName(w, r, vars["name"])
+	if !ok {
+		return
+	}
+	webhookID := vars["id"]
+
+	if err := h.ps.RemoveWebhook(name, webhookID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	resp := map[string]string{
+		"status": "deleted",
+		"id":     webhookID,
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetWebhooks handles GET /topics/{name}/webhooks
+func (h *HTTPHandlers) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name, ok := topicName(w, r, vars["name"])
+	if !ok {
+		return
+	}
+
+	webhooks, err := h.ps.GetWebhooks(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	resp := pubsub.WebhooksResponse{
+		Topic:    vars["name"],
+		Webhooks: webhooks,
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetHealth handles GET /health
+func (h *HTTPHandlers) GetHealth(w http.ResponseWriter, r *http.Request) {
+	health := h.ps.GetHealth()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	json.NewEncoder(w).Encode(health)
+}
+
+// GetNamespaceHealth handles GET /namespaces/{ns}/health
+func (h *HTTPHandlers) GetNamespaceHealth(w http.ResponseWriter, r *http.Request) {
+	ns := mux.Vars(r)["ns"]
+	if !pubsub.ValidNamespace(ns) {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "Invalid namespace"))
+		return
+	}
+	health := h.ps.GetNamespaceHealth(ns)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	json.NewEncoder(w).Encode(health)
+}
+
+// GetMetrics handles GET /metrics
+func (h *HTTPHandlers) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	if h.metrics == nil {
+		writeError(w, errorOf(pubsub.ErrNotFound, "Metrics are not enabled"))
+		return
+	}
+	h.metrics.ServeHTTP(w, r)
+}
+
+// GetAudit handles GET /audit with optional client_id, from, to and limit
+// parameters
+func (h *HTTPHandlers) GetAudit(w http.ResponseWriter, r *http.Request) {
+	auditLog := h.ps.AuditLog()
+	if auditLog == nil {
+		writeError(w, errorOf(pubsub.ErrNotFound, "Audit log is not enabled"))
+		return
+	}
+	query := r.URL.Query()
+
+	q := pubsub.AuditQuery{ClientID: query.Get("client_id")}
+	for _, bound := range []struct {
+		name string
+		ts   *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		v := query.Get(bound.name)
+		if v == "" {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			writeError(w, errorOf(pubsub.ErrInvalidRequest, bound.name+" must be an RFC3339 timestamp"))
+			return
+		}
+		*bound.ts = ts
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, errorOf(pubsub.ErrInvalidRequest, "limit must be a positive integer"))
+			return
+		}
+		q.Limit = n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	resp := pubsub.AuditResponse{
+		Records: auditLog.Query(q),
+		Dropped: auditLog.Dropped(),
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetLiveness handles GET /livez
+func (h *HTTPHandlers) GetLiveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	json.NewEncoder(w).Encode(map[string]string{"status": "alive"})
+}
+
+// GetReadiness handles GET /readyz
+func (h *HTTPHandlers) GetReadiness(w http.ResponseWriter, r *http.Request) {
+	readiness := h.ps.CheckReadiness()
+
+	w.Header().Set("Content-Type", "application/json")
+	if readiness.Status != "ready" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	json.NewEncoder(w).Encode(readiness)
+}
+
+// GetStats handles GET /stats
+func (h *HTTPHandlers) GetStats(w http.ResponseWriter, r *http.Request) {
+	stats := h.ps.GetStats()
+	stats.Buffers.PollSubscriptions = h.polls.bufferStats()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	json.NewEncoder(w).Encode(stats)
+}
+
+// GetNamespaceStats handles GET /namespaces/{ns}/stats
+func (h *HTTPHandlers) GetNamespaceStats(w http.ResponseWriter, r *http.Request) {
+	ns := mux.Vars(r)["ns"]
+	if !pubsub.ValidNamespace(ns) {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "Invalid namespace"))
+		return
+	}
+	stats := h.ps.GetNamespaceStats(ns)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	json.NewEncoder(w).Encode(stats)
+}
+
+// GetSubscriptionsStatus handles GET /subscriptions. client_id and topic
+// narrow it to one client's topics, one topic's clients, or both; limit and
+// offset page the unfiltered listing.
+func (h *HTTPHandlers) GetSubscriptionsStatus(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	clientID, topic := query.Get("client_id"), query.Get("topic")
+
+	var status pubsub.SubscriptionsStatusResponse
+	switch {
+	case clientID != "":
+		status = h.ps.GetClientSubscriptions(clientID, topic)
+	case topic != "":
+		status = h.ps.GetTopicSubscriptions(topic)
+	default:
+		limit, offset := 0, 0
+		if v := query.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeError(w, errorOf(pubsub.ErrInvalidRequest, "limit must be a positive integer"))
+				return
+			}
+			limit = n
+		}
+		if v := query.Get("offset"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeError(w, errorOf(pubsub.ErrInvalidRequest, "offset must be a non-negative integer"))
+				return
+			}
+			offset = n
+		}
+		status = h.ps.ListSubscriptions(offset, limit)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	json.NewEncoder(w).Encode(status)
+}
+
+// GetClient handles GET /clients/{id}
+func (h *HTTPHandlers) GetClient(w http.ResponseWriter, r *http.Request) {
+	detail, ok := h.ps.GetClientDetail(mux.Vars(r)["id"])
+	if !ok {
+		writeError(w, errorOf(pubsub.ErrNotFound, "Client not found"))
+		return
+	}
+	detail.Buffers.Poll = h.polls.clientBufferStats(detail.ClientID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(detail)
+}
+
+// ResetBufferHighWatermarks handles DELETE /admin/buffers/high-watermarks,
+// so buffer occupancy can be measured from a point such as a deploy
+func (h *HTTPHandlers) ResetBufferHighWatermarks(w http.ResponseWriter, r *http.Request) {
+	h.ps.ResetBufferHighWatermarks()
+	h.polls.resetHighWatermarks()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "reset"})
+}
+
+// GetCounterSnapshot handles GET /admin/stats/snapshot
+func (h *HTTPHandlers) GetCounterSnapshot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h.ps.CounterSnapshot())
+}
+
+// ResetCounters handles POST /admin/stats/reset, answering with the
+// counters as they were so the period they covered isn't lost
+func (h *HTTPHandlers) ResetCounters(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h.ps.ResetCounters())
+}
+
+// ResetClientUsage handles DELETE /clients/{id}/usage
+func (h *HTTPHandlers) ResetClientUsage(w http.ResponseWriter, r *http.Request) {
+	clientID := mux.Vars(r)["id"]
+	if !h.ps.ResetClientUsage(clientID) {
+		writeError(w, errorOf(pubsub.ErrNotFound, "Client not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "reset", "client_id": clientID})
+}
+
+// KickClient handles POST /clients/{id}/kick
+func (h *HTTPHandlers) KickClient(w http.ResponseWriter, r *http.Request) {
+	clientID := mux.Vars(r)["id"]
+	var req pubsub.KickRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			writeError(w, errorOf(pubsub.ErrInvalidRequest, "Invalid JSON payload"))
+			return
+		}
+	}
+	if !h.ps.KickClient(clientID, req.Reason) {
+		writeError(w, errorOf(pubsub.ErrNotFound, "Client not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "kicked", "client_id": clientID})
+}
+
+// CreateSnapshot handles POST /admin/snapshot
+func (h *HTTPHandlers) CreateSnapshot(w http.ResponseWriter, r *http.Request) {
+	var req pubsub.SnapshotRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			writeError(w, errorOf(pubsub.ErrInvalidRequest, "Invalid JSON payload"))
+			return
+		}
+	}
+
+	snapshot := h.ps.Snapshot()
+
+	if req.Path == "" {
+		// Stream the snapshot in the response
+		w.Header().Set("Content-Type", "application/json")
+		if req.Gzip {
+			w.Header().Set("Content-Type", "application/gzip")
+		}
+		w.WriteHeader(http.StatusOK)
+		pubsub.WriteSnapshot(w, snapshot, req.Gzip)
+		return
+	}
+
+	if h.snapshotDir == "" {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "Server-side snapshots are disabled; stream the snapshot instead"))
+		return
+	}
+	if !filepath.IsLocal(req.Path) {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "Snapshot path must be relative to the snapshot directory"))
+		return
+	}
+	f, err := os.Create(filepath.Join(h.snapshotDir, req.Path))
+	if err != nil {
+		writeError(w, fmt.Errorf("failed to create snapshot file: %w", err))
+		return
+	}
+	if err := pubsub.WriteSnapshot(f, snapshot, req.Gzip); err != nil {
+		f.Close()
+		writeError(w, fmt.Errorf("failed to write snapshot: %w", err))
+		return
+	}
+	if err := f.Close(); err != nil {
+		writeError(w, fmt.Errorf("failed to write snapshot: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	resp := pubsub.SnapshotResponse{
+		Status: "written",
+		Path:   req.Path,
+		Topics: len(snapshot.Topics),
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// RestoreSnapshot handles POST /admin/restore with a snapshot as the body
+func (h *HTTPHandlers) RestoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := pubsub.ReadSnapshot(r.Body)
+	if err != nil {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "Invalid snapshot: "+err.Error()))
+		return
+	}
+
+	restored, err := h.ps.RestoreSnapshot(snapshot)
+	if err != nil {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	resp := pubsub.RestoreResponse{
+		Status: "restored",
+		Topics: restored,
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Broadcast handles POST /admin/broadcast
+func (h *HTTPHandlers) Broadcast(w http.ResponseWriter, r *http.Request) {
+	var req pubsub.BroadcastRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "Invalid JSON payload"))
+		return
+	}
+
+	resp, err := h.ps.Broadcast(req.Message, req.Severity, time.Duration(req.CloseAfterSeconds)*time.Second)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// SendToClient handles POST /clients/{id}/send
+func (h *HTTPHandlers) SendToClient(w http.ResponseWriter, r *http.Request) {
+	clientID := mux.Vars(r)["id"]
+
+	var req pubsub.DirectSendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "Invalid JSON payload"))
+		return
+	}
+
+	status, err := h.ps.SendDirect(r.Context(), req.From, clientID, req.Message, pubsub.DirectOptions{
+		Hold:          req.BufferIfOffline,
+		CorrelationID: req.CorrelationID,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(pubsub.DirectSendResponse{Status: status, ClientID: clientID})
+}
+
+// Drain handles POST /admin/drain
+func (h *HTTPHandlers) Drain(w http.ResponseWriter, r *http.Request) {
+	if h.ps.Drain() {
+		log.Printf("Draining: refusing new connections and subscriptions")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h.ps.DrainState())
+}
+
+// Undrain handles POST /admin/undrain
+func (h *HTTPHandlers) Undrain(w http.ResponseWriter, r *http.Request) {
+	if h.ps.Undrain() {
+		log.Printf("Drain ended: accepting new connections and subscriptions")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h.ps.DrainState())
+}
+
+// GetConnections handles GET /admin/connections
+func (h *HTTPHandlers) GetConnections(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(pubsub.ConnectionsResponse{
+		Total:    h.ps.OpenConnections(),
+		MaxPerIP: h.ps.MaxConnectionsPerIP(),
+		ByIP:     h.ps.ConnectionsByIP(),
+	})
+}
+
+// UpsertPollSubscription handles POST /subscriptions
+func (h *HTTPHandlers) UpsertPollSubscription(w http.ResponseWriter, r *http.Request) {
+	var req pubsub.PollSubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "Invalid JSON payload"))
+		return
+	}
+	if len(req.Topics) == 0 {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "At least one topic is required"))
+		return
+	}
+
+	sub, err := h.polls.Upsert(r.Context(), req.ClientID, r.Header.Get(PollTokenHeader), req.Topics)
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	resp := pubsub.PollSubscriptionResponse{
+		Status:    "subscribed",
+		ClientID:  sub.clientID,
+		PollToken: sub.Token(),
+		Topics:    req.Topics,
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// DeletePollSubscription handles DELETE /subscriptions/{client_id}
+func (h *HTTPHandlers) DeletePollSubscription(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	clientID := vars["client_id"]
+
+	if err := h.polls.Remove(clientID, r.Header.Get(PollTokenHeader)); err != nil {
+		writePollError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	resp := map[string]string{
+		"status":    "deleted",
+		"client_id": clientID,
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// CancelScheduled handles DELETE /scheduled/{token}
+func (h *HTTPHandlers) CancelScheduled(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	scheduled, err := h.ps.CancelScheduled(token)
+	if err != nil {
+		writeError(w, errorOf(pubsub.ErrNotFound, err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	resp := map[string]string{
+		"status": "cancelled",
+		"token":  token,
+		"topic":  scheduled.Topic,
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Poll handles GET /poll
+func (h *HTTPHandlers) Poll(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	clientID := query.Get("client_id")
+	if clientID == "" {
+		writeError(w, errorOf(pubsub.ErrInvalidRequest, "client_id is required"))
+		return
+	}
+
+	wait := DefaultPollWait
+	if v := query.Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			writeError(w, errorOf(pubsub.ErrInvalidRequest, "wait must be a duration such as 30s"))
+			return
+		}
+		wait = d
+	}
+	if wait > MaxPollWait {
+		wait = MaxPollWait
+	}
+
+	cursor := int64(-1)
+	if v := query.Get("cursor"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			writeError(w, errorOf(pubsub.ErrInvalidRequest, "cursor must be a non-negative integer"))
+			return
+		}
+		cursor = n
+	}
+
+	events, next, err := h.polls.Poll(r.Context(), clientID, r.Header.Get(PollTokenHeader), cursor, wait)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return // Client went away
+		}
+		writePollError(w, err)
+		return
+	}
+
+	resp := pubsub.PollResponse{
+		ClientID: clientID,
+		Events:   events,
+		Cursor:   next,
+	}
+	if resp.Events == nil {
+		resp.Events = []pubsub.EventResponse{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// writeError answers a request the broker refused, with the HTTP status
+// of the error's stable code
+func writeError(w http.ResponseWriter, err error) {
+	errData := pubsub.NewErrorData(err)
+	writeErrorStatus(w, pubsub.HTTPStatus(errData.Code), errData)
+}
+
+// writeErrorStatus answers with errData under a status other than its
+// code's, for refusals HTTP names more precisely
+func writeErrorStatus(w http.ResponseWriter, status int, errData pubsub.ErrorData) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorBody{
+		Error:     errData.Message,
+		Code:      errData.Code,
+		Reason:    errData.Reason,
+		Retryable: errData.Retryable,
+	})
+}
+
+// errorOf returns an error reading as message, reported under kind's code
+func errorOf(kind error, message string) error {
+	errData := pubsub.NewErrorData(kind)
+	errData.Message = message
+	return errData
+}
+
+// errorBody is the JSON body of a refused request
+type errorBody struct {
+	Error     string `json:"error"`
+	Code      string `json:"code,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Retryable bool   `json:"retryable"`
+}
+
+// writePollError reports a poll subscription failure: a wrong token is
+// forbidden, a client_id held by another connection conflicts, an unknown
+// subscription is not found, and the broker's own refusals carry their code
+func writePollError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errWrongPollToken):
+		writeError(w, errorOf(pubsub.ErrPermissionDenied, err.Error()))
+	case errors.Is(err, errClientIDInUse):
+		errData := pubsub.ErrorData{Code: "CLIENT_ID_IN_USE", Message: err.Error()}.Stable()
+		writeErrorStatus(w, http.StatusConflict, errData)
+	case errors.Is(err, errPollSubscriptionNotFound):
+		writeError(w, errorOf(pubsub.ErrNotFound, err.Error()))
+	default:
+		// The broker refused the subscription
+		writeError(w, err)
+	}
+}
+
+// namespacePrefixes mount the topic routes for the default namespace and
+// for a namespace named in the path
+var namespacePrefixes = []string{"", "/namespaces/{ns}"}
+
+// SetupRoutes configures every HTTP route on one router without admin
+// authentication
+func (h *HTTPHandlers) SetupRoutes(router *mux.Router) {
+	h.SetupAdminRoutes(router)
+	h.SetupPublicRoutes(router)
+}
+
+// SetupPublicRoutes configures the routes clients use: the websocket,
+// health checks, topic reads and the long-polling fallback
+func (h *HTTPHandlers) SetupPublicRoutes(router *mux.Router) {
+	// Topic reads, in the default namespace or a named one
+	for _, prefix := range namespacePrefixes {
+		router.HandleFunc(prefix+"/topics", h.GetTopics).Methods("GET")
+		router.HandleFunc(prefix+"/topics/{name}", h.GetTopicDetail).Methods("GET")
+		router.HandleFunc(prefix+"/topics/{name}/messages", h.GetTopicMessages).Methods("GET")
+		router.HandleFunc(prefix+"/topics/{name}/messages/{message_id}/receipts", h.GetMessageReceipts).Methods("GET")
+	}
+
+	// System endpoints
+	router.HandleFunc("/health", h.GetHealth).Methods("GET")
+	router.HandleFunc("/namespaces/{ns}/health", h.GetNamespaceHealth).Methods("GET")
+	router.HandleFunc("/livez", h.GetLiveness).Methods("GET")
+	router.HandleFunc("/readyz", h.GetReadiness).Methods("GET")
+
+	// Long-polling fallback
+	router.HandleFunc("/subscriptions", h.UpsertPollSubscription).Methods("POST")
+	router.HandleFunc("/subscriptions/{client_id}", h.DeletePollSubscription).Methods("DELETE")
+	router.HandleFunc("/poll", h.Poll).Methods("GET")
+	router.HandleFunc("/scheduled/{token}", h.CancelScheduled).Methods("DELETE")
+
+	// WebSocket endpoint
+	router.Handle("/ws", h.websocket).Methods("GET")
+	router.Handle("/ws/{namespace}", h.websocket).Methods("GET")
+}
+
+// SetupAdminRoutes configures the operator routes: topic mutations,
+// history export and import, webhooks, stats, metrics, the subscription
+// and client listings, the audit log, the firehose websocket, snapshots,
+// broadcasts, drain mode, buffer high watermarks and counter resets.
+// Callers protect them with AdminAuth or a separate listener.
+func (h *HTTPHandlers) SetupAdminRoutes(router *mux.Router) {
+	// Topic management, in the default namespace or a named one
+	for _, prefix := range namespacePrefixes {
+		router.HandleFunc(prefix+"/topics", h.CreateTopic).Methods("POST")
+		router.HandleFunc(prefix+"/topics/{name}", h.DeleteTopic).Methods("DELETE")
+		router.HandleFunc(prefix+"/topics/{name}", h.UpdateTopic).Methods("PATCH")
+		router.HandleFunc(prefix+"/topics/{name}/schema", h.SetTopicSchema).Methods("PUT")
+		router.HandleFunc(prefix+"/topics/{name}/pause", h.PauseTopic).Methods("POST")
+		router.HandleFunc(prefix+"/topics/{name}/resume", h.ResumeTopic).Methods("POST")
+		router.HandleFunc(prefix+"/topics/{name}/messages", h.PurgeTopicMessages).Methods("DELETE")
+		router.HandleFunc(prefix+"/topics/{name}/messages/{message_id}", h.DeleteTopicMessage).Methods("DELETE")
+		router.HandleFunc(prefix+"/topics/{name}/export", h.ExportTopic).Methods("GET")
+		router.HandleFunc(prefix+"/topics/{name}/import", h.ImportTopic).Methods("POST")
+		router.HandleFunc(prefix+"/topics/{name}/webhooks", h.CreateWebhook).Methods("POST")
+		router.HandleFunc(prefix+"/topics/{name}/webhooks", h.GetWebhooks).Methods("GET")
+		router.HandleFunc(prefix+"/topics/{name}/webhooks/{id}", h.DeleteWebhook).Methods("DELETE")
+	}
+
+	// System endpoints
+	router.HandleFunc("/stats", h.GetStats).Methods("GET")
+	router.HandleFunc("/namespaces/{ns}/stats", h.GetNamespaceStats).Methods("GET")
+	router.HandleFunc("/metrics", h.GetMetrics).Methods("GET")
+	router.HandleFunc("/subscriptions", h.GetSubscriptionsStatus).Methods("GET")
+	router.HandleFunc("/clients/{id}", h.GetClient).Methods("GET")
+	router.HandleFunc("/clients/{id}/usage", h.ResetClientUsage).Methods("DELETE")
+	router.HandleFunc("/clients/{id}/send", h.SendToClient).Methods("POST")
+	router.HandleFunc("/clients/{id}/kick", h.KickClient).Methods("POST")
+	router.HandleFunc("/audit", h.GetAudit).Methods("GET")
+
+	// WebSocket endpoint that may subscribe to the firehose
+	router.HandleFunc("/admin/ws", h.websocket.Admin()).Methods("GET")
+
+	// Snapshots, only once enabled behind auth
+	if h.snapshots {
+		router.HandleFunc("/admin/snapshot", h.CreateSnapshot).Methods("POST")
+		router.HandleFunc("/admin/restore", h.RestoreSnapshot).Methods("POST")
+	}
+
+	// Broadcasts to every connected client
+	router.HandleFunc("/admin/broadcast", h.Broadcast).Methods("POST")
+
+	// Drain mode, for rolling restarts
+	router.HandleFunc("/admin/drain", h.Drain).Methods("POST")
+	router.HandleFunc("/admin/undrain", h.Undrain).Methods("POST")
+
+	// Connection listing
+	router.HandleFunc("/admin/connections", h.GetConnections).Methods("GET")
+
+	// Buffer high watermarks
+	router.HandleFunc("/admin/buffers/high-watermarks", h.ResetBufferHighWatermarks).Methods("DELETE")
+
+	// Counter snapshots and resets
+	router.HandleFunc("/admin/stats/snapshot", h.GetCounterSnapshot).Methods("GET")
+	router.HandleFunc("/admin/stats/reset", h.ResetCounters).Methods("POST")
+}
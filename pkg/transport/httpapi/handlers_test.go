@@ -0,0 +1,1317 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/AnshulDekate/pubsub/pkg/clock/clocktest"
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// testClient is a connected client that discards what it is sent
+type testClient struct{ id string }
+
+func (c testClient) GetClientID() string           { return c.id }
+func (c testClient) IsConnected() bool             { return true }
+func (c testClient) SendMessage(interface{}) error { return nil }
+func (c testClient) GetLastActive() time.Time      { return time.Now() }
+
+// fullClient is a connection whose send buffer is always full
+type fullClient struct{ testClient }
+
+func (c fullClient) SendMessage(interface{}) error { return errors.New("send buffer full") }
+
+// apiServer serves the HTTP routes for ps
+func apiServer(t *testing.T, ps *pubsub.PubSubSystem) *httptest.Server {
+	t.Helper()
+	router := mux.NewRouter()
+	NewHTTPHandlers(ps).SetupRoutes(router)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// snapshotServer is apiServer with snapshots enabled, as behind admin auth
+func snapshotServer(t *testing.T, ps *pubsub.PubSubSystem, dir string) *httptest.Server {
+	t.Helper()
+	handlers := NewHTTPHandlers(ps)
+	if err := handlers.EnableSnapshots(AdminAuth{Token: "secret"}, dir); err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	handlers.SetupRoutes(router)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// do sends a request and decodes the JSON response into out, returning
+// the status
+func do(t *testing.T, method, url, body string, out interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode
+}
+
+// publishN publishes n messages to topic
+func publishN(t *testing.T, ps *pubsub.PubSubSystem, topic string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := ps.Publish(context.Background(), topic, pubsub.MessageData{ID: fmt.Sprintf("m%d", i), Payload: i}, ""); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+}
+
+func TestTopicDetailEmptyTopic(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	server := apiServer(t, ps)
+
+	var detail pubsub.TopicDetailResponse
+	if status := do(t, "GET", server.URL+"/topics/orders", "", &detail); status != http.StatusOK {
+		t.Fatalf("GET /topics/orders = %d", status)
+	}
+	if detail.Name != "orders" || detail.CreatedAt.IsZero() || detail.HistorySize != pubsub.TopicHistoryBufferSize {
+		t.Errorf("detail = %+v", detail)
+	}
+	if detail.MessageCount != 0 || detail.HistoryCount != 0 || detail.LatestSeq != 0 || detail.Subscribers != 0 {
+		t.Errorf("new topic has traffic: %+v", detail)
+	}
+	if detail.LastPublishedAt != nil {
+		t.Errorf("new topic has a last publish: %+v", detail)
+	}
+
+	var notFound map[string]string
+	if status := do(t, "GET", server.URL+"/topics/missing", "", &notFound); status != http.StatusNotFound || notFound["error"] == "" {
+		t.Errorf("GET /topics/missing = %d %v", status, notFound)
+	}
+}
+
+func TestTopicDetailWithTraffic(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	server := apiServer(t, ps)
+	if _, err := ps.Subscribe(context.Background(), "watcher", "orders", 0, testClient{id: "watcher"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, payload := range []string{"a", "b", "c"} {
+		if err := ps.Publish(context.Background(), "orders", pubsub.MessageData{ID: uuid.New().String(), Payload: payload}, ""); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	var detail pubsub.TopicDetailResponse
+	if status := do(t, "GET", server.URL+"/topics/orders", "", &detail); status != http.StatusOK {
+		t.Fatalf("GET /topics/orders = %d", status)
+	}
+	if detail.MessageCount != 3 || detail.HistoryCount != 3 || detail.LatestSeq != 3 || detail.Subscribers != 1 {
+		t.Errorf("counts = %+v", detail)
+	}
+	if detail.LastPublishedAt == nil || time.Since(*detail.LastPublishedAt) > time.Minute {
+		t.Errorf("last publish = %v", detail.LastPublishedAt)
+	}
+}
+
+func TestTopicMessagesPagination(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	server := apiServer(t, ps)
+	page := func(query string) (pubsub.TopicMessagesResponse, int) {
+		var resp pubsub.TopicMessagesResponse
+		status := do(t, "GET", server.URL+"/topics/orders/messages"+query, "", &resp)
+		return resp, status
+	}
+
+	// An empty history is an empty array, not null
+	var raw map[string]interface{}
+	do(t, "GET", server.URL+"/topics/orders/messages", "", &raw)
+	if messages, ok := raw["messages"].([]interface{}); !ok || len(messages) != 0 || raw["next_cursor"] != nil {
+		t.Errorf("empty history = %v", raw)
+	}
+	if _, status := page("?order=sideways"); status != http.StatusBadRequest {
+		t.Errorf("bad order = %d", status)
+	}
+	if _, status := page("?after_seq=1&before_seq=5"); status != http.StatusBadRequest {
+		t.Errorf("both cursors = %d", status)
+	}
+	if status := do(t, "GET", server.URL+"/topics/missing/messages", "", nil); status != http.StatusNotFound {
+		t.Errorf("unknown topic = %d", status)
+	}
+
+	// Fill the ring past its capacity so pages cross the wraparound
+	total := pubsub.TopicHistoryBufferSize + 30
+	for i := 0; i < total; i++ {
+		if err := ps.Publish(context.Background(), "orders", pubsub.MessageData{ID: uuid.New().String(), Payload: i}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldest := int64(total - pubsub.TopicHistoryBufferSize + 1)
+
+	// The default page is the newest 50, newest first
+	resp, _ := page("")
+	if len(resp.Messages) != DefaultHistoryPageLimit || resp.Messages[0].Seq != int64(total) || resp.NextCursor == nil {
+		t.Fatalf("default page = %d messages from %d, cursor %v", len(resp.Messages), resp.Messages[0].Seq, resp.NextCursor)
+	}
+
+	// Follow next_cursor forward from the oldest message to the end
+	next := oldest
+	query := "?order=asc&limit=300"
+	for {
+		resp, status := page(query)
+		if status != http.StatusOK {
+			t.Fatalf("GET %s = %d", query, status)
+		}
+		for _, event := range resp.Messages {
+			if event.Seq != next {
+				t.Fatalf("page %s has seq %d, want %d", query, event.Seq, next)
+			}
+			next++
+		}
+		if resp.NextCursor == nil {
+			break
+		}
+		query = fmt.Sprintf("?order=asc&limit=300&after_seq=%d", *resp.NextCursor)
+	}
+	if next != int64(total+1) {
+		t.Errorf("paging forward stopped before seq %d", next)
+	}
+
+	// And backward from the newest
+	next = int64(total)
+	query = "?limit=300"
+	for {
+		resp, _ := page(query)
+		for _, event := range resp.Messages {
+			if event.Seq != next {
+				t.Fatalf("page %s has seq %d, want %d", query, event.Seq, next)
+			}
+			next--
+		}
+		if resp.NextCursor == nil {
+			break
+		}
+		query = fmt.Sprintf("?limit=300&before_seq=%d", *resp.NextCursor)
+	}
+	if next != oldest-1 {
+		t.Errorf("paging backward stopped after seq %d", next+1)
+	}
+
+	// Limits above the maximum are capped
+	if resp, _ := page("?limit=100000"); len(resp.Messages) != DefaultMaxHistoryLimit {
+		t.Errorf("oversized limit returned %d messages", len(resp.Messages))
+	}
+}
+
+func TestMaxHistoryLimitIsPositive(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	publishN(t, ps, "orders", 3)
+	for _, max := range []int{0, -1} {
+		handlers := NewHTTPHandlers(ps)
+		handlers.SetMaxHistoryLimit(max)
+		router := mux.NewRouter()
+		handlers.SetupRoutes(router)
+		server := httptest.NewServer(router)
+		defer server.Close()
+
+		// The default maximum applies rather than an empty page
+		var resp pubsub.TopicMessagesResponse
+		if status := do(t, "GET", server.URL+"/topics/orders/messages?limit=2", "", &resp); status != http.StatusOK || len(resp.Messages) != 2 || resp.NextCursor == nil {
+			t.Errorf("max %d: GET messages = %d %+v", max, status, resp)
+		}
+	}
+}
+
+func TestPurgeTopicMessages(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	server := apiServer(t, ps)
+	publishN(t, ps, "orders", 6)
+
+	if status := do(t, "DELETE", server.URL+"/topics/orders/messages?before_seq=0", "", nil); status != http.StatusBadRequest {
+		t.Errorf("before_seq=0 = %d", status)
+	}
+	if status := do(t, "DELETE", server.URL+"/topics/orders/messages?before_seq=2&before_ts=2024-01-01T00:00:00Z", "", nil); status != http.StatusBadRequest {
+		t.Errorf("both bounds = %d", status)
+	}
+
+	var resp pubsub.PurgeMessagesResponse
+	if status := do(t, "DELETE", server.URL+"/topics/orders/messages?before_seq=3", "", &resp); status != http.StatusOK || resp.Purged != 2 {
+		t.Errorf("partial purge = %d %+v", status, resp)
+	}
+	if status := do(t, "DELETE", server.URL+"/topics/orders/messages", "", &resp); status != http.StatusOK || resp.Purged != 4 {
+		t.Errorf("full purge = %d %+v", status, resp)
+	}
+	if detail, _ := ps.GetTopicDetail("orders"); detail.HistoryCount != 0 || detail.MessageCount != 6 {
+		t.Errorf("after purging, detail = %+v", detail)
+	}
+	if status := do(t, "DELETE", server.URL+"/topics/missing/messages", "", nil); status != http.StatusNotFound {
+		t.Errorf("unknown topic = %d", status)
+	}
+}
+
+func TestDeleteTopicMessage(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	server := apiServer(t, ps)
+	publishN(t, ps, "orders", 5)
+
+	var resp pubsub.DeleteMessageResponse
+	if status := do(t, "DELETE", server.URL+"/topics/orders/messages/m2", "", &resp); status != http.StatusOK || resp.MessageID != "m2" || resp.Seq != 3 {
+		t.Errorf("delete = %d %+v", status, resp)
+	}
+	replay, err := ps.Subscribe(context.Background(), "c1", "orders", 5, testClient{id: "c1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range replay {
+		if event.Message.ID == "m2" {
+			t.Error("last_n replayed the deleted message")
+		}
+	}
+	if len(replay) != 4 {
+		t.Errorf("replayed %d messages, want 4", len(replay))
+	}
+
+	if status := do(t, "DELETE", server.URL+"/topics/orders/messages/m2", "", nil); status != http.StatusNotFound {
+		t.Errorf("deleting it again = %d", status)
+	}
+	if status := do(t, "DELETE", server.URL+"/topics/orders/messages/unknown", "", nil); status != http.StatusNotFound {
+		t.Errorf("unknown message = %d", status)
+	}
+}
+
+func TestCancelScheduledOverREST(t *testing.T) {
+	ps := pubsub.New()
+	defer ps.Close()
+	if err := ps.CreateTopic(context.Background(), "meetings"); err != nil {
+		t.Fatal(err)
+	}
+	server := apiServer(t, ps)
+	scheduled, err := ps.SchedulePublish(context.Background(), "meetings", pubsub.MessageData{ID: "m1", Payload: "starts now"}, "", pubsub.PublishOptions{}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp map[string]string
+	if status := do(t, "DELETE", server.URL+"/scheduled/"+scheduled.Token, "", &resp); status != http.StatusOK || resp["status"] != "cancelled" || resp["topic"] != "meetings" {
+		t.Errorf("cancel = %d %v", status, resp)
+	}
+	if status := do(t, "DELETE", server.URL+"/scheduled/"+scheduled.Token, "", nil); status != http.StatusNotFound {
+		t.Errorf("second cancel = %d", status)
+	}
+	if pending := ps.GetStats().Topics["meetings"].Scheduled; pending != 0 {
+		t.Errorf("scheduled = %d after cancelling", pending)
+	}
+}
+
+func TestSendToClientOverREST(t *testing.T) {
+	ps := pubsub.New()
+	server := apiServer(t, ps)
+
+	var resp pubsub.DirectSendResponse
+	body := `{"from":"ops","message":{"id":"m1","payload":"refresh"},"buffer_if_offline":true}`
+	if status := do(t, "POST", server.URL+"/clients/bob/send", body, &resp); status != http.StatusOK || resp.Status != pubsub.DirectBuffered {
+		t.Errorf("held send = %d %+v", status, resp)
+	}
+	if status := do(t, "POST", server.URL+"/clients/bob/send", `{"message":{"id":"m2"}}`, &resp); status != http.StatusOK || resp.Status != pubsub.DirectOffline {
+		t.Errorf("send = %d %+v", status, resp)
+	}
+	if status := do(t, "POST", server.URL+"/clients/bob/send", `{"message":{}}`, nil); status != http.StatusBadRequest {
+		t.Errorf("send without a message id = %d", status)
+	}
+
+	ps.SetDirectAuthorizer(func(ctx context.Context, from, to string) error { return errors.New("closed") })
+	if status := do(t, "POST", server.URL+"/clients/bob/send", body, nil); status != http.StatusForbidden {
+		t.Errorf("refused send = %d", status)
+	}
+}
+
+func TestTopicRetention(t *testing.T) {
+	ps := pubsub.New()
+	server := apiServer(t, ps)
+
+	if status := do(t, "POST", server.URL+"/topics", `{"name":"orders","retention_seconds":-1}`, nil); status != http.StatusBadRequest {
+		t.Errorf("negative retention = %d", status)
+	}
+	if status := do(t, "POST", server.URL+"/topics", `{"name":"orders","retention_seconds":60}`, nil); status != http.StatusCreated {
+		t.Fatalf("POST /topics = %d", status)
+	}
+	publishN(t, ps, "orders", 3)
+
+	// Fresh messages are all kept
+	var detail pubsub.TopicDetailResponse
+	if status := do(t, "GET", server.URL+"/topics/orders", "", &detail); status != http.StatusOK {
+		t.Fatalf("GET /topics/orders = %d", status)
+	}
+	if detail.RetentionSeconds != 60 || detail.HistoryCount != 3 {
+		t.Errorf("detail = %+v", detail)
+	}
+}
+
+func TestSweepRetentionExpiresQuietTopics(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	ps := pubsub.NewWithClock(clock)
+	if err := ps.CreateTopicWithConfig(context.Background(), "orders", pubsub.TopicConfig{Retention: 50 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	publishN(t, ps, "orders", 4)
+
+	clock.Advance(100 * time.Millisecond)
+	if n := ps.SweepRetention(); n != 4 {
+		t.Errorf("sweep removed %d events, want 4", n)
+	}
+}
+
+func TestRetentionOverREST(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	ps := pubsub.NewWithClock(clock)
+	server := apiServer(t, ps)
+	if status := do(t, "POST", server.URL+"/topics", `{"name":"orders"}`, nil); status != http.StatusCreated {
+		t.Fatalf("POST /topics = %d", status)
+	}
+	publishN(t, ps, "orders", 3)
+	clock.Advance(1100 * time.Millisecond)
+	publishN(t, ps, "orders", 2)
+
+	detail := func() pubsub.TopicDetailResponse {
+		t.Helper()
+		var detail pubsub.TopicDetailResponse
+		do(t, "GET", server.URL+"/topics/orders", "", &detail)
+		return detail
+	}
+	if got := detail(); got.RetentionSeconds != 0 || got.ExpiredCount != 0 || got.HistoryCount != 5 {
+		t.Errorf("without retention, detail = %+v", got)
+	}
+
+	// Retention added later applies to the events already held, and the
+	// events it drops are counted
+	if status := do(t, "PATCH", server.URL+"/topics/orders", `{"retention_seconds":1}`, nil); status != http.StatusOK {
+		t.Fatalf("PATCH /topics/orders = %d", status)
+	}
+	if got := detail(); got.RetentionSeconds != 1 || got.ExpiredCount != 3 || got.HistoryCount != 2 {
+		t.Errorf("after adding retention, detail = %+v", got)
+	}
+
+	// New subscribers' last_n replay never includes expired events
+	clock.Advance(600 * time.Millisecond)
+	publishN(t, ps, "orders", 1)
+	clock.Advance(600 * time.Millisecond)
+	late := testClient{id: "late"}
+	ps.RegisterClient(late)
+	replay, err := ps.SubscribeWithOptions(context.Background(), late.id, "orders", pubsub.SubscribeOptions{LastN: 10}, late)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replay) != 1 || replay[0].Seq != 6 {
+		t.Errorf("last_n replayed %+v, want only the newest event", replay)
+	}
+
+	// Lengthening retention doesn't bring expired events back
+	if status := do(t, "PATCH", server.URL+"/topics/orders", `{"retention_seconds":0}`, nil); status != http.StatusOK {
+		t.Fatalf("PATCH /topics/orders = %d", status)
+	}
+	if got := detail(); got.RetentionSeconds != 0 || got.ExpiredCount != 5 || got.HistoryCount != 1 {
+		t.Errorf("after removing retention, detail = %+v", got)
+	}
+
+	if status := do(t, "PATCH", server.URL+"/topics/orders", `{"retention_seconds":-1}`, nil); status != http.StatusBadRequest {
+		t.Errorf("PATCH with negative retention = %d", status)
+	}
+	if status := do(t, "PATCH", server.URL+"/topics/missing", `{"retention_seconds":60}`, nil); status != http.StatusNotFound {
+		t.Errorf("PATCH an unknown topic's retention = %d", status)
+	}
+}
+
+func TestTopicSchemaOverREST(t *testing.T) {
+	ps := pubsub.New()
+	server := apiServer(t, ps)
+	const schema = `{"type":"object","required":["id"]}`
+
+	if status := do(t, "POST", server.URL+"/topics", `{"name":"orders","schema":`+schema+`}`, nil); status != http.StatusCreated {
+		t.Fatalf("POST /topics with a schema = %d", status)
+	}
+	var detail pubsub.TopicDetailResponse
+	if do(t, "GET", server.URL+"/topics/orders", "", &detail); string(detail.Schema) != schema {
+		t.Errorf("GET /topics/orders schema = %s", detail.Schema)
+	}
+	publish := func(payload interface{}) error {
+		return ps.Publish(context.Background(), "orders", pubsub.MessageData{ID: uuid.New().String(), Payload: payload}, "")
+	}
+	var invalidPayload *pubsub.SchemaValidationError
+	if err := publish(map[string]interface{}{"name": "x"}); !errors.As(err, &invalidPayload) {
+		t.Errorf("publishing without id = %v", err)
+	}
+
+	// Replaced, then removed
+	const stricter = `{"type":"object","required":["id","name"]}`
+	if status := do(t, "PUT", server.URL+"/topics/orders/schema", stricter, &detail); status != http.StatusOK || string(detail.Schema) != stricter {
+		t.Errorf("PUT schema = %d, %s", status, detail.Schema)
+	}
+	if err := publish(map[string]interface{}{"id": "x"}); !errors.As(err, &invalidPayload) {
+		t.Errorf("publishing without name under the new schema = %v", err)
+	}
+	if status := do(t, "PUT", server.URL+"/topics/orders/schema", `{"type":5}`, nil); status != http.StatusBadRequest {
+		t.Errorf("PUT invalid schema = %d", status)
+	}
+	var removed pubsub.TopicDetailResponse
+	if status := do(t, "PUT", server.URL+"/topics/orders/schema", "null", &removed); status != http.StatusOK || removed.Schema != nil {
+		t.Errorf("PUT null schema = %d, %s", status, removed.Schema)
+	}
+	if err := publish("anything"); err != nil {
+		t.Errorf("publishing with no schema = %v", err)
+	}
+
+	if status := do(t, "POST", server.URL+"/topics", `{"name":"bad","schema":{"type":5}}`, nil); status != http.StatusBadRequest {
+		t.Errorf("POST /topics with an invalid schema = %d", status)
+	}
+}
+
+func TestTopicActivityOverREST(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateTopic(context.Background(), "quiet"); err != nil {
+		t.Fatal(err)
+	}
+	server := apiServer(t, ps)
+	ps.RegisterClient(testClient{id: "watcher"})
+	if _, err := ps.Subscribe(context.Background(), "watcher", "orders", 0, testClient{id: "watcher"}); err != nil {
+		t.Fatal(err)
+	}
+	publishN(t, ps, "orders", 30)
+
+	// The same activity under every listing
+	activity := func() map[string]pubsub.TopicActivity {
+		var topics pubsub.TopicsResponse
+		do(t, "GET", server.URL+"/topics", "", &topics)
+		var detail pubsub.TopicDetailResponse
+		do(t, "GET", server.URL+"/topics/orders", "", &detail)
+		var stats pubsub.StatsResponse
+		do(t, "GET", server.URL+"/stats", "", &stats)
+		byName := map[string]pubsub.TopicActivity{"detail": detail.TopicActivity, "stats": stats.Topics["orders"].TopicActivity}
+		for _, topic := range topics.Topics {
+			byName[topic.Name] = topic.TopicActivity
+		}
+		return byName
+	}
+	// Deliveries are recorded as subscribers are sent events
+	for deadline := time.Now().Add(5 * time.Second); activity()["orders"].LastDeliveryAt == nil && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	got := activity()
+	for _, view := range []string{"orders", "detail", "stats"} {
+		a := got[view]
+		if a.Rate1m != 0.5 || a.Rate5m != 0.1 || a.LastPublishedAt == nil || a.LastDeliveryAt == nil {
+			t.Errorf("%s activity = %+v", view, a)
+		}
+	}
+	if quiet := got["quiet"]; quiet.Rate1m != 0 || quiet.LastPublishedAt != nil || quiet.LastDeliveryAt != nil {
+		t.Errorf("quiet topic activity = %+v", quiet)
+	}
+}
+
+func getHealth(t *testing.T, url string) pubsub.HealthResponse {
+	t.Helper()
+	var health pubsub.HealthResponse
+	if status := do(t, "GET", url+"/health", "", &health); status != http.StatusOK {
+		t.Fatalf("GET /health = %d", status)
+	}
+	return health
+}
+
+func TestHealthKeepsExistingFields(t *testing.T) {
+	server := apiServer(t, pubsub.New())
+
+	var raw map[string]interface{}
+	do(t, "GET", server.URL+"/health", "", &raw)
+	for _, field := range []string{"status", "uptime_sec", "topics", "subscribers", "connections", "identified_clients", "goroutines", "heap_inuse_bytes", "drop_rate"} {
+		if _, ok := raw[field]; !ok {
+			t.Errorf("/health lacks %q: %v", field, raw)
+		}
+	}
+
+	health := getHealth(t, server.URL)
+	if health.Status != "ok" || len(health.Reasons) != 0 || health.Goroutines == 0 || health.HeapInuseBytes == 0 {
+		t.Errorf("idle health = %+v", health)
+	}
+}
+
+func TestHealthDegradesOnDrops(t *testing.T) {
+	ps := pubsub.New()
+	server := apiServer(t, ps)
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	for _, client := range []pubsub.ClientInterface{testClient{id: "fast"}, fullClient{testClient{id: "stuck"}}} {
+		ps.RegisterClient(client)
+		if _, err := ps.Subscribe(context.Background(), client.GetClientID(), "orders", 0, client); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Half of the deliveries are dropped, well over the default 5%
+	publishN(t, ps, "orders", 10)
+	health := getHealth(t, server.URL)
+	if health.Status != "degraded" || health.DroppedLastMinute != 10 || health.DropRate != 0.5 {
+		t.Fatalf("health with drops = %+v", health)
+	}
+	if len(health.Reasons) != 1 || !strings.Contains(health.Reasons[0], "drop rate") {
+		t.Errorf("reasons = %q", health.Reasons)
+	}
+
+	// A higher threshold tolerates them
+	ps.SetHealthThresholds(pubsub.HealthThresholds{MaxDropRate: 0.6})
+	if health := getHealth(t, server.URL); health.Status != "ok" {
+		t.Errorf("health under a 60%% threshold = %+v", health)
+	}
+}
+
+func TestHealthDegradesOnConnections(t *testing.T) {
+	ps := pubsub.New()
+	server := apiServer(t, ps)
+	ps.SetHealthThresholds(pubsub.HealthThresholds{MaxConnections: 2})
+
+	ps.RegisterClient(testClient{id: "a"})
+	if health := getHealth(t, server.URL); health.Status != "ok" || health.Connections != 1 {
+		t.Errorf("health under the connection threshold = %+v", health)
+	}
+	ps.RegisterClient(testClient{id: "b"})
+	health := getHealth(t, server.URL)
+	if health.Status != "degraded" || len(health.Reasons) != 1 || !strings.Contains(health.Reasons[0], "connections") {
+		t.Errorf("health at the connection threshold = %+v", health)
+	}
+}
+
+func getReadiness(t *testing.T, url string) (pubsub.ReadinessResponse, int) {
+	t.Helper()
+	var readiness pubsub.ReadinessResponse
+	status := do(t, "GET", url+"/readyz", "", &readiness)
+	return readiness, status
+}
+
+func TestReadinessDuringShutdown(t *testing.T) {
+	ps := pubsub.New()
+	server := apiServer(t, ps)
+
+	if readiness, status := getReadiness(t, server.URL); status != http.StatusOK || readiness.Status != "ready" {
+		t.Fatalf("GET /readyz = %d %+v", status, readiness)
+	}
+
+	ps.BeginShutdown()
+	readiness, status := getReadiness(t, server.URL)
+	if status != http.StatusServiceUnavailable || readiness.Reason != "shutting down" {
+		t.Errorf("GET /readyz while shutting down = %d %+v", status, readiness)
+	}
+	// The process is still alive
+	if status := do(t, "GET", server.URL+"/livez", "", nil); status != http.StatusOK {
+		t.Errorf("GET /livez while shutting down = %d", status)
+	}
+}
+
+func TestReadinessOverCapacity(t *testing.T) {
+	ps := pubsub.New()
+	server := apiServer(t, ps)
+	ps.SetMaxConnections(2)
+
+	for i := 0; i < 2; i++ {
+		if !ps.AcquireConnection() {
+			t.Fatalf("connection %d refused under the cap", i+1)
+		}
+	}
+	if ps.AcquireConnection() {
+		t.Fatal("connection accepted over the cap")
+	}
+	readiness, status := getReadiness(t, server.URL)
+	if status != http.StatusServiceUnavailable || !strings.Contains(readiness.Reason, "connection limit") {
+		t.Errorf("GET /readyz at the cap = %d %+v", status, readiness)
+	}
+
+	ps.ReleaseConnection()
+	if readiness, status := getReadiness(t, server.URL); status != http.StatusOK {
+		t.Errorf("GET /readyz under the cap = %d %+v", status, readiness)
+	}
+}
+
+func TestSnapshotAndRestore(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	publishN(t, ps, "orders", 5)
+	dir := t.TempDir()
+	server := snapshotServer(t, ps, dir)
+
+	// Streamed in the response, gzipped
+	resp, err := http.Post(server.URL+"/admin/snapshot", "application/json", strings.NewReader(`{"gzip":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/gzip" {
+		t.Fatalf("POST /admin/snapshot = %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	target := pubsub.New()
+	var restored pubsub.RestoreResponse
+	if status := do(t, "POST", snapshotServer(t, target, "").URL+"/admin/restore", string(body), &restored); status != http.StatusOK || restored.Topics != 1 {
+		t.Fatalf("POST /admin/restore = %d %+v", status, restored)
+	}
+	if detail, err := target.GetTopicDetail("orders"); err != nil || detail.HistoryCount != 5 || detail.LatestSeq != 5 {
+		t.Errorf("restored detail = %+v, %v", detail, err)
+	}
+
+	// Written to a server-side file, only under the snapshot directory
+	var written pubsub.SnapshotResponse
+	if status := do(t, "POST", server.URL+"/admin/snapshot", `{"path":"snapshot.json"}`, &written); status != http.StatusOK || written.Topics != 1 {
+		t.Errorf("POST /admin/snapshot to a file = %d %+v", status, written)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "snapshot.json")); err != nil {
+		t.Errorf("snapshot file: %v", err)
+	}
+	for _, path := range []string{filepath.Join(t.TempDir(), "escaped.json"), "../escaped.json", "a/../../escaped.json"} {
+		if status := do(t, "POST", server.URL+"/admin/snapshot", `{"path":"`+path+`"}`, nil); status != http.StatusBadRequest {
+			t.Errorf("POST /admin/snapshot to %s = %d", path, status)
+		}
+	}
+	if status := do(t, "POST", snapshotServer(t, ps, "").URL+"/admin/snapshot", `{"path":"snapshot.json"}`, nil); status != http.StatusBadRequest {
+		t.Errorf("POST /admin/snapshot to a file without a snapshot directory = %d", status)
+	}
+
+	if status := do(t, "POST", server.URL+"/admin/restore", "not a snapshot", nil); status != http.StatusBadRequest {
+		t.Errorf("restoring garbage = %d", status)
+	}
+}
+
+func TestSnapshotsNeedAuth(t *testing.T) {
+	handlers := NewHTTPHandlers(pubsub.New())
+	if err := handlers.EnableSnapshots(AdminAuth{}, t.TempDir()); err == nil {
+		t.Error("snapshots enabled without admin credentials")
+	}
+
+	// SetupRoutes serves no auth, so it leaves them out by default
+	server := apiServer(t, pubsub.New())
+	if status := do(t, "POST", server.URL+"/admin/snapshot", "{}", nil); status != http.StatusNotFound && status != http.StatusMethodNotAllowed {
+		t.Errorf("POST /admin/snapshot = %d", status)
+	}
+}
+
+func TestTopicListingOverREST(t *testing.T) {
+	ps := pubsub.New()
+	for i := 0; i < 5; i++ {
+		if err := ps.CreateTopic(context.Background(), fmt.Sprintf("conv-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	server := apiServer(t, ps)
+	list := func(query string) (pubsub.TopicsResponse, int) {
+		t.Helper()
+		var resp pubsub.TopicsResponse
+		status := do(t, "GET", server.URL+"/topics"+query, "", &resp)
+		return resp, status
+	}
+	names := func(resp pubsub.TopicsResponse) string {
+		var names []string
+		for _, topic := range resp.Topics {
+			names = append(names, topic.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	for query, want := range map[string]string{
+		"?prefix=conv-&limit=2":                     "conv-0,conv-1",
+		"?prefix=conv-&limit=2&offset=2":            "conv-2,conv-3",
+		"?prefix=conv-&limit=2&offset=4":            "conv-4",
+		"?prefix=conv-&sort=name&order=desc":        "conv-4,conv-3,conv-2,conv-1,conv-0",
+		"?prefix=conv-&order=desc&limit=1&offset=4": "conv-0",
+	} {
+		resp, status := list(query)
+		if status != http.StatusOK || names(resp) != want || resp.TotalCount != 5 {
+			t.Errorf("%s = %d, %q of %d, want %q of 5", query, status, names(resp), resp.TotalCount, want)
+		}
+	}
+	if resp, _ := list(""); resp.TotalCount != 6 || resp.Topics[0].CreatedAt.IsZero() {
+		t.Errorf("unfiltered listing = %+v", resp)
+	}
+
+	// An empty page is still a list
+	for _, query := range []string{"?prefix=none", "?prefix=conv-&offset=5"} {
+		var raw map[string]json.RawMessage
+		do(t, "GET", server.URL+"/topics"+query, "", &raw)
+		if string(raw["topics"]) != "[]" {
+			t.Errorf("%s lists %s", query, raw["topics"])
+		}
+	}
+
+	for _, query := range []string{"?sort=size", "?order=up", "?limit=0", "?limit=x", "?offset=-1", "?label=novalue"} {
+		if _, status := list(query); status != http.StatusBadRequest {
+			t.Errorf("%s = %d, want 400", query, status)
+		}
+	}
+}
+
+func TestTopicStateOverREST(t *testing.T) {
+	ps := pubsub.New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "room"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Publish(ctx, "room", pubsub.MessageData{ID: "m1", Payload: 1}, ""); err != nil {
+		t.Fatal(err)
+	}
+	server := apiServer(t, ps)
+	state := func() string {
+		var detail pubsub.TopicDetailResponse
+		do(t, "GET", server.URL+"/topics/room", "", &detail)
+		return detail.State
+	}
+	if got := state(); got != pubsub.TopicStateActive {
+		t.Errorf("new topic is %q", got)
+	}
+
+	if status := do(t, "PATCH", server.URL+"/topics/room", `{"state":"archived"}`, nil); status != http.StatusOK {
+		t.Fatalf("archiving = %d", status)
+	}
+	if got := state(); got != pubsub.TopicStateArchived {
+		t.Errorf("archived topic is %q", got)
+	}
+	err := ps.Publish(ctx, "room", pubsub.MessageData{ID: "m2", Payload: 2}, "")
+	if !errors.Is(err, pubsub.ErrTopicArchived) {
+		t.Errorf("publishing to the archived topic = %v", err)
+	}
+	var messages pubsub.TopicMessagesResponse
+	if status := do(t, "GET", server.URL+"/topics/room/messages", "", &messages); status != http.StatusOK || len(messages.Messages) != 1 {
+		t.Errorf("reading archived history = %d, %+v", status, messages)
+	}
+
+	if status := do(t, "PATCH", server.URL+"/topics/room", `{"state":"frozen"}`, nil); status != http.StatusBadRequest {
+		t.Errorf("an unknown state = %d", status)
+	}
+	if status := do(t, "PATCH", server.URL+"/topics/room", `{"state":"active"}`, nil); status != http.StatusOK || state() != pubsub.TopicStateActive {
+		t.Errorf("unarchiving = %d, leaving %q", status, state())
+	}
+}
+
+func TestTopicPauseOverREST(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "alerts"); err != nil {
+		t.Fatal(err)
+	}
+	server := apiServer(t, ps)
+	listed := func() bool {
+		var list pubsub.TopicsResponse
+		do(t, "GET", server.URL+"/topics", "", &list)
+		return list.Topics[0].Paused
+	}
+
+	var resp pubsub.TopicPauseResponse
+	if status := do(t, "POST", server.URL+"/topics/alerts/pause", "", &resp); status != http.StatusOK || !resp.Paused || !listed() {
+		t.Fatalf("pausing = %d, %+v", status, resp)
+	}
+	publishN(t, ps, "alerts", 2)
+	if status := do(t, "POST", server.URL+"/topics/alerts/resume", "", &resp); status != http.StatusOK || resp.Paused || resp.Delivered != 2 || listed() {
+		t.Errorf("resuming = %d, %+v", status, resp)
+	}
+	if status := do(t, "POST", server.URL+"/topics/missing/pause", "", nil); status != http.StatusNotFound {
+		t.Errorf("pausing an unknown topic = %d", status)
+	}
+}
+
+func TestTopicLabelsOverREST(t *testing.T) {
+	ps := pubsub.New()
+	server := apiServer(t, ps)
+	for _, body := range []string{
+		`{"name":"orders","description":"Order events","labels":{"team":"shop","env":"prod"}}`,
+		`{"name":"carts","labels":{"team":"shop","env":"staging"}}`,
+		`{"name":"invoices","labels":{"team":"billing","env":"prod"}}`,
+	} {
+		if status := do(t, "POST", server.URL+"/topics", body, nil); status != http.StatusCreated {
+			t.Fatalf("POST /topics %s = %d", body, status)
+		}
+	}
+	if status := do(t, "POST", server.URL+"/topics", `{"name":"bad","labels":{"a=b":"c"}}`, nil); status != http.StatusBadRequest {
+		t.Errorf("creating with an invalid label = %d", status)
+	}
+
+	var detail pubsub.TopicDetailResponse
+	do(t, "GET", server.URL+"/topics/orders", "", &detail)
+	if detail.Description != "Order events" || detail.Labels["team"] != "shop" || detail.Labels["env"] != "prod" {
+		t.Errorf("detail = %+v", detail)
+	}
+
+	list := func(query string) string {
+		t.Helper()
+		var resp pubsub.TopicsResponse
+		if status := do(t, "GET", server.URL+"/topics"+query, "", &resp); status != http.StatusOK {
+			t.Fatalf("GET /topics%s = %d", query, status)
+		}
+		var names []string
+		for _, topic := range resp.Topics {
+			names = append(names, topic.Name)
+		}
+		return strings.Join(names, ",")
+	}
+	for query, want := range map[string]string{
+		"?label=team=shop":                "carts,orders",
+		"?label=team=shop&label=env=prod": "orders",
+		"?label=env=prod&sort=name":       "invoices,orders",
+		"?label=team=ops":                 "",
+	} {
+		if got := list(query); got != want {
+			t.Errorf("%s lists %q, want %q", query, got, want)
+		}
+	}
+
+	// PATCH changes the description and replaces the labels
+	if status := do(t, "PATCH", server.URL+"/topics/carts", `{"description":"Abandoned carts","labels":{"team":"billing"}}`, nil); status != http.StatusOK {
+		t.Fatalf("PATCH /topics/carts = %d", status)
+	}
+	if got := list("?label=team=billing"); got != "carts,invoices" {
+		t.Errorf("billing topics after the patch = %q", got)
+	}
+	var topics pubsub.TopicsResponse
+	do(t, "GET", server.URL+"/topics?prefix=carts", "", &topics)
+	if len(topics.Topics) != 1 || topics.Topics[0].Description != "Abandoned carts" || len(topics.Topics[0].Labels) != 1 {
+		t.Errorf("patched listing = %+v", topics.Topics)
+	}
+	for path, body := range map[string]string{
+		"/topics/carts":   `{"labels":{"":"x"}}`,
+		"/topics/missing": `{"description":"x"}`,
+	} {
+		if status := do(t, "PATCH", server.URL+path, body, nil); status == http.StatusOK {
+			t.Errorf("PATCH %s %s = %d", path, body, status)
+		}
+	}
+}
+
+func TestTopicSigningOverREST(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.SetSigningKeys(map[string][]byte{"v1": []byte("0123456789abcdef-v1"), "v2": []byte("0123456789abcdef-v2")}); err != nil {
+		t.Fatal(err)
+	}
+	server := apiServer(t, ps)
+	if status := do(t, "POST", server.URL+"/topics", `{"name":"orders","signing_key_id":"v1"}`, nil); status != http.StatusCreated {
+		t.Fatalf("POST /topics = %d", status)
+	}
+	if status := do(t, "POST", server.URL+"/topics", `{"name":"audit","signing_key_id":"v9"}`, nil); status != http.StatusBadRequest {
+		t.Errorf("creating with an unknown key = %d", status)
+	}
+
+	// The detail says which key signs, never the key itself
+	detail := func() (pubsub.TopicDetailResponse, string) {
+		t.Helper()
+		var raw json.RawMessage
+		do(t, "GET", server.URL+"/topics/orders", "", &raw)
+		var detail pubsub.TopicDetailResponse
+		json.Unmarshal(raw, &detail)
+		return detail, string(raw)
+	}
+	got, raw := detail()
+	if !got.Signed || got.SigningKeyID != "v1" || strings.Contains(raw, "0123456789abcdef") {
+		t.Errorf("detail = %s", raw)
+	}
+
+	for body, want := range map[string]int{
+		`{"signing_key_id":"v9"}`: http.StatusBadRequest,
+		`{"signing_key_id":"v2"}`: http.StatusOK,
+	} {
+		if status := do(t, "PATCH", server.URL+"/topics/orders", body, nil); status != want {
+			t.Errorf("PATCH %s = %d, want %d", body, status, want)
+		}
+	}
+	if got, _ := detail(); got.SigningKeyID != "v2" {
+		t.Errorf("after rotating, detail = %+v", got)
+	}
+	if status := do(t, "PATCH", server.URL+"/topics/orders", `{"signing_key_id":""}`, nil); status != http.StatusOK {
+		t.Fatalf("turning signing off = %d", status)
+	}
+	if got, _ := detail(); got.Signed || got.SigningKeyID != "" {
+		t.Errorf("after turning signing off, detail = %+v", got)
+	}
+	if status := do(t, "PATCH", server.URL+"/topics/missing", `{"signing_key_id":"v1"}`, nil); status != http.StatusNotFound {
+		t.Errorf("signing an unknown topic = %d", status)
+	}
+}
+
+func TestTopicLimitsOverREST(t *testing.T) {
+	ps := pubsub.New()
+	server := apiServer(t, ps)
+	if status := do(t, "POST", server.URL+"/topics", `{"name":"chat","max_payload_bytes":4096,"max_publish_rate":100000}`, nil); status != http.StatusCreated {
+		t.Fatalf("creating with limits = %d", status)
+	}
+	if status := do(t, "POST", server.URL+"/topics", `{"name":"bad","max_publish_rate":-1}`, nil); status != http.StatusBadRequest {
+		t.Errorf("creating with a negative rate = %d", status)
+	}
+
+	// Publish steadily while the limits are tightened
+	var limited, tooLarge atomic.Int64
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			err := ps.Publish(context.Background(), "chat", pubsub.MessageData{ID: "m", Payload: strings.Repeat("x", 1000)}, "")
+			var limitErr *pubsub.PayloadLimitError
+			switch {
+			case errors.Is(err, pubsub.ErrTopicRateLimited):
+				limited.Add(1)
+			case errors.As(err, &limitErr):
+				tooLarge.Add(1)
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+	waitFor := func(what string, counter *atomic.Int64) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); counter.Load() == 0; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("no publish was %s", what)
+			}
+		}
+	}
+
+	var detail pubsub.TopicDetailResponse
+	if status := do(t, "PATCH", server.URL+"/topics/chat", `{"max_publish_rate":5}`, &detail); status != http.StatusOK || detail.MaxPublishRate != 5 || detail.MaxPayloadBytes != 4096 {
+		t.Fatalf("tightening the rate = %d, %+v", status, detail)
+	}
+	waitFor("rate limited", &limited)
+	if status := do(t, "PATCH", server.URL+"/topics/chat", `{"max_payload_bytes":500}`, &detail); status != http.StatusOK || detail.MaxPayloadBytes != 500 {
+		t.Fatalf("tightening the payload size = %d, %+v", status, detail)
+	}
+	waitFor("too large", &tooLarge)
+	if status := do(t, "PATCH", server.URL+"/topics/chat", `{"max_payload_bytes":-5}`, nil); status != http.StatusBadRequest {
+		t.Errorf("a negative payload limit = %d", status)
+	}
+}
+
+func TestMessageReceiptsOverREST(t *testing.T) {
+	ps := pubsub.New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "announcements"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b"} {
+		if _, err := ps.SubscribeWithOptions(ctx, id, "announcements", pubsub.SubscribeOptions{AckMode: pubsub.AckModeExplicit}, testClient{id: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ps.PublishWithResult(ctx, "announcements", pubsub.MessageData{ID: "m1"}, "", pubsub.PublishOptions{Receipts: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.AckMessages(ctx, "b", "announcements", 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	server := apiServer(t, ps)
+
+	var receipts pubsub.MessageReceiptsResponse
+	if status := do(t, "GET", server.URL+"/topics/announcements/messages/m1/receipts", "", &receipts); status != http.StatusOK {
+		t.Fatalf("GET receipts = %d", status)
+	}
+	if receipts.Expected != 2 || len(receipts.Acked) != 1 || receipts.Acked[0].ClientID != "b" || fmt.Sprint(receipts.Pending) != "[a]" {
+		t.Errorf("receipts = %+v", receipts)
+	}
+	if status := do(t, "GET", server.URL+"/topics/announcements/messages/m2/receipts", "", nil); status != http.StatusNotFound {
+		t.Errorf("receipts of an untracked message = %d", status)
+	}
+	if status := do(t, "GET", server.URL+"/topics/missing/messages/m1/receipts", "", nil); status != http.StatusNotFound {
+		t.Errorf("receipts on an unknown topic = %d", status)
+	}
+}
+
+func TestPrivateTopicReadsOverREST(t *testing.T) {
+	ps := pubsub.New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "board"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.PublishWithResult(ctx, "board", pubsub.MessageData{ID: "m1"}, "", pubsub.PublishOptions{Receipts: true}); err != nil {
+		t.Fatal(err)
+	}
+	server := apiServer(t, ps)
+	paths := []string{"/topics/board/messages", "/topics/board/messages/m1/receipts"}
+	for _, path := range paths {
+		if status := do(t, "GET", server.URL+path, "", nil); status != http.StatusOK && status != http.StatusNotFound {
+			t.Errorf("GET %s on a public topic = %d", path, status)
+		}
+	}
+
+	// Anonymous readers aren't members of a private topic
+	if err := ps.SetTopicVisibility(ctx, "board", pubsub.TopicVisibilityPrivate); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		var body errorBody
+		if status := do(t, "GET", server.URL+path, "", &body); status != http.StatusForbidden || body.Code != pubsub.CodePermissionDenied || body.Reason != "JOIN_REQUIRED" {
+			t.Errorf("GET %s on a private topic = %d %+v", path, status, body)
+		}
+	}
+}
+
+func TestTopicSlowConsumerOverREST(t *testing.T) {
+	ps := pubsub.New()
+	server := apiServer(t, ps)
+	if status := do(t, "POST", server.URL+"/topics", `{"name":"ticks","slow_consumer":{"max_consecutive_drops":20}}`, nil); status != http.StatusCreated {
+		t.Fatalf("creating with a policy = %d", status)
+	}
+	if status := do(t, "POST", server.URL+"/topics", `{"name":"bad","slow_consumer":{"max_drop_ratio":2}}`, nil); status != http.StatusBadRequest {
+		t.Errorf("creating with a ratio over 1 = %d", status)
+	}
+
+	var detail pubsub.TopicDetailResponse
+	status := do(t, "PATCH", server.URL+"/topics/ticks", `{"slow_consumer":{"max_drop_ratio":0.2,"grace_seconds":5}}`, &detail)
+	want := pubsub.SlowConsumerSettings{MaxDropRatio: 0.2, GraceSeconds: 5}
+	if status != http.StatusOK || detail.SlowConsumer == nil || *detail.SlowConsumer != want {
+		t.Fatalf("changing the policy = %d, %+v", status, detail.SlowConsumer)
+	}
+	detail = pubsub.TopicDetailResponse{}
+	if status := do(t, "PATCH", server.URL+"/topics/ticks", `{"slow_consumer":null}`, &detail); status != http.StatusOK || detail.SlowConsumer != nil {
+		t.Errorf("restoring the server policy = %d, %+v", status, detail.SlowConsumer)
+	}
+	if status := do(t, "PATCH", server.URL+"/topics/ticks", `{"slow_consumer":{"grace_seconds":-1}}`, nil); status != http.StatusBadRequest {
+		t.Errorf("a negative grace = %d", status)
+	}
+}
+
+func TestErrorCodesOverREST(t *testing.T) {
+	ps := pubsub.New()
+	server := apiServer(t, ps)
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		method, path, body string
+		status             int
+		code               string
+	}{
+		{"GET", "/topics/missing", "", http.StatusNotFound, pubsub.CodeTopicNotFound},
+		{"PATCH", "/topics/missing", `{"retention_seconds":60}`, http.StatusNotFound, pubsub.CodeTopicNotFound},
+		{"DELETE", "/topics/missing", "", http.StatusNotFound, pubsub.CodeTopicNotFound},
+		{"PATCH", "/topics/orders", `{"retention_seconds":-1}`, http.StatusBadRequest, pubsub.CodeValidationFailed},
+		{"POST", "/topics", `{"name":"$orders"}`, http.StatusForbidden, pubsub.CodePermissionDenied},
+		{"POST", "/topics", `not json`, http.StatusBadRequest, pubsub.CodeValidationFailed},
+		{"GET", "/topics/orders/messages?limit=0", "", http.StatusBadRequest, pubsub.CodeValidationFailed},
+		{"GET", "/topics/orders/messages?after_seq=1&before_seq=5", "", http.StatusBadRequest, pubsub.CodeValidationFailed},
+		{"DELETE", "/topics/orders/messages?before_seq=x", "", http.StatusBadRequest, pubsub.CodeValidationFailed},
+		{"DELETE", "/topics/orders/messages/m1", "", http.StatusNotFound, pubsub.CodeNotFound},
+		{"DELETE", "/topics/orders/webhooks/wh-1", "", http.StatusNotFound, pubsub.CodeNotFound},
+		{"GET", "/clients/nobody", "", http.StatusNotFound, pubsub.CodeNotFound},
+		{"GET", "/poll", "", http.StatusBadRequest, pubsub.CodeValidationFailed},
+	}
+	for _, tc := range cases {
+		var body errorBody
+		if status := do(t, tc.method, server.URL+tc.path, tc.body, &body); status != tc.status || body.Code != tc.code || body.Retryable || body.Error == "" {
+			t.Errorf("%s %s = %d %+v, want %d %s", tc.method, tc.path, status, body, tc.status, tc.code)
+		}
+	}
+
+	// A busy server says to come back later
+	ps.Drain()
+	var body errorBody
+	status := do(t, "POST", server.URL+"/subscriptions", `{"client_id":"poller","topics":["orders"]}`, &body)
+	if status != http.StatusServiceUnavailable || body.Code != pubsub.CodeServerBusy || body.Reason != "SERVER_DRAINING" || !body.Retryable {
+		t.Errorf("subscribing while draining = %d %+v", status, body)
+	}
+}
+
+func TestTopicSubscriberWatermarksOverREST(t *testing.T) {
+	ps := pubsub.New()
+	server := apiServer(t, ps)
+	if status := do(t, "POST", server.URL+"/topics", `{"name":"lobby","subscriber_watermarks":{"high":100,"low":1}}`, nil); status != http.StatusCreated {
+		t.Fatalf("creating with watermarks = %d", status)
+	}
+	if status := do(t, "POST", server.URL+"/topics", `{"name":"bad","subscriber_watermarks":{"high":1,"low":5}}`, nil); status != http.StatusBadRequest {
+		t.Errorf("creating with low above high = %d", status)
+	}
+
+	var detail pubsub.TopicDetailResponse
+	status := do(t, "PATCH", server.URL+"/topics/lobby", `{"subscriber_watermarks":{"high":50,"hysteresis":5}}`, &detail)
+	want := pubsub.SubscriberWatermarks{High: 50, Hysteresis: 5}
+	if status != http.StatusOK || detail.Watermarks == nil || *detail.Watermarks != want {
+		t.Fatalf("changing the watermarks = %d, %+v", status, detail.Watermarks)
+	}
+	detail = pubsub.TopicDetailResponse{}
+	if status := do(t, "PATCH", server.URL+"/topics/lobby", `{"subscriber_watermarks":null}`, &detail); status != http.StatusOK || detail.Watermarks != nil {
+		t.Errorf("restoring the server watermarks = %d, %+v", status, detail.Watermarks)
+	}
+	if status := do(t, "PATCH", server.URL+"/topics/lobby", `{"subscriber_watermarks":{"low":-1}}`, nil); status != http.StatusBadRequest {
+		t.Errorf("a negative watermark = %d", status)
+	}
+}
+
+// exportTopic downloads a topic's history export
+func exportTopic(t *testing.T, url string) []pubsub.EventResponse {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("export = %d, %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	var events []pubsub.EventResponse
+	decoder := json.NewDecoder(resp.Body)
+	for decoder.More() {
+		var event pubsub.EventResponse
+		if err := decoder.Decode(&event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestExportAndImportOverREST(t *testing.T) {
+	ps := pubsub.New()
+	server := apiServer(t, ps)
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	publishN(t, ps, "orders", 250)
+	before := exportTopic(t, server.URL+"/topics/orders/export")
+	if len(before) != 250 || before[0].Seq != 1 || before[0].Topic != "orders" {
+		t.Fatalf("exported %d events, first %+v", len(before), before[0])
+	}
+
+	// Export, wipe, import and compare
+	var body strings.Builder
+	for _, event := range before {
+		line, _ := json.Marshal(event)
+		body.Write(line)
+		body.WriteByte('\n')
+	}
+	if status := do(t, "DELETE", server.URL+"/topics/orders/messages", "", nil); status != http.StatusOK {
+		t.Fatalf("purge = %d", status)
+	}
+	if events := exportTopic(t, server.URL+"/topics/orders/export"); len(events) != 0 {
+		t.Fatalf("%d events left after purge", len(events))
+	}
+	var imported pubsub.ImportResponse
+	if status := do(t, "POST", server.URL+"/topics/orders/import", body.String(), &imported); status != http.StatusOK || imported.Accepted != 250 || imported.Rejected != 0 {
+		t.Fatalf("import = %d, %+v", status, imported)
+	}
+	if imported.FirstSeq != 251 || imported.LastSeq != 500 {
+		t.Errorf("imported as seq %d to %d", imported.FirstSeq, imported.LastSeq)
+	}
+	after := exportTopic(t, server.URL+"/topics/orders/export")
+	if len(after) != len(before) {
+		t.Fatalf("%d events after import, want %d", len(after), len(before))
+	}
+	for i := range after {
+		if after[i].Message.ID != before[i].Message.ID || after[i].Message.Payload != before[i].Message.Payload || after[i].Seq != before[i].Seq+250 {
+			t.Fatalf("event %d imported as %+v, was %+v", i, after[i], before[i])
+		}
+	}
+
+	// Bad lines are counted and listed
+	bad := "{\"message\":{\"id\":\"a\",\"payload\":1}}\nnot json\n\n{\"message\":{\"payload\":2}}\n"
+	imported = pubsub.ImportResponse{}
+	if status := do(t, "POST", server.URL+"/topics/orders/import", bad, &imported); status != http.StatusOK || imported.Accepted != 1 || imported.Rejected != 2 {
+		t.Fatalf("import with bad lines = %d, %+v", status, imported)
+	}
+	if len(imported.Errors) != 2 || imported.Errors[0].Line != 2 || imported.Errors[1].Line != 4 {
+		t.Errorf("errors = %+v", imported.Errors)
+	}
+
+	if status := do(t, "POST", server.URL+"/topics/orders/import?deliver=maybe", bad, nil); status != http.StatusBadRequest {
+		t.Errorf("a bad deliver flag = %d", status)
+	}
+	if status := do(t, "GET", server.URL+"/topics/missing/export", "", nil); status != http.StatusNotFound {
+		t.Errorf("exporting a missing topic = %d", status)
+	}
+	if status := do(t, "POST", server.URL+"/topics/missing/import", bad, nil); status != http.StatusNotFound {
+		t.Errorf("importing into a missing topic = %d", status)
+	}
+}
+
+func TestCounterSnapshotAndReset(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.Subscribe(context.Background(), "c1", "orders", 0, testClient{id: "c1"}); err != nil {
+		t.Fatal(err)
+	}
+	server := apiServer(t, ps)
+	publishN(t, ps, "orders", 5)
+
+	var before pubsub.CounterSnapshot
+	if status := do(t, "GET", server.URL+"/admin/stats/snapshot", "", &before); status != http.StatusOK {
+		t.Fatalf("snapshot status = %d", status)
+	}
+	if orders := before.Topics["orders"]; orders.Messages != 5 || orders.PublishedLastMinute != 5 || before.Timestamp.IsZero() {
+		t.Fatalf("snapshot = %+v", before)
+	}
+
+	var reset pubsub.CounterSnapshot
+	if status := do(t, "POST", server.URL+"/admin/stats/reset", "", &reset); status != http.StatusOK {
+		t.Fatalf("reset status = %d", status)
+	}
+	if reset.Topics["orders"] != before.Topics["orders"] || !reset.Since.Equal(before.Since) {
+		t.Errorf("reset returned %+v, want the counters before it %+v", reset, before)
+	}
+
+	publishN(t, ps, "orders", 2)
+	var after pubsub.CounterSnapshot
+	do(t, "GET", server.URL+"/admin/stats/snapshot", "", &after)
+	orders := after.Topics["orders"]
+	if orders.Messages != 2 || orders.PublishedLastMinute != 2 || orders.Subscribers != 1 {
+		t.Errorf("counters after the reset = %+v", orders)
+	}
+	if !after.Since.Equal(reset.Timestamp) {
+		t.Errorf("since = %v, want the reset at %v", after.Since, reset.Timestamp)
+	}
+}
@@ -0,0 +1,302 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+const (
+	// DefaultMaxRequestBodyBytes caps REST request bodies
+	DefaultMaxRequestBodyBytes = 1 << 20
+
+	// How often idle rate limit buckets are dropped
+	rateBucketSweepInterval = time.Minute
+)
+
+// RequestLimits configures the REST API's request limits
+type RequestLimits struct {
+	MaxBodyBytes int64   // Largest request body; 0 disables the limit
+	Rate         float64 // Mutating requests per second per client IP; 0 disables rate limiting
+	Burst        int     // Requests a client IP may make at once (default: Rate rounded up)
+
+	// Proxies whose X-Forwarded-For header is trusted to name the client.
+	// Requests from other addresses are limited by their own address.
+	TrustedProxies []*net.IPNet
+}
+
+// ParseTrustedProxies parses a comma-separated list of IP addresses and
+// CIDR ranges
+func ParseTrustedProxies(value string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy range %q: %w", entry, err)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// RequestLimiter enforces RequestLimits: a body size cap on every request
+// but websocket upgrades, and a per-client-IP token bucket on mutating
+// requests
+type RequestLimiter struct {
+	ps     *pubsub.PubSubSystem
+	limits RequestLimits
+
+	buckets   map[string]*rateBucket
+	lastSweep time.Time
+	mutex     sync.Mutex
+}
+
+// rateBucket is one client IP's token bucket
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRequestLimiter creates a limiter counting rejections in ps's stats
+func NewRequestLimiter(ps *pubsub.PubSubSystem, limits RequestLimits) *RequestLimiter {
+	if limits.Rate > 0 && limits.Burst <= 0 {
+		limits.Burst = int(math.Max(1, math.Ceil(limits.Rate)))
+	}
+	return &RequestLimiter{
+		ps:        ps,
+		limits:    limits,
+		buckets:   make(map[string]*rateBucket),
+		lastSweep: ps.Clock().Now(),
+	}
+}
+
+// Middleware rejects rate-limited requests with 429 and oversized bodies
+// with 413. Websocket upgrades and topic imports are exempt from the body
+// limit.
+func (rl *RequestLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rl.limits.Rate > 0 && mutating(r.Method) {
+			if wait, ok := rl.allow(rl.ClientIP(r)); !ok {
+				rl.ps.HTTPTraffic().RateLimited.Add(1)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				writeLimitError(w, pubsub.CodeRateLimited, "rate limit exceeded, retry later")
+				return
+			}
+		}
+
+		if rl.limits.MaxBodyBytes <= 0 || websocket.IsWebSocketUpgrade(r) || streamsBody(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength > rl.limits.MaxBodyBytes {
+			rl.rejectBody(w)
+			return
+		}
+
+		// A body without a length is only found to be too large while the
+		// handler reads it; the handler's response is replaced then
+		body := &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, rl.limits.MaxBodyBytes)}
+		r.Body = body
+		next.ServeHTTP(&limitedWriter{ResponseWriter: w, body: body, reject: rl.rejectBody}, r)
+	})
+}
+
+// ClientIP returns the address requests are rate limited by: the remote
+// address, or for a trusted proxy the nearest untrusted address in
+// X-Forwarded-For
+func (rl *RequestLimiter) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !rl.trusted(host) {
+		return host
+	}
+
+	// Proxies append the address they received from, so walk back from
+	// the nearest hop until one isn't a trusted proxy
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i]) == nil {
+			break
+		}
+		host = hops[i]
+		if !rl.trusted(host) {
+			break
+		}
+	}
+	return host
+}
+
+// trusted reports whether addr is a trusted proxy
+func (rl *RequestLimiter) trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range rl.limits.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allow takes a token from ip's bucket, or reports how long until one is
+// available
+func (rl *RequestLimiter) allow(ip string) (time.Duration, bool) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := rl.ps.Clock().Now()
+	burst := float64(rl.limits.Burst)
+	if now.Sub(rl.lastSweep) >= rateBucketSweepInterval {
+		// Drop buckets that have refilled; they behave like new ones
+		for key, bucket := range rl.buckets {
+			if bucket.tokens+now.Sub(bucket.last).Seconds()*rl.limits.Rate >= burst {
+				delete(rl.buckets, key)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	bucket, exists := rl.buckets[ip]
+	if !exists {
+		bucket = &rateBucket{tokens: burst, last: now}
+		rl.buckets[ip] = bucket
+	}
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*rl.limits.Rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return time.Duration((1 - bucket.tokens) / rl.limits.Rate * float64(time.Second)), false
+	}
+	bucket.tokens--
+	return 0, true
+}
+
+// rejectBody answers a request whose body is over the limit
+func (rl *RequestLimiter) rejectBody(w http.ResponseWriter) {
+	rl.ps.HTTPTraffic().BodyTooLarge.Add(1)
+	writeLimitError(w, pubsub.CodePayloadTooLarge,
+		fmt.Sprintf("request body exceeds %d bytes", rl.limits.MaxBodyBytes))
+}
+
+// streamsBody reports whether r is a topic import, whose body is read a
+// line at a time with each line held to the payload limit, so it is as
+// long as the history being imported
+func streamsBody(r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if strings.HasPrefix(path, "namespaces/") {
+		parts := strings.SplitN(path, "/", 3)
+		if len(parts) < 3 {
+			return false
+		}
+		path = parts[2]
+	}
+	parts := strings.Split(path, "/")
+	return len(parts) == 3 && parts[0] == "topics" && parts[1] != "" && parts[2] == "import"
+}
+
+// mutating reports whether a method changes server state
+func mutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// writeLimitError writes a JSON error for a refused request
+func writeLimitError(w http.ResponseWriter, code, message string) {
+	writeError(w, pubsub.ErrorData{Code: code, Message: message})
+}
+
+// limitedBody notes when a read hits the body size limit
+type limitedBody struct {
+	io.ReadCloser
+	exceeded bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		b.exceeded = true
+	}
+	return n, err
+}
+
+// limitedWriter replaces the handler's response with a 413 once its
+// request body has hit the limit
+type limitedWriter struct {
+	http.ResponseWriter
+	body     *limitedBody
+	reject   func(http.ResponseWriter)
+	rejected bool
+}
+
+func (w *limitedWriter) WriteHeader(code int) {
+	if w.rejected {
+		return
+	}
+	if w.body.exceeded {
+		w.rejected = true
+		w.reject(w.ResponseWriter)
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if !w.rejected && w.body.exceeded {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	}
+	if w.rejected {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush supports handlers that stream their response
+func (w *limitedWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok && !w.rejected {
+		flusher.Flush()
+	}
+}
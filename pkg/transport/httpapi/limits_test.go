@@ -0,0 +1,308 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/clock/clocktest"
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+	"github.com/AnshulDekate/pubsub/pkg/transport/ws"
+)
+
+// limitedRequest serves one request through limiter in front of the REST
+// API
+func limitedRequest(limiter *RequestLimiter, handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	limiter.Middleware(handler).ServeHTTP(rec, req)
+	return rec
+}
+
+// createTopic returns a POST /topics request from remoteAddr
+func createTopic(name, remoteAddr string) *http.Request {
+	req := httptest.NewRequest("POST", "/topics", strings.NewReader(fmt.Sprintf(`{"name":%q}`, name)))
+	req.RemoteAddr = remoteAddr
+	return req
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	ps := pubsub.New()
+	handler := Handler(ps)
+	limiter := NewRequestLimiter(ps, RequestLimits{MaxBodyBytes: 64})
+	padded := fmt.Sprintf(`{"name":"orders","pad":%q}`, strings.Repeat("x", 100))
+
+	// Refused up front by its Content-Length, and while read when sent
+	// without one
+	sized := httptest.NewRequest("POST", "/topics", strings.NewReader(padded))
+	chunked := httptest.NewRequest("POST", "/topics", io.MultiReader(strings.NewReader(padded)))
+	chunked.ContentLength = -1
+	for name, req := range map[string]*http.Request{"sized": sized, "chunked": chunked} {
+		rec := limitedRequest(limiter, handler, req)
+		var resp errorBody
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s body: %q: %v", name, rec.Body, err)
+		}
+		if rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(resp.Error, "64 bytes") || resp.Code != pubsub.CodePayloadTooLarge {
+			t.Errorf("%s body = %d %+v", name, rec.Code, resp)
+		}
+	}
+	if _, err := ps.GetTopicDetail("orders"); err == nil {
+		t.Error("topic created from an oversized body")
+	}
+
+	// Bodies within the limit are served, and with the limit disabled so
+	// is the padded one
+	if rec := limitedRequest(limiter, handler, createTopic("orders", "192.0.2.1:1234")); rec.Code != http.StatusCreated {
+		t.Errorf("small body = %d %s", rec.Code, rec.Body)
+	}
+	unlimited := NewRequestLimiter(ps, RequestLimits{})
+	padded = strings.Replace(padded, "orders", "payments", 1)
+	if rec := limitedRequest(unlimited, handler, httptest.NewRequest("POST", "/topics", strings.NewReader(padded))); rec.Code != http.StatusCreated {
+		t.Errorf("padded body without a limit = %d %s", rec.Code, rec.Body)
+	}
+	if stats := ps.GetStats(); stats.HTTP.BodyTooLarge != 2 || stats.HTTP.RateLimited != 0 {
+		t.Errorf("http stats = %+v", stats.HTTP)
+	}
+}
+
+func TestRequestBodyLimitExemptsWebSockets(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	limiter := NewRequestLimiter(ps, RequestLimits{MaxBodyBytes: 1})
+	server := httptest.NewServer(limiter.Middleware(Handler(ps)))
+	t.Cleanup(server.Close)
+
+	c := connectTenant(t, server, "/ws", "dashboard")
+	if frame := c.subscribe("orders"); frame["type"] != "ack" {
+		t.Errorf("subscribing through a limited server = %v", frame)
+	}
+}
+
+func TestRequestBodyLimitExemptsImports(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	handler := Handler(ps)
+	limiter := NewRequestLimiter(ps, RequestLimits{MaxBodyBytes: 1024})
+	var body strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&body, `{"message":{"id":"m-%d","payload":%q}}`+"\n", i, strings.Repeat("x", 32))
+	}
+
+	// A history many times the limit imports whole, with a length or
+	// without one
+	sized := httptest.NewRequest("POST", "/topics/orders/import", strings.NewReader(body.String()))
+	chunked := httptest.NewRequest("POST", "/topics/orders/import", io.MultiReader(strings.NewReader(body.String())))
+	chunked.ContentLength = -1
+	for name, req := range map[string]*http.Request{"sized": sized, "chunked": chunked} {
+		rec := limitedRequest(limiter, handler, req)
+		var imported pubsub.ImportResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &imported); err != nil || rec.Code != http.StatusOK || imported.Accepted != 100 {
+			t.Errorf("%s import = %d %s", name, rec.Code, rec.Body)
+		}
+	}
+
+	// Only imports are exempt
+	for _, path := range []string{"/topics", "/topics/import", "/topics/orders/import/x", "/namespaces/acme/topics/orders/export"} {
+		if streamsBody(httptest.NewRequest("POST", path, nil)) {
+			t.Errorf("POST %s exempt from the body limit", path)
+		}
+	}
+	if !streamsBody(httptest.NewRequest("POST", "/namespaces/acme/topics/orders/import", nil)) {
+		t.Error("namespaced import held to the body limit")
+	}
+}
+
+func TestRequestRateLimit(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	ps := pubsub.NewWithClock(clock)
+	handler := Handler(ps)
+	limiter := NewRequestLimiter(ps, RequestLimits{Rate: 0.5, Burst: 2})
+
+	for i := 0; i < 2; i++ {
+		if rec := limitedRequest(limiter, handler, createTopic(fmt.Sprint("burst-", i), "192.0.2.1:1234")); rec.Code != http.StatusCreated {
+			t.Fatalf("request %d of the burst = %d", i, rec.Code)
+		}
+	}
+	rec := limitedRequest(limiter, handler, createTopic("over", "192.0.2.1:1235"))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("over the limit = %d %s, Retry-After %q", rec.Code, rec.Body, rec.Header().Get("Retry-After"))
+	}
+
+	// Reads aren't limited, and other addresses have their own bucket
+	read := httptest.NewRequest("GET", "/topics", nil)
+	read.RemoteAddr = "192.0.2.1:1234"
+	if rec := limitedRequest(limiter, handler, read); rec.Code != http.StatusOK {
+		t.Errorf("GET while limited = %d", rec.Code)
+	}
+	if rec := limitedRequest(limiter, handler, createTopic("other", "192.0.2.2:1234")); rec.Code != http.StatusCreated {
+		t.Errorf("another address = %d", rec.Code)
+	}
+
+	// The bucket refills at the configured rate
+	clock.Advance(2 * time.Second)
+	if rec := limitedRequest(limiter, handler, createTopic("refilled", "192.0.2.1:1234")); rec.Code != http.StatusCreated {
+		t.Errorf("after refilling = %d", rec.Code)
+	}
+	if rec := limitedRequest(limiter, handler, createTopic("again", "192.0.2.1:1234")); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("after spending the refill = %d", rec.Code)
+	}
+	if stats := ps.GetStats(); stats.HTTP.RateLimited != 2 || stats.HTTP.BodyTooLarge != 0 {
+		t.Errorf("http stats = %+v", stats.HTTP)
+	}
+}
+
+func TestRateLimitClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies(" 10.0.0.0/8, 192.0.2.7 ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := NewRequestLimiter(pubsub.New(), RequestLimits{TrustedProxies: proxies})
+	for _, tc := range []struct {
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"203.0.113.5:1234", nil, "203.0.113.5"},
+		// Only trusted proxies may name the client
+		{"203.0.113.5:1234", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"192.0.2.7:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		// Trusted hops are skipped, nearest first, across headers
+		{"10.0.0.1:1234", []string{"198.51.100.1, 10.1.1.1", "10.2.2.2"}, "198.51.100.1"},
+		// A spoofed hop beyond the first untrusted one is ignored
+		{"10.0.0.1:1234", []string{"6.6.6.6, 198.51.100.1"}, "198.51.100.1"},
+		// Garbage stops the walk at the last trusted hop
+		{"10.0.0.1:1234", []string{"198.51.100.1, unknown, 10.1.1.1"}, "10.1.1.1"},
+		{"10.0.0.1:1234", nil, "10.0.0.1"},
+	} {
+		req := httptest.NewRequest("POST", "/topics", nil)
+		req.RemoteAddr = tc.remote
+		for _, value := range tc.forwarded {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+		if got := limiter.ClientIP(req); got != tc.want {
+			t.Errorf("ClientIP from %s via %q = %s, want %s", tc.remote, tc.forwarded, got, tc.want)
+		}
+	}
+
+	for _, value := range []string{"10.0.0.0/33", "proxy.internal"} {
+		if _, err := ParseTrustedProxies(value); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) succeeded", value)
+		}
+	}
+}
+
+func TestRateLimitBehindProxy(t *testing.T) {
+	ps := pubsub.New()
+	handler := Handler(ps)
+	proxies, _ := ParseTrustedProxies("10.0.0.1")
+	limiter := NewRequestLimiter(ps, RequestLimits{Rate: 1, TrustedProxies: proxies})
+
+	// Clients behind the proxy are limited separately
+	for i, client := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.1"} {
+		req := createTopic(fmt.Sprint("topic-", i), "10.0.0.1:4000")
+		req.Header.Set("X-Forwarded-For", client)
+		want := http.StatusCreated
+		if i == 2 {
+			want = http.StatusTooManyRequests
+		}
+		if rec := limitedRequest(limiter, handler, req); rec.Code != want {
+			t.Errorf("request %d from %s = %d, want %d", i, client, rec.Code, want)
+		}
+	}
+}
+
+func TestConnectionCapPerIP(t *testing.T) {
+	ps := pubsub.New()
+	ps.SetMaxConnectionsPerIP(3)
+	proxies, _ := ParseTrustedProxies("127.0.0.1")
+	limiter := NewRequestLimiter(ps, RequestLimits{TrustedProxies: proxies})
+	wsHandler, err := ws.NewHandler(ps, ws.WebSocketOptions{ClientIP: limiter.ClientIP})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers := NewHTTPHandlers(ps)
+	handlers.SetWebSocketHandler(wsHandler)
+	router := mux.NewRouter()
+	handlers.SetupRoutes(router)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	// dialFrom connects as a client behind the trusted proxy
+	dialFrom := func(ip string) (*websocket.Conn, int, pubsub.ErrorData) {
+		conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", http.Header{"X-Forwarded-For": {ip}})
+		if err == nil {
+			t.Cleanup(func() { conn.Close() })
+			return conn, http.StatusSwitchingProtocols, pubsub.ErrorData{}
+		}
+		if resp == nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var refused pubsub.ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&refused)
+		return nil, resp.StatusCode, refused.Error
+	}
+	byIP := func() map[string]int {
+		var connections pubsub.ConnectionsResponse
+		if status := do(t, "GET", server.URL+"/admin/connections", "", &connections); status != http.StatusOK {
+			t.Fatalf("GET /admin/connections = %d", status)
+		}
+		return connections.ByIP
+	}
+	waitForCount := func(ip string, want int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for byIP()[ip] != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s has %d connections, want %d", ip, byIP()[ip], want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	var conns []*websocket.Conn
+	for i := 0; i < 3; i++ {
+		conn, status, _ := dialFrom("198.51.100.1")
+		if status != http.StatusSwitchingProtocols {
+			t.Fatalf("connection %d refused with %d", i, status)
+		}
+		conns = append(conns, conn)
+	}
+	if _, status, refused := dialFrom("198.51.100.1"); status != http.StatusTooManyRequests || refused.Reason != "IP_CONNECTION_LIMIT" {
+		t.Errorf("connection over the cap = %d %+v, want 429 IP_CONNECTION_LIMIT", status, refused)
+	}
+	if _, status, _ := dialFrom("198.51.100.2"); status != http.StatusSwitchingProtocols {
+		t.Errorf("another client was refused with %d", status)
+	}
+	if counts := byIP(); counts["198.51.100.1"] != 3 || counts["198.51.100.2"] != 1 {
+		t.Errorf("connections by IP = %v", counts)
+	}
+
+	// Dropping the socket without a close frame frees the slot too
+	conns[0].UnderlyingConn().Close()
+	waitForCount("198.51.100.1", 2)
+	if _, status, _ := dialFrom("198.51.100.1"); status != http.StatusSwitchingProtocols {
+		t.Errorf("connection after a slot was freed refused with %d", status)
+	}
+
+	for _, conn := range conns[1:] {
+		conn.Close()
+	}
+	waitForCount("198.51.100.1", 1)
+	if n := ps.GetStats().WebSocket.IPLimitRejections; n != 1 {
+		t.Errorf("%d per-IP rejections counted, want 1", n)
+	}
+}
@@ -0,0 +1,188 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// tenant is a websocket connection bound to a namespace by its URL
+type tenant struct {
+	t    *testing.T
+	conn *websocket.Conn
+	id   string
+}
+
+func connectTenant(t *testing.T, server *httptest.Server, path, clientID string) *tenant {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+path+"?client_id="+clientID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &tenant{t: t, conn: conn, id: clientID}
+	c.next("welcome")
+	return c
+}
+
+// request sends req and returns the first ack or error frame
+func (c *tenant) request(req map[string]interface{}) map[string]interface{} {
+	c.t.Helper()
+	req["client_id"] = c.id
+	req["request_id"] = uuid.NewString()
+	if err := c.conn.WriteJSON(req); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.next("ack", "error")
+}
+
+// next returns the next frame of one of types, skipping the others
+func (c *tenant) next(types ...string) map[string]interface{} {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var frame map[string]interface{}
+		if err := c.conn.ReadJSON(&frame); err != nil {
+			c.t.Fatalf("%s waiting for %v: %v", c.id, types, err)
+		}
+		for _, kind := range types {
+			if frame["type"] == kind {
+				return frame
+			}
+		}
+	}
+}
+
+func (c *tenant) subscribe(topic string) map[string]interface{} {
+	c.t.Helper()
+	return c.request(map[string]interface{}{"type": "subscribe", "topic": topic})
+}
+
+func (c *tenant) publish(topic string, payload interface{}) map[string]interface{} {
+	c.t.Helper()
+	return c.request(map[string]interface{}{"type": "publish", "topic": topic, "message": map[string]interface{}{"id": uuid.NewString(), "payload": payload}})
+}
+
+// quiet fails if an event arrives within a short wait. The read deadline
+// breaks the connection, so it is the last read.
+func (c *tenant) quiet() {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	for {
+		var frame map[string]interface{}
+		if err := c.conn.ReadJSON(&frame); err != nil {
+			return
+		}
+		if frame["type"] == "event" {
+			c.t.Errorf("%s received %v", c.id, frame)
+		}
+	}
+}
+
+func TestNamespaceIsolation(t *testing.T) {
+	ps := pubsub.New()
+	server := apiServer(t, ps)
+	for _, path := range []string{"/namespaces/alpha/topics", "/namespaces/beta/topics", "/topics"} {
+		if status := do(t, "POST", server.URL+path, `{"name":"orders"}`, nil); status != http.StatusCreated {
+			t.Fatalf("POST %s = %d", path, status)
+		}
+	}
+	alpha := connectTenant(t, server, "/ws/alpha", "alpha-reader")
+	beta := connectTenant(t, server, "/ws/beta", "beta-reader")
+	plain := connectTenant(t, server, "/ws", "default-reader")
+	for _, c := range []*tenant{alpha, beta, plain} {
+		if frame := c.subscribe("orders"); frame["type"] != "ack" {
+			t.Fatalf("%s subscribing = %v", c.id, frame)
+		}
+	}
+
+	// Delivery: the same name reaches only the publisher's namespace
+	writer := connectTenant(t, server, "/ws/alpha", "alpha-writer")
+	if frame := writer.publish("orders", "for alpha"); frame["type"] != "ack" {
+		t.Fatalf("publishing in alpha = %v", frame)
+	}
+	event := alpha.next("event")
+	if message, _ := event["message"].(map[string]interface{}); event["topic"] != "orders" || message["payload"] != "for alpha" {
+		t.Errorf("alpha received %v", event)
+	}
+
+	// Other namespaces can't be addressed, by name or by hello
+	if frame := alpha.subscribe("beta::orders"); frame["type"] != "error" {
+		t.Errorf("subscribing across namespaces = %v", frame)
+	}
+	if frame := writer.publish("beta::orders", "sneaky"); frame["type"] != "error" {
+		t.Errorf("publishing across namespaces = %v", frame)
+	}
+	if frame := writer.request(map[string]interface{}{"type": "hello", "protocol_version": 1, "namespace": "beta"}); frame["type"] != "error" {
+		t.Errorf("switching namespace by hello = %v", frame)
+	}
+
+	// Stats and health
+	var stats pubsub.StatsResponse
+	do(t, "GET", server.URL+"/namespaces/alpha/stats", "", &stats)
+	if len(stats.Topics) != 1 || stats.Topics["orders"].Messages != 1 {
+		t.Errorf("alpha stats = %+v", stats.Topics)
+	}
+	do(t, "GET", server.URL+"/namespaces/beta/stats", "", &stats)
+	if stats.Topics["orders"].Messages != 0 {
+		t.Errorf("beta stats = %+v", stats.Topics)
+	}
+	var health pubsub.HealthResponse
+	do(t, "GET", server.URL+"/namespaces/beta/health", "", &health)
+	if health.Topics != 1 || health.Subscribers != 1 {
+		t.Errorf("beta health = %+v", health)
+	}
+	var topics pubsub.TopicsResponse
+	do(t, "GET", server.URL+"/topics", "", &topics)
+	if len(topics.Topics) != 1 || topics.Topics[0].Name != "orders" || topics.Topics[0].MessageCount != 0 {
+		t.Errorf("the default namespace lists %+v", topics.Topics)
+	}
+
+	// Deletion: alpha's topic goes, beta's carries on
+	if status := do(t, "DELETE", server.URL+"/namespaces/alpha/topics/orders", "", nil); status != http.StatusOK {
+		t.Fatalf("deleting alpha's orders = %d", status)
+	}
+	if info := alpha.next("info"); info["topic"] != "orders" {
+		t.Errorf("alpha was told %v", info)
+	}
+	if status := do(t, "GET", server.URL+"/namespaces/alpha/topics/orders", "", nil); status != http.StatusNotFound {
+		t.Errorf("alpha's orders after deletion = %d", status)
+	}
+	for _, path := range []string{"/namespaces/beta/topics/orders", "/topics/orders"} {
+		if status := do(t, "GET", server.URL+path, "", nil); status != http.StatusOK {
+			t.Errorf("GET %s after deleting alpha's = %d", path, status)
+		}
+	}
+	writer = connectTenant(t, server, "/ws/beta", "beta-writer")
+	if frame := writer.publish("orders", "for beta"); frame["type"] != "ack" {
+		t.Fatalf("publishing in beta = %v", frame)
+	}
+	// The first event beta sees is its own
+	event = beta.next("event")
+	if message, _ := event["message"].(map[string]interface{}); event["topic"] != "orders" || message["payload"] != "for beta" {
+		t.Errorf("beta received %v", event)
+	}
+	plain.quiet()
+}
+
+func TestInvalidNamespaceRefused(t *testing.T) {
+	server := apiServer(t, pubsub.New())
+	for _, path := range []string{"/namespaces/Bad%20Name/topics", "/namespaces/a::b/topics"} {
+		if status := do(t, "POST", server.URL+path, `{"name":"orders"}`, nil); status != http.StatusBadRequest {
+			t.Errorf("POST %s = %d", path, status)
+		}
+	}
+	if _, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/Bad", nil); err == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("dialing an invalid namespace = %v", err)
+	}
+	if status := do(t, "POST", server.URL+"/namespaces/alpha/topics", `{"name":"x::y"}`, nil); status != http.StatusBadRequest {
+		t.Errorf("creating a topic with the separator = %d", status)
+	}
+}
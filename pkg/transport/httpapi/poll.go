@@ -0,0 +1,439 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+const (
+	DefaultPollSubscriptionTTL = 5 * time.Minute  // Idle time before a poll subscription is reaped
+	DefaultPollWait            = 30 * time.Second // Long-poll duration when ?wait= is omitted
+	MaxPollWait                = 60 * time.Second // Upper bound on ?wait=
+
+	pollReapInterval = 10 * time.Second // How often idle poll subscriptions are looked for
+
+	// PollTokenHeader carries the token POST /subscriptions issued on the
+	// subscription's later requests
+	PollTokenHeader = "X-Poll-Token"
+)
+
+var (
+	errPollSubscriptionNotFound = errors.New("poll subscription not found")
+	errWrongPollToken           = errors.New("wrong poll token")
+	errClientIDInUse            = errors.New("client_id is held by another connection")
+)
+
+// PollSubscription is a server-side subscription drained over HTTP long
+// polling. Events are buffered in a per-client RingBuffer between polls.
+type PollSubscription struct {
+	ps       *pubsub.PubSubSystem
+	clientID string
+	token    string // Issued at creation; required to change, poll or delete the subscription
+	topics   map[string]bool
+	buffer   *pubsub.RingBuffer[pubsub.EventResponse]
+
+	// Signalled (non-blocking) whenever a new event is buffered
+	notify chan struct{}
+
+	mutex    sync.Mutex
+	lastSeen time.Time
+	cursor   int64                  // Delivery sequence of the newest event handed out
+	unacked  []pubsub.EventResponse // Events handed out but not yet acknowledged by a cursor
+	waiter   chan struct{}          // Closed to release the in-flight poll when a newer one arrives
+}
+
+func (sub *PollSubscription) GetClientID() string {
+	return sub.clientID
+}
+
+func (sub *PollSubscription) IsConnected() bool {
+	return true
+}
+
+func (sub *PollSubscription) SendMessage(msg interface{}) error {
+	var event pubsub.EventResponse
+	switch m := msg.(type) {
+	case *pubsub.PreparedEvent:
+		event = m.Event
+	case pubsub.EventResponse:
+		event = m
+	case pubsub.InfoResponse:
+		event = pubsub.EventResponse{
+			Type:      m.Type,
+			Topic:     m.Topic,
+			Message:   pubsub.MessageData{Payload: m.EventPayload()},
+			Timestamp: m.Timestamp,
+		}
+	default:
+		return pubsub.ErrorData{Code: pubsub.CodeInternal, Message: "Unknown message type to send"}
+	}
+
+	// Volatile events only wait for a poll when nothing else is buffered
+	if event.Volatile && sub.buffer.Len() > 0 {
+		return pubsub.ErrVolatileDropped
+	}
+
+	// The ring buffer drops the oldest event on overflow and refuses
+	// events once the subscription has been released
+	evicted, ok, err := sub.buffer.PushEvict(event)
+	if err != nil {
+		return err
+	}
+	if ok {
+		sub.ps.DeadLetter(evicted, sub.clientID, pubsub.DeadLetterBufferEvicted)
+	}
+	select {
+	case sub.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (sub *PollSubscription) GetLastActive() time.Time {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	return sub.lastSeen
+}
+
+// PollManager owns the long-polling subscriptions and reaps idle ones
+type PollManager struct {
+	ps *pubsub.PubSubSystem
+
+	subscriptions map[string]*PollSubscription // clientID -> subscription
+	ttl           time.Duration
+	mutex         sync.Mutex
+}
+
+// NewPollManager creates a poll manager and starts its reaper
+func NewPollManager(ps *pubsub.PubSubSystem, ttl time.Duration) *PollManager {
+	if ttl <= 0 {
+		ttl = DefaultPollSubscriptionTTL
+	}
+	pm := &PollManager{
+		ps:            ps,
+		subscriptions: make(map[string]*PollSubscription),
+		ttl:           ttl,
+	}
+	go pm.reapLoop()
+	return pm
+}
+
+// SetTTL configures how long a poll subscription may go without polling
+func (pm *PollManager) SetTTL(ttl time.Duration) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	pm.ttl = ttl
+}
+
+// Token returns the secret the subscription was issued, which every later
+// request for it must present
+func (sub *PollSubscription) Token() string {
+	return sub.token
+}
+
+// newPollToken returns a random poll token
+func newPollToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("reading random poll token: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// validToken compares token with the subscription's in constant time
+func (sub *PollSubscription) validToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(sub.token)) == 1
+}
+
+// lookup returns a client's subscription if token is its token
+func (pm *PollManager) lookup(clientID, token string) (*PollSubscription, error) {
+	pm.mutex.Lock()
+	sub, exists := pm.subscriptions[clientID]
+	pm.mutex.Unlock()
+
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", errPollSubscriptionNotFound, clientID)
+	}
+	if !sub.validToken(token) {
+		return nil, fmt.Errorf("%w for %s", errWrongPollToken, clientID)
+	}
+	return sub, nil
+}
+
+// Upsert creates a poll subscription or changes its topic set. A new
+// subscription is issued a token, and changing an existing one takes that
+// token. A client_id held by a connection on another transport is refused.
+func (pm *PollManager) Upsert(ctx context.Context, clientID, token string, topics []string) (*PollSubscription, error) {
+	if clientID == "" {
+		clientID = uuid.New().String()
+	}
+
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	sub, exists := pm.subscriptions[clientID]
+	if exists && !sub.validToken(token) {
+		return nil, fmt.Errorf("%w for %s", errWrongPollToken, clientID)
+	}
+	if !exists {
+		sub = &PollSubscription{
+			ps:       pm.ps,
+			clientID: clientID,
+			token:    newPollToken(),
+			topics:   make(map[string]bool),
+			buffer:   pubsub.NewRingBuffer[pubsub.EventResponse](pubsub.DefaultBufferSize),
+			notify:   make(chan struct{}, 1),
+			lastSeen: pm.ps.Clock().Now(),
+		}
+		if _, ok := pm.ps.RegisterClientIfAbsent(sub); !ok {
+			return nil, fmt.Errorf("%w: %s", errClientIDInUse, clientID)
+		}
+	}
+
+	// Long polling serves the default namespace only
+	wanted := make(map[string]bool, len(topics))
+	for _, topic := range topics {
+		if _, err := pubsub.QualifyTopic(pubsub.DefaultNamespace, topic); err != nil {
+			return nil, err
+		}
+		wanted[topic] = true
+	}
+
+	// Subscribe to new topics first so a missing topic leaves the
+	// existing subscription untouched
+	var added []string
+	for topic := range wanted {
+		if sub.topics[topic] {
+			continue
+		}
+		if _, err := pm.ps.Subscribe(ctx, clientID, topic, 0, sub); err != nil {
+			// Roll back even if ctx is what failed
+			for _, t := range added {
+				pm.ps.Unsubscribe(context.Background(), clientID, t)
+			}
+			if !exists {
+				pm.ps.UnregisterClientIfCurrent(sub)
+			}
+			return nil, err
+		}
+		added = append(added, topic)
+	}
+	for topic := range sub.topics {
+		if !wanted[topic] {
+			pm.ps.Unsubscribe(context.Background(), clientID, topic)
+		}
+	}
+
+	sub.mutex.Lock()
+	sub.topics = wanted
+	sub.lastSeen = pm.ps.Clock().Now()
+	sub.mutex.Unlock()
+
+	if !exists {
+		pm.subscriptions[clientID] = sub
+		pm.ps.DeliverHeldDirect(sub)
+	}
+	return sub, nil
+}
+
+// Remove deletes a poll subscription, given its token
+func (pm *PollManager) Remove(clientID, token string) error {
+	pm.mutex.Lock()
+	sub, exists := pm.subscriptions[clientID]
+	if exists && !sub.validToken(token) {
+		pm.mutex.Unlock()
+		return fmt.Errorf("%w for %s", errWrongPollToken, clientID)
+	}
+	delete(pm.subscriptions, clientID)
+	pm.mutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("%w: %s", errPollSubscriptionNotFound, clientID)
+	}
+	pm.release(sub)
+	return nil
+}
+
+// Poll returns unacknowledged and newly buffered events for a client,
+// waiting up to wait for at least one. cursor acknowledges every event up to
+// that delivery sequence; events after it are handed out again, so a lost
+// response never loses events. A negative cursor acknowledges everything
+// previously handed out. A newer poll for the same client releases an
+// in-flight one with no events. token must be the subscription's token.
+func (pm *PollManager) Poll(ctx context.Context, clientID, token string, cursor int64, wait time.Duration) ([]pubsub.EventResponse, int64, error) {
+	sub, err := pm.lookup(clientID, token)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sub.mutex.Lock()
+	if sub.waiter != nil {
+		close(sub.waiter)
+	}
+	waiter := make(chan struct{})
+	sub.waiter = waiter
+	sub.lastSeen = pm.ps.Clock().Now()
+	sub.acknowledge(cursor)
+	sub.mutex.Unlock()
+
+	defer func() {
+		sub.mutex.Lock()
+		if sub.waiter == waiter {
+			sub.waiter = nil
+		}
+		sub.lastSeen = pm.ps.Clock().Now()
+		sub.mutex.Unlock()
+	}()
+
+	timer := pm.ps.Clock().NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		sub.mutex.Lock()
+		select {
+		case <-waiter:
+			// Superseded by a newer poll, which owns delivery now
+			c := sub.cursor
+			sub.mutex.Unlock()
+			return nil, c, nil
+		default:
+		}
+		if fresh := sub.buffer.PopAll(); len(fresh) > 0 {
+			sub.unacked = append(sub.unacked, fresh...)
+			sub.cursor += int64(len(fresh))
+		}
+		if len(sub.unacked) > 0 {
+			events := make([]pubsub.EventResponse, len(sub.unacked))
+			copy(events, sub.unacked)
+			c := sub.cursor
+			sub.mutex.Unlock()
+			return events, c, nil
+		}
+		c := sub.cursor
+		sub.mutex.Unlock()
+
+		select {
+		case <-sub.notify:
+		case <-waiter:
+		case <-timer.C():
+			return nil, c, nil
+		case <-ctx.Done():
+			return nil, c, ctx.Err()
+		}
+	}
+}
+
+// acknowledge drops handed-out events up to cursor. Callers must hold the mutex.
+func (sub *PollSubscription) acknowledge(cursor int64) {
+	if cursor < 0 || cursor >= sub.cursor {
+		sub.unacked = nil
+		return
+	}
+	// Unacked events hold delivery sequences (sub.cursor-len(unacked), sub.cursor]
+	first := sub.cursor - int64(len(sub.unacked)) + 1
+	if cursor >= first {
+		sub.unacked = sub.unacked[cursor-first+1:]
+	}
+}
+
+// reapLoop removes subscriptions that have not polled within the TTL
+func (pm *PollManager) reapLoop() {
+	ticker := pm.ps.Clock().NewTicker(pollReapInterval)
+	defer ticker.Stop()
+
+	for range ticker.C() {
+		var expired []*PollSubscription
+		pm.mutex.Lock()
+		cutoff := pm.ps.Clock().Now().Add(-pm.ttl)
+		for clientID, sub := range pm.subscriptions {
+			sub.mutex.Lock()
+			idle := sub.waiter == nil && sub.lastSeen.Before(cutoff)
+			sub.mutex.Unlock()
+			if idle {
+				delete(pm.subscriptions, clientID)
+				expired = append(expired, sub)
+			}
+		}
+		pm.mutex.Unlock()
+
+		for _, sub := range expired {
+			log.Printf("Reaping idle poll subscription %s", sub.clientID)
+			pm.release(sub)
+			sub.deadLetterPending()
+		}
+	}
+}
+
+// deadLetterPending reports every event the client never acknowledged as
+// expired. Call it after release so nothing more is buffered.
+func (sub *PollSubscription) deadLetterPending() {
+	sub.mutex.Lock()
+	pending := append(sub.unacked, sub.buffer.PopAll()...)
+	sub.unacked = nil
+	sub.mutex.Unlock()
+
+	for _, event := range pending {
+		sub.ps.DeadLetter(event, sub.clientID, pubsub.DeadLetterTTLExpired)
+	}
+}
+
+// release detaches a subscription from the pub-sub system
+func (pm *PollManager) release(sub *PollSubscription) {
+	pm.ps.DisconnectClient(sub.clientID)
+	pm.ps.UnregisterClientIfCurrent(sub)
+	sub.buffer.Close()
+
+	sub.mutex.Lock()
+	if sub.waiter != nil {
+		close(sub.waiter)
+		sub.waiter = nil
+	}
+	sub.mutex.Unlock()
+}
+
+// bufferStats sums the event buffers of every poll subscription
+func (pm *PollManager) bufferStats() pubsub.BufferStats {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	var stats pubsub.BufferStats
+	for _, sub := range pm.subscriptions {
+		stats.Add(sub.buffer.Stats())
+	}
+	return stats
+}
+
+// clientBufferStats returns the event buffer of clientID's poll
+// subscription, or nil if it has none
+func (pm *PollManager) clientBufferStats(clientID string) *pubsub.RingBufferStats {
+	pm.mutex.Lock()
+	sub, exists := pm.subscriptions[clientID]
+	pm.mutex.Unlock()
+
+	if !exists {
+		return nil
+	}
+	stats := sub.buffer.Stats()
+	return &stats
+}
+
+// resetHighWatermarks starts every poll buffer's high watermark over
+func (pm *PollManager) resetHighWatermarks() {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	for _, sub := range pm.subscriptions {
+		sub.buffer.ResetHighWatermark()
+	}
+}
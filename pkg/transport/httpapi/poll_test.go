@@ -0,0 +1,305 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AnshulDekate/pubsub/pkg/clock/clocktest"
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// pollServer serves the HTTP routes for ps with an "orders" topic
+func pollServer(t *testing.T, ps *pubsub.PubSubSystem) *httptest.Server {
+	t.Helper()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	return apiServer(t, ps)
+}
+
+// doWithToken is do with a poll token
+func doWithToken(t *testing.T, method, url, token, body string, out interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set(PollTokenHeader, token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode
+}
+
+// subscribePoll creates a poll subscription and returns its token
+func subscribePoll(t *testing.T, server *httptest.Server, clientID string) string {
+	t.Helper()
+	var resp pubsub.PollSubscriptionResponse
+	status := do(t, "POST", server.URL+"/subscriptions", fmt.Sprintf(`{"client_id":%q,"topics":["orders"]}`, clientID), &resp)
+	if status != http.StatusOK || resp.PollToken == "" {
+		t.Fatalf("POST /subscriptions = %d %+v", status, resp)
+	}
+	return resp.PollToken
+}
+
+func poll(t *testing.T, server *httptest.Server, clientID, token string, cursor int64, wait string) pubsub.PollResponse {
+	t.Helper()
+	var resp pubsub.PollResponse
+	url := fmt.Sprintf("%s/poll?client_id=%s&cursor=%d&wait=%s", server.URL, clientID, cursor, wait)
+	if status := doWithToken(t, "GET", url, token, "", &resp); status != http.StatusOK {
+		t.Fatalf("GET /poll = %d", status)
+	}
+	return resp
+}
+
+func TestPollDeliversExactlyOnceInOrder(t *testing.T) {
+	ps := pubsub.New()
+	server := pollServer(t, ps)
+	token := subscribePoll(t, server, "poller")
+
+	const total = 300
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// Small bursts, so polls and publishes interleave without
+		// overflowing the subscription's buffer
+		for sent := 0; sent < total; sent += 10 {
+			for i := 0; i < 10; i++ {
+				if err := ps.Publish(context.Background(), "orders", pubsub.MessageData{ID: fmt.Sprintf("m%d", sent+i), Payload: sent + i}, ""); err != nil {
+					t.Errorf("Publish: %v", err)
+					return
+				}
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	var seqs []int64
+	cursor := int64(0)
+	deadline := time.Now().Add(10 * time.Second)
+	for len(seqs) < total && time.Now().Before(deadline) {
+		resp := poll(t, server, "poller", token, cursor, "1s")
+		for _, event := range resp.Events {
+			seqs = append(seqs, event.Seq)
+		}
+		cursor = resp.Cursor
+	}
+	wg.Wait()
+
+	if len(seqs) != total {
+		t.Fatalf("received %d events, want %d", len(seqs), total)
+	}
+	for i, seq := range seqs {
+		if seq != int64(i+1) {
+			t.Fatalf("event %d has seq %d, want %d", i, seq, i+1)
+		}
+	}
+}
+
+func TestPollDropsVolatileEventsWhileBehind(t *testing.T) {
+	ps := pubsub.New()
+	server := pollServer(t, ps)
+	token := subscribePoll(t, server, "poller")
+	publish := func(id string, opts pubsub.PublishOptions) {
+		t.Helper()
+		if err := ps.PublishWithOptions(context.Background(), "orders", pubsub.MessageData{ID: id, Payload: id}, "", opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Behind a buffered event, a volatile one takes no slot
+	publish("m1", pubsub.PublishOptions{})
+	publish("typing-1", pubsub.PublishOptions{Volatile: true})
+	resp := poll(t, server, "poller", token, 0, "0s")
+	if len(resp.Events) != 1 || resp.Events[0].Message.ID != "m1" {
+		t.Fatalf("poll = %+v, want only m1", resp.Events)
+	}
+
+	// With nothing buffered it waits for the next poll
+	publish("typing-2", pubsub.PublishOptions{Volatile: true})
+	resp = poll(t, server, "poller", token, resp.Cursor, "0s")
+	if len(resp.Events) != 1 || resp.Events[0].Message.ID != "typing-2" || !resp.Events[0].Volatile {
+		t.Fatalf("poll = %+v, want the volatile typing-2", resp.Events)
+	}
+	if dropped := ps.GetHealth().DroppedLastMinute; dropped != 0 {
+		t.Errorf("%d volatile events counted as drops", dropped)
+	}
+}
+
+func TestPollNewerPollReleasesInFlightOne(t *testing.T) {
+	ps := pubsub.New()
+	server := pollServer(t, ps)
+	token := subscribePoll(t, server, "poller")
+
+	first := make(chan pubsub.PollResponse)
+	go func() { first <- poll(t, server, "poller", token, 0, "10s") }()
+	time.Sleep(50 * time.Millisecond) // Let the first poll start waiting
+
+	second := make(chan pubsub.PollResponse)
+	go func() { second <- poll(t, server, "poller", token, 0, "10s") }()
+	select {
+	case resp := <-first:
+		if len(resp.Events) != 0 {
+			t.Fatalf("superseded poll returned %d events", len(resp.Events))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("in-flight poll was not released by the newer one")
+	}
+
+	publishN(t, ps, "orders", 2)
+	resp := <-second
+	if len(resp.Events) != 2 || resp.Events[0].Seq != 1 || resp.Events[1].Seq != 2 {
+		t.Fatalf("newer poll got %+v", resp.Events)
+	}
+
+	// Not acknowledging them gets them again
+	again := poll(t, server, "poller", token, 0, "0s")
+	if len(again.Events) != 2 || again.Events[0].Seq != 1 {
+		t.Fatalf("unacknowledged events redelivered as %+v", again.Events)
+	}
+	if after := poll(t, server, "poller", token, again.Cursor, "0s"); len(after.Events) != 0 {
+		t.Fatalf("acknowledged events delivered again: %+v", after.Events)
+	}
+}
+
+func TestPollRequiresToken(t *testing.T) {
+	ps := pubsub.New()
+	server := pollServer(t, ps)
+	token := subscribePoll(t, server, "poller")
+
+	for _, wrong := range []string{"", "not-the-token"} {
+		if status := doWithToken(t, "GET", server.URL+"/poll?client_id=poller&wait=0s", wrong, "", nil); status != http.StatusForbidden {
+			t.Errorf("poll with token %q = %d, want 403", wrong, status)
+		}
+		if status := doWithToken(t, "DELETE", server.URL+"/subscriptions/poller", wrong, "", nil); status != http.StatusForbidden {
+			t.Errorf("delete with token %q = %d, want 403", wrong, status)
+		}
+		// Taking the subscription over needs the token too
+		if status := doWithToken(t, "POST", server.URL+"/subscriptions", wrong, `{"client_id":"poller","topics":["orders"]}`, nil); status != http.StatusForbidden {
+			t.Errorf("re-subscribing with token %q = %d, want 403", wrong, status)
+		}
+	}
+
+	var resp pubsub.PollSubscriptionResponse
+	if status := doWithToken(t, "POST", server.URL+"/subscriptions", token, `{"client_id":"poller","topics":["orders"]}`, &resp); status != http.StatusOK {
+		t.Fatalf("re-subscribing with the token = %d", status)
+	}
+	if resp.PollToken != token {
+		t.Errorf("re-subscribing changed the token")
+	}
+	if status := doWithToken(t, "DELETE", server.URL+"/subscriptions/poller", token, "", nil); status != http.StatusOK {
+		t.Fatalf("delete with the token = %d", status)
+	}
+	if status := doWithToken(t, "GET", server.URL+"/poll?client_id=poller&wait=0s", token, "", nil); status != http.StatusNotFound {
+		t.Errorf("poll after delete = %d, want 404", status)
+	}
+}
+
+func TestPollRefusesClientIDHeldElsewhere(t *testing.T) {
+	ps := pubsub.New()
+	server := pollServer(t, ps)
+	other := testClient{id: "ws-client"}
+	ps.RegisterClient(other)
+
+	status := do(t, "POST", server.URL+"/subscriptions", `{"client_id":"ws-client","topics":["orders"]}`, nil)
+	if status != http.StatusConflict {
+		t.Fatalf("subscribing as a connected client = %d, want 409", status)
+	}
+	if holder, ok := ps.RegisterClientIfAbsent(testClient{id: "ws-client"}); ok || holder != other || len(ps.GetClientTopics("ws-client")) != 0 {
+		t.Fatalf("the other connection was disturbed: %v %v", holder, ps.GetClientTopics("ws-client"))
+	}
+}
+
+func TestPollSubscriptionsExpireWhenIdle(t *testing.T) {
+	clock := clocktest.NewFake(time.Now())
+	ps := pubsub.NewWithClock(clock)
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	pm := NewPollManager(ps, time.Minute)
+	sub, err := pm.Upsert(context.Background(), "poller", "", []string{"orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond) // Let the reaper start its ticker
+	}
+
+	// Polling keeps the subscription alive past the TTL
+	clock.Advance(50 * time.Second)
+	if _, _, err := pm.Poll(context.Background(), "poller", sub.Token(), -1, 0); err != nil {
+		t.Fatalf("poll within the TTL: %v", err)
+	}
+	clock.Advance(50 * time.Second)
+	if _, err := pm.lookup("poller", sub.Token()); err != nil {
+		t.Fatalf("reaped despite polling: %v", err)
+	}
+
+	// A minute without a poll, plus a reap interval, and it is gone
+	clock.Advance(time.Minute + pollReapInterval)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := pm.lookup("poller", sub.Token()); errors.Is(err, errPollSubscriptionNotFound) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("idle subscription wasn't reaped")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if topics := ps.GetClientTopics("poller"); len(topics) != 0 {
+		t.Errorf("reaped subscription still on %v", topics)
+	}
+}
+
+func TestPollBufferStats(t *testing.T) {
+	ps := pubsub.New()
+	server := pollServer(t, ps)
+	token := subscribePoll(t, server, "poller")
+	for i := 0; i < 3; i++ {
+		if err := ps.Publish(context.Background(), "orders", pubsub.MessageData{ID: fmt.Sprintf("m%d", i), Payload: i}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if events := poll(t, server, "poller", token, 0, "0s").Events; len(events) != 3 {
+		t.Fatalf("polled %d events", len(events))
+	}
+
+	var stats pubsub.StatsResponse
+	do(t, "GET", server.URL+"/stats", "", &stats)
+	if got := stats.Buffers.PollSubscriptions; got.Buffers != 1 || got.Pushes != 3 || got.Size != 0 || got.HighWatermark != 3 {
+		t.Errorf("poll buffers in /stats = %+v", got)
+	}
+	var detail pubsub.ClientDetailResponse
+	do(t, "GET", server.URL+"/clients/poller", "", &detail)
+	if detail.Buffers.Poll == nil || detail.Buffers.Poll.Capacity != pubsub.DefaultBufferSize {
+		t.Errorf("poller's buffers = %+v", detail.Buffers)
+	}
+
+	if status := do(t, "DELETE", server.URL+"/admin/buffers/high-watermarks", "", nil); status != http.StatusOK {
+		t.Fatalf("resetting high watermarks = %d", status)
+	}
+	do(t, "GET", server.URL+"/stats", "", &stats)
+	if got := stats.Buffers.PollSubscriptions.HighWatermark; got != 0 {
+		t.Errorf("poll high watermark after the reset = %d", got)
+	}
+	if got := stats.Buffers.TopicHistory.HighWatermark; got != 3 {
+		t.Errorf("history high watermark after the reset = %d, want the size 3", got)
+	}
+}
@@ -0,0 +1,101 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+func TestSubscriptionsFilters(t *testing.T) {
+	ps := pubsub.New()
+	ctx := context.Background()
+	for _, name := range []string{"alerts", "billing", "orders"} {
+		if err := ps.CreateTopic(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for clientID, topics := range map[string][]string{
+		"dashboard": {"alerts", "orders"},
+		"ledger":    {"orders"},
+	} {
+		client := testClient{id: clientID}
+		ps.RegisterClient(client)
+		for _, topic := range topics {
+			if _, err := ps.Subscribe(ctx, clientID, topic, 0, client); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	server := apiServer(t, ps)
+
+	for _, tc := range []struct {
+		query string
+		want  pubsub.SubscriptionsStatusResponse
+	}{
+		{"?client_id=dashboard", pubsub.SubscriptionsStatusResponse{
+			TotalClients: 1, TotalTopics: 2, TotalCount: 2,
+			Subscriptions:  []pubsub.ClientSubscription{{ClientID: "dashboard", Topics: []string{"alerts", "orders"}}},
+			TopicBreakdown: map[string][]string{},
+		}},
+		{"?topic=orders", pubsub.SubscriptionsStatusResponse{
+			TotalClients: 2, TotalTopics: 1, TotalCount: 2,
+			Subscriptions:  []pubsub.ClientSubscription{},
+			TopicBreakdown: map[string][]string{"orders": {"dashboard", "ledger"}},
+		}},
+		// Both answer whether a client is subscribed to a topic
+		{"?client_id=ledger&topic=orders", pubsub.SubscriptionsStatusResponse{
+			TotalClients: 1, TotalTopics: 1, TotalCount: 1,
+			Subscriptions:  []pubsub.ClientSubscription{{ClientID: "ledger", Topics: []string{"orders"}}},
+			TopicBreakdown: map[string][]string{},
+		}},
+		{"?client_id=ledger&topic=alerts", pubsub.SubscriptionsStatusResponse{
+			Subscriptions: []pubsub.ClientSubscription{}, TopicBreakdown: map[string][]string{},
+		}},
+		// Unknown clients and topics are empty, not missing
+		{"?client_id=nobody", pubsub.SubscriptionsStatusResponse{
+			Subscriptions: []pubsub.ClientSubscription{}, TopicBreakdown: map[string][]string{},
+		}},
+		{"?topic=missing", pubsub.SubscriptionsStatusResponse{
+			Subscriptions: []pubsub.ClientSubscription{}, TopicBreakdown: map[string][]string{},
+		}},
+		{"?topic=billing", pubsub.SubscriptionsStatusResponse{
+			TotalTopics: 1, Subscriptions: []pubsub.ClientSubscription{}, TopicBreakdown: map[string][]string{"billing": {}},
+		}},
+		// The unfiltered listing pages clients and topics alike
+		{"", pubsub.SubscriptionsStatusResponse{
+			TotalClients: 2, TotalTopics: 3, TotalCount: 2,
+			Subscriptions: []pubsub.ClientSubscription{
+				{ClientID: "dashboard", Topics: []string{"alerts", "orders"}},
+				{ClientID: "ledger", Topics: []string{"orders"}},
+			},
+			TopicBreakdown: map[string][]string{"alerts": {"dashboard"}, "billing": {}, "orders": {"dashboard", "ledger"}},
+		}},
+		{"?limit=1&offset=1", pubsub.SubscriptionsStatusResponse{
+			TotalClients: 2, TotalTopics: 3, TotalCount: 2,
+			Subscriptions:  []pubsub.ClientSubscription{{ClientID: "ledger", Topics: []string{"orders"}}},
+			TopicBreakdown: map[string][]string{"billing": {}},
+		}},
+		{"?offset=5", pubsub.SubscriptionsStatusResponse{
+			TotalClients: 2, TotalTopics: 3, TotalCount: 2,
+			Subscriptions: []pubsub.ClientSubscription{}, TopicBreakdown: map[string][]string{},
+		}},
+	} {
+		var got pubsub.SubscriptionsStatusResponse
+		if status := do(t, "GET", server.URL+"/subscriptions"+tc.query, "", &got); status != http.StatusOK {
+			t.Errorf("GET /subscriptions%s = %d", tc.query, status)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("GET /subscriptions%s = %+v, want %+v", tc.query, got, tc.want)
+		}
+	}
+
+	for _, query := range []string{"?limit=0", "?limit=x", "?offset=-1"} {
+		if status := do(t, "GET", server.URL+"/subscriptions"+query, "", nil); status != http.StatusBadRequest {
+			t.Errorf("GET /subscriptions%s = %d", query, status)
+		}
+	}
+}
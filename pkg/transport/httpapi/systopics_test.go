@@ -0,0 +1,70 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// sysMonitor records what is published to a $sys topic
+type sysMonitor struct {
+	events chan pubsub.EventResponse
+}
+
+func (m *sysMonitor) GetClientID() string      { return "monitor" }
+func (m *sysMonitor) IsConnected() bool        { return true }
+func (m *sysMonitor) GetLastActive() time.Time { return time.Now() }
+
+func (m *sysMonitor) SendMessage(msg interface{}) error {
+	if prepared, ok := msg.(*pubsub.PreparedEvent); ok {
+		m.events <- prepared.Event
+	}
+	return nil
+}
+
+func TestSystemTopicsOverREST(t *testing.T) {
+	ps := pubsub.New()
+	ps.EnableSystemTopics(time.Hour)
+	t.Cleanup(ps.Close)
+	server := apiServer(t, ps)
+	monitor := &sysMonitor{events: make(chan pubsub.EventResponse, 16)}
+	ps.RegisterClient(monitor)
+	if _, err := ps.Subscribe(context.Background(), "monitor", pubsub.SysTopicsTopic, 0, monitor); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reserved names are refused
+	for _, name := range []string{"$orders", "$sys/mine"} {
+		if status := do(t, "POST", server.URL+"/topics", `{"name":"`+name+`"}`, nil); status != http.StatusForbidden {
+			t.Errorf("creating %s = %d", name, status)
+		}
+	}
+
+	// A change made over REST is attributed to the basic-auth user
+	req, err := http.NewRequest("POST", server.URL+"/topics", strings.NewReader(`{"name":"orders"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("alice", "x")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("creating orders = %d", resp.StatusCode)
+	}
+	select {
+	case event := <-monitor.events:
+		want := pubsub.SystemEvent{Event: "topic_created", Namespace: pubsub.DefaultNamespace, Topic: "orders", Actor: "alice"}
+		if event.Message.Payload != want {
+			t.Errorf("$sys/topics got %+v, want %+v", event.Message.Payload, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no $sys/topics event for the created topic")
+	}
+}
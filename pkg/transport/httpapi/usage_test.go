@@ -0,0 +1,157 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// meteredConn is a websocket connection that tallies the application
+// frames it writes and reads
+type meteredConn struct {
+	t    *testing.T
+	conn *websocket.Conn
+	want pubsub.ClientUsageStats // What the broker should have counted
+}
+
+func dialMetered(t *testing.T, url string) *meteredConn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http")+"/ws?client_id=billing", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &meteredConn{t: t, conn: conn}
+	c.read("welcome")
+	return c
+}
+
+func (c *meteredConn) write(frame string) {
+	c.t.Helper()
+	if err := c.conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+		c.t.Fatal(err)
+	}
+	c.want.MessagesReceived++
+	c.want.BytesReceived += int64(len(frame))
+}
+
+// read reads one frame of each of types, in any order, and returns their
+// sizes by type
+func (c *meteredConn) read(types ...string) map[string]int {
+	c.t.Helper()
+	wanted := map[string]bool{}
+	for _, kind := range types {
+		wanted[kind] = true
+	}
+	sizes := map[string]int{}
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(sizes) < len(types) {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.t.Fatalf("waiting for %v: %v", types, err)
+		}
+		var frame struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal(data, &frame)
+		if _, seen := sizes[frame.Type]; seen || !wanted[frame.Type] {
+			c.t.Fatalf("unexpected %s frame %s", frame.Type, data)
+		}
+		sizes[frame.Type] = len(data)
+		c.want.MessagesSent++
+		c.want.BytesSent += int64(len(data))
+	}
+	return sizes
+}
+
+// usage reads the client's usage over REST once it matches want, failing
+// if it doesn't in time
+func usage(t *testing.T, url, clientID string, want pubsub.ClientUsageStats) {
+	t.Helper()
+	var detail pubsub.ClientDetailResponse
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if status := do(t, "GET", url+"/clients/"+clientID, "", &detail); status != http.StatusOK {
+			t.Fatalf("GET /clients/%s = %d", clientID, status)
+		}
+		// Writes are counted just after the frame goes out
+		if detail.Usage == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s usage = %+v, want %+v", clientID, detail.Usage, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestClientUsageMatchesFrames(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	server := apiServer(t, ps)
+
+	c := dialMetered(t, server.URL)
+	c.write(`{"type":"subscribe","topic":"orders","client_id":"billing","request_id":"s-1"}`)
+	c.read("ack")
+	publish := fmt.Sprintf(`{"type":"publish","topic":"orders","client_id":"billing","request_id":"p-1","message":{"id":%q,"payload":{"amount":42}}}`, uuid.NewString())
+	c.write(publish)
+	sizes := c.read("ack", "event")
+	usage(t, server.URL, "billing", c.want)
+
+	// The topic is charged for the publish received and the event sent
+	var stats pubsub.StatsResponse
+	if status := do(t, "GET", server.URL+"/stats", "", &stats); status != http.StatusOK {
+		t.Fatalf("GET /stats = %d", status)
+	}
+	if orders := stats.Topics["orders"]; orders.BytesIn != int64(len(publish)) || orders.BytesOut != int64(sizes["event"]) {
+		t.Errorf("orders traffic in %d out %d, want %d and %d", orders.BytesIn, orders.BytesOut, len(publish), sizes["event"])
+	}
+
+	// A reconnect that claims the same client_id keeps counting
+	c.conn.Close()
+	waitForDisconnect(t, ps, "billing")
+	before := c.want
+	c = dialMetered(t, server.URL)
+	c.want.MessagesReceived += before.MessagesReceived
+	c.want.BytesReceived += before.BytesReceived
+	c.want.MessagesSent += before.MessagesSent
+	c.want.BytesSent += before.BytesSent
+	c.write(`{"type":"subscribe","topic":"orders","client_id":"billing","request_id":"s-2"}`)
+	c.read("ack")
+	usage(t, server.URL, "billing", c.want)
+
+	// Until reset by an operator
+	if status := do(t, "DELETE", server.URL+"/clients/billing/usage", "", nil); status != http.StatusOK {
+		t.Fatalf("resetting billing's usage = %d", status)
+	}
+	usage(t, server.URL, "billing", pubsub.ClientUsageStats{})
+	if status := do(t, "DELETE", server.URL+"/clients/nobody/usage", "", nil); status != http.StatusNotFound {
+		t.Errorf("resetting an unknown client's usage = %d", status)
+	}
+}
+
+// waitForDisconnect waits until the broker has let go of clientID
+func waitForDisconnect(t *testing.T, ps *pubsub.PubSubSystem, clientID string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if detail, _ := ps.GetClientDetail(clientID); !detail.Connected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s is still connected", clientID)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,335 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Control packet types, from the high nibble of the fixed header
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetUnsubscribe = 10
+	packetUnsuback    = 11
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+)
+
+// CONNACK return codes
+const (
+	connAccepted           = 0x00
+	connBadProtocol        = 0x01
+	connIdentifierRejected = 0x02
+	connServerUnavailable  = 0x03
+	connNotAuthorized      = 0x05
+)
+
+// subackFailure is the SUBACK return code for a refused topic filter
+const subackFailure = 0x80
+
+// PUBLISH fixed header flags
+const (
+	publishRetain = 0x01
+	publishQoS    = 0x06
+)
+
+var (
+	errMalformed      = errors.New("malformed packet")
+	errPacketTooLarge = errors.New("packet too large")
+)
+
+// packet is a control packet: its type, the flags in the low nibble of the
+// fixed header, and the variable header and payload
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+// readPacket reads one packet, refusing any whose remaining length is over
+// maxSize before reading it
+func readPacket(r *bufio.Reader, maxSize int) (packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+
+	// The remaining length takes one to four bytes, seven bits at a time
+	var length, shift int
+	for i := 0; ; i++ {
+		if i == 4 {
+			return packet{}, errMalformed
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		length |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	if length > maxSize {
+		return packet{}, fmt.Errorf("%w: %d bytes", errPacketTooLarge, length)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+	return packet{kind: header >> 4, flags: header & 0x0f, body: body}, nil
+}
+
+// encodePacket returns a packet's wire form
+func encodePacket(kind, flags byte, body []byte) []byte {
+	buf := make([]byte, 0, len(body)+5)
+	buf = append(buf, kind<<4|flags)
+	length := len(body)
+	for {
+		b := byte(length & 0x7f)
+		length >>= 7
+		if length > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if length == 0 {
+			break
+		}
+	}
+	return append(buf, body...)
+}
+
+// appendString appends a length-prefixed string
+func appendString(buf []byte, s string) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+// decoder reads the fields of a packet body, remembering the first error
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.buf) < 1 {
+		d.err = errMalformed
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *decoder) uint16() uint16 {
+	if d.err != nil || len(d.buf) < 2 {
+		d.err = errMalformed
+		return 0
+	}
+	v := binary.BigEndian.Uint16(d.buf)
+	d.buf = d.buf[2:]
+	return v
+}
+
+// bytes reads length-prefixed binary data
+func (d *decoder) bytes() []byte {
+	n := int(d.uint16())
+	if d.err != nil || len(d.buf) < n {
+		d.err = errMalformed
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+// string reads a length-prefixed UTF-8 string, which may not contain NUL
+func (d *decoder) string() string {
+	b := d.bytes()
+	if d.err == nil && (!utf8.Valid(b) || containsNUL(b)) {
+		d.err = errMalformed
+	}
+	return string(b)
+}
+
+func containsNUL(b []byte) bool {
+	for _, c := range b {
+		if c == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// will is the last will a CONNECT carries
+type will struct {
+	topic   string
+	payload []byte
+	qos     byte
+	retain  bool
+}
+
+// connectPacket is a decoded CONNECT
+type connectPacket struct {
+	protocol     string
+	level        byte
+	cleanSession bool
+	keepAlive    uint16
+	clientID     string
+	will         *will
+	username     string
+	password     []byte
+}
+
+// parseConnect decodes a CONNECT body. A packet from another protocol
+// version decodes as far as its protocol name and level, so it can be
+// answered with connBadProtocol.
+func parseConnect(body []byte) (connectPacket, error) {
+	d := &decoder{buf: body}
+	c := connectPacket{protocol: d.string(), level: d.byte()}
+	if d.err != nil {
+		return c, d.err
+	}
+	if c.protocol != "MQTT" || c.level != 4 {
+		return c, nil
+	}
+
+	flags := d.byte()
+	c.keepAlive = d.uint16()
+	c.clientID = d.string()
+	if flags&0x01 != 0 {
+		return c, errMalformed
+	}
+	c.cleanSession = flags&0x02 != 0
+	if flags&0x04 != 0 {
+		c.will = &will{
+			qos:    flags >> 3 & 0x03,
+			retain: flags&0x20 != 0,
+		}
+		c.will.topic = d.string()
+		c.will.payload = d.bytes()
+		if c.will.qos == 3 {
+			return c, errMalformed
+		}
+	} else if flags&0x38 != 0 {
+		return c, errMalformed
+	}
+	if flags&0x80 != 0 {
+		c.username = d.string()
+	}
+	if flags&0x40 != 0 {
+		if flags&0x80 == 0 {
+			return c, errMalformed
+		}
+		c.password = d.bytes()
+	}
+	return c, d.err
+}
+
+// publishPacket is a decoded PUBLISH
+type publishPacket struct {
+	topic    string
+	qos      byte
+	retain   bool
+	packetID uint16
+	payload  []byte
+}
+
+func parsePublish(p packet) (publishPacket, error) {
+	d := &decoder{buf: p.body}
+	pub := publishPacket{
+		qos:    p.flags & publishQoS >> 1,
+		retain: p.flags&publishRetain != 0,
+	}
+	if pub.qos == 3 {
+		return pub, errMalformed
+	}
+	pub.topic = d.string()
+	if pub.qos > 0 {
+		pub.packetID = d.uint16()
+	}
+	pub.payload = d.buf
+	return pub, d.err
+}
+
+// encodePublish returns a QoS 0 PUBLISH
+func encodePublish(topic string, payload []byte, retain bool) []byte {
+	body := make([]byte, 0, 2+len(topic)+len(payload))
+	body = appendString(body, topic)
+	body = append(body, payload...)
+	var flags byte
+	if retain {
+		flags |= publishRetain
+	}
+	return encodePacket(packetPublish, flags, body)
+}
+
+// subscription is one topic filter of a SUBSCRIBE
+type subscription struct {
+	filter string
+	qos    byte
+}
+
+// parseSubscribe decodes a SUBSCRIBE body into its packet ID and filters
+func parseSubscribe(p packet) (uint16, []subscription, error) {
+	if p.flags != 0x02 {
+		return 0, nil, errMalformed
+	}
+	d := &decoder{buf: p.body}
+	packetID := d.uint16()
+	var subs []subscription
+	for d.err == nil && len(d.buf) > 0 {
+		sub := subscription{filter: d.string(), qos: d.byte()}
+		if sub.qos > 2 {
+			return 0, nil, errMalformed
+		}
+		subs = append(subs, sub)
+	}
+	if d.err == nil && len(subs) == 0 {
+		d.err = errMalformed
+	}
+	return packetID, subs, d.err
+}
+
+// parseUnsubscribe decodes an UNSUBSCRIBE body into its packet ID and
+// filters
+func parseUnsubscribe(p packet) (uint16, []string, error) {
+	if p.flags != 0x02 {
+		return 0, nil, errMalformed
+	}
+	d := &decoder{buf: p.body}
+	packetID := d.uint16()
+	var filters []string
+	for d.err == nil && len(d.buf) > 0 {
+		filters = append(filters, d.string())
+	}
+	if d.err == nil && len(filters) == 0 {
+		d.err = errMalformed
+	}
+	return packetID, filters, d.err
+}
+
+// encodeAck returns a packet whose body is just a packet ID, such as PUBACK
+// or UNSUBACK
+func encodeAck(kind byte, packetID uint16) []byte {
+	return encodePacket(kind, 0, binary.BigEndian.AppendUint16(nil, packetID))
+}
+
+func encodeConnack(code byte) []byte {
+	return encodePacket(packetConnack, 0, []byte{0, code})
+}
+
+func encodeSuback(packetID uint16, codes []byte) []byte {
+	body := binary.BigEndian.AppendUint16(nil, packetID)
+	return encodePacket(packetSuback, 0, append(body, codes...))
+}
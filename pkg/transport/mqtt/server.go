@@ -0,0 +1,679 @@
+// Package mqtt serves MQTT 3.1.1 clients from a PubSubSystem, so devices
+// that speak only MQTT share topics, history, stats and limits with
+// websocket clients. An MQTT topic name is a topic in the default namespace
+// with each '/' replaced by Options.TopicSeparator, and an MQTT client ID
+// is a client_id. Like websocket clients, MQTT clients aren't
+// authenticated, so the user name and password of a CONNECT are ignored.
+//
+// Delivery is QoS 0: subscriptions are granted at QoS 0, and QoS 1
+// publishes are acknowledged once they have been handled. QoS 2 publishes
+// close the connection. Topics have no pattern subscriptions, so filters
+// with the '+' and '#' wildcards are refused.
+//
+// Topics don't retain messages either. Instead, events published with the
+// retain flag carry RetainHeader, and a new subscription is sent the
+// topic's newest such event while it is still in the topic's history.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// Defaults for the zero values in Options
+const (
+	DefaultTopicSeparator = "."
+	DefaultMaxPacketSize  = 256 * 1024
+	DefaultConnectTimeout = 10 * time.Second
+	DefaultWriteWait      = 10 * time.Second
+)
+
+// RetainHeader is set to "true" on events published with the retain flag
+const RetainHeader = "Mqtt-Retain"
+
+// ErrServerClosed is returned by Serve once the server is closed
+var ErrServerClosed = errors.New("mqtt: server closed")
+
+var (
+	errWildcard      = errors.New("wildcards are not supported")
+	errEmptyTopic    = errors.New("empty topic name")
+	errSeparatorName = errors.New("topic name contains the topic separator")
+)
+
+// Options configures a Server
+type Options struct {
+	// Replaces each '/' of an MQTT topic name to form the topic, and the
+	// reverse on the way out; "/" uses MQTT names as they are
+	TopicSeparator string
+
+	// Largest packet accepted, not counting its fixed header
+	MaxPacketSize int
+
+	// How long a new connection has to send CONNECT
+	ConnectTimeout time.Duration
+
+	// How long a write to a client may take before it is disconnected
+	WriteWait time.Duration
+}
+
+// Server accepts MQTT connections for a PubSubSystem
+type Server struct {
+	ps   *pubsub.PubSubSystem
+	opts Options
+
+	mutex     sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[*conn]struct{}
+}
+
+// NewServer returns a Server for ps
+func NewServer(ps *pubsub.PubSubSystem, opts Options) *Server {
+	if opts.TopicSeparator == "" {
+		opts.TopicSeparator = DefaultTopicSeparator
+	}
+	if opts.MaxPacketSize <= 0 {
+		opts.MaxPacketSize = DefaultMaxPacketSize
+	}
+	if opts.ConnectTimeout <= 0 {
+		opts.ConnectTimeout = DefaultConnectTimeout
+	}
+	if opts.WriteWait <= 0 {
+		opts.WriteWait = DefaultWriteWait
+	}
+	return &Server{
+		ps:        ps,
+		opts:      opts,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*conn]struct{}),
+	}
+}
+
+// ListenAndServe serves MQTT on the given address until the server is
+// closed
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until the server is closed, when it
+// returns ErrServerClosed
+func (s *Server) Serve(l net.Listener) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mutex.Unlock()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.mutex.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.handle(nc)
+	}
+}
+
+// Close stops accepting connections and closes those open. Their last
+// wills are published as for any dropped connection.
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	s.closed = true
+	listeners := s.listeners
+	conns := s.conns
+	s.listeners = make(map[net.Listener]struct{})
+	s.conns = make(map[*conn]struct{})
+	s.mutex.Unlock()
+
+	for l := range listeners {
+		l.Close()
+	}
+	for c := range conns {
+		c.close("server closed")
+	}
+	return nil
+}
+
+// handle serves one connection until it ends
+func (s *Server) handle(nc net.Conn) {
+	c := newConn(s, nc)
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		nc.Close()
+		return
+	}
+	s.conns[c] = struct{}{}
+	s.mutex.Unlock()
+
+	c.serve()
+
+	s.mutex.Lock()
+	delete(s.conns, c)
+	s.mutex.Unlock()
+}
+
+// topicName maps an MQTT topic name to its topic. Names with wildcards,
+// and names that already contain the separator and so couldn't be mapped
+// back, are refused.
+func (s *Server) topicName(name string) (string, error) {
+	if name == "" {
+		return "", errEmptyTopic
+	}
+	if strings.ContainsAny(name, "+#") {
+		return "", errWildcard
+	}
+	if s.opts.TopicSeparator != "/" && strings.Contains(name, s.opts.TopicSeparator) {
+		return "", errSeparatorName
+	}
+	return pubsub.QualifyTopic(pubsub.DefaultNamespace, strings.ReplaceAll(name, "/", s.opts.TopicSeparator))
+}
+
+// mqttName maps a topic to its MQTT topic name
+func (s *Server) mqttName(topic string) string {
+	return strings.ReplaceAll(pubsub.LocalTopic(topic), s.opts.TopicSeparator, "/")
+}
+
+// message turns an MQTT payload into a message. A payload that is valid
+// JSON is published as its value, anything else as a string.
+func message(payload []byte, retain bool) pubsub.MessageData {
+	msg := pubsub.MessageData{ID: uuid.NewString()}
+	if err := json.Unmarshal(payload, &msg.Payload); err != nil {
+		msg.Payload = string(payload)
+	}
+	if retain {
+		msg.Headers = map[string]string{RetainHeader: "true"}
+	}
+	return msg
+}
+
+// payload turns an event's payload back into MQTT bytes: strings as they
+// are, anything else as JSON
+func payload(value interface{}) ([]byte, error) {
+	if s, ok := value.(string); ok {
+		return []byte(s), nil
+	}
+	return json.Marshal(value)
+}
+
+// outgoing is a packet for the writer, and for a PUBLISH the topic its
+// traffic is counted against
+type outgoing struct {
+	data  []byte
+	topic string
+}
+
+// conn is one MQTT connection. It is the ClientInterface its subscriptions
+// deliver to; live events are buffered and dropped like a websocket
+// client's, while acks and replays go out in the order they are made.
+type conn struct {
+	s        *Server
+	ps       *pubsub.PubSubSystem
+	netConn  net.Conn
+	reader   *bufio.Reader
+	remoteIP string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	clientID   string
+	keepAlive  time.Duration
+	usage      *pubsub.ClientUsage
+	published  int64
+	disconnect bool   // The client ended the connection with DISCONNECT
+	reason     string // Why the connection ended, set once by close
+	lastActive atomic.Int64
+
+	control   chan outgoing
+	events    chan pubsub.EventResponse
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newConn(s *Server, nc net.Conn) *conn {
+	remoteIP, _, err := net.SplitHostPort(nc.RemoteAddr().String())
+	if err != nil {
+		remoteIP = nc.RemoteAddr().String()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &conn{
+		s:        s,
+		ps:       s.ps,
+		netConn:  nc,
+		reader:   bufio.NewReader(nc),
+		remoteIP: remoteIP,
+		ctx:      ctx,
+		cancel:   cancel,
+		control:  make(chan outgoing),
+		events:   make(chan pubsub.EventResponse, pubsub.ClientSendBufferSize),
+		done:     make(chan struct{}),
+	}
+	c.lastActive.Store(time.Now().UnixNano())
+	return c
+}
+
+// serve handles the connection's CONNECT and then its packets until it
+// ends
+func (c *conn) serve() {
+	defer c.cancel()
+	if !c.connect() {
+		c.netConn.Close()
+		return
+	}
+	go c.writePump()
+
+	for {
+		if c.keepAlive > 0 {
+			c.netConn.SetReadDeadline(time.Now().Add(c.keepAlive * 3 / 2))
+		}
+		p, err := readPacket(c.reader, c.s.opts.MaxPacketSize)
+		if err != nil {
+			c.close(readError(err))
+			break
+		}
+		c.lastActive.Store(time.Now().UnixNano())
+		c.usage.Received(len(p.body))
+		if !c.handlePacket(p) {
+			break
+		}
+	}
+	c.cleanup()
+}
+
+// readError describes why reading from the connection stopped
+func readError(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, net.ErrClosed):
+		return "connection closed"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "keep alive timeout"
+	default:
+		return err.Error()
+	}
+}
+
+// connect reads the CONNECT that must open the connection and answers it,
+// registering the client. It returns false if the connection was refused.
+func (c *conn) connect() bool {
+	c.netConn.SetReadDeadline(time.Now().Add(c.s.opts.ConnectTimeout))
+	p, err := readPacket(c.reader, c.s.opts.MaxPacketSize)
+	if err != nil {
+		log.Printf("MQTT connection from %s failed before CONNECT: %v", c.remoteIP, err)
+		return false
+	}
+	if p.kind != packetConnect {
+		log.Printf("MQTT connection from %s sent packet type %d before CONNECT", c.remoteIP, p.kind)
+		return false
+	}
+	req, err := parseConnect(p.body)
+	if err != nil {
+		log.Printf("MQTT connection from %s sent a malformed CONNECT: %v", c.remoteIP, err)
+		return false
+	}
+	refuse := func(code byte, reason string) bool {
+		log.Printf("Refusing MQTT connection from %s: %s", c.remoteIP, reason)
+		c.writeNow(encodeConnack(code))
+		return false
+	}
+	if req.protocol != "MQTT" || req.level != 4 {
+		return refuse(connBadProtocol, fmt.Sprintf("unsupported protocol %s level %d", req.protocol, req.level))
+	}
+
+	// Without a stored session, an empty client ID is only allowed for a
+	// clean session, which gets a generated one
+	if req.clientID == "" {
+		if !req.cleanSession {
+			return refuse(connIdentifierRejected, "empty client ID without a clean session")
+		}
+		req.clientID = uuid.NewString()
+	}
+
+	// A draining server, and one at its connection caps, takes no new
+	// connections; the slots are released in cleanup
+	if c.ps.IsDraining() {
+		return refuse(connServerUnavailable, "server is draining")
+	}
+	if !c.ps.AcquireIPConnection(c.remoteIP) {
+		return refuse(connServerUnavailable, "per-IP connection limit reached")
+	}
+	if !c.ps.AcquireConnection() {
+		c.ps.ReleaseIPConnection(c.remoteIP)
+		return refuse(connServerUnavailable, "connection limit reached")
+	}
+
+	// A client ID held by another connection is refused rather than taken
+	// over
+	c.clientID = req.clientID
+	if _, ok := c.ps.RegisterClientIfAbsent(c); !ok {
+		c.ps.ReleaseConnection()
+		c.ps.ReleaseIPConnection(c.remoteIP)
+		return refuse(connIdentifierRejected, "client ID "+req.clientID+" is in use")
+	}
+	if req.will != nil {
+		if err := c.setWill(req.will); err != nil {
+			c.ps.UnregisterClientIfCurrent(c)
+			c.ps.ReleaseConnection()
+			c.ps.ReleaseIPConnection(c.remoteIP)
+			return refuse(connNotAuthorized, fmt.Sprintf("last will of %s refused: %v", req.clientID, err))
+		}
+	}
+
+	c.keepAlive = time.Duration(req.keepAlive) * time.Second
+	c.netConn.SetReadDeadline(time.Time{})
+	c.usage = c.ps.ClientUsage(c.clientID)
+	c.usage.Received(len(p.body))
+	if !c.writeNow(encodeConnack(connAccepted)) {
+		c.reason = "writing CONNACK failed"
+		c.cleanup()
+		return false
+	}
+	c.ps.Audit(pubsub.AuditRecord{
+		Event:      pubsub.AuditConnect,
+		ClientID:   c.clientID,
+		RemoteAddr: c.netConn.RemoteAddr().String(),
+	})
+	log.Printf("New MQTT client connected with ID: %s", c.clientID)
+	return true
+}
+
+// setWill registers a CONNECT's will as the client's last will
+func (c *conn) setWill(w *will) error {
+	topic, err := c.s.topicName(w.topic)
+	if err != nil {
+		return err
+	}
+	return c.ps.SetLastWill(c.clientID, pubsub.LastWill{Topic: topic, Message: message(w.payload, w.retain)})
+}
+
+// writeNow writes a packet before the writer has started
+func (c *conn) writeNow(data []byte) bool {
+	c.netConn.SetWriteDeadline(time.Now().Add(c.s.opts.WriteWait))
+	_, err := c.netConn.Write(data)
+	return err == nil
+}
+
+// handlePacket handles a packet after CONNECT. It returns false once the
+// connection has been closed.
+func (c *conn) handlePacket(p packet) bool {
+	switch p.kind {
+	case packetPublish:
+		return c.publish(p)
+	case packetSubscribe:
+		return c.subscribe(p)
+	case packetUnsubscribe:
+		return c.unsubscribe(p)
+	case packetPingreq:
+		return c.send(outgoing{data: encodePacket(packetPingresp, 0, nil)})
+	case packetDisconnect:
+		c.disconnect = true
+		c.close("client disconnected")
+		return false
+	default:
+		c.close(fmt.Sprintf("unexpected packet type %d", p.kind))
+		return false
+	}
+}
+
+// publish publishes a PUBLISH to its topic. Failures are logged, since
+// MQTT 3.1.1 has no way to report them.
+func (c *conn) publish(p packet) bool {
+	pub, err := parsePublish(p)
+	if err != nil {
+		c.close("malformed PUBLISH")
+		return false
+	}
+	if pub.qos == 2 {
+		c.close("QoS 2 is not supported")
+		return false
+	}
+
+	topic, err := c.s.topicName(pub.topic)
+	switch {
+	case errors.Is(err, errWildcard):
+		c.close("wildcard in a PUBLISH topic")
+		return false
+	case err != nil:
+		log.Printf("MQTT client %s: dropped publish to %q: %v", c.clientID, pub.topic, err)
+	default:
+		if err := c.ps.Publish(c.ctx, topic, message(pub.payload, pub.retain), c.clientID); err != nil {
+			log.Printf("MQTT client %s: dropped publish to %s: %v", c.clientID, topic, err)
+		} else {
+			c.published++
+			c.ps.RecordTopicTraffic(topic, len(p.body), 0)
+		}
+	}
+
+	if pub.qos == 1 {
+		return c.send(outgoing{data: encodeAck(packetPuback, pub.packetID)})
+	}
+	return true
+}
+
+// subscribe subscribes to each filter of a SUBSCRIBE, granting QoS 0, then
+// sends the retained events of the topics subscribed to
+func (c *conn) subscribe(p packet) bool {
+	packetID, subs, err := parseSubscribe(p)
+	if err != nil {
+		c.close("malformed SUBSCRIBE")
+		return false
+	}
+
+	codes := make([]byte, len(subs))
+	var retained []outgoing
+	for i, sub := range subs {
+		topic, err := c.s.topicName(sub.filter)
+		if err == nil {
+			_, err = c.ps.Subscribe(c.ctx, c.clientID, topic, 0, c)
+		}
+		if err != nil {
+			log.Printf("MQTT client %s: refused subscription to %q: %v", c.clientID, sub.filter, err)
+			codes[i] = subackFailure
+			continue
+		}
+		log.Printf("MQTT client %s subscribed to topic %s", c.clientID, topic)
+		if replay, ok := c.retained(topic, sub.filter); ok {
+			retained = append(retained, replay)
+		}
+	}
+
+	if !c.send(outgoing{data: encodeSuback(packetID, codes)}) {
+		return false
+	}
+	for _, replay := range retained {
+		if !c.send(replay) {
+			return false
+		}
+	}
+	return true
+}
+
+// retained returns the PUBLISH replaying topic's newest retained event, if
+// the topic's history still holds one. An empty retained payload clears
+// what was retained before it.
+func (c *conn) retained(topic, name string) (outgoing, bool) {
+	events, _, err := c.ps.GetTopicMessages(topic, 0, 0, pubsub.TopicHistoryBufferSize, true)
+	if err != nil {
+		return outgoing{}, false
+	}
+	for _, event := range events {
+		if event.Message.Headers[RetainHeader] != "true" {
+			continue
+		}
+		data, err := payload(event.Message.Payload)
+		if err != nil || len(data) == 0 {
+			return outgoing{}, false
+		}
+		return outgoing{data: encodePublish(name, data, true), topic: topic}, true
+	}
+	return outgoing{}, false
+}
+
+// unsubscribe ends the subscriptions of an UNSUBSCRIBE. Filters the client
+// isn't subscribed to are acknowledged all the same.
+func (c *conn) unsubscribe(p packet) bool {
+	packetID, filters, err := parseUnsubscribe(p)
+	if err != nil {
+		c.close("malformed UNSUBSCRIBE")
+		return false
+	}
+	for _, filter := range filters {
+		if topic, err := c.s.topicName(filter); err == nil {
+			c.ps.Unsubscribe(c.ctx, c.clientID, topic)
+		}
+	}
+	return c.send(outgoing{data: encodeAck(packetUnsuback, packetID)})
+}
+
+// send hands a packet to the writer, waiting for it unless the connection
+// is closing
+func (c *conn) send(out outgoing) bool {
+	select {
+	case c.control <- out:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// writePump writes packets and live events until the connection closes
+func (c *conn) writePump() {
+	for {
+		var out outgoing
+		select {
+		case <-c.done:
+			return
+		case out = <-c.control:
+		case event := <-c.events:
+			data, err := payload(event.Message.Payload)
+			if err != nil {
+				log.Printf("MQTT client %s: dropped event %s: %v", c.clientID, event.Message.ID, err)
+				continue
+			}
+			out = outgoing{data: encodePublish(c.s.mqttName(event.Topic), data, false), topic: event.Topic}
+		}
+
+		c.netConn.SetWriteDeadline(time.Now().Add(c.s.opts.WriteWait))
+		if _, err := c.netConn.Write(out.data); err != nil {
+			c.close(fmt.Sprintf("write failed: %v", err))
+			return
+		}
+		if out.topic != "" {
+			c.usage.Sent(1, len(out.data))
+			c.ps.RecordTopicTraffic(out.topic, 0, len(out.data))
+		}
+	}
+}
+
+// close ends the connection for reason, unless it already ended; the
+// reader then cleans up
+func (c *conn) close(reason string) {
+	c.closeOnce.Do(func() {
+		c.reason = reason
+		close(c.done)
+		c.netConn.Close()
+	})
+}
+
+// cleanup removes the client from the system once its connection ended.
+// Only a DISCONNECT withdraws the client's last will.
+func (c *conn) cleanup() {
+	c.ps.DisconnectClient(c.clientID)
+	if c.ps.UnregisterClientIfCurrent(c) {
+		c.ps.ReleaseClientUsage(c.clientID)
+	}
+	if c.disconnect {
+		c.ps.ClearLastWill(c.clientID)
+	} else {
+		c.ps.ReleaseLastWill(c.clientID)
+	}
+	c.ps.Audit(pubsub.AuditRecord{
+		Event:     pubsub.AuditDisconnect,
+		ClientID:  c.clientID,
+		Published: c.published,
+		Reason:    c.reason,
+	})
+	c.ps.ReleaseConnection()
+	c.ps.ReleaseIPConnection(c.remoteIP)
+	log.Printf("MQTT client %s disconnected: %s", c.clientID, c.reason)
+}
+
+func (c *conn) GetClientID() string {
+	return c.clientID
+}
+
+func (c *conn) IsConnected() bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (c *conn) GetLastActive() time.Time {
+	return time.Unix(0, c.lastActive.Load())
+}
+
+// SendMessage queues an event for the client. It never blocks: when the
+// buffer is full the event is dropped, as for a websocket client. MQTT has
+// no frames for infos and errors, so those are not sent.
+func (c *conn) SendMessage(msg interface{}) error {
+	var event pubsub.EventResponse
+	switch m := msg.(type) {
+	case *pubsub.PreparedEvent:
+		event = m.Event
+	case pubsub.EventResponse:
+		event = m
+	default:
+		return nil
+	}
+	if event.Type != "event" {
+		return nil
+	}
+
+	select {
+	case c.events <- event:
+		return nil
+	default:
+		return pubsub.ErrorData{Code: pubsub.CodeServerBusy, Reason: "CLIENT_OVERLOADED", Message: "Client send buffer is full"}
+	}
+}
+
+// CloseGracefully implements pubsub.GracefulCloser. MQTT 3.1.1 has no way
+// to tell a client why, so the connection is just closed.
+func (c *conn) CloseGracefully(reason string) {
+	c.close(reason)
+}
+
+// Kick implements pubsub.Kicker
+func (c *conn) Kick(reason string) {
+	c.CloseGracefully(reason)
+}
@@ -0,0 +1,256 @@
+package mqtt
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+	"github.com/AnshulDekate/pubsub/pkg/transport/ws"
+)
+
+// serve serves MQTT for a system with the given topics and returns the
+// listener's address
+func serve(t *testing.T, opts Options, topics ...string) (*pubsub.PubSubSystem, string) {
+	t.Helper()
+	ps := pubsub.New()
+	t.Cleanup(ps.Close)
+	for _, topic := range topics {
+		if err := ps.CreateTopic(context.Background(), topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(ps, opts)
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+	return ps, l.Addr().String()
+}
+
+// connect connects an MQTT client, failing the test if it is refused
+func connect(t *testing.T, addr string, opts *paho.ClientOptions) paho.Client {
+	t.Helper()
+	client, err := tryConnect(addr, opts)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect(0) })
+	return client
+}
+
+func tryConnect(addr string, opts *paho.ClientOptions) (paho.Client, error) {
+	opts.AddBroker("tcp://" + addr).SetProtocolVersion(4).SetAutoReconnect(false).SetConnectTimeout(5 * time.Second)
+	client := paho.NewClient(opts)
+	token := client.Connect()
+	token.Wait()
+	return client, token.Error()
+}
+
+// subscribe subscribes an MQTT client to topic, returning its messages and
+// the SUBACK return code
+func subscribe(t *testing.T, client paho.Client, topic string) (<-chan paho.Message, byte) {
+	t.Helper()
+	messages := make(chan paho.Message, 16)
+	token := client.Subscribe(topic, 0, func(_ paho.Client, msg paho.Message) { messages <- msg })
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("subscribe %s: %v", topic, token.Error())
+	}
+	return messages, token.(*paho.SubscribeToken).Result()[topic]
+}
+
+func nextMessage(t *testing.T, messages <-chan paho.Message) paho.Message {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no MQTT message was delivered")
+		return nil
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// wsFrame is the part of a websocket frame the tests look at
+type wsFrame struct {
+	Type    string             `json:"type"`
+	Topic   string             `json:"topic"`
+	Message pubsub.MessageData `json:"message"`
+}
+
+// dialWebSocket connects a websocket client to ps subscribed to topic
+func dialWebSocket(t *testing.T, ps *pubsub.PubSubSystem, topic string) *websocket.Conn {
+	t.Helper()
+	server := httptest.NewServer(ws.HandleWebSocket(ps))
+	t.Cleanup(server.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := conn.WriteJSON(map[string]interface{}{"type": "subscribe", "topic": topic, "request_id": "s-1"}); err != nil {
+		t.Fatal(err)
+	}
+	for nextFrame(t, conn).Type != "ack" {
+	}
+	return conn
+}
+
+// nextFrame returns the next websocket frame, skipping the welcome
+func nextFrame(t *testing.T, conn *websocket.Conn) wsFrame {
+	t.Helper()
+	for {
+		var frame wsFrame
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatalf("reading websocket frame: %v", err)
+		}
+		if frame.Type != "welcome" {
+			return frame
+		}
+	}
+}
+
+func TestMQTTAndWebSocketClientsShareTopics(t *testing.T) {
+	ps, addr := serve(t, Options{}, "sensors.temp")
+	device := connect(t, addr, paho.NewClientOptions().SetClientID("device-1"))
+	messages, code := subscribe(t, device, "sensors/temp")
+	if code != 0 {
+		t.Fatalf("SUBACK return code %#x", code)
+	}
+	browser := dialWebSocket(t, ps, "sensors.temp")
+
+	// An MQTT publish reaches websocket subscribers, JSON as its value
+	token := device.Publish("sensors/temp", 1, false, `{"celsius":21.5}`)
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("QoS 1 publish wasn't acknowledged: %v", token.Error())
+	}
+	frame := nextFrame(t, browser)
+	if payload, _ := frame.Message.Payload.(map[string]interface{}); frame.Type != "event" || frame.Topic != "sensors.temp" || payload["celsius"] != 21.5 {
+		t.Errorf("websocket client got %+v", frame)
+	}
+	if msg := nextMessage(t, messages); string(msg.Payload()) != `{"celsius":21.5}` {
+		t.Errorf("MQTT subscriber got %s", msg.Payload())
+	}
+
+	// A websocket publish reaches MQTT subscribers, a string as its bytes
+	err := browser.WriteJSON(map[string]interface{}{
+		"type":       "publish",
+		"topic":      "sensors.temp",
+		"message":    map[string]interface{}{"id": uuid.NewString(), "payload": "calibrating"},
+		"request_id": "p-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := nextMessage(t, messages)
+	if msg.Topic() != "sensors/temp" || string(msg.Payload()) != "calibrating" || msg.Retained() {
+		t.Errorf("MQTT subscriber got %s on %s (retained %v)", msg.Payload(), msg.Topic(), msg.Retained())
+	}
+
+	// Both publishes are in the shared history and counted for the device
+	history, _, err := ps.GetTopicMessages("sensors.temp", 0, 0, 10, false)
+	if err != nil || len(history) != 2 {
+		t.Fatalf("history = %+v, %v", history, err)
+	}
+	waitFor(t, "device-1's usage", func() bool {
+		usage, _ := ps.GetClientUsage("device-1")
+		return usage.MessagesSent == 2
+	})
+}
+
+func TestUnsupportedFiltersAreRefused(t *testing.T) {
+	_, addr := serve(t, Options{}, "sensors.temp")
+	device := connect(t, addr, paho.NewClientOptions().SetClientID("device-1"))
+	for _, filter := range []string{"sensors/+", "sensors/#", "sensors/missing", "sensors.temp"} {
+		if _, code := subscribe(t, device, filter); code != subackFailure {
+			t.Errorf("SUBACK return code for %s = %#x", filter, code)
+		}
+	}
+
+	// The connection is still usable
+	if _, code := subscribe(t, device, "sensors/temp"); code != 0 {
+		t.Errorf("SUBACK return code %#x", code)
+	}
+}
+
+func TestRetainedPublishIsReplayedToNewSubscribers(t *testing.T) {
+	ps, addr := serve(t, Options{TopicSeparator: "_"}, "lamp_state")
+	device := connect(t, addr, paho.NewClientOptions().SetClientID("lamp"))
+	for _, publish := range []struct {
+		payload string
+		retain  bool
+	}{{"on", true}, {"flicker", false}} {
+		if token := device.Publish("lamp/state", 0, publish.retain, publish.payload); !token.WaitTimeout(5 * time.Second) {
+			t.Fatal("publish timed out")
+		}
+	}
+
+	waitFor(t, "both publishes", func() bool {
+		history, _, _ := ps.GetTopicMessages("lamp_state", 0, 0, 10, false)
+		return len(history) == 2
+	})
+
+	// The newest retained event is replayed, not the newest event
+	watcher := connect(t, addr, paho.NewClientOptions().SetClientID("watcher"))
+	messages, _ := subscribe(t, watcher, "lamp/state")
+	if msg := nextMessage(t, messages); string(msg.Payload()) != "on" || !msg.Retained() {
+		t.Errorf("replayed %s (retained %v)", msg.Payload(), msg.Retained())
+	}
+}
+
+func TestClientIDsAndWills(t *testing.T) {
+	ps, addr := serve(t, Options{}, "devices.status")
+	watcher := connect(t, addr, paho.NewClientOptions().SetClientID("watcher"))
+	messages, _ := subscribe(t, watcher, "devices/status")
+
+	// A client ID in use is refused rather than taken over
+	opts := paho.NewClientOptions().SetClientID("device-1").SetWill("devices/status", "device-1 offline", 0, false)
+	device := connect(t, addr, opts)
+	if _, err := tryConnect(addr, paho.NewClientOptions().SetClientID("device-1")); err == nil || !strings.Contains(err.Error(), "identifier rejected") {
+		t.Errorf("second connection as device-1: %v", err)
+	}
+
+	// An empty client ID with a clean session gets a generated one
+	anonymous := connect(t, addr, paho.NewClientOptions())
+	if _, code := subscribe(t, anonymous, "devices/status"); code != 0 {
+		t.Errorf("SUBACK return code %#x", code)
+	}
+
+	// A dropped connection publishes its will; a clean disconnect doesn't
+	if !ps.KickClient("device-1", "maintenance") {
+		t.Fatal("device-1 couldn't be kicked")
+	}
+	if msg := nextMessage(t, messages); string(msg.Payload()) != "device-1 offline" {
+		t.Errorf("will published %s", msg.Payload())
+	}
+	waitFor(t, "device-1 to go", func() bool { return !device.IsConnectionOpen() })
+
+	opts = paho.NewClientOptions().SetClientID("device-2").SetWill("devices/status", "device-2 offline", 0, false)
+	connect(t, addr, opts).Disconnect(100)
+	if err := ps.Publish(context.Background(), "devices.status", pubsub.MessageData{ID: uuid.NewString(), Payload: "marker"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if msg := nextMessage(t, messages); string(msg.Payload()) != "marker" {
+		t.Errorf("after a clean disconnect got %s", msg.Payload())
+	}
+}
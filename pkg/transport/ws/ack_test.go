@@ -0,0 +1,175 @@
+package ws
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// paymentsServer serves a broker with a "payments" topic and the given
+// explicit-ack policy
+func paymentsServer(t *testing.T, timeout time.Duration, window int) (*pubsub.PubSubSystem, *httptest.Server) {
+	t.Helper()
+	ps := pubsub.New()
+	ps.SetAckPolicy(timeout, window)
+	if err := ps.CreateTopic(context.Background(), "payments"); err != nil {
+		t.Fatal(err)
+	}
+	return ps, serve(t, ps, WebSocketOptions{})
+}
+
+// subscribeExplicit subscribes c to "payments" in explicit-ack mode, as
+// client_id consumer
+func (c *wireClient) subscribeExplicit(consumer string) {
+	c.t.Helper()
+	c.send(map[string]interface{}{"type": "subscribe", "topic": "payments", "client_id": consumer, "ack_mode": "explicit", "request_id": "s-1"})
+	c.expect("ack")
+}
+
+// msgAck acknowledges a delivery tag, or with upToSeq every event up to it
+func (c *wireClient) msgAck(requestID string, tag, upToSeq int64) {
+	c.t.Helper()
+	c.send(map[string]interface{}{"type": "msg_ack", "topic": "payments", "delivery_tag": tag, "up_to_seq": upToSeq, "request_id": requestID})
+	c.expect("ack")
+}
+
+// expectDelivery reads the next event and checks its sequence number and
+// whether it is a redelivery, returning its delivery tag
+func (c *wireClient) expectDelivery(seq int64, redelivered bool) int64 {
+	c.t.Helper()
+	event := c.expect("event")
+	if event["seq"] != float64(seq) || (event["redelivered"] == true) != redelivered {
+		c.t.Fatalf("got event %v, want seq %d redelivered %v", event, seq, redelivered)
+	}
+	tag, _ := event["delivery_tag"].(float64)
+	if tag == 0 {
+		c.t.Fatalf("event %v has no delivery tag", event)
+	}
+	return int64(tag)
+}
+
+func publishPayments(t *testing.T, ps *pubsub.PubSubSystem, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := ps.Publish(context.Background(), "payments", pubsub.MessageData{ID: uuid.New().String(), Payload: i}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func unacked(ps *pubsub.PubSubSystem, consumer string) int {
+	return ps.GetStats().Unacked[consumer]
+}
+
+func TestExplicitAckAndTimeoutRedelivery(t *testing.T) {
+	ps, server := paymentsServer(t, 300*time.Millisecond, 100)
+	c := dialCodec(t, server, pubsub.JSONCodec)
+	c.subscribeExplicit("ledger")
+
+	publishPayments(t, ps, 3)
+	tags := []int64{c.expectDelivery(1, false), c.expectDelivery(2, false), c.expectDelivery(3, false)}
+	if got := unacked(ps, "ledger"); got != 3 {
+		t.Fatalf("%d unacked, want 3", got)
+	}
+
+	// One by tag, then cumulatively up to seq 2
+	c.msgAck("a-1", tags[0], 0)
+	c.msgAck("a-2", 0, 2)
+	if got := unacked(ps, "ledger"); got != 1 {
+		t.Fatalf("%d unacked after acking, want 1", got)
+	}
+
+	// Only the unacked one comes back after the timeout, on the next sweep
+	if tag := c.expectDelivery(3, true); tag != tags[2] {
+		t.Errorf("redelivered with tag %d, want %d", tag, tags[2])
+	}
+	c.msgAck("a-3", tags[2], 0)
+	if got := unacked(ps, "ledger"); got != 0 {
+		t.Errorf("%d unacked after acking everything", got)
+	}
+
+	// Nothing more is redelivered
+	time.Sleep(2 * time.Second)
+	publishPayments(t, ps, 1)
+	c.expectDelivery(4, false)
+}
+
+func TestExplicitAckWindowPausesDelivery(t *testing.T) {
+	ps, server := paymentsServer(t, time.Hour, 2)
+	c := dialCodec(t, server, pubsub.JSONCodec)
+	c.subscribeExplicit("ledger")
+
+	publishPayments(t, ps, 4)
+	first := c.expectDelivery(1, false)
+	c.expectDelivery(2, false)
+	if got := unacked(ps, "ledger"); got != 2 {
+		t.Fatalf("%d unacked with a window of 2", got)
+	}
+
+	// Each ack lets the next waiting event through, in order
+	c.msgAck("a-1", first, 0)
+	c.expectDelivery(3, false)
+	c.msgAck("a-2", 0, 3)
+	c.expectDelivery(4, false)
+}
+
+func TestExplicitAckRedeliversOnReconnect(t *testing.T) {
+	ps, server := paymentsServer(t, time.Hour, 100)
+	first := dialCodec(t, server, pubsub.JSONCodec)
+	first.subscribeExplicit("ledger")
+	publishPayments(t, ps, 2)
+	tag := first.expectDelivery(1, false)
+	first.expectDelivery(2, false)
+	first.msgAck("a-1", tag, 0)
+	first.conn.Close()
+	waitFor(t, "the first connection to go", func() bool {
+		detail, _ := ps.GetTopicDetail("payments")
+		return detail.Subscribers == 0
+	})
+
+	// Published while the consumer was away
+	publishPayments(t, ps, 1)
+
+	second := dialCodec(t, server, pubsub.JSONCodec)
+	second.subscribeExplicit("ledger")
+	second.expectDelivery(2, true)
+	second.expectDelivery(3, false)
+	if got := unacked(ps, "ledger"); got != 2 {
+		t.Errorf("%d unacked after resuming, want 2", got)
+	}
+}
+
+func TestPublishReceipts(t *testing.T) {
+	ps, server := paymentsServer(t, time.Hour, 100)
+	ledger, audit := dialCodec(t, server, pubsub.JSONCodec), dialCodec(t, server, pubsub.JSONCodec)
+	ledger.subscribeExplicit("ledger")
+	audit.subscribeExplicit("audit")
+
+	publisher := dialV2(t, server)
+	messageID := uuid.New().String()
+	publisher.send(map[string]interface{}{"type": "publish", "topic": "payments", "client_id": "announcer", "receipts": true, "request_id": "p-1",
+		"message": map[string]interface{}{"id": messageID, "payload": "hello"}})
+	publisher.expect("ack")
+
+	ledger.msgAck("a-1", ledger.expectDelivery(1, false), 0)
+	receipt := publisher.expect("receipt")
+	updates, _ := receipt["receipts"].([]interface{})
+	if len(updates) != 1 {
+		t.Fatalf("receipt = %v", receipt)
+	}
+	update := updates[0].(map[string]interface{})
+	if update["topic"] != "payments" || update["message_id"] != messageID || update["client_id"] != "ledger" || update["acked"] != float64(1) || update["expected"] != float64(2) {
+		t.Errorf("receipt update = %v", update)
+	}
+
+	audit.expectDelivery(1, false)
+	receipts, err := ps.MessageReceipts("payments", messageID)
+	if err != nil || len(receipts.Acked) != 1 || len(receipts.Pending) != 1 || receipts.Pending[0] != "audit" {
+		t.Errorf("receipts = %+v, %v", receipts, err)
+	}
+}
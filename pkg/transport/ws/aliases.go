@@ -0,0 +1,112 @@
+package ws
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// capabilityTopicAliases in a hello has events name their topic by a small
+// number instead of its name. Each subscribe ack carries the topic's
+// alias as topic_alias; a subscribe with "full_topic": true keeps the full
+// name for that topic's events.
+const capabilityTopicAliases = "topic_aliases"
+
+// topicAliases numbers the topics of a connection that negotiated topic
+// aliases. A topic keeps its alias for the life of the connection, across
+// unsubscribes, and a new connection starts over from 1.
+type topicAliases struct {
+	enabled atomic.Bool
+
+	mutex   sync.Mutex
+	byTopic map[string]int  // Internal topic name -> alias
+	full    map[string]bool // Topics last subscribed with full_topic
+
+	// Aliases already sent on an event (writePump only). The ack naming an
+	// alias may be overtaken by the topic's first events, so the first
+	// event carrying an alias also carries the name.
+	announced map[int]bool
+}
+
+// assign returns topic's alias for a subscribe, numbering it if it has
+// none, or 0 if the connection didn't negotiate aliases or asked for the
+// full name
+func (a *topicAliases) assign(topic string, full bool) int {
+	if !a.enabled.Load() {
+		return 0
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if full {
+		if a.full == nil {
+			a.full = make(map[string]bool)
+		}
+		a.full[topic] = true
+		return 0
+	}
+	delete(a.full, topic)
+	if a.byTopic == nil {
+		a.byTopic = make(map[string]int)
+	}
+	alias, exists := a.byTopic[topic]
+	if !exists {
+		alias = len(a.byTopic) + 1
+		a.byTopic[topic] = alias
+	}
+	return alias
+}
+
+// lookup returns the alias an event for topic is sent with, or 0 for the
+// full name
+func (a *topicAliases) lookup(topic string) int {
+	if topic == "" || !a.enabled.Load() {
+		return 0
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.full[topic] {
+		return 0
+	}
+	return a.byTopic[topic]
+}
+
+// aliasedEvent is an event naming its topic by alias as "ta". Topic is
+// only set on the first event with the alias.
+type aliasedEvent struct {
+	pubsub.EventResponse
+	TopicAlias int `json:"ta"`
+}
+
+// MarshalJSON leaves out the blank topic name, which the event would send
+// as ""; MessagePack leaves out empty fields anyway
+func (e aliasedEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		pubsub.EventResponse
+		Topic      string `json:"topic,omitempty"`
+		TopicAlias int    `json:"ta"`
+	}{e.EventResponse, e.Topic, e.TopicAlias})
+}
+
+// aliased returns an event frame's message naming its topic by alias.
+// Only called by writePump.
+func (c *Client) aliased(frame outboundFrame, alias int) aliasedEvent {
+	var event pubsub.EventResponse
+	if frame.prepared != nil {
+		event = pubsub.LocalizeMessage(frame.prepared.Event).(pubsub.EventResponse)
+	} else {
+		event, _ = frame.message.(pubsub.EventResponse)
+	}
+
+	if c.aliases.announced[alias] {
+		event.Topic = ""
+	} else {
+		if c.aliases.announced == nil {
+			c.aliases.announced = make(map[int]bool)
+		}
+		c.aliases.announced[alias] = true
+	}
+	return aliasedEvent{EventResponse: event, TopicAlias: alias}
+}
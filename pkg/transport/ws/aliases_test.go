@@ -0,0 +1,116 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// rawEvent reads frames until an event and returns its bytes
+func rawEvent(t *testing.T, c *wireClient) (map[string]interface{}, int) {
+	t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for an event: %v", err)
+		}
+		var frame map[string]interface{}
+		if err := json.Unmarshal(data, &frame); err != nil {
+			t.Fatal(err)
+		}
+		if frame["type"] == "event" {
+			return frame, len(data)
+		}
+	}
+}
+
+func TestTopicAliases(t *testing.T) {
+	ps := pubsub.New()
+	topics := []string{"tenant-42/conversations/7f9a0c1e/events", "tenant-42/conversations/b2d4e6f8/events"}
+	for _, topic := range topics {
+		if err := ps.CreateTopic(context.Background(), topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := serve(t, ps, WebSocketOptions{})
+	aliased := dialV2(t, server, capabilityTopicAliases)
+	plain := dialV2(t, server)
+
+	subscribe := func(c *wireClient, topic string, extra map[string]interface{}) map[string]interface{} {
+		t.Helper()
+		req := map[string]interface{}{"type": "subscribe", "topic": topic, "request_id": uuid.NewString()}
+		for k, v := range extra {
+			req[k] = v
+		}
+		c.send(req)
+		return c.expect("ack")
+	}
+	publish := func(topic string) {
+		t.Helper()
+		if err := ps.Publish(context.Background(), topic, pubsub.MessageData{ID: uuid.NewString(), Payload: "hi"}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	aliases := map[string]float64{}
+	for _, topic := range topics {
+		if ack := subscribe(plain, topic, nil); ack["topic_alias"] != nil {
+			t.Errorf("ack without the capability = %v", ack)
+		}
+		alias, _ := subscribe(aliased, topic, nil)["topic_alias"].(float64)
+		aliases[topic] = alias
+	}
+	if a, b := aliases[topics[0]], aliases[topics[1]]; a == 0 || b == 0 || a == b {
+		t.Fatalf("aliases = %v, want distinct ones", aliases)
+	}
+
+	for round := 0; round < 3; round++ {
+		for _, topic := range topics {
+			publish(topic)
+			full, fullSize := rawEvent(t, plain)
+			if full["topic"] != topic || full["ta"] != nil {
+				t.Fatalf("event without the capability = %v", full)
+			}
+			event, size := rawEvent(t, aliased)
+			if event["ta"] != aliases[topic] {
+				t.Fatalf("round %d: event for %s = %v, want alias %v", round, topic, event, aliases[topic])
+			}
+			// The first event with an alias names the topic too, in case it
+			// overtakes the ack
+			if round == 0 {
+				if event["topic"] != topic {
+					t.Errorf("first aliased event = %v", event)
+				}
+				continue
+			}
+			if _, named := event["topic"]; named || size >= fullSize-len(topic)+10 {
+				t.Errorf("round %d: aliased event is %d bytes against %d: %v", round, size, fullSize, event)
+			}
+		}
+	}
+
+	// Subscribing again with full_topic falls back to the name, and
+	// without it returns to the same alias
+	if ack := subscribe(aliased, topics[0], map[string]interface{}{"full_topic": true}); ack["topic_alias"] != nil {
+		t.Errorf("ack for full_topic = %v", ack)
+	}
+	publish(topics[0])
+	if event, _ := rawEvent(t, aliased); event["topic"] != topics[0] || event["ta"] != nil {
+		t.Errorf("event after full_topic = %v", event)
+	}
+	if ack := subscribe(aliased, topics[0], nil); ack["topic_alias"] != aliases[topics[0]] {
+		t.Errorf("alias after subscribing again = %v, want %v", ack["topic_alias"], aliases[topics[0]])
+	}
+
+	// Aliases are numbered afresh on a new connection
+	again := dialV2(t, server, capabilityTopicAliases)
+	if ack := subscribe(again, topics[1], nil); ack["topic_alias"] != float64(1) {
+		t.Errorf("first alias on a new connection = %v", ack["topic_alias"])
+	}
+}
@@ -0,0 +1,234 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// batchFrame is one websocket frame's messages and whether it was an array
+type batchFrame struct {
+	array    bool
+	messages []map[string]interface{}
+}
+
+func readBatchFrame(t testing.TB, conn *websocket.Conn) batchFrame {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var frame batchFrame
+	if frame.array = data[0] == '['; frame.array {
+		err = json.Unmarshal(data, &frame.messages)
+	} else {
+		var message map[string]interface{}
+		err = json.Unmarshal(data, &message)
+		frame.messages = append(frame.messages, message)
+	}
+	if err != nil {
+		t.Fatalf("decoding %s: %v", data, err)
+	}
+	return frame
+}
+
+// subscribeOrders subscribes conn to "orders", in batch mode if batch is
+// set, and waits for the ack
+func subscribeOrders(t testing.TB, conn *websocket.Conn, batch bool) {
+	t.Helper()
+	conn.WriteJSON(map[string]interface{}{"type": "subscribe", "topic": "orders", "request_id": "s-1", "batch": batch})
+	for {
+		for _, message := range readBatchFrame(t, conn).messages {
+			if message["type"] == "ack" {
+				return
+			}
+		}
+	}
+}
+
+func ordersServer(t testing.TB) (*pubsub.PubSubSystem, string) {
+	t.Helper()
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(HandleWebSocket(ps))
+	t.Cleanup(server.Close)
+	return ps, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func dialURL(t testing.TB, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	readBatchFrame(t, conn) // welcome
+	return conn
+}
+
+func publishOrders(t testing.TB, ps *pubsub.PubSubSystem, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := ps.Publish(context.Background(), "orders", pubsub.MessageData{ID: fmt.Sprintf("m%d", i), Payload: i}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBatchFramesKeepOrder(t *testing.T) {
+	ps, url := ordersServer(t)
+	batched, plain := dialURL(t, url), dialURL(t, url)
+	subscribeOrders(t, batched, true)
+	subscribeOrders(t, plain, false)
+
+	// Fewer than a send buffer holds, so none are dropped
+	const events = 200
+	publishOrders(t, ps, events)
+
+	// Every frame to the batch client is an array, together holding every
+	// event in order, several to a frame
+	frames, next := 0, 1.0
+	for next <= events {
+		frame := readBatchFrame(t, batched)
+		if !frame.array || len(frame.messages) > maxBatchMessages {
+			t.Fatalf("batch client got a frame of %d messages, array %v", len(frame.messages), frame.array)
+		}
+		for _, message := range frame.messages {
+			if message["type"] != "event" || message["seq"] != next {
+				t.Fatalf("batch client got %v, want seq %v", message, next)
+			}
+			next++
+		}
+		frames++
+	}
+	if frames == events {
+		t.Errorf("%d events arrived in %d frames, none coalesced", events, frames)
+	}
+
+	// The other client still gets one object per frame
+	for seq := 1.0; seq <= events; seq++ {
+		frame := readBatchFrame(t, plain)
+		if frame.array || frame.messages[0]["seq"] != seq {
+			t.Fatalf("plain client got %v, array %v, want seq %v", frame.messages, frame.array, seq)
+		}
+	}
+}
+
+func TestBatchCapabilityInHello(t *testing.T) {
+	ps, url := ordersServer(t)
+	conn := dialURL(t, url)
+	conn.WriteJSON(map[string]interface{}{"type": "hello", "protocol_version": 1, "capabilities": []string{capabilityBatch}, "request_id": "h-1"})
+	if frame := readBatchFrame(t, conn); !frame.array {
+		t.Fatalf("hello with the batch capability answered with %v", frame.messages)
+	}
+	subscribeOrders(t, conn, false)
+
+	publishOrders(t, ps, 1)
+	if frame := readBatchFrame(t, conn); !frame.array || frame.messages[0]["type"] != "event" {
+		t.Errorf("event after opting in by hello = %v, array %v", frame.messages, frame.array)
+	}
+}
+
+// BenchmarkFirehose measures delivering events to one subscriber as fast
+// as they are published, one frame each or coalesced into batches. Events
+// are published in bursts smaller than the send buffer, each waiting for
+// the last to arrive, so none are dropped.
+func BenchmarkFirehose(b *testing.B) {
+	for _, batch := range []bool{false, true} {
+		b.Run(fmt.Sprintf("batch=%v", batch), func(b *testing.B) {
+			ps, url := ordersServer(b)
+			conn := dialURL(b, url)
+			subscribeOrders(b, conn, batch)
+
+			const burst = 128
+			frames := 0
+			b.ResetTimer()
+			for sent := 0; sent < b.N; {
+				n := burst
+				if n > b.N-sent {
+					n = b.N - sent
+				}
+				for i := 0; i < n; i++ {
+					if err := ps.Publish(context.Background(), "orders", pubsub.MessageData{ID: fmt.Sprint(sent + i), Payload: i}, ""); err != nil {
+						b.Fatal(err)
+					}
+				}
+				sent += n
+				for received := 0; received < n; frames++ {
+					received += len(readBatchFrame(b, conn).messages)
+				}
+			}
+			b.ReportMetric(float64(b.N)/float64(frames), "events/frame")
+		})
+	}
+}
+
+// TestReplayThroughScratchBuffers replays events encoded one after another
+// into a connection's reused buffer, with one large enough that the buffer
+// is replaced, and checks every frame arrives intact
+func TestReplayThroughScratchBuffers(t *testing.T) {
+	ps, url := ordersServer(t)
+	const events = 150
+	for i := 0; i < events; i++ {
+		payload := map[string]interface{}{"n": i, "pad": strings.Repeat("x", i%7)}
+		if i == 70 {
+			payload["pad"] = strings.Repeat("y", maxScratchBytes-1024)
+		}
+		if err := ps.Publish(context.Background(), "orders", pubsub.MessageData{ID: fmt.Sprint(i), Payload: payload}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, batch := range []bool{false, true} {
+		conn := dialURL(t, url)
+		conn.WriteJSON(map[string]interface{}{"type": "subscribe", "topic": "orders", "request_id": "s-1", "batch": batch, "last_n": events})
+		next := 1.0
+		for next <= events {
+			for _, message := range readBatchFrame(t, conn).messages {
+				if message["type"] != "event" {
+					continue
+				}
+				n := next - 1
+				body, _ := message["message"].(map[string]interface{})
+				payload, _ := body["payload"].(map[string]interface{})
+				pad, _ := payload["pad"].(string)
+				wantPad := int(n) % 7
+				if n == 70 {
+					wantPad = maxScratchBytes - 1024
+				}
+				if message["seq"] != next || body["id"] != fmt.Sprint(n) || payload["n"] != n || len(pad) != wantPad {
+					t.Fatalf("batch %v: event %v = seq %v id %v n %v pad %d", batch, next, message["seq"], body["id"], payload["n"], len(pad))
+				}
+				next++
+			}
+		}
+	}
+}
+
+// BenchmarkEncodeReply encodes a reply for one client into a fresh slice
+// and into the connection's reused buffer
+func BenchmarkEncodeReply(b *testing.B) {
+	c := &Client{codec: pubsub.JSONCodec}
+	frame := outboundFrame{message: pubsub.AckResponse{Type: "ack", RequestID: "r-1", Topic: "orders", Status: "ok"}}
+	for _, scratch := range []bool{false, true} {
+		b.Run(fmt.Sprintf("scratch=%v", scratch), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := c.encode(frame, scratch); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
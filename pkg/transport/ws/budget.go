@@ -0,0 +1,32 @@
+package ws
+
+import "time"
+
+// errorBudget counts a connection's protocol errors within a sliding
+// window. Only used by readPump.
+type errorBudget struct {
+	limit  int
+	window time.Duration
+	errors []time.Time // Oldest first, all within the window
+}
+
+// newErrorBudget allows fewer than limit errors per window
+func newErrorBudget(limit int, window time.Duration) *errorBudget {
+	return &errorBudget{limit: limit, window: window}
+}
+
+// fail records an error at now and reports whether the budget is spent
+func (b *errorBudget) fail(now time.Time) bool {
+	expired := 0
+	for expired < len(b.errors) && now.Sub(b.errors[expired]) >= b.window {
+		expired++
+	}
+	b.errors = append(b.errors[:0], b.errors[expired:]...)
+	b.errors = append(b.errors, now)
+	return len(b.errors) >= b.limit
+}
+
+// reset forgets every error, after a request that succeeded
+func (b *errorBudget) reset() {
+	b.errors = b.errors[:0]
+}
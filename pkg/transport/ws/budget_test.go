@@ -0,0 +1,101 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+func TestInvalidRequestsExhaustErrorBudget(t *testing.T) {
+	ps := pubsub.New()
+	server := serve(t, ps, WebSocketOptions{ErrorBudget: 4})
+	conn, _ := dial(t, server, "", nil)
+	conn.WriteJSON(map[string]interface{}{"type": "hello", "protocol_version": 2, "request_id": "h-1"})
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	readCode := func() interface{} {
+		t.Helper()
+		var frame map[string]interface{}
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatalf("reading answer: %v", err)
+		}
+		if frame["type"] != "error" {
+			return frame["type"]
+		}
+		return frame["error"].(map[string]interface{})["code"]
+	}
+	if kind := readCode(); kind != "hello_ack" {
+		t.Fatalf("hello answered with %v", kind)
+	}
+
+	invalid := []struct {
+		frame string
+		code  string
+	}{
+		{`{"type": "subscribe", "topic": `, "VALIDATION_FAILED"},
+		{`{"type": "shout"}`, "VALIDATION_FAILED"},
+		{`{"type": "publish", "topic": "orders", "request_id": "p-1", "message": {"id": "not-a-uuid"}}`, "VALIDATION_FAILED"},
+	}
+	send := func(i int) {
+		t.Helper()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(invalid[i%len(invalid)].frame)); err != nil {
+			t.Fatal(err)
+		}
+		if code := readCode(); code != invalid[i%len(invalid)].code {
+			t.Fatalf("invalid request %d answered with %v, want %s", i, code, invalid[i%len(invalid)].code)
+		}
+	}
+
+	// A valid request in between starts the count over
+	for i := 0; i < 3; i++ {
+		send(i)
+	}
+	conn.WriteJSON(map[string]interface{}{"type": "ping", "request_id": "ping-1"})
+	if kind := readCode(); kind != "pong" {
+		t.Fatalf("ping answered with %v", kind)
+	}
+	for i := 0; i < 3; i++ {
+		send(i)
+	}
+	if disconnects := ps.GetStats().WebSocket.ErrorDisconnects; disconnects != 0 {
+		t.Fatalf("error disconnects = %d before the budget was spent", disconnects)
+	}
+
+	// The fourth in a row spends it: its own error, the final one, then
+	// the close, and nothing the client sends is answered
+	send(3)
+	if code := readCode(); code != "RATE_LIMITED" {
+		t.Fatalf("final error = %v, want TOO_MANY_ERRORS", code)
+	}
+	conn.WriteJSON(map[string]interface{}{"type": "ping", "request_id": "ping-2"})
+	_, data, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, CloseTooManyErrors) {
+		t.Fatalf("after the final error got %s, %v; want close %d", data, err, CloseTooManyErrors)
+	}
+	if disconnects := ps.GetStats().WebSocket.ErrorDisconnects; disconnects != 1 {
+		t.Errorf("error disconnects = %d, want 1", disconnects)
+	}
+}
+
+func TestErrorBudgetWindow(t *testing.T) {
+	start := time.Date(2025, 8, 25, 10, 0, 0, 0, time.UTC)
+	budget := newErrorBudget(3, time.Minute)
+
+	// Errors older than the window no longer count
+	if budget.fail(start) || budget.fail(start.Add(30*time.Second)) {
+		t.Fatal("budget spent after two errors")
+	}
+	if budget.fail(start.Add(time.Minute)) {
+		t.Fatal("budget spent by an error outside the window")
+	}
+	if !budget.fail(start.Add(80 * time.Second)) {
+		t.Fatal("budget not spent by three errors within the window")
+	}
+
+	budget.reset()
+	if budget.fail(start.Add(90*time.Second)) || budget.fail(start.Add(100*time.Second)) {
+		t.Error("budget spent after a reset and two errors")
+	}
+}
@@ -0,0 +1,283 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/AnshulDekate/pubsub/pkg/clock"
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// capabilityChunking in a hello lets the connection publish in chunks and
+// receive oversized events in chunks
+const capabilityChunking = "chunking"
+
+// Room left in each chunk frame for its envelope (type, message_id, index)
+const chunkEnvelope = 128
+
+// chunkedUpload is a chunked publish being reassembled
+type chunkedUpload struct {
+	begin pubsub.ChunkBegin
+	data  []byte
+	next  int         // Index of the chunk expected next
+	timer clock.Timer // Drops the upload once it is abandoned
+}
+
+// uploads holds a connection's chunked publishes in progress. Chunks are
+// added by readPump; abandoned uploads are dropped by their timers.
+type uploads struct {
+	maxBytes int           // Announced bytes allowed in progress at once
+	timeout  time.Duration // Longest wait for the next chunk
+	clock    clock.Clock   // Runs the timeout timers
+	expired  func()        // Called for each upload dropped by its timer
+
+	mutex    sync.Mutex
+	byID     map[string]*chunkedUpload
+	reserved int // Announced bytes of the uploads in progress
+}
+
+// newUploads creates an empty set of uploads
+func newUploads(maxBytes int, timeout time.Duration, clock clock.Clock, expired func()) *uploads {
+	return &uploads{
+		maxBytes: maxBytes,
+		timeout:  timeout,
+		clock:    clock,
+		expired:  expired,
+		byID:     make(map[string]*chunkedUpload),
+	}
+}
+
+// begin starts an upload, reserving its announced size
+func (u *uploads) begin(req pubsub.ChunkBegin) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if _, exists := u.byID[req.MessageID]; exists {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "message " + req.MessageID + " is already being uploaded"}
+	}
+	if u.reserved+req.TotalSize > u.maxBytes {
+		return pubsub.ErrorData{
+			Code:    pubsub.CodePayloadTooLarge,
+			Reason:  "CHUNKED_TOO_LARGE",
+			Message: fmt.Sprintf("%d bytes would exceed the %d bytes of chunked publishes allowed in progress", req.TotalSize, u.maxBytes),
+			Limit:   u.maxBytes,
+		}
+	}
+
+	upload := &chunkedUpload{begin: req, data: make([]byte, 0, req.TotalSize)}
+	upload.timer = u.clock.AfterFunc(u.timeout, func() { u.expire(req.MessageID, upload) })
+	u.byID[req.MessageID] = upload
+	u.reserved += req.TotalSize
+	return nil
+}
+
+// add appends a chunk to its upload. A chunk out of order or beyond the
+// announced size fails the whole upload. The begin request is returned for
+// answering either way.
+func (u *uploads) add(chunk pubsub.Chunk) (pubsub.ChunkBegin, error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	upload, exists := u.byID[chunk.MessageID]
+	if !exists {
+		return pubsub.ChunkBegin{}, pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Reason: "UNKNOWN_UPLOAD", Message: "no chunked publish of message " + chunk.MessageID + " is in progress"}
+	}
+	switch {
+	case chunk.Index != upload.next:
+		u.remove(chunk.MessageID, upload)
+		return upload.begin, pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Reason: "CHUNK_OUT_OF_ORDER", Message: fmt.Sprintf("got chunk %d, expected %d", chunk.Index, upload.next)}
+	case chunk.Index >= upload.begin.Chunks || len(upload.data)+len(chunk.Data) > upload.begin.TotalSize:
+		u.remove(chunk.MessageID, upload)
+		return upload.begin, pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Reason: "CHUNK_SIZE_MISMATCH", Message: fmt.Sprintf("chunks exceed the announced %d chunks of %d bytes", upload.begin.Chunks, upload.begin.TotalSize)}
+	}
+	upload.data = append(upload.data, chunk.Data...)
+	upload.next++
+	upload.timer.Reset(u.timeout)
+	return upload.begin, nil
+}
+
+// end completes an upload, returning its begin request and data, provided
+// every announced chunk and byte arrived
+func (u *uploads) end(messageID string) (pubsub.ChunkBegin, []byte, error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	upload, exists := u.byID[messageID]
+	if !exists {
+		return pubsub.ChunkBegin{}, nil, pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Reason: "UNKNOWN_UPLOAD", Message: "no chunked publish of message " + messageID + " is in progress"}
+	}
+	u.remove(messageID, upload)
+	if upload.next != upload.begin.Chunks || len(upload.data) != upload.begin.TotalSize {
+		return upload.begin, nil, pubsub.ErrorData{
+			Code:    pubsub.CodeValidationFailed,
+			Reason:  "CHUNK_SIZE_MISMATCH",
+			Message: fmt.Sprintf("got %d chunks of %d bytes, announced %d of %d", upload.next, len(upload.data), upload.begin.Chunks, upload.begin.TotalSize),
+		}
+	}
+	return upload.begin, upload.data, nil
+}
+
+// expire drops an upload its timer found abandoned, unless it has already
+// ended
+func (u *uploads) expire(messageID string, upload *chunkedUpload) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.byID[messageID] != upload {
+		return
+	}
+	u.remove(messageID, upload)
+	log.Printf("Dropping chunked publish of message %s after %s without a chunk", messageID, u.timeout)
+	u.expired()
+}
+
+// remove forgets an upload and releases its reservation; the caller holds
+// the mutex
+func (u *uploads) remove(messageID string, upload *chunkedUpload) {
+	upload.timer.Stop()
+	delete(u.byID, messageID)
+	u.reserved -= upload.begin.TotalSize
+}
+
+// clear drops every upload, on disconnect
+func (u *uploads) clear() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	for messageID, upload := range u.byID {
+		u.remove(messageID, upload)
+	}
+}
+
+// inProgress returns the number of uploads in progress
+func (u *uploads) inProgress() int {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return len(u.byID)
+}
+
+// handlePublishBegin starts a chunked publish
+func (c *Client) handlePublishBegin(req pubsub.ChunkBegin) error {
+	if !c.chunking.Load() {
+		return c.uploadError(req.RequestID, pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "chunked publishing needs the chunking capability"})
+	}
+	if req.RequestID == "" || req.MessageID == "" || req.TotalSize <= 0 || req.Chunks <= 0 {
+		return c.uploadError(req.RequestID, pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "request_id, message_id, total_size and chunks are required"})
+	}
+	if err := c.uploads.begin(req); err != nil {
+		return c.uploadError(req.RequestID, err)
+	}
+	return nil
+}
+
+// handlePublishChunk adds a chunk to a chunked publish
+func (c *Client) handlePublishChunk(chunk pubsub.Chunk) error {
+	if begin, err := c.uploads.add(chunk); err != nil {
+		return c.uploadError(begin.RequestID, err)
+	}
+	return nil
+}
+
+// handlePublishEnd publishes a reassembled message like any other publish.
+// The data is the JSON encoding of the message's payload.
+func (c *Client) handlePublishEnd(end pubsub.ChunkEnd) error {
+	begin, data, err := c.uploads.end(end.MessageID)
+	if err != nil {
+		return c.uploadError(begin.RequestID, err)
+	}
+
+	maxDepth := c.ps.PayloadLimits().MaxDepth
+	if depth := pubsub.JSONDepth(data); depth > maxDepth {
+		return c.uploadError(begin.RequestID, pubsub.ErrorData{
+			Code:    pubsub.CodePayloadTooLarge,
+			Reason:  "PAYLOAD_TOO_DEEP",
+			Message: fmt.Sprintf("payload nesting exceeds the limit of %d", maxDepth),
+			Limit:   maxDepth,
+		})
+	}
+	var payload interface{}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return c.uploadError(begin.RequestID, pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "reassembled payload is not JSON: " + err.Error()})
+	}
+
+	req := pubsub.PublishRequest{
+		Type:      "publish",
+		Topic:     begin.Topic,
+		Message:   pubsub.MessageData{ID: begin.MessageID, Payload: payload},
+		ClientID:  begin.ClientID,
+		RequestID: begin.RequestID,
+	}
+	c.frameSize = len(data)
+	return c.once(req.Type, req.RequestID, func() error { return c.handlePublish(req) })
+}
+
+// uploadError answers a chunked publish that failed. It counts against the
+// error budget like any invalid request.
+func (c *Client) uploadError(requestID string, err error) error {
+	errorData, ok := err.(pubsub.ErrorData)
+	if !ok {
+		errorData = pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: err.Error()}
+	}
+	c.invalid = true
+	return c.sendMessage(pubsub.ErrorResponse{
+		Type:      "error",
+		RequestID: requestID,
+		Error:     errorData,
+		Timestamp: c.clock.Now(),
+	})
+}
+
+// chunkedEvent returns the event a frame carries when it is too large for
+// one frame and the client takes chunks
+func (c *Client) chunkedEvent(frame outboundFrame, size int) (pubsub.EventResponse, bool) {
+	if !c.chunking.Load() || size <= c.maxFrameSize() {
+		return pubsub.EventResponse{}, false
+	}
+	if frame.prepared != nil {
+		return frame.prepared.Event, true
+	}
+	event, ok := frame.message.(pubsub.EventResponse)
+	return event, ok
+}
+
+// writeChunked writes an event's encoding as event_begin, event_chunk
+// frames each within the frame limit, and event_end. The client joins the
+// chunks' data and decodes it like a frame of its own.
+func (c *Client) writeChunked(frame outboundFrame, event pubsub.EventResponse, data []byte) error {
+	// JSON carries the data in base64, 4 bytes for every 3
+	size := c.maxFrameSize() - chunkEnvelope
+	if !c.codec.Binary() {
+		size = size / 4 * 3
+	}
+	size = max(size, 1)
+
+	messages := []interface{}{pubsub.ChunkBegin{
+		Type:      "event_begin",
+		Topic:     pubsub.LocalTopic(event.Topic),
+		MessageID: event.Message.ID,
+		TotalSize: len(data),
+		Chunks:    (len(data) + size - 1) / size,
+	}}
+	for index, offset := 0, 0; offset < len(data); index, offset = index+1, offset+size {
+		messages = append(messages, pubsub.Chunk{
+			Type:      "event_chunk",
+			MessageID: event.Message.ID,
+			Index:     index,
+			Data:      data[offset:min(offset+size, len(data))],
+		})
+	}
+	messages = append(messages, pubsub.ChunkEnd{Type: "event_end", MessageID: event.Message.ID})
+
+	for _, message := range messages {
+		encoded, err := c.codec.Marshal(message)
+		if err != nil {
+			return err
+		}
+		c.conn.SetWriteDeadline(time.Now().Add(c.opts.WriteWait))
+		if err := c.writeFrame(outboundFrame{message: message, topic: frame.topic}, encoded); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package ws
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/AnshulDekate/pubsub/pkg/clock"
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// filesServer serves a "files" topic with frames limited to 1024 bytes
+func filesServer(t *testing.T, opts WebSocketOptions) (*pubsub.PubSubSystem, *httptest.Server) {
+	t.Helper()
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "files"); err != nil {
+		t.Fatal(err)
+	}
+	opts.MaxMessageSize = 1024
+	return ps, serve(t, ps, opts)
+}
+
+// dialV2 connects in JSON and negotiates protocol v2 with capabilities
+func dialV2(t *testing.T, server *httptest.Server, capabilities ...string) *wireClient {
+	t.Helper()
+	c := dialCodec(t, server, pubsub.JSONCodec)
+	c.send(map[string]interface{}{"type": "hello", "protocol_version": 2, "capabilities": capabilities, "request_id": "h-1"})
+	c.expect("hello_ack")
+	return c
+}
+
+// errorCode returns the code of a v2 error frame
+func errorCode(frame map[string]interface{}) interface{} {
+	errorData, _ := frame["error"].(map[string]interface{})
+	return errorData["code"]
+}
+
+// errorReason returns the more specific code of an error frame
+func errorReason(frame map[string]interface{}) interface{} {
+	errorData, _ := frame["error"].(map[string]interface{})
+	return errorData["reason"]
+}
+
+// splitChunks splits data into publish_chunk requests of size bytes
+func splitChunks(messageID string, data []byte, size int) []map[string]interface{} {
+	var chunks []map[string]interface{}
+	for offset := 0; offset < len(data); offset += size {
+		chunks = append(chunks, map[string]interface{}{
+			"type":       "publish_chunk",
+			"message_id": messageID,
+			"index":      len(chunks),
+			"data":       base64.StdEncoding.EncodeToString(data[offset:min(offset+size, len(data))]),
+		})
+	}
+	return chunks
+}
+
+// publishBegin is the publish_begin announcing chunks for data
+func publishBegin(requestID, messageID string, data []byte, chunks int) map[string]interface{} {
+	return map[string]interface{}{
+		"type":       "publish_begin",
+		"topic":      "files",
+		"request_id": requestID,
+		"message_id": messageID,
+		"total_size": len(data),
+		"chunks":     chunks,
+	}
+}
+
+func TestChunkedPublishAndDelivery(t *testing.T) {
+	ps, server := filesServer(t, WebSocketOptions{})
+	chunked, whole := dialV2(t, server, capabilityChunking), dialV2(t, server)
+	for _, sub := range []*wireClient{chunked, whole} {
+		sub.send(map[string]interface{}{"type": "subscribe", "topic": "files", "request_id": "s-1"})
+		sub.expect("ack")
+	}
+
+	payload := map[string]interface{}{"name": "report.txt", "body": strings.Repeat("lorem ipsum ", 500)}
+	data, _ := json.Marshal(payload)
+	messageID := uuid.New().String()
+	chunks := splitChunks(messageID, data, 600)
+
+	pub := dialV2(t, server, capabilityChunking)
+	pub.send(publishBegin("p-1", messageID, data, len(chunks)))
+	for _, chunk := range chunks {
+		pub.send(chunk)
+	}
+	pub.send(map[string]interface{}{"type": "publish_end", "message_id": messageID})
+	if ack := pub.expect("ack"); ack["request_id"] != "p-1" {
+		t.Fatalf("ack = %v", ack)
+	}
+
+	// A subscriber that takes chunks gets frames within the limit and joins
+	// them into the event
+	begin := chunked.expect("event_begin")
+	if begin["message_id"] != messageID || begin["topic"] != "files" {
+		t.Fatalf("event_begin = %v", begin)
+	}
+	var joined []byte
+	for i := 0; i < int(begin["chunks"].(float64)); i++ {
+		chunk := chunked.expect("event_chunk")
+		if chunk["index"] != float64(i) {
+			t.Fatalf("event_chunk %v, want index %d", chunk["index"], i)
+		}
+		part, err := base64.StdEncoding.DecodeString(chunk["data"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		joined = append(joined, part...)
+	}
+	chunked.expect("event_end")
+	if len(joined) != int(begin["total_size"].(float64)) {
+		t.Errorf("joined %d bytes, announced %v", len(joined), begin["total_size"])
+	}
+	var event pubsub.EventResponse
+	if err := json.Unmarshal(joined, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Message.ID != messageID || event.Message.Payload.(map[string]interface{})["body"] != payload["body"] {
+		t.Errorf("reassembled event = %+v", event)
+	}
+
+	// One that doesn't gets it whole
+	if event := whole.expect("event"); messageID != event["message"].(map[string]interface{})["id"] {
+		t.Errorf("whole event = %v", event)
+	}
+	if messages := ps.GetStats().Topics["files"].Messages; messages != 1 {
+		t.Errorf("files messages = %d, want 1", messages)
+	}
+}
+
+func TestChunkedPublishRejections(t *testing.T) {
+	ps, server := filesServer(t, WebSocketOptions{MaxChunkedBytes: 8192})
+	data := []byte(`"` + strings.Repeat("x", 4998) + `"`)
+
+	// Chunking must be negotiated first
+	plain := dialV2(t, server)
+	plain.send(publishBegin("p-0", uuid.New().String(), data, 10))
+	if failure := plain.expect("error"); failure["request_id"] != "p-0" || errorCode(failure) != "VALIDATION_FAILED" {
+		t.Errorf("publish_begin without chunking answered with %v", failure)
+	}
+
+	// A chunk out of order fails the upload, which is then gone
+	pub := dialV2(t, server, capabilityChunking)
+	messageID := uuid.New().String()
+	chunks := splitChunks(messageID, data, 600)
+	pub.send(publishBegin("p-1", messageID, data, len(chunks)))
+	pub.send(chunks[0])
+	pub.send(chunks[2])
+	if failure := pub.expect("error"); failure["request_id"] != "p-1" || errorCode(failure) != "VALIDATION_FAILED" || errorReason(failure) != "CHUNK_OUT_OF_ORDER" {
+		t.Errorf("out of order chunk answered with %v", failure)
+	}
+	pub.send(map[string]interface{}{"type": "publish_end", "message_id": messageID})
+	if failure := pub.expect("error"); errorReason(failure) != "UNKNOWN_UPLOAD" {
+		t.Errorf("publish_end of the failed upload answered with %v", failure)
+	}
+
+	// Uploads in progress share the connection's cap
+	pub.send(publishBegin("p-2", uuid.New().String(), data, len(chunks)))
+	pub.send(publishBegin("p-3", uuid.New().String(), data, len(chunks)))
+	if failure := pub.expect("error"); failure["request_id"] != "p-3" || errorCode(failure) != "PAYLOAD_TOO_LARGE" || errorReason(failure) != "CHUNKED_TOO_LARGE" {
+		t.Errorf("upload over the cap answered with %v", failure)
+	}
+
+	// An end before every chunk arrived is refused
+	messageID = uuid.New().String()
+	pub = dialV2(t, server, capabilityChunking)
+	pub.send(publishBegin("p-4", messageID, data, len(chunks)))
+	pub.send(splitChunks(messageID, data, 600)[0])
+	pub.send(map[string]interface{}{"type": "publish_end", "message_id": messageID})
+	if failure := pub.expect("error"); failure["request_id"] != "p-4" || errorCode(failure) != "VALIDATION_FAILED" || errorReason(failure) != "CHUNK_SIZE_MISMATCH" {
+		t.Errorf("early publish_end answered with %v", failure)
+	}
+
+	if messages := ps.GetStats().Topics["files"].Messages; messages != 0 {
+		t.Errorf("files messages = %d, want 0", messages)
+	}
+}
+
+func TestAbandonedUploadsExpire(t *testing.T) {
+	ps, server := filesServer(t, WebSocketOptions{ChunkTimeout: 50 * time.Millisecond, MaxChunkedBytes: 6000})
+	data := []byte(`"` + strings.Repeat("x", 4998) + `"`)
+	pub := dialV2(t, server, capabilityChunking)
+	messageID := uuid.New().String()
+	chunks := splitChunks(messageID, data, 600)
+
+	pub.send(publishBegin("p-1", messageID, data, len(chunks)))
+	pub.send(chunks[0])
+	waitFor(t, "the upload to expire", func() bool { return ps.GetStats().WebSocket.ExpiredUploads == 1 })
+
+	pub.send(chunks[1])
+	if failure := pub.expect("error"); errorReason(failure) != "UNKNOWN_UPLOAD" {
+		t.Errorf("chunk after expiry answered with %v", failure)
+	}
+
+	// Its reservation is released, so the same upload can start over
+	pub.send(publishBegin("p-2", messageID, data, len(chunks)))
+	for _, chunk := range chunks {
+		pub.send(chunk)
+	}
+	pub.send(map[string]interface{}{"type": "publish_end", "message_id": messageID})
+	if ack := pub.expect("ack"); ack["request_id"] != "p-2" {
+		t.Errorf("retried upload answered with %v", ack)
+	}
+}
+
+func TestUploadsTrackReservations(t *testing.T) {
+	u := newUploads(100, time.Minute, clock.Real, func() {})
+	if err := u.begin(pubsub.ChunkBegin{MessageID: "a", TotalSize: 60, Chunks: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.begin(pubsub.ChunkBegin{MessageID: "a", TotalSize: 10, Chunks: 1}); err == nil {
+		t.Error("a second upload of the same message was started")
+	}
+	if _, err := u.add(pubsub.Chunk{MessageID: "a", Index: 0, Data: make([]byte, 61)}); err == nil {
+		t.Error("a chunk beyond the announced size was accepted")
+	}
+	if u.inProgress() != 0 || u.reserved != 0 {
+		t.Errorf("%d uploads reserving %d bytes after a failed chunk", u.inProgress(), u.reserved)
+	}
+
+	u.begin(pubsub.ChunkBegin{MessageID: "b", TotalSize: 40, Chunks: 1})
+	u.clear()
+	if u.inProgress() != 0 || u.reserved != 0 {
+		t.Errorf("%d uploads reserving %d bytes after clear", u.inProgress(), u.reserved)
+	}
+}
@@ -0,0 +1,94 @@
+package ws
+
+import (
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// Close codes the server ends a connection with. Each close frame carries a
+// short reason and, where the client can still be told, follows a final
+// error or info frame saying why.
+const (
+	CloseNormal          = websocket.CloseNormalClosure   // Answering the client's own close
+	CloseGoingAway       = websocket.CloseGoingAway       // The server is shutting down
+	ClosePolicyViolation = websocket.ClosePolicyViolation // The client broke an access policy
+	CloseMessageTooBig   = websocket.CloseMessageTooBig   // A frame was over the read limit
+	CloseTryAgainLater   = websocket.CloseTryAgainLater   // The client wasn't keeping up
+
+	CloseKicked             = 4000 // An operator disconnected the client
+	CloseIdle               = 4001 // Nothing was heard from the client within the pong wait
+	CloseSessionTakenOver   = 4002 // Another connection took over the client_id
+	CloseUnsupportedVersion = 4400 // The hello asked for a protocol version the server lacks
+	CloseTooManyErrors      = 4429 // The client spent its error budget
+)
+
+// closeNotices are the reasons of the info frames sent ahead of closes
+// that don't follow an error
+var closeNotices = map[int]string{
+	CloseGoingAway:        "GOING_AWAY",
+	ClosePolicyViolation:  "POLICY_VIOLATION",
+	CloseKicked:           "KICKED",
+	CloseSessionTakenOver: "SESSION_TAKEN_OVER",
+}
+
+// Close ends the connection with code, after an info frame telling the
+// client reason. It doesn't wait for the close to go out. Embedders can use
+// it to end connections for their own reasons, e.g. ClosePolicyViolation
+// once a client's credentials are revoked.
+func (c *Client) Close(code int, reason string) {
+	reason = closeReason(reason)
+	c.sendMessage(pubsub.InfoResponse{
+		Type:      "info",
+		Message:   reason,
+		Reason:    closeNotices[code],
+		Timestamp: c.clock.Now(),
+	})
+	c.closeWith(code, reason)
+}
+
+// CloseGracefully sends a going-away close frame ahead of queued events
+func (c *Client) CloseGracefully(reason string) {
+	c.Close(CloseGoingAway, reason)
+}
+
+// Kick implements pubsub.Kicker
+func (c *Client) Kick(reason string) {
+	if reason == "" {
+		reason = "kicked"
+	}
+	c.Close(CloseKicked, reason)
+}
+
+// closeWith queues a close frame behind the control responses already
+// queued, so it follows any final error or info. A client whose control
+// queue is full isn't keeping up and is closed at once with
+// CloseTryAgainLater.
+func (c *Client) closeWith(code int, reason string) {
+	frame := outboundFrame{closeCode: code, closeText: closeReason(reason)}
+	if err := c.enqueueControl(frame, false); err != nil {
+		c.closeNow(CloseTryAgainLater, "client overloaded")
+	}
+}
+
+// closeNow sends a close frame ahead of anything queued and drops the
+// connection
+func (c *Client) closeNow(code int, reason string) {
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, closeReason(reason)), time.Now().Add(c.opts.WriteWait))
+	c.conn.Close()
+}
+
+// closeReason cuts a reason to fit a close frame, on a rune boundary
+func closeReason(reason string) string {
+	if len(reason) <= maxCloseReason {
+		return reason
+	}
+	cut := maxCloseReason
+	for cut > 0 && !utf8.RuneStart(reason[cut]) {
+		cut--
+	}
+	return reason[:cut]
+}
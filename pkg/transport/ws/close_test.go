@@ -0,0 +1,226 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// closeFrames reads until the server closes the connection and returns the
+// frames that came before the close, and the close
+func closeFrames(t *testing.T, conn *websocket.Conn) ([]map[string]interface{}, *websocket.CloseError) {
+	t.Helper()
+	var frames []map[string]interface{}
+	// Answering pings or the close may fail on a connection the server
+	// has dropped, which would hide the close
+	conn.SetPingHandler(func(string) error { return nil })
+	conn.SetCloseHandler(func(int, string) error { return nil })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var frame map[string]interface{}
+		err := conn.ReadJSON(&frame)
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			return frames, closeErr
+		}
+		if err != nil {
+			t.Fatalf("reading until the close: %v", err)
+		}
+		frames = append(frames, frame)
+	}
+}
+
+// finalPayload returns the payload of the last frame, which comes wrapped
+// in an event envelope, and its type
+func finalPayload(t *testing.T, frames []map[string]interface{}) (string, map[string]interface{}) {
+	t.Helper()
+	if len(frames) == 0 {
+		t.Fatal("no frame before the close")
+	}
+	last := frames[len(frames)-1]
+	message, _ := last["message"].(map[string]interface{})
+	payload, _ := message["payload"].(map[string]interface{})
+	kind, _ := last["type"].(string)
+	return kind, payload
+}
+
+// wsClient returns the connection registered as clientID
+func wsClient(t *testing.T, ps *pubsub.PubSubSystem, clientID string) *Client {
+	t.Helper()
+	holder, free := ps.RegisterClientIfAbsent(probeClient{id: clientID})
+	client, ok := holder.(*Client)
+	if free || !ok {
+		t.Fatalf("%s is not a websocket connection", clientID)
+	}
+	return client
+}
+
+func TestServerClosesSayWhy(t *testing.T) {
+	ps := pubsub.New()
+	server := serve(t, ps, WebSocketOptions{MaxMessageSize: 1024})
+
+	infoClose := func(name string, end func(clientID string), reason, notice string, code int) {
+		t.Run(name, func(t *testing.T) {
+			conn, welcome := dial(t, server, "", nil)
+			end(welcome.ClientID)
+			frames, closeErr := closeFrames(t, conn)
+			if closeErr.Code != code || closeErr.Text != reason {
+				t.Errorf("closed with %d %q, want %d %q", closeErr.Code, closeErr.Text, code, reason)
+			}
+			if kind, payload := finalPayload(t, frames); kind != "info" || payload["reason"] != notice || payload["msg"] != reason {
+				t.Errorf("final frame %s %v, want an info with reason %s", kind, payload, notice)
+			}
+		})
+	}
+	infoClose("shutdown", func(string) { ps.CloseAllClients("server shutting down") },
+		"server shutting down", "GOING_AWAY", CloseGoingAway)
+	infoClose("kick", func(id string) {
+		if !ps.KickClient(id, "spamming") {
+			t.Fatal("not kicked")
+		}
+	}, "spamming", "KICKED", CloseKicked)
+	infoClose("policy", func(id string) { wsClient(t, ps, id).Close(ClosePolicyViolation, "token revoked") },
+		"token revoked", "POLICY_VIOLATION", ClosePolicyViolation)
+
+	t.Run("client close", func(t *testing.T) {
+		conn, _ := dial(t, server, "", nil)
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "leaving"))
+		if _, closeErr := closeFrames(t, conn); closeErr.Code != CloseNormal || closeErr.Text != "goodbye" {
+			t.Errorf("closed with %d %q, want %d goodbye", closeErr.Code, closeErr.Text, CloseNormal)
+		}
+	})
+
+	t.Run("message too big", func(t *testing.T) {
+		conn, _ := dial(t, server, "", nil)
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"type": "ping", "pad": "`+strings.Repeat("x", 2000)+`"}`))
+		frames, closeErr := closeFrames(t, conn)
+		if closeErr.Code != CloseMessageTooBig || closeErr.Text != "message too big" {
+			t.Errorf("closed with %d %q, want %d", closeErr.Code, closeErr.Text, CloseMessageTooBig)
+		}
+		if kind, payload := finalPayload(t, frames); kind != "error" || payload["code"] != pubsub.CodePayloadTooLarge || payload["reason"] != "MESSAGE_TOO_BIG" {
+			t.Errorf("final frame %s %v, want a MESSAGE_TOO_BIG error", kind, payload)
+		}
+	})
+}
+
+func TestIdleClientIsClosed(t *testing.T) {
+	ps := pubsub.New()
+	server := serve(t, ps, WebSocketOptions{PongWait: 200 * time.Millisecond, PingPeriod: 100 * time.Millisecond})
+	conn, _ := dial(t, server, "", nil)
+
+	// Not reading, the client answers no ping
+	time.Sleep(400 * time.Millisecond)
+	if _, closeErr := closeFrames(t, conn); closeErr.Code != CloseIdle || closeErr.Text != "idle timeout" {
+		t.Errorf("closed with %d %q, want %d idle timeout", closeErr.Code, closeErr.Text, CloseIdle)
+	}
+}
+
+func TestSessionTakeoverCloses(t *testing.T) {
+	ps, server := identityServer(t, WebSocketOptions{ClientIDTakeover: true})
+	holder, _ := dialWelcome(t, server, "", nil)
+	if frame := holder.subscribeAs("dashboard"); frame["type"] != "ack" {
+		t.Fatalf("claiming dashboard = %v", frame)
+	}
+
+	c, _ := dialWelcome(t, server, "", nil)
+	done := make(chan map[string]interface{})
+	go func() { done <- c.subscribeAs("dashboard") }()
+	frames, closeErr := closeFrames(t, holder.conn)
+	if frame := <-done; frame["type"] != "ack" {
+		t.Fatalf("takeover = %v", frame)
+	}
+	if closeErr.Code != CloseSessionTakenOver || !strings.Contains(closeErr.Text, "taken over") {
+		t.Errorf("closed with %d %q, want %d", closeErr.Code, closeErr.Text, CloseSessionTakenOver)
+	}
+	if kind, payload := finalPayload(t, frames); kind != "info" || payload["reason"] != "SESSION_TAKEN_OVER" {
+		t.Errorf("final frame %s %v, want a SESSION_TAKEN_OVER info", kind, payload)
+	}
+	if !connected(ps, "dashboard") {
+		t.Error("the new connection doesn't hold dashboard")
+	}
+}
+
+func TestOverloadedClientIsClosedAtOnce(t *testing.T) {
+	ps := pubsub.New()
+	// The connection's queues are never drained, as for a client that has
+	// stopped reading
+	closed := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := NewClient(conn, ps, WebSocketOptions{ControlBufferSize: 1})
+		for client.enqueueControl(outboundFrame{message: pubsub.PongResponse{Type: "pong"}}, false) == nil {
+		}
+		client.CloseGracefully("server shutting down")
+		close(closed)
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	<-closed
+	if _, closeErr := closeFrames(t, conn); closeErr.Code != CloseTryAgainLater || closeErr.Text != "client overloaded" {
+		t.Errorf("closed with %d %q, want %d client overloaded", closeErr.Code, closeErr.Text, CloseTryAgainLater)
+	}
+}
+
+func TestClosingDuringPublishFloodWithFanoutWorkers(t *testing.T) {
+	ps := pubsub.New()
+	defer ps.Close()
+	if err := ps.EnableFanoutWorkers(4); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	server := serve(t, ps, WebSocketOptions{})
+
+	for round := 0; round < 3; round++ {
+		var clients []*wireClient
+		for i := 0; i < 10; i++ {
+			client, _ := dialWelcome(t, server, "", nil)
+			clientID := fmt.Sprintf("sub-%d-%d", round, i)
+			if frame := client.subscribeAs(clientID); frame["type"] != "ack" {
+				t.Fatalf("subscribing = %v", frame)
+			}
+			clients = append(clients, client)
+		}
+
+		// Deliveries still queued on the lanes must not reach a connection
+		// whose send queue was closed
+		stop := make(chan struct{})
+		flooded := make(chan struct{})
+		go func() {
+			defer close(flooded)
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				ps.Publish(context.Background(), "orders", pubsub.MessageData{ID: fmt.Sprintf("m-%d-%d", round, i)}, "")
+			}
+		}()
+		for i, client := range clients {
+			client.conn.Close()
+			clientID := fmt.Sprintf("sub-%d-%d", round, i)
+			waitFor(t, clientID+" to disconnect", func() bool { return !connected(ps, clientID) })
+		}
+		close(stop)
+		<-flooded
+	}
+}
@@ -0,0 +1,197 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// wireClient speaks the protocol in one codec, decoding frames generically
+type wireClient struct {
+	t     *testing.T
+	conn  *websocket.Conn
+	codec pubsub.Codec
+
+	// Frames read while expecting another type
+	pending []map[string]interface{}
+}
+
+// dialCodec connects to server in codec's wire format
+func dialCodec(t *testing.T, server *httptest.Server, codec pubsub.Codec) *wireClient {
+	t.Helper()
+	dialer := *websocket.DefaultDialer
+	if codec == pubsub.MsgpackCodec {
+		dialer.Subprotocols = []string{pubsub.MsgpackSubprotocol}
+	}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &wireClient{t: t, conn: conn, codec: codec}
+}
+
+func (c *wireClient) send(msg interface{}) {
+	c.t.Helper()
+	data, err := c.codec.Marshal(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := c.conn.WriteMessage(frameType(c.codec), data); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// expect returns the first frame of type kind, reading until one arrives.
+// Frames of other types are kept for later calls.
+func (c *wireClient) expect(kind string) map[string]interface{} {
+	c.t.Helper()
+	for i, frame := range c.pending {
+		if frame["type"] == kind {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return frame
+		}
+	}
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		ft, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.t.Fatalf("%s client waiting for %s: %v", c.codec.Name(), kind, err)
+		}
+		if ft != frameType(c.codec) {
+			c.t.Fatalf("%s client got frame type %d", c.codec.Name(), ft)
+		}
+		var frame map[string]interface{}
+		if err := c.codec.Unmarshal(data, &frame); err != nil {
+			c.t.Fatalf("%s client decoding %q: %v", c.codec.Name(), data, err)
+		}
+		if frame["type"] == kind {
+			return frame
+		}
+		c.pending = append(c.pending, frame)
+	}
+}
+
+// normalized passes v through JSON, so values decoded from either codec
+// compare equal when they are the same
+func normalized(t *testing.T, v interface{}) interface{} {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out interface{}
+	json.Unmarshal(data, &out)
+	return out
+}
+
+// semantics keeps the fields of an event that clients act on. MessagePack
+// omits empty fields that JSON sends as false or zero, so those are left
+// out.
+func semantics(t *testing.T, event map[string]interface{}) interface{} {
+	t.Helper()
+	return normalized(t, map[string]interface{}{
+		"type":    event["type"],
+		"topic":   event["topic"],
+		"seq":     event["seq"],
+		"message": event["message"],
+	})
+}
+
+// runCodecScenario subscribes and publishes over codec and returns what
+// the subscriber saw and the error a bad publish got, normalized
+func runCodecScenario(t *testing.T, codec pubsub.Codec, blob []byte) (events []interface{}, rawBlob interface{}, publishErr interface{}) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	server := serve(t, ps, WebSocketOptions{})
+	sub, pub := dialCodec(t, server, codec), dialCodec(t, server, codec)
+
+	sub.send(map[string]interface{}{"type": "subscribe", "topic": "orders", "request_id": "s-1"})
+	sub.expect("ack")
+
+	for i, payload := range []interface{}{blob, map[string]interface{}{"n": 7, "name": "ü", "ok": true, "items": []interface{}{"a", 1.5}}} {
+		pub.send(map[string]interface{}{
+			"type":       "publish",
+			"topic":      "orders",
+			"request_id": fmt.Sprintf("p-%d", i),
+			"message":    map[string]interface{}{"id": uuid.New().String(), "payload": payload, "headers": map[string]string{"n": fmt.Sprint(i)}},
+		})
+		pub.expect("ack")
+
+		event := sub.expect("event")
+		message := event["message"].(map[string]interface{})
+		if i == 0 {
+			rawBlob = message["payload"]
+		}
+		// IDs differ between runs
+		delete(message, "id")
+		events = append(events, semantics(t, event))
+	}
+
+	pub.send(map[string]interface{}{"type": "publish", "topic": "missing", "request_id": "p-bad", "message": map[string]interface{}{"id": uuid.New().String(), "payload": "x"}})
+	return events, rawBlob, normalized(t, pub.expect("error")["error"])
+}
+
+func TestCodecsHaveTheSameSemantics(t *testing.T) {
+	blob := []byte{0x00, 0x01, 0x7f, 0x80, 0xfe, 0xff, '{', '"'}
+
+	jsonEvents, jsonBlob, jsonErr := runCodecScenario(t, pubsub.JSONCodec, blob)
+	msgpackEvents, msgpackBlob, msgpackErr := runCodecScenario(t, pubsub.MsgpackCodec, blob)
+
+	if !reflect.DeepEqual(jsonEvents, msgpackEvents) {
+		t.Errorf("events differ between codecs:\njson    %v\nmsgpack %v", jsonEvents, msgpackEvents)
+	}
+	if !reflect.DeepEqual(jsonErr, msgpackErr) {
+		t.Errorf("errors differ between codecs:\njson    %v\nmsgpack %v", jsonErr, msgpackErr)
+	}
+
+	// Binary payloads arrive intact: base64 in JSON, raw bytes in MessagePack
+	if s, _ := jsonBlob.(string); s != base64.StdEncoding.EncodeToString(blob) {
+		t.Errorf("JSON payload = %#v", jsonBlob)
+	}
+	if b, _ := msgpackBlob.([]byte); !bytes.Equal(b, blob) {
+		t.Errorf("MessagePack payload = %#v", msgpackBlob)
+	}
+}
+
+func TestCodecsInteroperate(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	server := serve(t, ps, WebSocketOptions{})
+	jsonSub, msgpackSub := dialCodec(t, server, pubsub.JSONCodec), dialCodec(t, server, pubsub.MsgpackCodec)
+	for _, sub := range []*wireClient{jsonSub, msgpackSub} {
+		sub.send(map[string]interface{}{"type": "subscribe", "topic": "orders", "request_id": "s-1"})
+		sub.expect("ack")
+	}
+
+	pub := dialCodec(t, server, pubsub.MsgpackCodec)
+	pub.send(map[string]interface{}{
+		"type":       "publish",
+		"topic":      "orders",
+		"request_id": "p-1",
+		"message":    map[string]interface{}{"id": uuid.New().String(), "payload": map[string]interface{}{"total": 42}},
+	})
+	pub.expect("ack")
+
+	jsonEvent, msgpackEvent := jsonSub.expect("event"), msgpackSub.expect("event")
+	if !reflect.DeepEqual(semantics(t, jsonEvent), semantics(t, msgpackEvent)) {
+		t.Errorf("the same event differs between codecs:\njson    %v\nmsgpack %v", jsonEvent, msgpackEvent)
+	}
+}
@@ -0,0 +1,97 @@
+package ws
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// dialDeflate connects with or without offering permessage-deflate and
+// reports whether the server agreed to it
+func dialDeflate(t *testing.T, server *httptest.Server, deflate bool) (*wireClient, bool) {
+	t.Helper()
+	dialer := *websocket.DefaultDialer
+	dialer.EnableCompression = deflate
+	conn, resp, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	c := &wireClient{t: t, conn: conn, codec: pubsub.JSONCodec}
+	c.send(map[string]interface{}{"type": "subscribe", "topic": "orders", "request_id": "s-1"})
+	c.expect("ack")
+	return c, strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+}
+
+func TestCompressingAndPlainClientsInteroperate(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	server := serve(t, ps, WebSocketOptions{EnableCompression: true, CompressionThreshold: 1024})
+
+	compressing, negotiated := dialDeflate(t, server, true)
+	if !negotiated {
+		t.Fatal("server did not agree to permessage-deflate")
+	}
+	plain, negotiated := dialDeflate(t, server, false)
+	if negotiated {
+		t.Fatal("server negotiated deflate with a client that didn't offer it")
+	}
+	compressed := ps.WebSocketTraffic().CompressedMessages.Load()
+
+	// A large, repetitive event and a small one. Clients can't send
+	// messages that large, so they are published server-side.
+	large := strings.Repeat(`{"sku":"A-1","qty":1}`, 1000)
+	for i, payload := range []string{large, "small"} {
+		if err := ps.Publish(context.Background(), "orders", pubsub.MessageData{ID: uuid.New().String(), Payload: payload}, ""); err != nil {
+			t.Fatal(err)
+		}
+		for _, subscriber := range []*wireClient{compressing, plain} {
+			event := subscriber.expect("event")
+			if got := event["message"].(map[string]interface{})["payload"]; got != payload {
+				t.Fatalf("event %d arrived at %s with %d payload bytes", i, subscriber.conn.LocalAddr(), len(got.(string)))
+			}
+		}
+	}
+
+	// Only the large event to the compressing client was compressed: not
+	// the small event, the acks or anything sent to the plain client
+	traffic := ps.GetStats().WebSocket
+	if got := traffic.CompressedMessages - compressed; got != 1 {
+		t.Errorf("compressed %d messages, want 1", got)
+	}
+	if traffic.WireBytes >= traffic.PayloadBytes {
+		t.Errorf("%d bytes on the wire for %d bytes of messages", traffic.WireBytes, traffic.PayloadBytes)
+	}
+}
+
+func TestCompressionOffByDefault(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	if _, negotiated := dialDeflate(t, serve(t, ps, WebSocketOptions{}), true); negotiated {
+		t.Error("deflate negotiated without EnableCompression")
+	}
+}
+
+func TestNewHandlerDefaultsCompression(t *testing.T) {
+	h, err := NewHandler(pubsub.New(), WebSocketOptions{EnableCompression: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.opts.CompressionLevel != DefaultCompressionLevel || h.opts.CompressionThreshold != DefaultCompressionThreshold {
+		t.Errorf("zero options configured as %+v", h.opts)
+	}
+	if _, err := NewHandler(pubsub.New(), WebSocketOptions{CompressionLevel: 12}); err == nil {
+		t.Error("NewHandler accepted compression level 12")
+	}
+}
@@ -0,0 +1,130 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// connectRequest is what the upgrade URL asks for, saving a round trip:
+// GET /ws?client_id=abc&topics=room1,room2&last_n=20&protocol_version=2
+type connectRequest struct {
+	clientID string
+	topics   []string
+	lastN    int
+	version  int // 0 keeps the default
+}
+
+// parseConnectRequest reads a connect request from the upgrade URL's query.
+// Malformed numbers refuse the upgrade; bad topic names are reported per
+// topic once connected.
+func parseConnectRequest(query url.Values) (connectRequest, error) {
+	req := connectRequest{clientID: query.Get("client_id")}
+	for _, topic := range strings.Split(query.Get("topics"), ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			req.topics = append(req.topics, topic)
+		}
+	}
+	if value := query.Get("last_n"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return connectRequest{}, errors.New("last_n must be a non-negative integer")
+		}
+		req.lastN = n
+	}
+	if value := query.Get("protocol_version"); value != "" {
+		version, err := strconv.Atoi(value)
+		if err != nil || version < pubsub.ProtocolVersion1 || version > pubsub.MaxProtocolVersion {
+			return connectRequest{}, fmt.Errorf("protocol_version must be %d to %d", pubsub.ProtocolVersion1, pubsub.MaxProtocolVersion)
+		}
+		req.version = version
+	}
+	return req, nil
+}
+
+// claimAtConnect applies the protocol version and binds the client ID
+// named in the upgrade URL, before the welcome frame announces them. A
+// refused claim leaves the assigned ID in place. Called before the pumps
+// start.
+func (c *Client) claimAtConnect(req connectRequest) error {
+	if req.version != 0 {
+		c.version.Store(int32(req.version))
+	}
+	if req.clientID == "" {
+		return nil
+	}
+	return c.claimClientID(req.clientID)
+}
+
+// subscribeAtConnect subscribes to the topics named in the upgrade URL,
+// answering each in order with an ack or error flagged as connect, then
+// replays their history. The subscriptions count as the connection's first
+// request, so a hello is refused afterwards. Called before readPump starts.
+func (c *Client) subscribeAtConnect(req connectRequest) {
+	if len(req.topics) == 0 {
+		return
+	}
+	c.started = true
+	if err := c.claimClientID(""); err != nil {
+		c.connectError("", err)
+		return
+	}
+
+	// Acks jump the event queue, so they all go out before the histories
+	var histories [][]pubsub.EventResponse
+	for _, name := range req.topics {
+		if c.ctx.Err() != nil {
+			return
+		}
+		topic, err := c.topic(name)
+		if err != nil {
+			c.connectError(name, err)
+			continue
+		}
+		history, err := c.ps.SubscribeWithOptions(c.ctx, c.id(), topic, pubsub.SubscribeOptions{
+			LastN:    req.lastN,
+			Consumer: c.id(),
+		}, c)
+		if err != nil {
+			c.connectError(name, err)
+			continue
+		}
+		log.Printf("Subscribed client %s to topic %s at connect", c.id(), topic)
+
+		if err := c.sendMessage(pubsub.AckResponse{
+			Type:      "ack",
+			Topic:     name,
+			Status:    "ok",
+			Connect:   true,
+			Timestamp: c.clock.Now(),
+		}); err != nil {
+			return
+		}
+		histories = append(histories, history)
+	}
+
+	for _, history := range histories {
+		for _, event := range history {
+			if err := c.sendMessage(event); err != nil {
+				log.Printf("Error sending last message to client %s: %v", c.id(), err)
+			}
+		}
+	}
+}
+
+// connectError answers a connect request that failed, for one topic or,
+// with topic empty, for the client ID
+func (c *Client) connectError(topic string, err error) {
+	c.sendMessage(pubsub.ErrorResponse{
+		Type:      "error",
+		Topic:     topic,
+		Connect:   true,
+		Error:     pubsub.NewErrorData(err),
+		Timestamp: c.clock.Now(),
+	})
+}
@@ -0,0 +1,139 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// roomsServer serves a system with rooms room1 and room2, each holding
+// three messages
+func roomsServer(t *testing.T) (*pubsub.PubSubSystem, *httptest.Server) {
+	t.Helper()
+	ps := pubsub.New()
+	for _, room := range []string{"room1", "room2"} {
+		if err := ps.CreateTopic(context.Background(), room); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			if err := ps.Publish(context.Background(), room, pubsub.MessageData{ID: fmt.Sprintf("%s-%d", room, i), Payload: i}, ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return ps, serve(t, ps, WebSocketOptions{})
+}
+
+// nextFrame reads c's next frame, whatever its type
+func (c *wireClient) nextFrame() map[string]interface{} {
+	c.t.Helper()
+	var frame map[string]interface{}
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := c.conn.ReadJSON(&frame); err != nil {
+		c.t.Fatalf("reading frame: %v", err)
+	}
+	return frame
+}
+
+func TestSubscribeInUpgradeURL(t *testing.T) {
+	ps, server := roomsServer(t)
+	c, welcome := dialWelcome(t, server, "?client_id=abc&topics=room1,missing,room2,bad::name&last_n=2", nil)
+	if welcome.ClientID != "abc" {
+		t.Fatalf("welcome client_id = %s, want the claimed abc", welcome.ClientID)
+	}
+
+	// Each topic is answered in order, a failure reported for its topic
+	// alone, and then the histories are replayed
+	want := []string{
+		"ack room1", "error missing TOPIC_NOT_FOUND", "ack room2", "error bad::name VALIDATION_FAILED",
+		"event room1-1", "event room1-2", "event room2-1", "event room2-2",
+	}
+	for i, expected := range want {
+		frame := c.nextFrame()
+		kind, _, payload := v1Answer(frame)
+		got := kind
+		switch kind {
+		case "ack":
+			got += fmt.Sprintf(" %v", frame["topic"])
+			if payload["connect"] != true {
+				t.Errorf("frame %d: ack %v not flagged connect", i, payload)
+			}
+		case "event":
+			got += " " + messageID(frame)
+		case "error":
+			got += fmt.Sprintf(" %v %v", frame["topic"], payload["code"])
+		}
+		if got != expected {
+			t.Fatalf("frame %d = %s, want %s", i, got, expected)
+		}
+	}
+
+	// Live events follow, and the message-based flow works as before
+	if err := ps.Publish(context.Background(), "room2", pubsub.MessageData{ID: "live", Payload: "x"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if event := c.expect("event"); messageID(event) != "live" {
+		t.Errorf("live event = %v", event)
+	}
+	if ack := c.request(map[string]interface{}{"type": "unsubscribe", "topic": "room1", "client_id": "abc", "request_id": "u-1"}); ack["type"] != "ack" {
+		t.Errorf("unsubscribe answered with %v", ack)
+	}
+	waitFor(t, "the unsubscribe", func() bool {
+		topics := ps.GetClientTopics("abc")
+		return len(topics) == 1 && topics[0] == "room2"
+	})
+
+	// Like any other first request, the subscriptions rule out a hello
+	c.send(map[string]interface{}{"type": "hello", "protocol_version": 2, "request_id": "h-1"})
+	if kind, _, payload := v1Answer(c.expect("error")); kind != "error" || !strings.Contains(fmt.Sprint(payload["message"]), "hello must be the first message") {
+		t.Errorf("late hello answered with %v", payload)
+	}
+}
+
+func TestSubscribeInUpgradeURLWithProtocolV2(t *testing.T) {
+	_, server := roomsServer(t)
+	c, _ := dialWelcome(t, server, "?topics=room1,missing&protocol_version=2", nil)
+
+	if ack := c.nextFrame(); ack["type"] != "ack" || ack["topic"] != "room1" || ack["connect"] != true {
+		t.Errorf("ack = %v", ack)
+	}
+	failure := c.expect("error")
+	if failure["topic"] != "missing" || failure["connect"] != true || failure["error"].(map[string]interface{})["code"] != "TOPIC_NOT_FOUND" {
+		t.Errorf("error = %v", failure)
+	}
+}
+
+func TestRefusedClaimInUpgradeURLSubscribesNothing(t *testing.T) {
+	ps, server := roomsServer(t)
+	dialWelcome(t, server, "?client_id=abc", nil)
+
+	c, welcome := dialWelcome(t, server, "?client_id=abc&topics=room1&protocol_version=2", nil)
+	if welcome.ClientID == "abc" {
+		t.Fatal("second connection was given the held client_id")
+	}
+	failure := c.nextFrame()
+	if failure["type"] != "error" || failure["connect"] != true || errorReason(failure) != "CLIENT_ID_IN_USE" {
+		t.Errorf("claim answered with %v", failure)
+	}
+	if topics := ps.GetClientTopics(welcome.ClientID); len(topics) != 0 {
+		t.Errorf("subscribed to %v under the assigned ID", topics)
+	}
+}
+
+func TestMalformedUpgradeURLIsRefused(t *testing.T) {
+	_, server := roomsServer(t)
+	for _, query := range []string{"?last_n=-1", "?last_n=many", "?protocol_version=9"} {
+		_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+query, nil)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: dial = %v, %v; want 400", query, resp, err)
+		}
+	}
+}
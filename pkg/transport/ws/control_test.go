@@ -0,0 +1,103 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// flood publishes to "orders" until stop is closed
+func flood(ps *pubsub.PubSubSystem, stop chan struct{}) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			ps.Publish(context.Background(), "orders", pubsub.MessageData{ID: fmt.Sprintf("f%d", i), Payload: strings.Repeat("x", 512)}, "")
+		}
+	}()
+	return &wg
+}
+
+// readUntil reads frames, batched or not, until done returns true for one
+func readUntil(t *testing.T, conn *websocket.Conn, what string, done func(message map[string]interface{}) bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		conn.SetReadDeadline(deadline)
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", what, err)
+		}
+		frame := batchFrame{}
+		if frame.array = data[0] == '['; frame.array {
+			err = json.Unmarshal(data, &frame.messages)
+		} else {
+			var message map[string]interface{}
+			err = json.Unmarshal(data, &message)
+			frame.messages = append(frame.messages, message)
+		}
+		if err != nil {
+			t.Fatalf("decoding %s: %v", data, err)
+		}
+		for _, message := range frame.messages {
+			if done(message) {
+				return
+			}
+		}
+	}
+}
+
+func TestAcksArriveDuringEventFlood(t *testing.T) {
+	ps := pubsub.New()
+	ctx := context.Background()
+	const subscribes = 40
+	if err := ps.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < subscribes; i++ {
+		if err := ps.CreateTopic(ctx, fmt.Sprintf("t%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := serve(t, ps, WebSocketOptions{})
+	conn, _ := dial(t, server, "", nil)
+	conn.WriteJSON(map[string]interface{}{"type": "subscribe", "topic": "orders", "request_id": "s-orders"})
+
+	// Events pile up unread while the subscribes go out, overflowing the
+	// event queue many times over
+	stop := make(chan struct{})
+	flooding := flood(ps, stop)
+	waitFor(t, "events to be dropped", func() bool { return ps.GetHealth().DroppedLastMinute > 0 })
+	for i := 0; i < subscribes; i++ {
+		conn.WriteJSON(map[string]interface{}{"type": "subscribe", "topic": fmt.Sprintf("t%d", i), "request_id": fmt.Sprintf("s-%d", i)})
+	}
+	waitFor(t, "the subscribes", func() bool {
+		detail, _ := ps.GetTopicDetail(fmt.Sprintf("t%d", subscribes-1))
+		return detail.Subscribers == 1
+	})
+	close(stop)
+	flooding.Wait()
+
+	acked := make(map[string]bool)
+	readUntil(t, conn, "every ack", func(message map[string]interface{}) bool {
+		if message["type"] == "ack" {
+			inner, _ := message["message"].(map[string]interface{})
+			acked[fmt.Sprint(inner["id"])] = true
+		}
+		return len(acked) == subscribes+1
+	})
+}
@@ -0,0 +1,47 @@
+package ws
+
+import (
+	"log"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// handleSendToClient sends a direct message to another client and answers
+// with whether it was delivered, held or the target is offline
+func (c *Client) handleSendToClient(req pubsub.SendToClientRequest) error {
+	if req.RequestID == "" {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "request_id is required"}
+	}
+	if err := c.claimClientID(req.ClientID); err != nil {
+		return err
+	}
+
+	status, err := c.ps.SendDirect(c.ctx, c.id(), req.Target, req.Message, pubsub.DirectOptions{
+		Hold:          req.BufferIfOffline,
+		CorrelationID: req.CorrelationID,
+	})
+	if err != nil {
+		return c.respond(pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.NewErrorData(err),
+			Timestamp: c.clock.Now(),
+		})
+	}
+	log.Printf("Direct message %s from client %s to %s: %s", req.Message.ID, c.id(), req.Target, status)
+
+	return c.respond(pubsub.AckResponse{
+		Type:      "ack",
+		RequestID: req.RequestID,
+		Status:    status,
+		Timestamp: c.clock.Now(),
+	})
+}
+
+// deliverHeldDirect sends the direct messages held for the connection's
+// client ID, once the welcome frame is queued
+func (c *Client) deliverHeldDirect() {
+	if n := c.ps.DeliverHeldDirect(c); n > 0 {
+		log.Printf("Delivered %d held direct messages to client %s", n, c.id())
+	}
+}
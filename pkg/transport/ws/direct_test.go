@@ -0,0 +1,51 @@
+package ws
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// sendTo asks c to send a direct message to target and returns the answer
+func (c *wireClient) sendTo(target string, buffer bool) map[string]interface{} {
+	c.t.Helper()
+	return c.request(map[string]interface{}{
+		"type":              "send_to_client",
+		"target_client_id":  target,
+		"buffer_if_offline": buffer,
+		"client_id":         "alice",
+		"request_id":        uuid.New().String(),
+		"message":           map[string]interface{}{"id": uuid.New().String(), "payload": "refresh"},
+	})
+}
+
+func TestSendToClient(t *testing.T) {
+	server := serve(t, pubsub.New(), WebSocketOptions{})
+	bob, _ := dialWelcome(t, server, "?client_id=bob&protocol_version=2", nil)
+	alice := dialV2(t, server)
+
+	if ack := alice.sendTo("bob", false); ack["type"] != "ack" || ack["status"] != "delivered" {
+		t.Fatalf("send to bob answered with %v", ack)
+	}
+	if direct := bob.expect("direct"); direct["from"] != "alice" || direct["message"].(map[string]interface{})["payload"] != "refresh" {
+		t.Errorf("bob got %v", direct)
+	}
+
+	if ack := alice.sendTo("carol", false); ack["status"] != "offline" {
+		t.Errorf("send to offline carol answered with %v", ack)
+	}
+	if ack := alice.sendTo("carol", true); ack["status"] != "buffered" {
+		t.Errorf("held send to carol answered with %v", ack)
+	}
+	if failure := alice.sendTo("", false); errorCode(failure) != "VALIDATION_FAILED" {
+		t.Errorf("send without a target answered with %v", failure)
+	}
+
+	// Carol gets the held message right after her welcome
+	carol, _ := dialWelcome(t, server, "?client_id=carol", nil)
+	if direct := carol.nextFrame(); direct["type"] != "direct" || direct["from"] != "alice" {
+		t.Errorf("carol's first frame = %v", direct)
+	}
+}
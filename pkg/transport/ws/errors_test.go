@@ -0,0 +1,39 @@
+package ws
+
+import (
+	"testing"
+)
+
+func TestErrorCodesOverWebsocket(t *testing.T) {
+	ps, server := roomsServer(t)
+	owner := dialV2(t, server)
+	owner.request(map[string]interface{}{"type": "create_topic", "topic": "owned", "client_id": "owner", "request_id": "c-1"})
+	c := dialV2(t, server)
+
+	cases := []struct {
+		name string
+		req  map[string]interface{}
+		code string
+	}{
+		{"subscribe to a missing topic", map[string]interface{}{"type": "subscribe", "topic": "missing", "request_id": "r-1"}, "TOPIC_NOT_FOUND"},
+		{"create an existing topic", map[string]interface{}{"type": "create_topic", "topic": "room1", "request_id": "r-2"}, "TOPIC_EXISTS"},
+		{"unsubscribe without a subscription", map[string]interface{}{"type": "unsubscribe", "topic": "room1", "request_id": "r-3"}, "NOT_SUBSCRIBED"},
+		{"delete another client's topic", map[string]interface{}{"type": "delete_topic", "topic": "owned", "request_id": "r-4"}, "PERMISSION_DENIED"},
+		{"publish a bad message ID", map[string]interface{}{"type": "publish", "topic": "room1", "message": map[string]interface{}{"id": "nope"}, "request_id": "r-5"}, "VALIDATION_FAILED"},
+		{"subscribe with an unknown ack mode", map[string]interface{}{"type": "subscribe", "topic": "room1", "ack_mode": "sometimes", "request_id": "r-6"}, "VALIDATION_FAILED"},
+	}
+	for _, tc := range cases {
+		failure := c.request(tc.req)
+		errorData, _ := failure["error"].(map[string]interface{})
+		if failure["type"] != "error" || errorData["code"] != tc.code || errorData["retryable"] != false {
+			t.Errorf("%s answered with %v, want %s", tc.name, failure, tc.code)
+		}
+	}
+
+	// A busy server says to come back later
+	ps.Drain()
+	failure := c.request(map[string]interface{}{"type": "subscribe", "topic": "room2", "request_id": "r-7"})
+	if errorCode(failure) != "SERVER_BUSY" || errorReason(failure) != "SERVER_DRAINING" || failure["error"].(map[string]interface{})["retryable"] != true {
+		t.Errorf("subscribing while draining answered with %v", failure)
+	}
+}
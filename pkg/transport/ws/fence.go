@@ -0,0 +1,58 @@
+package ws
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// fences keeps a subscription's events from reaching the client after it
+// was told the subscription ended. Acks and notices go out on the priority
+// queue, so events already queued for the topic would otherwise follow
+// them; instead each queued event is numbered, ending a subscription fences
+// its topic at the latest number, and the write pump drops the topic's
+// events at or below the fence.
+type fences struct {
+	// Events queued so far; an event's number is the count after it
+	queued atomic.Uint64
+
+	mutex   sync.Mutex
+	byTopic map[string]uint64
+}
+
+// stamp numbers an event on its way into the queue
+func (f *fences) stamp(frame *outboundFrame) {
+	if frame.topic != "" {
+		frame.seq = f.queued.Add(1)
+	}
+}
+
+// raise fences off the events queued so far for topic. The pub-sub system
+// has delivered everything for the ended subscription by then, so those
+// are all of its events still queued.
+func (f *fences) raise(topic string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.byTopic == nil {
+		f.byTopic = make(map[string]uint64)
+	}
+	f.byTopic[topic] = f.queued.Load()
+}
+
+// passes reports whether a dequeued frame may be written. The queue is in
+// order, so the first of a topic's events past its fence lifts the fence.
+func (f *fences) passes(frame outboundFrame) bool {
+	if frame.topic == "" {
+		return true
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	fence, fenced := f.byTopic[frame.topic]
+	if !fenced {
+		return true
+	}
+	if frame.seq <= fence {
+		return false
+	}
+	delete(f.byTopic, frame.topic)
+	return true
+}
@@ -0,0 +1,134 @@
+package ws
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// floodServer serves a system delivering on fanout workers, with "orders"
+// published to continuously and a client subscribed to "markers" only
+func floodServer(t *testing.T) (*pubsub.PubSubSystem, *wireClient, string) {
+	t.Helper()
+	ps := pubsub.New()
+	if err := ps.EnableFanoutWorkers(4); err != nil {
+		t.Fatal(err)
+	}
+	for _, topic := range []string{"orders", "markers"} {
+		if err := ps.CreateTopic(context.Background(), topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := serve(t, ps, WebSocketOptions{SendBufferSize: 1024})
+	c, welcome := dialWelcome(t, server, "", nil)
+	if frame := c.request(map[string]interface{}{"type": "subscribe", "topic": "markers", "client_id": welcome.ClientID, "request_id": "m"}); frame["type"] != "ack" {
+		t.Fatalf("subscribing to markers = %v", frame)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// Bursts outpace the client; publishing fails while the topic
+			// is deleted
+			for i := 0; i < 200; i++ {
+				ps.Publish(context.Background(), "orders", pubsub.MessageData{ID: uuid.NewString(), Payload: "flood"}, "")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		wg.Wait()
+	})
+	return ps, c, welcome.ClientID
+}
+
+// skipTo reads frames until one of type kind
+func skipTo(c *wireClient, kind string) {
+	c.t.Helper()
+	for c.nextFrame()["type"] != kind {
+	}
+}
+
+// afterMarker publishes markers until the client reads one and returns how
+// many orders events it read before that. Anything queued for orders so far
+// comes first; markers are published again in case the queue was full.
+func afterMarker(t *testing.T, ps *pubsub.PubSubSystem, c *wireClient) int {
+	t.Helper()
+	marker := uuid.NewString()
+	seen := make(chan struct{})
+	defer close(seen)
+	go func() {
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			ps.Publish(context.Background(), "markers", pubsub.MessageData{ID: uuid.NewString(), Payload: marker}, "")
+			select {
+			case <-seen:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	events := 0
+	for {
+		frame := c.nextFrame()
+		if frame["type"] != "event" {
+			continue
+		}
+		if frame["topic"] == "orders" {
+			events++
+			continue
+		}
+		if message, _ := frame["message"].(map[string]interface{}); message["payload"] == marker {
+			return events
+		}
+	}
+}
+
+func TestUnsubscribeFencesQueuedEvents(t *testing.T) {
+	ps, c, clientID := floodServer(t)
+	for cycle := 0; cycle < 50; cycle++ {
+		c.send(map[string]interface{}{"type": "subscribe", "topic": "orders", "client_id": clientID, "request_id": uuid.NewString()})
+		skipTo(c, "ack")
+		c.send(map[string]interface{}{"type": "unsubscribe", "topic": "orders", "client_id": clientID, "request_id": uuid.NewString()})
+		skipTo(c, "ack")
+		if events := afterMarker(t, ps, c); events != 0 {
+			t.Fatalf("cycle %d: %d orders events after the unsubscribe ack", cycle, events)
+		}
+	}
+}
+
+func TestDeleteTopicFencesQueuedEvents(t *testing.T) {
+	ps, c, clientID := floodServer(t)
+	for cycle := 0; cycle < 20; cycle++ {
+		if cycle > 0 {
+			if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		c.send(map[string]interface{}{"type": "subscribe", "topic": "orders", "client_id": clientID, "request_id": uuid.NewString()})
+		skipTo(c, "ack")
+		if err := ps.DeleteTopic(context.Background(), "orders"); err != nil {
+			t.Fatal(err)
+		}
+		skipTo(c, "info")
+		if events := afterMarker(t, ps, c); events != 0 {
+			t.Fatalf("cycle %d: %d orders events after topic_deleted", cycle, events)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package ws
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+func TestFirehoseNeedsAnAdminConnection(t *testing.T) {
+	ps := pubsub.New()
+	for _, name := range []string{"orders", "payments"} {
+		if err := ps.CreateTopic(context.Background(), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := NewHandler(ps, WebSocketOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	admin := httptest.NewServer(h.Admin())
+	t.Cleanup(admin.Close)
+	firehose := func(c *wireClient, clientID string) map[string]interface{} {
+		return c.request(map[string]interface{}{"type": "subscribe", "firehose": true, "client_id": clientID, "request_id": "f-" + clientID})
+	}
+
+	c, _ := dialWelcome(t, server, "", nil)
+	frame := firehose(c, "snoop")
+	message, _ := frame["message"].(map[string]interface{})
+	data, _ := message["payload"].(map[string]interface{})
+	if data["code"] != "PERMISSION_DENIED" {
+		t.Errorf("firehose on a plain connection = %v", frame)
+	}
+
+	tap, _ := dialWelcome(t, admin, "", nil)
+	if frame := firehose(tap, "operator"); frame["type"] != "ack" {
+		t.Fatalf("firehose on an admin connection = %v", frame)
+	}
+	for _, topic := range []string{"orders", "payments"} {
+		if err := ps.Publish(context.Background(), topic, pubsub.MessageData{ID: uuid.NewString(), Payload: topic}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, topic := range []string{"orders", "payments"} {
+		tapped := tap.expect(pubsub.FirehoseEventType)
+		if tapped["topic"] != topic || tapped["namespace"] != pubsub.DefaultNamespace {
+			t.Errorf("firehose copy = %v, want one from %s", tapped, topic)
+		}
+	}
+	if detail, _ := ps.GetTopicDetail("orders"); detail.Subscribers != 0 {
+		t.Errorf("the firehose counts as %d subscribers", detail.Subscribers)
+	}
+}
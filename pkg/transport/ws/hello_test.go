@@ -0,0 +1,148 @@
+package ws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// helloSession runs the same requests on a new connection, after a hello
+// for version when it is non-zero, and returns every frame answering them
+func helloSession(t *testing.T, version int) []map[string]interface{} {
+	t.Helper()
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	conn, _ := dial(t, serve(t, ps, WebSocketOptions{}), "", nil)
+	requests := []map[string]interface{}{
+		{"type": "subscribe", "topic": "orders", "request_id": "s-1"},
+		{"type": "unsubscribe", "topic": "orders", "request_id": "u-1"},
+		{"type": "subscribe", "topic": "missing", "request_id": "s-2"},
+		{"type": "hello", "protocol_version": 1, "request_id": "h-2"},
+	}
+	if version != 0 {
+		requests = append([]map[string]interface{}{{"type": "hello", "protocol_version": version, "capabilities": []string{"future-thing"}, "request_id": "h-1"}}, requests...)
+	}
+	for _, req := range requests {
+		if err := conn.WriteJSON(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	frames := make([]map[string]interface{}, len(requests))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := range frames {
+		if err := conn.ReadJSON(&frames[i]); err != nil {
+			t.Fatalf("reading answer %d: %v", i, err)
+		}
+	}
+	return frames
+}
+
+// v1Answer returns a v1 frame's type, request ID and payload, which v1
+// wraps in an event envelope
+func v1Answer(frame map[string]interface{}) (string, interface{}, map[string]interface{}) {
+	message, _ := frame["message"].(map[string]interface{})
+	payload, _ := message["payload"].(map[string]interface{})
+	return frame["type"].(string), message["id"], payload
+}
+
+func TestProtocolV1WithoutHello(t *testing.T) {
+	checkV1(t, helloSession(t, 0))
+}
+
+func TestProtocolV1NegotiatedByHello(t *testing.T) {
+	frames := helloSession(t, 1)
+	kind, id, ack := v1Answer(frames[0])
+	capabilities, _ := ack["capabilities"].([]interface{})
+	if kind != "hello_ack" || id != "h-1" || ack["protocol_version"] != 1.0 || len(capabilities) == 0 || ack["limits"] == nil {
+		t.Errorf("hello_ack = %v", frames[0])
+	}
+	// Same as a client that never said hello
+	checkV1(t, frames[1:])
+}
+
+// checkV1 checks the answers to helloSession's requests under version 1
+func checkV1(t *testing.T, frames []map[string]interface{}) {
+	t.Helper()
+	for i, want := range []struct {
+		kind string
+		id   interface{}
+		code string
+	}{
+		{"ack", "s-1", ""},
+		// client_id is required, and handler errors are generic
+		{"error", "", "PROCESSING_ERROR"},
+		{"error", "s-2", "TOPIC_NOT_FOUND"},
+		{"error", "", "PROCESSING_ERROR"},
+	} {
+		kind, id, payload := v1Answer(frames[i])
+		if kind != want.kind || id != want.id || (want.code != "" && payload["code"] != want.code) {
+			t.Errorf("answer %d = %v, want %s %v %s", i, frames[i], want.kind, want.id, want.code)
+		}
+	}
+}
+
+func TestProtocolV2NegotiatedByHello(t *testing.T) {
+	frames := helloSession(t, 2)
+	ack := frames[0]
+	capabilities, _ := ack["capabilities"].([]interface{})
+	if ack["type"] != "hello_ack" || ack["request_id"] != "h-1" || ack["protocol_version"] != 2.0 || ack["limits"] == nil || ack["message"] != nil {
+		t.Errorf("hello_ack = %v", ack)
+	}
+	// Unknown capabilities are ignored; the server lists its own
+	for _, capability := range capabilities {
+		if capability == "future-thing" {
+			t.Errorf("server claims the client's unknown capability: %v", capabilities)
+		}
+	}
+	if len(capabilities) != len(serverCapabilities) {
+		t.Errorf("capabilities = %v, want %v", capabilities, serverCapabilities)
+	}
+
+	// Responses have their own shapes, client_id may be left out and
+	// errors keep their codes
+	for i, want := range []struct {
+		kind, id, code string
+	}{
+		{"ack", "s-1", ""},
+		{"ack", "u-1", ""},
+		{"error", "s-2", "TOPIC_NOT_FOUND"},
+		{"error", "", "VALIDATION_FAILED"},
+	} {
+		frame := frames[i+1]
+		data, _ := frame["error"].(map[string]interface{})
+		id, _ := frame["request_id"].(string)
+		if frame["type"] != want.kind || id != want.id || (want.code != "" && data["code"] != want.code) || frame["message"] != nil {
+			t.Errorf("answer %d = %v, want %s %s %s", i, frame, want.kind, want.id, want.code)
+		}
+	}
+}
+
+func TestUnsupportedProtocolVersionCloses(t *testing.T) {
+	server := serve(t, pubsub.New(), WebSocketOptions{})
+	for _, version := range []int{pubsub.MaxProtocolVersion + 1, 99, 0, -1} {
+		conn, _ := dial(t, server, "", nil)
+		conn.WriteJSON(map[string]interface{}{"type": "hello", "protocol_version": version, "request_id": "h-1"})
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		var frame map[string]interface{}
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if kind, id, payload := v1Answer(frame); kind != "error" || id != "h-1" || payload["reason"] != "UNSUPPORTED_PROTOCOL_VERSION" {
+			t.Errorf("version %d answered with %v", version, frame)
+		}
+
+		// Then the close, and nothing the client sends is answered
+		conn.WriteJSON(map[string]interface{}{"type": "subscribe", "topic": "orders", "request_id": "s-1"})
+		_, data, err := conn.ReadMessage()
+		if !websocket.IsCloseError(err, CloseUnsupportedVersion) {
+			t.Errorf("version %d: after the error got %s, %v; want close %d", version, data, err, CloseUnsupportedVersion)
+		}
+	}
+}
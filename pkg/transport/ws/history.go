@@ -0,0 +1,84 @@
+package ws
+
+import (
+	"time"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+const (
+	DefaultHistoryPageSize = 50  // Messages in a get_history page without a limit
+	MaxHistoryPageSize     = 500 // Upper bound for a get_history limit
+
+	// historyEnvelope is room left in a history frame for its other fields
+	historyEnvelope = 512
+)
+
+// handleGetHistory answers a get_history with a page of the topic's
+// history. Subscribing isn't needed, and the connection's subscriptions
+// and queues are left alone, but private topics still need membership.
+func (c *Client) handleGetHistory(req pubsub.GetHistoryRequest) error {
+	if req.RequestID == "" {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "request_id is required"}
+	}
+	if req.Limit < 0 || req.BeforeSeq < 0 {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "limit and before_seq must not be negative"}
+	}
+	topic, err := c.topic(req.Topic)
+	if err != nil {
+		return err
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = DefaultHistoryPageSize
+	}
+	limit = min(limit, MaxHistoryPageSize)
+	var beforeTS time.Time
+	if req.BeforeTS != nil {
+		beforeTS = *req.BeforeTS
+	}
+
+	reader := c.id()
+	if c.admin {
+		reader = ""
+	}
+	events, next, err := c.ps.ReadHistory(c.ctx, reader, topic, req.BeforeSeq, beforeTS, limit)
+	if err != nil {
+		return c.sendMessage(pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Topic:     req.Topic,
+			Error:     pubsub.NewErrorData(err),
+			Timestamp: c.clock.Now(),
+		})
+	}
+
+	// A page that wouldn't fit in one frame is cut short, dropping its
+	// oldest messages for the next page
+	budget := c.maxFrameSize() - historyEnvelope
+	for i := len(events) - 1; i >= 0; i-- {
+		event := pubsub.LocalizeMessage(events[i]).(pubsub.EventResponse)
+		events[i] = event
+		encoded, err := c.codec.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if budget -= len(encoded); budget < 0 && i < len(events)-1 {
+			events = events[i+1:]
+			next = events[0].Seq
+			break
+		}
+	}
+
+	response := pubsub.HistoryResponse{
+		Type:      "history",
+		RequestID: req.RequestID,
+		Topic:     req.Topic,
+		Messages:  events,
+		Timestamp: c.clock.Now(),
+	}
+	if next > 0 {
+		response.NextCursor = &next
+	}
+	return c.sendMessage(response)
+}
@@ -0,0 +1,93 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// getHistory reads a page of orders' history, before beforeSeq when set
+func (c *wireClient) getHistory(beforeSeq interface{}, limit int) map[string]interface{} {
+	c.t.Helper()
+	request := map[string]interface{}{"type": "get_history", "topic": "orders", "limit": limit, "request_id": fmt.Sprint("h-", beforeSeq)}
+	if beforeSeq != nil {
+		request["before_seq"] = beforeSeq
+	}
+	c.send(request)
+	return c.expect("history")
+}
+
+func TestGetHistoryPagesBackwards(t *testing.T) {
+	ps := pubsub.New()
+	ctx := context.Background()
+	if err := ps.CreateTopic(ctx, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 500; i++ {
+		if err := ps.Publish(ctx, "orders", pubsub.MessageData{ID: fmt.Sprintf("o-%d", i), Payload: i}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := serve(t, ps, WebSocketOptions{})
+
+	// Without subscribing, the whole history comes back in pages of 50,
+	// each oldest first
+	c := dialV2(t, server)
+	var cursor interface{}
+	want := 500
+	for pages := 0; ; pages++ {
+		page := c.getHistory(cursor, 50)
+		messages := page["messages"].([]interface{})
+		if len(messages) != 50 {
+			t.Fatalf("page %d has %d messages", pages, len(messages))
+		}
+		for i, message := range messages {
+			if seq := message.(map[string]interface{})["seq"]; seq != float64(want-49+i) {
+				t.Fatalf("page %d message %d has seq %v, want %d", pages, i, seq, want-49+i)
+			}
+		}
+		want -= 50
+		if cursor = page["next_cursor"]; cursor == nil {
+			break
+		}
+	}
+	if want != 0 {
+		t.Fatalf("paging stopped with %d messages left", want)
+	}
+
+	// A subscriber reading history keeps its subscription as it was
+	c.send(map[string]interface{}{"type": "subscribe", "topic": "orders", "request_id": "s-1"})
+	c.expect("ack")
+	if page := c.getHistory(nil, 2); len(page["messages"].([]interface{})) != 2 || page["next_cursor"] != float64(499) {
+		t.Errorf("subscriber's page = %v", page)
+	}
+	if err := ps.Publish(ctx, "orders", pubsub.MessageData{ID: "o-501"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if event := c.expect("event"); event["seq"] != float64(501) {
+		t.Errorf("subscriber got %v after reading history", event)
+	}
+	if subscribers := ps.GetStats().Topics["orders"].Subscribers; subscribers != 1 {
+		t.Errorf("subscribers = %d, want 1", subscribers)
+	}
+}
+
+func TestGetHistoryOfAPrivateTopic(t *testing.T) {
+	ps := pubsub.New()
+	ctx := context.Background()
+	if err := ps.CreateTopicWithConfig(ctx, "orders", pubsub.TopicConfig{Owner: "alice", Private: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Publish(ctx, "orders", pubsub.MessageData{ID: "o-1"}, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	server := serve(t, ps, WebSocketOptions{})
+
+	bob := dialV2(t, server)
+	bob.send(map[string]interface{}{"type": "get_history", "topic": "orders", "request_id": "h-1"})
+	if failure := bob.expect("error"); errorCode(failure) != "PERMISSION_DENIED" || errorReason(failure) != "JOIN_REQUIRED" {
+		t.Errorf("non-member read answered with %v", failure)
+	}
+}
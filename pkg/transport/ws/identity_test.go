@@ -0,0 +1,276 @@
+package ws
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// dialWelcome connects like dial and wraps the connection in a JSON
+// wireClient, returning the welcome frame too
+func dialWelcome(t *testing.T, server *httptest.Server, query string, header http.Header) (*wireClient, pubsub.WelcomeResponse) {
+	t.Helper()
+	conn, welcome := dial(t, server, query, header)
+	return &wireClient{t: t, conn: conn, codec: pubsub.JSONCodec}, welcome
+}
+
+// subscribeAs subscribes c to "orders" as clientID and returns the answer,
+// an ack or an error. Each claim has its own request_id, so none is
+// answered from the duplicate request cache.
+func (c *wireClient) subscribeAs(clientID string) map[string]interface{} {
+	c.t.Helper()
+	return c.request(map[string]interface{}{"type": "subscribe", "topic": "orders", "client_id": clientID, "request_id": "s-" + clientID})
+}
+
+// request sends req and returns the answer, an ack or an error
+func (c *wireClient) request(req map[string]interface{}) map[string]interface{} {
+	c.t.Helper()
+	c.send(req)
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var frame map[string]interface{}
+		if err := c.conn.ReadJSON(&frame); err != nil {
+			c.t.Fatalf("waiting for the subscribe answer: %v", err)
+		}
+		if frame["type"] == "ack" || frame["type"] == "error" {
+			return frame
+		}
+	}
+}
+
+// errorMessage returns the message of an error frame, which is wrapped in
+// an event envelope, or "" for any other frame
+func errorMessage(frame map[string]interface{}) string {
+	if frame["type"] != "error" {
+		return ""
+	}
+	message, _ := frame["message"].(map[string]interface{})
+	data, _ := message["payload"].(map[string]interface{})
+	text, _ := data["message"].(string)
+	return text
+}
+
+func identityServer(t *testing.T, opts WebSocketOptions) (*pubsub.PubSubSystem, *httptest.Server) {
+	t.Helper()
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	return ps, serve(t, ps, opts)
+}
+
+func TestWelcomeAssignsClientIDToAdopt(t *testing.T) {
+	ps, server := identityServer(t, WebSocketOptions{})
+	c, welcome := dialWelcome(t, server, "", nil)
+	if _, err := uuid.Parse(welcome.ClientID); err != nil {
+		t.Fatalf("assigned client_id %q is not a UUID", welcome.ClientID)
+	}
+	if welcome.ProtocolVersion != pubsub.ProtocolVersion1 || welcome.Limits.MaxClientIDLength != maxClientIDLength ||
+		welcome.Limits.MaxMessageSize == 0 || welcome.Limits.SendBufferSize != pubsub.ClientSendBufferSize {
+		t.Errorf("welcome = %+v", welcome)
+	}
+	if _, other := dialWelcome(t, server, "", nil); other.ClientID == welcome.ClientID {
+		t.Errorf("two connections were both assigned %s", welcome.ClientID)
+	}
+
+	// Adopting the assigned ID
+	if frame := c.subscribeAs(welcome.ClientID); frame["type"] != "ack" {
+		t.Fatalf("subscribing as the assigned ID = %v", frame)
+	}
+	if got := ps.GetClientTopics(welcome.ClientID); !reflect.DeepEqual(got, []string{"orders"}) {
+		t.Errorf("assigned ID subscribed to %v", got)
+	}
+
+	// Cleanup goes by the assigned ID
+	c.conn.Close()
+	waitFor(t, "the subscription to go", func() bool { return len(ps.GetClientTopics(welcome.ClientID)) == 0 })
+}
+
+func TestUnidentifiedClientIsCleanedUp(t *testing.T) {
+	ps, server := identityServer(t, WebSocketOptions{})
+	c, welcome := dialWelcome(t, server, "", nil)
+	if !connected(ps, welcome.ClientID) {
+		t.Fatal("connection is not registered under its assigned ID")
+	}
+	c.conn.Close()
+	waitFor(t, "the connection to go", func() bool { return !connected(ps, welcome.ClientID) })
+}
+
+func TestClaimingClientID(t *testing.T) {
+	ps, server := identityServer(t, WebSocketOptions{})
+	c, welcome := dialWelcome(t, server, "", nil)
+	if frame := c.subscribeAs("dashboard"); frame["type"] != "ack" {
+		t.Fatalf("claiming dashboard = %v", frame)
+	}
+	if got := ps.GetClientTopics("dashboard"); !reflect.DeepEqual(got, []string{"orders"}) {
+		t.Errorf("dashboard subscribed to %v", got)
+	}
+	if connected(ps, welcome.ClientID) {
+		t.Errorf("assigned ID %s still connected after claiming another", welcome.ClientID)
+	}
+
+	// Once bound, the ID can't change
+	if text := errorMessage(c.subscribeAs("someone-else")); !strings.Contains(text, "mismatch") {
+		t.Errorf("claiming a second ID = %q", text)
+	}
+}
+
+func TestClaimedClientIDValidation(t *testing.T) {
+	_, server := identityServer(t, WebSocketOptions{})
+	for claimed, want := range map[string]string{
+		strings.Repeat("x", maxClientIDLength+1): "at most",
+	} {
+		c, _ := dialWelcome(t, server, "", nil)
+		if text := errorMessage(c.subscribeAs(claimed)); !strings.Contains(text, want) {
+			t.Errorf("claiming %q = %q", claimed, text)
+		}
+	}
+
+	// Protocol v1 requests other than a subscribe can't leave it out
+	c, _ := dialWelcome(t, server, "", nil)
+	unsubscribe := map[string]interface{}{"type": "unsubscribe", "topic": "orders", "request_id": "u-1"}
+	if text := errorMessage(c.request(unsubscribe)); !strings.Contains(text, "required") {
+		t.Errorf("unsubscribe without a client_id = %q", text)
+	}
+
+	// Right at the limit is fine
+	c, _ = dialWelcome(t, server, "", nil)
+	if frame := c.subscribeAs(strings.Repeat("x", maxClientIDLength)); frame["type"] != "ack" {
+		t.Errorf("client_id of %d bytes = %v", maxClientIDLength, frame)
+	}
+}
+
+func TestClaimedClientIDCollision(t *testing.T) {
+	ps, server := identityServer(t, WebSocketOptions{})
+	holder, _ := dialWelcome(t, server, "", nil)
+	if frame := holder.subscribeAs("dashboard"); frame["type"] != "ack" {
+		t.Fatalf("claiming dashboard = %v", frame)
+	}
+
+	// Refused while the holder is connected, and the claimant keeps its
+	// assigned ID
+	c, welcome := dialWelcome(t, server, "", nil)
+	if text := errorMessage(c.subscribeAs("dashboard")); !strings.Contains(text, "bound to another connection") {
+		t.Errorf("claiming a live ID = %q", text)
+	}
+	if !connected(ps, welcome.ClientID) {
+		t.Errorf("refused claimant lost its assigned ID %s", welcome.ClientID)
+	}
+
+	// Free again once the holder has gone
+	holder.conn.Close()
+	waitFor(t, "the holder to go", func() bool { return !connected(ps, "dashboard") })
+	if frame := c.subscribeAs("dashboard"); frame["type"] != "ack" {
+		t.Errorf("claiming dashboard after its holder left = %v", frame)
+	}
+}
+
+func TestClientIDTakeover(t *testing.T) {
+	ps, server := identityServer(t, WebSocketOptions{ClientIDTakeover: true})
+	holder, _ := dialWelcome(t, server, "", nil)
+	if frame := holder.subscribeAs("dashboard"); frame["type"] != "ack" {
+		t.Fatalf("claiming dashboard = %v", frame)
+	}
+
+	// The holder is closed and the claim succeeds
+	c, _ := dialWelcome(t, server, "", nil)
+	if frame := c.subscribeAs("dashboard"); frame["type"] != "ack" {
+		t.Fatalf("takeover = %v", frame)
+	}
+	if got := ps.GetClientTopics("dashboard"); !reflect.DeepEqual(got, []string{"orders"}) {
+		t.Errorf("dashboard subscribed to %v after the takeover", got)
+	}
+	holder.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := holder.conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+}
+
+// certServer serves websockets as if every connection presented a verified
+// client certificate for cn
+func certServer(t *testing.T, cn string, opts WebSocketOptions) (*pubsub.PubSubSystem, *httptest.Server) {
+	t.Helper()
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	opts.ClientIDFromCert = true
+	h, err := NewHandler(ps, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		h.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return ps, server
+}
+
+func TestSecondConnectionWithSameCertificate(t *testing.T) {
+	ps, server := certServer(t, "sensor", WebSocketOptions{})
+	holder, welcome := dialWelcome(t, server, "", nil)
+	if welcome.ClientID != "sensor" {
+		t.Fatalf("connected as %q", welcome.ClientID)
+	}
+	if frame := holder.subscribeAs("sensor"); frame["type"] != "ack" {
+		t.Fatalf("subscribing = %v", frame)
+	}
+
+	// The second connection is refused, and closing it leaves the
+	// holder's subscriptions alone
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, closeErr := closeFrames(t, conn)
+	if len(frames) != 1 || !strings.Contains(errorMessage(frames[0]), "bound to another connection") || closeErr.Code != ClosePolicyViolation {
+		t.Errorf("second connection got %v, closed with %v", frames, closeErr)
+	}
+	conn.Close()
+	waitFor(t, "the refused connection's cleanup", func() bool { return ps.GetStats().WebSocket.Connections == 1 })
+	if got := ps.GetClientTopics("sensor"); !reflect.DeepEqual(got, []string{"orders"}) {
+		t.Errorf("sensor subscribed to %v after the refused connection closed", got)
+	}
+	if !connected(ps, "sensor") {
+		t.Error("the holder lost its registration")
+	}
+}
+
+func TestCertificateIdentityTakeover(t *testing.T) {
+	ps, server := certServer(t, "sensor", WebSocketOptions{ClientIDTakeover: true})
+	holder, _ := dialWelcome(t, server, "", nil)
+	if frame := holder.subscribeAs("sensor"); frame["type"] != "ack" {
+		t.Fatalf("subscribing = %v", frame)
+	}
+
+	// The older connection is closed before the newer one is welcomed
+	c, welcome := dialWelcome(t, server, "", nil)
+	if welcome.ClientID != "sensor" {
+		t.Fatalf("connected as %q", welcome.ClientID)
+	}
+	if _, closeErr := closeFrames(t, holder.conn); closeErr.Code != CloseSessionTakenOver {
+		t.Errorf("holder closed with %v", closeErr)
+	}
+	if frame := c.subscribeAs("sensor"); frame["type"] != "ack" {
+		t.Fatalf("subscribing after the takeover = %v", frame)
+	}
+	if got := ps.GetClientTopics("sensor"); !reflect.DeepEqual(got, []string{"orders"}) {
+		t.Errorf("sensor subscribed to %v after the takeover", got)
+	}
+}
@@ -0,0 +1,42 @@
+package ws
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+func TestPublisherSeesInterceptorRejection(t *testing.T) {
+	ps, server := identityServer(t, WebSocketOptions{})
+	ps.AddPublishInterceptor(func(ctx context.Context, topic string, msg *pubsub.MessageData, senderID string) error {
+		if text, _ := msg.Payload.(string); strings.Contains(text, "spoiler") {
+			return pubsub.ErrorData{Code: "BANNED_WORD", Message: "message contains spoiler"}
+		}
+		return nil
+	})
+	c, _ := dialWelcome(t, server, "?client_id=alice", nil)
+	publish := func(requestID, text string) map[string]interface{} {
+		return c.request(map[string]interface{}{
+			"type": "publish", "topic": "orders", "request_id": requestID,
+			"message": map[string]interface{}{"id": uuid.NewString(), "payload": text},
+		})
+	}
+
+	// Protocol v1 carries the code in the wrapped payload
+	frame := publish("p-1", "a spoiler")
+	message, _ := frame["message"].(map[string]interface{})
+	data, _ := message["payload"].(map[string]interface{})
+	if frame["type"] != "error" || data["code"] != "PERMISSION_DENIED" || data["reason"] != "BANNED_WORD" || message["id"] != "p-1" {
+		t.Errorf("rejected publish answered %v", frame)
+	}
+	if frame := publish("p-2", "fine"); frame["type"] != "ack" {
+		t.Errorf("accepted publish answered %v", frame)
+	}
+	if detail, _ := ps.GetTopicDetail("orders"); detail.MessageCount != 1 {
+		t.Errorf("%d messages recorded, want 1", detail.MessageCount)
+	}
+}
@@ -0,0 +1,125 @@
+package ws
+
+import (
+	"log"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// requestJoin asks the owner of a private topic to admit the connection
+// and answers join_pending. The subscription is made, and acknowledged
+// under the subscribe's request_id, once the owner approves.
+func (c *Client) requestJoin(req pubsub.SubscribeRequest, topic string, opts pubsub.SubscribeOptions) error {
+	pending, err := c.ps.RequestJoin(c.ctx, topic, c, func(err error) { c.joinDecided(req, topic, opts, err) })
+	if err != nil {
+		return c.respond(pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.NewErrorData(err),
+			Timestamp: c.clock.Now(),
+		})
+	}
+	log.Printf("Client %s is waiting to join private topic %s", c.id(), topic)
+
+	// Set now, since consumers belongs to readPump; acks are refused until
+	// the subscription exists
+	if req.AckMode == pubsub.AckModeExplicit {
+		c.consumers[topic] = opts.Consumer
+	}
+
+	return c.respond(pubsub.AckResponse{
+		Type:      "join_pending",
+		RequestID: req.RequestID,
+		Topic:     req.Topic,
+		Status:    "pending",
+		ExpiresAt: &pending.ExpiresAt,
+		Timestamp: c.clock.Now(),
+	})
+}
+
+// joinDecided completes a subscribe that waited on a join request. It runs
+// outside readPump, so it answers with sendMessage.
+func (c *Client) joinDecided(req pubsub.SubscribeRequest, topic string, opts pubsub.SubscribeOptions, err error) {
+	if c.ctx.Err() != nil {
+		return
+	}
+	if err == nil {
+		var lastMessages []pubsub.EventResponse
+		if lastMessages, err = c.ps.SubscribeWithOptions(c.ctx, c.id(), topic, opts, c); err == nil {
+			c.joined(req, lastMessages)
+			return
+		}
+	}
+
+	if err := c.sendMessage(pubsub.ErrorResponse{
+		Type:      "error",
+		RequestID: req.RequestID,
+		Topic:     req.Topic,
+		Error:     pubsub.NewErrorData(err),
+		Timestamp: c.clock.Now(),
+	}); err != nil {
+		log.Printf("Error sending join outcome to client %s: %v", c.id(), err)
+	}
+}
+
+// joined acknowledges an approved subscribe and sends its last N messages
+func (c *Client) joined(req pubsub.SubscribeRequest, lastMessages []pubsub.EventResponse) {
+	log.Printf("Client %s joined private topic %s", c.id(), req.Topic)
+	if err := c.sendMessage(pubsub.AckResponse{
+		Type:      "ack",
+		RequestID: req.RequestID,
+		Topic:     req.Topic,
+		Status:    "ok",
+		Timestamp: c.clock.Now(),
+	}); err != nil {
+		log.Printf("Error acknowledging join of client %s: %v", c.id(), err)
+		return
+	}
+	for _, lastMsg := range lastMessages {
+		if c.ctx.Err() != nil {
+			return
+		}
+		if err := c.sendMessage(lastMsg); err != nil {
+			log.Printf("Error sending last message to client %s: %v", c.id(), err)
+		}
+	}
+}
+
+// handleJoinDecision approves or denies a pending request to join a
+// private topic, which only its owner and admin connections may do
+func (c *Client) handleJoinDecision(req pubsub.JoinDecisionRequest) error {
+	if req.RequestID == "" || req.ClientID == "" {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "request_id and client_id are required"}
+	}
+	topic, err := c.topic(req.Topic)
+	if err != nil {
+		return err
+	}
+
+	requester := c.id()
+	if c.admin {
+		requester = ""
+	}
+	approve := req.Type == "approve_join"
+	if err := c.ps.DecideJoin(c.ctx, topic, requester, req.ClientID, approve); err != nil {
+		return c.respond(pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Topic:     req.Topic,
+			Error:     pubsub.NewErrorData(err),
+			Timestamp: c.clock.Now(),
+		})
+	}
+
+	status := "denied"
+	if approve {
+		status = "approved"
+	}
+	return c.respond(pubsub.AckResponse{
+		Type:      "ack",
+		RequestID: req.RequestID,
+		Topic:     req.Topic,
+		Status:    status,
+		Timestamp: c.clock.Now(),
+	})
+}
@@ -0,0 +1,105 @@
+package ws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// askToJoin subscribes to "board" as clientID, expecting to be held
+// pending, and returns the subscribe's request ID
+func (c *wireClient) askToJoin(clientID string) string {
+	c.t.Helper()
+	requestID := uuid.New().String()
+	c.send(map[string]interface{}{"type": "subscribe", "topic": "board", "client_id": clientID, "last_n": 2, "request_id": requestID})
+	if pending := c.expect("join_pending"); pending["status"] != "pending" || pending["request_id"] != requestID || pending["expires_at"] == nil {
+		c.t.Fatalf("subscribe to a private topic answered with %v", pending)
+	}
+	return requestID
+}
+
+// decideJoin approves or denies clientID's request to join "board"
+func (c *wireClient) decideJoin(kind, clientID string) map[string]interface{} {
+	c.t.Helper()
+	return c.request(map[string]interface{}{
+		"type":       kind,
+		"topic":      "board",
+		"client_id":  clientID,
+		"request_id": uuid.New().String(),
+	})
+}
+
+func TestPrivateTopicJoin(t *testing.T) {
+	ps := pubsub.New()
+	server := serve(t, ps, WebSocketOptions{})
+	alice := dialV2(t, server)
+	ack := alice.request(map[string]interface{}{
+		"type":       "create_topic",
+		"topic":      "board",
+		"client_id":  "alice",
+		"visibility": "private",
+		"request_id": uuid.New().String(),
+	})
+	if ack["status"] != "created" {
+		t.Fatalf("create answered with %v", ack)
+	}
+	for _, id := range []string{"b1", "b2"} {
+		if err := ps.Publish(context.Background(), "board", pubsub.MessageData{ID: id}, "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Approved, the subscribe is acknowledged and replays its history
+	bob := dialV2(t, server)
+	requestID := bob.askToJoin("bob")
+	if notice := alice.expect("join_request"); notice["from"] != "bob" || notice["topic"] != "board" {
+		t.Errorf("owner got %v", notice)
+	}
+	if detail, _ := ps.GetTopicDetail("board"); detail.Visibility != "private" || len(detail.PendingJoins) != 1 || detail.PendingJoins[0].ClientID != "bob" {
+		t.Errorf("detail = %s %+v", detail.Visibility, detail.PendingJoins)
+	}
+	if failure := bob.decideJoin("approve_join", "bob"); errorCode(failure) != "PERMISSION_DENIED" {
+		t.Errorf("approval by a non-owner answered with %v", failure)
+	}
+	if ack := alice.decideJoin("approve_join", "bob"); ack["status"] != "approved" {
+		t.Fatalf("approve answered with %v", ack)
+	}
+	if ack := bob.expect("ack"); ack["request_id"] != requestID {
+		t.Errorf("approved subscribe answered with %v", ack)
+	}
+	for _, want := range []string{"b1", "b2"} {
+		event := bob.expect("event")
+		if id := event["message"].(map[string]interface{})["id"]; id != want {
+			t.Errorf("replayed %v, want %s", id, want)
+		}
+	}
+	if failure := alice.decideJoin("approve_join", "bob"); errorReason(failure) != "JOIN_NOT_FOUND" {
+		t.Errorf("deciding twice answered with %v", failure)
+	}
+
+	// Denied, the subscribe fails
+	carol := dialV2(t, server)
+	requestID = carol.askToJoin("carol")
+	if ack := alice.decideJoin("deny_join", "carol"); ack["status"] != "denied" {
+		t.Fatalf("deny answered with %v", ack)
+	}
+	if failure := carol.expect("error"); errorCode(failure) != "PERMISSION_DENIED" || errorReason(failure) != "JOIN_DENIED" || failure["request_id"] != requestID {
+		t.Errorf("denied subscribe answered with %v", failure)
+	}
+
+	// Undecided, it expires, even with the owner gone
+	ps.SetJoinTimeout(100 * time.Millisecond)
+	dave := dialV2(t, server)
+	dave.askToJoin("dave")
+	alice.conn.Close()
+	if failure := dave.expect("error"); errorReason(failure) != "JOIN_EXPIRED" {
+		t.Errorf("expired subscribe answered with %v", failure)
+	}
+	if detail, _ := ps.GetTopicDetail("board"); len(detail.PendingJoins) != 0 {
+		t.Errorf("expired requests still listed: %+v", detail.PendingJoins)
+	}
+}
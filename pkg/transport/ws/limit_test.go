@@ -0,0 +1,109 @@
+package ws
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// openConnections dials server n times at once and returns the connections
+// that were accepted and the responses of those that weren't
+func openConnections(t *testing.T, server string, n int) ([]*websocket.Conn, []*http.Response) {
+	t.Helper()
+	var mutex sync.Mutex
+	var accepted []*websocket.Conn
+	var refused []*http.Response
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, resp, err := websocket.DefaultDialer.Dial(server, nil)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err == nil {
+				accepted = append(accepted, conn)
+			} else if resp != nil {
+				refused = append(refused, resp)
+			} else {
+				t.Errorf("dial: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	t.Cleanup(func() {
+		for _, conn := range accepted {
+			conn.Close()
+		}
+	})
+	return accepted, refused
+}
+
+func TestConnectionLimit(t *testing.T) {
+	const limit = 20
+	ps := pubsub.New()
+	ps.SetMaxConnections(limit)
+	server := serve(t, ps, WebSocketOptions{})
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	connections := func() int64 { return ps.GetStats().WebSocket.Connections }
+
+	accepted, refused := openConnections(t, url, limit+10)
+	if len(accepted) != limit || len(refused) != 10 {
+		t.Fatalf("%d accepted and %d refused, want %d and 10", len(accepted), len(refused), limit)
+	}
+	for _, resp := range refused {
+		var body pubsub.ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&body)
+		if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" ||
+			body.Error.Reason != "CONNECTION_LIMIT" || !body.Error.Retryable {
+			t.Errorf("refused with %d, Retry-After %q, %+v", resp.StatusCode, resp.Header.Get("Retry-After"), body)
+		}
+	}
+	if got := ps.WebSocketTraffic().LimitRejections.Load(); got != 10 {
+		t.Errorf("%d rejections counted, want 10", got)
+	}
+	if got := connections(); got != limit {
+		t.Errorf("%d connections counted, want %d", got, limit)
+	}
+	if readiness := ps.CheckReadiness(); readiness.Status == "ready" {
+		t.Errorf("ready at the connection limit: %+v", readiness)
+	}
+
+	// Every way a connection ends gives its slot back: a close frame, a
+	// dropped TCP connection, and a server-side close
+	for i, conn := range accepted {
+		switch i % 3 {
+		case 0:
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		case 1:
+			conn.UnderlyingConn().Close()
+		case 2:
+			conn.Close()
+		}
+	}
+	waitFor(t, "the slots to be released", func() bool { return connections() == 0 })
+	if readiness := ps.CheckReadiness(); readiness.Status != "ready" {
+		t.Errorf("not ready once connections closed: %+v", readiness)
+	}
+
+	// A request that fails to upgrade doesn't keep one either
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || connections() != 0 {
+		t.Errorf("plain GET = %d, leaving %d connections counted", resp.StatusCode, connections())
+	}
+
+	// The whole capacity is available again
+	if accepted, refused := openConnections(t, url, limit); len(accepted) != limit || len(refused) != 0 {
+		t.Errorf("after closing, %d accepted and %d refused", len(accepted), len(refused))
+	}
+}
@@ -0,0 +1,85 @@
+package ws
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// capabilityProbe in a hello asks the server to send the connection
+// liveness probes
+const capabilityProbe = "probe"
+
+// liveness tracks when a connection was last heard from and whether it
+// answers the server's probes
+type liveness struct {
+	now func() time.Time // Clock for activity and probe deadlines
+
+	lastActive atomic.Int64 // Unix nanoseconds of the latest frame or pong
+	probing    atomic.Bool  // Set once the client negotiates probes
+
+	mutex        sync.Mutex
+	probeID      string    // Unanswered probe, empty when there is none
+	probeSentAt  time.Time // When probeID was sent
+	unresponsive bool      // probeID went unanswered past its deadline
+}
+
+// newLiveness tracks a connection that is active now
+func newLiveness(now func() time.Time) *liveness {
+	l := &liveness{now: now}
+	l.touch()
+	return l
+}
+
+// touch records that the client was heard from
+func (l *liveness) touch() {
+	l.lastActive.Store(l.now().UnixNano())
+}
+
+// last returns when the client was last heard from
+func (l *liveness) last() time.Time {
+	return time.Unix(0, l.lastActive.Load())
+}
+
+// nextProbe is called every probe period. It returns the ID of a new probe
+// to send, or "" while the last one is unanswered, in which case the client
+// is marked unresponsive once timeout has passed. The second result reports
+// whether this call marked it.
+func (l *liveness) nextProbe(timeout time.Duration) (string, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.probeID != "" {
+		if l.unresponsive || l.now().Sub(l.probeSentAt) < timeout {
+			return "", false
+		}
+		l.unresponsive = true
+		return "", true
+	}
+	l.probeID = uuid.New().String()
+	l.probeSentAt = l.now()
+	return l.probeID, false
+}
+
+// answer records the client's answer to a probe. Answers to anything but
+// the outstanding probe are ignored.
+func (l *liveness) answer(probeID string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if probeID != l.probeID {
+		return
+	}
+	l.probeID = ""
+	l.unresponsive = false
+}
+
+// isUnresponsive reports whether the client let a probe go unanswered past
+// its deadline and hasn't answered it since
+func (l *liveness) isUnresponsive() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.unresponsive
+}
@@ -0,0 +1,112 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/clock/clocktest"
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+func newFakeClock() *clocktest.Fake {
+	return clocktest.NewFake(time.Date(2025, 8, 25, 10, 0, 0, 0, time.UTC))
+}
+
+// waitTimers waits for n timers and tickers to be armed on clock, so that
+// advancing it reaches the connections' pumps
+func waitTimers(t *testing.T, clock *clocktest.Fake, n int) {
+	t.Helper()
+	waitFor(t, "the pumps' tickers", func() bool { return clock.Timers() >= n })
+}
+
+// lastActive returns a connected client's last activity from its detail
+func lastActive(t *testing.T, ps *pubsub.PubSubSystem, clientID string) time.Time {
+	t.Helper()
+	detail, ok := ps.GetClientDetail(clientID)
+	if !ok || detail.LastActive == nil {
+		t.Fatalf("detail for %s = %+v, %v", clientID, detail, ok)
+	}
+	return *detail.LastActive
+}
+
+func TestPingsRefreshLastActive(t *testing.T) {
+	clock := newFakeClock()
+	ps := pubsub.New()
+	server := serve(t, ps, WebSocketOptions{Clock: clock, PongWait: time.Hour})
+	c, welcome := dialWelcome(t, server, "", nil)
+	c.send(map[string]interface{}{"type": "hello", "protocol_version": 2, "request_id": "h-1"})
+	c.expect("hello_ack")
+	connectedAt := lastActive(t, ps, welcome.ClientID)
+
+	// An application ping moves LastActive, and the pong carries the
+	// server's clock
+	pinged := clock.Advance(time.Minute)
+	c.send(map[string]interface{}{"type": "ping", "request_id": "p-1"})
+	pong := c.expect("pong")
+	if got := lastActive(t, ps, welcome.ClientID); !got.Equal(pinged) || !got.After(connectedAt) {
+		t.Errorf("last active after ping = %s, want %s", got, pinged)
+	}
+	if serverTime := pong["server_time_ms"]; serverTime != float64(pinged.UnixMilli()) {
+		t.Errorf("pong server_time_ms = %v, want %d", serverTime, pinged.UnixMilli())
+	}
+
+	// So does a websocket-level pong
+	ponged := clock.Advance(time.Minute)
+	if err := c.conn.WriteControl(websocket.PongMessage, nil, time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the pong to refresh last active", func() bool {
+		return lastActive(t, ps, welcome.ClientID).Equal(ponged)
+	})
+}
+
+func TestUnansweredProbeMarksClientUnresponsive(t *testing.T) {
+	clock := newFakeClock()
+	ps := pubsub.New()
+	server := serve(t, ps, WebSocketOptions{Clock: clock, PongWait: time.Hour, ProbePeriod: 10 * time.Second, ProbeTimeout: time.Minute})
+	unresponsive := func(clientID string) bool {
+		detail, _ := ps.GetClientDetail(clientID)
+		return detail.Unresponsive
+	}
+
+	// Connections that don't negotiate probes get none
+	plain, plainWelcome := dialWelcome(t, server, "", nil)
+	plain.send(map[string]interface{}{"type": "hello", "protocol_version": 2, "request_id": "h-1"})
+	plain.expect("hello_ack")
+
+	c, welcome := dialWelcome(t, server, "", nil)
+	c.send(map[string]interface{}{"type": "hello", "protocol_version": 2, "capabilities": []string{capabilityProbe}, "request_id": "h-1"})
+	c.expect("hello_ack")
+	waitTimers(t, clock, 4)
+	clock.Advance(10 * time.Second)
+	probe := c.expect("probe")
+
+	// Unanswered but within the deadline
+	clock.Advance(30 * time.Second)
+	time.Sleep(50 * time.Millisecond)
+	if unresponsive(welcome.ClientID) {
+		t.Fatal("client unresponsive before the probe deadline")
+	}
+
+	clock.Advance(time.Minute)
+	waitFor(t, "the client to be marked unresponsive", func() bool { return unresponsive(welcome.ClientID) })
+	if unresponsive(plainWelcome.ClientID) {
+		t.Error("client without probes marked unresponsive")
+	}
+
+	// A stale answer changes nothing; answering the probe clears the mark
+	// and the next probe follows
+	c.send(map[string]interface{}{"type": "probe_ack", "probe_id": "someone-elses"})
+	time.Sleep(50 * time.Millisecond)
+	if !unresponsive(welcome.ClientID) {
+		t.Fatal("a stale probe_ack cleared the mark")
+	}
+	c.send(map[string]interface{}{"type": "probe_ack", "probe_id": probe["probe_id"]})
+	waitFor(t, "the answer to clear the mark", func() bool { return !unresponsive(welcome.ClientID) })
+	clock.Advance(10 * time.Second)
+	if next := c.expect("probe"); next["probe_id"] == probe["probe_id"] {
+		t.Errorf("probe %v sent twice", next["probe_id"])
+	}
+}
@@ -0,0 +1,63 @@
+package ws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+func TestNamespacePublishRate(t *testing.T) {
+	ps := pubsub.New()
+	for _, topic := range []string{"alpha::orders", "beta::orders"} {
+		if err := ps.CreateTopic(context.Background(), topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ps.SetNamespaceLimits("alpha", pubsub.NamespaceLimits{PublishRate: 2}); err != nil {
+		t.Fatal(err)
+	}
+	server := serve(t, ps, WebSocketOptions{})
+	connect := func(ns string) *wireClient {
+		c, _ := dialWelcome(t, server, "?client_id="+ns+"-writer", nil)
+		c.send(map[string]interface{}{"type": "hello", "protocol_version": 2, "namespace": ns, "request_id": "h"})
+		c.expect("hello_ack")
+		return c
+	}
+	publish := func(c *wireClient) string {
+		frame := c.request(map[string]interface{}{
+			"type": "publish", "topic": "orders", "request_id": uuid.NewString(),
+			"message": map[string]interface{}{"id": uuid.NewString(), "payload": 1},
+		})
+		if frame["type"] == "ack" {
+			return "ok"
+		}
+		data, _ := frame["error"].(map[string]interface{})
+		code, _ := data["code"].(string)
+		return code
+	}
+	alpha, beta := connect("alpha"), connect("beta")
+
+	// A second's worth is the burst
+	for i, want := range []string{"ok", "ok", "RATE_LIMITED"} {
+		if got := publish(alpha); got != want {
+			t.Errorf("alpha publish %d = %s, want %s", i+1, got, want)
+		}
+	}
+	// beta has no limit of its own
+	for i := 0; i < 5; i++ {
+		if got := publish(beta); got != "ok" {
+			t.Errorf("beta publish %d = %s", i+1, got)
+		}
+	}
+	time.Sleep(550 * time.Millisecond)
+	if got := publish(alpha); got != "ok" {
+		t.Errorf("alpha publish after half a second = %s", got)
+	}
+	if got := publish(alpha); got != "RATE_LIMITED" {
+		t.Errorf("alpha publish beyond the refill = %s", got)
+	}
+}
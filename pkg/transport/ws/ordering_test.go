@@ -0,0 +1,48 @@
+package ws
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+func TestOrderingKeyOnTheWire(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.EnableFanoutWorkers(4); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	server := serve(t, ps, WebSocketOptions{})
+	subscriber, _ := dialWelcome(t, server, "", nil)
+	if frame := subscriber.subscribeAs("shipping"); frame["type"] != "ack" {
+		t.Fatalf("subscribing = %v", frame)
+	}
+	publisher, _ := dialWelcome(t, server, "?client_id=checkout", nil)
+	publish := func(requestID, key string) map[string]interface{} {
+		return publisher.request(map[string]interface{}{
+			"type": "publish", "topic": "orders", "request_id": requestID, "ordering_key": key,
+			"message": map[string]interface{}{"id": uuid.NewString(), "payload": requestID},
+		})
+	}
+
+	// Delivered with the key, for consumers sharding on it
+	if frame := publish("p-1", "order-42"); frame["type"] != "ack" {
+		t.Fatalf("publishing with an ordering key = %v", frame)
+	}
+	if event := subscriber.expect("event"); event["ordering_key"] != "order-42" {
+		t.Errorf("event delivered as %v", event)
+	}
+
+	frame := publish("p-2", strings.Repeat("k", pubsub.MaxOrderingKeyLength+1))
+	message, _ := frame["message"].(map[string]interface{})
+	data, _ := message["payload"].(map[string]interface{})
+	if data["code"] != "VALIDATION_FAILED" || !strings.Contains(errorMessage(frame), "ordering_key") {
+		t.Errorf("publishing with an ordering key a byte too long = %v", frame)
+	}
+}
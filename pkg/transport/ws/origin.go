@@ -0,0 +1,79 @@
+package ws
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OriginPolicy decides which browser origins may open websockets and make
+// CORS requests. Patterns are exact origins ("https://app.example.com"),
+// subdomain wildcards ("https://*.example.com") or "*" for any origin.
+type OriginPolicy struct {
+	permissive bool
+	patterns   []string
+}
+
+// NewOriginPolicy creates a policy. A permissive policy allows every origin,
+// as in development; otherwise only the listed patterns and same-host
+// requests are allowed.
+func NewOriginPolicy(patterns []string, permissive bool) *OriginPolicy {
+	policy := &OriginPolicy{permissive: permissive}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if pattern == "*" {
+			policy.permissive = true
+			continue
+		}
+		policy.patterns = append(policy.patterns, strings.TrimSuffix(pattern, "/"))
+	}
+	return policy
+}
+
+// Permissive reports whether every origin is allowed
+func (p *OriginPolicy) Permissive() bool {
+	return p.permissive
+}
+
+// AllowedOrigin reports whether an Origin header value is allowed
+func (p *OriginPolicy) AllowedOrigin(origin string) bool {
+	if p.permissive {
+		return true
+	}
+
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+	normalized := u.Scheme + "://" + u.Host
+
+	for _, pattern := range p.patterns {
+		if pattern == normalized {
+			return true
+		}
+		scheme, host, ok := strings.Cut(pattern, "://*.")
+		if ok && scheme == u.Scheme && strings.HasSuffix(u.Host, "."+host) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckRequest reports whether a request's origin is allowed. Requests
+// without an Origin header (non-browser clients) and same-host requests are
+// always allowed.
+func (p *OriginPolicy) CheckRequest(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if p.AllowedOrigin(origin) {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
@@ -0,0 +1,100 @@
+package ws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+func TestOriginPolicy(t *testing.T) {
+	policy := NewOriginPolicy([]string{"https://app.example.com", " https://*.example.org/ ", ""}, false)
+	for origin, want := range map[string]bool{
+		"https://app.example.com":      true,
+		"HTTPS://APP.EXAMPLE.COM":      true,
+		"https://evil.example.com":     false,
+		"http://app.example.com":       false, // Scheme must match
+		"https://app.example.com:8443": false, // So must the port
+		"https://a.example.org":        true,
+		"https://a.b.example.org":      true,
+		"https://example.org":          false, // The wildcard needs a subdomain
+		"https://notexample.org":       false,
+		"http://a.example.org":         false,
+		"null":                         false,
+		"":                             false,
+	} {
+		if got := policy.AllowedOrigin(origin); got != want {
+			t.Errorf("AllowedOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+
+	if policy.Permissive() {
+		t.Error("policy with patterns is permissive")
+	}
+	if !NewOriginPolicy(nil, true).AllowedOrigin("https://anywhere.test") {
+		t.Error("permissive policy refused an origin")
+	}
+	if !NewOriginPolicy([]string{"*"}, false).Permissive() {
+		t.Error(`"*" did not allow every origin`)
+	}
+}
+
+func TestOriginPolicyCheckRequest(t *testing.T) {
+	policy := NewOriginPolicy([]string{"https://app.example.com"}, false)
+	request := func(origin string) *http.Request {
+		r := httptest.NewRequest("GET", "http://broker.example.com/ws", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+
+	for origin, want := range map[string]bool{
+		"https://app.example.com":   true,
+		"https://evil.example.com":  false,
+		"":                          true, // Non-browser clients send no Origin
+		"http://broker.example.com": true, // Same host
+	} {
+		if got := policy.CheckRequest(request(origin)); got != want {
+			t.Errorf("CheckRequest with Origin %q = %v, want %v", origin, got, want)
+		}
+	}
+}
+
+func TestHandlerRejectsDisallowedOrigins(t *testing.T) {
+	ps := pubsub.New()
+	server := serve(t, ps, WebSocketOptions{Origins: NewOriginPolicy([]string{"https://*.example.com"}, false)})
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	for origin, want := range map[string]bool{
+		"https://app.example.com": true,
+		"https://evil.test":       false,
+		"":                        true,
+	} {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if want {
+			if err != nil {
+				t.Errorf("Origin %q refused: %v", origin, err)
+				continue
+			}
+			conn.Close()
+		} else if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+			if conn != nil {
+				conn.Close()
+			}
+			t.Errorf("Origin %q was not refused with 403", origin)
+		}
+	}
+
+	if rejected := ps.GetStats().WebSocket.OriginRejections; rejected != 1 {
+		t.Errorf("counted %d origin rejections, want 1", rejected)
+	}
+}
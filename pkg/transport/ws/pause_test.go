@@ -0,0 +1,61 @@
+package ws
+
+import (
+	"context"
+	"testing"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+func TestPauseAndResumeOverTheWire(t *testing.T) {
+	ps := pubsub.New()
+	ps.SetPauseBufferSize(4)
+	for _, name := range []string{"orders", "alerts"} {
+		if err := ps.CreateTopic(context.Background(), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := dialCodec(t, serve(t, ps, WebSocketOptions{}), pubsub.JSONCodec)
+	for _, topic := range []string{"orders", "alerts"} {
+		c.send(map[string]interface{}{"type": "subscribe", "topic": topic, "client_id": "mobile", "request_id": "s-" + topic})
+		c.expect("ack")
+	}
+
+	request := func(kind, requestID string) map[string]interface{} {
+		c.send(map[string]interface{}{"type": kind, "topic": "orders", "client_id": "mobile", "request_id": requestID})
+		ack := c.expect("ack")
+		message, _ := ack["message"].(map[string]interface{})
+		payload, _ := message["payload"].(map[string]interface{})
+		if message["id"] != requestID {
+			t.Fatalf("%s answered with %v", kind, ack)
+		}
+		return payload
+	}
+
+	if payload := request("pause", "pause-1"); payload["status"] != "paused" {
+		t.Errorf("pause ack = %v", payload)
+	}
+	// Past the buffer's capacity; the other subscription carries on
+	publishOrders(t, ps, 6)
+	if err := ps.Publish(context.Background(), "alerts", pubsub.MessageData{ID: "a-1", Payload: "low battery"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	// Published after the orders, the alert is the first event to arrive
+	if event := c.expect("event"); event["topic"] != "alerts" {
+		t.Fatalf("while paused got %v", event)
+	}
+
+	payload := request("resume", "resume-1")
+	if payload["status"] != "resumed" || payload["buffered"] != 4.0 || payload["evicted"] != 2.0 {
+		t.Errorf("resume ack = %v", payload)
+	}
+
+	// The newest four held, in order, sent ahead of the resume ack, then
+	// live delivery
+	publishOrders(t, ps, 1)
+	for seq := 3.0; seq <= 7; seq++ {
+		if event := c.expect("event"); event["topic"] != "orders" || event["seq"] != seq {
+			t.Fatalf("after resuming got %v, want orders seq %v", event, seq)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package ws
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+func TestPayloadLimitsKeepTheConnection(t *testing.T) {
+	ps := pubsub.New()
+	ps.SetPayloadLimits(pubsub.PayloadLimits{MaxBytes: 1000, MaxDepth: 8})
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	server := serve(t, ps, WebSocketOptions{})
+
+	// Both limits are announced, and the frame size follows the payload size
+	conn, welcome := dial(t, server, "", nil)
+	if welcome.Limits.MaxPayloadBytes != 1000 || welcome.Limits.MaxPayloadDepth != 8 || welcome.Limits.MaxMessageSize != 1000+frameOverhead {
+		t.Errorf("welcome limits = %+v", welcome.Limits)
+	}
+	c := &wireClient{t: t, conn: conn, codec: pubsub.JSONCodec}
+	publish := func(requestID string, payload interface{}) map[string]interface{} {
+		return c.request(map[string]interface{}{"type": "publish", "topic": "orders", "request_id": requestID,
+			"message": map[string]interface{}{"id": uuid.New().String(), "payload": payload}})
+	}
+	rejected := func(frame map[string]interface{}, reason interface{}, limit int) bool {
+		message, _ := frame["message"].(map[string]interface{})
+		data, _ := message["payload"].(map[string]interface{})
+		return frame["type"] == "error" && data["code"] == "PAYLOAD_TOO_LARGE" && data["reason"] == reason && data["limit"] == float64(limit)
+	}
+
+	// A string payload marshals to its length plus the quotes
+	if frame := publish("p-under", strings.Repeat("x", 997)); frame["type"] != "ack" {
+		t.Errorf("payload just under the limit = %v", frame)
+	}
+	if frame := publish("p-over", strings.Repeat("x", 999)); !rejected(frame, nil, 1000) {
+		t.Errorf("payload just over the limit = %v", frame)
+	}
+
+	// Deep nesting is refused before the frame is decoded
+	deep := `{"type":"publish","topic":"orders","request_id":"p-deep","message":{"id":"` + uuid.New().String() +
+		`","payload":` + strings.Repeat("[", 100) + strings.Repeat("]", 100) + `}}`
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(deep)); err != nil {
+		t.Fatal(err)
+	}
+	if frame := c.expect("error"); !rejected(frame, "PAYLOAD_TOO_DEEP", 8) {
+		t.Errorf("100 levels of nesting = %v", frame)
+	}
+
+	// Still connected
+	if frame := publish("p-after", "ok"); frame["type"] != "ack" {
+		t.Errorf("publish after the rejections = %v", frame)
+	}
+	if detail, _ := ps.GetTopicDetail("orders"); detail.MessageCount != 2 {
+		t.Errorf("%d messages published, want 2", detail.MessageCount)
+	}
+}
+
+func TestTopicLimitsOverWebsocket(t *testing.T) {
+	ps := pubsub.New()
+	ps.SetPayloadLimits(pubsub.PayloadLimits{MaxBytes: 1000})
+	ctx := context.Background()
+	if err := ps.CreateTopicWithConfig(ctx, "telemetry", pubsub.TopicConfig{MaxPayloadBytes: 10000, MaxPublishRate: 1}); err != nil {
+		t.Fatal(err)
+	}
+	server := serve(t, ps, WebSocketOptions{})
+
+	// The frame limit grows to fit the largest topic override
+	conn, welcome := dial(t, server, "", nil)
+	if welcome.Limits.MaxMessageSize != 10000+frameOverhead {
+		t.Errorf("welcome limits = %+v", welcome.Limits)
+	}
+	c := &wireClient{t: t, conn: conn, codec: pubsub.JSONCodec}
+	publish := func(requestID string) map[string]interface{} {
+		return c.request(map[string]interface{}{"type": "publish", "topic": "telemetry", "request_id": requestID,
+			"message": map[string]interface{}{"id": uuid.New().String(), "payload": strings.Repeat("x", 5000)}})
+	}
+	if frame := publish("big"); frame["type"] != "ack" {
+		t.Fatalf("payload over the server limit = %v", frame)
+	}
+	frame := publish("fast")
+	message, _ := frame["message"].(map[string]interface{})
+	if data, _ := message["payload"].(map[string]interface{}); data["code"] != "RATE_LIMITED" || data["reason"] != "TOPIC_RATE_LIMITED" || data["retryable"] != true {
+		t.Errorf("publish over the topic rate = %v", frame)
+	}
+}
@@ -0,0 +1,75 @@
+package ws
+
+import (
+	"log"
+	"sync"
+
+	"github.com/AnshulDekate/pubsub/pkg/clock"
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// publishAcks collects the message IDs of "ack": "batch" publishes until
+// their cumulative ack goes out
+type publishAcks struct {
+	mutex sync.Mutex
+	ids   []string
+	timer clock.Timer // Flushes a partial batch; nil while none is pending
+}
+
+// ackBatched adds an accepted publish to the connection's next cumulative
+// ack, sending it once the batch is full
+func (c *Client) ackBatched(messageID string) {
+	c.ps.WebSocketTraffic().BatchedPublishes.Add(1)
+	c.acks.mutex.Lock()
+	defer c.acks.mutex.Unlock()
+	c.acks.ids = append(c.acks.ids, messageID)
+	if len(c.acks.ids) >= c.opts.PublishAckBatchSize {
+		c.flushAcksLocked()
+		return
+	}
+	if c.acks.timer == nil {
+		c.acks.timer = c.clock.AfterFunc(c.opts.PublishAckInterval, c.flushAcks)
+	}
+}
+
+// flushAcks sends the pending cumulative ack when its interval passes
+func (c *Client) flushAcks() {
+	c.acks.mutex.Lock()
+	defer c.acks.mutex.Unlock()
+	c.flushAcksLocked()
+}
+
+// flushAcksLocked sends the pending cumulative ack, if any. Callers hold
+// the mutex, which cleanup takes before closing the send queues.
+func (c *Client) flushAcksLocked() {
+	if c.acks.timer != nil {
+		c.acks.timer.Stop()
+		c.acks.timer = nil
+	}
+	if len(c.acks.ids) == 0 || c.ctx.Err() != nil {
+		return
+	}
+	ack := pubsub.AckResponse{
+		Type:       "ack",
+		Status:     "batch",
+		MessageIDs: c.acks.ids,
+		Timestamp:  c.clock.Now(),
+	}
+	c.acks.ids = nil
+	if err := c.sendMessage(ack); err != nil {
+		log.Printf("Error sending cumulative publish ack to client %s: %v", c.id(), err)
+		return
+	}
+	c.ps.WebSocketTraffic().BatchAcks.Add(1)
+}
+
+// stopAcks drops the pending cumulative ack of a closing connection
+func (c *Client) stopAcks() {
+	c.acks.mutex.Lock()
+	defer c.acks.mutex.Unlock()
+	if c.acks.timer != nil {
+		c.acks.timer.Stop()
+		c.acks.timer = nil
+	}
+	c.acks.ids = nil
+}
@@ -0,0 +1,159 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// publishWithAck publishes a message to room1 with the given ack mode and
+// returns its message ID
+func (c *wireClient) publishWithAck(ack string) string {
+	c.t.Helper()
+	id := uuid.New().String()
+	c.send(map[string]interface{}{
+		"type":       "publish",
+		"topic":      "room1",
+		"ack":        ack,
+		"request_id": "p-" + id,
+		"message":    map[string]interface{}{"id": id, "payload": "x"},
+	})
+	return id
+}
+
+func TestBatchPublishAcks(t *testing.T) {
+	ps, _ := roomsServer(t)
+	server := serve(t, ps, WebSocketOptions{PublishAckBatchSize: 3, PublishAckInterval: 200 * time.Millisecond})
+	c := dialV2(t, server)
+
+	// A full batch is acknowledged at once, in publish order
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ids = append(ids, c.publishWithAck("batch"))
+	}
+	ack := c.expect("ack")
+	if ack["status"] != "batch" || fmt.Sprint(ack["message_ids"]) != fmt.Sprint(ids) {
+		t.Fatalf("cumulative ack = %v, want message_ids %v", ack, ids)
+	}
+
+	// A partial one once the interval passes; errors aren't held back
+	last := c.publishWithAck("batch")
+	c.send(map[string]interface{}{"type": "publish", "topic": "missing", "ack": "batch", "request_id": "p-bad", "message": map[string]interface{}{"id": uuid.New().String()}})
+	if failure := c.expect("error"); failure["request_id"] != "p-bad" {
+		t.Errorf("failed publish answered with %v", failure)
+	}
+	if ack := c.expect("ack"); fmt.Sprint(ack["message_ids"]) != fmt.Sprint([]string{last}) {
+		t.Errorf("partial cumulative ack = %v", ack)
+	}
+
+	waitFor(t, "both cumulative acks counted", func() bool {
+		traffic := ps.GetStats().WebSocket
+		return traffic.BatchedPublishes == 4 && traffic.BatchAcks == 2
+	})
+}
+
+func TestUnackedPublishes(t *testing.T) {
+	ps, server := roomsServer(t)
+	c := dialV2(t, server)
+
+	// Only the acked publish is answered
+	c.publishWithAck("none")
+	acked := c.publishWithAck("")
+	if ack := c.nextFrame(); ack["type"] != "ack" || ack["request_id"] != "p-"+acked {
+		t.Errorf("first answer = %v, want the ack of %s", ack, acked)
+	}
+	if detail, _ := ps.GetTopicDetail("room1"); detail.MessageCount != 5 {
+		t.Errorf("message count = %d, want 5", detail.MessageCount)
+	}
+	if unacked := ps.GetStats().WebSocket.UnackedPublishes; unacked != 1 {
+		t.Errorf("unacked publishes = %d, want 1", unacked)
+	}
+
+	// Errors are still sent, and unknown modes refused
+	c.send(map[string]interface{}{"type": "publish", "topic": "missing", "ack": "none", "request_id": "p-bad", "message": map[string]interface{}{"id": uuid.New().String()}})
+	if failure := c.nextFrame(); failure["type"] != "error" || failure["request_id"] != "p-bad" {
+		t.Errorf("failed publish answered with %v", failure)
+	}
+	c.send(map[string]interface{}{"type": "publish", "topic": "room1", "ack": "some", "request_id": "p-mode", "message": map[string]interface{}{"id": uuid.New().String()}})
+	if failure := c.nextFrame(); errorCode(failure) != "VALIDATION_FAILED" {
+		t.Errorf("unknown ack mode answered with %v", failure)
+	}
+}
+
+func TestPublishAckOnPausedTopic(t *testing.T) {
+	ps, server := roomsServer(t)
+	c := dialV2(t, server)
+	if _, err := ps.PauseTopic(context.Background(), "room1"); err != nil {
+		t.Fatal(err)
+	}
+	c.publishWithAck("")
+	if ack := c.expect("ack"); ack["status"] != "accepted_paused" {
+		t.Errorf("publish to a paused topic acked with %v", ack)
+	}
+	if _, err := ps.ResumeTopic(context.Background(), "room1"); err != nil {
+		t.Fatal(err)
+	}
+	c.publishWithAck("")
+	if ack := c.expect("ack"); ack["status"] != "ok" {
+		t.Errorf("publish after resuming acked with %v", ack)
+	}
+}
+
+// BenchmarkPublishAckModes measures publishing from one connection with an
+// ack per message, no acks, and cumulative acks. Without per-message acks
+// the publisher doesn't wait, and the run ends once the server has
+// accepted every publish.
+func BenchmarkPublishAckModes(b *testing.B) {
+	for _, mode := range []string{"", pubsub.PublishAckNone, pubsub.PublishAckBatch} {
+		name := mode
+		if name == "" {
+			name = "each"
+		}
+		b.Run(name, func(b *testing.B) {
+			ps, url := ordersServer(b)
+			conn := dialURL(b, url)
+			publish := func(ack string) {
+				id := uuid.New().String()
+				conn.WriteJSON(map[string]interface{}{"type": "publish", "topic": "orders", "ack": ack, "request_id": id, "message": map[string]interface{}{"id": id}})
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				publish(mode)
+				if mode == "" {
+					readBatchFrame(b, conn)
+				}
+			}
+			switch mode {
+			case pubsub.PublishAckNone:
+				publish("")
+				readBatchFrame(b, conn)
+			case pubsub.PublishAckBatch:
+				for acked := 0; acked < b.N; {
+					acked += len(batchAckIDs(readBatchFrame(b, conn)))
+				}
+			}
+			b.StopTimer()
+			if count := ps.GetStats().Topics["orders"].Messages; count < int64(b.N) {
+				b.Fatalf("published %d of %d", count, b.N)
+			}
+		})
+	}
+}
+
+// batchAckIDs returns the message IDs a v1 cumulative ack frame accepts
+func batchAckIDs(frame batchFrame) []interface{} {
+	var ids []interface{}
+	for _, message := range frame.messages {
+		body, _ := message["message"].(map[string]interface{})
+		payload, _ := body["payload"].(map[string]interface{})
+		accepted, _ := payload["message_ids"].([]interface{})
+		ids = append(ids, accepted...)
+	}
+	return ids
+}
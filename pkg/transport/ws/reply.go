@@ -0,0 +1,73 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// handlePublishAndWait publishes a request with a fresh inbox as its
+// reply_to and answers with the first reply sent there, or REQUEST_TIMEOUT.
+// The wait happens off readPump, so other requests keep flowing meanwhile.
+func (c *Client) handlePublishAndWait(req pubsub.PublishRequest, topic string) error {
+	if req.TimeoutMs < 0 || time.Duration(req.TimeoutMs)*time.Millisecond > pubsub.MaxReplyTimeout {
+		return c.respond(pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: fmt.Sprintf("timeout_ms must be 0 to %d", pubsub.MaxReplyTimeout.Milliseconds())},
+			Timestamp: c.clock.Now(),
+		})
+	}
+	if req.DeliverAt != nil || req.DelayMs != 0 || req.ReplyTo != "" {
+		return c.respond(pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "publish_and_wait picks its own reply_to and can't be scheduled"},
+			Timestamp: c.clock.Now(),
+		})
+	}
+
+	pending, err := c.ps.Request(c.ctx, topic, req.Message, c.id(), req.Options(), time.Duration(req.TimeoutMs)*time.Millisecond)
+	if err != nil {
+		return c.respond(pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.NewErrorData(err),
+			Timestamp: c.clock.Now(),
+		})
+	}
+	c.ps.RecordTopicTraffic(topic, c.frameSize, 0)
+	c.published++
+
+	go func() {
+		reply, err := pending.Wait(c.ctx)
+		switch {
+		case errors.Is(err, pubsub.ErrRequestTimeout):
+			c.sendMessage(pubsub.ErrorResponse{
+				Type:      "error",
+				RequestID: req.RequestID,
+				Topic:     req.Topic,
+				Error:     pubsub.ErrorData{Code: pubsub.CodeServerBusy, Reason: "REQUEST_TIMEOUT", Message: "no reply to correlation_id " + pending.CorrelationID + " in time"},
+				Timestamp: c.clock.Now(),
+			})
+		case err != nil:
+			// The connection closed while waiting
+		default:
+			if err := c.sendMessage(pubsub.ReplyResponse{
+				Type:          "reply",
+				RequestID:     req.RequestID,
+				Topic:         req.Topic,
+				CorrelationID: reply.CorrelationID,
+				From:          reply.From,
+				Message:       reply.Message,
+				Timestamp:     reply.Timestamp,
+			}); err != nil {
+				log.Printf("Error sending reply to client %s: %v", c.id(), err)
+			}
+		}
+	}()
+	return nil
+}
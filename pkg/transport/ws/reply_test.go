@@ -0,0 +1,58 @@
+package ws
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPublishAndWait(t *testing.T) {
+	_, server := roomsServer(t)
+	responder := dialV2(t, server)
+	responder.send(map[string]interface{}{"type": "subscribe", "topic": "room1", "client_id": "echo", "request_id": "s-1"})
+	responder.expect("ack")
+
+	requester := dialV2(t, server)
+	ask := func(requestID, topic string, timeoutMs int) {
+		requester.send(map[string]interface{}{
+			"type":           "publish_and_wait",
+			"topic":          topic,
+			"request_id":     requestID,
+			"correlation_id": "corr-" + requestID,
+			"timeout_ms":     timeoutMs,
+			"message":        map[string]interface{}{"id": uuid.New().String(), "payload": "ping"},
+		})
+	}
+
+	// The responder echoes the request to its reply_to
+	ask("r-1", "room1", 5000)
+	request := responder.expect("event")
+	if request["reply_to"] == nil || request["correlation_id"] != "corr-r-1" {
+		t.Fatalf("request event = %v", request)
+	}
+	echoed := responder.request(map[string]interface{}{
+		"type":             "send_to_client",
+		"target_client_id": request["reply_to"],
+		"correlation_id":   request["correlation_id"],
+		"request_id":       "d-1",
+		"message":          map[string]interface{}{"id": uuid.New().String(), "payload": "pong"},
+	})
+	if echoed["status"] != "delivered" {
+		t.Fatalf("reply answered with %v", echoed)
+	}
+	reply := requester.expect("reply")
+	if reply["request_id"] != "r-1" || reply["correlation_id"] != "corr-r-1" || reply["from"] != "echo" || reply["message"].(map[string]interface{})["payload"] != "pong" {
+		t.Errorf("reply = %v", reply)
+	}
+
+	// Nobody answers on room2
+	ask("r-2", "room2", 100)
+	if failure := requester.expect("error"); failure["request_id"] != "r-2" || errorReason(failure) != "REQUEST_TIMEOUT" {
+		t.Errorf("unanswered request got %v", failure)
+	}
+
+	// Inbox IDs are reserved
+	if failure := requester.request(map[string]interface{}{"type": "subscribe", "topic": "room1", "client_id": "_inbox.mine", "request_id": "s-2"}); errorCode(failure) != "VALIDATION_FAILED" {
+		t.Errorf("claiming an inbox ID answered with %v", failure)
+	}
+}
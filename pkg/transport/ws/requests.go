@@ -0,0 +1,90 @@
+package ws
+
+import (
+	"container/list"
+	"time"
+)
+
+// requestKey identifies a request by its type and request_id, so a
+// subscribe and an unsubscribe reusing an ID don't answer for each other
+type requestKey struct {
+	kind string
+	id   string
+}
+
+// recentRequest is the response a request got and when
+type recentRequest struct {
+	key      requestKey
+	response interface{}
+	at       time.Time
+}
+
+// requestCache remembers the responses to a connection's latest requests,
+// least recently used first out, so a client retrying a request it didn't
+// see answered gets the same response without the request running twice.
+// Only used by readPump.
+type requestCache struct {
+	size    int
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[requestKey]*list.Element
+	order   list.List // Most recently used at the front
+}
+
+// newRequestCache creates a cache of up to size responses kept for ttl by
+// the now clock
+func newRequestCache(size int, ttl time.Duration, now func() time.Time) *requestCache {
+	return &requestCache{
+		size:    size,
+		ttl:     ttl,
+		now:     now,
+		entries: make(map[requestKey]*list.Element),
+	}
+}
+
+// get returns the response remembered for key, unless it has expired
+func (rc *requestCache) get(key requestKey) (interface{}, bool) {
+	element, exists := rc.entries[key]
+	if !exists {
+		return nil, false
+	}
+	entry := element.Value.(*recentRequest)
+	if rc.now().Sub(entry.at) > rc.ttl {
+		rc.remove(element)
+		return nil, false
+	}
+	rc.order.MoveToFront(element)
+	return entry.response, true
+}
+
+// add remembers the response to key, dropping expired entries and the
+// least recently used ones over the size
+func (rc *requestCache) add(key requestKey, response interface{}) {
+	now := rc.now()
+	if element, exists := rc.entries[key]; exists {
+		entry := element.Value.(*recentRequest)
+		entry.response, entry.at = response, now
+		rc.order.MoveToFront(element)
+		return
+	}
+	rc.entries[key] = rc.order.PushFront(&recentRequest{key: key, response: response, at: now})
+
+	for oldest := rc.order.Back(); oldest != nil; oldest = rc.order.Back() {
+		if rc.order.Len() <= rc.size && now.Sub(oldest.Value.(*recentRequest).at) <= rc.ttl {
+			break
+		}
+		rc.remove(oldest)
+	}
+}
+
+// remove drops one entry
+func (rc *requestCache) remove(element *list.Element) {
+	delete(rc.entries, element.Value.(*recentRequest).key)
+	rc.order.Remove(element)
+}
+
+// clear drops every entry
+func (rc *requestCache) clear() {
+	clear(rc.entries)
+	rc.order.Init()
+}
@@ -0,0 +1,171 @@
+package ws
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// publishRequest is a publish to "orders" of a message with id
+func publishRequest(requestID, id string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":       "publish",
+		"topic":      "orders",
+		"request_id": requestID,
+		"message":    map[string]interface{}{"id": id, "payload": "x"},
+	}
+}
+
+// messageID returns the id of an event frame's message
+func messageID(event map[string]interface{}) string {
+	message, _ := event["message"].(map[string]interface{})
+	id, _ := message["id"].(string)
+	return id
+}
+
+func TestRetriedPublishRunsOnce(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	server := serve(t, ps, WebSocketOptions{})
+	sub, pub := dialCodec(t, server, pubsub.JSONCodec), dialCodec(t, server, pubsub.JSONCodec)
+	sub.send(map[string]interface{}{"type": "subscribe", "topic": "orders", "request_id": "s-1"})
+	sub.expect("ack")
+
+	// The retry is answered with the first ack, timestamp and all
+	first := uuid.New().String()
+	pub.send(publishRequest("p-1", first))
+	ack := pub.expect("ack")
+	pub.send(publishRequest("p-1", first))
+	if retried := pub.expect("ack"); !reflect.DeepEqual(retried, ack) {
+		t.Errorf("retried ack = %v, want %v", retried, ack)
+	}
+	// The request_id decides, not the message: a retry with a fresh
+	// message ID is still a retry
+	pub.send(publishRequest("p-1", uuid.New().String()))
+	pub.expect("ack")
+
+	// A marker published after the retries is the next event the
+	// subscriber sees, so the publish ran exactly once
+	marker := uuid.New().String()
+	pub.send(publishRequest("p-2", marker))
+	pub.expect("ack")
+	if id := messageID(sub.expect("event")); id != first {
+		t.Fatalf("first event = %s, want %s", id, first)
+	}
+	if id := messageID(sub.expect("event")); id != marker {
+		t.Fatalf("event after the retries = %s, want the marker %s", id, marker)
+	}
+
+	if repeated := ps.GetStats().WebSocket.RepeatedRequests; repeated != 2 {
+		t.Errorf("repeated requests = %d, want 2", repeated)
+	}
+	if events := ps.GetStats().Topics["orders"].Messages; events != 2 {
+		t.Errorf("orders messages = %d, want 2", events)
+	}
+}
+
+func TestRetriedErrorsAreResent(t *testing.T) {
+	ps := pubsub.New()
+	server := serve(t, ps, WebSocketOptions{})
+	pub := dialCodec(t, server, pubsub.JSONCodec)
+
+	pub.send(publishRequest("p-1", uuid.New().String()))
+	failed := pub.expect("error")
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	// Resent from the cache although the publish would now succeed
+	pub.send(publishRequest("p-1", uuid.New().String()))
+	if retried := pub.expect("error"); !reflect.DeepEqual(retried, failed) {
+		t.Errorf("retried error = %v, want %v", retried, failed)
+	}
+}
+
+func TestRequestIDsAreScopedByTypeAndConnection(t *testing.T) {
+	ps := pubsub.New()
+	if err := ps.CreateTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	server := serve(t, ps, WebSocketOptions{})
+	c := dialCodec(t, server, pubsub.JSONCodec)
+
+	// An unsubscribe reusing the subscribe's ID isn't taken for a retry
+	c.send(map[string]interface{}{"type": "subscribe", "topic": "orders", "client_id": "c-1", "request_id": "r-1"})
+	c.expect("ack")
+	c.send(map[string]interface{}{"type": "unsubscribe", "topic": "orders", "client_id": "c-1", "request_id": "r-1"})
+	c.expect("ack")
+	waitFor(t, "the unsubscribe", func() bool { return ps.GetStats().Topics["orders"].Subscribers == 0 })
+
+	// A new connection starts with an empty cache, so the same request
+	// runs again
+	id := uuid.New().String()
+	c.send(publishRequest("p-1", id))
+	c.expect("ack")
+	other := dialCodec(t, server, pubsub.JSONCodec)
+	other.send(publishRequest("p-1", uuid.New().String()))
+	other.expect("ack")
+	if events := ps.GetStats().Topics["orders"].Messages; events != 2 {
+		t.Errorf("orders messages = %d, want 2", events)
+	}
+	if repeated := ps.GetStats().WebSocket.RepeatedRequests; repeated != 0 {
+		t.Errorf("repeated requests = %d, want 0", repeated)
+	}
+}
+
+func TestRequestCache(t *testing.T) {
+	clock := newFakeClock()
+	cache := newRequestCache(2, 200*time.Millisecond, clock.Now)
+	key := func(id string) requestKey { return requestKey{kind: "publish", id: id} }
+	cached := func(id string) interface{} {
+		response, _ := cache.get(key(id))
+		return response
+	}
+
+	cache.add(key("a"), "ack a")
+	cache.add(key("b"), "ack b")
+	if _, ok := cache.get(requestKey{kind: "subscribe", id: "a"}); ok {
+		t.Error("a subscribe found the publish's response")
+	}
+
+	// Reading a makes b the least recently used, evicted by c
+	if got := cached("a"); got != "ack a" {
+		t.Errorf("a = %v", got)
+	}
+	cache.add(key("c"), "ack c")
+	if got := cached("b"); got != nil {
+		t.Errorf("b = %v after eviction", got)
+	}
+	if cached("a") != "ack a" || cached("c") != "ack c" {
+		t.Errorf("a, c = %v, %v", cached("a"), cached("c"))
+	}
+
+	// Re-adding a key replaces its response and restarts its TTL
+	clock.Advance(120 * time.Millisecond)
+	cache.add(key("a"), "error a")
+	clock.Advance(100 * time.Millisecond)
+	if got := cached("c"); got != nil {
+		t.Errorf("c = %v after its TTL", got)
+	}
+	if got := cached("a"); got != "error a" {
+		t.Errorf("a = %v, want the replaced response", got)
+	}
+
+	// Adding drops expired entries without waiting for a get
+	clock.Advance(300 * time.Millisecond)
+	cache.add(key("d"), "ack d")
+	if len(cache.entries) != 1 || cache.order.Len() != 1 {
+		t.Errorf("%d entries, %d in order after expiry, want 1", len(cache.entries), cache.order.Len())
+	}
+
+	cache.clear()
+	if got := cached("d"); got != nil || cache.order.Len() != 0 {
+		t.Errorf("d = %v, %d in order after clear", got, cache.order.Len())
+	}
+}
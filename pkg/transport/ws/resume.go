@@ -0,0 +1,89 @@
+package ws
+
+import (
+	"log"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// ResumeTokenPolicy decides what happens to a connection claiming a
+// client_id without its resume token. Every connection is issued a token
+// for its client_id, in the welcome frame or the first ack after a claim,
+// and rotated on each reconnect; a reconnect presents it in the
+// X-Resume-Token upgrade header or the hello's resume_token.
+type ResumeTokenPolicy string
+
+const (
+	// ResumeTokensOff lets any connection claim any free client_id. Topic
+	// ownership and private topic memberships are keyed on the bare
+	// client_id, so with tokens off whoever claims an ID next inherits
+	// them; the other policies keep the token of such an ID past the
+	// session retention.
+	ResumeTokensOff ResumeTokenPolicy = "off"
+
+	// ResumeTokensNewIdentity keeps a connection without the token on its
+	// server-assigned client_id; its requests naming the claimed ID are
+	// served under the assigned one
+	ResumeTokensNewIdentity ResumeTokenPolicy = "new_identity"
+
+	// ResumeTokensReject refuses the claim with PERMISSION_DENIED
+	ResumeTokensReject ResumeTokenPolicy = "reject"
+)
+
+// resumeTokenHeader carries a resume token in the upgrade request. Browsers
+// can't set it, and present theirs in the hello instead.
+const resumeTokenHeader = "X-Resume-Token"
+
+// checkResumeToken decides a claim of a client_id by the resume token the
+// connection presented. It reports whether to bind the claimed ID; with
+// ResumeTokensNewIdentity a claim without the token stays on the current
+// ID, and with ResumeTokensReject it is refused.
+func (c *Client) checkResumeToken(current, claimed string) (bool, error) {
+	if c.opts.ResumeTokens == "" || c.opts.ResumeTokens == ResumeTokensOff || c.ps.CheckResumeToken(claimed, c.presentedToken) {
+		return true, nil
+	}
+	if c.opts.ResumeTokens == ResumeTokensReject {
+		return false, pubsub.ErrorData{Code: pubsub.CodePermissionDenied, Reason: "RESUME_TOKEN_INVALID", Message: "client_id " + claimed + " needs its resume token"}
+	}
+
+	log.Printf("Client %s claimed client_id %s without its resume token; keeping its own ID", current, claimed)
+	c.identified = true
+	c.unclaimed = claimed
+	c.pendingToken = c.token
+	// A claim in the upgrade URL is answered by the welcome frame naming
+	// the connection's ID
+	if c.welcomed {
+		c.sendMessage(pubsub.InfoResponse{
+			Type:      "info",
+			Message:   "client_id " + claimed + " needs its resume token; connected as " + current,
+			Reason:    "NEW_IDENTITY",
+			Timestamp: c.clock.Now(),
+		})
+	}
+	return false, nil
+}
+
+// issueResumeToken gives the connection a fresh token for its client_id,
+// retiring the one issued for previous, its ID until now. The token goes
+// out in the welcome frame, or the next ack once welcomed.
+func (c *Client) issueResumeToken(previous string) {
+	if previous != "" {
+		c.ps.DiscardResumeToken(previous, c.token)
+	}
+	c.token = c.ps.IssueResumeToken(c.id())
+	if c.welcomed {
+		c.pendingToken = c.token
+	}
+}
+
+// withResumeToken adds a token not yet sent to an ack
+func (c *Client) withResumeToken(response interface{}) interface{} {
+	ack, ok := response.(pubsub.AckResponse)
+	if !ok || c.pendingToken == "" {
+		return response
+	}
+	ack.ClientID = c.id()
+	ack.ResumeToken = c.pendingToken
+	c.pendingToken = ""
+	return ack
+}
@@ -0,0 +1,134 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// ackPayload returns the payload of an ack, which comes wrapped in an
+// event envelope
+func ackPayload(t *testing.T, frame map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	if frame["type"] != "ack" {
+		t.Fatalf("answer = %v, want an ack", frame)
+	}
+	message, _ := frame["message"].(map[string]interface{})
+	payload, _ := message["payload"].(map[string]interface{})
+	return payload
+}
+
+// leaveAsAlice claims alice on a new connection, disconnects it and holds a
+// direct message for alice, returning the resume token the claim was issued
+func leaveAsAlice(t *testing.T, ps *pubsub.PubSubSystem, server *httptest.Server) string {
+	t.Helper()
+	c, welcome := dialWelcome(t, server, "", nil)
+	ack := ackPayload(t, c.subscribeAs("alice"))
+	token, _ := ack["resume_token"].(string)
+	if ack["client_id"] != "alice" || token == "" || token == welcome.ResumeToken {
+		t.Fatalf("claim ack = %v, want a new token for alice", ack)
+	}
+	c.conn.Close()
+	waitFor(t, "alice to disconnect", func() bool { return !connected(ps, "alice") })
+
+	if _, err := ps.SendDirect(context.Background(), "bob", "alice", pubsub.MessageData{ID: uuid.New().String(), Payload: "secret"}, pubsub.DirectOptions{Hold: true}); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestResumeTokenResumesSession(t *testing.T) {
+	t.Run("header", func(t *testing.T) {
+		ps, server := identityServer(t, WebSocketOptions{ResumeTokens: ResumeTokensNewIdentity})
+		token := leaveAsAlice(t, ps, server)
+		c, welcome := dialWelcome(t, server, "?client_id=alice", http.Header{resumeTokenHeader: {token}})
+		if welcome.ClientID != "alice" {
+			t.Fatalf("resumed as %s", welcome.ClientID)
+		}
+		if welcome.ResumeToken == "" || welcome.ResumeToken == token {
+			t.Error("the token wasn't rotated")
+		}
+		if direct := c.nextFrame(); direct["type"] != "direct" {
+			t.Errorf("first frame after the welcome = %v, want the held message", direct)
+		}
+	})
+
+	t.Run("hello", func(t *testing.T) {
+		ps, server := identityServer(t, WebSocketOptions{ResumeTokens: ResumeTokensNewIdentity})
+		token := leaveAsAlice(t, ps, server)
+		c, _ := dialWelcome(t, server, "", nil)
+		c.send(map[string]interface{}{"type": "hello", "protocol_version": 1, "resume_token": token})
+		c.expect("hello_ack")
+		c.send(map[string]interface{}{"type": "subscribe", "topic": "orders", "client_id": "alice", "request_id": "s"})
+		ack := ackPayload(t, c.expect("ack"))
+		if ack["client_id"] != "alice" || ack["resume_token"] == token {
+			t.Errorf("resume ack = %v", ack)
+		}
+		if direct := c.expect("direct"); direct["from"] != "bob" {
+			t.Errorf("held message = %v", direct)
+		}
+	})
+}
+
+func TestClaimWithoutResumeToken(t *testing.T) {
+	t.Run("new identity", func(t *testing.T) {
+		ps, server := identityServer(t, WebSocketOptions{ResumeTokens: ResumeTokensNewIdentity})
+		leaveAsAlice(t, ps, server)
+
+		c, welcome := dialWelcome(t, server, "", http.Header{resumeTokenHeader: {"guess"}})
+		c.send(map[string]interface{}{"type": "subscribe", "topic": "orders", "client_id": "alice", "request_id": "s"})
+		if _, notice := finalPayload(t, []map[string]interface{}{c.expect("info")}); notice["reason"] != "NEW_IDENTITY" {
+			t.Errorf("notice = %v", notice)
+		}
+		ack := ackPayload(t, c.expect("ack"))
+		if ack["client_id"] != welcome.ClientID || ack["resume_token"] != welcome.ResumeToken {
+			t.Errorf("claim ack = %v, want the assigned identity", ack)
+		}
+		if connected(ps, "alice") || !connected(ps, welcome.ClientID) {
+			t.Error("the connection took alice")
+		}
+		// Requests may keep naming the claimed ID
+		if frame := c.request(map[string]interface{}{"type": "unsubscribe", "topic": "orders", "client_id": "alice", "request_id": "u"}); frame["type"] != "ack" {
+			t.Errorf("unsubscribe as alice = %v", frame)
+		}
+		if held := ps.DeliverHeldDirect(probeClient{id: "alice"}); held != 1 {
+			t.Errorf("%d held messages left for alice, want 1", held)
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		ps, server := identityServer(t, WebSocketOptions{ResumeTokens: ResumeTokensReject})
+		token := leaveAsAlice(t, ps, server)
+
+		c, _ := dialWelcome(t, server, "?protocol_version=2", http.Header{resumeTokenHeader: {token + "0"}})
+		if failure := c.subscribeAs("alice"); errorReason(failure) != "RESUME_TOKEN_INVALID" {
+			t.Errorf("claim with a wrong token = %v", failure)
+		}
+		if connected(ps, "alice") {
+			t.Error("the connection took alice")
+		}
+	})
+}
+
+func TestExpiredResumeTokenFreesClientID(t *testing.T) {
+	ps, server := identityServer(t, WebSocketOptions{ResumeTokens: ResumeTokensReject})
+	ps.SetSessionRetention(50 * time.Millisecond)
+	leaveAsAlice(t, ps, server)
+	time.Sleep(100 * time.Millisecond)
+
+	// With the token expired the ID is free, and alice's held message gone
+	c, _ := dialWelcome(t, server, "", nil)
+	ack := ackPayload(t, c.subscribeAs("alice"))
+	if ack["client_id"] != "alice" || ack["resume_token"] == "" {
+		t.Fatalf("claim ack = %v", ack)
+	}
+	if held := ps.DeliverHeldDirect(probeClient{id: "alice"}); held != 0 {
+		t.Errorf("%d held messages delivered to a new alice", held)
+	}
+}
@@ -0,0 +1,80 @@
+package ws
+
+import (
+	"log"
+	"time"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// handleSchedule holds a validated publish for later delivery and answers
+// with the token that cancels it
+func (c *Client) handleSchedule(req pubsub.PublishRequest, topic string) error {
+	if req.DeliverAt != nil && req.DelayMs != 0 {
+		return c.respond(pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "deliver_at and delay_ms are mutually exclusive"},
+			Timestamp: c.clock.Now(),
+		})
+	}
+	if req.DelayMs < 0 {
+		return c.respond(pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "delay_ms must not be negative"},
+			Timestamp: c.clock.Now(),
+		})
+	}
+
+	deliverAt := c.clock.Now().Add(time.Duration(req.DelayMs) * time.Millisecond)
+	if req.DeliverAt != nil {
+		deliverAt = *req.DeliverAt
+	}
+	scheduled, err := c.ps.SchedulePublish(c.ctx, topic, req.Message, c.id(), req.Options(), deliverAt)
+	if err != nil {
+		return c.respond(pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.NewErrorData(err),
+			Timestamp: c.clock.Now(),
+		})
+	}
+	log.Printf("Scheduled message %s from client %s to topic %s for %s", scheduled.Token, c.id(), topic, scheduled.DeliverAt.Format(time.RFC3339))
+
+	return c.respond(pubsub.AckResponse{
+		Type:      "ack",
+		RequestID: req.RequestID,
+		Topic:     req.Topic,
+		Status:    "scheduled",
+		Token:     scheduled.Token,
+		DeliverAt: &scheduled.DeliverAt,
+		Timestamp: c.clock.Now(),
+	})
+}
+
+// handleCancelScheduled cancels a scheduled publish by its token
+func (c *Client) handleCancelScheduled(req pubsub.CancelScheduledRequest) error {
+	if req.RequestID == "" || req.Token == "" {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "request_id and token are required"}
+	}
+
+	scheduled, err := c.ps.CancelScheduled(req.Token)
+	if err != nil {
+		return c.sendMessage(pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.NewErrorData(err),
+			Timestamp: c.clock.Now(),
+		})
+	}
+
+	return c.sendMessage(pubsub.AckResponse{
+		Type:      "ack",
+		RequestID: req.RequestID,
+		Topic:     pubsub.LocalTopic(scheduled.Topic),
+		Status:    "cancelled",
+		Token:     scheduled.Token,
+		Timestamp: c.clock.Now(),
+	})
+}
@@ -0,0 +1,37 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+func TestPublishRejectedBySchema(t *testing.T) {
+	ps := pubsub.New()
+	schema := json.RawMessage(`{"type":"object","required":["id","amount"],"properties":{"amount":{"type":"number"}}}`)
+	if err := ps.CreateTopicWithConfig(context.Background(), "orders", pubsub.TopicConfig{Schema: schema}); err != nil {
+		t.Fatal(err)
+	}
+	c := dialCodec(t, serve(t, ps, WebSocketOptions{}), pubsub.JSONCodec)
+	publish := func(requestID string, payload interface{}) {
+		c.send(map[string]interface{}{"type": "publish", "topic": "orders", "request_id": requestID, "message": map[string]interface{}{"id": uuid.New().String(), "payload": payload}})
+	}
+
+	// Every violation is listed, up to a few, in the error's details
+	publish("p-1", map[string]interface{}{"amount": "ten"})
+	frame := c.expect("error")
+	message, _ := frame["message"].(map[string]interface{})
+	data, _ := message["payload"].(map[string]interface{})
+	details, _ := data["details"].([]interface{})
+	if message["id"] != "p-1" || data["code"] != "VALIDATION_FAILED" || data["reason"] != "SCHEMA_VALIDATION_FAILED" || len(details) != 2 {
+		t.Errorf("invalid publish answered with %v", frame)
+	}
+
+	// The connection stays usable
+	publish("p-2", map[string]interface{}{"id": "o1", "amount": 10})
+	c.expect("ack")
+}
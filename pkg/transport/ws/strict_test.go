@@ -0,0 +1,70 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// answerRaw sends a raw text frame and returns the ack or error answering it
+func (c *wireClient) answerRaw(frame string) map[string]interface{} {
+	c.t.Helper()
+	if err := c.conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+		c.t.Fatal(err)
+	}
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var answer map[string]interface{}
+		if err := c.conn.ReadJSON(&answer); err != nil {
+			c.t.Fatalf("waiting for the answer to %s: %v", frame, err)
+		}
+		if answer["type"] == "ack" || answer["type"] == "error" {
+			return answer
+		}
+	}
+}
+
+func TestStrictProtocol(t *testing.T) {
+	frames := []struct {
+		name    string
+		frame   string
+		lenient string // Error code in lenient mode, "" for an ack
+		field   string // Field strict mode refuses, "" for an ack
+	}{
+		{"valid", `{"type":"subscribe","topic":"orders","request_id":"s-1"}`, "", ""},
+		{"negative last_n", `{"type":"subscribe","topic":"orders","last_n":-1,"request_id":"s-2"}`, "", "last_n"},
+		{"unknown field", `{"type":"subscribe","topic":"orders","lastN":3,"request_id":"s-3"}`, "", "lastN"},
+		{"wrong type", `{"type":"publish","topic":"orders","message":{"id":"` + uuid.New().String() + `"},"delay_ms":"soon","request_id":"p-1"}`, "VALIDATION_FAILED", "delay_ms"},
+		{"missing topic", `{"type":"unsubscribe","request_id":"u-1"}`, "NOT_SUBSCRIBED", "topic"},
+	}
+
+	run := func(t *testing.T, c *wireClient, strict bool) {
+		for _, tc := range frames {
+			answer := c.answerRaw(tc.frame)
+			want := tc.lenient
+			if strict && tc.field != "" {
+				want = "VALIDATION_FAILED"
+			}
+			if got := errorCode(answer); (want == "" && answer["type"] != "ack") || (want != "" && got != want) {
+				t.Errorf("%s answered with %v, want %q", tc.name, answer, want)
+				continue
+			}
+			if !strict || tc.field == "" {
+				continue
+			}
+			errorData := answer["error"].(map[string]interface{})
+			if errorData["field"] != tc.field || answer["request_id"] == nil || answer["request_id"] == "" {
+				t.Errorf("%s answered with %v, want field %s and the request_id", tc.name, answer, tc.field)
+			}
+		}
+	}
+
+	_, server := identityServer(t, WebSocketOptions{})
+	t.Run("lenient", func(t *testing.T) { run(t, dialV2(t, server), false) })
+	t.Run("negotiated", func(t *testing.T) { run(t, dialV2(t, server, capabilityStrict), true) })
+
+	_, strictServer := identityServer(t, WebSocketOptions{StrictProtocol: true})
+	t.Run("server option", func(t *testing.T) { run(t, dialV2(t, strictServer), true) })
+}
@@ -0,0 +1,69 @@
+package ws
+
+import (
+	"log"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// handleTopicRequest creates, deletes or transfers a topic. The creating
+// client owns the topic; deleting and transferring it are reserved to its
+// owner and to admin connections.
+func (c *Client) handleTopicRequest(req pubsub.TopicRequest) error {
+	if req.RequestID == "" {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "request_id is required"}
+	}
+	topic, err := c.topic(req.Topic)
+	if err != nil {
+		return err
+	}
+	if err := c.requireClientID(req.ClientID); err != nil {
+		return err
+	}
+	private, err := pubsub.ParseVisibility(req.Visibility)
+	if err != nil {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: err.Error()}
+	}
+	ctx := pubsub.WithActor(c.ctx, c.id())
+
+	status := "created"
+	switch req.Type {
+	case "create_topic":
+		err = c.ps.CreateTopicWithConfig(ctx, topic, pubsub.TopicConfig{Owner: c.id(), Private: private})
+	case "delete_topic":
+		status = "deleted"
+		if c.admin {
+			err = c.ps.DeleteTopic(ctx, topic)
+		} else {
+			err = c.ps.DeleteOwnedTopic(ctx, topic, c.id())
+		}
+	case "transfer_topic":
+		if req.NewOwner == "" {
+			return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "new_owner is required"}
+		}
+		status = "transferred"
+		requester := c.id()
+		if c.admin {
+			requester = ""
+		}
+		err = c.ps.TransferTopic(ctx, topic, requester, req.NewOwner)
+	}
+	if err != nil {
+		return c.respond(pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Topic:     req.Topic,
+			Error:     pubsub.NewErrorData(err),
+			Timestamp: c.clock.Now(),
+		})
+	}
+	log.Printf("Client %s: topic %s %s", c.id(), topic, status)
+
+	return c.respond(pubsub.AckResponse{
+		Type:      "ack",
+		RequestID: req.RequestID,
+		Topic:     req.Topic,
+		Status:    status,
+		Timestamp: c.clock.Now(),
+	})
+}
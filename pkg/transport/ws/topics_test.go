@@ -0,0 +1,76 @@
+package ws
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// manageTopic sends a topic request for "board" as clientID and returns
+// the answer
+func (c *wireClient) manageTopic(kind, clientID, newOwner string) map[string]interface{} {
+	c.t.Helper()
+	return c.request(map[string]interface{}{
+		"type":       kind,
+		"topic":      "board",
+		"client_id":  clientID,
+		"new_owner":  newOwner,
+		"request_id": uuid.New().String(),
+	})
+}
+
+func TestTopicOwnership(t *testing.T) {
+	ps := pubsub.New()
+	h, err := NewHandler(ps, WebSocketOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	admin := httptest.NewServer(h.Admin())
+	t.Cleanup(admin.Close)
+
+	alice, bob := dialV2(t, server), dialV2(t, server)
+	if ack := alice.manageTopic("create_topic", "alice", ""); ack["status"] != "created" {
+		t.Fatalf("create answered with %v", ack)
+	}
+	if detail, _ := ps.GetTopicDetail("board"); detail.Owner != "alice" {
+		t.Errorf("owner = %q, want alice", detail.Owner)
+	}
+	if failure := bob.manageTopic("create_topic", "bob", ""); errorCode(failure) != "TOPIC_EXISTS" {
+		t.Errorf("creating it again answered with %v", failure)
+	}
+	if failure := bob.manageTopic("delete_topic", "bob", ""); errorCode(failure) != "PERMISSION_DENIED" {
+		t.Errorf("delete by a non-owner answered with %v", failure)
+	}
+	if failure := bob.manageTopic("transfer_topic", "bob", "bob"); errorCode(failure) != "PERMISSION_DENIED" {
+		t.Errorf("transfer by a non-owner answered with %v", failure)
+	}
+
+	// Once transferred, only the new owner may delete it
+	if ack := alice.manageTopic("transfer_topic", "alice", "bob"); ack["status"] != "transferred" {
+		t.Fatalf("transfer answered with %v", ack)
+	}
+	if failure := alice.manageTopic("delete_topic", "alice", ""); errorCode(failure) != "PERMISSION_DENIED" {
+		t.Errorf("delete by the previous owner answered with %v", failure)
+	}
+	if ack := bob.manageTopic("delete_topic", "bob", ""); ack["status"] != "deleted" {
+		t.Fatalf("delete by the new owner answered with %v", ack)
+	}
+	if _, exists := ps.TopicOwner("board"); exists {
+		t.Error("topic survived its owner deleting it")
+	}
+	if failure := bob.manageTopic("delete_topic", "bob", ""); errorCode(failure) != "TOPIC_NOT_FOUND" {
+		t.Errorf("deleting a missing topic answered with %v", failure)
+	}
+
+	// Admin connections manage any topic
+	alice.manageTopic("create_topic", "alice", "")
+	operator := dialV2(t, admin)
+	if ack := operator.manageTopic("delete_topic", "ops", ""); ack["status"] != "deleted" {
+		t.Errorf("admin delete answered with %v", ack)
+	}
+}
@@ -0,0 +1,26 @@
+package ws
+
+import "testing"
+
+func TestSubscriptionTTL(t *testing.T) {
+	ps, server := roomsServer(t)
+	c := dialV2(t, server)
+	ack := c.request(map[string]interface{}{"type": "subscribe", "topic": "room1", "client_id": "tail", "ttl_seconds": 1, "request_id": "s-1"})
+	if ack["type"] != "ack" || ack["expires_at"] == nil {
+		t.Fatalf("subscribe answered with %v", ack)
+	}
+	if ack := c.request(map[string]interface{}{"type": "touch_subscription", "topic": "room1", "request_id": "t-1"}); ack["status"] != "touched" || ack["expires_at"] == nil {
+		t.Errorf("touch answered with %v", ack)
+	}
+	if failure := c.request(map[string]interface{}{"type": "touch_subscription", "topic": "room2", "request_id": "t-2"}); errorCode(failure) != "NOT_SUBSCRIBED" {
+		t.Errorf("touching an unsubscribed topic answered with %v", failure)
+	}
+
+	notice := c.expect("unsubscribed")
+	if notice["topic"] != "room1" || notice["reason"] != "ttl_expired" {
+		t.Errorf("expiry notice = %v", notice)
+	}
+	if ps.IsSubscribed("tail", "room1") {
+		t.Error("still subscribed after the TTL expired")
+	}
+}
@@ -0,0 +1,2404 @@
+// Package ws serves pubsub clients over WebSocket connections, including
+// subprotocol negotiation, compression and origin checking.
+package ws
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/clock"
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+const (
+	// Time allowed to write a message to the peer
+	DefaultWriteWait = 10 * time.Second
+
+	// Time allowed to read the next pong message from the peer
+	DefaultPongWait = 60 * time.Second
+
+	// Send pings to peer with this period. Must be less than the pong wait
+	DefaultPingPeriod = (DefaultPongWait * 9) / 10
+
+	// Socket buffer sizes used by the upgrader
+	DefaultReadBufferSize  = 1024
+	DefaultWriteBufferSize = 1024
+
+	// Frame bytes allowed on top of the payload limit for the request
+	// envelope (type, topic, ids, filters)
+	frameOverhead = 4096
+
+	// Nesting a publish frame adds around its payload: {"message":{"payload":...}}
+	publishEnvelopeDepth = 2
+
+	// Longest client_id a client may claim
+	maxClientIDLength = 128
+
+	// How long a takeover waits for the previous connection to clean up,
+	// and how much of that it gives the close handshake before dropping it
+	takeoverWait      = 5 * time.Second
+	takeoverCloseWait = time.Second
+
+	// Longest close reason that fits a close frame's 125-byte payload
+	maxCloseReason = 123
+
+	// Retry-After sent with upgrades refused at the connection cap
+	connectionLimitRetryAfter = 5 * time.Second
+
+	// Control responses (acks, errors, pongs, infos) queued per client. They
+	// are written before any queued event.
+	DefaultControlBufferSize = 64
+
+	// Limits on how many queued messages one batch frame coalesces
+	maxBatchMessages = 64
+	maxBatchBytes    = 64 * 1024
+
+	// Largest encoding buffer a connection keeps between messages
+	maxScratchBytes = 64 * 1024
+
+	// Responses remembered per connection for retried request_ids, and for
+	// how long
+	DefaultRequestCacheSize = 256
+	DefaultRequestCacheTTL  = 5 * time.Minute
+
+	// Probes go out this often to connections that negotiated them, and a
+	// client that hasn't answered one within the timeout is unresponsive
+	DefaultProbePeriod  = 30 * time.Second
+	DefaultProbeTimeout = 10 * time.Second
+
+	// A connection sending this many invalid requests within the window,
+	// without a valid one in between, is closed
+	DefaultErrorBudget = 20
+	DefaultErrorWindow = 10 * time.Second
+
+	// Announced bytes of chunked publishes a connection may have in
+	// progress, and how long one may wait for its next chunk
+	DefaultMaxChunkedBytes = 4 * 1024 * 1024
+	DefaultChunkTimeout    = 30 * time.Second
+
+	// Publishes with "ack": "batch" are acknowledged together once this
+	// many are accepted, or this long after the first
+	DefaultPublishAckBatchSize = 100
+	DefaultPublishAckInterval  = 50 * time.Millisecond
+
+	DefaultCompressionLevel     = flate.BestSpeed // Favour latency over ratio
+	DefaultCompressionThreshold = 1024            // Messages smaller than this are sent uncompressed
+)
+
+// WebSocketOptions configures per-server websocket behaviour
+type WebSocketOptions struct {
+	// Negotiate permessage-deflate with clients that offer it
+	EnableCompression bool
+
+	// flate level used for compressed messages; 0 keeps the default
+	CompressionLevel int
+
+	// Only messages of at least this many bytes are compressed; 0 keeps
+	// the default
+	CompressionThreshold int
+
+	// Browser origins allowed to connect; nil allows every origin
+	Origins *OriginPolicy
+
+	// Use the verified client certificate CN as the client ID (mutual TLS)
+	ClientIDFromCert bool
+
+	// Let a connection claim a client_id held by another live connection,
+	// closing the older one instead of refusing the claim
+	ClientIDTakeover bool
+
+	// Returns the address a connection counts against the per-IP
+	// connection cap under, e.g. one resolving X-Forwarded-For from trusted
+	// proxies; nil means the host of the remote address
+	ClientIP func(r *http.Request) string
+
+	// What a claim of a client_id without its resume token gets; empty
+	// means ResumeTokensOff
+	ResumeTokens ResumeTokenPolicy
+
+	// Keepalive: the connection is dropped when no pong arrives within
+	// PongWait; pings go out every PingPeriod, which must be shorter. Zero
+	// PingPeriod means 90% of PongWait.
+	PongWait   time.Duration
+	PingPeriod time.Duration
+
+	// Time allowed to write one frame
+	WriteWait time.Duration
+
+	// Largest accepted incoming frame; zero means the payload limit plus
+	// room for the request envelope
+	MaxMessageSize int
+
+	// Events and control responses queued per client
+	SendBufferSize    int
+	ControlBufferSize int
+
+	// Socket buffer sizes used by the upgrader
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// Subscribe, unsubscribe and publish responses remembered per
+	// connection, and for how long, so a retry reusing the request_id gets
+	// the original response instead of running again
+	RequestCacheSize int
+	RequestCacheTTL  time.Duration
+
+	// Liveness probes sent to connections that negotiated the probe
+	// capability, and how long one may go unanswered before the client is
+	// reported unresponsive
+	ProbePeriod  time.Duration
+	ProbeTimeout time.Duration
+
+	// A connection is closed once it sends ErrorBudget invalid requests
+	// within ErrorWindow; a valid request starts the count over
+	ErrorBudget int
+	ErrorWindow time.Duration
+
+	// Chunked publishes: announced bytes a connection may have in progress
+	// at once, and how long an upload waits for its next chunk before it is
+	// dropped
+	MaxChunkedBytes int
+	ChunkTimeout    time.Duration
+
+	// Cumulative acks for "ack": "batch" publishes go out once this many
+	// are accepted, or this long after the first of them
+	PublishAckBatchSize int
+	PublishAckInterval  time.Duration
+
+	// Parse every connection's requests strictly, as if each had listed
+	// the strict capability in its hello
+	StrictProtocol bool
+
+	// Clock for timestamps, client activity, pings and the pong wait,
+	// probes, the error window, the request cache, chunk timeouts and
+	// publish ack batching; nil means the PubSubSystem's. Socket read and
+	// write deadlines stay on the wall clock, which the OS enforces them
+	// by, so the pong wait is also checked on this clock at every ping.
+	Clock clock.Clock
+}
+
+// capabilityBatch in a hello opts the connection into batch frames, like
+// "batch": true on a subscribe
+const capabilityBatch = "batch"
+
+// capabilityStrict in a hello has the connection's requests parsed
+// strictly: unknown fields, wrong types and out-of-range values are refused
+// with VALIDATION_FAILED instead of being ignored
+const capabilityStrict = "strict"
+
+// serverCapabilities are the optional features announced in hello_ack
+var serverCapabilities = []string{capabilityBatch, "msgpack", "explicit_ack", "filters", "pause", capabilityProbe, capabilityChunking, capabilityStrict, capabilityTopicAliases}
+
+// errCloseSent is returned by writeControl after it sends a close frame
+var errCloseSent = errors.New("close frame sent")
+
+// errFrameTooBig is returned by readFrame for a frame over the read limit
+var errFrameTooBig = errors.New("frame too big")
+
+// withDefaults fills in the zero levels, durations and sizes of opts
+func (opts WebSocketOptions) withDefaults() WebSocketOptions {
+	if opts.Origins == nil {
+		opts.Origins = NewOriginPolicy(nil, true)
+	}
+	if opts.CompressionLevel == 0 {
+		opts.CompressionLevel = DefaultCompressionLevel
+	}
+	if opts.CompressionThreshold == 0 {
+		opts.CompressionThreshold = DefaultCompressionThreshold
+	}
+	if opts.PongWait == 0 {
+		opts.PongWait = DefaultPongWait
+	}
+	if opts.PingPeriod == 0 {
+		opts.PingPeriod = opts.PongWait * 9 / 10
+	}
+	if opts.WriteWait == 0 {
+		opts.WriteWait = DefaultWriteWait
+	}
+	if opts.SendBufferSize == 0 {
+		opts.SendBufferSize = pubsub.ClientSendBufferSize
+	}
+	if opts.ControlBufferSize == 0 {
+		opts.ControlBufferSize = DefaultControlBufferSize
+	}
+	if opts.ReadBufferSize == 0 {
+		opts.ReadBufferSize = DefaultReadBufferSize
+	}
+	if opts.WriteBufferSize == 0 {
+		opts.WriteBufferSize = DefaultWriteBufferSize
+	}
+	if opts.RequestCacheSize == 0 {
+		opts.RequestCacheSize = DefaultRequestCacheSize
+	}
+	if opts.RequestCacheTTL == 0 {
+		opts.RequestCacheTTL = DefaultRequestCacheTTL
+	}
+	if opts.ProbePeriod == 0 {
+		opts.ProbePeriod = DefaultProbePeriod
+	}
+	if opts.ProbeTimeout == 0 {
+		opts.ProbeTimeout = DefaultProbeTimeout
+	}
+	if opts.ErrorBudget == 0 {
+		opts.ErrorBudget = DefaultErrorBudget
+	}
+	if opts.ErrorWindow == 0 {
+		opts.ErrorWindow = DefaultErrorWindow
+	}
+	if opts.MaxChunkedBytes == 0 {
+		opts.MaxChunkedBytes = DefaultMaxChunkedBytes
+	}
+	if opts.ChunkTimeout == 0 {
+		opts.ChunkTimeout = DefaultChunkTimeout
+	}
+	if opts.PublishAckBatchSize == 0 {
+		opts.PublishAckBatchSize = DefaultPublishAckBatchSize
+	}
+	if opts.PublishAckInterval == 0 {
+		opts.PublishAckInterval = DefaultPublishAckInterval
+	}
+	return opts
+}
+
+// validate reports options no connection can be served with
+func (opts WebSocketOptions) validate() error {
+	switch {
+	case opts.CompressionLevel < flate.HuffmanOnly || opts.CompressionLevel > flate.BestCompression:
+		return fmt.Errorf("compression level %d is not a flate level", opts.CompressionLevel)
+	case opts.PongWait < 0 || opts.PingPeriod < 0 || opts.WriteWait < 0 || opts.RequestCacheTTL < 0 ||
+		opts.ProbePeriod < 0 || opts.ProbeTimeout < 0 || opts.ErrorWindow < 0 ||
+		opts.ChunkTimeout < 0 || opts.PublishAckInterval < 0:
+		return errors.New("websocket timeouts must be positive")
+	case opts.PingPeriod >= opts.PongWait:
+		return fmt.Errorf("ping period %s must be shorter than pong wait %s", opts.PingPeriod, opts.PongWait)
+	case opts.MaxMessageSize < 0 || opts.SendBufferSize < 0 || opts.ControlBufferSize < 0 ||
+		opts.ReadBufferSize < 0 || opts.WriteBufferSize < 0 || opts.RequestCacheSize < 0 ||
+		opts.ErrorBudget < 0 || opts.MaxChunkedBytes < 0 || opts.PublishAckBatchSize < 0:
+		return errors.New("websocket sizes must not be negative")
+	}
+	switch opts.ResumeTokens {
+	case "", ResumeTokensOff, ResumeTokensNewIdentity, ResumeTokensReject:
+	default:
+		return fmt.Errorf("resume token policy %q is not %s, %s or %s", opts.ResumeTokens, ResumeTokensOff, ResumeTokensNewIdentity, ResumeTokensReject)
+	}
+	return nil
+}
+
+// Handler serves websocket connections with one set of options
+type Handler struct {
+	ps       *pubsub.PubSubSystem
+	opts     WebSocketOptions
+	upgrader websocket.Upgrader
+}
+
+// NewHandler creates a handler serving connections with opts. Zero
+// levels, durations and sizes keep their defaults.
+func NewHandler(ps *pubsub.PubSubSystem, opts WebSocketOptions) (*Handler, error) {
+	opts = opts.withDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	return &Handler{
+		ps:   ps,
+		opts: opts,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:    opts.ReadBufferSize,
+			WriteBufferSize:   opts.WriteBufferSize,
+			EnableCompression: opts.EnableCompression,
+			Subprotocols:      []string{pubsub.MsgpackSubprotocol},
+			CheckOrigin: func(r *http.Request) bool {
+				return opts.Origins.CheckRequest(r)
+			},
+		},
+	}, nil
+}
+
+// Client represents a WebSocket client connection
+type Client struct {
+	// The websocket connection
+	conn *websocket.Conn
+
+	// Client ID for identification: server-assigned until the client claims
+	// its own. Written only by readPump; read through id() elsewhere.
+	clientID string
+	idMutex  sync.RWMutex
+
+	// Set by the first request that binds the client ID (readPump only)
+	identified bool
+
+	// Set once the welcome frame is queued; later claims deliver the
+	// direct messages held for the claimed ID
+	welcomed bool
+
+	// Resume token issued for the client ID, one not yet sent in an ack,
+	// and the one the connection presented (readPump only after the
+	// upgrade)
+	token          string
+	pendingToken   string
+	presentedToken string
+
+	// A client_id claimed without its resume token, which requests may
+	// keep naming for the connection's own ID (readPump only)
+	unclaimed string
+
+	// Closed once cleanup has detached the client from the pub-sub system
+	done chan struct{}
+
+	// Reference to pub-sub system
+	ps *pubsub.PubSubSystem
+
+	// Server options in effect when the client connected
+	opts WebSocketOptions
+
+	// opts.Clock, or the pub-sub system's
+	clock clock.Clock
+
+	// Buffered channel for sending messages (handles backpressure)
+	messageChan chan outboundFrame
+
+	// Priority queue for control responses so an event flood can't crowd
+	// out acks and errors
+	controlChan chan outboundFrame
+
+	// Wire format for outgoing messages, negotiated via subprotocol
+	codec pubsub.Codec
+
+	// Whether permessage-deflate was negotiated for this connection
+	compression bool
+
+	// Set once the client opts into batch frames; only used with JSON
+	batch atomic.Bool
+
+	// Set once the client negotiates chunked publishes and events
+	chunking atomic.Bool
+
+	// Chunked publishes being reassembled
+	uploads *uploads
+
+	// Negotiated protocol version; written by readPump, read by any sender
+	version atomic.Int32
+
+	// Set once the first request has been handled, after which a hello is
+	// refused (readPump only)
+	started bool
+
+	// Set by the strict capability or option; requests are then parsed
+	// with pubsub.ParseMessageStrict (readPump only)
+	strict bool
+
+	// Namespace every topic name the client sends is resolved in; set from
+	// the URL path or by hello (readPump only after the upgrade)
+	namespace string
+	// Set when the URL path picked the namespace
+	namespaceFixed bool
+
+	// Set for connections upgraded on an admin route, which may use the
+	// firehose
+	admin bool
+
+	// Address the connection counts against the per-IP cap under
+	remoteIP string
+
+	// Traffic counters of the bound client_id
+	usage atomic.Pointer[pubsub.ClientUsage]
+
+	// Size of the frame being handled, for topic traffic (readPump only)
+	frameSize int
+
+	// Messages published, and why the read loop ended, for the audit log
+	// (readPump only)
+	published   int64
+	closeReason string
+	closeCode   int
+
+	// Set after refusing a hello; later requests are ignored while the
+	// close frame goes out (readPump only)
+	rejected bool
+
+	// Canceled on disconnect so in-flight work for a dead client is abandoned
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// topic -> consumer name for explicit-ack subscriptions; only touched
+	// by readPump
+	consumers map[string]string
+
+	// Responses to recent requests, and the request being handled, whose
+	// response respond remembers (readPump only)
+	requests *requestCache
+	handling requestKey
+
+	// When the client was last heard from and its probe answers
+	live *liveness
+
+	// Recent invalid requests, and whether the request being handled was
+	// answered with a validation error (readPump only)
+	budget  *errorBudget
+	invalid bool
+
+	// Accepted "ack": "batch" publishes awaiting their cumulative ack
+	acks publishAcks
+
+	// Topics whose queued events are dropped after an unsubscribe
+	fences fences
+
+	// Numbers events name their topics by, once negotiated
+	aliases topicAliases
+
+	// Scratch space reused across writes (writePump only)
+	scratch writeScratch
+}
+
+// writeScratch holds buffers the write pump reuses for every frame, so
+// steady traffic doesn't allocate per message
+type writeScratch struct {
+	// Encoding of the latest message for this client alone; valid until
+	// the next one is encoded
+	buf     bytes.Buffer
+	encoder *json.Encoder
+
+	// The frames of the batch being written and their encodings; cleared
+	// after each batch so delivered events aren't kept alive
+	frames []outboundFrame
+	data   [][]byte
+}
+
+// Punctuation of a batch frame
+var (
+	batchOpen      = []byte{'['}
+	batchSeparator = []byte{','}
+	batchClose     = []byte{']'}
+)
+
+// NewClient creates a new client instance served with opts. Zero
+// levels, durations and sizes keep their defaults.
+func NewClient(conn *websocket.Conn, ps *pubsub.PubSubSystem, opts WebSocketOptions) *Client {
+	opts = opts.withDefaults()
+	clientID := uuid.New().String()
+	clk := opts.Clock
+	if clk == nil {
+		clk = ps.Clock()
+	}
+
+	codec := pubsub.JSONCodec
+	if conn.Subprotocol() == pubsub.MsgpackSubprotocol {
+		codec = pubsub.MsgpackCodec
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	client := &Client{
+		conn:        conn,
+		clientID:    clientID, // Generate client ID immediately on connection
+		ps:          ps,
+		opts:        opts,
+		clock:       clk,
+		strict:      opts.StrictProtocol,
+		messageChan: make(chan outboundFrame, opts.SendBufferSize), // Buffered channel for backpressure
+		controlChan: make(chan outboundFrame, opts.ControlBufferSize),
+		codec:       codec,
+		ctx:         ctx,
+		cancel:      cancel,
+		consumers:   make(map[string]string),
+		requests:    newRequestCache(opts.RequestCacheSize, opts.RequestCacheTTL, clk.Now),
+		live:        newLiveness(clk.Now),
+		budget:      newErrorBudget(opts.ErrorBudget, opts.ErrorWindow),
+		uploads: newUploads(opts.MaxChunkedBytes, opts.ChunkTimeout, clk, func() {
+			ps.WebSocketTraffic().ExpiredUploads.Add(1)
+		}),
+		done:      make(chan struct{}),
+		namespace: pubsub.DefaultNamespace,
+	}
+	client.version.Store(pubsub.DefaultProtocolVersion)
+	return client
+}
+
+// protocolVersion returns the negotiated protocol version
+func (c *Client) protocolVersion() int {
+	return int(c.version.Load())
+}
+
+// maxFrameSize is the read limit: the configured maximum, or the largest
+// payload any topic accepts plus room for the request around it
+func (c *Client) maxFrameSize() int {
+	if c.opts.MaxMessageSize > 0 {
+		return c.opts.MaxMessageSize
+	}
+	return c.ps.MaxPublishPayloadBytes() + frameOverhead
+}
+
+// id returns the client ID
+func (c *Client) id() string {
+	c.idMutex.RLock()
+	defer c.idMutex.RUnlock()
+	return c.clientID
+}
+
+// claimClientID binds the connection to the client_id of a request. The
+// first request may claim any free ID, or none to adopt the assigned one;
+// later requests must repeat the bound ID.
+func (c *Client) claimClientID(claimed string) error {
+	current := c.id()
+	if claimed == "" || claimed == current || claimed == c.unclaimed {
+		c.identified = true
+		return nil
+	}
+	if c.identified {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "client_id mismatch with existing connection"}
+	}
+	if len(claimed) > maxClientIDLength {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: fmt.Sprintf("client_id must be at most %d bytes", maxClientIDLength)}
+	}
+	if strings.HasPrefix(claimed, pubsub.InboxPrefix) {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "client_ids starting with " + pubsub.InboxPrefix + " are reserved for reply inboxes"}
+	}
+	if bind, err := c.checkResumeToken(current, claimed); !bind {
+		return err
+	}
+
+	if holder, ok := c.ps.RebindClient(c, current, claimed); !ok {
+		if err := c.takeOver(holder, claimed); err != nil {
+			return err
+		}
+		if _, ok := c.ps.RebindClient(c, current, claimed); !ok {
+			return errClientIDInUse(claimed)
+		}
+	}
+
+	c.idMutex.Lock()
+	c.clientID = claimed
+	c.idMutex.Unlock()
+	c.usage.Store(c.ps.ClientUsage(claimed))
+	c.identified = true
+	c.issueResumeToken(current)
+	c.ps.Audit(pubsub.AuditRecord{Event: pubsub.AuditIdentify, ClientID: claimed, PreviousID: current})
+	log.Printf("Client %s claimed client_id %s", current, claimed)
+
+	// A claim in the upgrade URL is followed by the welcome frame, after
+	// which held messages are delivered
+	if c.welcomed {
+		c.deliverHeldDirect()
+	}
+	return nil
+}
+
+// takeOver closes holder, the connection holding clientID, so this one
+// can have the ID, and waits until it has released its subscriptions so
+// its cleanup can't remove ours. Without ClientIDTakeover, or when the
+// holder isn't a websocket connection, the ID stays with the holder.
+func (c *Client) takeOver(holder pubsub.ClientInterface, clientID string) error {
+	previous, isClient := holder.(*Client)
+	if !c.opts.ClientIDTakeover || !isClient {
+		return errClientIDInUse(clientID)
+	}
+
+	log.Printf("Client %s taking over client_id %s", c.id(), clientID)
+	previous.Close(CloseSessionTakenOver, "client_id "+clientID+" was taken over by another connection")
+	select {
+	case <-previous.done:
+	case <-time.After(takeoverCloseWait):
+		previous.conn.Close()
+	}
+	select {
+	case <-previous.done:
+		return nil
+	case <-time.After(takeoverWait):
+		return pubsub.ErrorData{Code: pubsub.CodePermissionDenied, Reason: "CLIENT_ID_IN_USE", Message: "timed out taking over client_id " + clientID}
+	}
+}
+
+// errClientIDInUse refuses a client_id bound to another connection
+func errClientIDInUse(clientID string) error {
+	return pubsub.ErrorData{Code: pubsub.CodePermissionDenied, Reason: "CLIENT_ID_IN_USE", Message: "client_id " + clientID + " is bound to another connection"}
+}
+
+// registerCertIdentity registers the connection under the client ID from
+// its certificate. Like a claimed ID, one held by another connection with
+// the same certificate is taken over or refused.
+func (c *Client) registerCertIdentity() error {
+	if holder, ok := c.ps.RegisterClientIfAbsent(c); !ok {
+		if err := c.takeOver(holder, c.clientID); err != nil {
+			return err
+		}
+		if _, ok := c.ps.RegisterClientIfAbsent(c); !ok {
+			return errClientIDInUse(c.clientID)
+		}
+	}
+	c.ps.ClientConnected(c.clientID)
+	return nil
+}
+
+// requireClientID binds a request's client_id like claimClientID. Protocol
+// v1 requires the ID on requests that carry one; v2 falls back to the
+// connection's ID.
+func (c *Client) requireClientID(claimed string) error {
+	if claimed == "" && c.protocolVersion() < pubsub.ProtocolVersion2 {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "client_id is required"}
+	}
+	return c.claimClientID(claimed)
+}
+
+// welcome queues the welcome frame announcing the assigned client ID
+func (c *Client) welcome() error {
+	if c.token == "" {
+		c.issueResumeToken("")
+	}
+	c.welcomed = true
+	return c.sendMessage(pubsub.WelcomeResponse{
+		Type:               "welcome",
+		ClientID:           c.id(),
+		ResumeToken:        c.token,
+		ProtocolVersion:    c.protocolVersion(),
+		MaxProtocolVersion: pubsub.MaxProtocolVersion,
+		Limits:             c.limits(),
+		Timestamp:          c.clock.Now(),
+	})
+}
+
+// limits describes the server limits announced in welcome and hello_ack
+func (c *Client) limits() pubsub.WelcomeLimits {
+	return pubsub.WelcomeLimits{
+		MaxMessageSize:    c.maxFrameSize(),
+		MaxPayloadBytes:   c.ps.PayloadLimits().MaxBytes,
+		MaxPayloadDepth:   c.ps.PayloadLimits().MaxDepth,
+		SendBufferSize:    c.opts.SendBufferSize,
+		ControlBufferSize: c.opts.ControlBufferSize,
+		MaxBatchMessages:  maxBatchMessages,
+		MaxBatchBytes:     maxBatchBytes,
+		MaxClientIDLength: maxClientIDLength,
+		PingPeriodMs:      int(c.opts.PingPeriod / time.Millisecond),
+		PongWaitMs:        int(c.opts.PongWait / time.Millisecond),
+		WriteWaitMs:       int(c.opts.WriteWait / time.Millisecond),
+	}
+}
+
+// readPump pumps messages from the websocket connection
+func (c *Client) readPump() {
+	defer func() {
+		c.cleanup()
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadDeadline(time.Now().Add(c.opts.PongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(c.opts.PongWait))
+		c.live.touch()
+		return nil
+	})
+	// A client's close is answered with a normal one, whatever its code
+	c.conn.SetCloseHandler(func(int, string) error {
+		c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(CloseNormal, "goodbye"), time.Now().Add(c.opts.WriteWait))
+		return nil
+	})
+
+	for {
+		ft, message, err := c.readFrame()
+		if err == errFrameTooBig {
+			c.closeTooBig()
+			continue
+		}
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				c.closeNow(CloseIdle, "idle timeout")
+			}
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("WebSocket error: %v", err)
+			}
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				c.closeCode = closeErr.Code
+				c.closeReason = closeErr.Text
+				if c.closeReason == "" {
+					c.closeReason = "closed by client"
+				}
+			} else {
+				c.closeReason = err.Error()
+			}
+			break
+		}
+
+		c.usage.Load().Received(len(message))
+		c.frameSize = len(message)
+		c.live.touch()
+
+		// Parse and handle the message directly
+		err = c.handleMessage(codecForFrame(ft), message)
+		if err != nil {
+			log.Printf("Error handling message from client %s: %v", c.id(), err)
+			// Send error response; protocol v2 keeps the handler's code
+			errorData := pubsub.ErrorData{Code: "PROCESSING_ERROR", Message: err.Error()}
+			if c.protocolVersion() >= pubsub.ProtocolVersion2 {
+				errorData = pubsub.NewErrorData(err)
+			}
+			errorResp := pubsub.ErrorResponse{
+				Type:      "error",
+				Error:     errorData,
+				Timestamp: c.clock.Now(),
+			}
+			c.sendMessage(errorResp)
+		}
+
+		// Invalid requests spend the error budget; a valid one refills it
+		if err != nil || c.invalid {
+			if c.budget.fail(c.clock.Now()) {
+				c.closeForErrors()
+			}
+		} else {
+			c.budget.reset()
+		}
+		c.invalid = false
+	}
+}
+
+// closeForErrors tells a client that spent its error budget why it is
+// being disconnected and closes the connection with code 4429. Later
+// requests are ignored while the close frame goes out.
+func (c *Client) closeForErrors() {
+	log.Printf("Client %s sent %d invalid requests within %s, closing", c.id(), c.opts.ErrorBudget, c.opts.ErrorWindow)
+	c.rejected = true
+	c.ps.WebSocketTraffic().ErrorDisconnects.Add(1)
+
+	errorResp := pubsub.ErrorResponse{
+		Type: "error",
+		Error: pubsub.ErrorData{
+			Code:    pubsub.CodeRateLimited,
+			Reason:  "TOO_MANY_ERRORS",
+			Message: fmt.Sprintf("%d invalid requests within %s", c.opts.ErrorBudget, c.opts.ErrorWindow),
+			Limit:   c.opts.ErrorBudget,
+		},
+		Timestamp: c.clock.Now(),
+	}
+	if err := c.sendMessage(errorResp); err != nil {
+		return
+	}
+	c.enqueueControl(outboundFrame{closeCode: CloseTooManyErrors, closeText: "too many errors"}, true)
+}
+
+// readFrame reads the next frame, up to the read limit. The limit is
+// enforced here rather than by the connection so the client can be told
+// about it before the close.
+func (c *Client) readFrame() (int, []byte, error) {
+	ft, r, err := c.conn.NextReader()
+	if err != nil {
+		return 0, nil, err
+	}
+	limit := c.maxFrameSize()
+	data, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(data) > limit {
+		return ft, nil, errFrameTooBig
+	}
+	return ft, data, nil
+}
+
+// closeTooBig tells a client that sent a frame over the read limit why it
+// is being disconnected and closes the connection with CloseMessageTooBig.
+// The rest of the frame, and later requests, are ignored while the close
+// frame goes out.
+func (c *Client) closeTooBig() {
+	if c.rejected {
+		return
+	}
+	log.Printf("Client %s sent a frame over %d bytes, closing", c.id(), c.maxFrameSize())
+	c.rejected = true
+	errorResp := pubsub.ErrorResponse{
+		Type: "error",
+		Error: pubsub.ErrorData{
+			Code:    pubsub.CodePayloadTooLarge,
+			Reason:  "MESSAGE_TOO_BIG",
+			Message: fmt.Sprintf("frame exceeds the limit of %d bytes", c.maxFrameSize()),
+			Limit:   c.maxFrameSize(),
+		},
+		Timestamp: c.clock.Now(),
+	}
+	if err := c.sendMessage(errorResp); err != nil {
+		return
+	}
+	c.enqueueControl(outboundFrame{closeCode: CloseMessageTooBig, closeText: "message too big"}, true)
+}
+
+// writePump pumps messages from the hub to the websocket connection
+func (c *Client) writePump() {
+	ticker := c.clock.NewTicker(c.opts.PingPeriod)
+	probes := c.clock.NewTicker(c.opts.ProbePeriod)
+	defer func() {
+		ticker.Stop()
+		probes.Stop()
+		c.conn.Close()
+		// Release a read loop waiting for room in the control queue
+		c.cancel()
+		c.deadLetterQueued()
+	}()
+
+	log.Printf("writePump started for client %s", c.id())
+
+	for {
+		// Control responses always go out before queued events
+		select {
+		case frame := <-c.controlChan:
+			if err := c.writeControl(frame); err != nil {
+				c.closeAfter(err)
+				return
+			}
+			continue
+		default:
+		}
+
+		select {
+		case frame := <-c.controlChan:
+			if err := c.writeControl(frame); err != nil {
+				c.closeAfter(err)
+				return
+			}
+
+		case frame, ok := <-c.messageChan:
+			c.conn.SetWriteDeadline(time.Now().Add(c.opts.WriteWait))
+			if !ok {
+				log.Printf("messageChan closed for client %s", c.id())
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(CloseNormal, "closing"))
+				return
+			}
+			if !c.fences.passes(frame) {
+				continue
+			}
+
+			data, err := c.encode(frame, true)
+			if err != nil {
+				log.Printf("Error encoding message for client %s: %v", c.id(), err)
+				continue
+			}
+			closed := false
+			if event, ok := c.chunkedEvent(frame, len(data)); ok {
+				err = c.writeChunked(frame, event, data)
+			} else if c.batching() {
+				closed, err = c.writeBatch(frame, data, true)
+			} else {
+				err = c.writeFrame(frame, data)
+			}
+			if err != nil {
+				log.Printf("Error writing message to client %s: %v", c.id(), err)
+				return
+			}
+			if closed {
+				log.Printf("messageChan closed for client %s", c.id())
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(CloseNormal, "closing"))
+				return
+			}
+
+		case <-ticker.C():
+			// A peer that hasn't answered within the pong wait is gone,
+			// whatever the socket deadline says
+			if silent := c.clock.Now().Sub(c.live.last()); silent >= c.opts.PongWait {
+				log.Printf("Client %s sent nothing for %s, closing", c.id(), silent)
+				c.writeControl(outboundFrame{closeCode: CloseIdle, closeText: "idle timeout"})
+				return
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(c.opts.WriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Printf("Error sending ping to client %s: %v", c.id(), err)
+				return
+			}
+
+		case <-probes.C():
+			if err := c.probe(); err != nil {
+				log.Printf("Error sending probe to client %s: %v", c.id(), err)
+				return
+			}
+		}
+	}
+}
+
+// probe sends a liveness probe to a client that negotiated them, unless the
+// last one is still unanswered, and marks the client unresponsive once that
+// one is overdue. Only called by writePump.
+func (c *Client) probe() error {
+	if !c.live.probing.Load() {
+		return nil
+	}
+	probeID, overdue := c.live.nextProbe(c.opts.ProbeTimeout)
+	if overdue {
+		log.Printf("Client %s did not answer a probe within %s", c.id(), c.opts.ProbeTimeout)
+	}
+	if probeID == "" {
+		return nil
+	}
+	return c.writeControl(outboundFrame{message: pubsub.ProbeMessage{
+		Type:      "probe",
+		ProbeID:   probeID,
+		Timestamp: c.clock.Now(),
+	}})
+}
+
+// closeAfter handles a failed control write. After a close frame it gives
+// the peer time to answer, so the read loop sees the close handshake
+// complete before the connection is torn down.
+func (c *Client) closeAfter(err error) {
+	if err != errCloseSent {
+		log.Printf("Error writing message to client %s: %v", c.id(), err)
+		return
+	}
+	select {
+	case <-c.done:
+	case <-time.After(c.opts.WriteWait):
+	}
+}
+
+// deadLetterQueued reports published events still queued when the
+// connection ends. It returns once cleanup closes messageChan, after which
+// nothing more can be queued.
+func (c *Client) deadLetterQueued() {
+	for frame := range c.messageChan {
+		if frame.prepared != nil {
+			c.ps.DeadLetter(frame.prepared.Event, c.id(), pubsub.DeadLetterSlowConsumer)
+		}
+	}
+}
+
+// batching reports whether frames are written as JSON arrays
+func (c *Client) batching() bool {
+	return c.batch.Load() && !c.codec.Binary()
+}
+
+// writeControl writes a control response on its own, as a one-element
+// array for batch-mode clients. A close frame ends the connection and
+// returns errCloseSent.
+func (c *Client) writeControl(frame outboundFrame) error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.opts.WriteWait))
+	if frame.closeCode != 0 {
+		c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(frame.closeCode, frame.closeText))
+		return errCloseSent
+	}
+	data, err := c.encode(frame, true)
+	if err != nil {
+		log.Printf("Error encoding message for client %s: %v", c.id(), err)
+		return nil
+	}
+	if c.batching() {
+		_, err = c.writeBatch(frame, data, false)
+		return err
+	}
+	return c.writeFrame(frame, data)
+}
+
+// writeFrame writes one encoded message as its own frame
+func (c *Client) writeFrame(frame outboundFrame, data []byte) error {
+	c.setCompression(len(data))
+	c.ps.WebSocketTraffic().PayloadBytes.Add(int64(len(data)))
+	if err := c.conn.WriteMessage(frameType(c.codec), data); err != nil {
+		return err
+	}
+	c.countSent(frame, len(data))
+	return nil
+}
+
+// countSent records a written message in the client's usage and, for an
+// event, its topic's traffic
+func (c *Client) countSent(frame outboundFrame, size int) {
+	c.usage.Load().Sent(1, size)
+	if frame.topic != "" {
+		c.ps.RecordTopicTraffic(frame.topic, 0, size)
+	}
+}
+
+// writeBatch writes first as a single JSON array frame. With drain set,
+// whatever events are already queued are added, up to the batch limits.
+// Reports whether the event queue was found closed while draining.
+func (c *Client) writeBatch(firstFrame outboundFrame, first []byte, drain bool) (bool, error) {
+	frames := append(c.scratch.frames[:0], firstFrame)
+	batch := append(c.scratch.data[:0], first)
+	defer func() {
+		// Keep the capacity, not the events
+		clear(frames)
+		clear(batch)
+		c.scratch.frames, c.scratch.data = frames[:0], batch[:0]
+	}()
+	size := len(first)
+	closed := false
+
+drain:
+	for drain && len(batch) < maxBatchMessages && size < maxBatchBytes {
+		select {
+		case frame, ok := <-c.messageChan:
+			if !ok {
+				closed = true
+				break drain
+			}
+			if !c.fences.passes(frame) {
+				continue
+			}
+			// first may be in the scratch buffer, so these get their own
+			data, err := c.encode(frame, false)
+			if err != nil {
+				log.Printf("Error encoding message for client %s: %v", c.id(), err)
+				continue
+			}
+			frames = append(frames, frame)
+			batch = append(batch, data)
+			size += len(data)
+		default:
+			break drain
+		}
+	}
+
+	// Elements plus brackets and separating commas
+	size += len(batch) + 1
+	c.setCompression(size)
+	c.ps.WebSocketTraffic().PayloadBytes.Add(int64(size))
+
+	w, err := c.conn.NextWriter(websocket.TextMessage)
+	if err != nil {
+		return closed, err
+	}
+	w.Write(batchOpen)
+	for i, data := range batch {
+		if i > 0 {
+			w.Write(batchSeparator)
+		}
+		w.Write(data)
+	}
+	w.Write(batchClose)
+	if err := w.Close(); err != nil {
+		return closed, err
+	}
+
+	// The brackets and commas count towards the client's bytes only
+	for i, frame := range frames {
+		c.countSent(frame, len(batch[i]))
+	}
+	c.usage.Load().Sent(0, len(batch)+1)
+	return closed, nil
+}
+
+// setCompression compresses the next frame if it is large enough
+func (c *Client) setCompression(size int) {
+	if !c.compression {
+		return
+	}
+	compress := size >= c.opts.CompressionThreshold
+	c.conn.EnableWriteCompression(compress)
+	if compress {
+		c.ps.WebSocketTraffic().CompressedMessages.Add(1)
+	}
+}
+
+// topic returns the internal name of a topic in the client's namespace
+func (c *Client) topic(name string) (string, error) {
+	topic, err := pubsub.QualifyTopic(c.namespace, name)
+	if err != nil {
+		return "", pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Reason: "INVALID_TOPIC", Message: err.Error()}
+	}
+	return topic, nil
+}
+
+// handleMessage processes incoming messages from clients
+func (c *Client) handleMessage(codec pubsub.Codec, data []byte) error {
+	// A refused hello is followed only by the close frame
+	if c.rejected {
+		return nil
+	}
+
+	// Refuse pathological nesting before it reaches the decoder
+	maxDepth := c.ps.PayloadLimits().MaxDepth
+	if !codec.Binary() && pubsub.JSONDepth(data) > maxDepth+publishEnvelopeDepth {
+		c.invalid = true
+		errorResp := pubsub.ErrorResponse{
+			Type: "error",
+			Error: pubsub.ErrorData{
+				Code:    pubsub.CodePayloadTooLarge,
+				Reason:  "PAYLOAD_TOO_DEEP",
+				Message: fmt.Sprintf("payload nesting exceeds the limit of %d", maxDepth),
+				Limit:   maxDepth,
+			},
+			Timestamp: c.clock.Now(),
+		}
+		return c.sendMessage(errorResp)
+	}
+
+	message, err := c.parse(codec, data)
+	if err != nil {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: err.Error()}
+	}
+	if message == nil {
+		return nil // A strict validation failure was already answered
+	}
+
+	if hello, ok := message.(pubsub.HelloRequest); ok {
+		return c.handleHello(hello)
+	}
+	c.started = true
+
+	switch msg := message.(type) {
+	case pubsub.SubscribeRequest:
+		return c.once(msg.Type, msg.RequestID, func() error { return c.handleSubscribe(msg) })
+	case pubsub.UnsubscribeRequest:
+		return c.once(msg.Type, msg.RequestID, func() error { return c.handleUnsubscribe(msg) })
+	case pubsub.TouchSubscriptionRequest:
+		return c.handleTouchSubscription(msg)
+	case pubsub.PublishRequest:
+		return c.once(msg.Type, msg.RequestID, func() error { return c.handlePublish(msg) })
+	case pubsub.MsgAckRequest:
+		return c.handleMsgAck(msg)
+	case pubsub.CancelScheduledRequest:
+		return c.handleCancelScheduled(msg)
+	case pubsub.GetHistoryRequest:
+		return c.handleGetHistory(msg)
+	case pubsub.TopicRequest:
+		return c.once(msg.Type, msg.RequestID, func() error { return c.handleTopicRequest(msg) })
+	case pubsub.JoinDecisionRequest:
+		return c.once(msg.Type, msg.RequestID, func() error { return c.handleJoinDecision(msg) })
+	case pubsub.SendToClientRequest:
+		return c.once(msg.Type, msg.RequestID, func() error { return c.handleSendToClient(msg) })
+	case pubsub.PauseRequest:
+		return c.handlePause(msg)
+	case pubsub.ResumeRequest:
+		return c.handleResume(msg)
+	case pubsub.PingRequest:
+		return c.handlePing(msg)
+	case pubsub.ProbeAckRequest:
+		return c.handleProbeAck(msg)
+	case pubsub.ChunkBegin:
+		return c.handlePublishBegin(msg)
+	case pubsub.Chunk:
+		return c.handlePublishChunk(msg)
+	case pubsub.ChunkEnd:
+		return c.handlePublishEnd(msg)
+	default:
+		return pubsub.ErrorData{
+			Code:    pubsub.CodeValidationFailed,
+			Reason:  "UNKNOWN_MESSAGE_TYPE",
+			Message: "Unknown message type received",
+		}
+	}
+}
+
+// parse decodes a request, strictly if the connection asked for it. A
+// strict validation failure is answered here, with the request's
+// request_id when it can be read, counts against the error budget and
+// returns a nil message.
+func (c *Client) parse(codec pubsub.Codec, data []byte) (interface{}, error) {
+	if !c.strict {
+		return pubsub.ParseMessageWith(codec, data)
+	}
+	message, err := pubsub.ParseMessageStrict(codec, data)
+	var coded pubsub.ErrorData
+	if !errors.As(err, &coded) || coded.Code != pubsub.CodeValidationFailed {
+		return message, err
+	}
+
+	// Any request_id that decodes as a string, even in a frame that
+	// otherwise failed
+	var peek struct {
+		RequestID string `json:"request_id"`
+	}
+	codec.Unmarshal(data, &peek)
+	c.invalid = true
+	return nil, c.sendMessage(pubsub.ErrorResponse{
+		Type:      "error",
+		RequestID: peek.RequestID,
+		Error:     coded,
+		Timestamp: c.clock.Now(),
+	})
+}
+
+// once runs a request's handler unless the connection already answered its
+// request_id, in which case the remembered response is sent again
+func (c *Client) once(kind, requestID string, handle func() error) error {
+	if requestID == "" {
+		return handle()
+	}
+	key := requestKey{kind: kind, id: requestID}
+	if response, ok := c.requests.get(key); ok {
+		c.ps.WebSocketTraffic().RepeatedRequests.Add(1)
+		return c.respond(response) // Not handling a request, so not remembered again
+	}
+
+	c.handling = key
+	defer func() { c.handling = requestKey{} }()
+	return handle()
+}
+
+// respond sends the ack or error answering the request being handled and
+// remembers it for retries. A VALIDATION_FAILED error counts against the
+// error budget.
+func (c *Client) respond(response interface{}) error {
+	response = c.withResumeToken(response)
+	if errorResp, ok := response.(pubsub.ErrorResponse); ok && errorResp.Error.Code == pubsub.CodeValidationFailed {
+		c.invalid = true
+	}
+	if c.handling.id != "" {
+		c.requests.add(c.handling, response)
+	}
+	return c.sendMessage(response)
+}
+
+// handleHello negotiates the protocol version. It must come before any
+// other request; an unsupported version is refused and the connection
+// closed with code 4400.
+func (c *Client) handleHello(req pubsub.HelloRequest) error {
+	if c.started {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "hello must be the first message"}
+	}
+	c.started = true
+
+	if req.ProtocolVersion < pubsub.ProtocolVersion1 || req.ProtocolVersion > pubsub.MaxProtocolVersion {
+		c.rejected = true
+		errorResp := pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error: pubsub.ErrorData{
+				Code:    pubsub.CodeValidationFailed,
+				Reason:  "UNSUPPORTED_PROTOCOL_VERSION",
+				Message: fmt.Sprintf("protocol_version %d is not supported; use %d to %d", req.ProtocolVersion, pubsub.ProtocolVersion1, pubsub.MaxProtocolVersion),
+			},
+			Timestamp: c.clock.Now(),
+		}
+		if err := c.sendMessage(errorResp); err != nil {
+			return err
+		}
+		return c.enqueueControl(outboundFrame{closeCode: CloseUnsupportedVersion, closeText: "unsupported protocol version"}, true)
+	}
+	c.version.Store(int32(req.ProtocolVersion))
+
+	if req.Namespace != "" && req.Namespace != c.namespace {
+		if c.namespaceFixed {
+			return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: fmt.Sprintf("connection is bound to namespace %s by its URL", c.namespace)}
+		}
+		if !pubsub.ValidNamespace(req.Namespace) {
+			return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Reason: "INVALID_NAMESPACE", Message: fmt.Sprintf("invalid namespace %q", req.Namespace)}
+		}
+		c.namespace = req.Namespace
+	}
+	if req.ResumeToken != "" {
+		c.presentedToken = req.ResumeToken
+	}
+
+	for _, capability := range req.Capabilities {
+		switch capability {
+		case capabilityBatch:
+			c.batch.Store(true)
+		case capabilityProbe:
+			c.live.probing.Store(true)
+		case capabilityChunking:
+			c.chunking.Store(true)
+		case capabilityStrict:
+			c.strict = true
+		case capabilityTopicAliases:
+			c.aliases.enabled.Store(true)
+		}
+	}
+	if req.LastWill != nil {
+		if err := c.registerWill(*req.LastWill); err != nil {
+			return err
+		}
+	}
+
+	return c.sendMessage(pubsub.HelloAckResponse{
+		Type:            "hello_ack",
+		RequestID:       req.RequestID,
+		ProtocolVersion: req.ProtocolVersion,
+		Capabilities:    serverCapabilities,
+		Namespace:       c.namespace,
+		Limits:          c.limits(),
+		Timestamp:       c.clock.Now(),
+	})
+}
+
+// registerWill resolves a last will's topic in the connection's namespace
+// and registers it for the connection's client ID
+func (c *Client) registerWill(will pubsub.LastWill) error {
+	topic, err := c.topic(will.Topic)
+	if err != nil {
+		return err
+	}
+	will.Topic = topic
+	if err := c.ps.SetLastWill(c.id(), will); err != nil {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Reason: "INVALID_LAST_WILL", Message: err.Error()}
+	}
+	log.Printf("Client %s registered a last will for topic %s", c.id(), topic)
+	return nil
+}
+
+// handleSubscribe processes subscribe requests
+func (c *Client) handleSubscribe(req pubsub.SubscribeRequest) error {
+	// Validate request ID
+	if req.RequestID == "" {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "request_id is required"}
+	}
+	if req.Firehose {
+		return c.handleFirehose(req)
+	}
+	if req.TTLSeconds < 0 {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "ttl_seconds must not be negative"}
+	}
+
+	topic, err := c.topic(req.Topic)
+	if err != nil {
+		return err
+	}
+	if err := c.claimClientID(req.ClientID); err != nil {
+		return err
+	}
+	if req.LastWill != nil {
+		if err := c.registerWill(*req.LastWill); err != nil {
+			return err
+		}
+	}
+	log.Printf("Subscribing client %s to topic %s", c.id(), topic)
+
+	// Batch framing is per connection and can only be switched on
+	if req.Batch {
+		c.batch.Store(true)
+	}
+
+	// Explicit-ack state is keyed by client ID, so a reconnect that claims
+	// the same ID resumes its unacked events
+	consumer := c.id()
+
+	opts := pubsub.SubscribeOptions{
+		LastN:    req.LastN,
+		SinceSeq: req.SinceSeq,
+		AckMode:  req.AckMode,
+		Consumer: consumer,
+		Filter:   req.Filter,
+		Headers:  req.Headers,
+		TTL:      time.Duration(req.TTLSeconds) * time.Second,
+	}
+	lastMessages, err := c.ps.SubscribeWithOptions(c.ctx, c.id(), topic, opts, c)
+	if errors.Is(err, pubsub.ErrJoinRequired) {
+		return c.requestJoin(req, topic, opts)
+	}
+	if err != nil {
+		// Send error response
+		errorResp := pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.NewErrorData(err),
+			Timestamp: c.clock.Now(),
+		}
+		return c.respond(errorResp)
+	}
+
+	// Send acknowledgment
+	ackResp := pubsub.AckResponse{
+		Type:      "ack",
+		RequestID: req.RequestID,
+		Topic:     req.Topic,
+		Status:    "ok",
+		Timestamp: c.clock.Now(),
+	}
+	if expiresAt, ok := c.ps.SubscriptionExpiry(c.id(), topic); ok {
+		ackResp.ExpiresAt = &expiresAt
+	}
+	ackResp.TopicAlias = c.aliases.assign(topic, req.FullTopic)
+
+	if req.AckMode == pubsub.AckModeExplicit {
+		c.consumers[topic] = consumer
+	} else {
+		delete(c.consumers, topic)
+	}
+
+	if err := c.respond(ackResp); err != nil {
+		return err
+	}
+
+	// Send last N messages if any, stopping early if the client goes away
+	for _, lastMsg := range lastMessages {
+		if c.ctx.Err() != nil {
+			return c.ctx.Err()
+		}
+		if err := c.sendMessage(lastMsg); err != nil {
+			log.Printf("Error sending last message to client %s: %v", c.id(), err)
+		}
+	}
+
+	return nil
+}
+
+// handleFirehose processes a subscribe to every topic, which only admin
+// connections may make
+func (c *Client) handleFirehose(req pubsub.SubscribeRequest) error {
+	if !c.admin {
+		return c.respond(pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.ErrorData{Code: pubsub.CodePermissionDenied, Message: "the firehose requires an admin connection"},
+			Timestamp: c.clock.Now(),
+		})
+	}
+	if err := c.claimClientID(req.ClientID); err != nil {
+		return err
+	}
+	log.Printf("Client %s subscribed to the firehose", c.id())
+
+	c.ps.SubscribeFirehose(c.id(), c)
+	return c.respond(pubsub.AckResponse{
+		Type:      "ack",
+		RequestID: req.RequestID,
+		Status:    "ok",
+		Timestamp: c.clock.Now(),
+	})
+}
+
+// handleUnsubscribe processes unsubscribe requests
+func (c *Client) handleUnsubscribe(req pubsub.UnsubscribeRequest) error {
+	if req.RequestID == "" {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "request_id is required"}
+	}
+	if req.Firehose {
+		if err := c.requireClientID(req.ClientID); err != nil {
+			return err
+		}
+		c.ps.UnsubscribeFirehose(c.id())
+		return c.respond(pubsub.AckResponse{
+			Type:      "ack",
+			RequestID: req.RequestID,
+			Status:    "ok",
+			Timestamp: c.clock.Now(),
+		})
+	}
+	topic, err := c.topic(req.Topic)
+	if err != nil {
+		return err
+	}
+	// Validate client ID matches the connection
+	if err := c.requireClientID(req.ClientID); err != nil {
+		return err
+	}
+
+	err = c.ps.Unsubscribe(c.ctx, c.id(), topic)
+	if err != nil {
+		errorResp := pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.NewErrorData(err),
+			Timestamp: c.clock.Now(),
+		}
+		return c.respond(errorResp)
+	}
+	delete(c.consumers, topic)
+	c.fences.raise(topic)
+
+	// Send acknowledgment
+	ackResp := pubsub.AckResponse{
+		Type:      "ack",
+		RequestID: req.RequestID,
+		Topic:     req.Topic,
+		Status:    "ok",
+		Timestamp: c.clock.Now(),
+	}
+
+	return c.respond(ackResp)
+}
+
+// handleTouchSubscription restarts the TTL of one of the connection's
+// subscriptions
+func (c *Client) handleTouchSubscription(req pubsub.TouchSubscriptionRequest) error {
+	if req.RequestID == "" {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "request_id is required"}
+	}
+	topic, err := c.topic(req.Topic)
+	if err != nil {
+		return err
+	}
+	if err := c.requireClientID(req.ClientID); err != nil {
+		return err
+	}
+
+	expiresAt, err := c.ps.TouchSubscription(c.id(), topic)
+	if err != nil {
+		return c.sendMessage(pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Topic:     req.Topic,
+			Error:     pubsub.NewErrorData(err),
+			Timestamp: c.clock.Now(),
+		})
+	}
+	return c.sendMessage(pubsub.AckResponse{
+		Type:      "ack",
+		RequestID: req.RequestID,
+		Topic:     req.Topic,
+		Status:    "touched",
+		ExpiresAt: &expiresAt,
+		Timestamp: c.clock.Now(),
+	})
+}
+
+// handlePublish processes publish requests
+func (c *Client) handlePublish(req pubsub.PublishRequest) error {
+	if req.RequestID == "" {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "request_id is required"}
+	}
+
+	topic, err := c.topic(req.Topic)
+	if err != nil {
+		return err
+	}
+	if err := c.claimClientID(req.ClientID); err != nil {
+		return err
+	}
+	log.Printf("Publishing message from client %s to topic %s", c.id(), topic)
+
+	// Validate message ID is a valid UUID
+	if req.Message.ID == "" {
+		errorResp := pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "message.id must be a valid UUID"},
+			Timestamp: c.clock.Now(),
+		}
+		return c.respond(errorResp)
+	}
+
+	// Validate UUID format
+	if _, err := uuid.Parse(req.Message.ID); err != nil {
+		errorResp := pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "message.id must be a valid UUID"},
+			Timestamp: c.clock.Now(),
+		}
+		return c.respond(errorResp)
+	}
+
+	if len(req.OrderingKey) > pubsub.MaxOrderingKeyLength {
+		errorResp := pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: fmt.Sprintf("ordering_key must be at most %d bytes", pubsub.MaxOrderingKeyLength)},
+			Timestamp: c.clock.Now(),
+		}
+		return c.respond(errorResp)
+	}
+
+	if len(req.CompactKey) > pubsub.MaxCompactKeyLength {
+		errorResp := pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: fmt.Sprintf("compact_key must be at most %d bytes", pubsub.MaxCompactKeyLength)},
+			Timestamp: c.clock.Now(),
+		}
+		return c.respond(errorResp)
+	}
+
+	if len(req.ReplyTo) > pubsub.MaxCorrelationLength || len(req.CorrelationID) > pubsub.MaxCorrelationLength {
+		errorResp := pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: fmt.Sprintf("reply_to and correlation_id must be at most %d bytes", pubsub.MaxCorrelationLength)},
+			Timestamp: c.clock.Now(),
+		}
+		return c.respond(errorResp)
+	}
+
+	switch req.Ack {
+	case "", pubsub.PublishAckNone, pubsub.PublishAckBatch:
+	default:
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "ack must be none or batch"}
+	}
+	if req.Ack != "" && (req.Type == "publish_and_wait" || req.DeliverAt != nil || req.DelayMs != 0) {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "ack none and batch only apply to immediate publishes"}
+	}
+
+	if req.Type == "publish_and_wait" {
+		return c.handlePublishAndWait(req, topic)
+	}
+	if req.DeliverAt != nil || req.DelayMs != 0 {
+		return c.handleSchedule(req, topic)
+	}
+
+	// Use the stored client_id from the connection
+	result, err := c.ps.PublishWithResult(c.ctx, topic, req.Message, c.id(), req.Options())
+	if err != nil {
+		errorResp := pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.NewErrorData(err),
+			Timestamp: c.clock.Now(),
+		}
+		return c.respond(errorResp)
+	}
+	c.ps.RecordTopicTraffic(topic, c.frameSize, 0)
+	c.published++
+
+	switch req.Ack {
+	case pubsub.PublishAckNone:
+		c.ps.WebSocketTraffic().UnackedPublishes.Add(1)
+		return nil
+	case pubsub.PublishAckBatch:
+		c.ackBatched(req.Message.ID)
+		return nil
+	}
+
+	// Send acknowledgment
+	ackResp := pubsub.AckResponse{
+		Type:      "ack",
+		RequestID: req.RequestID,
+		Topic:     req.Topic,
+		Status:    "ok",
+		Timestamp: c.clock.Now(),
+	}
+	if result.Paused {
+		ackResp.Status = "accepted_paused"
+	}
+
+	return c.respond(ackResp)
+}
+
+// handleMsgAck processes acknowledgments for explicit-ack subscriptions
+func (c *Client) handleMsgAck(req pubsub.MsgAckRequest) error {
+	if req.RequestID == "" {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "request_id is required"}
+	}
+	if req.DeliveryTag <= 0 && req.UpToSeq <= 0 {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "delivery_tag or up_to_seq is required"}
+	}
+
+	topic, err := c.topic(req.Topic)
+	if err != nil {
+		return err
+	}
+	consumer, exists := c.consumers[topic]
+	if !exists {
+		errorResp := pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.ErrorData{Code: pubsub.CodeNotSubscribed, Message: "no explicit-ack subscription to topic " + req.Topic},
+			Timestamp: c.clock.Now(),
+		}
+		return c.sendMessage(errorResp)
+	}
+
+	if _, err := c.ps.AckMessages(c.ctx, consumer, topic, req.DeliveryTag, req.UpToSeq); err != nil {
+		errorResp := pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.NewErrorData(err),
+			Timestamp: c.clock.Now(),
+		}
+		return c.sendMessage(errorResp)
+	}
+
+	ackResp := pubsub.AckResponse{
+		Type:      "ack",
+		RequestID: req.RequestID,
+		Topic:     req.Topic,
+		Status:    "ok",
+		Timestamp: c.clock.Now(),
+	}
+
+	return c.sendMessage(ackResp)
+}
+
+// handlePause processes pause requests
+func (c *Client) handlePause(req pubsub.PauseRequest) error {
+	if req.RequestID == "" {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "request_id is required"}
+	}
+	topic, err := c.topic(req.Topic)
+	if err != nil {
+		return err
+	}
+	if err := c.requireClientID(req.ClientID); err != nil {
+		return err
+	}
+
+	if err := c.ps.PauseSubscription(c.ctx, c.id(), topic); err != nil {
+		errorResp := pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.NewErrorData(err),
+			Timestamp: c.clock.Now(),
+		}
+		return c.sendMessage(errorResp)
+	}
+
+	ackResp := pubsub.AckResponse{
+		Type:      "ack",
+		RequestID: req.RequestID,
+		Topic:     req.Topic,
+		Status:    "paused",
+		Timestamp: c.clock.Now(),
+	}
+
+	return c.sendMessage(ackResp)
+}
+
+// handleResume processes resume requests
+func (c *Client) handleResume(req pubsub.ResumeRequest) error {
+	if req.RequestID == "" {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "request_id is required"}
+	}
+	topic, err := c.topic(req.Topic)
+	if err != nil {
+		return err
+	}
+	if err := c.requireClientID(req.ClientID); err != nil {
+		return err
+	}
+
+	buffered, evicted, err := c.ps.ResumeSubscription(c.ctx, c.id(), topic)
+	if err != nil {
+		errorResp := pubsub.ErrorResponse{
+			Type:      "error",
+			RequestID: req.RequestID,
+			Error:     pubsub.NewErrorData(err),
+			Timestamp: c.clock.Now(),
+		}
+		return c.sendMessage(errorResp)
+	}
+
+	ackResp := pubsub.AckResponse{
+		Type:      "ack",
+		RequestID: req.RequestID,
+		Topic:     req.Topic,
+		Status:    "resumed",
+		Resume:    &pubsub.ResumeStats{Buffered: buffered, Evicted: evicted},
+		Timestamp: c.clock.Now(),
+	}
+
+	return c.sendMessage(ackResp)
+}
+
+// handlePing processes ping requests
+func (c *Client) handlePing(req pubsub.PingRequest) error {
+	if req.RequestID == "" {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "request_id is required"}
+	}
+
+	now := c.clock.Now()
+	pongResp := pubsub.PongResponse{
+		Type:       "pong",
+		RequestID:  req.RequestID,
+		ServerTime: now.UnixMilli(),
+		Timestamp:  now,
+	}
+
+	return c.sendMessage(pongResp)
+}
+
+// handleProbeAck processes a client's answer to a liveness probe
+func (c *Client) handleProbeAck(req pubsub.ProbeAckRequest) error {
+	if req.ProbeID == "" {
+		return pubsub.ErrorData{Code: pubsub.CodeValidationFailed, Message: "probe_id is required"}
+	}
+	c.live.answer(req.ProbeID)
+	return nil
+}
+
+// sendMessage sends a message to the client
+func (c *Client) sendMessage(message interface{}) error {
+	// Events are accounted to their topic by its internal name
+	var topic string
+	switch m := message.(type) {
+	case *pubsub.PreparedEvent:
+		if m.Event.Type == "event" {
+			topic = m.Event.Topic
+		}
+	case pubsub.EventResponse:
+		if m.Type == "event" {
+			topic = m.Topic
+		}
+	case pubsub.InfoResponse:
+		// Events queued before a subscription ended must not follow the
+		// notice that says so
+		if m.Topic != "" && (m.Type == "unsubscribed" || m.Message == "topic_deleted") {
+			c.fences.raise(m.Topic)
+		}
+	}
+
+	// Clients know topics by their name within the namespace
+	message = pubsub.LocalizeMessage(message)
+
+	// Errors say whether the request is worth sending again
+	if errorResp, ok := message.(pubsub.ErrorResponse); ok {
+		errorResp.Error.Retryable = pubsub.Retryable(errorResp.Error.Code)
+		message = errorResp
+	}
+
+	// Published events are already shared between subscribers. Volatile
+	// ones only go to a connection with nothing else queued.
+	if prepared, ok := message.(*pubsub.PreparedEvent); ok {
+		if prepared.Event.Volatile && len(c.messageChan) > 0 {
+			return pubsub.ErrVolatileDropped
+		}
+		return c.enqueue(outboundFrame{prepared: prepared, topic: topic})
+	}
+
+	// Protocol v2 clients get responses in their own shapes; v1 clients
+	// get every response in the event shape
+	if _, isEvent := message.(pubsub.EventResponse); !isEvent && c.protocolVersion() >= pubsub.ProtocolVersion2 {
+		switch message.(type) {
+		case pubsub.AckResponse, pubsub.ErrorResponse, pubsub.PongResponse, pubsub.HelloAckResponse, pubsub.ReplyResponse, pubsub.HistoryResponse:
+			return c.enqueueControl(outboundFrame{message: message}, true)
+		case pubsub.InfoResponse, pubsub.ReceiptResponse:
+			return c.enqueueControl(outboundFrame{message: message}, false)
+		}
+	}
+
+	// Convert message to EventResponse format for the send channel
+	var eventMsg pubsub.EventResponse
+
+	switch msg := message.(type) {
+	case pubsub.EventResponse:
+		eventMsg = msg
+	case pubsub.AckResponse:
+		// Convert AckResponse to EventResponse format
+		payload := map[string]interface{}{"status": msg.Status}
+		if msg.Connect {
+			payload["connect"] = true
+		}
+		if msg.Resume != nil {
+			payload["buffered"] = msg.Resume.Buffered
+			payload["evicted"] = msg.Resume.Evicted
+		}
+		if msg.Token != "" {
+			payload["token"] = msg.Token
+		}
+		if msg.ResumeToken != "" {
+			payload["client_id"] = msg.ClientID
+			payload["resume_token"] = msg.ResumeToken
+		}
+		if msg.TopicAlias != 0 {
+			payload["topic_alias"] = msg.TopicAlias
+		}
+		if msg.DeliverAt != nil {
+			payload["deliver_at"] = msg.DeliverAt
+		}
+		if msg.ExpiresAt != nil {
+			payload["expires_at"] = msg.ExpiresAt
+		}
+		if msg.MessageIDs != nil {
+			payload["message_ids"] = msg.MessageIDs
+		}
+		eventMsg = pubsub.EventResponse{
+			Type:      msg.Type,
+			Topic:     msg.Topic,
+			Message:   pubsub.MessageData{ID: msg.RequestID, Payload: payload},
+			Timestamp: msg.Timestamp,
+		}
+	case pubsub.ErrorResponse:
+		// Convert ErrorResponse to EventResponse format
+		eventMsg = pubsub.EventResponse{
+			Type:      msg.Type,
+			Topic:     msg.Topic,
+			Message:   pubsub.MessageData{ID: msg.RequestID, Payload: msg.Error},
+			Timestamp: msg.Timestamp,
+		}
+	case pubsub.ReplyResponse:
+		// Convert ReplyResponse to EventResponse format, the request's ID
+		// standing in for the reply's
+		eventMsg = pubsub.EventResponse{
+			Type:          msg.Type,
+			Topic:         msg.Topic,
+			Message:       pubsub.MessageData{ID: msg.RequestID, Payload: msg.Message.Payload},
+			From:          msg.From,
+			CorrelationID: msg.CorrelationID,
+			Timestamp:     msg.Timestamp,
+		}
+	case pubsub.HistoryResponse:
+		// Convert HistoryResponse to EventResponse format
+		eventMsg = pubsub.EventResponse{
+			Type:  msg.Type,
+			Topic: msg.Topic,
+			Message: pubsub.MessageData{ID: msg.RequestID, Payload: map[string]interface{}{
+				"messages":    msg.Messages,
+				"next_cursor": msg.NextCursor,
+			}},
+			Timestamp: msg.Timestamp,
+		}
+	case pubsub.PongResponse:
+		// Convert PongResponse to EventResponse format
+		eventMsg = pubsub.EventResponse{
+			Type:      msg.Type,
+			Topic:     "",
+			Message:   pubsub.MessageData{ID: msg.RequestID, Payload: "pong"},
+			Timestamp: msg.Timestamp,
+		}
+	case pubsub.InfoResponse:
+		// Convert InfoResponse to EventResponse format
+		eventMsg = pubsub.EventResponse{
+			Type:      msg.Type,
+			Topic:     msg.Topic,
+			Message:   pubsub.MessageData{ID: "", Payload: msg.EventPayload()},
+			Timestamp: msg.Timestamp,
+		}
+	case pubsub.ReceiptResponse:
+		// Convert ReceiptResponse to EventResponse format
+		eventMsg = pubsub.EventResponse{
+			Type:      msg.Type,
+			Message:   pubsub.MessageData{Payload: map[string]interface{}{"receipts": msg.Receipts}},
+			Timestamp: msg.Timestamp,
+		}
+	case pubsub.WelcomeResponse:
+		// Convert WelcomeResponse to EventResponse format
+		eventMsg = pubsub.EventResponse{
+			Type: msg.Type,
+			Message: pubsub.MessageData{ID: msg.ClientID, Payload: map[string]interface{}{
+				"client_id":            msg.ClientID,
+				"resume_token":         msg.ResumeToken,
+				"protocol_version":     msg.ProtocolVersion,
+				"max_protocol_version": msg.MaxProtocolVersion,
+				"limits":               msg.Limits,
+			}},
+			Timestamp: msg.Timestamp,
+		}
+	case pubsub.HelloAckResponse:
+		// Convert HelloAckResponse to EventResponse format
+		eventMsg = pubsub.EventResponse{
+			Type: msg.Type,
+			Message: pubsub.MessageData{ID: msg.RequestID, Payload: map[string]interface{}{
+				"protocol_version": msg.ProtocolVersion,
+				"capabilities":     msg.Capabilities,
+				"namespace":        msg.Namespace,
+				"limits":           msg.Limits,
+			}},
+			Timestamp: msg.Timestamp,
+		}
+	default:
+		return pubsub.ErrorData{Code: pubsub.CodeInternal, Message: "Unknown message type to send"}
+	}
+
+	switch message.(type) {
+	case pubsub.EventResponse:
+		return c.enqueue(outboundFrame{message: eventMsg, topic: topic})
+	case pubsub.InfoResponse, pubsub.ReceiptResponse:
+		// Infos are sent under topic locks and receipts from timers, so
+		// they must not wait
+		return c.enqueueControl(outboundFrame{message: eventMsg}, false)
+	default:
+		// Replies to the client's own requests wait for room rather than
+		// being dropped; only the read loop sends these
+		return c.enqueueControl(outboundFrame{message: eventMsg}, true)
+	}
+}
+
+// enqueueControl queues a control response on the priority queue. With
+// wait set it blocks until there is room or the connection is closing.
+func (c *Client) enqueueControl(frame outboundFrame, wait bool) error {
+	select {
+	case c.controlChan <- frame:
+		return nil
+	default:
+	}
+	if wait {
+		select {
+		case c.controlChan <- frame:
+			return nil
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		}
+	}
+	log.Printf("Client %s control queue is full, dropping message", c.id())
+	return pubsub.ErrorData{Code: pubsub.CodeServerBusy, Reason: "CLIENT_OVERLOADED", Message: "Client control queue is full"}
+}
+
+// enqueue hands a frame to writePump without blocking
+func (c *Client) enqueue(frame outboundFrame) error {
+	c.fences.stamp(&frame)
+	select {
+	case c.messageChan <- frame:
+		return nil
+	default:
+		// Channel is full, client is slow
+		log.Printf("Client %s messageChan is full, dropping message", c.id())
+		return pubsub.ErrorData{Code: pubsub.CodeServerBusy, Reason: "CLIENT_OVERLOADED", Message: "Client messageChan buffer is full"}
+	}
+}
+
+// ClientInterface implementation
+func (c *Client) GetClientID() string {
+	return c.id()
+}
+
+func (c *Client) IsConnected() bool {
+	// Check if WebSocket connection is still alive
+	return c.conn != nil
+}
+
+func (c *Client) SendMessage(msg interface{}) error {
+	return c.sendMessage(msg)
+}
+
+// GetLastActive returns when the client last sent a frame or answered a
+// websocket ping
+func (c *Client) GetLastActive() time.Time {
+	return c.live.last()
+}
+
+// Unresponsive implements pubsub.LivenessReporter
+func (c *Client) Unresponsive() bool {
+	return c.live.isUnresponsive()
+}
+
+// cleanup handles client disconnection
+func (c *Client) cleanup() {
+	c.cancel()
+
+	// Only the connection holding its client ID tears down the ID's
+	// subscriptions and will; one refused the ID leaves the holder's
+	// alone. Takeovers wait for this cleanup, so the ID can't change
+	// hands in between.
+	current := c.ps.IsCurrentClient(c)
+	if current {
+		c.ps.DisconnectClient(c.id())
+	}
+	if c.ps.UnregisterClientIfCurrent(c) {
+		c.ps.ReleaseClientUsage(c.id())
+		c.ps.ClientDisconnected(c.id())
+	}
+	c.ps.WithdrawJoinRequests(c)
+	c.stopAcks()
+
+	// Only a client's own normal close withdraws its last will
+	if current {
+		if c.closeCode == websocket.CloseNormalClosure {
+			c.ps.ClearLastWill(c.id())
+		} else {
+			c.ps.ReleaseLastWill(c.id())
+		}
+	}
+	c.ps.Audit(pubsub.AuditRecord{
+		Event:     pubsub.AuditDisconnect,
+		ClientID:  c.id(),
+		Published: c.published,
+		Reason:    c.closeReason,
+		CloseCode: c.closeCode,
+	})
+
+	c.ps.ReleaseResumeToken(c.id(), c.token)
+	c.ps.ReleaseConnection()
+	c.ps.ReleaseIPConnection(c.remoteIP)
+	c.requests.clear()
+	c.uploads.clear()
+
+	// Close messageChan
+	close(c.messageChan)
+	close(c.done)
+
+	log.Printf("Client %s disconnected", c.id())
+}
+
+// HandleWebSocket handles WebSocket connections with the default options
+func HandleWebSocket(ps *pubsub.PubSubSystem) http.HandlerFunc {
+	return DefaultHandler(ps).ServeHTTP
+}
+
+// HandleAdminWebSocket handles WebSocket connections allowed to subscribe
+// to the firehose, with the default options. Serve it only behind admin
+// authentication.
+func HandleAdminWebSocket(ps *pubsub.PubSubSystem) http.HandlerFunc {
+	return DefaultHandler(ps).Admin()
+}
+
+// DefaultHandler creates a handler serving connections with the default
+// options
+func DefaultHandler(ps *pubsub.PubSubSystem) *Handler {
+	h, err := NewHandler(ps, WebSocketOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// ServeHTTP upgrades and serves one connection
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handle(false)(w, r)
+}
+
+// Admin handles connections allowed to subscribe to the firehose. Serve
+// it only behind admin authentication.
+func (h *Handler) Admin() http.HandlerFunc {
+	return h.handle(true)
+}
+
+// handle upgrades and serves one connection
+func (h *Handler) handle(admin bool) http.HandlerFunc {
+	ps := h.ps
+	return func(w http.ResponseWriter, r *http.Request) {
+		// A /ws/{namespace} path binds the connection to that namespace
+		namespace := mux.Vars(r)["namespace"]
+		if namespace != "" && !pubsub.ValidNamespace(namespace) {
+			http.Error(w, "Invalid namespace", http.StatusBadRequest)
+			return
+		}
+
+		// The query may claim a client ID and subscribe at once
+		connect, err := parseConnectRequest(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Reject disallowed browser origins before upgrading
+		if !h.opts.Origins.CheckRequest(r) {
+			ps.WebSocketTraffic().OriginRejections.Add(1)
+			log.Printf("Rejecting WebSocket upgrade from origin %s", r.Header.Get("Origin"))
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+
+		// A draining server keeps its connections but takes no new ones
+		if ps.IsDraining() {
+			log.Printf("Rejecting WebSocket upgrade from %s: server is draining", r.RemoteAddr)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(pubsub.ErrorResponse{
+				Type:      "error",
+				Error:     pubsub.ErrorData{Code: pubsub.CodeServerBusy, Reason: "SERVER_DRAINING", Message: "server is draining, connect to another instance", Retryable: true},
+				Timestamp: ps.Clock().Now(),
+			})
+			return
+		}
+
+		// Refuse before upgrading once the remote IP or the server is at
+		// its connection cap; the slots are released in cleanup
+		remoteIP := h.clientIP(r)
+		if !ps.AcquireIPConnection(remoteIP) {
+			ps.WebSocketTraffic().IPLimitRejections.Add(1)
+			log.Printf("Rejecting WebSocket upgrade from %s: per-IP connection limit reached", remoteIP)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(int(connectionLimitRetryAfter/time.Second)))
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(pubsub.ErrorResponse{
+				Type:      "error",
+				Error:     pubsub.ErrorData{Code: pubsub.CodeRateLimited, Reason: "IP_CONNECTION_LIMIT", Message: fmt.Sprintf("%s has too many open connections", remoteIP), Retryable: true},
+				Timestamp: ps.Clock().Now(),
+			})
+			return
+		}
+		if !ps.AcquireConnection() {
+			ps.ReleaseIPConnection(remoteIP)
+			ps.WebSocketTraffic().LimitRejections.Add(1)
+			log.Printf("Rejecting WebSocket upgrade from %s: connection limit reached", r.RemoteAddr)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(int(connectionLimitRetryAfter/time.Second)))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(pubsub.ErrorResponse{
+				Type:      "error",
+				Error:     pubsub.ErrorData{Code: pubsub.CodeServerBusy, Reason: "CONNECTION_LIMIT", Message: "server is at its connection limit, retry later", Retryable: true},
+				Timestamp: ps.Clock().Now(),
+			})
+			return
+		}
+
+		// Count the bytes that actually hit the wire so /stats can show
+		// the effect of compression
+		counted := &countingResponseWriter{ResponseWriter: w, written: &ps.WebSocketTraffic().WireBytes}
+		conn, err := h.upgrader.Upgrade(counted, r, nil)
+		if err != nil {
+			ps.ReleaseConnection()
+			ps.ReleaseIPConnection(remoteIP)
+			log.Printf("WebSocket upgrade error: %v", err)
+			return
+		}
+		if h.opts.EnableCompression {
+			conn.SetCompressionLevel(h.opts.CompressionLevel)
+		}
+
+		client := NewClient(conn, ps, h.opts)
+		client.admin = admin
+		client.remoteIP = remoteIP
+		if namespace != "" {
+			client.namespace = namespace
+			client.namespaceFixed = true
+		}
+		var certErr error
+		if cn := verifiedClientCN(r); h.opts.ClientIDFromCert && cn != "" {
+			// The certificate decides the identity; it can't be claimed away
+			client.clientID = cn
+			client.identified = true
+			certErr = client.registerCertIdentity()
+		} else {
+			ps.RegisterClient(client)
+		}
+		client.usage.Store(ps.ClientUsage(client.clientID))
+		client.presentedToken = r.Header.Get(resumeTokenHeader)
+		client.compression = h.opts.EnableCompression && offersDeflate(r)
+		ps.Audit(pubsub.AuditRecord{
+			Event:      pubsub.AuditConnect,
+			ClientID:   client.clientID,
+			RemoteAddr: r.RemoteAddr,
+			UserAgent:  r.UserAgent(),
+		})
+		log.Printf("New WebSocket client connected with ID: %s (codec %s)", client.clientID, client.codec.Name())
+		if certErr != nil {
+			// Refused its certificate's ID: told why and closed, without
+			// a welcome
+			log.Printf("Refusing client %s: %v", client.clientID, certErr)
+			go client.writePump()
+			client.connectError("", certErr)
+			client.closeWith(ClosePolicyViolation, "client_id "+client.clientID+" is bound to another connection")
+			go client.readPump()
+			return
+		}
+		claimErr := client.claimAtConnect(connect)
+
+		// Queued before the pumps start so it is always the first frame
+		if err := client.welcome(); err != nil {
+			log.Printf("Error sending welcome to client %s: %v", client.id(), err)
+		}
+		client.deliverHeldDirect()
+
+		// Start read and write pumps in separate goroutines. Subscriptions
+		// in the URL are answered before any request the client sends, and
+		// not made at all under an ID the client didn't ask for.
+		go client.writePump()
+		if claimErr != nil {
+			client.connectError("", claimErr)
+		} else {
+			client.subscribeAtConnect(connect)
+		}
+		go client.readPump()
+	}
+}
+
+// outboundFrame is a queued outgoing message: either a published event
+// shared with other subscribers or a response for this client alone
+type outboundFrame struct {
+	prepared *pubsub.PreparedEvent
+	message  interface{}
+
+	// Internal name of an event's topic, for traffic accounting
+	topic string
+
+	// Queue position of an event, checked against its topic's fence
+	seq uint64
+
+	// Set on the frame that closes the connection with a status code
+	closeCode int
+	closeText string
+}
+
+// encode returns the frame's bytes, reusing a shared event's encoding
+func (f outboundFrame) encode(codec pubsub.Codec) ([]byte, error) {
+	if f.prepared != nil {
+		return f.prepared.Encode(codec)
+	}
+	return codec.Marshal(f.message)
+}
+
+// encode returns a frame's bytes for this client. With scratch set, a JSON
+// message for this client alone is encoded into the write pump's reused
+// buffer, and the bytes are only valid until the next such call.
+func (c *Client) encode(frame outboundFrame, scratch bool) ([]byte, error) {
+	if alias := c.aliases.lookup(frame.topic); alias != 0 {
+		return c.codec.Marshal(c.aliased(frame, alias))
+	}
+	if !scratch || frame.prepared != nil || c.codec != pubsub.JSONCodec {
+		return frame.encode(c.codec)
+	}
+	if c.scratch.encoder == nil || c.scratch.buf.Cap() > maxScratchBytes {
+		// Start over after an unusually large message rather than hold
+		// its buffer for the life of the connection
+		c.scratch.buf = bytes.Buffer{}
+		c.scratch.encoder = json.NewEncoder(&c.scratch.buf)
+	}
+	c.scratch.buf.Reset()
+	if err := c.scratch.encoder.Encode(frame.message); err != nil {
+		return nil, err
+	}
+	// Encode ends every value with a newline that Marshal doesn't add
+	return bytes.TrimSuffix(c.scratch.buf.Bytes(), []byte{'\n'}), nil
+}
+
+// codecForFrame picks the codec for an incoming frame: binary frames are
+// always MessagePack and text frames are always JSON
+func codecForFrame(ft int) pubsub.Codec {
+	if ft == websocket.BinaryMessage {
+		return pubsub.MsgpackCodec
+	}
+	return pubsub.JSONCodec
+}
+
+// frameType is the websocket frame type used for a codec's outgoing messages
+func frameType(codec pubsub.Codec) int {
+	if codec.Binary() {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+// offersDeflate reports whether the upgrade request offered permessage-deflate
+func offersDeflate(r *http.Request) bool {
+	for _, ext := range r.Header.Values("Sec-WebSocket-Extensions") {
+		if strings.Contains(ext, "permessage-deflate") {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address r counts against the per-IP connection cap
+// under
+func (h *Handler) clientIP(r *http.Request) string {
+	if h.opts.ClientIP != nil {
+		return h.opts.ClientIP(r)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// verifiedClientCN returns the common name of the request's verified client
+// certificate, or "" when the connection has none
+func verifiedClientCN(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// countingResponseWriter hands the websocket upgrader a connection that
+// counts every byte written to it
+type countingResponseWriter struct {
+	http.ResponseWriter
+	written *atomic.Int64
+}
+
+func (w *countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &countingConn{Conn: conn, written: w.written}, brw, nil
+}
+
+// countingConn is a net.Conn that adds written bytes to a shared counter
+type countingConn struct {
+	net.Conn
+	written *atomic.Int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,295 @@
+package ws
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// serve serves websocket connections to ps with opts
+func serve(t *testing.T, ps *pubsub.PubSubSystem, opts WebSocketOptions) *httptest.Server {
+	t.Helper()
+	h, err := NewHandler(ps, opts)
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// dial connects to server with an optional query and reads the welcome
+// frame, which comes wrapped in an event envelope like every other frame
+func dial(t *testing.T, server *httptest.Server, query string, header http.Header) (*websocket.Conn, pubsub.WelcomeResponse) {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+query, header)
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		t.Fatalf("dial: %v (status %d)", err, status)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	var frame struct {
+		Type    string `json:"type"`
+		Message struct {
+			Payload pubsub.WelcomeResponse `json:"payload"`
+		} `json:"message"`
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&frame); err != nil || frame.Type != "welcome" {
+		t.Fatalf("welcome = %+v, %v", frame, err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	return conn, frame.Message.Payload
+}
+
+// waitFor polls cond until it holds or the test times out
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// probeClient stands in for a connection when checking who holds an ID
+type probeClient struct{ id string }
+
+func (c probeClient) GetClientID() string           { return c.id }
+func (c probeClient) IsConnected() bool             { return true }
+func (c probeClient) SendMessage(interface{}) error { return nil }
+func (c probeClient) GetLastActive() time.Time      { return time.Now() }
+
+// connected reports whether a connection is registered as clientID
+func connected(ps *pubsub.PubSubSystem, clientID string) bool {
+	probe := probeClient{id: clientID}
+	if _, ok := ps.RegisterClientIfAbsent(probe); ok {
+		ps.UnregisterClientIfCurrent(probe)
+		return false
+	}
+	return true
+}
+
+func TestNewHandlerValidatesOptions(t *testing.T) {
+	ps := pubsub.New()
+	for name, opts := range map[string]WebSocketOptions{
+		"ping period not shorter than pong wait": {PongWait: time.Second, PingPeriod: time.Second},
+		"negative write wait":                    {WriteWait: -time.Second},
+		"negative send buffer":                   {SendBufferSize: -1},
+		"negative control buffer":                {ControlBufferSize: -1},
+		"negative request cache size":            {RequestCacheSize: -1},
+		"negative request cache ttl":             {RequestCacheTTL: -time.Second},
+		"negative probe period":                  {ProbePeriod: -time.Second},
+		"negative probe timeout":                 {ProbeTimeout: -time.Second},
+		"negative error budget":                  {ErrorBudget: -1},
+		"negative error window":                  {ErrorWindow: -time.Second},
+		"negative max chunked bytes":             {MaxChunkedBytes: -1},
+		"negative chunk timeout":                 {ChunkTimeout: -time.Second},
+	} {
+		if _, err := NewHandler(ps, opts); err == nil {
+			t.Errorf("%s: NewHandler accepted %+v", name, opts)
+		}
+	}
+
+	// Zero values keep the defaults, with a ping period 90% of the pong wait
+	h, err := NewHandler(ps, WebSocketOptions{PongWait: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+	if h.opts.PingPeriod != 9*time.Second || h.opts.SendBufferSize != pubsub.ClientSendBufferSize {
+		t.Errorf("defaults = ping period %s, send buffer %d", h.opts.PingPeriod, h.opts.SendBufferSize)
+	}
+}
+
+func TestWelcomeReportsKeepalive(t *testing.T) {
+	for _, opts := range []WebSocketOptions{
+		// Mobile clients on flaky networks
+		{PongWait: 3 * time.Minute, PingPeriod: 2 * time.Minute, WriteWait: time.Minute, SendBufferSize: 4096, ControlBufferSize: 512},
+		// Fast dead-peer detection on a LAN
+		{PongWait: 15 * time.Second, PingPeriod: 5 * time.Second, WriteWait: time.Second, SendBufferSize: 1, ControlBufferSize: 2, MaxMessageSize: 4096},
+	} {
+		_, welcome := dial(t, serve(t, pubsub.New(), opts), "", nil)
+		limits := welcome.Limits
+		if limits.PongWaitMs != int(opts.PongWait/time.Millisecond) ||
+			limits.PingPeriodMs != int(opts.PingPeriod/time.Millisecond) ||
+			limits.WriteWaitMs != int(opts.WriteWait/time.Millisecond) ||
+			limits.SendBufferSize != opts.SendBufferSize ||
+			limits.ControlBufferSize != opts.ControlBufferSize {
+			t.Errorf("options %+v announced as %+v", opts, limits)
+		}
+		if opts.MaxMessageSize > 0 && limits.MaxMessageSize != opts.MaxMessageSize {
+			t.Errorf("max message size announced as %d, want %d", limits.MaxMessageSize, opts.MaxMessageSize)
+		}
+	}
+}
+
+func TestKeepalivePingsAndDropsSilentPeers(t *testing.T) {
+	clock := newFakeClock()
+	ps := pubsub.New()
+	server := serve(t, ps, WebSocketOptions{Clock: clock, PongWait: time.Minute, PingPeriod: 10 * time.Second})
+
+	// A client that answers pings stays connected well past the pong wait
+	live, liveWelcome := dial(t, server, "", nil)
+	pings := make(chan struct{}, 100)
+	live.SetPingHandler(func(data string) error {
+		pings <- struct{}{}
+		return live.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	go func() {
+		for {
+			if _, _, err := live.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// One that never reads never answers, and is dropped once the pong
+	// wait has passed on the server's clock
+	_, silentWelcome := dial(t, server, "", nil)
+	waitTimers(t, clock, 4)
+	for i := 0; i < 5; i++ {
+		now := clock.Advance(10 * time.Second)
+		select {
+		case <-pings:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no ping %d", i+1)
+		}
+		waitFor(t, "the pong to refresh last active", func() bool {
+			return lastActive(t, ps, liveWelcome.ClientID).Equal(now)
+		})
+	}
+	if !connected(ps, silentWelcome.ClientID) {
+		t.Fatal("silent client dropped before the pong wait")
+	}
+
+	clock.Advance(10 * time.Second)
+	waitFor(t, "the silent client to be dropped", func() bool {
+		return !connected(ps, silentWelcome.ClientID)
+	})
+	if !connected(ps, liveWelcome.ClientID) {
+		t.Fatal("client answering pings was dropped")
+	}
+}
+
+func TestScheduledPublish(t *testing.T) {
+	ps, server := roomsServer(t)
+	watcher := dialV2(t, server)
+	watcher.send(map[string]interface{}{"type": "subscribe", "topic": "room1", "request_id": "s-1"})
+	watcher.expect("ack")
+
+	pub := dialV2(t, server)
+	publish := func(requestID string, fields map[string]interface{}) map[string]interface{} {
+		req := map[string]interface{}{
+			"type":       "publish",
+			"topic":      "room1",
+			"request_id": requestID,
+			"message":    map[string]interface{}{"id": uuid.New().String(), "payload": requestID},
+		}
+		for k, v := range fields {
+			req[k] = v
+		}
+		return pub.request(req)
+	}
+
+	if failure := publish("p-0", map[string]interface{}{"delay_ms": 10, "deliver_at": time.Now().Format(time.RFC3339)}); errorCode(failure) != "VALIDATION_FAILED" {
+		t.Errorf("both deliver_at and delay_ms answered with %v", failure)
+	}
+
+	// A cancelled message is never delivered
+	ack := publish("p-1", map[string]interface{}{"delay_ms": 200})
+	if ack["status"] != "scheduled" || ack["token"] == nil || ack["deliver_at"] == nil {
+		t.Fatalf("scheduled publish answered with %v", ack)
+	}
+	if cancelled := pub.request(map[string]interface{}{"type": "cancel_scheduled", "token": ack["token"], "request_id": "c-1"}); cancelled["status"] != "cancelled" || cancelled["topic"] != "room1" {
+		t.Errorf("cancel answered with %v", cancelled)
+	}
+	if failure := pub.request(map[string]interface{}{"type": "cancel_scheduled", "token": ack["token"], "request_id": "c-2"}); errorReason(failure) != "SCHEDULE_NOT_FOUND" {
+		t.Errorf("second cancel answered with %v", failure)
+	}
+
+	// One left pending is published when due
+	publish("p-2", map[string]interface{}{"delay_ms": 50})
+	if event := watcher.expect("event"); event["message"].(map[string]interface{})["payload"] != "p-2" || event["seq"] != float64(4) {
+		t.Errorf("scheduled event = %v", event)
+	}
+	if pending := ps.GetStats().Topics["room1"].Scheduled; pending != 0 {
+		t.Errorf("scheduled = %d after delivery", pending)
+	}
+}
+
+func TestPublishWithoutStoring(t *testing.T) {
+	_, server := roomsServer(t)
+	watcher := dialV2(t, server)
+	watcher.send(map[string]interface{}{"type": "subscribe", "topic": "room1", "request_id": "s-1"})
+	watcher.expect("ack")
+
+	pub := dialV2(t, server)
+	pub.send(map[string]interface{}{
+		"type":       "publish",
+		"topic":      "room1",
+		"request_id": "p-1",
+		"store":      false,
+		"message":    map[string]interface{}{"id": uuid.New().String(), "payload": "typing"},
+	})
+	pub.expect("ack")
+	if event := watcher.expect("event"); event["ephemeral"] != true || event["seq"] != nil {
+		t.Errorf("ephemeral event = %v", event)
+	}
+
+	// A later last_n replays only the three stored messages
+	late := dialV2(t, server)
+	late.send(map[string]interface{}{"type": "subscribe", "topic": "room1", "last_n": 5, "request_id": "s-2"})
+	late.expect("ack")
+	for i := 0; i < 3; i++ {
+		if event := late.expect("event"); messageID(event) != fmt.Sprintf("room1-%d", i) {
+			t.Fatalf("history event %d = %v", i, event)
+		}
+	}
+}
+
+func TestUsageOfDisconnectedClientsExpires(t *testing.T) {
+	clock := newFakeClock()
+	ps := pubsub.NewWithClock(clock)
+	ps.SetSessionRetention(time.Minute)
+	server := serve(t, ps, WebSocketOptions{})
+
+	// Every connection starts on its own assigned ID with its own counters
+	for i := 0; i < 3; i++ {
+		c, welcome := dialWelcome(t, server, "", nil)
+		if _, ok := ps.GetClientUsage(welcome.ClientID); !ok {
+			t.Fatalf("no usage counted for %s", welcome.ClientID)
+		}
+		c.conn.Close()
+		waitFor(t, "the connection to go", func() bool { return !connected(ps, welcome.ClientID) })
+		waitFor(t, "its cleanup", func() bool { return ps.GetStats().WebSocket.Connections == 0 })
+	}
+	live, welcome := dialWelcome(t, server, "", nil)
+	defer live.conn.Close()
+
+	// Departed clients' counters outlast the retention only until swept
+	clock.Advance(30 * time.Second)
+	if dropped := ps.SweepClientUsage(); dropped != 0 {
+		t.Errorf("%d counters dropped within the retention", dropped)
+	}
+	clock.Advance(30 * time.Second)
+	if dropped := ps.SweepClientUsage(); dropped != 3 {
+		t.Errorf("%d counters dropped after the retention, want 3", dropped)
+	}
+	if _, ok := ps.GetClientUsage(welcome.ClientID); !ok {
+		t.Error("a connected client's counters were dropped")
+	}
+}
@@ -0,0 +1,101 @@
+package ws
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/AnshulDekate/pubsub/pkg/pubsub"
+)
+
+// subscribeWithWill subscribes c to room1 as clientID, registering a will
+// that publishes "offline" to room2 after delayMs
+func (c *wireClient) subscribeWithWill(clientID string, delayMs int) {
+	c.t.Helper()
+	ack := c.request(map[string]interface{}{
+		"type":       "subscribe",
+		"topic":      "room1",
+		"client_id":  clientID,
+		"request_id": "s-" + clientID,
+		"last_will": map[string]interface{}{
+			"topic":    "room2",
+			"message":  map[string]interface{}{"id": "will-" + clientID, "payload": clientID + " offline"},
+			"delay_ms": delayMs,
+		},
+	})
+	if ack["type"] != "ack" {
+		c.t.Fatalf("subscribe with a last will answered with %v", ack)
+	}
+}
+
+// expectNoPresence publishes a marker to room2 and checks that it is the
+// next event watcher sees
+func expectNoPresence(t *testing.T, ps *pubsub.PubSubSystem, watcher *wireClient) {
+	t.Helper()
+	if err := ps.Publish(context.Background(), "room2", pubsub.MessageData{ID: "marker-" + t.Name(), Payload: "marker"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if event := watcher.expect("event"); event["message"].(map[string]interface{})["payload"] != "marker" {
+		t.Errorf("room2 event = %v, want the marker", event)
+	}
+}
+
+func TestLastWill(t *testing.T) {
+	ps, server := roomsServer(t)
+	watcher := dialV2(t, server)
+	watcher.send(map[string]interface{}{"type": "subscribe", "topic": "room2", "request_id": "s-1"})
+	watcher.expect("ack")
+
+	// A will registered at hello follows the client_id claimed later, and
+	// an abrupt disconnect publishes it
+	abrupt := dialCodec(t, server, pubsub.JSONCodec)
+	abrupt.send(map[string]interface{}{
+		"type":             "hello",
+		"protocol_version": 2,
+		"request_id":       "h-1",
+		"last_will":        map[string]interface{}{"topic": "room2", "message": map[string]interface{}{"id": "will-dev-1", "payload": "dev-1 offline"}},
+	})
+	abrupt.expect("hello_ack")
+	abrupt.request(map[string]interface{}{"type": "subscribe", "topic": "room1", "client_id": "dev-1", "request_id": "s-dev-1"})
+	if will, ok := ps.LastWill("dev-1"); !ok || will.Topic != "room2" || will.FiresAt != nil {
+		t.Fatalf("dev-1 will = %+v, %v", will, ok)
+	}
+	abrupt.conn.Close()
+	if event := watcher.expect("event"); event["message"].(map[string]interface{})["payload"] != "dev-1 offline" {
+		t.Fatalf("room2 event = %v, want the will", event)
+	}
+
+	// Reconnecting within the delay cancels it
+	flaky := dialV2(t, server)
+	flaky.subscribeWithWill("dev-2", 60000)
+	flaky.conn.Close()
+	waitFor(t, "the pending will", func() bool {
+		will, ok := ps.LastWill("dev-2")
+		return ok && will.FiresAt != nil
+	})
+	back, _ := dialWelcome(t, server, "?client_id=dev-2", nil)
+	if _, ok := ps.LastWill("dev-2"); ok {
+		t.Error("reconnecting left the will pending")
+	}
+
+	// A clean close withdraws it
+	leaving := dialV2(t, server)
+	leaving.subscribeWithWill("dev-3", 0)
+	leaving.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	waitFor(t, "the will to be withdrawn", func() bool {
+		_, ok := ps.LastWill("dev-3")
+		return !ok
+	})
+	back.conn.Close()
+	expectNoPresence(t, ps, watcher)
+
+	if failure := dialV2(t, server).request(map[string]interface{}{
+		"type":       "subscribe",
+		"topic":      "room1",
+		"request_id": "s-2",
+		"last_will":  map[string]interface{}{"topic": "missing", "message": map[string]interface{}{"id": "w"}},
+	}); errorReason(failure) != "INVALID_LAST_WILL" {
+		t.Errorf("will for a missing topic answered with %v", failure)
+	}
+}
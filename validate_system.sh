@@ -23,7 +23,7 @@ echo -e "${GREEN}✅ Go is available${NC}"
 
 # Check if project compiles
 echo "📦 Checking compilation..."
-if go build -o /tmp/chatroom-test . > /dev/null 2>&1; then
+if go build -o /tmp/chatroom-test ./cmd/server > /dev/null 2>&1; then
     echo -e "${GREEN}✅ Project compiles successfully${NC}"
     rm -f /tmp/chatroom-test
 else
@@ -56,7 +56,7 @@ fi
 
 # Start server for quick integration test
 echo "🚀 Testing server startup..."
-PORT=9099 go run . &
+PORT=9099 go run ./cmd/server &
 SERVER_PID=$!
 sleep 2
 
@@ -86,7 +86,7 @@ echo "🚀 To run comprehensive tests:"
 echo "   ./run_tests.sh all"
 echo ""
 echo "📖 To start manual testing:"
-echo "   go run .                # Start server"
+echo "   go run ./cmd/server     # Start server"
 echo "   ./manual_test.sh       # In another terminal"
 echo ""
 echo "🐳 To test with Docker:"
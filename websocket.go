@@ -1,412 +0,0 @@
-package main
-
-import (
-	"log"
-	"net/http"
-	"time"
-
-	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
-)
-
-const (
-	// Time allowed to write a message to the peer
-	writeWait = 10 * time.Second
-
-	// Time allowed to read the next pong message from the peer
-	pongWait = 60 * time.Second
-
-	// Send pings to peer with this period. Must be less than pongWait
-	pingPeriod = (pongWait * 9) / 10
-
-	// Maximum message size allowed from peer
-	maxMessageSize = 512
-)
-
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		// Allow all origins for this example
-		return true
-	},
-}
-
-// Client represents a WebSocket client connection
-type Client struct {
-	// The websocket connection
-	conn *websocket.Conn
-
-	// Client ID for identification
-	clientID string
-
-	// Reference to pub-sub system
-	pubsub *PubSubSystem
-
-	// Buffered channel for sending messages (handles backpressure)
-	messageChan chan EventResponse
-}
-
-// NewClient creates a new client instance
-func NewClient(conn *websocket.Conn, pubsub *PubSubSystem) *Client {
-	clientID := uuid.New().String()
-	return &Client{
-		conn:        conn,
-		clientID:    clientID, // Generate client ID immediately on connection
-		pubsub:      pubsub,
-		messageChan: make(chan EventResponse, 256), // Buffered channel for backpressure
-	}
-}
-
-// readPump pumps messages from the websocket connection
-func (c *Client) readPump() {
-	defer func() {
-		c.cleanup()
-		c.conn.Close()
-	}()
-
-	c.conn.SetReadLimit(maxMessageSize)
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(pongWait))
-		return nil
-	})
-
-	for {
-		_, message, err := c.conn.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
-			}
-			break
-		}
-
-		// Parse and handle the message directly
-		if err := c.handleMessage(message); err != nil {
-			log.Printf("Error handling message from client %s: %v", c.clientID, err)
-			// Send error response
-			errorResp := ErrorResponse{
-				Type:      "error",
-				Error:     ErrorData{Code: "PROCESSING_ERROR", Message: err.Error()},
-				Timestamp: time.Now(),
-			}
-			c.sendMessage(errorResp)
-		}
-	}
-}
-
-// writePump pumps messages from the hub to the websocket connection
-func (c *Client) writePump() {
-	ticker := time.NewTicker(pingPeriod)
-	defer func() {
-		ticker.Stop()
-		c.conn.Close()
-	}()
-
-	log.Printf("writePump started for client %s", c.clientID)
-
-	for {
-		select {
-		case message, ok := <-c.messageChan:
-			log.Printf("Received message for client %s: %+v", c.clientID, message)
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				log.Printf("messageChan closed for client %s", c.clientID)
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-
-			if err := c.conn.WriteJSON(message); err != nil {
-				log.Printf("Error writing message to client %s: %v", c.clientID, err)
-				return
-			}
-
-		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				log.Printf("Error sending ping to client %s: %v", c.clientID, err)
-				return
-			}
-		}
-	}
-}
-
-// handleMessage processes incoming messages from clients
-func (c *Client) handleMessage(data []byte) error {
-	message, err := ParseMessage(data)
-	if err != nil {
-		return err
-	}
-
-	switch msg := message.(type) {
-	case SubscribeRequest:
-		return c.handleSubscribe(msg)
-	case UnsubscribeRequest:
-		return c.handleUnsubscribe(msg)
-	case PublishRequest:
-		return c.handlePublish(msg)
-	case PingRequest:
-		return c.handlePing(msg)
-	default:
-		return ErrorData{
-			Code:    "UNKNOWN_MESSAGE_TYPE",
-			Message: "Unknown message type received",
-		}
-	}
-}
-
-// handleSubscribe processes subscribe requests
-func (c *Client) handleSubscribe(req SubscribeRequest) error {
-	// Validate request ID
-	if req.RequestID == "" {
-		return ErrorData{Code: "BAD_REQUEST", Message: "request_id is required"}
-	}
-
-	// Client ID is already set when connection was established
-	log.Printf("Subscribing client %s to topic %s", c.clientID, req.Topic)
-
-	lastMessages, err := c.pubsub.Subscribe(c.clientID, req.Topic, req.LastN, c)
-	if err != nil {
-		// Send error response
-		errorResp := ErrorResponse{
-			Type:      "error",
-			RequestID: req.RequestID,
-			Error:     ErrorData{Code: "SUBSCRIBE_FAILED", Message: err.Error()},
-			Timestamp: time.Now(),
-		}
-		return c.sendMessage(errorResp)
-	}
-
-	// Send acknowledgment
-	ackResp := AckResponse{
-		Type:      "ack",
-		RequestID: req.RequestID,
-		Topic:     req.Topic,
-		Status:    "ok",
-		Timestamp: time.Now(),
-	}
-
-	if err := c.sendMessage(ackResp); err != nil {
-		return err
-	}
-
-	// Send last N messages if any
-	for _, lastMsg := range lastMessages {
-		if err := c.sendMessage(lastMsg); err != nil {
-			log.Printf("Error sending last message to client %s: %v", c.clientID, err)
-		}
-	}
-
-	return nil
-}
-
-// handleUnsubscribe processes unsubscribe requests
-func (c *Client) handleUnsubscribe(req UnsubscribeRequest) error {
-	if req.RequestID == "" {
-		return ErrorData{Code: "BAD_REQUEST", Message: "request_id is required"}
-	}
-	if req.ClientID == "" {
-		return ErrorData{Code: "BAD_REQUEST", Message: "client_id is required"}
-	}
-
-	// Validate client ID matches the connection
-	if c.clientID == "" {
-		c.clientID = req.ClientID
-	} else if c.clientID != req.ClientID {
-		return ErrorData{Code: "BAD_REQUEST", Message: "client_id mismatch with existing connection"}
-	}
-
-	err := c.pubsub.Unsubscribe(c.clientID, req.Topic)
-	if err != nil {
-		errorResp := ErrorResponse{
-			Type:      "error",
-			RequestID: req.RequestID,
-			Error:     ErrorData{Code: "UNSUBSCRIBE_FAILED", Message: err.Error()},
-			Timestamp: time.Now(),
-		}
-		return c.sendMessage(errorResp)
-	}
-
-	// Send acknowledgment
-	ackResp := AckResponse{
-		Type:      "ack",
-		RequestID: req.RequestID,
-		Topic:     req.Topic,
-		Status:    "ok",
-		Timestamp: time.Now(),
-	}
-
-	return c.sendMessage(ackResp)
-}
-
-// handlePublish processes publish requests
-func (c *Client) handlePublish(req PublishRequest) error {
-	if req.RequestID == "" {
-		return ErrorData{Code: "BAD_REQUEST", Message: "request_id is required"}
-	}
-
-	// Client ID is already set when connection was established
-	log.Printf("Publishing message from client %s to topic %s", c.clientID, req.Topic)
-
-	// Validate message ID is a valid UUID
-	if req.Message.ID == "" {
-		errorResp := ErrorResponse{
-			Type:      "error",
-			RequestID: req.RequestID,
-			Error:     ErrorData{Code: "BAD_REQUEST", Message: "message.id must be a valid UUID"},
-			Timestamp: time.Now(),
-		}
-		return c.sendMessage(errorResp)
-	}
-
-	// Validate UUID format
-	if _, err := uuid.Parse(req.Message.ID); err != nil {
-		errorResp := ErrorResponse{
-			Type:      "error",
-			RequestID: req.RequestID,
-			Error:     ErrorData{Code: "BAD_REQUEST", Message: "message.id must be a valid UUID"},
-			Timestamp: time.Now(),
-		}
-		return c.sendMessage(errorResp)
-	}
-
-	// Use the stored client_id from the connection
-	err := c.pubsub.Publish(req.Topic, req.Message, c.clientID)
-	if err != nil {
-		errorResp := ErrorResponse{
-			Type:      "error",
-			RequestID: req.RequestID,
-			Error:     ErrorData{Code: "PUBLISH_FAILED", Message: err.Error()},
-			Timestamp: time.Now(),
-		}
-		return c.sendMessage(errorResp)
-	}
-
-	// Send acknowledgment
-	ackResp := AckResponse{
-		Type:      "ack",
-		RequestID: req.RequestID,
-		Topic:     req.Topic,
-		Status:    "ok",
-		Timestamp: time.Now(),
-	}
-
-	return c.sendMessage(ackResp)
-}
-
-// handlePing processes ping requests
-func (c *Client) handlePing(req PingRequest) error {
-	if req.RequestID == "" {
-		return ErrorData{Code: "BAD_REQUEST", Message: "request_id is required"}
-	}
-
-	pongResp := PongResponse{
-		Type:      "pong",
-		RequestID: req.RequestID,
-		Timestamp: time.Now(),
-	}
-
-	return c.sendMessage(pongResp)
-}
-
-// sendMessage sends a message to the client
-func (c *Client) sendMessage(message interface{}) error {
-	// Convert message to EventResponse format for the send channel
-	var eventMsg EventResponse
-
-	switch msg := message.(type) {
-	case EventResponse:
-		eventMsg = msg
-	case AckResponse:
-		// Convert AckResponse to EventResponse format
-		eventMsg = EventResponse{
-			Type:      msg.Type,
-			Topic:     msg.Topic,
-			Message:   MessageData{ID: msg.RequestID, Payload: map[string]interface{}{"status": msg.Status}},
-			Timestamp: msg.Timestamp,
-		}
-	case ErrorResponse:
-		// Convert ErrorResponse to EventResponse format
-		eventMsg = EventResponse{
-			Type:      msg.Type,
-			Topic:     "",
-			Message:   MessageData{ID: msg.RequestID, Payload: msg.Error},
-			Timestamp: msg.Timestamp,
-		}
-	case PongResponse:
-		// Convert PongResponse to EventResponse format
-		eventMsg = EventResponse{
-			Type:      msg.Type,
-			Topic:     "",
-			Message:   MessageData{ID: msg.RequestID, Payload: "pong"},
-			Timestamp: msg.Timestamp,
-		}
-	case InfoResponse:
-		// Convert InfoResponse to EventResponse format
-		eventMsg = EventResponse{
-			Type:      msg.Type,
-			Topic:     msg.Topic,
-			Message:   MessageData{ID: "", Payload: msg.Message},
-			Timestamp: msg.Timestamp,
-		}
-	default:
-		return ErrorData{Code: "INTERNAL_ERROR", Message: "Unknown message type to send"}
-	}
-
-	select {
-	case c.messageChan <- eventMsg:
-		log.Printf("Message sent to client %s: %+v", c.clientID, eventMsg)
-		return nil
-	default:
-		// Channel is full, client is slow
-		log.Printf("Client %s messageChan is full, dropping message", c.clientID)
-		return ErrorData{Code: "CLIENT_OVERLOADED", Message: "Client messageChan buffer is full"}
-	}
-}
-
-// ClientInterface implementation
-func (c *Client) GetClientID() string {
-	return c.clientID
-}
-
-func (c *Client) IsConnected() bool {
-	// Check if WebSocket connection is still alive
-	return c.conn != nil
-}
-
-func (c *Client) SendMessage(msg interface{}) error {
-	return c.sendMessage(msg)
-}
-
-func (c *Client) GetLastActive() time.Time {
-	return time.Now() // WebSocket connection is active if it exists
-}
-
-// cleanup handles client disconnection
-func (c *Client) cleanup() {
-	// Disconnect client from pub-sub system
-	c.pubsub.DisconnectClient(c.clientID)
-
-	// Close messageChan
-	close(c.messageChan)
-
-	log.Printf("Client %s disconnected", c.clientID)
-}
-
-// HandleWebSocket handles WebSocket connections
-func HandleWebSocket(pubsub *PubSubSystem) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		conn, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			log.Printf("WebSocket upgrade error: %v", err)
-			return
-		}
-
-		client := NewClient(conn, pubsub)
-		log.Printf("New WebSocket client connected with ID: %s", client.clientID)
-
-		// Start read and write pumps in separate goroutines
-		go client.writePump()
-		go client.readPump()
-	}
-}